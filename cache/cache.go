@@ -22,6 +22,7 @@ type DataStore interface {
 	RemoveWithDelay(u interface{}, duration time.Duration) (err error)
 	LockedModify(u interface{}, add func(a, b interface{}) interface{}, increment interface{}) (interface{}, error)
 	SetTimeOut(u interface{}, timeout time.Duration) (err error)
+	KeyList() []interface{}
 	ToString() string
 }
 
@@ -354,6 +355,20 @@ func (c *Cache) SizeOf() int {
 	return len(c.data)
 }
 
+// KeyList returns all the keys that are currently stored in the cache
+func (c *Cache) KeyList() []interface{} {
+
+	c.Lock()
+	defer c.Unlock()
+
+	list := []interface{}{}
+	for k := range c.data {
+		list = append(list, k)
+	}
+
+	return list
+}
+
 // LockedModify  locks the data store
 func (c *Cache) LockedModify(u interface{}, add func(a, b interface{}) interface{}, increment interface{}) (interface{}, error) {
 
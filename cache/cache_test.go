@@ -365,3 +365,25 @@ func TestRemoveWithDelay(t *testing.T) {
 
 	})
 }
+
+func TestKeyList(t *testing.T) {
+
+	t.Parallel()
+
+	Convey("Given I create a new cache with some entries", t, func() {
+		c := NewCache("keylist")
+		c.AddOrUpdate("info1", 1)
+		c.AddOrUpdate("info2", 2)
+
+		Convey("When I get the key list, I should get all the keys", func() {
+			So(c.KeyList(), ShouldContain, "info1")
+			So(c.KeyList(), ShouldContain, "info2")
+			So(len(c.KeyList()), ShouldEqual, 2)
+		})
+
+		Convey("When I remove an entry, it should not be in the key list", func() {
+			So(c.Remove("info1"), ShouldBeNil)
+			So(c.KeyList(), ShouldResemble, []interface{}{"info2"})
+		})
+	})
+}
@@ -0,0 +1,94 @@
+package pcapreplay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// ProcessingUnit describes a processing unit of the replayed traffic and its policy
+type ProcessingUnit struct {
+	ID               string                 `json:"id"`
+	IPAddress        string                 `json:"ip"`
+	IPv6Address      string                 `json:"ipv6,omitempty"`
+	Tags             map[string]string      `json:"tags,omitempty"`
+	Action           policy.PUAction        `json:"action,omitempty"`
+	ApplicationACLs  policy.IPRuleList      `json:"applicationACLs,omitempty"`
+	NetworkACLs      policy.IPRuleList      `json:"networkACLs,omitempty"`
+	TransmitterRules policy.TagSelectorList `json:"transmitterRules,omitempty"`
+	ReceiverRules    policy.TagSelectorList `json:"receiverRules,omitempty"`
+	ExcludedNetworks []string               `json:"excludedNetworks,omitempty"`
+}
+
+// PolicyFile is the content of the policy file of a replay. The processing units
+// are the ones running on the host where the traffic was captured.
+type PolicyFile struct {
+	PSK             string            `json:"psk,omitempty"`
+	ProcessingUnits []*ProcessingUnit `json:"processingUnits"`
+}
+
+// LoadPolicyFile reads and validates a policy file
+func LoadPolicyFile(filename string) (*PolicyFile, error) {
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read policy file %s: %s", filename, err)
+	}
+
+	p := &PolicyFile{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("Invalid policy file %s: %s", filename, err)
+	}
+
+	if len(p.ProcessingUnits) == 0 {
+		return nil, fmt.Errorf("No processing units in policy file %s", filename)
+	}
+
+	for i, pu := range p.ProcessingUnits {
+		if pu.ID == "" || pu.IPAddress == "" {
+			return nil, fmt.Errorf("Processing unit %d requires an id and an ip", i)
+		}
+	}
+
+	return p, nil
+}
+
+// PUInfo returns the runtime and policy of the processing unit. Processing
+// units without an action are policed.
+func (p *ProcessingUnit) PUInfo() *policy.PUInfo {
+
+	action := p.Action
+	if action == 0 {
+		action = policy.Police
+	}
+
+	runtimeIPs := policy.ExtendedMap{"bridge": p.IPAddress}
+	if p.IPv6Address != "" {
+		runtimeIPs[policy.DefaultIPv6Namespace] = p.IPv6Address
+	}
+
+	runtime := policy.NewPURuntime(p.ID, 0, "", nil, runtimeIPs, constants.ContainerPU, nil)
+
+	puPolicy := policy.NewPUPolicy(
+		p.ID,
+		action,
+		p.ApplicationACLs,
+		p.NetworkACLs,
+		p.TransmitterRules,
+		p.ReceiverRules,
+		policy.NewTagStoreFromMap(p.Tags),
+		nil,
+		policy.ExtendedMap{policy.DefaultNamespace: p.IPAddress},
+		[]string{},
+		p.ExcludedNetworks,
+	)
+
+	// The enforcer identifies the remote processing unit with the transmitter label
+	puPolicy.AddIdentityTag(enforcer.TransmitterLabel, p.ID)
+
+	return policy.PUInfoFromPolicyAndRuntime(p.ID, puPolicy, runtime)
+}
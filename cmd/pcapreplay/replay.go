@@ -0,0 +1,261 @@
+package pcapreplay
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const (
+	defaultPSK = "trireme replay"
+	serverID   = "pcapreplay"
+)
+
+// ReplayFromArguments replays the pcap file of the arguments with the policy
+// file of the arguments and prints the results on the standard output
+func ReplayFromArguments(arguments map[string]interface{}) error {
+
+	policyFile, ok := arguments["--policy"].(string)
+	if !ok || policyFile == "" {
+		return fmt.Errorf("A policy file is required")
+	}
+
+	pcapFile, ok := arguments["<pcap>"].(string)
+	if !ok || pcapFile == "" {
+		return fmt.Errorf("A pcap file is required")
+	}
+
+	p, err := LoadPolicyFile(policyFile)
+	if err != nil {
+		return err
+	}
+
+	r, err := NewReplayer(p, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	return r.ReplayFile(pcapFile)
+}
+
+// Stats are the totals of a replay
+type Stats struct {
+	Packets  int
+	Accepted int
+	Dropped  int
+	Skipped  int
+}
+
+// flowPrinter collects the flow records generated while a packet is processed
+type flowPrinter struct {
+	flows []*collector.FlowRecord
+}
+
+// CollectFlowEvent implements the EventCollector interface
+func (c *flowPrinter) CollectFlowEvent(record *collector.FlowRecord) {
+	c.flows = append(c.flows, record)
+}
+
+// CollectContainerEvent implements the EventCollector interface
+func (c *flowPrinter) CollectContainerEvent(record *collector.ContainerRecord) {}
+
+// Replayer feeds captured packets through the datapath of an enforcer that
+// enforces the processing units of a policy file. Packets sent by a processing
+// unit are processed as application packets and packets destined to a processing
+// unit are processed as network packets. A packet between two processing units
+// goes through both. The capture must hold the traffic of the processing units
+// before any enforcer processing, like a capture of their interfaces.
+type Replayer struct {
+	enforcer *enforcer.Datapath
+	flows    *flowPrinter
+	local    map[string]string
+	output   io.Writer
+	Stats    Stats
+}
+
+// NewReplayer creates a new replayer that prints the verdicts on the output
+func NewReplayer(p *PolicyFile, output io.Writer) (*Replayer, error) {
+
+	psk := p.PSK
+	if psk == "" {
+		psk = defaultPSK
+	}
+
+	flows := &flowPrinter{}
+	secret := secrets.NewPSKSecrets([]byte(psk))
+
+	r := &Replayer{
+		enforcer: enforcer.NewWithPacketSources(serverID, flows, nil, secret, constants.LocalContainer, "/proc", nil, nil).(*enforcer.Datapath),
+		flows:    flows,
+		local:    map[string]string{},
+		output:   output,
+	}
+
+	for _, pu := range p.ProcessingUnits {
+		if err := r.enforcer.Enforce(pu.ID, pu.PUInfo()); err != nil {
+			return nil, fmt.Errorf("Unable to enforce processing unit %s: %s", pu.ID, err)
+		}
+
+		// Addresses are stored in the same format as the packet addresses
+		for _, address := range []string{pu.IPAddress, pu.IPv6Address} {
+			if ip := net.ParseIP(address); ip != nil {
+				r.local[ip.String()] = pu.ID
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// ReplayFile replays all the packets of a pcap file
+func (r *Replayer) ReplayFile(filename string) error {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("Unable to open pcap file %s: %s", filename, err)
+	}
+	defer f.Close() // nolint
+
+	return r.Replay(f)
+}
+
+// Replay replays all the packets of a pcap stream and prints the totals
+func (r *Replayer) Replay(input io.Reader) error {
+
+	reader, err := pcapgo.NewReader(input)
+	if err != nil {
+		return fmt.Errorf("Invalid pcap file: %s", err)
+	}
+
+	for {
+		data, _, err := reader.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read packet %d: %s", r.Stats.Packets+1, err)
+		}
+
+		r.ReplayPacket(data, reader.LinkType())
+	}
+
+	fmt.Fprintf(r.output, "%d packets: %d accepted, %d dropped, %d skipped\n", r.Stats.Packets, r.Stats.Accepted, r.Stats.Dropped, r.Stats.Skipped) // nolint
+
+	return nil
+}
+
+// ReplayPacket processes a single packet captured with the given link type
+func (r *Replayer) ReplayPacket(data []byte, linkType layers.LinkType) {
+
+	r.Stats.Packets++
+
+	decoded := gopacket.NewPacket(data, linkType, gopacket.Default)
+	network := decoded.NetworkLayer()
+	if network == nil {
+		r.skip("not an IP packet")
+		return
+	}
+
+	buffer := append(append([]byte{}, network.LayerContents()...), network.LayerPayload()...)
+
+	src, dst := network.NetworkFlow().Endpoints()
+	_, fromPU := r.local[src.String()]
+	_, toPU := r.local[dst.String()]
+
+	if !fromPU && !toPU {
+		r.skip("no processing unit for " + src.String() + " -> " + dst.String())
+		return
+	}
+
+	if fromPU {
+		processed, ok := r.process(packet.PacketTypeApplication, buffer)
+		if !ok {
+			r.Stats.Dropped++
+			return
+		}
+		buffer = processed
+	}
+
+	if toPU {
+		if _, ok := r.process(packet.PacketTypeNetwork, buffer); !ok {
+			r.Stats.Dropped++
+			return
+		}
+	}
+
+	r.Stats.Accepted++
+}
+
+// process runs one stage of the datapath and prints the verdict and the flows
+// reported by the enforcer. It returns the packet to transmit if it is accepted.
+func (r *Replayer) process(context uint64, buffer []byte) ([]byte, bool) {
+
+	stage := "network"
+	if context == packet.PacketTypeApplication {
+		stage = "application"
+	}
+
+	p, err := packet.New(context, buffer, "0")
+	if err != nil {
+		fmt.Fprintf(r.output, "%d %s drop: %s\n", r.Stats.Packets, stage, err) // nolint
+		return nil, false
+	}
+
+	description := describe(p)
+
+	if context == packet.PacketTypeApplication {
+		err = r.enforcer.ProcessApplicationPacket(p)
+	} else {
+		err = r.enforcer.ProcessNetworkPacket(p)
+	}
+
+	if err != nil {
+		fmt.Fprintf(r.output, "%d %s %s drop: %s\n", r.Stats.Packets, stage, description, err) // nolint
+	} else {
+		fmt.Fprintf(r.output, "%d %s %s accept\n", r.Stats.Packets, stage, description) // nolint
+	}
+
+	for _, flow := range r.flows.flows {
+		fmt.Fprintf(r.output, "  flow %s\n", flow.String()) // nolint
+	}
+	r.flows.flows = nil
+
+	if err != nil {
+		return nil, false
+	}
+
+	return p.GetBytes(), true
+}
+
+// skip records a packet that is not processed by the datapath
+func (r *Replayer) skip(reason string) {
+
+	r.Stats.Skipped++
+	fmt.Fprintf(r.output, "%d skip: %s\n", r.Stats.Packets, reason) // nolint
+}
+
+// describe returns a short description of a packet
+func describe(p *packet.Packet) string {
+
+	switch p.IPProto {
+	case packet.IPProtocolTCP:
+		return fmt.Sprintf("tcp %s:%d -> %s:%d [%s] len=%d",
+			p.SourceAddress, p.SourcePort, p.DestinationAddress, p.DestinationPort,
+			packet.TCPFlagsToStr(p.TCPFlags), len(p.ReadTCPData()))
+	case packet.IPProtocolUDP:
+		return fmt.Sprintf("udp %s:%d -> %s:%d",
+			p.SourceAddress, p.SourcePort, p.DestinationAddress, p.DestinationPort)
+	default:
+		return fmt.Sprintf("proto %d %s -> %s", p.IPProto, p.SourceAddress, p.DestinationAddress)
+	}
+}
@@ -0,0 +1,154 @@
+package pcapreplay
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/enforcer/utils/packetgen"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	testPolicy = `{
+	"processingUnits": [
+		{"id": "client", "ip": "10.1.1.1", "tags": {"app": "client"}},
+		{
+			"id": "server",
+			"ip": "10.1.1.2",
+			"tags": {"app": "server"},
+			"receiverRules": [
+				{
+					"Clause": [{"Key": "app", "Value": ["client"], "Operator": "="}],
+					"Policy": {"Action": 1, "PolicyID": "allow-client"}
+				}
+			]
+		}
+	]
+}`
+)
+
+func writePolicyFile(content string) string {
+
+	f, err := ioutil.TempFile("", "policy")
+	So(err, ShouldBeNil)
+	defer f.Close() // nolint
+
+	_, err = f.WriteString(content)
+	So(err, ShouldBeNil)
+
+	return f.Name()
+}
+
+func createPcap(srcIP, dstIP string) []byte {
+
+	flow, err := packetgen.NewPacketFlow("aa:ff:aa:ff:aa:ff", "ff:aa:ff:aa:ff:aa", srcIP, dstIP, 2000, 80).GenerateTCPFlow(packetgen.PacketFlowTypeGenerateGoodFlow)
+	So(err, ShouldBeNil)
+
+	output := &bytes.Buffer{}
+	writer := pcapgo.NewWriter(output)
+	So(writer.WriteFileHeader(65536, layers.LinkTypeRaw), ShouldBeNil)
+
+	for i := 0; i < flow.GetNumPackets(); i++ {
+		data, err := flow.GetNthPacket(i).ToBytes()
+		So(err, ShouldBeNil)
+		So(writer.WritePacket(gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}, data), ShouldBeNil)
+	}
+
+	return output.Bytes()
+}
+
+func TestLoadPolicyFile(t *testing.T) {
+
+	Convey("When I load a valid policy file", t, func() {
+		filename := writePolicyFile(testPolicy)
+		defer os.Remove(filename) // nolint
+
+		p, err := LoadPolicyFile(filename)
+
+		Convey("Then I should get the processing units", func() {
+			So(err, ShouldBeNil)
+			So(len(p.ProcessingUnits), ShouldEqual, 2)
+			So(p.ProcessingUnits[1].ReceiverRules[0].Policy.PolicyID, ShouldEqual, "allow-client")
+		})
+	})
+
+	Convey("When I load a policy file without processing units, I should get an error", t, func() {
+		filename := writePolicyFile(`{"processingUnits": []}`)
+		defer os.Remove(filename) // nolint
+
+		_, err := LoadPolicyFile(filename)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("When I load a policy file with a processing unit without an IP, I should get an error", t, func() {
+		filename := writePolicyFile(`{"processingUnits": [{"id": "client"}]}`)
+		defer os.Remove(filename) // nolint
+
+		_, err := LoadPolicyFile(filename)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("When I load a file that doesn't exist, I should get an error", t, func() {
+		_, err := LoadPolicyFile("/nonexistent/policy.json")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestReplay(t *testing.T) {
+
+	Convey("Given I create a replayer for a policy file", t, func() {
+
+		filename := writePolicyFile(testPolicy)
+		defer os.Remove(filename) // nolint
+
+		p, err := LoadPolicyFile(filename)
+		So(err, ShouldBeNil)
+
+		output := &bytes.Buffer{}
+		r, err := NewReplayer(p, output)
+		So(err, ShouldBeNil)
+
+		Convey("When I replay an allowed connection", func() {
+			So(r.Replay(bytes.NewReader(createPcap("10.1.1.1", "10.1.1.2"))), ShouldBeNil)
+
+			Convey("Then all the packets should be accepted and the flow reported", func() {
+				So(r.Stats, ShouldResemble, Stats{Packets: 3, Accepted: 3})
+				So(output.String(), ShouldContainSubstring, "1 application tcp 10.1.1.1:2000 -> 10.1.1.2:80 [....S.] len=0 accept")
+				So(output.String(), ShouldContainSubstring, "1 network tcp 10.1.1.1:2000 -> 10.1.1.2:80")
+				So(output.String(), ShouldContainSubstring, "flow <flowrecord contextID:server")
+				So(output.String(), ShouldContainSubstring, "3 packets: 3 accepted, 0 dropped, 0 skipped")
+			})
+		})
+
+		Convey("When I replay a connection that the policy rejects", func() {
+			So(r.Replay(bytes.NewReader(createPcap("10.1.1.2", "10.1.1.1"))), ShouldBeNil)
+
+			Convey("Then the syn should be dropped with the reason", func() {
+				So(r.Stats.Dropped, ShouldBeGreaterThan, 0)
+				So(output.String(), ShouldContainSubstring, "1 network tcp 10.1.1.2:2000 -> 10.1.1.1:80 [....S.]")
+				So(output.String(), ShouldContainSubstring, "drop: Packet processing failed for network packet")
+				So(output.String(), ShouldContainSubstring, "flow <flowrecord contextID:client")
+			})
+		})
+
+		Convey("When I replay a connection between unknown hosts", func() {
+			So(r.Replay(bytes.NewReader(createPcap("10.2.2.1", "10.2.2.2"))), ShouldBeNil)
+
+			Convey("Then all the packets should be skipped", func() {
+				So(r.Stats, ShouldResemble, Stats{Packets: 3, Skipped: 3})
+				So(strings.Count(output.String(), "skip: no processing unit"), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When I replay an invalid pcap file, I should get an error", func() {
+			So(r.Replay(bytes.NewReader([]byte("not a pcap"))), ShouldNotBeNil)
+		})
+	})
+}
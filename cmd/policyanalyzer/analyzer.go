@@ -0,0 +1,116 @@
+package policyanalyzer
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/policy/analyzer"
+	"github.com/aporeto-inc/trireme/resolver"
+)
+
+// ProcessingUnit is a processing unit and its runtime tags
+type ProcessingUnit struct {
+	ID   string            `json:"id" yaml:"id"`
+	Tags map[string]string `json:"tags" yaml:"tags"`
+}
+
+// ProcessingUnits is the content of the file of the processing units to analyze
+type ProcessingUnits struct {
+	ProcessingUnits []*ProcessingUnit `json:"processingUnits" yaml:"processingUnits"`
+}
+
+// AnalyzeFromArguments analyzes the policies of the processing units of the
+// arguments with the policy document of the arguments and prints the problems
+// found on the standard output
+func AnalyzeFromArguments(arguments map[string]interface{}) error {
+
+	policyFile, ok := arguments["--policy"].(string)
+	if !ok || policyFile == "" {
+		return fmt.Errorf("A policy file is required")
+	}
+
+	puFile, ok := arguments["<pus>"].(string)
+	if !ok || puFile == "" {
+		return fmt.Errorf("A processing unit file is required")
+	}
+
+	data, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read policy file %s: %s", policyFile, err)
+	}
+
+	document, err := resolver.ParseDocument(policyFile, data)
+	if err != nil {
+		return fmt.Errorf("Invalid policy file: %s", err)
+	}
+
+	pus, err := LoadProcessingUnits(puFile)
+	if err != nil {
+		return err
+	}
+
+	count := Analyze(document, pus, os.Stdout)
+	if count > 0 {
+		return fmt.Errorf("%d problems found", count)
+	}
+
+	return nil
+}
+
+// LoadProcessingUnits reads a YAML or JSON file of processing units
+func LoadProcessingUnits(filename string) ([]*ProcessingUnit, error) {
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read processing unit file %s: %s", filename, err)
+	}
+
+	p := &ProcessingUnits{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("Invalid processing unit file %s: %s", filename, err)
+	}
+
+	for i, pu := range p.ProcessingUnits {
+		if pu == nil || pu.ID == "" {
+			return nil, fmt.Errorf("Processing unit %d requires an id", i)
+		}
+	}
+
+	return p.ProcessingUnits, nil
+}
+
+// Analyze resolves the policy of each processing unit with the document and
+// writes the problems found in the policies. The tags referenced by the
+// selectors are checked against the identities of all the processing units.
+// It returns the number of problems found.
+func Analyze(document *resolver.Document, pus []*ProcessingUnit, w io.Writer) int {
+
+	policies := make([]*policy.PUPolicy, len(pus))
+	identities := make([]*policy.TagStore, len(pus))
+
+	for i, pu := range pus {
+		runtime := policy.NewPURuntime(pu.ID, 0, "", policy.NewTagStoreFromMap(pu.Tags), nil, constants.ContainerPU, nil)
+
+		policies[i] = document.Resolve(pu.ID, runtime)
+		policies[i].AddIdentityTag(enforcer.TransmitterLabel, pu.ID)
+		identities[i] = policies[i].Identity()
+	}
+
+	count := 0
+
+	for i, pu := range pus {
+		for _, finding := range analyzer.Analyze(policies[i], identities) {
+			fmt.Fprintf(w, "%s: %s\n", pu.ID, finding) // nolint
+			count++
+		}
+	}
+
+	return count
+}
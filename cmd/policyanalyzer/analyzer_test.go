@@ -0,0 +1,79 @@
+package policyanalyzer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/resolver"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testDocument = `
+policies:
+- selector: {app: web}
+  runtimeIdentity: [app]
+  receiverRules:
+  - clauses:
+    - {key: app, operator: "=", values: [lb]}
+    actions: [reject]
+  - clauses:
+    - {key: app, operator: "=", values: [lb]}
+    - {key: "$sys:port", operator: "=", values: ["80"]}
+    actions: [accept]
+- runtimeIdentity: [app]
+  receiverRules:
+  - clauses:
+    - {key: AporetoContextID, operator: in, values: [web-1]}
+    actions: [accept]
+`
+
+func writeFile(content string) string {
+
+	f, err := ioutil.TempFile("", "policyanalyzer")
+	So(err, ShouldBeNil)
+	defer f.Close() // nolint
+
+	_, err = f.WriteString(content)
+	So(err, ShouldBeNil)
+
+	return f.Name()
+}
+
+func TestAnalyze(t *testing.T) {
+
+	Convey("Given a policy document and processing units", t, func() {
+
+		document, err := resolver.ParseDocument("policy.yaml", []byte(testDocument))
+		So(err, ShouldBeNil)
+
+		filename := writeFile(`{"processingUnits": [{"id": "web-1", "tags": {"app": "web"}}, {"id": "db-1", "tags": {"app": "db"}}]}`)
+		defer os.Remove(filename) // nolint
+
+		pus, err := LoadProcessingUnits(filename)
+		So(err, ShouldBeNil)
+		So(pus, ShouldHaveLength, 2)
+
+		Convey("When I analyze the policies, the problems should be written", func() {
+			output := &bytes.Buffer{}
+			So(Analyze(document, pus, output), ShouldEqual, 3)
+			So(output.String(), ShouldEqual,
+				"web-1: receiverRules[1]: shadowed: all the tags it matches are rejected by receiverRules[0]\n"+
+					"web-1: receiverRules[0]: unknown-tag: clause 0 references values of the key app that no processing unit carries: lb\n"+
+					"web-1: receiverRules[1]: unknown-tag: clause 0 references values of the key app that no processing unit carries: lb\n")
+		})
+	})
+
+	Convey("When I load a processing unit without an id, I should get an error", t, func() {
+		filename := writeFile("processingUnits:\n- tags: {app: web}\n")
+		defer os.Remove(filename) // nolint
+
+		_, err := LoadProcessingUnits(filename)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("When the arguments miss the policy file, I should get an error", t, func() {
+		So(AnalyzeFromArguments(map[string]interface{}{"<pus>": "pus.yaml"}), ShouldNotBeNil)
+	})
+}
@@ -2,6 +2,7 @@ package remoteenforcer
 
 import (
 	"sync"
+	"time"
 
 	"github.com/aporeto-inc/trireme/collector"
 )
@@ -36,6 +37,21 @@ func (c *CollectorImpl) CollectFlowEvent(record *collector.FlowRecord) {
 
 	if r, ok := c.Flows[hash]; ok {
 		r.Count = r.Count + record.Count
+		r.ForwardPackets = r.ForwardPackets + record.ForwardPackets
+		r.ForwardBytes = r.ForwardBytes + record.ForwardBytes
+		r.ReversePackets = r.ReversePackets + record.ReversePackets
+		r.ReverseBytes = r.ReverseBytes + record.ReverseBytes
+
+		// Merged records span from the first start to the last end, and only
+		// end when all the merged flows ended
+		if r.StartTime.IsZero() || (!record.StartTime.IsZero() && record.StartTime.Before(r.StartTime)) {
+			r.StartTime = record.StartTime
+		}
+		if record.EndTime.IsZero() {
+			r.EndTime = time.Time{}
+		} else if !r.EndTime.IsZero() && record.EndTime.After(r.EndTime) {
+			r.EndTime = record.EndTime
+		}
 		return
 	}
 
@@ -2,6 +2,7 @@ package remoteenforcer
 
 import (
 	"testing"
+	"time"
 
 	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/policy"
@@ -101,3 +102,78 @@ func TestCollectFlowEvent(t *testing.T) {
 		})
 	})
 }
+
+func TestCollectFlowVolume(t *testing.T) {
+	Convey("Given a stats collector", t, func() {
+		c := NewCollector()
+
+		start := time.Now()
+		newRecord := func(packets, bytes uint64, startTime, endTime time.Time) *collector.FlowRecord {
+			return &collector.FlowRecord{
+				ContextID: "1",
+				Source: &collector.EndPoint{
+					ID:   "A",
+					IP:   "1.1.1.1",
+					Type: collector.PU,
+				},
+				Destination: &collector.EndPoint{
+					ID:   "B",
+					IP:   "2.2.2.2",
+					Type: collector.PU,
+					Port: 80,
+				},
+				Volume:         true,
+				Tags:           policy.NewTagStore(),
+				StartTime:      startTime,
+				EndTime:        endTime,
+				ForwardPackets: packets,
+				ForwardBytes:   bytes,
+				ReversePackets: packets,
+				ReverseBytes:   bytes,
+			}
+		}
+
+		Convey("When I add two volume records of the same flows and one of them ended", func() {
+			r := newRecord(10, 1000, start.Add(time.Second), time.Time{})
+			c.CollectFlowEvent(r)
+			c.CollectFlowEvent(newRecord(5, 500, start, start.Add(time.Minute)))
+
+			Convey("Then the volumes and the start times should be merged and the flows should not end", func() {
+				f := c.Flows[collector.StatsFlowHash(r)]
+				So(len(c.Flows), ShouldEqual, 1)
+				So(f.Count, ShouldEqual, 2)
+				So(f.ForwardPackets, ShouldEqual, 15)
+				So(f.ForwardBytes, ShouldEqual, 1500)
+				So(f.ReversePackets, ShouldEqual, 15)
+				So(f.ReverseBytes, ShouldEqual, 1500)
+				So(f.StartTime, ShouldResemble, start)
+				So(f.EndTime.IsZero(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I add two volume records of the same flows that ended", func() {
+			r := newRecord(10, 1000, start.Add(time.Second), start.Add(2*time.Minute))
+			c.CollectFlowEvent(r)
+			c.CollectFlowEvent(newRecord(5, 500, start, start.Add(time.Minute)))
+			c.CollectFlowEvent(newRecord(1, 100, start, start.Add(3*time.Minute)))
+
+			Convey("Then the flows should end at the last end", func() {
+				f := c.Flows[collector.StatsFlowHash(r)]
+				So(f.Count, ShouldEqual, 3)
+				So(f.EndTime, ShouldResemble, start.Add(3*time.Minute))
+			})
+		})
+
+		Convey("When I add the volume record of an accepted flow", func() {
+			r := newRecord(10, 1000, start, time.Time{})
+			accepted := newRecord(0, 0, start, time.Time{})
+			accepted.Volume = false
+			c.CollectFlowEvent(r)
+			c.CollectFlowEvent(accepted)
+
+			Convey("Then it should not be merged with the accepted record", func() {
+				So(len(c.Flows), ShouldEqual, 2)
+			})
+		})
+	})
+}
@@ -52,6 +52,21 @@ func (s *Server) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Response) erro
 	return nil
 }
 
+// Connections returns the connections of a context that are tracked by the enforcer
+func (s *Server) Connections(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	return nil
+}
+
+// EvaluateFlow returns the decision of the policy of a context for a flow
+func (s *Server) EvaluateFlow(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	return nil
+}
+
+// DriftStats returns the counts of the drifts of the rules repaired by the supervisor
+func (s *Server) DriftStats(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	return nil
+}
+
 // EnforcerExit this method is called when  we received a killrpocess message from the controller
 // This allows a graceful exit of the enforcer
 func (s *Server) EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
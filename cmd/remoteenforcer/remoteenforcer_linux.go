@@ -240,11 +240,16 @@ func (s *Server) InitSupervisor(req rpcwrapper.Request, resp *rpcwrapper.Respons
 			//TO DO
 			return fmt.Errorf("IPSets not supported yet")
 		default:
+			implementation := constants.IPTables
+			if payload.CaptureMethod == rpcwrapper.NFTables {
+				implementation = constants.NFTables
+			}
+
 			supervisorHandle, err := supervisor.NewSupervisor(
 				s.statsclient.(*StatsClient).collector,
 				s.Enforcer,
 				constants.RemoteContainer,
-				constants.IPTables,
+				implementation,
 				payload.TriremeNetworks,
 			)
 			if err != nil {
@@ -254,6 +259,8 @@ func (s *Server) InitSupervisor(req rpcwrapper.Request, resp *rpcwrapper.Respons
 			s.Supervisor = supervisorHandle
 		}
 
+		s.Supervisor.SetReconcileInterval(payload.ReconcileInterval)
+
 		if err := s.Supervisor.Start(); err != nil {
 			zap.L().Error("Error when starting the supervisor", zap.Error(err))
 		}
@@ -297,6 +304,8 @@ func (s *Server) Supervise(req rpcwrapper.Request, resp *rpcwrapper.Response) er
 		payload.TriremeNetworks,
 		payload.ExcludedNetworks)
 
+	pupolicy.SetGeneration(payload.Generation)
+
 	runtime := policy.NewPURuntimeWithDefaults()
 
 	puInfo := policy.PUInfoFromPolicyAndRuntime(payload.ContextID, pupolicy, runtime)
@@ -375,6 +384,9 @@ func (s *Server) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Response) erro
 		payload.TriremeNetworks,
 		payload.ExcludedNetworks)
 
+	pupolicy.SetRejectAction(payload.RejectAction)
+	pupolicy.SetGeneration(payload.Generation)
+
 	runtime := policy.NewPURuntimeWithDefaults()
 	puInfo := policy.PUInfoFromPolicyAndRuntime(payload.ContextID, pupolicy, runtime)
 	if puInfo == nil {
@@ -395,6 +407,91 @@ func (s *Server) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Response) erro
 	return nil
 }
 
+// Connections returns the connections of a context that are tracked by the enforcer
+func (s *Server) Connections(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpchdl.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = ("Connections Message Auth Failed")
+		return errors.New(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.Enforcer == nil {
+		resp.Status = ("Enforcer not initialized")
+		return errors.New(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.ConnectionsPayload)
+
+	connections, err := s.Enforcer.Connections(payload.ContextID)
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	resp.Payload = rpcwrapper.ConnectionsResponsePayload{
+		Connections: connections,
+	}
+
+	return nil
+}
+
+// EvaluateFlow returns the decision of the policy of a context for a flow
+func (s *Server) EvaluateFlow(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpchdl.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = ("EvaluateFlow Message Auth Failed")
+		return errors.New(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.Enforcer == nil {
+		resp.Status = ("Enforcer not initialized")
+		return errors.New(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.EvaluateFlowPayload)
+
+	decision, err := s.Enforcer.EvaluateFlow(payload.ContextID, payload.Query)
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	resp.Payload = rpcwrapper.EvaluateFlowResponsePayload{
+		Decision: decision,
+	}
+
+	return nil
+}
+
+// DriftStats returns the counts of the drifts of the rules repaired by the supervisor
+func (s *Server) DriftStats(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpchdl.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = ("DriftStats Message Auth Failed")
+		return errors.New(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.Supervisor == nil {
+		resp.Status = ("Supervisor not initialized")
+		return errors.New(resp.Status)
+	}
+
+	resp.Payload = rpcwrapper.DriftStatsResponsePayload{
+		Stats: s.Supervisor.DriftStats(),
+	}
+
+	return nil
+}
+
 // EnforcerExit this method is called when  we received a killrpocess message from the controller
 // This allows a graceful exit of the enforcer
 func (s *Server) EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
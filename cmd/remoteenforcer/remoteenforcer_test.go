@@ -854,6 +854,172 @@ func TestUnEnforce(t *testing.T) {
 	})
 }
 
+func TestConnections(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I create a new server with env set", t, func() {
+		serr := os.Setenv("STATSCHANNEL_PATH", "/tmp/test.sock")
+		So(serr, ShouldBeNil)
+		serr = os.Setenv("STATS_SECRET", "KMvm4a6kgLLma5NitOMGx2f9k21G3nrAaLbgA5zNNHM=")
+		So(serr, ShouldBeNil)
+
+		rpcHdl := rpcwrapper.NewRPCServer()
+		mockEnf := mockenforcer.NewMockPolicyEnforcer(ctrl)
+
+		var service enforcer.PacketProcessor
+		server, err := NewServer(service, rpcHdl, os.Getenv("STATSCHANNEL_PATH"), os.Getenv("STATS_SECRET"), nil)
+		So(err, ShouldBeNil)
+
+		var rpcwrperreq rpcwrapper.Request
+		var rpcwrperres rpcwrapper.Response
+		rpcwrperreq.Payload = rpcwrapper.ConnectionsPayload{ContextID: "b06f47830f64"}
+
+		Convey("When I try to send Connections command with invalid secret", func() {
+			digest := hmac.New(sha256.New, []byte("InvalidSecret"))
+			if _, err := digest.Write(structhash.Dump(rpcwrperreq.Payload, 1)); err != nil {
+				So(err, ShouldBeNil)
+			}
+			rpcwrperreq.HashAuth = digest.Sum(nil)
+
+			server.Enforcer = mockEnf
+			err := server.Connections(rpcwrperreq, &rpcwrperres)
+
+			Convey("Then I should get error", func() {
+				So(err, ShouldResemble, fmt.Errorf("Connections Message Auth Failed"))
+			})
+		})
+
+		Convey("When I try to send Connections command", func() {
+			records := []*collector.ConnectionRecord{{ContextID: "b06f47830f64", State: "Data"}}
+			mockEnf.EXPECT().Connections("b06f47830f64").Times(1).Return(records, nil)
+
+			digest := hmac.New(sha256.New, []byte(os.Getenv("STATS_SECRET")))
+			if _, err := digest.Write(structhash.Dump(rpcwrperreq.Payload, 1)); err != nil {
+				So(err, ShouldBeNil)
+			}
+			rpcwrperreq.HashAuth = digest.Sum(nil)
+
+			server.Enforcer = mockEnf
+			err := server.Connections(rpcwrperreq, &rpcwrperres)
+
+			Convey("Then I should get the connections in the response", func() {
+				So(err, ShouldBeNil)
+				So(rpcwrperres.Payload, ShouldResemble, rpcwrapper.ConnectionsResponsePayload{Connections: records})
+			})
+		})
+
+		serr = os.Setenv("STATSCHANNEL_PATH", "")
+		So(serr, ShouldBeNil)
+		serr = os.Setenv("STATS_SECRET", "")
+		So(serr, ShouldBeNil)
+	})
+}
+
+func TestEvaluateFlow(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I create a new server with env set", t, func() {
+		serr := os.Setenv("STATSCHANNEL_PATH", "/tmp/test.sock")
+		So(serr, ShouldBeNil)
+		serr = os.Setenv("STATS_SECRET", "KMvm4a6kgLLma5NitOMGx2f9k21G3nrAaLbgA5zNNHM=")
+		So(serr, ShouldBeNil)
+
+		rpcHdl := rpcwrapper.NewRPCServer()
+		mockEnf := mockenforcer.NewMockPolicyEnforcer(ctrl)
+
+		var service enforcer.PacketProcessor
+		server, err := NewServer(service, rpcHdl, os.Getenv("STATSCHANNEL_PATH"), os.Getenv("STATS_SECRET"), nil)
+		So(err, ShouldBeNil)
+
+		query := &policy.FlowQuery{Direction: policy.IncomingFlow, RemoteIP: "10.1.1.1", Port: 80}
+
+		var rpcwrperreq rpcwrapper.Request
+		var rpcwrperres rpcwrapper.Response
+		rpcwrperreq.Payload = rpcwrapper.EvaluateFlowPayload{ContextID: "b06f47830f64", Query: query}
+
+		Convey("When I try to send EvaluateFlow command", func() {
+			decision := &policy.FlowDecision{Action: policy.Reject, PolicyID: "default", Source: policy.DefaultDecision}
+			mockEnf.EXPECT().EvaluateFlow("b06f47830f64", query).Times(1).Return(decision, nil)
+
+			digest := hmac.New(sha256.New, []byte(os.Getenv("STATS_SECRET")))
+			if _, err := digest.Write(structhash.Dump(rpcwrperreq.Payload, 1)); err != nil {
+				So(err, ShouldBeNil)
+			}
+			rpcwrperreq.HashAuth = digest.Sum(nil)
+
+			server.Enforcer = mockEnf
+			err := server.EvaluateFlow(rpcwrperreq, &rpcwrperres)
+
+			Convey("Then I should get the decision in the response", func() {
+				So(err, ShouldBeNil)
+				So(rpcwrperres.Payload, ShouldResemble, rpcwrapper.EvaluateFlowResponsePayload{Decision: decision})
+			})
+		})
+
+		serr = os.Setenv("STATSCHANNEL_PATH", "")
+		So(serr, ShouldBeNil)
+		serr = os.Setenv("STATS_SECRET", "")
+		So(serr, ShouldBeNil)
+	})
+}
+
+func TestDriftStats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I create a new server with env set", t, func() {
+		serr := os.Setenv("STATSCHANNEL_PATH", "/tmp/test.sock")
+		So(serr, ShouldBeNil)
+		serr = os.Setenv("STATS_SECRET", "KMvm4a6kgLLma5NitOMGx2f9k21G3nrAaLbgA5zNNHM=")
+		So(serr, ShouldBeNil)
+
+		rpcHdl := rpcwrapper.NewRPCServer()
+		mockSup := mockinterfaces.NewMockSupervisor(ctrl)
+
+		var service enforcer.PacketProcessor
+		server, err := NewServer(service, rpcHdl, os.Getenv("STATSCHANNEL_PATH"), os.Getenv("STATS_SECRET"), nil)
+		So(err, ShouldBeNil)
+
+		var rpcwrperreq rpcwrapper.Request
+		var rpcwrperres rpcwrapper.Response
+		rpcwrperreq.Payload = rpcwrapper.DriftStatsPayload{ContextID: "b06f47830f64"}
+
+		digest := hmac.New(sha256.New, []byte(os.Getenv("STATS_SECRET")))
+		if _, err := digest.Write(structhash.Dump(rpcwrperreq.Payload, 1)); err != nil {
+			So(err, ShouldBeNil)
+		}
+		rpcwrperreq.HashAuth = digest.Sum(nil)
+
+		Convey("When I try to send DriftStats command before the supervisor is initialized", func() {
+			err := server.DriftStats(rpcwrperreq, &rpcwrperres)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldResemble, fmt.Errorf("Supervisor not initialized"))
+			})
+		})
+
+		Convey("When I try to send DriftStats command", func() {
+			stats := &collector.DriftStats{Reconciliations: 2, GlobalDrifts: 1, UnitDrifts: map[string]int{"b06f47830f64": 3}}
+			mockSup.EXPECT().DriftStats().Times(1).Return(stats)
+
+			server.Supervisor = mockSup
+			err := server.DriftStats(rpcwrperreq, &rpcwrperres)
+
+			Convey("Then I should get the drifts in the response", func() {
+				So(err, ShouldBeNil)
+				So(rpcwrperres.Payload, ShouldResemble, rpcwrapper.DriftStatsResponsePayload{Stats: stats})
+			})
+		})
+
+		serr = os.Setenv("STATSCHANNEL_PATH", "")
+		So(serr, ShouldBeNil)
+		serr = os.Setenv("STATS_SECRET", "")
+		So(serr, ShouldBeNil)
+	})
+}
+
 func TestUnSupervise(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -13,5 +13,5 @@ func (d *DefaultCollector) CollectContainerEvent(record *ContainerRecord) {}
 
 // StatsFlowHash is a has function to hash flows
 func StatsFlowHash(r *FlowRecord) string {
-	return r.Source.ID + ":" + r.Destination.ID + ":" + strconv.Itoa(int(r.Destination.Port)) + ":" + r.Action.String() + ":" + r.DropReason
+	return r.Source.ID + ":" + r.Destination.ID + ":" + strconv.Itoa(int(r.Destination.Port)) + ":" + r.Action.String() + ":" + r.DropReason + ":" + strconv.FormatBool(r.Encrypted) + ":" + strconv.FormatBool(r.Observed) + ":" + strconv.FormatBool(r.Volume) + ":" + strconv.FormatUint(r.PolicyGeneration, 10)
 }
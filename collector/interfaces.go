@@ -2,6 +2,7 @@ package collector
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/aporeto-inc/trireme/policy"
 )
@@ -27,6 +28,9 @@ const (
 	InvalidNonse = "nonse"
 	// PolicyDrop indicates that the flow is rejected because of the policy decision
 	PolicyDrop = "policy"
+	// PolicyExpired indicates that the flow is rejected because the schedule of
+	// the policy that accepted it is no longer active
+	PolicyExpired = "expired"
 	// ContainerStart indicates a container start event
 	ContainerStart = "start"
 	// ContainerStop indicates a container stop event
@@ -39,6 +43,9 @@ const (
 	ContainerUpdate = "update"
 	// ContainerFailed indicates an event that a container was stopped because of policy issues
 	ContainerFailed = "forcestop"
+	// ContainerDrift indicates that the rules of a container drifted from the
+	// programmed rules and were repaired
+	ContainerDrift = "drift"
 	// ContainerIgnored indicates that the container will be ignored by Trireme
 	ContainerIgnored = "ignore"
 	// UnknownContainerDelete indicates that policy for an unknown  container was deleted
@@ -76,28 +83,57 @@ func (e *EndPointType) String() string {
 	return "pu"
 }
 
-// EndPoint is a structure that holds all the endpoint information
+// EndPoint is a structure that holds all the endpoint information. The FQDN
+// is the DNS name of the application ACL that matched an external endpoint.
 type EndPoint struct {
 	ID   string
 	IP   string
+	FQDN string
 	Port uint16
 	Type EndPointType
 }
 
-// FlowRecord describes a flow record for statistis
+// FlowRecord describes a flow record for statistis. Accepted flows are reported
+// once when they are accepted, and then with Volume records that carry the
+// packets and bytes seen since the previous report. The last volume record of
+// a flow has its EndTime set. Forward counters are from the source to the
+// destination and reverse counters from the destination to the source.
+// Observed records report flows rejected by the policy of a PU in audit mode,
+// that were let through. The PolicyGeneration is the generation of the policy
+// of the PU that the flow was evaluated against.
 type FlowRecord struct {
-	ContextID   string
-	Count       int
-	Source      *EndPoint
-	Destination *EndPoint
-	Tags        *policy.TagStore
-	Action      policy.ActionType
-	DropReason  string
-	PolicyID    string
+	ContextID        string
+	Count            int
+	Source           *EndPoint
+	Destination      *EndPoint
+	Tags             *policy.TagStore
+	Action           policy.ActionType
+	DropReason       string
+	PolicyID         string
+	PolicyGeneration uint64
+	Encrypted        bool
+	Observed         bool
+	Volume           bool
+	StartTime        time.Time
+	EndTime          time.Time
+	ForwardPackets   uint64
+	ForwardBytes     uint64
+	ReversePackets   uint64
+	ReverseBytes     uint64
+}
+
+// Duration returns the duration of the flow. It is zero until the flow ends.
+func (f *FlowRecord) Duration() time.Duration {
+
+	if f.StartTime.IsZero() || f.EndTime.IsZero() {
+		return 0
+	}
+
+	return f.EndTime.Sub(f.StartTime)
 }
 
 func (f *FlowRecord) String() string {
-	return fmt.Sprintf("<flowrecord contextID:%s count:%d sourceID:%s destinationID:%s sourceIP: %s destinationIP:%s destinationPort:%d action:%s mode:%s>",
+	return fmt.Sprintf("<flowrecord contextID:%s count:%d sourceID:%s destinationID:%s sourceIP: %s destinationIP:%s destinationPort:%d action:%s mode:%s generation:%d encrypted:%t observed:%t volume:%t packets:%d/%d bytes:%d/%d duration:%s>",
 		f.ContextID,
 		f.Count,
 		f.Source.ID,
@@ -107,13 +143,78 @@ func (f *FlowRecord) String() string {
 		f.Destination.Port,
 		f.Action.String(),
 		f.DropReason,
+		f.PolicyGeneration,
+		f.Encrypted,
+		f.Observed,
+		f.Volume,
+		f.ForwardPackets,
+		f.ReversePackets,
+		f.ForwardBytes,
+		f.ReverseBytes,
+		f.Duration(),
 	)
 }
 
-// ContainerRecord is a statistics record for a container
+// ContainerRecord is a statistics record for a container. The PolicyGeneration
+// is the generation of the policy of the container when the event occurred.
 type ContainerRecord struct {
-	ContextID string
-	IPAddress string
-	Tags      *policy.TagStore
-	Event     string
+	ContextID        string
+	IPAddress        string
+	Tags             *policy.TagStore
+	Event            string
+	PolicyGeneration uint64
+}
+
+// DriftStats are the counts of the drifts of the rules of a supervisor that
+// were repaired
+type DriftStats struct {
+	// Reconciliations is the number of reconciliations of the rules
+	Reconciliations int
+	// GlobalDrifts is the number of drifts of the rules shared by the
+	// processing units
+	GlobalDrifts int
+	// UnitDrifts is the number of drifts of the rules of each supervised
+	// processing unit
+	UnitDrifts map[string]int
+}
+
+// ConnectionRecord describes a connection of a processing unit that is currently
+// tracked by the enforcer. The source is the initiator of the connection.
+type ConnectionRecord struct {
+	ContextID       string
+	Protocol        uint8
+	SourceIP        string
+	SourcePort      uint16
+	DestinationIP   string
+	DestinationPort uint16
+	Outgoing        bool
+	State           string
+	RemoteContextID string
+	FlowPolicy      *policy.FlowPolicy
+	Encrypted       bool
+	Age             time.Duration
+}
+
+// String returns a printable version of the connection record
+func (c *ConnectionRecord) String() string {
+
+	action := ""
+	if c.FlowPolicy != nil {
+		action = c.FlowPolicy.Action.String()
+	}
+
+	return fmt.Sprintf("<connection contextID:%s protocol:%d source:%s:%d destination:%s:%d outgoing:%t state:%s remoteContextID:%s action:%s encrypted:%t age:%s>",
+		c.ContextID,
+		c.Protocol,
+		c.SourceIP,
+		c.SourcePort,
+		c.DestinationIP,
+		c.DestinationPort,
+		c.Outgoing,
+		c.State,
+		c.RemoteContextID,
+		action,
+		c.Encrypted,
+		c.Age,
+	)
 }
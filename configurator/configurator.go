@@ -199,7 +199,8 @@ func NewTriremeWithOptions(options *TriremeOptions) (*TriremeResult, error) {
 		s, err = supervisorproxy.NewProxySupervisor(
 			options.EventCollector,
 			e,
-			rpcwrapper)
+			rpcwrapper,
+			options.ImplType)
 
 		if err != nil {
 			zap.L().Fatal("Failed to load Supervisor", zap.Error(err))
@@ -626,7 +627,7 @@ func NewDistributedTriremeDocker(serverID string,
 		),
 	}
 
-	s, err := supervisorproxy.NewProxySupervisor(eventCollector, enforcers[0], rpcwrapper)
+	s, err := supervisorproxy.NewProxySupervisor(eventCollector, enforcers[0], rpcwrapper, impl)
 
 	if err != nil {
 		zap.L().Fatal("Cannot initialize proxy supervisor", zap.Error(err))
@@ -664,7 +665,8 @@ func NewHybridTrireme(
 	containerSupervisor, cerr := supervisorproxy.NewProxySupervisor(
 		eventCollector,
 		containerEnforcer,
-		rpcwrapper)
+		rpcwrapper,
+		constants.IPTables)
 
 	if cerr != nil {
 		zap.L().Fatal("Failed to load Supervisor", zap.Error(cerr))
@@ -185,7 +185,7 @@ func testSupervisor(sec string, impl constants.ImplementationType, pusupmode con
 
 func testSupervisorProxy(sec string, puconmode constants.ModeType) (*supervisorproxy.ProxyInfo, error) {
 	var newSup *supervisorproxy.ProxyInfo
-	newSup, err := supervisorproxy.NewProxySupervisor(eventCollector(), testEnforcer(sec, puconmode), rpcwrapper.NewRPCWrapper())
+	newSup, err := supervisorproxy.NewProxySupervisor(eventCollector(), testEnforcer(sec, puconmode), rpcwrapper.NewRPCWrapper(), constants.IPTables)
 	if err != nil {
 		return nil, err
 	}
@@ -30,6 +30,9 @@ const (
 	IPSets ImplementationType = iota
 	// IPTables mandates an IPTable supervisor implementation
 	IPTables
+	// NFTables mandates an nftables supervisor implementation. It doesn't
+	// support the Linux processes of the users.
+	NFTables
 	// Remote indicates that this is a remote supervisor
 )
 
@@ -57,4 +60,7 @@ const (
 	DefaultRemoteArg = "enforce"
 	// DefaultConnMark is the default conn mark for all data packets
 	DefaultConnMark = uint32(0xEEEE)
+	// EncryptedConnMark is the conn mark of the encrypted connections. All
+	// their packets are sent to the enforcer.
+	EncryptedConnMark = uint32(0xEEEF)
 )
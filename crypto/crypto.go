@@ -71,7 +71,8 @@ func GenerateRandomString(s int) (string, error) {
 }
 
 // CreateEphemeralKey creates an ephmeral private/public key based on the
-// provided public key and the corresponding elliptic curve
+// provided public key and the corresponding elliptic curve. If no public key
+// is provided, the public key is marshaled with the elliptic curve.
 func CreateEphemeralKey(curve func() elliptic.Curve, pub *ecdsa.PublicKey) (*ecdsa.PrivateKey, []byte) {
 
 	ephemeral, err := ecdsa.GenerateKey(curve(), rand.Reader)
@@ -81,12 +82,40 @@ func CreateEphemeralKey(curve func() elliptic.Curve, pub *ecdsa.PublicKey) (*ecd
 		return nil, []byte{}
 	}
 
-	ephPub := elliptic.Marshal(pub.Curve, ephemeral.PublicKey.X, ephemeral.PublicKey.Y)
+	marshalCurve := ephemeral.Curve
+	if pub != nil {
+		marshalCurve = pub.Curve
+	}
+
+	ephPub := elliptic.Marshal(marshalCurve, ephemeral.PublicKey.X, ephemeral.PublicKey.Y)
 
 	return ephemeral, ephPub
 
 }
 
+// ComputeSharedKey computes the ECDH shared key between an ephemeral private key
+// and the marshaled ephemeral public key of the remote
+func ComputeSharedKey(private *ecdsa.PrivateKey, remote []byte) ([]byte, error) {
+
+	if private == nil {
+		return nil, fmt.Errorf("No private key")
+	}
+
+	x, y := elliptic.Unmarshal(private.Curve, remote)
+	if x == nil {
+		return nil, fmt.Errorf("Invalid remote public key")
+	}
+
+	sx, _ := private.Curve.ScalarMult(x, y, private.D.Bytes())
+
+	// Pad the shared key to the size of the curve
+	shared := make([]byte, (private.Curve.Params().BitSize+7)/8)
+	sxBytes := sx.Bytes()
+	copy(shared[len(shared)-len(sxBytes):], sxBytes)
+
+	return shared, nil
+}
+
 // LoadRootCertificates loads the certificates in the provide PEM buffer in a CertPool
 func LoadRootCertificates(rootPEM []byte) *x509.CertPool {
 
@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"crypto/elliptic"
 	"fmt"
 	"testing"
 
@@ -70,6 +71,35 @@ func TestRandomString(t *testing.T) {
 	})
 }
 
+// TestComputeSharedKey tests the ECDH key agreement between two ephemeral keys
+func TestComputeSharedKey(t *testing.T) {
+	Convey("Given two ephemeral keys", t, func() {
+		key1, pub1 := CreateEphemeralKey(elliptic.P256, nil)
+		key2, pub2 := CreateEphemeralKey(elliptic.P256, nil)
+		So(key1, ShouldNotBeNil)
+		So(key2, ShouldNotBeNil)
+
+		Convey("Both sides should compute the same shared key", func() {
+			shared1, err1 := ComputeSharedKey(key1, pub2)
+			shared2, err2 := ComputeSharedKey(key2, pub1)
+			So(err1, ShouldBeNil)
+			So(err2, ShouldBeNil)
+			So(len(shared1), ShouldEqual, 32)
+			So(shared1, ShouldResemble, shared2)
+		})
+
+		Convey("If I provide an invalid public key, I should get an error", func() {
+			_, err := ComputeSharedKey(key1, []byte("invalid"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("If I provide no private key, I should get an error", func() {
+			_, err := ComputeSharedKey(nil, pub2)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
 // TestFuncLoadEllipticCurve
 func TestFuncLoadEllipticCurve(t *testing.T) {
 	Convey("Given a valid EC key", t, func() {
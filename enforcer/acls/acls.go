@@ -4,8 +4,10 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aporeto-inc/trireme/policy"
 )
@@ -15,6 +17,7 @@ type PortAction struct {
 	min    uint16
 	max    uint16
 	policy *policy.FlowPolicy
+	rule   policy.IPRule
 }
 
 // PortActionList is a list of Port Actions
@@ -22,22 +25,56 @@ type PortActionList []*PortAction
 
 // ACLCache holds all the ACLS in an internal DB
 // map[prefixes][subnets] -> list of ports with their actions
+// IPv6 rules are held in a separate map indexed by the prefix length, and
+// the prefix lengths are kept sorted so that the longest prefix matches first.
+// The rules whose address is a DNS name apply to the addresses of the name
+// set with SetAddresses. A cache only holds the rules of a single protocol.
 type ACLCache struct {
-	prefixMap map[uint32]map[uint32]PortActionList
+	protocol      string
+	prefixMap     map[uint32]map[uint32]PortActionList
+	prefixMapV6   map[int]map[[net.IPv6len]byte]PortActionList
+	prefixLensV6  []int
+	fqdnMap       map[string]PortActionList
+	fqdnAddresses map[string][]net.IP
 }
 
-// NewACLCache creates a new ACL cache
+// NewACLCache creates a new ACL cache for TCP rules
 func NewACLCache() *ACLCache {
+	return NewProtocolACLCache("tcp")
+}
+
+// NewProtocolACLCache creates a new ACL cache for the rules of the given protocol.
+// The protocol is a name or a number, and the caches of ICMP match the types
+// and codes of the messages with GetMatchingICMPRule.
+func NewProtocolACLCache(protocol string) *ACLCache {
 	return &ACLCache{
-		prefixMap: make(map[uint32]map[uint32]PortActionList),
+		protocol:      strings.ToLower(protocol),
+		prefixMap:     make(map[uint32]map[uint32]PortActionList),
+		prefixMapV6:   make(map[int]map[[net.IPv6len]byte]PortActionList),
+		fqdnMap:       make(map[string]PortActionList),
+		fqdnAddresses: make(map[string][]net.IP),
 	}
 }
 
-// createPortAction parses a port spec and creates the action
+// createPortAction parses a port spec and creates the action. The ICMP rules
+// match the keys of the types and codes of their messages, and the rules of the
+// other protocols without ports match all the ports.
 func createPortAction(rule policy.IPRule) *PortAction {
 
 	p := &PortAction{}
-	if strings.Contains(rule.Port, ":") {
+	if rule.IsICMP() {
+		icmpType, icmpCode, err := rule.ICMPTypeCode()
+		if err != nil {
+			return nil
+		}
+
+		p.min, p.max = icmpRange(icmpType, icmpCode)
+
+	} else if !rule.HasPorts() {
+		p.min = 0
+		p.max = 0xFFFF
+
+	} else if strings.Contains(rule.Port, ":") {
 		parts := strings.Split(rule.Port, ":")
 		if len(parts) != 2 {
 			return nil
@@ -71,6 +108,7 @@ func createPortAction(rule policy.IPRule) *PortAction {
 	}
 
 	p.policy = rule.Policy
+	p.rule = rule
 
 	return p
 }
@@ -79,10 +117,14 @@ func createPortAction(rule policy.IPRule) *PortAction {
 func (c *ACLCache) AddRule(rule policy.IPRule) (err error) {
 	var subnet, mask uint32
 
-	if strings.ToLower(rule.Protocol) != "tcp" {
+	if !policy.SameProtocol(rule.Protocol, c.protocol) {
 		return nil
 	}
 
+	if name := rule.FQDN(); name != "" {
+		return c.addRuleFQDN(rule, name)
+	}
+
 	parts := strings.Split(rule.Address, "/")
 
 	subnetSlice := net.ParseIP(parts[0])
@@ -90,6 +132,10 @@ func (c *ACLCache) AddRule(rule policy.IPRule) (err error) {
 		return fmt.Errorf("Invalid address")
 	}
 
+	if subnetSlice.To4() == nil {
+		return c.addRuleV6(rule, subnetSlice, parts)
+	}
+
 	subnet = binary.BigEndian.Uint32(subnetSlice.To4())
 
 	switch len(parts) {
@@ -121,6 +167,173 @@ func (c *ACLCache) AddRule(rule policy.IPRule) (err error) {
 	return nil
 }
 
+// addRuleV6 adds a single IPv6 rule to the ACL Cache
+func (c *ACLCache) addRuleV6(rule policy.IPRule, subnetSlice net.IP, parts []string) error {
+
+	prefix := 8 * net.IPv6len
+
+	switch len(parts) {
+	case 1:
+	case 2:
+		maskvalue, err := strconv.Atoi(parts[1])
+		if err != nil || maskvalue < 0 || maskvalue > 8*net.IPv6len {
+			return fmt.Errorf("Invalid address")
+		}
+		prefix = maskvalue
+	default:
+		return fmt.Errorf("Invalid address")
+	}
+
+	c.addPrefixV6(prefix)
+
+	a := createPortAction(rule)
+	if a == nil {
+		return fmt.Errorf("Invalid port")
+	}
+
+	subnet := maskV6(subnetSlice, prefix)
+
+	c.prefixMapV6[prefix][subnet] = append(c.prefixMapV6[prefix][subnet], a)
+
+	return nil
+}
+
+// addPrefixV6 creates the map of the IPv6 subnets of a prefix length, and
+// inserts the length in the sorted prefix lengths
+func (c *ACLCache) addPrefixV6(prefix int) {
+
+	if _, ok := c.prefixMapV6[prefix]; ok {
+		return
+	}
+
+	c.prefixMapV6[prefix] = make(map[[net.IPv6len]byte]PortActionList)
+
+	index := sort.Search(len(c.prefixLensV6), func(i int) bool {
+		return c.prefixLensV6[i] < prefix
+	})
+
+	c.prefixLensV6 = append(c.prefixLensV6, 0)
+	copy(c.prefixLensV6[index+1:], c.prefixLensV6[index:])
+	c.prefixLensV6[index] = prefix
+}
+
+// addRuleFQDN adds a rule whose address is a DNS name to the ACL Cache
+func (c *ACLCache) addRuleFQDN(rule policy.IPRule, name string) error {
+
+	a := createPortAction(rule)
+	if a == nil {
+		return fmt.Errorf("Invalid port")
+	}
+
+	c.fqdnMap[name] = append(c.fqdnMap[name], a)
+
+	for _, ip := range c.fqdnAddresses[name] {
+		c.addHost(ip, PortActionList{a})
+	}
+
+	return nil
+}
+
+// FQDNs returns the sorted DNS names of the rules of the cache
+func (c *ACLCache) FQDNs() []string {
+
+	names := make([]string, 0, len(c.fqdnMap))
+	for name := range c.fqdnMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// SetAddresses sets the addresses of a DNS name. The rules of the name apply
+// to these addresses instead of the previous ones.
+func (c *ACLCache) SetAddresses(name string, ips []net.IP) {
+
+	actions := c.fqdnMap[name]
+
+	for _, ip := range c.fqdnAddresses[name] {
+		c.removeHost(ip, actions)
+	}
+
+	if len(ips) == 0 {
+		delete(c.fqdnAddresses, name)
+		return
+	}
+
+	c.fqdnAddresses[name] = ips
+
+	for _, ip := range ips {
+		c.addHost(ip, actions)
+	}
+}
+
+// addHost adds the port actions of a single address
+func (c *ACLCache) addHost(ip net.IP, actions PortActionList) {
+
+	if len(actions) == 0 {
+		return
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := uint32(0xFFFFFFFF)
+		if _, ok := c.prefixMap[mask]; !ok {
+			c.prefixMap[mask] = make(map[uint32]PortActionList)
+		}
+		addr := binary.BigEndian.Uint32(ip4)
+		c.prefixMap[mask][addr] = append(c.prefixMap[mask][addr], actions...)
+		return
+	}
+
+	prefix := 8 * net.IPv6len
+	c.addPrefixV6(prefix)
+	subnet := maskV6(ip, prefix)
+	c.prefixMapV6[prefix][subnet] = append(c.prefixMapV6[prefix][subnet], actions...)
+}
+
+// removeHost removes the port actions of a single address
+func (c *ACLCache) removeHost(ip net.IP, actions PortActionList) {
+
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := uint32(0xFFFFFFFF)
+		addr := binary.BigEndian.Uint32(ip4)
+		if list := actions.without(c.prefixMap[mask][addr]); len(list) > 0 {
+			c.prefixMap[mask][addr] = list
+		} else {
+			delete(c.prefixMap[mask], addr)
+		}
+		return
+	}
+
+	prefix := 8 * net.IPv6len
+	subnet := maskV6(ip, prefix)
+	if list := actions.without(c.prefixMapV6[prefix][subnet]); len(list) > 0 {
+		c.prefixMapV6[prefix][subnet] = list
+	} else {
+		delete(c.prefixMapV6[prefix], subnet)
+	}
+}
+
+// without returns the port actions of the list that are not in l
+func (l PortActionList) without(list PortActionList) PortActionList {
+
+	remaining := PortActionList{}
+	for _, p := range list {
+		found := false
+		for _, a := range l {
+			if p == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			remaining = append(remaining, p)
+		}
+	}
+
+	return remaining
+}
+
 // AddRuleList adds a list of rules to the cache
 func (c *ACLCache) AddRuleList(rules policy.IPRuleList) (err error) {
 
@@ -133,9 +346,69 @@ func (c *ACLCache) AddRuleList(rules policy.IPRuleList) (err error) {
 	return
 }
 
-// GetMatchingAction gets the matching action
+// ExpiredError is returned when no active rule matches a flow, but an accepting
+// rule whose schedule is not active does
+type ExpiredError struct {
+	Rule policy.IPRule
+}
+
+func (e *ExpiredError) Error() string {
+
+	return fmt.Sprintf("Rule %s is not active", e.Rule.Policy.PolicyID)
+}
+
+// GetMatchingAction gets the matching action. The ip can be either an
+// IPv4 or an IPv6 address. The flows that only match rules whose schedule is
+// not active are rejected with the policy of the rule and an ExpiredError.
 func (c *ACLCache) GetMatchingAction(ip []byte, port uint16) (*policy.FlowPolicy, error) {
 
+	p, err := c.getMatchingPortAction(ip, port)
+	if err != nil {
+		if expired, ok := err.(*ExpiredError); ok {
+			return &policy.FlowPolicy{Action: policy.Reject, PolicyID: expired.Rule.Policy.PolicyID, ServiceID: expired.Rule.Policy.ServiceID}, err
+		}
+		return &policy.FlowPolicy{Action: policy.Reject, PolicyID: "default", ServiceID: "default"}, err
+	}
+
+	return p.policy, nil
+}
+
+// GetMatchingRule gets the rule that provides the matching action. The ip
+// can be either an IPv4 or an IPv6 address. The address of the rule is the
+// DNS name of the rule when the ip is one of the addresses of the name.
+func (c *ACLCache) GetMatchingRule(ip []byte, port uint16) (*policy.IPRule, error) {
+
+	p, err := c.getMatchingPortAction(ip, port)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := p.rule
+
+	return &rule, nil
+}
+
+// GetMatchingICMPRule gets the rule that provides the matching action of an
+// ICMP message of the given type and code. The ip can be either an IPv4 or an
+// IPv6 address.
+func (c *ACLCache) GetMatchingICMPRule(ip []byte, icmpType, icmpCode uint8) (*policy.IPRule, error) {
+
+	return c.GetMatchingRule(ip, icmpKey(icmpType, icmpCode))
+}
+
+// getMatchingPortAction gets the port action that matches the ip and port
+func (c *ACLCache) getMatchingPortAction(ip []byte, port uint16) (*PortAction, error) {
+
+	if len(ip) == net.IPv6len {
+		if ip4 := net.IP(ip).To4(); ip4 != nil {
+			ip = ip4
+		} else {
+			return c.getMatchingPortActionV6(ip, port)
+		}
+	}
+
+	var expired *PortAction
+
 	addr := binary.BigEndian.Uint32(ip)
 	// Iterate over all the bitmasks we have
 	for bitmask, pmap := range c.prefixMap {
@@ -146,11 +419,99 @@ func (c *ACLCache) GetMatchingAction(ip []byte, port uint16) (*policy.FlowPolicy
 			// Scan the ports - TODO: better algorithm needed here
 			for _, p := range actionList {
 				if port >= p.min && port <= p.max {
-					return p.policy, nil
+					if !p.active() {
+						expired = p.expired(expired)
+						continue
+					}
+					return p, nil
 				}
 			}
 		}
 	}
 
-	return &policy.FlowPolicy{Action: policy.Reject, PolicyID: "default", ServiceID: "default"}, fmt.Errorf("No match")
+	return nil, noMatch(expired)
+}
+
+// getMatchingPortActionV6 gets the matching port action for an IPv6 address.
+// The rules of the longest prefix that matches the address win.
+func (c *ACLCache) getMatchingPortActionV6(ip []byte, port uint16) (*PortAction, error) {
+
+	var expired *PortAction
+
+	// Iterate over the prefix lengths from the longest
+	for _, prefix := range c.prefixLensV6 {
+
+		if actionList, ok := c.prefixMapV6[prefix][maskV6(ip, prefix)]; ok {
+
+			for _, p := range actionList {
+				if port >= p.min && port <= p.max {
+					if !p.active() {
+						expired = p.expired(expired)
+						continue
+					}
+					return p, nil
+				}
+			}
+		}
+	}
+
+	return nil, noMatch(expired)
+}
+
+// active returns true if the schedule of the rule is active
+func (p *PortAction) active() bool {
+
+	return p.policy == nil || p.policy.Schedule == nil || p.policy.Schedule.Active(time.Now())
+}
+
+// expired returns the accepting rule that is not active, or the previous one
+func (p *PortAction) expired(previous *PortAction) *PortAction {
+
+	if previous == nil && p.policy != nil && p.policy.Action.Accepted() {
+		return p
+	}
+
+	return previous
+}
+
+// noMatch returns the error of a lookup without an active rule
+func noMatch(expired *PortAction) error {
+
+	if expired != nil {
+		return &ExpiredError{Rule: expired.rule}
+	}
+
+	return fmt.Errorf("No match")
+}
+
+// icmpKey returns the key of the type and code of an ICMP message, that is
+// matched like a port
+func icmpKey(icmpType, icmpCode uint8) uint16 {
+
+	return uint16(icmpType)<<8 | uint16(icmpCode)
+}
+
+// icmpRange returns the range of the keys of the ICMP messages of a type and a
+// code, which are -1 for all the types or all the codes of the type
+func icmpRange(icmpType, icmpCode int) (uint16, uint16) {
+
+	switch {
+	case icmpType < 0:
+		return 0, 0xFFFF
+	case icmpCode < 0:
+		return icmpKey(uint8(icmpType), 0), icmpKey(uint8(icmpType), 0xFF)
+	default:
+		return icmpKey(uint8(icmpType), uint8(icmpCode)), icmpKey(uint8(icmpType), uint8(icmpCode))
+	}
+}
+
+// maskV6 returns the IPv6 address masked with the given prefix length
+func maskV6(ip []byte, prefix int) [net.IPv6len]byte {
+
+	var subnet [net.IPv6len]byte
+
+	masked := net.IP(ip).Mask(net.CIDRMask(prefix, 8*net.IPv6len))
+	copy(subnet[:], masked)
+
+	return subnet
 }
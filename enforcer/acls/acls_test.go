@@ -3,6 +3,7 @@ package acls
 import (
 	"net"
 	"testing"
+	"time"
 
 	"github.com/aporeto-inc/trireme/policy"
 	. "github.com/smartystreets/goconvey/convey"
@@ -100,3 +101,377 @@ func TestLookup(t *testing.T) {
 
 	})
 }
+
+func TestProtocolLookup(t *testing.T) {
+
+	Convey("Given a good DB for UDP rules", t, func() {
+		c := NewProtocolACLCache("UDP")
+		err := c.AddRuleList(rules)
+		So(err, ShouldBeNil)
+		So(len(c.prefixMap), ShouldEqual, 1)
+
+		Convey("When I lookup for a matching address and port of a UDP rule, I should get the right action", func() {
+			ip := net.ParseIP("192.168.100.1")
+			port := uint16(443)
+			a, err := c.GetMatchingAction(ip.To4(), port)
+			So(err, ShouldBeNil)
+			So(a.Action, ShouldEqual, policy.Accept)
+			So(a.PolicyID, ShouldEqual, "5")
+		})
+
+		Convey("When I lookup for a matching address and port of a TCP rule, I should get reject", func() {
+			ip := net.ParseIP("10.1.1.1")
+			port := uint16(80)
+			a, err := c.GetMatchingAction(ip.To4(), port)
+			So(err, ShouldNotBeNil)
+			So(a.Action, ShouldEqual, policy.Reject)
+		})
+	})
+}
+
+func TestICMPLookup(t *testing.T) {
+
+	Convey("Given a DB for ICMP rules that allow the pings and the path MTU discovery", t, func() {
+		c := NewProtocolACLCache("icmp")
+		err := c.AddRuleList(policy.IPRuleList{
+			policy.IPRule{
+				Address:  "10.0.0.0/8",
+				Protocol: "icmp",
+				ICMPType: "8",
+				ICMPCode: "0",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "ping"},
+			},
+			policy.IPRule{
+				Address:  "10.0.0.0/8",
+				Protocol: "1",
+				ICMPType: "3",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "unreachable"},
+			},
+			policy.IPRule{
+				Address:  "10.0.0.0/8",
+				Protocol: "icmp",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject, PolicyID: "icmp"},
+			},
+			policy.IPRule{
+				Address:  "10.0.0.0/8",
+				Protocol: "icmpv6",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "icmpv6"},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("The messages should match the rule of their type and code", func() {
+			a, err := c.GetMatchingICMPRule(net.ParseIP("10.1.1.1").To4(), 8, 0)
+			So(err, ShouldBeNil)
+			So(a.Policy.PolicyID, ShouldEqual, "ping")
+
+			a, err = c.GetMatchingICMPRule(net.ParseIP("10.1.1.1").To4(), 3, 4)
+			So(err, ShouldBeNil)
+			So(a.Policy.PolicyID, ShouldEqual, "unreachable")
+
+			a, err = c.GetMatchingICMPRule(net.ParseIP("10.1.1.1").To4(), 8, 1)
+			So(err, ShouldBeNil)
+			So(a.Policy.PolicyID, ShouldEqual, "icmp")
+			So(a.Policy.Action, ShouldEqual, policy.Reject)
+		})
+	})
+
+	Convey("Given a DB for the rules of protocols without ports", t, func() {
+		c := NewProtocolACLCache("47")
+		err := c.AddRuleList(policy.IPRuleList{
+			policy.IPRule{
+				Address:  "10.0.0.0/8",
+				Protocol: "gre",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "gre"},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("The rules should match all the ports", func() {
+			a, err := c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 0)
+			So(err, ShouldBeNil)
+			So(a.PolicyID, ShouldEqual, "gre")
+		})
+	})
+
+	Convey("Given an ICMP rule with an invalid code", t, func() {
+		c := NewProtocolACLCache("icmp")
+		err := c.AddRule(policy.IPRule{
+			Address:  "10.0.0.0/8",
+			Protocol: "icmp",
+			ICMPType: "3",
+			ICMPCode: "300",
+			Policy:   &policy.FlowPolicy{Action: policy.Accept},
+		})
+
+		Convey("I should get an error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestIPv6Lookup(t *testing.T) {
+
+	Convey("Given a DB with IPv4 and IPv6 rules", t, func() {
+		c := NewACLCache()
+		err := c.AddRuleList(policy.IPRuleList{
+			policy.IPRule{
+				Address:  "2001:db8::/32",
+				Port:     "80",
+				Protocol: "tcp",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: "v6"},
+			},
+			policy.IPRule{
+				Address:  "2001:db8:1::1",
+				Port:     "443",
+				Protocol: "tcp",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Reject,
+					PolicyID: "v6host"},
+			},
+			policy.IPRule{
+				Address:  "10.0.0.0/8",
+				Port:     "80",
+				Protocol: "tcp",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: "v4"},
+			},
+		})
+		So(err, ShouldBeNil)
+		So(len(c.prefixMap), ShouldEqual, 1)
+		So(len(c.prefixMapV6), ShouldEqual, 2)
+
+		Convey("When I lookup an address in the IPv6 subnet, I should get the right action", func() {
+			a, err := c.GetMatchingAction(net.ParseIP("2001:db8:ffff::10"), 80)
+			So(err, ShouldBeNil)
+			So(a.PolicyID, ShouldEqual, "v6")
+		})
+
+		Convey("When I lookup the IPv6 host address, I should get the right action", func() {
+			a, err := c.GetMatchingAction(net.ParseIP("2001:db8:1::1"), 443)
+			So(err, ShouldBeNil)
+			So(a.Action, ShouldEqual, policy.Reject)
+			So(a.PolicyID, ShouldEqual, "v6host")
+		})
+
+		Convey("When I lookup an IPv6 address outside the subnets, I should get reject", func() {
+			a, err := c.GetMatchingAction(net.ParseIP("2001:db9::1"), 80)
+			So(err, ShouldNotBeNil)
+			So(a.Action, ShouldEqual, policy.Reject)
+		})
+
+		Convey("When I lookup an IPv4 address in its 16 byte form, I should match the IPv4 rules", func() {
+			a, err := c.GetMatchingAction(net.ParseIP("10.1.1.1"), 80)
+			So(err, ShouldBeNil)
+			So(a.PolicyID, ShouldEqual, "v4")
+		})
+	})
+
+	Convey("Given a DB with overlapping IPv6 prefixes", t, func() {
+		c := NewACLCache()
+		err := c.AddRuleList(policy.IPRuleList{
+			policy.IPRule{
+				Address:  "::/0",
+				Port:     "80",
+				Protocol: "tcp",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: "all"},
+			},
+			policy.IPRule{
+				Address:  "2001:db8:1::/48",
+				Port:     "80",
+				Protocol: "tcp",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: "site"},
+			},
+			policy.IPRule{
+				Address:  "2001:db8::/32",
+				Port:     "80",
+				Protocol: "tcp",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Reject,
+					PolicyID: "doc"},
+			},
+		})
+		So(err, ShouldBeNil)
+		So(c.prefixLensV6, ShouldResemble, []int{48, 32, 0})
+
+		Convey("When I lookup an address in several prefixes, the longest prefix should always win", func() {
+			for i := 0; i < 100; i++ {
+				a, err := c.GetMatchingAction(net.ParseIP("2001:db8:2::1"), 80)
+				So(err, ShouldBeNil)
+				So(a.Action, ShouldEqual, policy.Reject)
+				So(a.PolicyID, ShouldEqual, "doc")
+
+				a, err = c.GetMatchingAction(net.ParseIP("2001:db8:1::1"), 80)
+				So(err, ShouldBeNil)
+				So(a.PolicyID, ShouldEqual, "site")
+
+				a, err = c.GetMatchingAction(net.ParseIP("2001:db9::1"), 80)
+				So(err, ShouldBeNil)
+				So(a.PolicyID, ShouldEqual, "all")
+			}
+		})
+	})
+
+	Convey("Given an IPv6 rule with a bad prefix", t, func() {
+		c := NewACLCache()
+		err := c.AddRule(policy.IPRule{
+			Address:  "2001:db8::/129",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy:   &policy.FlowPolicy{Action: policy.Accept},
+		})
+
+		Convey("I should get an error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestGetMatchingRule(t *testing.T) {
+
+	Convey("Given a good DB", t, func() {
+		c := NewACLCache()
+		err := c.AddRuleList(rules)
+		So(err, ShouldBeNil)
+
+		Convey("When I lookup for a matching address and port, I should get the rule", func() {
+			r, err := c.GetMatchingRule(net.ParseIP("192.168.100.1").To4(), 80)
+			So(err, ShouldBeNil)
+			So(r.Address, ShouldEqual, "192.168.100.0/24")
+			So(r.Port, ShouldEqual, "80")
+			So(r.Policy.PolicyID, ShouldEqual, "2")
+		})
+
+		Convey("When I lookup for a non matching address, I should get an error", func() {
+			r, err := c.GetMatchingRule(net.ParseIP("192.168.200.1").To4(), 80)
+			So(err, ShouldNotBeNil)
+			So(r, ShouldBeNil)
+		})
+
+		Convey("When I lookup for a UDP only port, I should get an error", func() {
+			_, err := c.GetMatchingRule(net.ParseIP("10.10.10.10").To4(), 443)
+			So(err, ShouldBeNil)
+			_, err = c.GetMatchingRule(net.ParseIP("10.10.10.10").To4(), 53)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestScheduledLookup(t *testing.T) {
+
+	Convey("Given a DB with a rule that has expired", t, func() {
+		c := NewACLCache()
+		err := c.AddRuleList(policy.IPRuleList{
+			policy.IPRule{
+				Address:  "10.1.0.0/16",
+				Protocol: "tcp",
+				Port:     "80",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: "expired",
+					Schedule: &policy.Schedule{NotAfter: time.Now().Add(-time.Hour)},
+				},
+			},
+			policy.IPRule{
+				Address:  "10.1.1.0/24",
+				Protocol: "tcp",
+				Port:     "443",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: "active",
+					Schedule: &policy.Schedule{NotBefore: time.Now().Add(-time.Hour)},
+				},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("When I lookup a flow matching the expired rule, it should be rejected with an expired error", func() {
+			p, err := c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 80)
+			So(err, ShouldHaveSameTypeAs, &ExpiredError{})
+			So(p.Action, ShouldEqual, policy.Reject)
+			So(p.PolicyID, ShouldEqual, "expired")
+		})
+
+		Convey("When I lookup a flow matching the active rule, it should be accepted", func() {
+			p, err := c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 443)
+			So(err, ShouldBeNil)
+			So(p.PolicyID, ShouldEqual, "active")
+		})
+
+		Convey("When I lookup a flow matching no rule, I should get the default policy", func() {
+			p, err := c.GetMatchingAction(net.ParseIP("10.2.1.1").To4(), 80)
+			So(err, ShouldNotBeNil)
+			So(err, ShouldNotHaveSameTypeAs, &ExpiredError{})
+			So(p.PolicyID, ShouldEqual, "default")
+		})
+	})
+}
+
+func TestFQDNLookup(t *testing.T) {
+
+	Convey("Given a DB with a rule on a DNS name", t, func() {
+		c := NewACLCache()
+		err := c.AddRuleList(policy.IPRuleList{
+			policy.IPRule{
+				Address:  "10.1.1.0/24",
+				Protocol: "tcp",
+				Port:     "80",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: "network"},
+			},
+			policy.IPRule{
+				Address:  "API.example.com",
+				Protocol: "tcp",
+				Port:     "443",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: "fqdn"},
+			},
+		})
+		So(err, ShouldBeNil)
+		So(c.FQDNs(), ShouldResemble, []string{"api.example.com"})
+
+		Convey("When the name has no addresses, the rule should not match", func() {
+			_, err := c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 443)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the addresses of the name are set", func() {
+			c.SetAddresses("api.example.com", []net.IP{net.ParseIP("10.1.1.1"), net.ParseIP("2001:db8::1")})
+
+			Convey("The rule should match the addresses of the name", func() {
+				p, err := c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 443)
+				So(err, ShouldBeNil)
+				So(p.PolicyID, ShouldEqual, "fqdn")
+
+				r, err := c.GetMatchingRule(net.ParseIP("2001:db8::1"), 443)
+				So(err, ShouldBeNil)
+				So(r.FQDN(), ShouldEqual, "api.example.com")
+
+				p, err = c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 80)
+				So(err, ShouldBeNil)
+				So(p.PolicyID, ShouldEqual, "network")
+			})
+
+			Convey("When the addresses of the name change, the rule should follow them", func() {
+				c.SetAddresses("api.example.com", []net.IP{net.ParseIP("10.1.1.2")})
+
+				_, err := c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 443)
+				So(err, ShouldNotBeNil)
+				_, err = c.GetMatchingAction(net.ParseIP("2001:db8::1"), 443)
+				So(err, ShouldNotBeNil)
+				p, err := c.GetMatchingAction(net.ParseIP("10.1.1.2").To4(), 443)
+				So(err, ShouldBeNil)
+				So(p.PolicyID, ShouldEqual, "fqdn")
+			})
+		})
+	})
+}
@@ -0,0 +1,126 @@
+package enforcer
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuditMode(t *testing.T) {
+
+	Convey("Given I create an enforcer with a processing unit in audit mode that rejects the other one", t, func() {
+
+		rules := policy.TagSelectorList{
+			{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Reject, PolicyID: "reject"},
+			},
+		}
+
+		netACLs := policy.IPRuleList{
+			{
+				Address:  "192.168.0.0/16",
+				Port:     "80",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject, PolicyID: "acl"},
+			},
+		}
+
+		recorder := &flowRecorder{}
+		enforcer, err1, err2 := setupTestProcessingUnits(recorder, rules, netACLs)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		capture := &packetCapture{}
+		enforcer.packetWriter = capture
+
+		puID2 := "SomeTestProcessingUnitId" + strconv.Itoa(iteration) + "2"
+		ctx, err := enforcer.contextTracker.Get(puID2)
+		So(err, ShouldBeNil)
+		context := ctx.(*PUContext)
+		context.Audit = true
+		context.RejectAction = policy.RejectReset
+
+		Convey("When I complete the handshake of a rejected connection", func() {
+
+			_, _, err := transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1000, 0, true, nil))
+			So(err, ShouldBeNil)
+
+			_, _, err = transmitTCPPacket(enforcer, createTCPTestPacket(testIP2, testIP1, 80, 2000, 5000, 1001, true, nil))
+			So(err, ShouldBeNil)
+
+			_, _, err = transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1001, 5001, false, nil))
+			So(err, ShouldBeNil)
+
+			Convey("Then the connection should be reported once as observed with its drop reason", func() {
+				So(recorder.flows, ShouldHaveLength, 1)
+
+				observed := recorder.flows[0]
+				So(observed.Observed, ShouldBeTrue)
+				So(observed.Action, ShouldEqual, policy.Reject)
+				So(observed.DropReason, ShouldEqual, collector.PolicyDrop)
+				So(observed.PolicyID, ShouldEqual, "reject")
+				So(observed.Destination.ID, ShouldEqual, puID2)
+			})
+
+			Convey("Then no reset should be sent", func() {
+				So(capture.packets, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a Syn from an external network is rejected by the ACLs, it should be accepted and reported as observed", func() {
+			err := enforcer.processNetworkTCPPackets(createTCPTestPacket("192.168.1.1", testIP2, 2000, 80, 1000, 0, true, nil))
+			So(err, ShouldBeNil)
+			So(capture.packets, ShouldBeEmpty)
+
+			So(recorder.flows, ShouldHaveLength, 1)
+			So(recorder.flows[0].Observed, ShouldBeTrue)
+			So(recorder.flows[0].Action, ShouldEqual, policy.Reject)
+			So(recorder.flows[0].PolicyID, ShouldEqual, "acl")
+		})
+
+		Convey("When I send a rejected UDP request and reply, the flow should be authorized and reported as observed", func() {
+			request := createUDPTestPacket(testIP1, testIP2, 12345, 53, []byte("request"))
+			So(enforcer.processApplicationUDPPackets(request), ShouldBeNil)
+			So(enforcer.processNetworkUDPPackets(request), ShouldBeNil)
+			So(string(request.ReadUDPData()), ShouldEqual, "request")
+
+			reply := createUDPTestPacket(testIP2, testIP1, 53, 12345, []byte("reply"))
+			So(enforcer.processApplicationUDPPackets(reply), ShouldBeNil)
+			So(enforcer.processNetworkUDPPackets(reply), ShouldBeNil)
+
+			_, conn, err := enforcer.appUDPRetrieveState(createUDPTestPacket(testIP1, testIP2, 12345, 53, []byte("data")))
+			So(err, ShouldBeNil)
+			So(conn.GetState(), ShouldEqual, UDPData)
+
+			So(recorder.flows, ShouldHaveLength, 1)
+			So(recorder.flows[0].Observed, ShouldBeTrue)
+			So(recorder.flows[0].PolicyID, ShouldEqual, "reject")
+		})
+
+		Convey("When a Syn with an invalid token is received, it should still be dropped", func() {
+			syn := createTCPTestPacket(testIP1, testIP2, 2000, 80, 1000, 0, true, nil)
+			So(enforcer.processApplicationTCPPackets(syn), ShouldBeNil)
+
+			buffer := syn.GetBytes()
+			buffer[len(buffer)-20] ^= 0xff
+			wire, err := packet.New(0, buffer, "0")
+			So(err, ShouldBeNil)
+
+			err = enforcer.processNetworkTCPPackets(wire)
+			So(err, ShouldNotBeNil)
+			So(recorder.flows, ShouldNotBeEmpty)
+			So(recorder.flows[len(recorder.flows)-1].Observed, ShouldBeFalse)
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package enforcer
 
 import (
+	"crypto/ecdsa"
 	"fmt"
 	"sync"
 	"time"
@@ -8,6 +9,8 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/aporeto-inc/trireme/cache"
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
 	"github.com/aporeto-inc/trireme/policy"
 )
 
@@ -43,6 +46,106 @@ const (
 	TCPData
 )
 
+// UDPFlowState identifies the constants of the state of a UDP flow
+type UDPFlowState int
+
+const (
+
+	// UDPStart is the state of a new flow before any packet has been processed
+	UDPStart UDPFlowState = iota
+
+	// UDPSynSend is the state where the initiator attaches its token to the packets
+	// of the flow, but no response has been received
+	UDPSynSend
+
+	// UDPSynReceived indicates that a packet with the token of the initiator has been
+	// received and the next reply must carry the token of the responder
+	UDPSynReceived
+
+	// UDPSynAckSend indicates that the token of the responder has been send
+	UDPSynAckSend
+
+	// UDPData indicates that the negotiation has been completed and the packets
+	// of the flow are now data packets
+	UDPData
+)
+
+// String returns the name of the state
+func (s TCPFlowState) String() string {
+
+	switch s {
+	case TCPSynSend:
+		return "SynSend"
+	case TCPSynReceived:
+		return "SynReceived"
+	case TCPSynAckSend:
+		return "SynAckSend"
+	case TCPSynAckReceived:
+		return "SynAckReceived"
+	case TCPAckSend:
+		return "AckSend"
+	case TCPAckProcessed:
+		return "AckProcessed"
+	case TCPData:
+		return "Data"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(s))
+	}
+}
+
+// String returns the name of the state
+func (s UDPFlowState) String() string {
+
+	switch s {
+	case UDPStart:
+		return "Start"
+	case UDPSynSend:
+		return "SynSend"
+	case UDPSynReceived:
+		return "SynReceived"
+	case UDPSynAckSend:
+		return "SynAckSend"
+	case UDPData:
+		return "Data"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(s))
+	}
+}
+
+// flowTuple is the four-tuple of a connection in the direction of the initiator
+type flowTuple struct {
+	sourceIP        string
+	sourcePort      uint16
+	destinationIP   string
+	destinationPort uint16
+}
+
+// newFlowTuple returns the four-tuple of the packet that initiates a connection
+func newFlowTuple(p *packet.Packet) flowTuple {
+
+	return flowTuple{
+		sourceIP:        p.SourceAddress.String(),
+		sourcePort:      p.SourcePort,
+		destinationIP:   p.DestinationAddress.String(),
+		destinationPort: p.DestinationPort,
+	}
+}
+
+// connectionRecord returns the record of a connection of the given context
+func (f flowTuple) connectionRecord(contextID string, protocol uint8, outgoing bool, created time.Time) *collector.ConnectionRecord {
+
+	return &collector.ConnectionRecord{
+		ContextID:       contextID,
+		Protocol:        protocol,
+		SourceIP:        f.sourceIP,
+		SourcePort:      f.sourcePort,
+		DestinationIP:   f.destinationIP,
+		DestinationPort: f.destinationPort,
+		Outgoing:        outgoing,
+		Age:             time.Since(created),
+	}
+}
+
 const (
 
 	// RejectReported represents that flow was reported as rejected
@@ -54,12 +157,14 @@ const (
 
 // AuthInfo keeps authentication information about a connection
 type AuthInfo struct {
-	LocalContext    []byte
-	RemoteContext   []byte
-	RemoteContextID string
-	RemotePublicKey interface{}
-	RemoteIP        string
-	RemotePort      string
+	LocalContext       []byte
+	RemoteContext      []byte
+	RemoteContextID    string
+	RemotePublicKey    interface{}
+	RemoteIP           string
+	RemotePort         string
+	EphemeralKey       *ecdsa.PrivateKey
+	RemoteEphemeralKey []byte
 }
 
 // TCPConnection is information regarding TCP Connection
@@ -91,6 +196,21 @@ type TCPConnection struct {
 
 	// FlowPolicy holds the last matched policy
 	FlowPolicy *policy.FlowPolicy
+
+	// observed is set when the policy rejected the connection, but it was let
+	// through because the processing unit is in audit mode
+	observed bool
+
+	// observedFlow is the report of an observed connection, whose volume is
+	// tracked once the connection is established
+	observedFlow *collector.FlowRecord
+
+	// encryption encrypts the payload of the connection if the policy requires it
+	encryption *sessionCipher
+
+	// flow and created identify the connection in the connection records
+	flow    flowTuple
+	created time.Time
 }
 
 // TCPConnectionExpirationNotifier handles processing the expiration of an element
@@ -125,6 +245,34 @@ func (c *TCPConnection) SetState(state TCPFlowState) {
 	c.logs = append(c.logs, fmt.Sprintf("set-state: %s %d", c.String(), state))
 }
 
+// Encrypted returns true if the payload of the connection is encrypted
+func (c *TCPConnection) Encrypted() bool {
+
+	return c.encryption != nil
+}
+
+// Record returns the record of the connection for the connection list of
+// the enforcer. Outgoing must be set if the connection was initiated by the
+// processing unit of the connection.
+func (c *TCPConnection) Record(outgoing bool) *collector.ConnectionRecord {
+
+	c.Lock()
+	defer c.Unlock()
+
+	contextID := ""
+	if c.Context != nil {
+		contextID = c.Context.ID
+	}
+
+	record := c.flow.connectionRecord(contextID, packet.IPProtocolTCP, outgoing, c.created)
+	record.State = c.state.String()
+	record.RemoteContextID = c.Auth.RemoteContextID
+	record.FlowPolicy = c.FlowPolicy
+	record.Encrypted = c.encryption != nil
+
+	return record
+}
+
 // SetReported is used to track if a flow is reported
 func (c *TCPConnection) SetReported(flowState bool) {
 
@@ -193,9 +341,81 @@ func (c *TCPConnection) Cleanup(expiration bool) {
 func NewTCPConnection() *TCPConnection {
 
 	c := &TCPConnection{
-		state: TCPSynSend,
-		logs:  []string{"Initialized"},
+		state:   TCPSynSend,
+		logs:    []string{"Initialized"},
+		created: time.Now(),
 	}
 
 	return c
 }
+
+// UDPConnection is information regarding a UDP flow
+type UDPConnection struct {
+	sync.Mutex
+
+	state UDPFlowState
+	Auth  AuthInfo
+
+	// synToken is the token attached by the initiator to all packets of the
+	// flow until the token of the responder is received
+	synToken []byte
+
+	// Context is the PUContext that is associated with this flow
+	Context *PUContext
+
+	// FlowPolicy holds the last matched policy
+	FlowPolicy *policy.FlowPolicy
+
+	// flow and created identify the flow in the connection records
+	flow    flowTuple
+	created time.Time
+}
+
+// String returns a printable version of the UDP connection
+func (c *UDPConnection) String() string {
+
+	return fmt.Sprintf("state:%d auth: %+v", c.state, c.Auth)
+}
+
+// GetState is used to return the state
+func (c *UDPConnection) GetState() UDPFlowState {
+
+	return c.state
+}
+
+// SetState is used to setup the state for the UDP connection
+func (c *UDPConnection) SetState(state UDPFlowState) {
+
+	c.state = state
+}
+
+// Record returns the record of the flow for the connection list of the
+// enforcer. Outgoing must be set if the flow was initiated by the processing
+// unit of the flow.
+func (c *UDPConnection) Record(outgoing bool) *collector.ConnectionRecord {
+
+	c.Lock()
+	defer c.Unlock()
+
+	contextID := ""
+	if c.Context != nil {
+		contextID = c.Context.ID
+	}
+
+	record := c.flow.connectionRecord(contextID, packet.IPProtocolUDP, outgoing, c.created)
+	record.State = c.state.String()
+	record.RemoteContextID = c.Auth.RemoteContextID
+	record.FlowPolicy = c.FlowPolicy
+
+	return record
+}
+
+// NewUDPConnection returns a UDPConnection information struct
+func NewUDPConnection(context *PUContext) *UDPConnection {
+
+	return &UDPConnection{
+		state:   UDPStart,
+		Context: context,
+		created: time.Now(),
+	}
+}
@@ -1,12 +1,16 @@
 package enforcer
 
+import "github.com/aporeto-inc/trireme/policy"
+
 const (
 	// TCPAuthenticationOptionBaseLen specifies the length of base TCP Authentication Option packet
 	TCPAuthenticationOptionBaseLen = 4
 	// TCPAuthenticationOptionAckLen specifies the length of TCP Authentication Option in the ack packet
 	TCPAuthenticationOptionAckLen = 20
+	// EncryptionTagLength is the length of the authentication tag appended to the segments of encrypted connections
+	EncryptionTagLength = 16
 	// PortNumberLabelString is the label to use for port numbers
-	PortNumberLabelString = "$sys:port"
+	PortNumberLabelString = policy.PortLabel
 	// TransmitterLabel is the name of the label used to identify the Transmitter Context
 	TransmitterLabel = "AporetoContextID"
 	// DefaultNetwork to be used
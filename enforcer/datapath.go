@@ -3,7 +3,9 @@ package enforcer
 // Go libraries
 import (
 	"fmt"
+	"net"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,6 +17,8 @@ import (
 	"github.com/aporeto-inc/trireme/constants"
 	"github.com/aporeto-inc/trireme/enforcer/acls"
 	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqdn"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packetsource"
 	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
 	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
 	"github.com/aporeto-inc/trireme/policy"
@@ -33,6 +37,9 @@ type Datapath struct {
 	service        PacketProcessor
 	secrets        secrets.Secrets
 	nflogger       nfLogger
+	flowStats      *flowStats
+	fqdnWatcher    *fqdn.Watcher
+	fqdnUnsub      func()
 	procMountPoint string
 
 	// Internal structures and caches
@@ -55,6 +62,13 @@ type Datapath struct {
 	netOrigConnectionTracker  cache.DataStore
 	netReplyConnectionTracker cache.DataStore
 
+	// Hash on full five-tuple and return the UDP flow
+	// These are auto-expired flows after 60 seconds of inactivity.
+	udpAppOrigConnectionTracker  cache.DataStore
+	udpAppReplyConnectionTracker cache.DataStore
+	udpNetOrigConnectionTracker  cache.DataStore
+	udpNetReplyConnectionTracker cache.DataStore
+
 	// CacheTimeout used for Trireme auto-detecion
 	externalIPCacheTimeout time.Duration
 
@@ -64,9 +78,12 @@ type Datapath struct {
 	// mode captures the mode of the enforcer
 	mode constants.ModeType
 
-	// stop signals
-	netStop []chan bool
-	appStop []chan bool
+	// packet sources of the application and network packets
+	appSource packetsource.PacketSource
+	netSource packetsource.PacketSource
+
+	// packetWriter injects the packets created by the datapath
+	packetWriter packetsource.PacketWriter
 
 	// ack size
 	ackSize uint32
@@ -111,6 +128,12 @@ func New(
 			zap.L().Fatal("Failed to set conntrack options", zap.Error(err))
 		}
 
+		// Enable the conntrack counters used to report the volume of the flows
+		cmd = exec.Command(sysctlCmd, "-w", "net.netfilter.nf_conntrack_acct=1")
+		if err := cmd.Run(); err != nil {
+			zap.L().Fatal("Failed to set conntrack options", zap.Error(err))
+		}
+
 	}
 
 	tokenEngine, err := tokens.NewJWT(validity, serverID, secrets)
@@ -141,13 +164,25 @@ func New(
 		mode:                      mode,
 		procMountPoint:            procMountPoint,
 		conntrackHdl:              conntrack.NewHandle(),
+
+		udpAppOrigConnectionTracker:  cache.NewCacheWithExpiration("udpAppOrigConnectionTracker", time.Second*60),
+		udpAppReplyConnectionTracker: cache.NewCacheWithExpiration("udpAppReplyConnectionTracker", time.Second*60),
+		udpNetOrigConnectionTracker:  cache.NewCacheWithExpiration("udpNetOrigConnectionTracker", time.Second*60),
+		udpNetReplyConnectionTracker: cache.NewCacheWithExpiration("udpNetReplyConnectionTracker", time.Second*60),
 	}
 
 	if d.tokenEngine == nil {
 		zap.L().Fatal("Unable to create enforcer")
 	}
 
-	d.nflogger = newNFLogger(11, 10, d.puInfoDelegate, collector)
+	d.nflogger = newNFLogger(11, 10, d.puInfoDelegate, d.fqdnDelegate, collector)
+
+	d.flowStats = newFlowStats(DefaultFlowStatsInterval, conntrackCounterReader(d.conntrackHdl), collector)
+
+	d.fqdnWatcher = fqdn.SharedWatcher()
+
+	d.appSource, d.netSource = d.defaultPacketSources()
+	d.packetWriter = d.defaultPacketWriter()
 
 	return d
 }
@@ -188,6 +223,36 @@ func NewWithDefaults(
 	)
 }
 
+// NewWithPacketSources creates a new data path with most things used by default that
+// processes the packets of the given sources instead of the netfilter queues. The
+// NFLOG based flow logs and the conntrack based flow volumes are not collected.
+func NewWithPacketSources(
+	serverID string,
+	collector collector.EventCollector,
+	service PacketProcessor,
+	secrets secrets.Secrets,
+	mode constants.ModeType,
+	procMountPoint string,
+	appSource packetsource.PacketSource,
+	netSource packetsource.PacketSource,
+) PolicyEnforcer {
+
+	d := NewWithDefaults(serverID, collector, service, secrets, mode, procMountPoint).(*Datapath)
+
+	d.appSource = appSource
+	d.netSource = netSource
+	d.nflogger = nil
+	d.flowStats = nil
+
+	// Packets are only injected if the network source can transmit them
+	d.packetWriter = nil
+	if writer, ok := netSource.(packetsource.PacketWriter); ok {
+		d.packetWriter = writer
+	}
+
+	return d
+}
+
 // Enforce implements the Enforce interface method and configures the data path for a new PU
 func (d *Datapath) Enforce(contextID string, puInfo *policy.PUInfo) error {
 
@@ -218,6 +283,15 @@ func (d *Datapath) Unenforce(contextID string) error {
 		)
 	}
 
+	if pu.IPv6 != "" {
+		if err := d.puFromIP.Remove(pu.IPv6); err != nil {
+			zap.L().Warn("Unable to remove cache entry during unenforcement",
+				zap.String("IPv6", pu.IPv6),
+				zap.Error(err),
+			)
+		}
+	}
+
 	if err := d.puFromIP.Remove(pu.Mark); err != nil {
 		zap.L().Warn("Unable to remove cache entry during unenforcement",
 			zap.String("Mark", pu.Mark),
@@ -234,6 +308,10 @@ func (d *Datapath) Unenforce(contextID string) error {
 		}
 	}
 
+	if d.fqdnWatcher != nil {
+		d.fqdnWatcher.Unwatch(fqdnOwnerPrefix + contextID)
+	}
+
 	if err := d.contextTracker.RemoveWithDelay(contextID, 10*time.Second); err != nil {
 		zap.L().Warn("Unable to remove context from cache",
 			zap.String("contextID", contextID),
@@ -250,6 +328,57 @@ func (d *Datapath) GetFilterQueue() *fqconfig.FilterQueue {
 	return d.filterQueue
 }
 
+// Connections returns the connections of a processing unit that are currently
+// tracked by the data path
+func (d *Datapath) Connections(contextID string) ([]*collector.ConnectionRecord, error) {
+
+	if _, err := d.contextTracker.Get(contextID); err != nil {
+		return nil, fmt.Errorf("ContextID not found in Enforcer")
+	}
+
+	records := []*collector.ConnectionRecord{}
+	seen := map[interface{}]bool{}
+
+	// The original trackers hold the connections in the direction of the
+	// initiator. Connections are listed once even if they have several keys.
+	trackers := []struct {
+		tracker  cache.DataStore
+		outgoing bool
+	}{
+		{d.appOrigConnectionTracker, true},
+		{d.netOrigConnectionTracker, false},
+		{d.udpAppOrigConnectionTracker, true},
+		{d.udpNetOrigConnectionTracker, false},
+	}
+
+	for _, t := range trackers {
+		for _, key := range t.tracker.KeyList() {
+
+			item, err := t.tracker.Get(key)
+			if err != nil || seen[item] {
+				continue
+			}
+			seen[item] = true
+
+			var record *collector.ConnectionRecord
+			switch conn := item.(type) {
+			case *TCPConnection:
+				record = conn.Record(t.outgoing)
+			case *UDPConnection:
+				record = conn.Record(t.outgoing)
+			default:
+				continue
+			}
+
+			if record.ContextID == contextID {
+				records = append(records, record)
+			}
+		}
+	}
+
+	return records, nil
+}
+
 // Start starts the application and network interceptors
 func (d *Datapath) Start() error {
 
@@ -258,10 +387,22 @@ func (d *Datapath) Start() error {
 		d.service.Initialize(d.secrets, d.filterQueue)
 	}
 
-	d.startApplicationInterceptor()
-	d.startNetworkInterceptor()
+	if err := d.startInterceptors(); err != nil {
+		return err
+	}
+
+	if d.nflogger != nil {
+		go d.nflogger.start()
+	}
+
+	if d.flowStats != nil {
+		d.flowStats.start()
+	}
 
-	go d.nflogger.start()
+	if d.fqdnWatcher != nil && d.fqdnUnsub == nil {
+		d.fqdnUnsub = d.fqdnWatcher.Subscribe(d.updateFQDN)
+		d.fqdnWatcher.Start()
+	}
 
 	return nil
 }
@@ -271,15 +412,21 @@ func (d *Datapath) Stop() error {
 
 	zap.L().Debug("Stoping enforcer")
 
-	for i := uint16(0); i < d.filterQueue.GetNumApplicationQueues(); i++ {
-		d.appStop[i] <- true
+	d.stopInterceptors()
+
+	if d.nflogger != nil {
+		d.nflogger.stop()
 	}
 
-	for i := uint16(0); i < d.filterQueue.GetNumNetworkQueues(); i++ {
-		d.netStop[i] <- true
+	if d.flowStats != nil {
+		d.flowStats.stop()
 	}
 
-	d.nflogger.stop()
+	if d.fqdnUnsub != nil {
+		d.fqdnUnsub()
+		d.fqdnUnsub = nil
+		d.fqdnWatcher.Stop()
+	}
 
 	return nil
 }
@@ -325,6 +472,15 @@ func (d *Datapath) doCreatePU(contextID string, puInfo *policy.PUInfo) error {
 		} else {
 			d.puFromIP.AddOrUpdate(DefaultNetwork, pu)
 		}
+
+		// Dual-stack PUs are also found by their IPv6 address. The address
+		// is stored in the same format as the packet addresses.
+		if ip, ok := puInfo.Runtime.DefaultIPv6Address(); ok {
+			if ipv6 := net.ParseIP(ip); ipv6 != nil {
+				pu.IPv6 = ipv6.String()
+				d.puFromIP.AddOrUpdate(pu.IPv6, pu)
+			}
+		}
 	}
 
 	// Cache PU from contextID for management and policy updates
@@ -335,29 +491,100 @@ func (d *Datapath) doCreatePU(contextID string, puInfo *policy.PUInfo) error {
 
 func (d *Datapath) doUpdatePU(puContext *PUContext, containerInfo *policy.PUInfo) error {
 
+	d.watchFQDNs(puContext.ID, containerInfo.Policy)
+
 	puContext.Lock()
 	defer puContext.Unlock()
 
-	puContext.AcceptRcvRules, puContext.RejectRcvRules = createRuleDBs(containerInfo.Policy.ReceiverRules())
-
-	puContext.AcceptTxtRules, puContext.RejectTxtRules = createRuleDBs(containerInfo.Policy.TransmitterRules())
-
 	puContext.Identity = containerInfo.Policy.Identity()
 
 	puContext.Annotations = containerInfo.Policy.Annotations()
 
+	puContext.RejectAction = containerInfo.Policy.RejectAction()
+
+	puContext.Audit = containerInfo.Policy.TriremeAction() == policy.Audit
+
+	puContext.Generation = containerInfo.Policy.Generation()
+
 	puContext.externalIPCache = cache.NewCacheWithExpiration(fmt.Sprintf("externalIPCache:%s", puContext.ID), d.externalIPCacheTimeout)
 
+	if err := setPolicyRules(puContext, containerInfo.Policy); err != nil {
+		return err
+	}
+
+	d.setFQDNAddresses(puContext)
+
+	return nil
+}
+
+// setPolicyRules creates the rule databases and the ACL caches of the context
+// from the policy
+func setPolicyRules(puContext *PUContext, puPolicy *policy.PUPolicy) error {
+
+	puContext.AcceptRcvRules, puContext.RejectRcvRules = createRuleDBs(puPolicy.Namespace(), puPolicy.ReceiverRules())
+
+	puContext.AcceptTxtRules, puContext.RejectTxtRules = createRuleDBs(puPolicy.Namespace(), puPolicy.TransmitterRules())
+
 	puContext.ApplicationACLs = acls.NewACLCache()
-	if err := puContext.ApplicationACLs.AddRuleList(containerInfo.Policy.ApplicationACLs()); err != nil {
+	if err := puContext.ApplicationACLs.AddRuleList(puPolicy.ApplicationACLs()); err != nil {
 		return err
 	}
 
 	puContext.NetworkACLS = acls.NewACLCache()
-	return puContext.NetworkACLS.AddRuleList(containerInfo.Policy.NetworkACLs())
+	if err := puContext.NetworkACLS.AddRuleList(puPolicy.NetworkACLs()); err != nil {
+		return err
+	}
+
+	puContext.UDPApplicationACLs = acls.NewProtocolACLCache("udp")
+	if err := puContext.UDPApplicationACLs.AddRuleList(puPolicy.ApplicationACLs()); err != nil {
+		return err
+	}
+
+	puContext.UDPNetworkACLs = acls.NewProtocolACLCache("udp")
+	if err := puContext.UDPNetworkACLs.AddRuleList(puPolicy.NetworkACLs()); err != nil {
+		return err
+	}
+
+	var err error
+	if puContext.ProtocolApplicationACLs, err = protocolACLCaches(puPolicy.ApplicationACLs()); err != nil {
+		return err
+	}
+
+	puContext.ProtocolNetworkACLs, err = protocolACLCaches(puPolicy.NetworkACLs())
+	return err
+}
+
+// protocolACLCaches creates an ACL cache for each protocol of the rules other
+// than TCP and UDP, by protocol number
+func protocolACLCaches(rules policy.IPRuleList) (map[uint8]*acls.ACLCache, error) {
+
+	caches := map[uint8]*acls.ACLCache{}
+
+	for _, rule := range rules {
+		number, err := policy.ProtocolNumber(rule.Protocol)
+		if err != nil {
+			return nil, err
+		}
+
+		if number == policy.ProtocolTCP || number == policy.ProtocolUDP {
+			continue
+		}
+
+		if _, ok := caches[number]; ok {
+			continue
+		}
+
+		cache := acls.NewProtocolACLCache(strconv.Itoa(int(number)))
+		if err := cache.AddRuleList(rules); err != nil {
+			return nil, err
+		}
+		caches[number] = cache
+	}
+
+	return caches, nil
 }
 
-func (d *Datapath) puInfoDelegate(contextID string) (ID string, tags *policy.TagStore) {
+func (d *Datapath) puInfoDelegate(contextID string) (ID string, tags *policy.TagStore, generation uint64) {
 
 	item, err := d.contextTracker.Get(contextID)
 	if err != nil {
@@ -368,6 +595,7 @@ func (d *Datapath) puInfoDelegate(contextID string) (ID string, tags *policy.Tag
 	ctx.Lock()
 	ID = ctx.ManagementID
 	tags = ctx.Annotations.Copy()
+	generation = ctx.Generation
 	ctx.Unlock()
 
 	return
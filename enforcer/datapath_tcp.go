@@ -3,6 +3,7 @@ package enforcer
 // Go libraries
 import (
 	"bytes"
+	"crypto/elliptic"
 	"fmt"
 	"strconv"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"github.com/aporeto-inc/trireme/cache"
 	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/crypto"
 	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
 	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
 	"github.com/aporeto-inc/trireme/log"
@@ -23,6 +25,10 @@ import (
 
 const (
 	portEntryTimeout = 60
+
+	// encryptedConnectionTimeout is the idle timeout of encrypted connections. Their
+	// packets are never released to the kernel and the state must be kept.
+	encryptedConnectionTimeout = time.Minute * 30
 )
 
 // processNetworkPackets processes packets arriving from network and are destined to the application
@@ -419,7 +425,8 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 		// If its not a service connection, we release it to the kernel. Subsequent
 		// packets after the first data packet, that might be already in the queue
 		// will be transmitted through the kernel directly. Service connections are
-		// delegated to the service module
+		// delegated to the service module. Encrypted connections are never released,
+		// their mark keeps all their packets in the queues.
 		if !conn.ServiceConnection && tcpPacket.SourceAddress.String() != tcpPacket.DestinationAddress.String() {
 			if err := d.conntrackHdl.ConntrackTableUpdateMark(
 				tcpPacket.SourceAddress.String(),
@@ -427,7 +434,7 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 				tcpPacket.IPProto,
 				tcpPacket.SourcePort,
 				tcpPacket.DestinationPort,
-				constants.DefaultConnMark,
+				connectionMark(conn),
 			); err != nil {
 				zap.L().Error("Failed to update conntrack table for flow",
 					zap.String("context", string(conn.Auth.LocalContext)),
@@ -442,7 +449,7 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 
 	// If we are already in the TCPData connection just forward the packet
 	if conn.GetState() == TCPData {
-		return nil, nil
+		return nil, d.encryptTCPPayload(tcpPacket, conn)
 	}
 
 	// Here we capture the first data packet after an ACK packet by modyfing the
@@ -450,7 +457,7 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 	// We will let the caches expire.
 	if conn.GetState() == TCPAckSend {
 		conn.SetState(TCPData)
-		return nil, nil
+		return nil, d.encryptTCPPayload(tcpPacket, conn)
 	}
 
 	return nil, fmt.Errorf("Received application ACK packet in the wrong state! %v", conn.GetState())
@@ -491,9 +498,10 @@ func (d *Datapath) processNetworkSynPacket(context *PUContext, conn *TCPConnecti
 	if err = tcpPacket.CheckTCPAuthenticationOption(TCPAuthenticationOptionBaseLen); err != nil {
 
 		// If there is no auth option, attempt the ACLs
-		plc, perr := context.NetworkACLS.GetMatchingAction(tcpPacket.SourceAddress.To4(), tcpPacket.DestinationPort)
-		d.reportExternalServiceFlow(context, plc, false, tcpPacket)
-		if perr != nil || plc.Action == policy.Reject {
+		plc, perr := context.NetworkACLS.GetMatchingAction(tcpPacket.SourceAddress, tcpPacket.DestinationPort)
+		d.reportExternalServiceFlow(context, plc, false, tcpPacket, aclDropReason(perr))
+		if (perr != nil || plc.Action == policy.Reject) && !context.Audit {
+			d.rejectConnection(context, tcpPacket, true)
 			return nil, nil, fmt.Errorf("No Auth or ACLS - drop outgoing connection ")
 		}
 
@@ -531,20 +539,45 @@ func (d *Datapath) processNetworkSynPacket(context *PUContext, conn *TCPConnecti
 
 	tcpPacket.DropDetachedBytes()
 
-	// Add the port as a label with an @ prefix. These labels are invalid otherwise
-	// If all policies are restricted by port numbers this will allow port-specific policies
-	claims.T.AppendKeyValue(PortNumberLabelString, strconv.Itoa(int(tcpPacket.DestinationPort)))
+	// Add the port and the protocol as labels with a $sys prefix. These labels are
+	// invalid otherwise. This allows port and protocol specific policies
+	addFlowLabels(claims.T, "tcp", tcpPacket.DestinationPort)
 
-	// Validate against reject rules first - We always process reject with higher priority
-	if index, plc := context.RejectRcvRules.Search(claims.T); index >= 0 {
+	// Validate against reject rules first - They win unless an accept rule has a higher priority
+	if index, plc := searchRejectRules(context.RejectRcvRules, context.AcceptRcvRules, claims.T); index >= 0 {
 		// Reject the connection
-		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.PolicyDrop, plc.(*policy.FlowPolicy))
+		record := d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.PolicyDrop, plc.(*policy.FlowPolicy))
+		if context.Audit {
+			d.observeNetworkSynPacket(conn, tcpPacket, plc.(*policy.FlowPolicy), record)
+			return plc, claims, nil
+		}
+		d.rejectConnection(context, tcpPacket, false)
 		return nil, nil, fmt.Errorf("Connection rejected because of policy %+v", claims.T)
 	}
 
 	// Search the policy rules for a matching rule.
 	if index, action := context.AcceptRcvRules.Search(claims.T); index >= 0 {
 
+		flowPolicy := action.(*policy.FlowPolicy)
+
+		// Encrypted flows require the ephemeral key of the transmitter. We create
+		// our own ephemeral key that will be transmitted in the SynAck packet.
+		if flowPolicy.Action.Encrypted() {
+			if err := d.acceptEncryption(conn, claims); err != nil {
+				record := d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.PolicyDrop, nil)
+				if context.Audit {
+					// The connection proceeds without encryption
+					d.observeNetworkSynPacket(conn, tcpPacket, flowPolicy, record)
+					return action, claims, nil
+				}
+				d.rejectConnection(context, tcpPacket, false)
+				return nil, nil, fmt.Errorf("Syn packet dropped because encryption failed: %s", err)
+			}
+
+			// Make room for the authentication tag in the segments of the receiver
+			tcpPacket.DecreaseTCPMSS(EncryptionTagLength)
+		}
+
 		hash := tcpPacket.L4FlowHash()
 		// Update the connection state and store the Nonse send to us by the host.
 		// We use the nonse in the subsequent packets to achieve randomization.
@@ -555,16 +588,77 @@ func (d *Datapath) processNetworkSynPacket(context *PUContext, conn *TCPConnecti
 		d.appReplyConnectionTracker.AddOrUpdate(tcpPacket.L4ReverseFlowHash(), conn)
 
 		// Cache the action
-		conn.FlowPolicy = action.(*policy.FlowPolicy)
+		conn.FlowPolicy = flowPolicy
 
 		// Accept the connection
 		return action, claims, nil
 	}
 
-	d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.PolicyDrop, nil)
+	reason, plc := rejectReason(context.AcceptRcvRules, claims.T)
+	record := d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, reason, plc)
+	if context.Audit {
+		if plc == nil {
+			plc = &policy.FlowPolicy{Action: policy.Reject}
+		}
+		d.observeNetworkSynPacket(conn, tcpPacket, plc, record)
+		return plc, claims, nil
+	}
+	d.rejectConnection(context, tcpPacket, false)
 	return nil, nil, fmt.Errorf("No matched tags - reject %+v", claims.T)
 }
 
+// observeNetworkSynPacket lets a Syn packet rejected by the policy of a PU in
+// audit mode through. The handshake proceeds with the flow policy that matched,
+// and the connection is not reported again when it is established since the
+// rejection was already reported. The volume of the reported flow is tracked
+// once the connection is established.
+func (d *Datapath) observeNetworkSynPacket(conn *TCPConnection, tcpPacket *packet.Packet, flowPolicy *policy.FlowPolicy, record *collector.FlowRecord) {
+
+	conn.observed = true
+	conn.observedFlow = record
+	conn.FlowPolicy = flowPolicy
+	conn.SetState(TCPSynReceived)
+
+	d.netOrigConnectionTracker.AddOrUpdate(tcpPacket.L4FlowHash(), conn)
+	d.appReplyConnectionTracker.AddOrUpdate(tcpPacket.L4ReverseFlowHash(), conn)
+}
+
+// rejectConnection terminates a connection rejected by the policy of the context
+// according to its reject action. The initiator receives a TCP RST, or an ICMP
+// administratively prohibited error for external flows if configured. The
+// Syn packet is dropped in all cases.
+func (d *Datapath) rejectConnection(context *PUContext, tcpPacket *packet.Packet, external bool) {
+
+	if context.RejectAction == policy.RejectDrop || d.packetWriter == nil {
+		return
+	}
+
+	var reply []byte
+	var err error
+
+	if external && context.RejectAction == policy.RejectResetICMP {
+		reply, err = tcpPacket.ICMPProhibitedReply()
+	} else {
+		reply, err = tcpPacket.TCPResetReply()
+	}
+
+	if err != nil {
+		zap.L().Debug("Unable to create reject packet",
+			zap.String("flow", tcpPacket.L4FlowHash()),
+			zap.Error(err),
+		)
+		return
+	}
+
+	if err := d.packetWriter.WritePacket(reply); err != nil {
+		zap.L().Warn("Unable to send reject packet",
+			zap.String("contextID", context.ID),
+			zap.String("flow", tcpPacket.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+}
+
 // processNetworkSynAckPacket processes a SynAck packet arriving from the network
 func (d *Datapath) processNetworkSynAckPacket(context *PUContext, conn *TCPConnection, tcpPacket *packet.Packet) (action interface{}, claims *tokens.ConnectionClaims, err error) {
 
@@ -583,10 +677,17 @@ func (d *Datapath) processNetworkSynAckPacket(context *PUContext, conn *TCPConne
 		}
 
 		// Never seen this IP before, let's parse them.
-		plc, err = context.ApplicationACLs.GetMatchingAction(tcpPacket.SourceAddress.To4(), tcpPacket.SourcePort)
+		plc, err = context.ApplicationACLs.GetMatchingAction(tcpPacket.SourceAddress, tcpPacket.SourcePort)
 		if err != nil || plc.Action&policy.Reject > 0 {
-			d.reportReverseExternalServiceFlow(context, plc, true, tcpPacket)
-			return nil, nil, fmt.Errorf("No Auth or ACLs - Drop SynAck packet and connection")
+			if !context.Audit {
+				d.reportReverseExternalServiceFlow(context, plc, true, tcpPacket, aclDropReason(err))
+				return nil, nil, fmt.Errorf("No Auth or ACLs - Drop SynAck packet and connection")
+			}
+
+			// The flow is released and reported as observed
+			if err != nil {
+				plc = &policy.FlowPolicy{Action: policy.Reject, ServiceID: "default"}
+			}
 		}
 
 		// Added to the cache if we can accept it
@@ -656,15 +757,48 @@ func (d *Datapath) processNetworkSynAckPacket(context *PUContext, conn *TCPConne
 
 	tcpPacket.DropDetachedBytes()
 
+	// The destination port of the connection is the source port of the SynAck
+	addFlowLabels(claims.T, "tcp", tcpPacket.SourcePort)
+
 	// We can now verify the reverse policy. The system requires that policy
 	// is matched in both directions. We have to make this optional as it can
 	// become a very strong condition
-	if index, _ := context.RejectTxtRules.Search(claims.T); d.mutualAuthorization && index >= 0 {
+	if index, plc := searchRejectRules(context.RejectTxtRules, context.AcceptTxtRules, claims.T); d.mutualAuthorization && index >= 0 {
 		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, collector.PolicyDrop, nil)
-		return nil, nil, fmt.Errorf("Dropping because of reject rule on transmitter")
+		if !context.Audit {
+			return nil, nil, fmt.Errorf("Dropping because of reject rule on transmitter")
+		}
+		d.observeNetworkSynAckPacket(conn, tcpPacket, plc.(*policy.FlowPolicy))
+		return plc, claims, nil
 	}
 
 	if index, action := context.AcceptTxtRules.Search(claims.T); !d.mutualAuthorization || index >= 0 {
+
+		// The receiver requests encryption by transmitting its ephemeral key. We
+		// must also drop the connection if our policy requires encryption and the
+		// receiver didn't agree to it. In audit mode the connection proceeds
+		// without encryption.
+		if len(claims.EK) > 0 {
+			if err := d.createSessionCipher(conn, claims.EK, true, tcpPacket.TCPAck-1, tcpPacket.TCPSeq); err != nil {
+				d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, collector.PolicyDrop, nil)
+				if !context.Audit {
+					return nil, nil, fmt.Errorf("SynAck packet dropped because encryption failed: %s", err)
+				}
+			} else {
+				// Make room for the authentication tag in the segments of the transmitter
+				tcpPacket.DecreaseTCPMSS(EncryptionTagLength)
+			}
+		} else if index >= 0 && action.(*policy.FlowPolicy).Action.Encrypted() {
+			d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, collector.PolicyDrop, nil)
+			if !context.Audit {
+				return nil, nil, fmt.Errorf("SynAck packet dropped because the receiver didn't accept encryption")
+			}
+		}
+
+		if index >= 0 {
+			conn.FlowPolicy = action.(*policy.FlowPolicy)
+		}
+
 		conn.SetState(TCPSynAckReceived)
 
 		// conntrack
@@ -672,15 +806,35 @@ func (d *Datapath) processNetworkSynAckPacket(context *PUContext, conn *TCPConne
 		return action, claims, nil
 	}
 
-	d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, collector.PolicyDrop, nil)
+	reason, plc := rejectReason(context.AcceptTxtRules, claims.T)
+	d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, conn.Auth.RemoteContextID, context, reason, plc)
+	if context.Audit {
+		if plc == nil {
+			plc = &policy.FlowPolicy{Action: policy.Reject}
+		}
+		d.observeNetworkSynAckPacket(conn, tcpPacket, plc)
+		return plc, claims, nil
+	}
 	return nil, nil, fmt.Errorf("Dropping packet SYNACK at the network ")
 }
 
+// observeNetworkSynAckPacket lets a SynAck packet rejected by the transmitter
+// rules of a PU in audit mode through. The handshake proceeds with the flow
+// policy that matched.
+func (d *Datapath) observeNetworkSynAckPacket(conn *TCPConnection, tcpPacket *packet.Packet, flowPolicy *policy.FlowPolicy) {
+
+	conn.observed = true
+	conn.FlowPolicy = flowPolicy
+	conn.SetState(TCPSynAckReceived)
+
+	d.netReplyConnectionTracker.AddOrUpdate(tcpPacket.L4FlowHash(), conn)
+}
+
 // processNetworkAckPacket processes an Ack packet arriving from the network
 func (d *Datapath) processNetworkAckPacket(context *PUContext, conn *TCPConnection, tcpPacket *packet.Packet) (action interface{}, claims *tokens.ConnectionClaims, err error) {
 
 	if conn.GetState() == TCPData || conn.GetState() == TCPAckSend {
-		return nil, nil, nil
+		return nil, nil, d.decryptTCPPayload(tcpPacket, conn)
 	}
 
 	context.Lock()
@@ -709,8 +863,22 @@ func (d *Datapath) processNetworkAckPacket(context *PUContext, conn *TCPConnecti
 
 		tcpPacket.DropDetachedBytes()
 
-		// We accept the packet as a new flow
-		d.reportAcceptedFlow(tcpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID, context, conn.FlowPolicy)
+		// The session key can only be derived once we know the nonce that the
+		// transmitter has accepted
+		if conn.Auth.EphemeralKey != nil {
+			if err := d.createSessionCipher(conn, conn.Auth.RemoteEphemeralKey, false, tcpPacket.TCPSeq-1, tcpPacket.TCPAck-1); err != nil {
+				d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID, context, collector.InvalidFormat, nil)
+				return nil, nil, fmt.Errorf("Ack packet dropped because encryption failed: %s", err)
+			}
+		}
+
+		// We accept the packet as a new flow. Observed connections were already
+		// reported when the policy rejected them.
+		if !conn.observed {
+			d.reportAcceptedFlow(tcpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID, context, conn.FlowPolicy)
+		} else {
+			d.trackFlow(conn.observedFlow, tcpPacket)
+		}
 
 		conn.SetState(TCPData)
 
@@ -721,7 +889,7 @@ func (d *Datapath) processNetworkAckPacket(context *PUContext, conn *TCPConnecti
 				tcpPacket.IPProto,
 				tcpPacket.SourcePort,
 				tcpPacket.DestinationPort,
-				constants.DefaultConnMark,
+				connectionMark(conn),
 			); err != nil {
 				zap.L().Error("Failed to update conntrack table after ack packet")
 			}
@@ -769,27 +937,38 @@ func (d *Datapath) createSynPacketToken(context *PUContext, auth *AuthInfo) (tok
 		// Randomize the nonce and send it
 		auth.LocalContext, err = d.tokenEngine.Randomize(context.synToken)
 		if err == nil {
+			auth.EphemeralKey = context.synEphemeralKey
 			return context.synToken, nil
 		}
 		// If there is an error, let's try to create a new one
 	}
 
+	// The ephemeral key is always offered, since the receiver decides if the
+	// flow must be encrypted. It is cached together with the token.
+	ephemeralKey, ek := crypto.CreateEphemeralKey(elliptic.P256, nil)
+
 	claims := &tokens.ConnectionClaims{
-		T: context.Identity,
+		T:  context.Identity,
+		EK: ek,
 	}
 
 	if context.synToken, auth.LocalContext, err = d.tokenEngine.CreateAndSign(false, claims); err != nil {
 		return []byte{}, nil
 	}
 
+	context.synEphemeralKey = ephemeralKey
 	context.synExpiration = time.Now().Add(time.Millisecond * 500)
 
+	auth.EphemeralKey = ephemeralKey
+
 	return context.synToken, nil
 
 }
 
 // createSynAckPacketToken  creates the authentication token for SynAck packets
-// We need to sign the received token. No caching possible here
+// We need to sign the received token. No caching possible here. The token must
+// not replace the cached Syn token since it carries the ephemeral key of the
+// connection if the flow is encrypted.
 func (d *Datapath) createSynAckPacketToken(context *PUContext, auth *AuthInfo) (token []byte, err error) {
 
 	claims := &tokens.ConnectionClaims{
@@ -797,14 +976,103 @@ func (d *Datapath) createSynAckPacketToken(context *PUContext, auth *AuthInfo) (
 		RMT: auth.RemoteContext,
 	}
 
-	if context.synToken, auth.LocalContext, err = d.tokenEngine.CreateAndSign(false, claims); err != nil {
+	if auth.EphemeralKey != nil {
+		claims.EK = elliptic.Marshal(auth.EphemeralKey.Curve, auth.EphemeralKey.X, auth.EphemeralKey.Y)
+	}
+
+	if token, auth.LocalContext, err = d.tokenEngine.CreateAndSign(false, claims); err != nil {
 		return []byte{}, nil
 	}
 
-	return context.synToken, nil
+	return token, nil
 
 }
 
+// acceptEncryption creates the ephemeral key of the receiver of an encrypted
+// flow. It fails if the transmitter didn't provide its ephemeral key.
+func (d *Datapath) acceptEncryption(conn *TCPConnection, claims *tokens.ConnectionClaims) error {
+
+	if len(claims.EK) == 0 {
+		return fmt.Errorf("No ephemeral key provided by the transmitter")
+	}
+
+	ephemeralKey, _ := crypto.CreateEphemeralKey(elliptic.P256, nil)
+	if ephemeralKey == nil {
+		return fmt.Errorf("Unable to create ephemeral key")
+	}
+
+	conn.Auth.EphemeralKey = ephemeralKey
+	conn.Auth.RemoteEphemeralKey = claims.EK
+
+	return nil
+}
+
+// createSessionCipher derives the session key of an encrypted connection from the
+// ephemeral keys and the nonces of the handshake. The initial sequence numbers
+// of the initiator and the responder position the data in the encrypted stream.
+func (d *Datapath) createSessionCipher(conn *TCPConnection, remoteEphemeralKey []byte, initiator bool, initiatorISN uint32, responderISN uint32) error {
+
+	sharedKey, err := crypto.ComputeSharedKey(conn.Auth.EphemeralKey, remoteEphemeralKey)
+	if err != nil {
+		return err
+	}
+
+	initiatorNonce, responderNonce := conn.Auth.RemoteContext, conn.Auth.LocalContext
+	if initiator {
+		initiatorNonce, responderNonce = conn.Auth.LocalContext, conn.Auth.RemoteContext
+	}
+
+	encryption, err := newSessionCipher(sharedKey, initiatorNonce, responderNonce, initiatorISN, responderISN, initiator)
+	if err != nil {
+		return err
+	}
+
+	conn.Auth.RemoteEphemeralKey = remoteEphemeralKey
+	conn.encryption = encryption
+
+	if conn.TimeOut == 0 {
+		conn.TimeOut = encryptedConnectionTimeout
+	}
+
+	return nil
+}
+
+// encryptTCPPayload encrypts the payload of an application packet of an encrypted
+// connection and appends the authentication tag
+func (d *Datapath) encryptTCPPayload(tcpPacket *packet.Packet, conn *TCPConnection) error {
+
+	if !conn.Encrypted() || tcpPacket.IsEmptyTCPPayload() {
+		return nil
+	}
+
+	tag := conn.encryption.encrypt(tcpPacket.TCPSeq, tcpPacket.ReadTCPData())
+
+	return tcpPacket.TCPDataAttach([]byte{}, tag)
+}
+
+// decryptTCPPayload validates the authentication tag of a network packet of an
+// encrypted connection, removes it and decrypts the payload
+func (d *Datapath) decryptTCPPayload(tcpPacket *packet.Packet, conn *TCPConnection) error {
+
+	if !conn.Encrypted() || tcpPacket.IsEmptyTCPPayload() {
+		return nil
+	}
+
+	data := tcpPacket.ReadTCPData()
+	if len(data) < EncryptionTagLength {
+		return fmt.Errorf("Encrypted packet dropped because of missing tag")
+	}
+
+	payload := data[:len(data)-EncryptionTagLength]
+	tag := data[len(data)-EncryptionTagLength:]
+
+	if err := conn.encryption.decrypt(tcpPacket.TCPSeq, payload, tag); err != nil {
+		return fmt.Errorf("Encrypted packet dropped: %s", err)
+	}
+
+	return tcpPacket.TCPDataTrim(EncryptionTagLength)
+}
+
 // parsePacketToken parses the packet token and populates the right state.
 // Returns an error if the token cannot be parsed or the signature fails
 func (d *Datapath) parsePacketToken(auth *AuthInfo, data []byte) (*tokens.ConnectionClaims, error) {
@@ -878,6 +1146,7 @@ func (d *Datapath) appSynRetrieveState(p *packet.Packet) (*PUContext, *TCPConnec
 
 	conn.(*TCPConnection).Lock()
 	conn.(*TCPConnection).Context = context
+	conn.(*TCPConnection).flow = newFlowTuple(p)
 	conn.(*TCPConnection).Unlock()
 	return context, conn.(*TCPConnection), nil
 }
@@ -971,6 +1240,7 @@ func (d *Datapath) netSynRetrieveState(p *packet.Packet) (*PUContext, *TCPConnec
 
 	conn.(*TCPConnection).Lock()
 	conn.(*TCPConnection).Context = context
+	conn.(*TCPConnection).flow = newFlowTuple(p)
 	conn.(*TCPConnection).Unlock()
 	return context, conn.(*TCPConnection), nil
 }
@@ -1034,12 +1304,21 @@ func updateTimer(c cache.DataStore, hash string, conn *TCPConnection) error {
 	conn.Lock()
 	defer conn.Unlock()
 
-	if conn.ServiceConnection && conn.TimeOut > 0 {
+	if (conn.ServiceConnection || conn.Encrypted()) && conn.TimeOut > 0 {
 		return c.SetTimeOut(hash, conn.TimeOut)
 	}
 	return nil
 }
 
+// connectionMark returns the conntrack mark of an authorized connection. The
+// packets of encrypted connections must all go through the enforcer.
+func connectionMark(conn *TCPConnection) uint32 {
+	if conn.Encrypted() {
+		return constants.EncryptedConnMark
+	}
+	return constants.DefaultConnMark
+}
+
 // contextFromIP returns the PU context from the default IP if remote. Otherwise
 // it returns the context from the port or mark values of the packet. Synack
 // packets are again special and the flow is reversed. If a container doesn't supply
@@ -1097,5 +1376,5 @@ func (d *Datapath) releaseFlow(context *PUContext, plc *policy.FlowPolicy, tcpPa
 		zap.L().Error("Failed to update conntrack table")
 	}
 
-	d.reportReverseExternalServiceFlow(context, plc, true, tcpPacket)
+	d.reportReverseExternalServiceFlow(context, plc, true, tcpPacket, collector.PolicyDrop)
 }
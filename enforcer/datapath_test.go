@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
@@ -4701,3 +4702,91 @@ func TestForPacketsWithRandomFlags(t *testing.T) {
 		})
 	})
 }
+
+func TestConnections(t *testing.T) {
+
+	Convey("Given I create an enforcer with two processing units that accept each other", t, func() {
+
+		rules := policy.TagSelectorList{
+			{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "accept"},
+			},
+		}
+
+		enforcer, err1, err2 := setupTestProcessingUnits(&collector.DefaultCollector{}, rules, nil)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		puID1 := "SomeTestProcessingUnitId" + strconv.Itoa(iteration) + "1"
+		puID2 := "SomeTestProcessingUnitId" + strconv.Itoa(iteration) + "2"
+
+		Convey("When I ask for the connections of an unknown context, I should get an error", func() {
+			_, err := enforcer.Connections("unknown")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I ask for the connections before any traffic, I should get none", func() {
+			connections, err := enforcer.Connections(puID1)
+			So(err, ShouldBeNil)
+			So(connections, ShouldBeEmpty)
+		})
+
+		Convey("When I complete a TCP handshake and send a UDP request", func() {
+
+			_, _, err := transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1000, 0, true, nil))
+			So(err, ShouldBeNil)
+			_, _, err = transmitTCPPacket(enforcer, createTCPTestPacket(testIP2, testIP1, 80, 2000, 5000, 1001, true, nil))
+			So(err, ShouldBeNil)
+			_, _, err = transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1001, 5001, false, nil))
+			So(err, ShouldBeNil)
+
+			request := createUDPTestPacket(testIP1, testIP2, 12345, 53, []byte("request"))
+			So(enforcer.processApplicationUDPPackets(request), ShouldBeNil)
+
+			Convey("Then the initiator should list the outgoing connections", func() {
+				connections, err := enforcer.Connections(puID1)
+				So(err, ShouldBeNil)
+				So(len(connections), ShouldEqual, 2)
+
+				for _, c := range connections {
+					So(c.ContextID, ShouldEqual, puID1)
+					So(c.Outgoing, ShouldBeTrue)
+					So(c.SourceIP, ShouldEqual, testIP1)
+					So(c.DestinationIP, ShouldEqual, testIP2)
+
+					if c.Protocol == packet.IPProtocolTCP {
+						So(c.SourcePort, ShouldEqual, 2000)
+						So(c.DestinationPort, ShouldEqual, 80)
+						So(c.State, ShouldEqual, TCPAckSend.String())
+						So(c.RemoteContextID, ShouldEqual, "value")
+						// The initiator doesn't have transmitter rules
+						So(c.FlowPolicy, ShouldBeNil)
+					} else {
+						So(c.Protocol, ShouldEqual, packet.IPProtocolUDP)
+						So(c.DestinationPort, ShouldEqual, 53)
+						So(c.State, ShouldEqual, UDPSynSend.String())
+					}
+				}
+			})
+
+			Convey("Then the receiver should list the incoming connection", func() {
+				connections, err := enforcer.Connections(puID2)
+				So(err, ShouldBeNil)
+				So(len(connections), ShouldEqual, 1)
+				So(connections[0].Outgoing, ShouldBeFalse)
+				So(connections[0].SourceIP, ShouldEqual, testIP1)
+				So(connections[0].SourcePort, ShouldEqual, 2000)
+				So(connections[0].State, ShouldEqual, TCPData.String())
+				So(connections[0].RemoteContextID, ShouldEqual, "value")
+				So(connections[0].FlowPolicy.PolicyID, ShouldEqual, "accept")
+			})
+		})
+	})
+}
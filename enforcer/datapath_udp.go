@@ -0,0 +1,491 @@
+package enforcer
+
+// Go libraries
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/log"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// UDP flows are authorized with a lightweight handshake that is piggybacked
+// on the data packets of the flow. The initiator inserts its identity token
+// in front of the payload of every packet it sends until it receives a reply
+// carrying the token of the responder. The responder validates the token of
+// the initiator against its receiver rules, strips the token from the packets
+// and attaches its own token to the next reply. Once both sides have seen the
+// tokens, the flow is released to the kernel with a conntrack mark.
+
+// processNetworkUDPPackets processes UDP packets arriving from the network and destined to the application
+func (d *Datapath) processNetworkUDPPackets(p *packet.Packet) (err error) {
+
+	if log.Trace {
+		zap.L().Debug("Processing network UDP packet ",
+			zap.String("flow", p.L4FlowHash()),
+		)
+
+		defer zap.L().Debug("Finished Processing network UDP packet ",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+
+	context, conn, err := d.netUDPRetrieveState(p)
+	if err != nil {
+		if log.Trace {
+			zap.L().Debug("UDP packet rejected",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return err
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	if err = d.processNetworkUDPPacket(p, context, conn); err != nil {
+		if log.Trace {
+			zap.L().Debug("Rejecting UDP packet ",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return fmt.Errorf("Packet processing failed for network UDP packet: %s", err.Error())
+	}
+
+	return nil
+}
+
+// processApplicationUDPPackets processes UDP packets arriving from an application and are destined to the network
+func (d *Datapath) processApplicationUDPPackets(p *packet.Packet) (err error) {
+
+	if log.Trace {
+		zap.L().Debug("Processing application UDP packet ",
+			zap.String("flow", p.L4FlowHash()),
+		)
+
+		defer zap.L().Debug("Finished Processing application UDP packet ",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+
+	context, conn, err := d.appUDPRetrieveState(p)
+	if err != nil {
+		if log.Trace {
+			zap.L().Debug("UDP packet rejected",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return err
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	if err = d.processApplicationUDPPacket(p, context, conn); err != nil {
+		if log.Trace {
+			zap.L().Debug("Dropping UDP packet ",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return fmt.Errorf("Processing failed for application UDP packet: %s", err.Error())
+	}
+
+	return nil
+}
+
+// processApplicationUDPPacket processes an application UDP packet based on the state of the flow
+func (d *Datapath) processApplicationUDPPacket(udpPacket *packet.Packet, context *PUContext, conn *UDPConnection) error {
+
+	switch conn.GetState() {
+
+	case UDPStart:
+		return d.processApplicationUDPFirstPacket(udpPacket, context, conn)
+
+	case UDPSynSend:
+		// The responder has not been authorized yet. Keep on sending our token
+		return udpPacket.UDPTokenAttach(packet.UDPSynMask, conn.synToken)
+
+	case UDPSynReceived:
+		// The initiator is waiting for our token. Attach it to this reply.
+		context.Lock()
+		token, err := d.createSynAckPacketToken(context, &conn.Auth)
+		context.Unlock()
+		if err != nil {
+			return err
+		}
+
+		if err := udpPacket.UDPTokenAttach(packet.UDPSynAckMask, token); err != nil {
+			return err
+		}
+
+		conn.SetState(UDPSynAckSend)
+		return nil
+
+	case UDPData:
+		// Packets of released flows that are still in the queues. Retry the
+		// release in case the conntrack entry was not confirmed the first time.
+		d.releaseUDPFlow(udpPacket, false)
+		return nil
+
+	default:
+		return nil
+	}
+}
+
+// processApplicationUDPFirstPacket processes the first packet of a UDP flow initiated by the application
+func (d *Datapath) processApplicationUDPFirstPacket(udpPacket *packet.Packet, context *PUContext, conn *UDPConnection) error {
+
+	context.Lock()
+	defer context.Unlock()
+
+	// Destinations that are explicitly allowed by the ACLs are external services
+	// and they will not understand our token. The flow is released immediately.
+	if plc, err := context.UDPApplicationACLs.GetMatchingAction(udpPacket.DestinationAddress, udpPacket.DestinationPort); err == nil {
+
+		d.reportExternalServiceFlow(context, plc, true, udpPacket, collector.PolicyDrop)
+		if plc.Action&policy.Reject > 0 && !context.Audit {
+			return fmt.Errorf("UDP flow to external service rejected by ACLs")
+		}
+
+		conn.FlowPolicy = plc
+		conn.SetState(UDPData)
+		d.udpAppOrigConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+		d.udpNetReplyConnectionTracker.AddOrUpdate(udpPacket.L4ReverseFlowHash(), conn)
+		d.releaseUDPFlow(udpPacket, false)
+
+		return nil
+	}
+
+	// Create the token once for the flow. The same nonce must be used for all
+	// packets, since the responder only validates the first one it receives.
+	token, err := d.createSynPacketToken(context, &conn.Auth)
+	if err != nil {
+		return err
+	}
+
+	conn.synToken = make([]byte, len(token))
+	copy(conn.synToken, token)
+
+	if err := udpPacket.UDPTokenAttach(packet.UDPSynMask, conn.synToken); err != nil {
+		return err
+	}
+
+	conn.SetState(UDPSynSend)
+
+	// Poplate the caches to track the flow
+	d.udpAppOrigConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+	d.udpNetReplyConnectionTracker.AddOrUpdate(udpPacket.L4ReverseFlowHash(), conn)
+
+	return nil
+}
+
+// processNetworkUDPPacket processes a network UDP packet and dispatches it based on the token it carries
+func (d *Datapath) processNetworkUDPPacket(udpPacket *packet.Packet, context *PUContext, conn *UDPConnection) error {
+
+	tokenType, token, err := udpPacket.ReadUDPToken()
+	if err != nil {
+		return d.processNetworkUDPDataPacket(udpPacket, context, conn)
+	}
+
+	switch tokenType {
+
+	case packet.UDPSynMask:
+		return d.processNetworkUDPSynPacket(udpPacket, context, conn, token)
+
+	case packet.UDPSynAckMask:
+		return d.processNetworkUDPSynAckPacket(udpPacket, context, conn, token)
+
+	default:
+		return fmt.Errorf("Invalid UDP token type %d", tokenType)
+	}
+}
+
+// processNetworkUDPSynPacket processes a UDP packet carrying the token of the initiator of the flow
+func (d *Datapath) processNetworkUDPSynPacket(udpPacket *packet.Packet, context *PUContext, conn *UDPConnection, token []byte) error {
+
+	switch conn.GetState() {
+
+	case UDPSynReceived, UDPSynAckSend:
+		// The initiator has not received our token yet. The flow is already
+		// authorized, so we only remove the token and make sure that the next
+		// reply carries our token again.
+		conn.SetState(UDPSynReceived)
+		return udpPacket.UDPTokenDetach()
+
+	case UDPData:
+		// Re-ordered packets after the negotiation is completed
+		return udpPacket.UDPTokenDetach()
+
+	case UDPSynSend:
+		return fmt.Errorf("Received UDP token of initiator in the wrong state %v", conn.GetState())
+	}
+
+	context.Lock()
+	defer context.Unlock()
+
+	// Decode the JWT token using the context key. If the token signature is not
+	// valid or there are no claims we must drop the packet.
+	claims, err := d.parsePacketToken(&conn.Auth, token)
+	if err != nil || claims == nil {
+		d.reportRejectedFlow(udpPacket, nil, collector.DefaultEndPoint, context.ManagementID, context, collector.InvalidToken, nil)
+		return fmt.Errorf("UDP packet dropped because of invalid token %v %+v", err, claims)
+	}
+
+	txLabel, _ := claims.T.Get(TransmitterLabel)
+
+	// Remove our data from the packet. No matter what we don't need the
+	// metadata any more.
+	if err := udpPacket.UDPTokenDetach(); err != nil {
+		d.reportRejectedFlow(udpPacket, nil, txLabel, context.ManagementID, context, collector.InvalidFormat, nil)
+		return fmt.Errorf("UDP packet dropped because of invalid format %v", err)
+	}
+
+	// Add the port and the protocol as labels with a $sys prefix. These labels are
+	// invalid otherwise. This allows port and protocol specific policies
+	addFlowLabels(claims.T, "udp", udpPacket.DestinationPort)
+
+	// Validate against reject rules first - They win unless an accept rule has a higher priority
+	if index, plc := searchRejectRules(context.RejectRcvRules, context.AcceptRcvRules, claims.T); index >= 0 {
+		record := d.reportRejectedFlow(udpPacket, nil, txLabel, context.ManagementID, context, collector.PolicyDrop, plc.(*policy.FlowPolicy))
+		if !context.Audit {
+			return fmt.Errorf("UDP flow rejected because of policy %+v", claims.T)
+		}
+		d.authorizeNetworkUDPFlow(udpPacket, conn, plc.(*policy.FlowPolicy))
+		d.trackFlow(record, udpPacket)
+		return nil
+	}
+
+	// Search the policy rules for a matching rule.
+	if index, action := context.AcceptRcvRules.Search(claims.T); index >= 0 {
+
+		d.authorizeNetworkUDPFlow(udpPacket, conn, action.(*policy.FlowPolicy))
+
+		// We accept the packet as a new flow
+		d.reportAcceptedFlow(udpPacket, nil, txLabel, context.ManagementID, context, conn.FlowPolicy)
+
+		return nil
+	}
+
+	reason, plc := rejectReason(context.AcceptRcvRules, claims.T)
+	record := d.reportRejectedFlow(udpPacket, nil, txLabel, context.ManagementID, context, reason, plc)
+	if !context.Audit {
+		return fmt.Errorf("No matched tags - reject UDP flow %+v", claims.T)
+	}
+	if plc == nil {
+		plc = &policy.FlowPolicy{Action: policy.Reject}
+	}
+	d.authorizeNetworkUDPFlow(udpPacket, conn, plc)
+	d.trackFlow(record, udpPacket)
+	return nil
+}
+
+// authorizeNetworkUDPFlow tracks a UDP flow authorized by the responder with the
+// flow policy that matched. Flows rejected by the policy of a PU in audit mode
+// are authorized with the rejecting policy.
+func (d *Datapath) authorizeNetworkUDPFlow(udpPacket *packet.Packet, conn *UDPConnection, flowPolicy *policy.FlowPolicy) {
+
+	conn.FlowPolicy = flowPolicy
+	conn.SetState(UDPSynReceived)
+
+	d.udpNetOrigConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+	d.udpAppReplyConnectionTracker.AddOrUpdate(udpPacket.L4ReverseFlowHash(), conn)
+}
+
+// processNetworkUDPSynAckPacket processes a UDP reply carrying the token of the responder of the flow
+func (d *Datapath) processNetworkUDPSynAckPacket(udpPacket *packet.Packet, context *PUContext, conn *UDPConnection, token []byte) error {
+
+	// Duplicate replies after the flow has been authorized
+	if conn.GetState() == UDPData {
+		return udpPacket.UDPTokenDetach()
+	}
+
+	if conn.GetState() != UDPSynSend {
+		return fmt.Errorf("Received UDP token of responder in the wrong state %v", conn.GetState())
+	}
+
+	context.Lock()
+	defer context.Unlock()
+
+	claims, err := d.parsePacketToken(&conn.Auth, token)
+	if err != nil || claims == nil {
+		d.reportRejectedFlow(udpPacket, nil, collector.DefaultEndPoint, context.ManagementID, context, collector.InvalidToken, nil)
+		return fmt.Errorf("UDP reply dropped because of bad claims %v %+v", err, claims)
+	}
+
+	// The responder must sign our nonce to prove that the reply belongs to this flow
+	if !bytes.Equal(claims.RMT, conn.Auth.LocalContext) {
+		d.reportRejectedFlow(udpPacket, nil, conn.Auth.RemoteContextID, context.ManagementID, context, collector.InvalidNonse, nil)
+		return fmt.Errorf("UDP reply dropped because of nonce mismatch")
+	}
+
+	if err := udpPacket.UDPTokenDetach(); err != nil {
+		d.reportRejectedFlow(udpPacket, nil, conn.Auth.RemoteContextID, context.ManagementID, context, collector.InvalidFormat, nil)
+		return fmt.Errorf("UDP reply dropped because of invalid format %v", err)
+	}
+
+	// The destination port of the flow is the source port of the reply
+	addFlowLabels(claims.T, "udp", udpPacket.SourcePort)
+
+	// We can now verify the reverse policy. The system requires that policy
+	// is matched in both directions. We have to make this optional as it can
+	// become a very strong condition
+	if index, _ := searchRejectRules(context.RejectTxtRules, context.AcceptTxtRules, claims.T); d.mutualAuthorization && index >= 0 {
+		d.reportRejectedFlow(udpPacket, nil, context.ManagementID, conn.Auth.RemoteContextID, context, collector.PolicyDrop, nil)
+		if !context.Audit {
+			return fmt.Errorf("Dropping UDP reply because of reject rule on transmitter")
+		}
+		conn.SetState(UDPData)
+		d.releaseUDPFlow(udpPacket, true)
+		return nil
+	}
+
+	if index, _ := context.AcceptTxtRules.Search(claims.T); !d.mutualAuthorization || index >= 0 {
+		conn.SetState(UDPData)
+		d.releaseUDPFlow(udpPacket, true)
+		return nil
+	}
+
+	reason, plc := rejectReason(context.AcceptTxtRules, claims.T)
+	d.reportRejectedFlow(udpPacket, nil, context.ManagementID, conn.Auth.RemoteContextID, context, reason, plc)
+	if !context.Audit {
+		return fmt.Errorf("Dropping UDP reply at the network")
+	}
+	conn.SetState(UDPData)
+	d.releaseUDPFlow(udpPacket, true)
+	return nil
+}
+
+// processNetworkUDPDataPacket processes a network UDP packet that doesn't carry a token
+func (d *Datapath) processNetworkUDPDataPacket(udpPacket *packet.Packet, context *PUContext, conn *UDPConnection) error {
+
+	switch conn.GetState() {
+
+	case UDPStart:
+		// Packets from sources that are not enforced are processed as external
+		// services based on the ACLs
+		context.Lock()
+		plc, err := context.UDPNetworkACLs.GetMatchingAction(udpPacket.SourceAddress, udpPacket.DestinationPort)
+		d.reportExternalServiceFlow(context, plc, false, udpPacket, aclDropReason(err))
+		audit := context.Audit
+		context.Unlock()
+		if (err != nil || plc.Action&policy.Reject > 0) && !audit {
+			return fmt.Errorf("No Auth or ACLs - drop UDP flow")
+		}
+
+		conn.FlowPolicy = plc
+		conn.SetState(UDPData)
+		d.udpNetOrigConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+		d.udpAppReplyConnectionTracker.AddOrUpdate(udpPacket.L4ReverseFlowHash(), conn)
+		d.releaseUDPFlow(udpPacket, false)
+
+		return nil
+
+	case UDPSynSend:
+		// We are the initiator and the reply doesn't carry the token of the responder
+		return fmt.Errorf("UDP reply dropped because of missing token")
+
+	case UDPSynAckSend:
+		// The initiator stopped sending its token, which means that it has
+		// received ours. The negotiation is completed.
+		conn.SetState(UDPData)
+		d.releaseUDPFlow(udpPacket, false)
+		return nil
+
+	case UDPData:
+		d.releaseUDPFlow(udpPacket, false)
+		return nil
+
+	default:
+		// The flow is already authorized
+		return nil
+	}
+}
+
+// appUDPRetrieveState retrieves the state of a UDP flow for an application packet.
+// It creates a new flow if the packet is not part of an existing one.
+func (d *Datapath) appUDPRetrieveState(p *packet.Packet) (*PUContext, *UDPConnection, error) {
+
+	hash := p.L4FlowHash()
+
+	if conn, err := d.udpAppReplyConnectionTracker.GetReset(hash, 0); err == nil {
+		return conn.(*UDPConnection).Context, conn.(*UDPConnection), nil
+	}
+
+	if conn, err := d.udpAppOrigConnectionTracker.GetReset(hash, 0); err == nil {
+		return conn.(*UDPConnection).Context, conn.(*UDPConnection), nil
+	}
+
+	context, err := d.contextFromIP(true, p.SourceAddress.String(), p.Mark, strconv.Itoa(int(p.SourcePort)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("No Context in App UDP Processing")
+	}
+
+	conn := NewUDPConnection(context)
+	conn.flow = newFlowTuple(p)
+
+	return context, conn, nil
+}
+
+// netUDPRetrieveState retrieves the state of a UDP flow for a network packet.
+// It creates a new flow if the packet is not part of an existing one.
+func (d *Datapath) netUDPRetrieveState(p *packet.Packet) (*PUContext, *UDPConnection, error) {
+
+	hash := p.L4FlowHash()
+
+	if conn, err := d.udpNetReplyConnectionTracker.GetReset(hash, 0); err == nil {
+		return conn.(*UDPConnection).Context, conn.(*UDPConnection), nil
+	}
+
+	if conn, err := d.udpNetOrigConnectionTracker.GetReset(hash, 0); err == nil {
+		return conn.(*UDPConnection).Context, conn.(*UDPConnection), nil
+	}
+
+	context, err := d.contextFromIP(false, p.DestinationAddress.String(), p.Mark, strconv.Itoa(int(p.DestinationPort)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("No Context in net UDP Processing")
+	}
+
+	conn := NewUDPConnection(context)
+	conn.flow = newFlowTuple(p)
+
+	return context, conn, nil
+}
+
+// releaseUDPFlow updates the conntrack mark of the flow, so that the rest of
+// the packets are not sent to the queues. Reverse must be set when the packet
+// is a reply of the flow.
+func (d *Datapath) releaseUDPFlow(udpPacket *packet.Packet, reverse bool) {
+
+	src, dst := udpPacket.SourceAddress.String(), udpPacket.DestinationAddress.String()
+	srcPort, dstPort := udpPacket.SourcePort, udpPacket.DestinationPort
+	if reverse {
+		src, dst = dst, src
+		srcPort, dstPort = dstPort, srcPort
+	}
+
+	if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		src,
+		dst,
+		udpPacket.IPProto,
+		srcPort,
+		dstPort,
+		constants.DefaultConnMark,
+	); err != nil {
+		zap.L().Debug("Failed to update conntrack table for UDP flow",
+			zap.String("flow", udpPacket.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+}
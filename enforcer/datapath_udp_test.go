@@ -0,0 +1,272 @@
+package enforcer
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	testIP1   = "10.1.1.1"
+	testIP2   = "10.1.1.2"
+	testIPv61 = "2001:db8::1"
+	testIPv62 = "2001:db8::2"
+)
+
+func createUDPTestPacket(srcIP, dstIP string, srcPort, dstPort uint16, payload []byte) *packet.Packet {
+
+	var ip gopacket.NetworkLayer
+	var ipLayer gopacket.SerializableLayer
+
+	if net.ParseIP(srcIP).To4() != nil {
+		ipv4 := &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolUDP,
+			SrcIP:    net.ParseIP(srcIP).To4(),
+			DstIP:    net.ParseIP(dstIP).To4(),
+		}
+		ip, ipLayer = ipv4, ipv4
+	} else {
+		ipv6 := &layers.IPv6{
+			Version:    6,
+			HopLimit:   64,
+			NextHeader: layers.IPProtocolUDP,
+			SrcIP:      net.ParseIP(srcIP),
+			DstIP:      net.ParseIP(dstIP),
+		}
+		ip, ipLayer = ipv6, ipv6
+	}
+
+	udp := &layers.UDP{
+		SrcPort: layers.UDPPort(srcPort),
+		DstPort: layers.UDPPort(dstPort),
+	}
+	So(udp.SetNetworkLayerForChecksum(ip), ShouldBeNil)
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	So(gopacket.SerializeLayers(buffer, options, ipLayer, udp, gopacket.Payload(payload)), ShouldBeNil)
+
+	p, err := packet.New(0, buffer.Bytes(), "0")
+	So(err, ShouldBeNil)
+
+	return p
+}
+
+func setupTestProcessingUnits(flowCollector collector.EventCollector, rules policy.TagSelectorList, netACLs policy.IPRuleList) (*Datapath, error, error) {
+
+	secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+	enforcer := NewWithDefaults("SomeServerId", flowCollector, nil, secret, constants.LocalContainer, "/proc").(*Datapath)
+
+	err1, err2 := enforceTestProcessingUnits(enforcer, rules, netACLs)
+
+	return enforcer, err1, err2
+}
+
+// enforceTestProcessingUnits enforces two processing units with the testIP1 and
+// testIP2 addresses. The second one receives the rules and network ACLs.
+func enforceTestProcessingUnits(enforcer *Datapath, rules policy.TagSelectorList, netACLs policy.IPRuleList) (error, error) {
+
+	iteration = iteration + 1
+	puID1 := "SomeTestProcessingUnitId" + strconv.Itoa(iteration) + "1"
+	puID2 := "SomeTestProcessingUnitId" + strconv.Itoa(iteration) + "2"
+
+	puInfo1 := policy.NewPUInfo(puID1, constants.ContainerPU)
+	puInfo1.Runtime.SetIPAddresses(policy.ExtendedMap{"bridge": testIP1, policy.DefaultIPv6Namespace: testIPv61})
+	puInfo1.Policy.SetIPAddresses(policy.ExtendedMap{policy.DefaultNamespace: testIP1})
+	puInfo1.Policy.AddIdentityTag(TransmitterLabel, "value")
+
+	puInfo2 := policy.NewPUInfo(puID2, constants.ContainerPU)
+	puInfo2.Runtime.SetIPAddresses(policy.ExtendedMap{"bridge": testIP2, policy.DefaultIPv6Namespace: testIPv62})
+	puInfo2 = policy.PUInfoFromPolicyAndRuntime(
+		puID2,
+		policy.NewPUPolicy(
+			puID2,
+			policy.Police,
+			nil,
+			netACLs,
+			nil,
+			rules,
+			policy.NewTagStoreFromMap(map[string]string{TransmitterLabel: "value"}),
+			nil,
+			policy.ExtendedMap{policy.DefaultNamespace: testIP2},
+			[]string{},
+			[]string{},
+		),
+		puInfo2.Runtime,
+	)
+
+	return enforcer.Enforce(puID1, puInfo1), enforcer.Enforce(puID2, puInfo2)
+}
+
+func TestUDPGoodFlow(t *testing.T) {
+
+	Convey("Given I create an enforcer with two processing units that accept each other", t, func() {
+
+		rules := policy.TagSelectorList{
+			{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept},
+			},
+		}
+
+		enforcer, err1, err2 := setupTestProcessingUnits(&collector.DefaultCollector{}, rules, nil)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When I send a request and a reply through the enforcer", func() {
+
+			request := createUDPTestPacket(testIP1, testIP2, 12345, 53, []byte("request"))
+			So(enforcer.processApplicationUDPPackets(request), ShouldBeNil)
+
+			Convey("Then the request should carry a token", func() {
+				So(request.UDPDataLength(), ShouldBeGreaterThan, len("request"))
+
+				tokenType, _, err := request.ReadUDPToken()
+				So(err, ShouldBeNil)
+				So(tokenType, ShouldEqual, packet.UDPSynMask)
+			})
+
+			Convey("Then the receiver should accept the request and remove the token", func() {
+				So(enforcer.processNetworkUDPPackets(request), ShouldBeNil)
+				So(string(request.ReadUDPData()), ShouldEqual, "request")
+				So(request.VerifyUDPChecksum(), ShouldBeTrue)
+
+				reply := createUDPTestPacket(testIP2, testIP1, 53, 12345, []byte("reply"))
+				So(enforcer.processApplicationUDPPackets(reply), ShouldBeNil)
+
+				tokenType, _, err := reply.ReadUDPToken()
+				So(err, ShouldBeNil)
+				So(tokenType, ShouldEqual, packet.UDPSynAckMask)
+
+				Convey("Then the initiator should accept the reply and the flow should be authorized", func() {
+					So(enforcer.processNetworkUDPPackets(reply), ShouldBeNil)
+					So(string(reply.ReadUDPData()), ShouldEqual, "reply")
+
+					_, conn, err := enforcer.appUDPRetrieveState(createUDPTestPacket(testIP1, testIP2, 12345, 53, []byte("data")))
+					So(err, ShouldBeNil)
+					So(conn.GetState(), ShouldEqual, UDPData)
+
+					data := createUDPTestPacket(testIP1, testIP2, 12345, 53, []byte("data"))
+					So(enforcer.processApplicationUDPPackets(data), ShouldBeNil)
+					So(string(data.ReadUDPData()), ShouldEqual, "data")
+				})
+			})
+		})
+	})
+}
+
+func TestUDPIPv6Flow(t *testing.T) {
+
+	Convey("Given I create an enforcer with two dual-stack processing units that accept each other", t, func() {
+
+		rules := policy.TagSelectorList{
+			{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept},
+			},
+		}
+
+		enforcer, err1, err2 := setupTestProcessingUnits(&collector.DefaultCollector{}, rules, nil)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When I send an IPv6 request and reply through the enforcer, the flow should be authorized", func() {
+
+			request := createUDPTestPacket(testIPv61, testIPv62, 12345, 53, []byte("request"))
+			So(enforcer.processApplicationUDPPackets(request), ShouldBeNil)
+			So(enforcer.processNetworkUDPPackets(request), ShouldBeNil)
+			So(string(request.ReadUDPData()), ShouldEqual, "request")
+			So(request.VerifyUDPChecksum(), ShouldBeTrue)
+
+			reply := createUDPTestPacket(testIPv62, testIPv61, 53, 12345, []byte("reply"))
+			So(enforcer.processApplicationUDPPackets(reply), ShouldBeNil)
+			So(enforcer.processNetworkUDPPackets(reply), ShouldBeNil)
+			So(string(reply.ReadUDPData()), ShouldEqual, "reply")
+
+			_, conn, err := enforcer.appUDPRetrieveState(createUDPTestPacket(testIPv61, testIPv62, 12345, 53, []byte("data")))
+			So(err, ShouldBeNil)
+			So(conn.GetState(), ShouldEqual, UDPData)
+		})
+	})
+}
+
+func TestUDPRejectedFlow(t *testing.T) {
+
+	Convey("Given I create an enforcer with a processing unit that accepts nothing", t, func() {
+
+		enforcer, err1, err2 := setupTestProcessingUnits(&collector.DefaultCollector{}, nil, nil)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When I send a request through the enforcer", func() {
+
+			request := createUDPTestPacket(testIP1, testIP2, 12345, 53, []byte("request"))
+			So(enforcer.processApplicationUDPPackets(request), ShouldBeNil)
+
+			Convey("Then the receiver should reject it", func() {
+				So(enforcer.processNetworkUDPPackets(request), ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestUDPExternalFlow(t *testing.T) {
+
+	Convey("Given I create an enforcer with a processing unit that accepts UDP from a network", t, func() {
+
+		netACLs := policy.IPRuleList{
+			{
+				Address:  "192.168.0.0/16",
+				Port:     "53",
+				Protocol: "udp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+		}
+
+		enforcer, err1, err2 := setupTestProcessingUnits(&collector.DefaultCollector{}, nil, netACLs)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When I receive a packet without a token from an allowed network", func() {
+
+			p := createUDPTestPacket("192.168.1.1", testIP2, 12345, 53, []byte("request"))
+
+			Convey("Then it should be accepted", func() {
+				So(enforcer.processNetworkUDPPackets(p), ShouldBeNil)
+				So(string(p.ReadUDPData()), ShouldEqual, "request")
+			})
+		})
+
+		Convey("When I receive a packet without a token from an unknown network", func() {
+
+			p := createUDPTestPacket("172.17.1.1", testIP2, 12345, 53, []byte("request"))
+
+			Convey("Then it should be dropped", func() {
+				So(enforcer.processNetworkUDPPackets(p), ShouldNotBeNil)
+			})
+		})
+	})
+}
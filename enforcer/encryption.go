@@ -0,0 +1,196 @@
+package enforcer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/aporeto-inc/trireme/crypto"
+)
+
+// Labels used to derive the keys of each direction of an encrypted connection
+var (
+	sessionKeyLabel        = []byte("trireme session key")
+	initiatorEncryptionKey = []byte("initiator encryption")
+	initiatorAuthenticator = []byte("initiator authentication")
+	responderEncryptionKey = []byte("responder encryption")
+	responderAuthenticator = []byte("responder authentication")
+)
+
+// sequenceTracker extends the 32 bit TCP sequence numbers of one direction
+// of a connection to 64 bit positions relative to the initial sequence number
+type sequenceTracker struct {
+	isn   uint32
+	last  uint32
+	epoch uint64
+}
+
+// newSequenceTracker creates a new tracker for the given initial sequence number
+func newSequenceTracker(isn uint32) *sequenceTracker {
+
+	return &sequenceTracker{
+		isn:  isn,
+		last: isn,
+	}
+}
+
+// position returns the position of the first byte of a segment in the stream
+// and the epoch of its sequence number. The tracker is not modified.
+func (s *sequenceTracker) position(seq uint32) (uint64, uint64) {
+
+	epoch := s.epoch
+
+	if int32(seq-s.last) >= 0 {
+		// The segment is ahead of what we have seen. Count a wrap around
+		// of the sequence numbers.
+		if seq < s.last {
+			epoch++
+		}
+	} else if seq > s.last && epoch > 0 {
+		// Retransmission of a segment before the last wrap around
+		epoch--
+	}
+
+	return (epoch<<32 | uint64(seq)) - uint64(s.isn), epoch
+}
+
+// update records the sequence number of a segment that has been processed
+func (s *sequenceTracker) update(seq uint32, epoch uint64) {
+
+	if epoch > s.epoch || (epoch == s.epoch && seq > s.last) {
+		s.epoch = epoch
+		s.last = seq
+	}
+}
+
+// sessionKeys holds the keys and the stream position of one direction
+type sessionKeys struct {
+	block    cipher.Block
+	macKey   []byte
+	sequence *sequenceTracker
+}
+
+// newSessionKeys derives the keys of one direction from the session key
+func newSessionKeys(sessionKey []byte, encryptionLabel []byte, authenticationLabel []byte, isn uint32) (*sessionKeys, error) {
+
+	encryptionKey, err := crypto.ComputeHmac256(encryptionLabel, sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	macKey, err := crypto.ComputeHmac256(authenticationLabel, sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionKeys{
+		block:    block,
+		macKey:   macKey,
+		sequence: newSequenceTracker(isn),
+	}, nil
+}
+
+// xorKeyStream encrypts or decrypts the data at the given stream position
+// with AES in counter mode. Retransmitted or re-segmented data is always
+// processed with the same key stream.
+func (k *sessionKeys) xorKeyStream(position uint64, data []byte) {
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[aes.BlockSize-8:], position/aes.BlockSize)
+
+	stream := cipher.NewCTR(k.block, iv)
+
+	// Discard the key stream before the position in the first block
+	skip := make([]byte, position%aes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+
+	stream.XORKeyStream(data, data)
+}
+
+// tag computes the authentication tag of the encrypted data at the given position
+func (k *sessionKeys) tag(position uint64, data []byte) []byte {
+
+	h := hmac.New(sha256.New, k.macKey)
+
+	positionBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(positionBytes, position)
+
+	h.Write(positionBytes) // nolint
+	h.Write(data)          // nolint
+
+	return h.Sum(nil)[:EncryptionTagLength]
+}
+
+// sessionCipher encrypts and authenticates the payload of a connection between
+// two enforcers. The session key is derived from the ECDH shared key of the
+// ephemeral keys and the nonces exchanged during the handshake.
+type sessionCipher struct {
+	tx *sessionKeys
+	rx *sessionKeys
+}
+
+// newSessionCipher creates the cipher of a connection. The initial sequence
+// numbers of both directions are used to compute the stream positions.
+func newSessionCipher(sharedKey []byte, initiatorNonce []byte, responderNonce []byte, initiatorISN uint32, responderISN uint32, initiator bool) (*sessionCipher, error) {
+
+	material := append([]byte{}, sessionKeyLabel...)
+	material = append(material, initiatorNonce...)
+	material = append(material, responderNonce...)
+
+	sessionKey, err := crypto.ComputeHmac256(material, sharedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	initiatorKeys, err := newSessionKeys(sessionKey, initiatorEncryptionKey, initiatorAuthenticator, initiatorISN)
+	if err != nil {
+		return nil, err
+	}
+
+	responderKeys, err := newSessionKeys(sessionKey, responderEncryptionKey, responderAuthenticator, responderISN)
+	if err != nil {
+		return nil, err
+	}
+
+	if initiator {
+		return &sessionCipher{tx: initiatorKeys, rx: responderKeys}, nil
+	}
+
+	return &sessionCipher{tx: responderKeys, rx: initiatorKeys}, nil
+}
+
+// encrypt encrypts the payload of a transmitted segment in place and returns
+// the authentication tag that must be appended to the segment
+func (s *sessionCipher) encrypt(seq uint32, payload []byte) []byte {
+
+	position, epoch := s.tx.sequence.position(seq)
+	s.tx.sequence.update(seq, epoch)
+
+	s.tx.xorKeyStream(position, payload)
+
+	return s.tx.tag(position, payload)
+}
+
+// decrypt validates the authentication tag of a received segment and decrypts
+// the payload in place. The payload is not modified if the tag is not valid.
+func (s *sessionCipher) decrypt(seq uint32, payload []byte, tag []byte) error {
+
+	position, epoch := s.rx.sequence.position(seq)
+
+	if !hmac.Equal(s.rx.tag(position, payload), tag) {
+		return fmt.Errorf("Invalid encryption tag")
+	}
+
+	s.rx.sequence.update(seq, epoch)
+	s.rx.xorKeyStream(position, payload)
+
+	return nil
+}
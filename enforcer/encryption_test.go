@@ -0,0 +1,281 @@
+package enforcer
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/vishvananda/netlink"
+)
+
+type flowRecorder struct {
+	flows []*collector.FlowRecord
+}
+
+func (r *flowRecorder) CollectFlowEvent(record *collector.FlowRecord) {
+	r.flows = append(r.flows, record)
+}
+
+func (r *flowRecorder) CollectContainerEvent(record *collector.ContainerRecord) {}
+
+// markRecorder is a conntrack handle that records the marks of the flows
+type markRecorder struct {
+	marks map[string]uint32
+}
+
+func (r *markRecorder) ConntrackTableList(table netlink.ConntrackTableType) ([]*netlink.ConntrackFlow, error) {
+	return nil, nil
+}
+
+func (r *markRecorder) ConntrackTableFlush(table netlink.ConntrackTableType) error {
+	return nil
+}
+
+func (r *markRecorder) ConntrackTableUpdateMark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) error {
+	r.marks[ipSrc+":"+strconv.Itoa(int(srcport))+"-"+ipDst+":"+strconv.Itoa(int(dstport))] = newmark
+	return nil
+}
+
+func createTCPTestPacket(srcIP, dstIP string, srcPort, dstPort uint16, seq, ack uint32, syn bool, payload []byte) *packet.Packet {
+
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.ParseIP(srcIP).To4(),
+		DstIP:    net.ParseIP(dstIP).To4(),
+	}
+
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(srcPort),
+		DstPort: layers.TCPPort(dstPort),
+		Seq:     seq,
+		Ack:     ack,
+		SYN:     syn,
+		ACK:     ack != 0,
+		Window:  0xffff,
+	}
+
+	if syn {
+		tcp.Options = []layers.TCPOption{
+			{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}},
+		}
+	}
+	So(tcp.SetNetworkLayerForChecksum(ip), ShouldBeNil)
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	So(gopacket.SerializeLayers(buffer, options, ip, tcp, gopacket.Payload(payload)), ShouldBeNil)
+
+	p, err := packet.New(0, buffer.Bytes(), "0")
+	So(err, ShouldBeNil)
+
+	return p
+}
+
+// transmitTCPPacket processes a packet at the application and returns the packet
+// on the wire and the packet delivered by the network processing
+func transmitTCPPacket(enforcer *Datapath, p *packet.Packet) (*packet.Packet, *packet.Packet, error) {
+
+	if err := enforcer.processApplicationTCPPackets(p); err != nil {
+		return nil, nil, err
+	}
+
+	wire, err := packet.New(0, p.GetBytes(), "0")
+	So(err, ShouldBeNil)
+
+	delivered, err := packet.New(0, wire.GetBytes(), "0")
+	So(err, ShouldBeNil)
+
+	if err := enforcer.processNetworkTCPPackets(delivered); err != nil {
+		return wire, nil, err
+	}
+
+	delivered, err = packet.New(0, delivered.GetBytes(), "0")
+	So(err, ShouldBeNil)
+
+	return wire, delivered, nil
+}
+
+func mss(p *packet.Packet) uint16 {
+	return binary.BigEndian.Uint16(p.Buffer[p.TCPDataStartBytes()-2 : p.TCPDataStartBytes()])
+}
+
+func TestSessionCipher(t *testing.T) {
+
+	Convey("Given two session ciphers created from the same keys", t, func() {
+
+		shared := []byte("0123456789abcdef0123456789abcdef")
+		initiator, err := newSessionCipher(shared, []byte("nonce1"), []byte("nonce2"), 1000, 5000, true)
+		So(err, ShouldBeNil)
+		responder, err := newSessionCipher(shared, []byte("nonce1"), []byte("nonce2"), 1000, 5000, false)
+		So(err, ShouldBeNil)
+
+		Convey("When the initiator encrypts a segment, the responder should decrypt it", func() {
+			data := []byte("some application data")
+			tag := initiator.encrypt(1001, data)
+			So(string(data), ShouldNotEqual, "some application data")
+			So(len(tag), ShouldEqual, EncryptionTagLength)
+
+			So(responder.decrypt(1001, data, tag), ShouldBeNil)
+			So(string(data), ShouldEqual, "some application data")
+		})
+
+		Convey("When the responder encrypts a segment, the initiator should decrypt it", func() {
+			data := []byte("reply")
+			tag := responder.encrypt(5001, data)
+			So(initiator.decrypt(5001, data, tag), ShouldBeNil)
+			So(string(data), ShouldEqual, "reply")
+		})
+
+		Convey("When a segment is retransmitted in two segments, the key stream should be the same", func() {
+			data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+			first, second := []byte(string(data[:20])), []byte(string(data[20:]))
+
+			initiator.encrypt(1001, data)
+			initiator.encrypt(1001, first)
+			initiator.encrypt(1021, second)
+
+			So(append(first, second...), ShouldResemble, data)
+		})
+
+		Convey("When a segment is modified, the responder should reject it", func() {
+			data := []byte("some application data")
+			tag := initiator.encrypt(1001, data)
+			data[0] = data[0] ^ 0xff
+
+			So(responder.decrypt(1001, data, tag), ShouldNotBeNil)
+		})
+
+		Convey("When a segment is replayed at a different position, the responder should reject it", func() {
+			data := []byte("some application data")
+			tag := initiator.encrypt(1001, data)
+
+			So(responder.decrypt(2001, data, tag), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a sequence tracker close to a wrap around", t, func() {
+
+		tracker := newSequenceTracker(0xfffffff0)
+
+		Convey("The positions should continue after the wrap around", func() {
+			position, epoch := tracker.position(0xfffffff1)
+			So(position, ShouldEqual, 1)
+			tracker.update(0xfffffff1, epoch)
+
+			position, epoch = tracker.position(0x10)
+			So(position, ShouldEqual, 0x20)
+			tracker.update(0x10, epoch)
+
+			position, _ = tracker.position(0xfffffff8)
+			So(position, ShouldEqual, 8)
+		})
+	})
+}
+
+func TestEncryptedFlow(t *testing.T) {
+
+	Convey("Given I create an enforcer with a processing unit that requires encryption", t, func() {
+
+		rules := policy.TagSelectorList{
+			{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept | policy.Encrypt},
+			},
+		}
+
+		recorder := &flowRecorder{}
+		enforcer, err1, err2 := setupTestProcessingUnits(recorder, rules, nil)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		conntrack := &markRecorder{marks: map[string]uint32{}}
+		enforcer.conntrackHdl = conntrack
+
+		Convey("When I complete the handshake", func() {
+
+			_, syn, err := transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1000, 0, true, nil))
+			So(err, ShouldBeNil)
+
+			_, synAck, err := transmitTCPPacket(enforcer, createTCPTestPacket(testIP2, testIP1, 80, 2000, 5000, 1001, true, nil))
+			So(err, ShouldBeNil)
+
+			_, _, err = transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1001, 5001, false, nil))
+			So(err, ShouldBeNil)
+
+			Convey("Then the MSS should leave room for the authentication tag", func() {
+				So(mss(syn), ShouldEqual, 1460-EncryptionTagLength)
+				So(mss(synAck), ShouldEqual, 1460-EncryptionTagLength)
+				So(syn.VerifyTCPChecksum(), ShouldBeTrue)
+			})
+
+			Convey("Then the flow should be reported as encrypted", func() {
+				So(len(recorder.flows), ShouldEqual, 1)
+				So(recorder.flows[0].Action.Accepted(), ShouldBeTrue)
+				So(recorder.flows[0].Encrypted, ShouldBeTrue)
+			})
+
+			Convey("Then the data of both directions should be encrypted on the wire", func() {
+
+				wire, delivered, err := transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1001, 5001, false, []byte("request")))
+				So(err, ShouldBeNil)
+				So(len(wire.ReadTCPData()), ShouldEqual, len("request")+EncryptionTagLength)
+				So(string(wire.ReadTCPData()[:len("request")]), ShouldNotEqual, "request")
+				So(wire.VerifyTCPChecksum(), ShouldBeTrue)
+				So(string(delivered.ReadTCPData()), ShouldEqual, "request")
+				So(delivered.VerifyTCPChecksum(), ShouldBeTrue)
+
+				wire, delivered, err = transmitTCPPacket(enforcer, createTCPTestPacket(testIP2, testIP1, 80, 2000, 5001, 1008, false, []byte("reply")))
+				So(err, ShouldBeNil)
+				So(string(wire.ReadTCPData()[:len("reply")]), ShouldNotEqual, "reply")
+				So(string(delivered.ReadTCPData()), ShouldEqual, "reply")
+			})
+
+			Convey("Then the connection should be marked so that all its packets are queued", func() {
+				So(conntrack.marks, ShouldResemble, map[string]uint32{
+					testIP1 + ":2000-" + testIP2 + ":80": constants.EncryptedConnMark,
+				})
+			})
+
+			Convey("Then the data after the first packets should still be encrypted", func() {
+
+				seq := uint32(1001)
+				for _, data := range []string{"first", "second", "third", "fourth", "fifth"} {
+					wire, delivered, err := transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 80, seq, 5001, false, []byte(data)))
+					So(err, ShouldBeNil)
+					So(len(wire.ReadTCPData()), ShouldEqual, len(data)+EncryptionTagLength)
+					So(string(wire.ReadTCPData()[:len(data)]), ShouldNotEqual, data)
+					So(string(delivered.ReadTCPData()), ShouldEqual, data)
+					seq += uint32(len(data))
+				}
+			})
+
+			Convey("Then modified data should be dropped", func() {
+
+				p := createTCPTestPacket(testIP1, testIP2, 2000, 80, 1001, 5001, false, []byte("request"))
+				So(enforcer.processApplicationTCPPackets(p), ShouldBeNil)
+
+				wire, err := packet.New(0, p.GetBytes(), "0")
+				So(err, ShouldBeNil)
+				wire.ReadTCPData()[0] ^= 0xff
+
+				So(enforcer.processNetworkTCPPackets(wire), ShouldNotBeNil)
+			})
+		})
+	})
+}
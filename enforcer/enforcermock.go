@@ -4,6 +4,7 @@ import (
 	"sync"
 	"testing"
 
+	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme/policy"
 )
@@ -19,6 +20,12 @@ type mockedMethodsPolicyEnforcer struct {
 	// GetFilterQueue returns the current FilterQueueConfig.
 	getFilterQueueMock func() *fqconfig.FilterQueue
 
+	// Connections returns the connections of the given contextID.
+	connectionsMock func(contextID string) ([]*collector.ConnectionRecord, error)
+
+	// EvaluateFlow returns the decision of the policy of the given contextID for a flow.
+	evaluateFlowMock func(contextID string, query *policy.FlowQuery) (*policy.FlowDecision, error)
+
 	// Start starts the Supervisor.
 	startMock func() error
 
@@ -37,6 +44,8 @@ type TestPolicyEnforcer interface {
 	MockEnforce(t *testing.T, impl func(contextID string, puInfo *policy.PUInfo) error)
 	MockUnenforce(t *testing.T, impl func(ip string) error)
 	MockGetFilterQueue(t *testing.T, impl func() *fqconfig.FilterQueue)
+	MockConnections(t *testing.T, impl func(contextID string) ([]*collector.ConnectionRecord, error))
+	MockEvaluateFlow(t *testing.T, impl func(contextID string, query *policy.FlowQuery) (*policy.FlowDecision, error))
 	MockStart(t *testing.T, impl func() error)
 	MockStop(t *testing.T, impl func() error)
 }
@@ -92,6 +101,16 @@ func (m *testPolicyEnforcer) MockGetFilterQueue(t *testing.T, impl func() *fqcon
 	m.currentMocksPolicyEnforcer(t).getFilterQueueMock = impl
 }
 
+func (m *testPolicyEnforcer) MockConnections(t *testing.T, impl func(contextID string) ([]*collector.ConnectionRecord, error)) {
+
+	m.currentMocksPolicyEnforcer(t).connectionsMock = impl
+}
+
+func (m *testPolicyEnforcer) MockEvaluateFlow(t *testing.T, impl func(contextID string, query *policy.FlowQuery) (*policy.FlowDecision, error)) {
+
+	m.currentMocksPolicyEnforcer(t).evaluateFlowMock = impl
+}
+
 func (m *testPolicyEnforcer) MockStart(t *testing.T, impl func() error) {
 
 	m.currentMocksPolicyEnforcer(t).startMock = impl
@@ -129,6 +148,24 @@ func (m *testPolicyEnforcer) GetFilterQueue() *fqconfig.FilterQueue {
 	return nil
 }
 
+func (m *testPolicyEnforcer) Connections(contextID string) ([]*collector.ConnectionRecord, error) {
+
+	if mock := m.currentMocksPolicyEnforcer(m.currentTest); mock != nil && mock.connectionsMock != nil {
+		return mock.connectionsMock(contextID)
+	}
+
+	return nil, nil
+}
+
+func (m *testPolicyEnforcer) EvaluateFlow(contextID string, query *policy.FlowQuery) (*policy.FlowDecision, error) {
+
+	if mock := m.currentMocksPolicyEnforcer(m.currentTest); mock != nil && mock.evaluateFlowMock != nil {
+		return mock.evaluateFlowMock(contextID, query)
+	}
+
+	return nil, nil
+}
+
 func (m *testPolicyEnforcer) Start() error {
 
 	if mock := m.currentMocksPolicyEnforcer(m.currentTest); mock != nil && mock.startMock != nil {
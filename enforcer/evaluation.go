@@ -0,0 +1,199 @@
+package enforcer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aporeto-inc/trireme/enforcer/lookup"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// EvaluateFlow returns the decision of the policy enforced for a processing unit
+// for the given flow, without any packet being processed. The decision is taken
+// with the same rules and in the same order as the datapath.
+func (d *Datapath) EvaluateFlow(contextID string, query *policy.FlowQuery) (*policy.FlowDecision, error) {
+
+	puContext, err := d.contextTracker.Get(contextID)
+	if err != nil {
+		return nil, fmt.Errorf("ContextID not found in Enforcer")
+	}
+
+	context := puContext.(*PUContext)
+
+	context.Lock()
+	defer context.Unlock()
+
+	return evaluateFlow(context, query, d.mutualAuthorization)
+}
+
+// EvaluatePUPolicy returns the decision of a policy for the given flow. The policy
+// doesn't need to be enforced. The transmitter rules are only enforced when
+// mutualAuthorization is set, like in the datapath.
+func EvaluatePUPolicy(puPolicy *policy.PUPolicy, query *policy.FlowQuery, mutualAuthorization bool) (*policy.FlowDecision, error) {
+
+	if puPolicy == nil {
+		return nil, fmt.Errorf("No policy provided")
+	}
+
+	context := &PUContext{}
+	if err := setPolicyRules(context, puPolicy); err != nil {
+		return nil, fmt.Errorf("Invalid policy: %s", err)
+	}
+
+	return evaluateFlow(context, query, mutualAuthorization)
+}
+
+// evaluateFlow evaluates the flow against the rules of the context
+func evaluateFlow(context *PUContext, query *policy.FlowQuery, mutualAuthorization bool) (*policy.FlowDecision, error) {
+
+	if query == nil {
+		return nil, fmt.Errorf("No flow provided")
+	}
+
+	protocol := strings.ToLower(query.Protocol)
+	if protocol == "" {
+		protocol = "tcp"
+	}
+
+	number, err := policy.ProtocolNumber(protocol)
+	if err != nil {
+		return nil, fmt.Errorf("Unsupported protocol %s", query.Protocol)
+	}
+
+	// Flows between processing units are authorized with the tags of the remote
+	if query.RemoteTags != nil {
+		if query.Direction == policy.IncomingFlow {
+			return evaluateReceiverRules(context, query), nil
+		}
+		return evaluateTransmitterRules(context, query, mutualAuthorization), nil
+	}
+
+	ip := net.ParseIP(query.RemoteIP)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid remote address %s", query.RemoteIP)
+	}
+
+	source := policy.ApplicationACLs
+	aclCache := context.ApplicationACLs
+	udpCache := context.UDPApplicationACLs
+	protocolCaches := context.ProtocolApplicationACLs
+	if query.Direction == policy.IncomingFlow {
+		source = policy.NetworkACLs
+		aclCache = context.NetworkACLS
+		udpCache = context.UDPNetworkACLs
+		protocolCaches = context.ProtocolNetworkACLs
+	}
+
+	switch number {
+	case policy.ProtocolTCP:
+	case policy.ProtocolUDP:
+		aclCache = udpCache
+	default:
+		aclCache = protocolCaches[number]
+	}
+
+	// Flows of the protocols without any rule are rejected
+	if aclCache == nil {
+		return defaultDecision(policy.Reject), nil
+	}
+
+	if number == policy.ProtocolICMP || number == policy.ProtocolICMPv6 {
+		rule, err := aclCache.GetMatchingICMPRule(ip, query.ICMPType, query.ICMPCode)
+		return ruleDecision(rule, err, source), nil
+	}
+
+	rule, err := aclCache.GetMatchingRule(ip, query.Port)
+	return ruleDecision(rule, err, source), nil
+}
+
+// evaluateReceiverRules evaluates a flow from a remote processing unit. The port
+// and the protocol are added to the remote tags, as the datapath does with the
+// received claims.
+func evaluateReceiverRules(context *PUContext, query *policy.FlowQuery) *policy.FlowDecision {
+
+	tags := query.RemoteTags.Copy()
+	addFlowLabels(tags, strings.ToLower(query.Protocol), query.Port)
+
+	if index, plc := searchRejectRules(context.RejectRcvRules, context.AcceptRcvRules, tags); index >= 0 {
+		return selectorDecision(context.RejectRcvRules, index, plc, policy.RejectRcvRules)
+	}
+
+	if index, plc := context.AcceptRcvRules.Search(tags); index >= 0 {
+		return selectorDecision(context.AcceptRcvRules, index, plc, policy.AcceptRcvRules)
+	}
+
+	return defaultDecision(policy.Reject)
+}
+
+// evaluateTransmitterRules evaluates a flow to a remote processing unit. Flows
+// are only restricted by the transmitter rules with mutual authorization.
+func evaluateTransmitterRules(context *PUContext, query *policy.FlowQuery, mutualAuthorization bool) *policy.FlowDecision {
+
+	tags := query.RemoteTags.Copy()
+	addFlowLabels(tags, strings.ToLower(query.Protocol), query.Port)
+
+	if index, plc := searchRejectRules(context.RejectTxtRules, context.AcceptTxtRules, tags); mutualAuthorization && index >= 0 {
+		return selectorDecision(context.RejectTxtRules, index, plc, policy.RejectTxtRules)
+	}
+
+	if index, plc := context.AcceptTxtRules.Search(tags); index >= 0 {
+		return selectorDecision(context.AcceptTxtRules, index, plc, policy.AcceptTxtRules)
+	}
+
+	if !mutualAuthorization {
+		return defaultDecision(policy.Accept)
+	}
+
+	return defaultDecision(policy.Reject)
+}
+
+// ruleDecision creates the decision of the ACL matching a flow with an external
+// address
+func ruleDecision(rule *policy.IPRule, err error, source policy.DecisionSource) *policy.FlowDecision {
+
+	if err != nil {
+		return defaultDecision(policy.Reject)
+	}
+
+	decision := &policy.FlowDecision{
+		Source: source,
+		IPRule: rule,
+	}
+
+	if rule.Policy != nil {
+		decision.Action = rule.Policy.Action
+		decision.PolicyID = rule.Policy.PolicyID
+	}
+
+	return decision
+}
+
+// selectorDecision creates the decision of the policy found at the index of the db
+func selectorDecision(db *lookup.PolicyDB, index int, plc interface{}, source policy.DecisionSource) *policy.FlowDecision {
+
+	decision := &policy.FlowDecision{
+		Source: source,
+	}
+
+	if selector, err := db.Selector(index); err == nil {
+		decision.TagSelector = selector
+	}
+
+	if flowPolicy, ok := plc.(*policy.FlowPolicy); ok && flowPolicy != nil {
+		decision.Action = flowPolicy.Action
+		decision.PolicyID = flowPolicy.PolicyID
+	}
+
+	return decision
+}
+
+// defaultDecision creates the decision taken when no rule matches a flow
+func defaultDecision(action policy.ActionType) *policy.FlowDecision {
+
+	return &policy.FlowDecision{
+		Action:   action,
+		PolicyID: "default",
+		Source:   policy.DefaultDecision,
+	}
+}
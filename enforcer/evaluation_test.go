@@ -0,0 +1,307 @@
+package enforcer
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEvaluateFlow(t *testing.T) {
+
+	Convey("Given I create an enforcer with two processing units that accept each other", t, func() {
+
+		rules := policy.TagSelectorList{
+			{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "accept"},
+			},
+		}
+
+		netACLs := policy.IPRuleList{
+			{
+				Address:  "192.168.0.0/16",
+				Port:     "80",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "acl"},
+			},
+		}
+
+		enforcer, err1, err2 := setupTestProcessingUnits(&collector.DefaultCollector{}, rules, netACLs)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		puID2 := "SomeTestProcessingUnitId" + strconv.Itoa(iteration) + "2"
+
+		Convey("When I evaluate a flow of an unknown context, I should get an error", func() {
+			_, err := enforcer.EvaluateFlow("unknown", &policy.FlowQuery{RemoteIP: "10.0.0.1", Port: 80})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I evaluate a flow from the other processing unit, it should be accepted like its packets", func() {
+			decision, err := enforcer.EvaluateFlow(puID2, &policy.FlowQuery{
+				Direction:  policy.IncomingFlow,
+				RemoteTags: policy.NewTagStoreFromMap(map[string]string{TransmitterLabel: "value"}),
+				Port:       80,
+			})
+			So(err, ShouldBeNil)
+			So(decision.Action, ShouldEqual, policy.Accept)
+			So(decision.PolicyID, ShouldEqual, "accept")
+			So(decision.Source, ShouldEqual, policy.AcceptRcvRules)
+			So(decision.TagSelector, ShouldNotBeNil)
+			So(decision.TagSelector.Clause, ShouldResemble, rules[0].Clause)
+
+			_, _, err = transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1000, 0, true, nil))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When I evaluate a flow from an external address, I should get the matching ACL", func() {
+			decision, err := enforcer.EvaluateFlow(puID2, &policy.FlowQuery{
+				Direction: policy.IncomingFlow,
+				RemoteIP:  "192.168.1.1",
+				Port:      80,
+			})
+			So(err, ShouldBeNil)
+			So(decision.Action, ShouldEqual, policy.Accept)
+			So(decision.PolicyID, ShouldEqual, "acl")
+			So(decision.Source, ShouldEqual, policy.NetworkACLs)
+			So(decision.IPRule, ShouldNotBeNil)
+			So(decision.IPRule.Address, ShouldEqual, "192.168.0.0/16")
+		})
+	})
+}
+
+func TestEvaluatePUPolicy(t *testing.T) {
+
+	Convey("Given a policy with receiver rules, transmitter rules and ACLs", t, func() {
+
+		appEqWeb := policy.KeyValueOperator{Key: "app", Value: []string{"web"}, Operator: policy.Equal}
+		envEqDev := policy.KeyValueOperator{Key: "env", Value: []string{"dev"}, Operator: policy.Equal}
+		port443 := policy.KeyValueOperator{Key: PortNumberLabelString, Value: []string{"443"}, Operator: policy.Equal}
+
+		rxRules := policy.TagSelectorList{
+			{
+				Clause: []policy.KeyValueOperator{appEqWeb},
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "rx-accept"},
+			},
+			{
+				Clause: []policy.KeyValueOperator{envEqDev},
+				Policy: &policy.FlowPolicy{Action: policy.Reject, PolicyID: "rx-reject"},
+			},
+			{
+				Clause: []policy.KeyValueOperator{port443},
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "rx-port"},
+			},
+		}
+
+		txRules := policy.TagSelectorList{
+			{
+				Clause: []policy.KeyValueOperator{appEqWeb},
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "tx-accept"},
+			},
+			{
+				Clause: []policy.KeyValueOperator{envEqDev},
+				Policy: &policy.FlowPolicy{Action: policy.Reject, PolicyID: "tx-reject"},
+			},
+		}
+
+		appACLs := policy.IPRuleList{
+			{
+				Address:  "10.0.0.0/8",
+				Port:     "80",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "app-tcp"},
+			},
+			{
+				Address:  "10.0.0.0/8",
+				Port:     "53",
+				Protocol: "udp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "app-udp"},
+			},
+			{
+				Address:  "10.0.0.0/8",
+				Protocol: "icmp",
+				ICMPType: "8",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "app-ping"},
+			},
+		}
+
+		netACLs := policy.IPRuleList{
+			{
+				Address:  "2001:db8::/32",
+				Port:     "22",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject, PolicyID: "net-v6"},
+			},
+			{
+				Address:  "192.168.0.0/16",
+				Protocol: "gre",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "net-gre"},
+			},
+		}
+
+		puPolicy := policy.NewPUPolicy("pu", policy.Police, appACLs, netACLs, txRules, rxRules, nil, nil, nil, []string{}, []string{})
+
+		web := policy.NewTagStoreFromMap(map[string]string{"app": "web"})
+		webDev := policy.NewTagStoreFromMap(map[string]string{"app": "web", "env": "dev"})
+		db := policy.NewTagStoreFromMap(map[string]string{"app": "db"})
+
+		Convey("When I evaluate an incoming flow matching an accept rule, it should be accepted", func() {
+			d, err := EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.IncomingFlow, RemoteTags: web, Port: 80}, false)
+			So(err, ShouldBeNil)
+			So(d.Action, ShouldEqual, policy.Accept)
+			So(d.PolicyID, ShouldEqual, "rx-accept")
+			So(d.Source, ShouldEqual, policy.AcceptRcvRules)
+		})
+
+		Convey("When I evaluate an incoming flow matching a reject rule, the reject rule should win", func() {
+			d, err := EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.IncomingFlow, RemoteTags: webDev, Port: 80}, false)
+			So(err, ShouldBeNil)
+			So(d.Action, ShouldEqual, policy.Reject)
+			So(d.PolicyID, ShouldEqual, "rx-reject")
+			So(d.Source, ShouldEqual, policy.RejectRcvRules)
+			So(d.TagSelector.Clause, ShouldResemble, []policy.KeyValueOperator{envEqDev})
+		})
+
+		Convey("When an accept rule of a higher priority matches, it should win over the reject rule", func() {
+			priorityRules := append(policy.TagSelectorList{
+				{
+					Clause:   []policy.KeyValueOperator{appEqWeb, envEqDev},
+					Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "rx-web-dev"},
+					Priority: 10,
+				},
+			}, rxRules...)
+			priorityPolicy := policy.NewPUPolicy("pu", policy.Police, appACLs, netACLs, txRules, priorityRules, nil, nil, nil, []string{}, []string{})
+
+			d, err := EvaluatePUPolicy(priorityPolicy, &policy.FlowQuery{Direction: policy.IncomingFlow, RemoteTags: webDev, Port: 80}, false)
+			So(err, ShouldBeNil)
+			So(d.Action, ShouldEqual, policy.Accept)
+			So(d.PolicyID, ShouldEqual, "rx-web-dev")
+			So(d.Source, ShouldEqual, policy.AcceptRcvRules)
+
+			d, err = EvaluatePUPolicy(priorityPolicy, &policy.FlowQuery{Direction: policy.IncomingFlow, RemoteTags: policy.NewTagStoreFromMap(map[string]string{"app": "db", "env": "dev"}), Port: 80}, false)
+			So(err, ShouldBeNil)
+			So(d.PolicyID, ShouldEqual, "rx-reject")
+		})
+
+		Convey("When I evaluate an incoming flow to a port with a rule, it should be matched by port", func() {
+			d, err := EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.IncomingFlow, RemoteTags: db, Port: 443}, false)
+			So(err, ShouldBeNil)
+			So(d.PolicyID, ShouldEqual, "rx-port")
+			So(db.GetSlice(), ShouldHaveLength, 1)
+		})
+
+		Convey("When I evaluate an incoming flow without a matching rule, it should be rejected", func() {
+			d, err := EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.IncomingFlow, RemoteTags: db, Port: 80}, false)
+			So(err, ShouldBeNil)
+			So(d.Action, ShouldEqual, policy.Reject)
+			So(d.Source, ShouldEqual, policy.DefaultDecision)
+			So(d.TagSelector, ShouldBeNil)
+		})
+
+		Convey("When I evaluate outgoing flows without mutual authorization, they should be accepted", func() {
+			d, err := EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.OutgoingFlow, RemoteTags: db, Port: 80}, false)
+			So(err, ShouldBeNil)
+			So(d.Action, ShouldEqual, policy.Accept)
+			So(d.Source, ShouldEqual, policy.DefaultDecision)
+
+			d, err = EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.OutgoingFlow, RemoteTags: webDev, Port: 80}, false)
+			So(err, ShouldBeNil)
+			So(d.Action, ShouldEqual, policy.Accept)
+			So(d.Source, ShouldEqual, policy.AcceptTxtRules)
+		})
+
+		Convey("When I evaluate outgoing flows with mutual authorization, the transmitter rules should apply", func() {
+			d, err := EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.OutgoingFlow, RemoteTags: web, Port: 80}, true)
+			So(err, ShouldBeNil)
+			So(d.PolicyID, ShouldEqual, "tx-accept")
+			So(d.Source, ShouldEqual, policy.AcceptTxtRules)
+
+			d, err = EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.OutgoingFlow, RemoteTags: webDev, Port: 80}, true)
+			So(err, ShouldBeNil)
+			So(d.PolicyID, ShouldEqual, "tx-reject")
+			So(d.Source, ShouldEqual, policy.RejectTxtRules)
+
+			d, err = EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.OutgoingFlow, RemoteTags: db, Port: 80}, true)
+			So(err, ShouldBeNil)
+			So(d.Action, ShouldEqual, policy.Reject)
+			So(d.Source, ShouldEqual, policy.DefaultDecision)
+		})
+
+		Convey("When I evaluate outgoing flows to external addresses, the application ACLs of the protocol should apply", func() {
+			d, err := EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.OutgoingFlow, RemoteIP: "10.1.1.1", Port: 80}, false)
+			So(err, ShouldBeNil)
+			So(d.PolicyID, ShouldEqual, "app-tcp")
+			So(d.Source, ShouldEqual, policy.ApplicationACLs)
+			So(d.IPRule.Protocol, ShouldEqual, "tcp")
+
+			d, err = EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.OutgoingFlow, Protocol: "UDP", RemoteIP: "10.1.1.1", Port: 53}, false)
+			So(err, ShouldBeNil)
+			So(d.PolicyID, ShouldEqual, "app-udp")
+
+			d, err = EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.OutgoingFlow, RemoteIP: "10.1.1.1", Port: 53}, false)
+			So(err, ShouldBeNil)
+			So(d.Action, ShouldEqual, policy.Reject)
+			So(d.Source, ShouldEqual, policy.DefaultDecision)
+			So(d.IPRule, ShouldBeNil)
+		})
+
+		Convey("When I evaluate ICMP flows, the ACLs of their type and code should apply", func() {
+			d, err := EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.OutgoingFlow, Protocol: "icmp", RemoteIP: "10.1.1.1", ICMPType: 8}, false)
+			So(err, ShouldBeNil)
+			So(d.PolicyID, ShouldEqual, "app-ping")
+			So(d.Source, ShouldEqual, policy.ApplicationACLs)
+
+			d, err = EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.OutgoingFlow, Protocol: "1", RemoteIP: "10.1.1.1", ICMPType: 3, ICMPCode: 4}, false)
+			So(err, ShouldBeNil)
+			So(d.Action, ShouldEqual, policy.Reject)
+			So(d.Source, ShouldEqual, policy.DefaultDecision)
+
+			d, err = EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.IncomingFlow, Protocol: "icmp", RemoteIP: "10.1.1.1", ICMPType: 8}, false)
+			So(err, ShouldBeNil)
+			So(d.Source, ShouldEqual, policy.DefaultDecision)
+		})
+
+		Convey("When I evaluate flows of protocols without ports, the ACLs of the protocol should apply", func() {
+			d, err := EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.IncomingFlow, Protocol: "47", RemoteIP: "192.168.1.1"}, false)
+			So(err, ShouldBeNil)
+			So(d.PolicyID, ShouldEqual, "net-gre")
+			So(d.Source, ShouldEqual, policy.NetworkACLs)
+
+			d, err = EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.IncomingFlow, Protocol: "sctp", RemoteIP: "192.168.1.1", Port: 80}, false)
+			So(err, ShouldBeNil)
+			So(d.Action, ShouldEqual, policy.Reject)
+			So(d.Source, ShouldEqual, policy.DefaultDecision)
+		})
+
+		Convey("When I evaluate an incoming flow from an IPv6 address, the network ACLs should apply", func() {
+			d, err := EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Direction: policy.IncomingFlow, RemoteIP: "2001:db8::1", Port: 22}, false)
+			So(err, ShouldBeNil)
+			So(d.Action, ShouldEqual, policy.Reject)
+			So(d.PolicyID, ShouldEqual, "net-v6")
+			So(d.Source, ShouldEqual, policy.NetworkACLs)
+		})
+
+		Convey("When I evaluate invalid flows, I should get errors", func() {
+			_, err := EvaluatePUPolicy(puPolicy, &policy.FlowQuery{RemoteIP: "invalid", Port: 80}, false)
+			So(err, ShouldNotBeNil)
+
+			_, err = EvaluatePUPolicy(puPolicy, &policy.FlowQuery{Protocol: "unknown", RemoteIP: "10.1.1.1", Port: 80}, false)
+			So(err, ShouldNotBeNil)
+
+			_, err = EvaluatePUPolicy(puPolicy, nil, false)
+			So(err, ShouldNotBeNil)
+
+			_, err = EvaluatePUPolicy(nil, &policy.FlowQuery{RemoteIP: "10.1.1.1", Port: 80}, false)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
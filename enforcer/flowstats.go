@@ -0,0 +1,238 @@
+package enforcer
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/netlink-go/conntrack"
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+)
+
+// DefaultFlowStatsInterval is the default interval between two reads of the
+// flow counters from conntrack
+const DefaultFlowStatsInterval = 30 * time.Second
+
+// flowStatsExpiry is the number of intervals after which a flow that could not
+// be seen in conntrack is reported as ended
+const flowStatsExpiry = 10
+
+// flowCounters are the packet and byte counters of a flow in both directions
+type flowCounters struct {
+	forwardPackets uint64
+	forwardBytes   uint64
+	reversePackets uint64
+	reverseBytes   uint64
+}
+
+// sub returns the counters accumulated since the previous counters. Counters
+// that went backwards belong to a new conntrack entry and are returned as is.
+func (c flowCounters) sub(previous flowCounters) flowCounters {
+
+	delta := func(current, previous uint64) uint64 {
+		if current < previous {
+			return current
+		}
+		return current - previous
+	}
+
+	return flowCounters{
+		forwardPackets: delta(c.forwardPackets, previous.forwardPackets),
+		forwardBytes:   delta(c.forwardBytes, previous.forwardBytes),
+		reversePackets: delta(c.reversePackets, previous.reversePackets),
+		reverseBytes:   delta(c.reverseBytes, previous.reverseBytes),
+	}
+}
+
+// flowCounterReader returns the counters of all the flows known to conntrack,
+// keyed by the tuple of the original direction of the flow
+type flowCounterReader func() (map[string]flowCounters, error)
+
+// flowKey returns the key of a flow tuple
+func flowKey(protocol uint8, sourceIP string, sourcePort uint16, destinationIP string, destinationPort uint16) string {
+
+	return strconv.Itoa(int(protocol)) + ":" + sourceIP + ":" + strconv.Itoa(int(sourcePort)) + ":" + destinationIP + ":" + strconv.Itoa(int(destinationPort))
+}
+
+// conntrackCounterReader reads the flow counters with the given conntrack handle.
+// Counters are only maintained by the kernel when nf_conntrack_acct is set.
+func conntrackCounterReader(hdl conntrack.Conntrack) flowCounterReader {
+
+	return func() (map[string]flowCounters, error) {
+
+		flows, err := hdl.ConntrackTableList(netlink.ConntrackTable)
+		if err != nil {
+			return nil, err
+		}
+
+		counters := make(map[string]flowCounters, len(flows))
+		for _, f := range flows {
+			key := flowKey(f.Forward.Protocol, f.Forward.SrcIP.String(), f.Forward.SrcPort, f.Forward.DstIP.String(), f.Forward.DstPort)
+			counters[key] = flowCounters{
+				forwardPackets: f.Forward.Packets,
+				forwardBytes:   f.Forward.Bytes,
+				reversePackets: f.Reverse.Packets,
+				reverseBytes:   f.Reverse.Bytes,
+			}
+		}
+
+		return counters, nil
+	}
+}
+
+// trackedFlow is an accepted flow whose volume is reported
+type trackedFlow struct {
+	key      string
+	record   collector.FlowRecord
+	reported flowCounters
+	seen     time.Time
+}
+
+// flowStats tracks the accepted flows and periodically reports the packets
+// and bytes they exchanged. A flow that is no longer known to conntrack has
+// ended and is reported a last time with its end time. Flows are also expired
+// when the counters cannot be read for too long.
+type flowStats struct {
+	interval     time.Duration
+	collector    collector.EventCollector
+	readCounters flowCounterReader
+	flows        map[string]*trackedFlow
+	stopCh       chan struct{}
+	sync.Mutex
+}
+
+// newFlowStats creates a flow volume reporter that reads the counters every interval
+func newFlowStats(interval time.Duration, readCounters flowCounterReader, collector collector.EventCollector) *flowStats {
+
+	return &flowStats{
+		interval:     interval,
+		collector:    collector,
+		readCounters: readCounters,
+		flows:        map[string]*trackedFlow{},
+	}
+}
+
+// track starts reporting the volume of the flow of an accepted flow record.
+// The tuple is the original direction of the flow in conntrack.
+func (f *flowStats) track(record *collector.FlowRecord, protocol uint8, sourceIP string, sourcePort uint16, destinationIP string, destinationPort uint16) {
+
+	key := flowKey(protocol, sourceIP, sourcePort, destinationIP, destinationPort)
+
+	f.Lock()
+	defer f.Unlock()
+
+	if _, ok := f.flows[record.ContextID+":"+key]; ok {
+		return
+	}
+
+	flow := &trackedFlow{
+		key:    key,
+		record: *record,
+		seen:   time.Now(),
+	}
+	flow.record.Count = 0
+	flow.record.Volume = true
+
+	f.flows[record.ContextID+":"+key] = flow
+}
+
+// start reads the counters every interval until stop is called
+func (f *flowStats) start() {
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.stopCh != nil {
+		return
+	}
+	f.stopCh = make(chan struct{})
+
+	go f.run(f.stopCh)
+}
+
+func (f *flowStats) run(stopCh chan struct{}) {
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.update(time.Now())
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// stop stops the periodic reads of the counters
+func (f *flowStats) stop() {
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.stopCh != nil {
+		close(f.stopCh)
+		f.stopCh = nil
+	}
+}
+
+// update reads the counters and reports the volume of the tracked flows since
+// the previous update. Flows that are gone from conntrack are reported as ended.
+// If the counters cannot be read, the flows that were not seen for too long are
+// reported as ended when they were last seen.
+func (f *flowStats) update(now time.Time) {
+
+	counters, err := f.readCounters()
+	if err != nil {
+		zap.L().Debug("Unable to read the flow counters", zap.Error(err))
+	}
+
+	records := []*collector.FlowRecord{}
+
+	f.Lock()
+	for id, flow := range f.flows {
+
+		if err != nil {
+			if now.Sub(flow.seen) >= flowStatsExpiry*f.interval {
+				record := flow.record
+				record.EndTime = flow.seen
+				records = append(records, &record)
+				delete(f.flows, id)
+			}
+			continue
+		}
+
+		current, ok := counters[flow.key]
+		if !ok {
+			record := flow.record
+			record.EndTime = now
+			records = append(records, &record)
+			delete(f.flows, id)
+			continue
+		}
+
+		flow.seen = now
+
+		delta := current.sub(flow.reported)
+		if delta == (flowCounters{}) {
+			continue
+		}
+
+		record := flow.record
+		record.ForwardPackets = delta.forwardPackets
+		record.ForwardBytes = delta.forwardBytes
+		record.ReversePackets = delta.reversePackets
+		record.ReverseBytes = delta.reverseBytes
+		records = append(records, &record)
+
+		flow.reported = current
+	}
+	f.Unlock()
+
+	for _, record := range records {
+		f.collector.CollectFlowEvent(record)
+	}
+}
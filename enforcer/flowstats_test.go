@@ -0,0 +1,140 @@
+package enforcer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFlowStats(t *testing.T) {
+
+	Convey("Given a flow stats reporter and an accepted flow", t, func() {
+
+		counters := map[string]flowCounters{}
+		var readErr error
+		recorder := &flowRecorder{}
+
+		f := newFlowStats(time.Second, func() (map[string]flowCounters, error) {
+			return counters, readErr
+		}, recorder)
+
+		start := time.Now()
+		accepted := &collector.FlowRecord{
+			ContextID:   "pu1",
+			Count:       1,
+			Source:      &collector.EndPoint{ID: "pu2", IP: "10.0.0.2", Port: 4000, Type: collector.PU},
+			Destination: &collector.EndPoint{ID: "pu1", IP: "10.0.0.1", Port: 80, Type: collector.PU},
+			Action:      policy.Accept,
+			DropReason:  "NA",
+			StartTime:   start,
+		}
+		f.track(accepted, 6, "10.0.0.2", 4000, "10.0.0.1", 80)
+		key := flowKey(6, "10.0.0.2", 4000, "10.0.0.1", 80)
+
+		Convey("When the counters of the flow increase", func() {
+
+			counters[key] = flowCounters{forwardPackets: 10, forwardBytes: 1000, reversePackets: 5, reverseBytes: 5000}
+			f.update(start.Add(time.Second))
+			counters[key] = flowCounters{forwardPackets: 12, forwardBytes: 1200, reversePackets: 6, reverseBytes: 6000}
+			f.update(start.Add(2 * time.Second))
+
+			Convey("Then I should see volume records with the counters since the previous report", func() {
+				So(len(recorder.flows), ShouldEqual, 2)
+				So(recorder.flows[0].Volume, ShouldBeTrue)
+				So(recorder.flows[0].Count, ShouldEqual, 0)
+				So(recorder.flows[0].StartTime, ShouldResemble, start)
+				So(recorder.flows[0].EndTime.IsZero(), ShouldBeTrue)
+				So(recorder.flows[0].ForwardPackets, ShouldEqual, 10)
+				So(recorder.flows[0].ReverseBytes, ShouldEqual, 5000)
+				So(recorder.flows[1].ForwardPackets, ShouldEqual, 2)
+				So(recorder.flows[1].ForwardBytes, ShouldEqual, 200)
+				So(recorder.flows[1].ReversePackets, ShouldEqual, 1)
+				So(recorder.flows[1].ReverseBytes, ShouldEqual, 1000)
+			})
+
+			Convey("Then the accepted record should not be modified", func() {
+				So(accepted.DropReason, ShouldEqual, "NA")
+				So(accepted.Count, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the counters of the flow do not change", func() {
+
+			counters[key] = flowCounters{forwardPackets: 10}
+			f.update(start.Add(time.Second))
+			f.update(start.Add(2 * time.Second))
+
+			Convey("Then I should see a single volume record", func() {
+				So(len(recorder.flows), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the flow is gone from conntrack", func() {
+
+			counters[key] = flowCounters{forwardPackets: 10}
+			f.update(start.Add(time.Second))
+			delete(counters, key)
+			f.update(start.Add(5 * time.Second))
+			f.update(start.Add(6 * time.Second))
+
+			Convey("Then I should see a last record with the duration of the flow", func() {
+				So(len(recorder.flows), ShouldEqual, 2)
+				So(recorder.flows[1].EndTime, ShouldResemble, start.Add(5*time.Second))
+				So(recorder.flows[1].Duration(), ShouldEqual, 5*time.Second)
+				So(recorder.flows[1].ForwardPackets, ShouldEqual, 0)
+				So(len(f.flows), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the flow is tracked twice", func() {
+
+			f.track(accepted, 6, "10.0.0.2", 4000, "10.0.0.1", 80)
+
+			Convey("Then it should be tracked once", func() {
+				So(len(f.flows), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the counters cannot be read", func() {
+
+			readErr = fmt.Errorf("error")
+			f.update(start.Add(time.Second))
+
+			Convey("Then the flow should not be reported as ended", func() {
+				So(len(recorder.flows), ShouldEqual, 0)
+				So(len(f.flows), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the counters cannot be read for too long", func() {
+
+			counters[key] = flowCounters{forwardPackets: 10}
+			seen := time.Now().Add(time.Second)
+			f.update(seen)
+			readErr = fmt.Errorf("error")
+			f.update(seen.Add(5 * time.Second))
+			f.update(seen.Add(flowStatsExpiry * time.Second))
+
+			Convey("Then the flow should be reported as ended when it was last seen", func() {
+				So(len(recorder.flows), ShouldEqual, 2)
+				So(recorder.flows[1].EndTime, ShouldResemble, seen)
+				So(len(f.flows), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I start and stop the reporter", func() {
+
+			f.start()
+			f.stop()
+			f.stop()
+
+			Convey("Then it should be stopped", func() {
+				So(f.stopCh, ShouldBeNil)
+			})
+		})
+	})
+}
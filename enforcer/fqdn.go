@@ -0,0 +1,120 @@
+package enforcer
+
+import (
+	"net"
+
+	"github.com/aporeto-inc/trireme/enforcer/acls"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// fqdnOwnerPrefix is the prefix of the owners of the names of the processing
+// units on the watcher, that is shared with the supervisors of the process
+const fqdnOwnerPrefix = "enforcer/"
+
+// watchFQDNs follows the addresses of the DNS names of the application ACLs
+// of a processing unit. The new names are resolved before it returns, so it
+// must not be called with the context locked.
+func (d *Datapath) watchFQDNs(contextID string, puPolicy *policy.PUPolicy) {
+
+	if d.fqdnWatcher == nil {
+		return
+	}
+
+	d.fqdnWatcher.Watch(fqdnOwnerPrefix+contextID, puPolicy.ApplicationACLs().FQDNs())
+}
+
+// setFQDNAddresses sets the current addresses of the DNS names in the
+// application ACLs of the context. The context must be locked.
+func (d *Datapath) setFQDNAddresses(context *PUContext) {
+
+	if d.fqdnWatcher == nil {
+		return
+	}
+
+	for _, cache := range applicationACLCaches(context) {
+		for _, name := range cache.FQDNs() {
+			cache.SetAddresses(name, d.fqdnWatcher.Addresses(name))
+		}
+	}
+}
+
+// updateFQDN updates the application ACLs of all the processing units when the
+// addresses of a DNS name change
+func (d *Datapath) updateFQDN(name string, ips []net.IP) {
+
+	for _, key := range d.contextTracker.KeyList() {
+
+		item, err := d.contextTracker.Get(key)
+		if err != nil {
+			continue
+		}
+
+		context := item.(*PUContext)
+		context.Lock()
+		for _, cache := range applicationACLCaches(context) {
+			cache.SetAddresses(name, ips)
+		}
+		context.Unlock()
+	}
+}
+
+// applicationACLCaches returns the caches of the application ACLs of all the
+// protocols of the context
+func applicationACLCaches(context *PUContext) []*acls.ACLCache {
+
+	caches := []*acls.ACLCache{}
+
+	for _, cache := range []*acls.ACLCache{context.ApplicationACLs, context.UDPApplicationACLs} {
+		if cache != nil {
+			caches = append(caches, cache)
+		}
+	}
+
+	for _, cache := range context.ProtocolApplicationACLs {
+		caches = append(caches, cache)
+	}
+
+	return caches
+}
+
+// fqdnDelegate returns the DNS name of the application ACL of a processing unit
+// that matches the address and port of an external service
+func (d *Datapath) fqdnDelegate(contextID string, ip net.IP, port uint16) string {
+
+	item, err := d.contextTracker.Get(contextID)
+	if err != nil {
+		return ""
+	}
+
+	context := item.(*PUContext)
+	context.Lock()
+	defer context.Unlock()
+
+	if name := matchedFQDN(context, packet.IPProtocolTCP, ip, port); name != "" {
+		return name
+	}
+
+	return matchedFQDN(context, packet.IPProtocolUDP, ip, port)
+}
+
+// matchedFQDN returns the DNS name of the application ACL that matches the
+// remote address and port of a flow, or an empty string if the ACL has none
+func matchedFQDN(context *PUContext, protocol uint8, ip net.IP, port uint16) string {
+
+	cache := context.ApplicationACLs
+	if protocol == packet.IPProtocolUDP {
+		cache = context.UDPApplicationACLs
+	}
+
+	if cache == nil {
+		return ""
+	}
+
+	rule, err := cache.GetMatchingRule(ip, port)
+	if err != nil {
+		return ""
+	}
+
+	return rule.FQDN()
+}
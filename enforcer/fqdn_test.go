@@ -0,0 +1,83 @@
+package enforcer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqdn"
+	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testResolver resolves the names from a map
+type testResolver map[string][]net.IP
+
+func (r testResolver) Resolve(name string) ([]net.IP, time.Duration, error) {
+	return r[name], time.Minute, nil
+}
+
+func TestFQDNACLs(t *testing.T) {
+
+	Convey("Given I create an enforcer with a processing unit that accepts UDP flows to a DNS name", t, func() {
+
+		recorder := &flowRecorder{}
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		enforcer := NewWithDefaults("SomeServerId", recorder, nil, secret, constants.LocalContainer, "/proc").(*Datapath)
+		enforcer.fqdnWatcher = fqdn.NewWatcher(testResolver{"api.example.com": {net.ParseIP("192.168.1.1")}})
+
+		appACLs := policy.IPRuleList{
+			{
+				Address:  "api.example.com",
+				Port:     "53",
+				Protocol: "udp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "fqdn"},
+			},
+		}
+
+		puInfo := policy.NewPUInfo("fqdnpu", constants.ContainerPU)
+		puInfo.Runtime.SetIPAddresses(policy.ExtendedMap{"bridge": testIP1})
+		puInfo = policy.PUInfoFromPolicyAndRuntime(
+			"fqdnpu",
+			policy.NewPUPolicy("fqdnpu", policy.Police, appACLs, nil, nil, nil, nil, nil, policy.ExtendedMap{policy.DefaultNamespace: testIP1}, []string{}, []string{}),
+			puInfo.Runtime,
+		)
+		So(enforcer.Enforce("fqdnpu", puInfo), ShouldBeNil)
+
+		Convey("When the PU sends a packet to an address of the name, it should be accepted and reported with the name", func() {
+			So(enforcer.processApplicationUDPPackets(createUDPTestPacket(testIP1, "192.168.1.1", 12345, 53, []byte("query"))), ShouldBeNil)
+
+			So(recorder.flows, ShouldHaveLength, 1)
+			So(recorder.flows[0].Action, ShouldEqual, policy.Accept)
+			So(recorder.flows[0].PolicyID, ShouldEqual, "fqdn")
+			So(recorder.flows[0].Destination.FQDN, ShouldEqual, "api.example.com")
+		})
+
+		Convey("When the PU sends a packet to an address of the name, the volume of the flow should be tracked", func() {
+			So(enforcer.processApplicationUDPPackets(createUDPTestPacket(testIP1, "192.168.1.1", 12345, 53, []byte("query"))), ShouldBeNil)
+
+			So(enforcer.flowStats.flows, ShouldHaveLength, 1)
+			So(enforcer.flowStats.flows, ShouldContainKey, "fqdnpu:"+flowKey(17, testIP1, 12345, "192.168.1.1", 53))
+		})
+
+		Convey("When the addresses of the name change", func() {
+			enforcer.updateFQDN("api.example.com", []net.IP{net.ParseIP("192.168.1.2")})
+
+			Convey("Then the packets to the new address should be accepted with the name", func() {
+				So(enforcer.processApplicationUDPPackets(createUDPTestPacket(testIP1, "192.168.1.2", 12345, 53, []byte("query"))), ShouldBeNil)
+
+				So(recorder.flows, ShouldHaveLength, 1)
+				So(recorder.flows[0].PolicyID, ShouldEqual, "fqdn")
+				So(recorder.flows[0].Destination.FQDN, ShouldEqual, "api.example.com")
+			})
+
+			Convey("Then the packets to the previous address should not match the name", func() {
+				So(enforcer.processApplicationUDPPackets(createUDPTestPacket(testIP1, "192.168.1.1", 12346, 53, []byte("query"))), ShouldBeNil)
+
+				So(recorder.flows, ShouldBeEmpty)
+			})
+		})
+	})
+}
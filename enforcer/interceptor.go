@@ -0,0 +1,126 @@
+package enforcer
+
+// Go libraries
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packetsource"
+	"go.uber.org/zap"
+)
+
+// startInterceptors starts the packet sources of the application and network packets
+func (d *Datapath) startInterceptors() error {
+
+	if d.appSource != nil {
+		if err := d.appSource.Start(d.applicationPacketHandler); err != nil {
+			return err
+		}
+	}
+
+	if d.netSource != nil {
+		if err := d.netSource.Start(d.networkPacketHandler); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stopInterceptors stops the packet sources of the application and network packets
+func (d *Datapath) stopInterceptors() {
+
+	if d.appSource != nil {
+		if err := d.appSource.Stop(); err != nil {
+			zap.L().Error("Unable to stop the application packet source", zap.Error(err))
+		}
+	}
+
+	if d.netSource != nil {
+		if err := d.netSource.Stop(); err != nil {
+			zap.L().Error("Unable to stop the network packet source", zap.Error(err))
+		}
+	}
+}
+
+// networkPacketHandler processes packets arriving from the network
+func (d *Datapath) networkPacketHandler(p *packetsource.Packet) {
+
+	// Parse the packet - drop if parsing fails
+	netPacket, err := packet.New(packet.PacketTypeNetwork, p.Buffer, strconv.Itoa(int(p.Mark)))
+
+	if err != nil {
+		netPacket.Print(packet.PacketFailureCreate)
+	} else {
+		err = d.ProcessNetworkPacket(netPacket)
+	}
+
+	d.setVerdict(p, netPacket, err)
+}
+
+// applicationPacketHandler processes packets arriving from an application and are destined to the network
+func (d *Datapath) applicationPacketHandler(p *packetsource.Packet) {
+
+	// Being liberal on what we transmit - malformed TCP packets are let go
+	// We are strict on what we accept on the other side, but we don't block
+	// lots of things at the ingress to the network
+	appPacket, err := packet.New(packet.PacketTypeApplication, p.Buffer, strconv.Itoa(int(p.Mark)))
+
+	if err != nil {
+		appPacket.Print(packet.PacketFailureCreate)
+	} else {
+		err = d.ProcessApplicationPacket(appPacket)
+	}
+
+	d.setVerdict(p, appPacket, err)
+}
+
+// ProcessNetworkPacket processes a parsed packet arriving from the network. The
+// packet is modified in place and must be dropped if an error is returned.
+func (d *Datapath) ProcessNetworkPacket(p *packet.Packet) error {
+
+	switch p.IPProto {
+	case packet.IPProtocolTCP:
+		return d.processNetworkTCPPackets(p)
+	case packet.IPProtocolUDP:
+		return d.processNetworkUDPPackets(p)
+	default:
+		return fmt.Errorf("Invalid IP Protocol %d", p.IPProto)
+	}
+}
+
+// ProcessApplicationPacket processes a parsed packet arriving from an application.
+// The packet is modified in place and must be dropped if an error is returned.
+func (d *Datapath) ProcessApplicationPacket(p *packet.Packet) error {
+
+	switch p.IPProto {
+	case packet.IPProtocolTCP:
+		return d.processApplicationTCPPackets(p)
+	case packet.IPProtocolUDP:
+		return d.processApplicationUDPPackets(p)
+	default:
+		return fmt.Errorf("Invalid IP Protocol %d", p.IPProto)
+	}
+}
+
+// setVerdict drops the packet if the processing failed. Otherwise it accepts the
+// packet with any options and data attached by the datapath.
+func (d *Datapath) setVerdict(p *packetsource.Packet, processed *packet.Packet, err error) {
+
+	if err != nil {
+		if verr := p.Sink.SetVerdict(p, false, p.Buffer); verr != nil {
+			zap.L().Debug("Unable to drop packet", zap.Error(verr))
+		}
+		return
+	}
+
+	buffer := make([]byte, len(processed.Buffer)+processed.TCPOptionLength()+processed.TCPDataLength())
+	copyIndex := copy(buffer, processed.Buffer)
+	copyIndex += copy(buffer[copyIndex:], processed.GetTCPOptions())
+	copyIndex += copy(buffer[copyIndex:], processed.GetTCPData())
+
+	if verr := p.Sink.SetVerdict(p, true, buffer[:copyIndex]); verr != nil {
+		zap.L().Debug("Unable to accept packet", zap.Error(verr))
+	}
+}
@@ -0,0 +1,126 @@
+package enforcer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packetsource"
+	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// waitVerdict returns the next verdict of a channel source
+func waitVerdict(source *packetsource.ChannelSource) *packetsource.Verdict {
+
+	select {
+	case verdict := <-source.Verdicts():
+		return verdict
+	case <-time.After(5 * time.Second):
+		return nil
+	}
+}
+
+// transmitThroughSources injects a packet in the application source and the
+// accepted packet in the network source. It returns the verdicts of both sources.
+func transmitThroughSources(app, net *packetsource.ChannelSource, p *packet.Packet) (*packetsource.Verdict, *packetsource.Verdict) {
+
+	_, err := app.Inject(p.GetBytes(), 0)
+	So(err, ShouldBeNil)
+
+	appVerdict := waitVerdict(app)
+	So(appVerdict, ShouldNotBeNil)
+	if !appVerdict.Accept {
+		return appVerdict, nil
+	}
+
+	_, err = net.Inject(appVerdict.Buffer, 0)
+	So(err, ShouldBeNil)
+
+	netVerdict := waitVerdict(net)
+	So(netVerdict, ShouldNotBeNil)
+
+	return appVerdict, netVerdict
+}
+
+func TestPacketSources(t *testing.T) {
+
+	Convey("Given I create an enforcer with channel packet sources", t, func() {
+
+		rules := policy.TagSelectorList{
+			{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept},
+			},
+		}
+
+		app := packetsource.NewChannelSource(10)
+		net := packetsource.NewChannelSource(10)
+		recorder := &flowRecorder{}
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+
+		enforcer := NewWithPacketSources("SomeServerId", recorder, nil, secret, constants.LocalContainer, "/proc", app, net).(*Datapath)
+		err1, err2 := enforceTestProcessingUnits(enforcer, rules, nil)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+		So(enforcer.Start(), ShouldBeNil)
+
+		Convey("When I complete a handshake and send data through the sources", func() {
+
+			synApp, synNet := transmitThroughSources(app, net, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1000, 0, true, nil))
+			synAckApp, synAckNet := transmitThroughSources(app, net, createTCPTestPacket(testIP2, testIP1, 80, 2000, 5000, 1001, true, nil))
+			ackApp, ackNet := transmitThroughSources(app, net, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1001, 5001, false, nil))
+
+			Convey("Then all the packets should be accepted", func() {
+				So(synApp.Accept, ShouldBeTrue)
+				So(synNet.Accept, ShouldBeTrue)
+				So(synAckApp.Accept, ShouldBeTrue)
+				So(synAckNet.Accept, ShouldBeTrue)
+				So(ackApp.Accept, ShouldBeTrue)
+				So(ackNet.Accept, ShouldBeTrue)
+			})
+
+			Convey("Then the tokens should be added on the wire and removed on delivery", func() {
+				So(len(synApp.Buffer), ShouldBeGreaterThan, len(synNet.Buffer))
+
+				delivered, err := packet.New(0, synNet.Buffer, "0")
+				So(err, ShouldBeNil)
+				So(delivered.TCPDataLength(), ShouldEqual, 0)
+				So(delivered.VerifyTCPChecksum(), ShouldBeTrue)
+			})
+
+			Convey("Then the flow should be reported", func() {
+				So(len(recorder.flows), ShouldEqual, 1)
+				So(recorder.flows[0].Action.Accepted(), ShouldBeTrue)
+			})
+
+			So(enforcer.Stop(), ShouldBeNil)
+		})
+
+		Convey("When I send a packet of an unknown protocol", func() {
+
+			p := createTCPTestPacket(testIP1, testIP2, 2000, 80, 1000, 0, true, nil)
+			buffer := p.GetBytes()
+			buffer[9] = 47
+
+			_, err := app.Inject(buffer, 0)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be dropped", func() {
+				verdict := waitVerdict(app)
+				So(verdict, ShouldNotBeNil)
+				So(verdict.Accept, ShouldBeFalse)
+			})
+
+			So(enforcer.Stop(), ShouldBeNil)
+		})
+	})
+}
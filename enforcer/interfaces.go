@@ -1,10 +1,12 @@
 package enforcer
 
 import (
+	"crypto/ecdsa"
 	"sync"
 	"time"
 
 	"github.com/aporeto-inc/trireme/cache"
+	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/constants"
 	"github.com/aporeto-inc/trireme/enforcer/acls"
 	"github.com/aporeto-inc/trireme/enforcer/lookup"
@@ -27,6 +29,14 @@ type PolicyEnforcer interface {
 	// GetFilterQueue returns the current FilterQueueConfig.
 	GetFilterQueue() *fqconfig.FilterQueue
 
+	// Connections returns the connections of the given contextID that are
+	// currently tracked by the PolicyEnforcer.
+	Connections(contextID string) ([]*collector.ConnectionRecord, error)
+
+	// EvaluateFlow returns the decision of the policy of the given contextID
+	// for a flow, without processing any packet.
+	EvaluateFlow(contextID string, query *policy.FlowQuery) (*policy.FlowDecision, error)
+
 	// Start starts the PolicyEnforcer.
 	Start() error
 
@@ -74,10 +84,31 @@ type PUContext struct {
 	externalIPCache cache.DataStore
 	Extension       interface{}
 	IP              string
+	IPv6            string
 	Mark            string
 	Ports           []string
 	PUType          constants.PUType
 	synToken        []byte
 	synExpiration   time.Time
+	synEphemeralKey *ecdsa.PrivateKey
+
+	// UDPApplicationACLs and UDPNetworkACLs hold the ACLs for UDP flows
+	UDPApplicationACLs *acls.ACLCache
+	UDPNetworkACLs     *acls.ACLCache
+
+	// ProtocolApplicationACLs and ProtocolNetworkACLs hold the ACLs of the other
+	// protocols by protocol number
+	ProtocolApplicationACLs map[uint8]*acls.ACLCache
+	ProtocolNetworkACLs     map[uint8]*acls.ACLCache
+
+	// RejectAction defines how the connections rejected by the policy are terminated
+	RejectAction policy.RejectAction
+
+	// Audit is set when the policy decisions are reported but not enforced
+	Audit bool
+
+	// Generation is the generation of the policy of the context
+	Generation uint64
+
 	sync.Mutex
 }
@@ -0,0 +1,231 @@
+package lookup
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// ClauseExplanation tells if a clause of a policy is satisfied by a set of tags
+type ClauseExplanation struct {
+	Clause  policy.KeyValueOperator
+	Matched bool
+}
+
+// Explanation describes how a policy of the database was evaluated for a set
+// of tags
+type Explanation struct {
+	// Index is the index of the policy in the database
+	Index int
+	// Selector is the tag selector of the policy
+	Selector policy.TagSelector
+	// Clauses tells which clauses of the selector matched or failed
+	Clauses []*ClauseExplanation
+	// ProtocolMatched and PortMatched are false if the protocol or the
+	// destination port of the flow are not ones of the selector
+	ProtocolMatched bool
+	PortMatched     bool
+	// Matched is true if the policy matched the tags
+	Matched bool
+	// Active is false if the schedule of the policy is not active
+	Active bool
+	// Selected is true for the policy returned by Search
+	Selected bool
+}
+
+// Explain evaluates all the policies of the database for a set of tags. The
+// explanations are returned in priority order, and the policy that Search
+// would return is marked as selected.
+func (m *PolicyDB) Explain(tags *policy.TagStore) []*Explanation {
+
+	s := m.search(tags)
+	slice := tags.GetSlice()
+
+	explanations := make([]*Explanation, 0, len(m.policies))
+
+	for i, p := range m.policies {
+		explanation := &Explanation{
+			Index:           p.index,
+			Selector:        m.selectors[i],
+			ProtocolMatched: protocolMatches(m.selectors[i].Protocols, slice),
+			PortMatched:     portMatches(m.selectors[i].Ports, slice),
+			Matched:         (len(p.tags) > 0 || p.count > 0) && !s.skip[p.index] && s.count[p.index] >= p.count,
+			Active:          s.active(p),
+			Selected:        s.best == p,
+			Clauses:         make([]*ClauseExplanation, len(p.tags)),
+		}
+
+		for j, clause := range p.tags {
+			explanation.Clauses[j] = &ClauseExplanation{
+				Clause:  clause,
+				Matched: m.clauseMatches(clause, slice),
+			}
+		}
+
+		explanations = append(explanations, explanation)
+	}
+
+	sort.SliceStable(explanations, func(i, j int) bool {
+		return explanations[i].Selector.Priority > explanations[j].Selector.Priority
+	})
+
+	return explanations
+}
+
+// clauseMatches evaluates a single clause against the tags
+func (m *PolicyDB) clauseMatches(clause policy.KeyValueOperator, tags []string) bool {
+
+	found := false
+
+	for _, t := range tags {
+		k, v := m.keyValueFromString(t)
+		if k != clause.Key {
+			continue
+		}
+
+		found = true
+
+		if ValueMatches(clause, v) {
+			return true
+		}
+	}
+
+	switch clause.Operator {
+	case policy.KeyExists:
+		return found
+	case policy.KeyNotExists:
+		return !found
+	}
+
+	return false
+}
+
+// protocolMatches returns true if the protocol label of the tags is one of
+// the protocols, or if there are no protocols
+func protocolMatches(protocols []string, tags []string) bool {
+
+	if len(protocols) == 0 {
+		return true
+	}
+
+	for _, t := range tags {
+		if !strings.HasPrefix(t, policy.ProtocolLabel+"=") {
+			continue
+		}
+		for _, p := range protocols {
+			if strings.EqualFold(p, t[len(policy.ProtocolLabel)+1:]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// portMatches returns true if the port label of the tags is in one of the
+// port ranges, or if there are no ranges
+func portMatches(ports []policy.PortRange, tags []string) bool {
+
+	if len(ports) == 0 {
+		return true
+	}
+
+	for _, t := range tags {
+		if !strings.HasPrefix(t, policy.PortLabel+"=") {
+			continue
+		}
+		port, err := strconv.ParseUint(t[len(policy.PortLabel)+1:], 10, 16)
+		if err != nil {
+			continue
+		}
+		for _, r := range ports {
+			if r.Contains(uint16(port)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ValueMatches evaluates a clause against the value of a tag with the key of
+// the clause
+func ValueMatches(clause policy.KeyValueOperator, value string) bool {
+
+	switch clause.Operator {
+
+	case policy.KeyExists:
+		return true
+
+	case policy.KeyNotExists:
+		return false
+
+	case policy.NotEqual, policy.NotIn:
+		for _, v := range clause.Value {
+			if v == value {
+				return false
+			}
+		}
+		return true
+
+	case policy.Matches:
+		for _, v := range clause.Value {
+			if exp, err := compileMatch(v); err == nil && exp.MatchString(value) {
+				return true
+			}
+		}
+		return false
+
+	case policy.GreaterThan, policy.GreaterOrEqual, policy.LessThan, policy.LessOrEqual:
+		number, ok := parseVersion(value)
+		if !ok {
+			return false
+		}
+		for _, v := range clause.Value {
+			threshold, ok := parseVersion(v)
+			if ok && compareMatches(clause.Operator, number.compare(threshold)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, v := range clause.Value {
+		switch {
+		case clause.Operator == policy.Equal && strings.HasSuffix(v, "*"):
+			if strings.HasPrefix(value, v[:len(v)-1]) {
+				return true
+			}
+		case clause.Operator == policy.HasPrefix:
+			if strings.HasPrefix(value, v) {
+				return true
+			}
+		case clause.Operator == policy.Equal || clause.Operator == policy.In:
+			if v == value {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// compareMatches returns true if the result of a comparison satisfies the
+// numeric operator
+func compareMatches(operator policy.Operator, result int) bool {
+
+	switch operator {
+	case policy.GreaterThan:
+		return result > 0
+	case policy.GreaterOrEqual:
+		return result >= 0
+	case policy.LessThan:
+		return result < 0
+	case policy.LessOrEqual:
+		return result <= 0
+	}
+
+	return false
+}
@@ -3,7 +3,9 @@ package lookup
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -12,10 +14,12 @@ import (
 
 // ForwardingPolicy is an instance of the forwarding policy
 type ForwardingPolicy struct {
-	tags    []policy.KeyValueOperator
-	count   int
-	index   int
-	actions interface{}
+	tags     []policy.KeyValueOperator
+	count    int
+	index    int
+	priority int
+	schedule *policy.Schedule
+	actions  interface{}
 }
 
 // intList is a list of integeres
@@ -24,29 +28,57 @@ type intList []int
 //PolicyDB is the structure of a policy
 type PolicyDB struct {
 	// rules    []policy
-	numberOfPolicies       int
-	equalPrefixes          map[string]intList
-	equalMapTable          map[string]map[string][]*ForwardingPolicy
-	notEqualMapTable       map[string]map[string][]*ForwardingPolicy
-	notStarTable           map[string][]*ForwardingPolicy
-	defaultNotExistsPolicy *ForwardingPolicy
+	numberOfPolicies  int
+	equalPrefixes     map[string]intList
+	equalMapTable     map[string]map[string][]*ForwardingPolicy
+	notEqualMapTable  map[string]map[string][]*ForwardingPolicy
+	notEqualValues    map[string][]string
+	notStarTable      map[string][]*ForwardingPolicy
+	notExistsPolicies []*ForwardingPolicy
+	selectors         []policy.TagSelector
+	policies          []*ForwardingPolicy
+	numberOfClauses   int
+	prefixTable       map[string]*prefixIndex
+	inTable           map[string]map[string][]*clauseEntry
+	notInTable        map[string][]*setClause
+	regexTable        map[string][]*regexClause
+	numericTable      map[string]*numericIndex
+	portTable         *portIndex
+	namespace         string
 }
 
 //NewPolicyDB creates a new PolicyDB for efficient search of policies
 func NewPolicyDB() (m *PolicyDB) {
 
 	m = &PolicyDB{
-		numberOfPolicies:       0,
-		equalMapTable:          map[string]map[string][]*ForwardingPolicy{},
-		equalPrefixes:          map[string]intList{},
-		notEqualMapTable:       map[string]map[string][]*ForwardingPolicy{},
-		notStarTable:           map[string][]*ForwardingPolicy{},
-		defaultNotExistsPolicy: nil,
+		numberOfPolicies: 0,
+		equalMapTable:    map[string]map[string][]*ForwardingPolicy{},
+		equalPrefixes:    map[string]intList{},
+		notEqualMapTable: map[string]map[string][]*ForwardingPolicy{},
+		notEqualValues:   map[string][]string{},
+		notStarTable:     map[string][]*ForwardingPolicy{},
+		prefixTable:      map[string]*prefixIndex{},
+		inTable:          map[string]map[string][]*clauseEntry{},
+		notInTable:       map[string][]*setClause{},
+		regexTable:       map[string][]*regexClause{},
+		numericTable:     map[string]*numericIndex{},
+		portTable:        &portIndex{},
 	}
 
 	return m
 }
 
+// NewScopedPolicyDB creates a new PolicyDB for the policies of a processing
+// unit of the namespace. The policies without a namespace only match the
+// tags of the namespace subtree of the processing unit.
+func NewScopedPolicyDB(namespace string) *PolicyDB {
+
+	m := NewPolicyDB()
+	m.namespace = namespace
+
+	return m
+}
+
 func (array intList) sortedInsert(value int) intList {
 	l := len(array)
 	if l == 0 {
@@ -74,19 +106,46 @@ func (array intList) sortedInsert(value int) intList {
 
 }
 
-//AddPolicy adds a policy to the database
+// sortedInsertString inserts a value in a sorted list of strings. The values
+// of the not equal table are kept sorted so that the searches are deterministic.
+func sortedInsertString(list []string, value string) []string {
+
+	i := sort.SearchStrings(list, value)
+	list = append(list, "")
+	copy(list[i+1:], list[i:])
+	list[i] = value
+
+	return list
+}
+
+//AddPolicy adds a policy to the database. The policies with a namespace only
+//match the tags of the namespace subtree. The policies without a namespace
+//are scoped to the namespace of the database.
 func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 
+	clauses, err := scopedClauses(selector, m.namespace)
+
 	// Create a new policy object
 	e := ForwardingPolicy{
-		count:   0,
-		tags:    selector.Clause,
-		actions: selector.Policy,
+		count:    0,
+		tags:     clauses,
+		priority: selector.Priority,
+		actions:  selector.Policy,
+	}
+
+	if selector.Policy != nil {
+		e.schedule = selector.Policy.Schedule
+	}
+
+	// A policy with an invalid namespace is never hit
+	if err != nil {
+		zap.L().Error("Invalid namespace in policy", zap.String("namespace", selector.Namespace), zap.Error(err))
+		e.count++
 	}
 
 	// For each tag of the incoming policy add a mapping between the map tables
 	// and the structure that represents the policy
-	for _, keyValueOp := range selector.Clause {
+	for _, keyValueOp := range clauses {
 
 		switch keyValueOp.Operator {
 
@@ -100,9 +159,6 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 
 		case policy.KeyNotExists:
 			m.notStarTable[keyValueOp.Key] = append(m.notStarTable[keyValueOp.Key], &e)
-			if len(selector.Clause) == 1 {
-				m.defaultNotExistsPolicy = &e
-			}
 
 		case policy.Equal:
 			if _, ok := m.equalMapTable[keyValueOp.Key]; !ok {
@@ -118,19 +174,57 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 			}
 			e.count++
 
-		default: // policy.NotEqual
+		case policy.NotEqual:
 			if _, ok := m.notEqualMapTable[keyValueOp.Key]; !ok {
 				m.notEqualMapTable[keyValueOp.Key] = map[string][]*ForwardingPolicy{}
 			}
 			for _, v := range keyValueOp.Value {
+				if _, ok := m.notEqualMapTable[keyValueOp.Key][v]; !ok {
+					m.notEqualValues[keyValueOp.Key] = sortedInsertString(m.notEqualValues[keyValueOp.Key], v)
+				}
 				m.notEqualMapTable[keyValueOp.Key][v] = append(m.notEqualMapTable[keyValueOp.Key][v], &e)
 				e.count++
 			}
+
+		default:
+			// A clause that can't be indexed is never hit and the policy never matches
+			if err := m.addClause(keyValueOp, &e); err != nil {
+				zap.L().Error("Invalid clause in policy", zap.String("key", keyValueOp.Key), zap.Error(err))
+			}
+			e.count++
+		}
+	}
+
+	// The protocols and the ports are clauses on the labels of the flow
+	if len(selector.Protocols) > 0 {
+		protocols := make([]string, len(selector.Protocols))
+		for i, p := range selector.Protocols {
+			protocols[i] = strings.ToLower(p)
+		}
+		if err := m.addClause(policy.KeyValueOperator{Key: policy.ProtocolLabel, Operator: policy.In, Value: protocols}, &e); err != nil {
+			zap.L().Error("Invalid protocols in policy", zap.Error(err))
+		}
+		e.count++
+	}
+
+	if len(selector.Ports) > 0 {
+		m.numberOfClauses++
+		entry := &clauseEntry{id: m.numberOfClauses, policy: &e}
+		for _, r := range selector.Ports {
+			m.portTable.add(r, entry)
 		}
+		e.count++
+	}
+
+	// Policies without any other clause than KeyNotExists are never hit
+	if e.count == 0 && len(clauses) > 0 {
+		m.notExistsPolicies = append(m.notExistsPolicies, &e)
 	}
 
 	// Increase the number of policies
 	m.numberOfPolicies++
+	m.selectors = append(m.selectors, selector)
+	m.policies = append(m.policies, &e)
 
 	// Give the policy an index
 	e.index = m.numberOfPolicies
@@ -140,6 +234,29 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 
 }
 
+// scopedClauses returns the clauses of the selector, starting with the clause
+// of its namespace. A selector without a namespace gets the namespace of the
+// processing unit that owns it, and a selector with the root namespace "/" is
+// not scoped.
+func scopedClauses(selector policy.TagSelector, owner string) ([]policy.KeyValueOperator, error) {
+
+	scope := selector.Namespace
+	if scope == "" {
+		scope = owner
+	}
+
+	namespace, err := policy.NormalizeNamespace(scope)
+	if err != nil {
+		return selector.Clause, err
+	}
+
+	if namespace == "" {
+		return selector.Clause, nil
+	}
+
+	return append([]policy.KeyValueOperator{policy.NamespaceClause(namespace)}, selector.Clause...), nil
+}
+
 func (m *PolicyDB) keyValueFromString(tag string) (key, value string) {
 
 	parts := strings.SplitN(tag, "=", 2)
@@ -151,18 +268,197 @@ func (m *PolicyDB) keyValueFromString(tag string) (key, value string) {
 	return parts[0], parts[1]
 }
 
-//Search searches for a set of tags in the database to find a policy match
+// addClause indexes a clause of one of the extended operators
+func (m *PolicyDB) addClause(keyValueOp policy.KeyValueOperator, e *ForwardingPolicy) error {
+
+	m.numberOfClauses++
+	entry := &clauseEntry{id: m.numberOfClauses, policy: e}
+	key := keyValueOp.Key
+
+	switch keyValueOp.Operator {
+
+	case policy.HasPrefix:
+		if _, ok := m.prefixTable[key]; !ok {
+			m.prefixTable[key] = newPrefixIndex()
+		}
+		for _, v := range keyValueOp.Value {
+			m.prefixTable[key].add(v, entry)
+		}
+
+	case policy.In:
+		if _, ok := m.inTable[key]; !ok {
+			m.inTable[key] = map[string][]*clauseEntry{}
+		}
+		for _, v := range keyValueOp.Value {
+			m.inTable[key][v] = append(m.inTable[key][v], entry)
+		}
+
+	case policy.NotIn:
+		clause := &setClause{entry: entry, values: make(map[string]struct{}, len(keyValueOp.Value))}
+		for _, v := range keyValueOp.Value {
+			clause.values[v] = struct{}{}
+		}
+		m.notInTable[key] = append(m.notInTable[key], clause)
+
+	case policy.Matches:
+		clause := &regexClause{entry: entry}
+		for _, v := range keyValueOp.Value {
+			exp, err := compileMatch(v)
+			if err != nil {
+				return err
+			}
+			clause.expressions = append(clause.expressions, exp)
+		}
+		m.regexTable[key] = append(m.regexTable[key], clause)
+
+	case policy.GreaterThan, policy.GreaterOrEqual, policy.LessThan, policy.LessOrEqual:
+		thresholds := make([]*threshold, len(keyValueOp.Value))
+		for i, v := range keyValueOp.Value {
+			value, ok := parseVersion(v)
+			if !ok {
+				return fmt.Errorf("Invalid numeric value %s", v)
+			}
+			thresholds[i] = &threshold{value: value, entry: entry}
+		}
+		if _, ok := m.numericTable[key]; !ok {
+			m.numericTable[key] = &numericIndex{}
+		}
+		for _, t := range thresholds {
+			m.numericTable[key].add(keyValueOp.Operator, t)
+		}
+
+	default:
+		return fmt.Errorf("Unknown operator %s", keyValueOp.Operator)
+	}
+
+	return nil
+}
+
+// searchState is the state of a search of the policies matching a set of tags
+type searchState struct {
+	// count is the number of hits of the tags of each policy
+	count []int
+	// skip marks the policies that fail a KeyNotExists clause
+	skip []bool
+	// seen marks the clauses of the extended operators that were hit, so that
+	// each clause is counted once even if several values of its key hit it
+	seen []bool
+	// best is the matching policy with the highest priority and the lowest
+	// index
+	best *ForwardingPolicy
+	// expired is the matching policy with the highest priority and the lowest
+	// index among the policies whose schedule is not active
+	expired *ForwardingPolicy
+	// now is the time of the search, read when a policy has a schedule
+	now time.Time
+}
+
+// hit counts a hit of one of the tags of the policy and keeps the policy if all
+// of its tags have been hit and it has a higher priority than the previous match
+func (s *searchState) hit(policy *ForwardingPolicy) {
+
+	// Skip the policy if we have marked it
+	if s.skip[policy.index] {
+		return
+	}
+
+	// Since a policy is hit, the count of remaining tags is reduced by one
+	s.count[policy.index]++
+
+	// If all tags of the policy have been hit, there is a match
+	if s.count[policy.index] == policy.count {
+		s.match(policy)
+	}
+}
+
+// match keeps a matching policy if it has a higher priority than the previous
+// match. The policies whose schedule is not active are kept apart.
+func (s *searchState) match(policy *ForwardingPolicy) {
+
+	if !s.active(policy) {
+		if policy.outranks(s.expired) {
+			s.expired = policy
+		}
+		return
+	}
+
+	if policy.outranks(s.best) {
+		s.best = policy
+	}
+}
+
+// outranks returns true if the policy has a higher priority than the other
+// policy, or the same priority and a lower index. Any policy outranks nil.
+func (p *ForwardingPolicy) outranks(other *ForwardingPolicy) bool {
+
+	if other == nil {
+		return true
+	}
+
+	if p.priority != other.priority {
+		return p.priority > other.priority
+	}
+
+	return p.index < other.index
+}
+
+// active returns true if the schedule of the policy is active
+func (s *searchState) active(policy *ForwardingPolicy) bool {
+
+	if policy.schedule == nil {
+		return true
+	}
+
+	if s.now.IsZero() {
+		s.now = time.Now()
+	}
+
+	return policy.schedule.Active(s.now)
+}
+
+//Search searches for a set of tags in the database to find a policy match. If
+//several policies match, the one with the highest priority is returned. Among
+//policies with the same priority, the one added first wins, whatever the order
+//of the tags. The policies whose schedule is not active are ignored.
 func (m *PolicyDB) Search(tags *policy.TagStore) (int, interface{}) {
 
-	count := make([]int, m.numberOfPolicies+1)
+	s := m.search(tags)
+
+	if s.best == nil {
+		return -1, nil
+	}
+
+	return s.best.index, s.best.actions
+}
+
+// Expired searches for a policy matching the tags whose schedule is not
+// active. It returns -1 if an active policy matches the tags, so that only the
+// flows that are no longer accepted because of a schedule are reported.
+func (m *PolicyDB) Expired(tags *policy.TagStore) (int, interface{}) {
 
-	skip := make([]bool, m.numberOfPolicies+1)
+	s := m.search(tags)
+
+	if s.best != nil || s.expired == nil {
+		return -1, nil
+	}
+
+	return s.expired.index, s.expired.actions
+}
+
+// search counts the hits of the tags on all the policies
+func (m *PolicyDB) search(tags *policy.TagStore) *searchState {
+
+	s := &searchState{
+		count: make([]int, m.numberOfPolicies+1),
+		skip:  make([]bool, m.numberOfPolicies+1),
+		seen:  make([]bool, m.numberOfClauses+1),
+	}
 
 	// Disable all policies that fail the not key exists
 	for _, t := range tags.GetSlice() {
 		k, _ := m.keyValueFromString(t)
 		for _, policy := range m.notStarTable[k] {
-			skip[policy.index] = true
+			s.skip[policy.index] = true
 		}
 	}
 
@@ -170,58 +466,114 @@ func (m *PolicyDB) Search(tags *policy.TagStore) (int, interface{}) {
 	for _, t := range tags.GetSlice() {
 		k, v := m.keyValueFromString(t)
 		// Search for matches of k=v
-		if index, action := searchInMapTabe(m.equalMapTable[k][v], count, skip); index >= 0 {
-			return index, action
-		}
+		searchInMapTabe(m.equalMapTable[k][v], s)
 
 		// Search for matches in prefixes
 		for _, i := range m.equalPrefixes[k] {
 			if i <= len(v) {
-				if index, action := searchInMapTabe(m.equalMapTable[k][v[:i]], count, skip); index >= 0 {
-					return index, action
-				}
+				searchInMapTabe(m.equalMapTable[k][v[:i]], s)
 			}
 		}
 
 		// Parse all of the policies that have a key that matches the incoming tag key
 		// and a not equal operator and that has a not match rule
-		for value, policies := range m.notEqualMapTable[k] {
+		for _, value := range m.notEqualValues[k] {
 			if v == value {
 				continue
 			}
 
-			if index, action := searchInMapTabe(policies, count, skip); index >= 0 {
-				return index, action
-			}
+			searchInMapTabe(m.notEqualMapTable[k][value], s)
 		}
+
+		// Search for matches of the extended operators
+		m.searchClauses(k, v, s)
 	}
 
-	if m.defaultNotExistsPolicy != nil && !skip[m.defaultNotExistsPolicy.index] {
-		return m.defaultNotExistsPolicy.index, m.defaultNotExistsPolicy.actions
+	// The policies with only KeyNotExists clauses match if they are not skipped
+	for _, policy := range m.notExistsPolicies {
+		if !s.skip[policy.index] {
+			s.match(policy)
+		}
 	}
 
-	return -1, nil
+	return s
 }
 
-func searchInMapTabe(table []*ForwardingPolicy, count []int, skip []bool) (int, interface{}) {
-	for _, policy := range table {
+// Priority returns the priority of the policy with the given index, or 0 if
+// the policy doesn't exist
+func (m *PolicyDB) Priority(index int) int {
+
+	if index < 1 || index > len(m.policies) {
+		return 0
+	}
+
+	return m.policies[index-1].priority
+}
 
-		// Skip the policy if we have marked it
-		if skip[policy.index] {
-			continue
+// Selector returns the tag selector of the policy with the given index
+func (m *PolicyDB) Selector(index int) (*policy.TagSelector, error) {
+
+	if index < 1 || index > len(m.selectors) {
+		return nil, fmt.Errorf("Policy %d not found", index)
+	}
+
+	selector := m.selectors[index-1]
+
+	return &selector, nil
+}
+
+// searchClauses searches the clauses of the extended operators hit by the tag k=v
+func (m *PolicyDB) searchClauses(k, v string, s *searchState) {
+
+	hit := func(entry *clauseEntry) {
+		if s.seen[entry.id] {
+			return
 		}
+		s.seen[entry.id] = true
+		s.hit(entry.policy)
+	}
 
-		// Since a policy is hit, the count of remaining tags is reduced by one
-		count[policy.index]++
+	hitAll := func(entries []*clauseEntry) {
+		for _, entry := range entries {
+			hit(entry)
+		}
+	}
+
+	hitAll(m.inTable[k][v])
+
+	if prefixes, ok := m.prefixTable[k]; ok {
+		prefixes.search(v, hitAll)
+	}
+
+	for _, clause := range m.notInTable[k] {
+		if _, ok := clause.values[v]; !ok {
+			hit(clause.entry)
+		}
+	}
 
-		// If all tags of the policy have been hit, there is a match
-		if count[policy.index] == policy.count {
-			return policy.index, policy.actions
+	for _, clause := range m.regexTable[k] {
+		if clause.matches(v) {
+			hit(clause.entry)
 		}
+	}
+
+	if numbers, ok := m.numericTable[k]; ok {
+		if value, ok := parseVersion(v); ok {
+			numbers.search(value, hit)
+		}
+	}
 
+	if k == policy.PortLabel {
+		if port, err := strconv.ParseUint(v, 10, 16); err == nil {
+			m.portTable.search(uint16(port), hit)
+		}
 	}
+}
 
-	return -1, nil
+func searchInMapTabe(table []*ForwardingPolicy, s *searchState) {
+	for _, policy := range table {
+		s.hit(policy)
+	}
 }
 
 // PrintPolicyDB is a debugging function to dump the map
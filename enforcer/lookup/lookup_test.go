@@ -2,6 +2,7 @@ package lookup
 
 import (
 	"testing"
+	"time"
 
 	"github.com/aporeto-inc/trireme/policy"
 	. "github.com/smartystreets/goconvey/convey"
@@ -307,12 +308,28 @@ func TestFuncSearch(t *testing.T) {
 				So(action.(*policy.FlowPolicy).Action, ShouldEqual, policy.Accept)
 			})
 
-			Convey("Given that I search for a value that matches a complete value ", func() {
+			Convey("Given that I search for a value that matches a complete value, the policy of the value should match ", func() {
 				tags := policy.NewTagStore()
 				tags.AppendKeyValue("domain", "com.example.web")
 
+				matched := []int{}
+				for _, explanation := range policyDB.Explain(tags) {
+					if explanation.Matched {
+						matched = append(matched, explanation.Index)
+					}
+				}
+				So(matched, ShouldContain, index8)
+			})
+
+			Convey("Given that I search for a value that matches a complete value and a prefix of the same priority, the policy added first should win ", func() {
+				tags := policy.NewTagStore()
+				tags.AppendKeyValue("domain", "com.example.web")
+
+				// The ties between policies of the same priority are broken by
+				// their index, and the prefix policy was added before the
+				// policy of the complete value
 				index, action := policyDB.Search(tags)
-				So(index, ShouldEqual, index8)
+				So(index, ShouldEqual, index7)
 				So(action.(*policy.FlowPolicy).Action, ShouldEqual, policy.Accept)
 			})
 
@@ -356,3 +373,560 @@ func TestFuncDumpDB(t *testing.T) {
 		})
 	})
 }
+
+// TestFuncSelector tests the retrieval of the selector of a policy
+func TestFuncSelector(t *testing.T) {
+	Convey("Given a policy DB with two policies", t, func() {
+		policyDB := NewPolicyDB()
+		index1 := policyDB.AddPolicy(appEqWebAndenvEqDemo)
+		index2 := policyDB.AddPolicy(policylangNotJava)
+
+		Convey("When I search for matching tags, I should get the selector of the match", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("lang", "go")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, index2)
+
+			selector, err := policyDB.Selector(index)
+			So(err, ShouldBeNil)
+			So(selector.Clause, ShouldResemble, policylangNotJava.Clause)
+			So(selector.Policy, ShouldEqual, policylangNotJava.Policy)
+		})
+
+		Convey("When I get the selector of the first policy, I should get it", func() {
+			selector, err := policyDB.Selector(index1)
+			So(err, ShouldBeNil)
+			So(selector.Clause, ShouldResemble, appEqWebAndenvEqDemo.Clause)
+		})
+
+		Convey("When I get the selector of an unknown policy, I should get an error", func() {
+			_, err := policyDB.Selector(-1)
+			So(err, ShouldNotBeNil)
+			_, err = policyDB.Selector(3)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFuncSearchExtendedOperators(t *testing.T) {
+
+	envPrefixProd := policy.KeyValueOperator{Key: "env", Value: []string{"prod-", "production"}, Operator: policy.HasPrefix}
+	versionAtLeast := policy.KeyValueOperator{Key: "version", Value: []string{"2.3"}, Operator: policy.GreaterOrEqual}
+	versionBelow := policy.KeyValueOperator{Key: "version", Value: []string{"2"}, Operator: policy.LessThan}
+	teamMatches := policy.KeyValueOperator{Key: "team", Value: []string{"net(work)?-[a-z]+"}, Operator: policy.Matches}
+	appIn := policy.KeyValueOperator{Key: "app", Value: []string{"web", "api", "db"}, Operator: policy.In}
+	appNotIn := policy.KeyValueOperator{Key: "app", Value: []string{"web", "api"}, Operator: policy.NotIn}
+
+	Convey("Given a policy DB with policies using the extended operators", t, func() {
+		policyDB := NewPolicyDB()
+
+		prodRecent := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{envPrefixProd, versionAtLeast},
+			Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "prod-recent"},
+		})
+		old := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{versionBelow},
+			Policy: &policy.FlowPolicy{Action: policy.Reject, PolicyID: "old"},
+		})
+		network := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{teamMatches, appIn},
+			Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "network"},
+		})
+		others := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{appNotIn},
+			Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "others"},
+		})
+
+		search := func(kv ...string) int {
+			tags := policy.NewTagStore()
+			for i := 0; i < len(kv); i += 2 {
+				tags.AppendKeyValue(kv[i], kv[i+1])
+			}
+			index, _ := policyDB.Search(tags)
+			return index
+		}
+
+		Convey("When I search for tags matching a prefix and a version, I should get the policy", func() {
+			So(search("env", "prod-eu", "version", "2.3"), ShouldEqual, prodRecent)
+			So(search("env", "production", "version", "v2.10.1"), ShouldEqual, prodRecent)
+		})
+
+		Convey("When I search for tags that match only one clause, I should not get the policy", func() {
+			So(search("env", "prod-eu", "version", "2.2.9"), ShouldEqual, -1)
+			So(search("env", "staging", "version", "3"), ShouldEqual, -1)
+			So(search("env", "prod-eu", "version", "latest"), ShouldEqual, -1)
+		})
+
+		Convey("When a value matches several prefixes of a clause, it should hit the clause once", func() {
+			So(search("env", "production-eu"), ShouldEqual, -1)
+		})
+
+		Convey("When a key has several values that hit a clause, the clause should not count for the others", func() {
+			So(search("env", "prod-eu", "env", "prod-us", "version", "1.0"), ShouldEqual, old)
+			So(search("env", "prod-eu", "env", "prod-us"), ShouldEqual, -1)
+			So(search("team", "net-edge", "team", "network-core", "app", "cache"), ShouldEqual, others)
+			So(search("app", "cache", "app", "queue", "team", "ops"), ShouldEqual, others)
+		})
+
+		Convey("When I search for a version less than the threshold, I should get the policy", func() {
+			So(search("version", "1.9"), ShouldEqual, old)
+			So(search("version", "2.0.0"), ShouldEqual, -1)
+		})
+
+		Convey("When I search for tags matching a regular expression and a set, I should get the policy", func() {
+			So(search("team", "network-core", "app", "db"), ShouldEqual, network)
+			So(search("team", "net-edge", "app", "api"), ShouldEqual, network)
+			So(search("team", "xnet-edge", "app", "api"), ShouldEqual, -1)
+		})
+
+		Convey("When I search for a value not in the set, I should get the policy", func() {
+			So(search("app", "cache"), ShouldEqual, others)
+			So(search("app", "web"), ShouldEqual, -1)
+			So(search("team", "net-edge"), ShouldEqual, -1)
+		})
+	})
+
+	Convey("Given a policy DB with invalid clauses", t, func() {
+		policyDB := NewPolicyDB()
+		policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{{Key: "team", Value: []string{"("}, Operator: policy.Matches}},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+		policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{{Key: "version", Value: []string{"latest"}, Operator: policy.GreaterThan}},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+
+		Convey("When I search for tags, the policies should never match", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("team", "(")
+			tags.AppendKeyValue("version", "latest")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, -1)
+		})
+	})
+}
+
+func TestVersionCompare(t *testing.T) {
+
+	Convey("Given versions", t, func() {
+
+		Convey("Then they should be compared by numeric components", func() {
+			for _, c := range []struct {
+				a, b   string
+				result int
+			}{
+				{"2.3", "2.3.0", 0},
+				{"2.10", "2.3", 1},
+				{"v1", "2", -1},
+				{"10", "9", 1},
+			} {
+				a, ok := parseVersion(c.a)
+				So(ok, ShouldBeTrue)
+				b, ok := parseVersion(c.b)
+				So(ok, ShouldBeTrue)
+				So(a.compare(b), ShouldEqual, c.result)
+			}
+		})
+
+		Convey("Then invalid versions should not be parsed", func() {
+			for _, s := range []string{"", "v", "2.x", "1..2"} {
+				_, ok := parseVersion(s)
+				So(ok, ShouldBeFalse)
+			}
+		})
+	})
+}
+
+func TestFuncSearchPriority(t *testing.T) {
+
+	Convey("Given a policy DB with overlapping policies of different priorities", t, func() {
+		policyDB := NewPolicyDB()
+
+		web := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{appEqWeb},
+			Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "web"},
+		})
+		webDemo := policyDB.AddPolicy(policy.TagSelector{
+			Clause:   []policy.KeyValueOperator{appEqWeb, envEqDemo},
+			Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "web-demo"},
+			Priority: 10,
+		})
+		demo := policyDB.AddPolicy(policy.TagSelector{
+			Clause:   []policy.KeyValueOperator{envEqDemo},
+			Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "demo"},
+			Priority: 5,
+		})
+
+		Convey("When the tags match all the policies, I should get the one with the highest priority in any tag order", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+			tags.AppendKeyValue("env", "demo")
+			index, action := policyDB.Search(tags)
+			So(index, ShouldEqual, webDemo)
+			So(action.(*policy.FlowPolicy).PolicyID, ShouldEqual, "web-demo")
+
+			tags = policy.NewTagStore()
+			tags.AppendKeyValue("env", "demo")
+			tags.AppendKeyValue("app", "web")
+			index, _ = policyDB.Search(tags)
+			So(index, ShouldEqual, webDemo)
+			So(policyDB.Priority(index), ShouldEqual, 10)
+		})
+
+		Convey("When the tags match only lower priority policies, I should get the highest of them", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+			tags.AppendKeyValue("env", "qa")
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, web)
+
+			tags = policy.NewTagStore()
+			tags.AppendKeyValue("app", "db")
+			tags.AppendKeyValue("env", "demo")
+			index, _ = policyDB.Search(tags)
+			So(index, ShouldEqual, demo)
+		})
+
+		Convey("When I explain a search, I should get all the policies in priority order with their clauses", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "db")
+			tags.AppendKeyValue("env", "demo")
+
+			explanations := policyDB.Explain(tags)
+			So(explanations, ShouldHaveLength, 3)
+
+			So(explanations[0].Index, ShouldEqual, webDemo)
+			So(explanations[0].Matched, ShouldBeFalse)
+			So(explanations[0].Selected, ShouldBeFalse)
+			So(explanations[0].Clauses, ShouldHaveLength, 2)
+			So(explanations[0].Clauses[0].Clause, ShouldResemble, appEqWeb)
+			So(explanations[0].Clauses[0].Matched, ShouldBeFalse)
+			So(explanations[0].Clauses[1].Matched, ShouldBeTrue)
+
+			So(explanations[1].Index, ShouldEqual, demo)
+			So(explanations[1].Matched, ShouldBeTrue)
+			So(explanations[1].Selected, ShouldBeTrue)
+
+			So(explanations[2].Index, ShouldEqual, web)
+			So(explanations[2].Selector.Policy.PolicyID, ShouldEqual, "web")
+			So(explanations[2].Matched, ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a policy DB with overlapping policies of the same priority", t, func() {
+		policyDB := NewPolicyDB()
+
+		demo := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{envEqDemo},
+			Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "demo"},
+		})
+		web := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{appEqWeb},
+			Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "web"},
+		})
+
+		Convey("When the tags match both policies, I should get the first policy added in any tag order", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+			tags.AppendKeyValue("env", "demo")
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, demo)
+
+			tags = policy.NewTagStore()
+			tags.AppendKeyValue("env", "demo")
+			tags.AppendKeyValue("app", "web")
+			index, _ = policyDB.Search(tags)
+			So(index, ShouldEqual, demo)
+		})
+
+		Convey("When I get the priorities of the policies, I should get their priorities", func() {
+			So(policyDB.Priority(web), ShouldEqual, 0)
+			So(policyDB.Priority(0), ShouldEqual, 0)
+			So(policyDB.Priority(3), ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given a policy DB with policies using every operator", t, func() {
+		policyDB := NewPolicyDB()
+		policyDB.AddPolicy(appEqWebAndenvNotDemoOrQA)
+		policyDB.AddPolicy(envKeyNotExistsAndAppEqWeb)
+		policyDB.AddPolicy(policyDomainParent)
+		policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "version", Value: []string{"2.3"}, Operator: policy.GreaterThan},
+				{Key: "team", Value: []string{"net.*"}, Operator: policy.Matches},
+				{Key: "dc", Operator: policy.KeyExists},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+
+		Convey("When I explain a search, the clauses should match like the lookup", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+			tags.AppendKeyValue("env", "prod")
+			tags.AppendKeyValue("domain", "com.example.web")
+			tags.AppendKeyValue("version", "2.4")
+			tags.AppendKeyValue("team", "security")
+
+			index, _ := policyDB.Search(tags)
+			explanations := policyDB.Explain(tags)
+			So(explanations, ShouldHaveLength, 4)
+
+			for _, e := range explanations {
+				matched := true
+				for _, c := range e.Clauses {
+					matched = matched && c.Matched
+				}
+				So(e.Matched, ShouldEqual, matched)
+				So(e.Selected, ShouldEqual, e.Index == index)
+			}
+
+			So(explanations[1].Clauses[0].Matched, ShouldBeFalse)
+			So(explanations[3].Clauses[0].Matched, ShouldBeTrue)
+			So(explanations[3].Clauses[1].Matched, ShouldBeFalse)
+			So(explanations[3].Clauses[2].Matched, ShouldBeFalse)
+		})
+	})
+}
+
+func TestFuncSearchSchedule(t *testing.T) {
+
+	Convey("Given a policy DB with an expired policy of a higher priority", t, func() {
+		policyDB := NewPolicyDB()
+
+		expired := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{appEqWeb},
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "expired",
+				Schedule: &policy.Schedule{NotAfter: time.Now().Add(-time.Hour)},
+			},
+			Priority: 10,
+		})
+		demo := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{envEqDemo},
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "demo",
+				Schedule: &policy.Schedule{NotBefore: time.Now().Add(-time.Hour)},
+			},
+		})
+
+		Convey("When the tags match both policies, I should get the active one", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+			tags.AppendKeyValue("env", "demo")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, demo)
+
+			index, _ = policyDB.Expired(tags)
+			So(index, ShouldEqual, -1)
+		})
+
+		Convey("When the tags only match the expired policy, I should not get a match but get the expired policy", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, -1)
+
+			index, action := policyDB.Expired(tags)
+			So(index, ShouldEqual, expired)
+			So(action.(*policy.FlowPolicy).PolicyID, ShouldEqual, "expired")
+
+			explanations := policyDB.Explain(tags)
+			So(explanations[0].Index, ShouldEqual, expired)
+			So(explanations[0].Matched, ShouldBeTrue)
+			So(explanations[0].Active, ShouldBeFalse)
+			So(explanations[0].Selected, ShouldBeFalse)
+		})
+	})
+}
+
+func TestFuncSearchPortsAndProtocols(t *testing.T) {
+
+	Convey("Given a policy DB with port and protocol restricted policies", t, func() {
+		policyDB := NewPolicyDB()
+
+		web := policyDB.AddPolicy(policy.TagSelector{
+			Clause:    []policy.KeyValueOperator{appEqWeb},
+			Policy:    &policy.FlowPolicy{Action: policy.Accept, PolicyID: "web"},
+			Protocols: []string{"TCP"},
+			Ports:     []policy.PortRange{{Min: 80, Max: 80}, {Min: 8000, Max: 8999}, {Min: 8080, Max: 8090}},
+		})
+		dns := policyDB.AddPolicy(policy.TagSelector{
+			Policy:    &policy.FlowPolicy{Action: policy.Accept, PolicyID: "dns"},
+			Protocols: []string{"udp"},
+			Ports:     []policy.PortRange{{Min: 53, Max: 53}},
+		})
+		high := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{envKeyNotExists},
+			Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "high"},
+			Ports:  []policy.PortRange{{Min: 1024, Max: 65535}},
+		})
+
+		flow := func(protocol, port string, tags map[string]string) *policy.TagStore {
+			store := policy.NewTagStoreFromMap(tags)
+			store.AppendKeyValue(policy.ProtocolLabel, protocol)
+			store.AppendKeyValue(policy.PortLabel, port)
+			return store
+		}
+
+		Convey("When the flow is in one of the port ranges, I should get the policy", func() {
+			index, _ := policyDB.Search(flow("tcp", "80", map[string]string{"app": "web", "env": "prod"}))
+			So(index, ShouldEqual, web)
+
+			index, _ = policyDB.Search(flow("tcp", "8085", map[string]string{"app": "web", "env": "prod"}))
+			So(index, ShouldEqual, web)
+
+			index, _ = policyDB.Search(flow("udp", "53", nil))
+			So(index, ShouldEqual, dns)
+
+			index, _ = policyDB.Search(flow("tcp", "65535", nil))
+			So(index, ShouldEqual, high)
+		})
+
+		Convey("When the port or the protocol of the flow don't match, I should not get the policy", func() {
+			index, _ := policyDB.Search(flow("tcp", "9000", map[string]string{"app": "web", "env": "prod"}))
+			So(index, ShouldEqual, -1)
+
+			index, _ = policyDB.Search(flow("udp", "80", map[string]string{"app": "web", "env": "prod"}))
+			So(index, ShouldEqual, -1)
+
+			index, _ = policyDB.Search(flow("tcp", "53", nil))
+			So(index, ShouldEqual, -1)
+
+			index, _ = policyDB.Search(flow("tcp", "8000", map[string]string{"env": "prod"}))
+			So(index, ShouldEqual, -1)
+		})
+
+		Convey("When I explain a search, the port and protocol should be explained", func() {
+			explanations := policyDB.Explain(flow("udp", "8080", map[string]string{"app": "web", "env": "prod"}))
+			So(explanations, ShouldHaveLength, 3)
+			So(explanations[0].Index, ShouldEqual, web)
+			So(explanations[0].ProtocolMatched, ShouldBeFalse)
+			So(explanations[0].PortMatched, ShouldBeTrue)
+			So(explanations[0].Matched, ShouldBeFalse)
+			So(explanations[1].Index, ShouldEqual, dns)
+			So(explanations[1].ProtocolMatched, ShouldBeTrue)
+			So(explanations[1].PortMatched, ShouldBeFalse)
+		})
+
+		Convey("When I add a port range after a search, the next searches should match it", func() {
+			index, _ := policyDB.Search(flow("tcp", "9000", map[string]string{"app": "web", "env": "prod"}))
+			So(index, ShouldEqual, -1)
+
+			admin := policyDB.AddPolicy(policy.TagSelector{
+				Clause: []policy.KeyValueOperator{appEqWeb},
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "admin"},
+				Ports:  []policy.PortRange{{Min: 9000, Max: 9001}},
+			})
+
+			index, _ = policyDB.Search(flow("tcp", "9000", map[string]string{"app": "web", "env": "prod"}))
+			So(index, ShouldEqual, admin)
+
+			index, _ = policyDB.Search(flow("tcp", "80", map[string]string{"app": "web", "env": "prod"}))
+			So(index, ShouldEqual, web)
+		})
+	})
+}
+
+func TestFuncSearchNamespaces(t *testing.T) {
+
+	Convey("Given a policy DB with policies scoped to namespaces", t, func() {
+		policyDB := NewPolicyDB()
+
+		acme := policyDB.AddPolicy(policy.TagSelector{
+			Clause:    []policy.KeyValueOperator{appEqWeb},
+			Policy:    &policy.FlowPolicy{Action: policy.Accept, PolicyID: "acme"},
+			Namespace: "/acme/",
+		})
+		payments := policyDB.AddPolicy(policy.TagSelector{
+			Policy:    &policy.FlowPolicy{Action: policy.Accept, PolicyID: "payments"},
+			Priority:  10,
+			Namespace: "acme/payments",
+		})
+		invalid := policyDB.AddPolicy(policy.TagSelector{
+			Clause:    []policy.KeyValueOperator{appEqWeb},
+			Policy:    &policy.FlowPolicy{Action: policy.Accept, PolicyID: "invalid"},
+			Priority:  20,
+			Namespace: "acme/*",
+		})
+
+		pu := func(namespace string) *policy.TagStore {
+			store := policy.NewTagStoreFromMap(map[string]string{"app": "web"})
+			store.SetNamespace(namespace) // nolint
+			return store
+		}
+
+		Convey("When the PU is in the namespace subtree, I should get the policy", func() {
+			index, _ := policyDB.Search(pu("acme"))
+			So(index, ShouldEqual, acme)
+
+			index, _ = policyDB.Search(pu("acme/shipping/api"))
+			So(index, ShouldEqual, acme)
+
+			index, _ = policyDB.Search(pu("acme/payments/api"))
+			So(index, ShouldEqual, payments)
+		})
+
+		Convey("When the PU is in another namespace or has none, I should not get the policy", func() {
+			index, _ := policyDB.Search(pu("acmecorp"))
+			So(index, ShouldEqual, -1)
+
+			index, _ = policyDB.Search(pu("globex/acme"))
+			So(index, ShouldEqual, -1)
+
+			index, _ = policyDB.Search(pu(""))
+			So(index, ShouldEqual, -1)
+		})
+
+		Convey("When I explain a search, the namespace should be the first clause", func() {
+			explanations := policyDB.Explain(pu("globex"))
+			So(explanations, ShouldHaveLength, 3)
+			So(explanations[0].Index, ShouldEqual, invalid)
+			So(explanations[0].Matched, ShouldBeFalse)
+			So(explanations[2].Index, ShouldEqual, acme)
+			So(explanations[2].Clauses, ShouldHaveLength, 2)
+			So(explanations[2].Clauses[0].Clause, ShouldResemble, policy.NamespaceClause("acme"))
+			So(explanations[2].Clauses[0].Matched, ShouldBeFalse)
+			So(explanations[2].Clauses[1].Matched, ShouldBeTrue)
+		})
+	})
+
+	Convey("Given a policy DB of a processing unit of a namespace", t, func() {
+		policyDB := NewScopedPolicyDB("acme/payments")
+
+		owned := policyDB.AddPolicy(policy.TagSelector{
+			Clause:   []policy.KeyValueOperator{appEqWeb},
+			Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "owned"},
+			Priority: 10,
+		})
+		root := policyDB.AddPolicy(policy.TagSelector{
+			Clause:    []policy.KeyValueOperator{appEqWeb},
+			Policy:    &policy.FlowPolicy{Action: policy.Accept, PolicyID: "root"},
+			Namespace: "/",
+		})
+
+		pu := func(namespace string) *policy.TagStore {
+			store := policy.NewTagStoreFromMap(map[string]string{"app": "web"})
+			store.SetNamespace(namespace) // nolint
+			return store
+		}
+
+		Convey("The policies without a namespace should only match the namespace of the processing unit", func() {
+			index, _ := policyDB.Search(pu("acme/payments/api"))
+			So(index, ShouldEqual, owned)
+
+			index, _ = policyDB.Search(pu("globex"))
+			So(index, ShouldEqual, root)
+
+			index, _ = policyDB.Search(pu(""))
+			So(index, ShouldEqual, root)
+		})
+	})
+}
@@ -0,0 +1,333 @@
+package lookup
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// clauseEntry is a clause of a policy indexed by one of the extended operators.
+// A clause is hit at most once per tag even if several of its values match.
+type clauseEntry struct {
+	id     int
+	policy *ForwardingPolicy
+}
+
+// prefixIndex indexes the clauses of the HasPrefix operator of a key by prefix.
+// The lengths of the prefixes are kept sorted so that a value is only looked up
+// for the prefix lengths that exist.
+type prefixIndex struct {
+	lengths []int
+	entries map[string][]*clauseEntry
+}
+
+func newPrefixIndex() *prefixIndex {
+
+	return &prefixIndex{
+		entries: map[string][]*clauseEntry{},
+	}
+}
+
+func (p *prefixIndex) add(prefix string, e *clauseEntry) {
+
+	if _, ok := p.entries[prefix]; !ok {
+		i := sort.SearchInts(p.lengths, len(prefix))
+		if i == len(p.lengths) || p.lengths[i] != len(prefix) {
+			p.lengths = append(p.lengths, 0)
+			copy(p.lengths[i+1:], p.lengths[i:])
+			p.lengths[i] = len(prefix)
+		}
+	}
+
+	p.entries[prefix] = append(p.entries[prefix], e)
+}
+
+func (p *prefixIndex) search(value string, fn func([]*clauseEntry)) {
+
+	for _, l := range p.lengths {
+		if l > len(value) {
+			return
+		}
+
+		if entries, ok := p.entries[value[:l]]; ok {
+			fn(entries)
+		}
+	}
+}
+
+// setClause is a clause of the NotIn operator
+type setClause struct {
+	entry  *clauseEntry
+	values map[string]struct{}
+}
+
+// regexClause is a clause of the Matches operator
+type regexClause struct {
+	entry       *clauseEntry
+	expressions []*regexp.Regexp
+}
+
+func (r *regexClause) matches(value string) bool {
+
+	for _, exp := range r.expressions {
+		if exp.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compileMatch compiles the regular expression of a Matches clause. The values
+// must fully match the expression.
+func compileMatch(expression string) (*regexp.Regexp, error) {
+
+	exp, err := regexp.Compile("^(?:" + expression + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid regular expression %s: %s", expression, err)
+	}
+
+	return exp, nil
+}
+
+// ValidateValues validates the values of a clause like they are validated
+// when the clause is added to a policy database. The values of the Matches
+// operator must be regular expressions, and the values of the numeric
+// operators must be numbers or versions.
+func ValidateValues(operator policy.Operator, values []string) error {
+
+	switch operator {
+	case policy.Matches:
+		for _, v := range values {
+			if _, err := compileMatch(v); err != nil {
+				return err
+			}
+		}
+
+	case policy.GreaterThan, policy.GreaterOrEqual, policy.LessThan, policy.LessOrEqual:
+		for _, v := range values {
+			if _, ok := parseVersion(v); !ok {
+				return fmt.Errorf("Invalid numeric value %s", v)
+			}
+		}
+	}
+
+	return nil
+}
+
+// threshold is a value of a clause of the numeric operators
+type threshold struct {
+	value version
+	entry *clauseEntry
+}
+
+// thresholdList is a list of thresholds sorted by value
+type thresholdList []*threshold
+
+func (l thresholdList) insert(t *threshold) thresholdList {
+
+	i := sort.Search(len(l), func(i int) bool {
+		return l[i].value.compare(t.value) > 0
+	})
+
+	l = append(l, nil)
+	copy(l[i+1:], l[i:])
+	l[i] = t
+
+	return l
+}
+
+// numericIndex indexes the clauses of the numeric operators of a key by
+// threshold. The clauses that match a value are a prefix or a suffix of the
+// sorted thresholds, found with a binary search.
+type numericIndex struct {
+	greater        thresholdList
+	greaterOrEqual thresholdList
+	less           thresholdList
+	lessOrEqual    thresholdList
+}
+
+func (n *numericIndex) add(operator policy.Operator, t *threshold) {
+
+	switch operator {
+	case policy.GreaterThan:
+		n.greater = n.greater.insert(t)
+	case policy.GreaterOrEqual:
+		n.greaterOrEqual = n.greaterOrEqual.insert(t)
+	case policy.LessThan:
+		n.less = n.less.insert(t)
+	case policy.LessOrEqual:
+		n.lessOrEqual = n.lessOrEqual.insert(t)
+	}
+}
+
+func (n *numericIndex) search(value version, fn func(*clauseEntry)) {
+
+	// Thresholds strictly lower than the value
+	i := sort.Search(len(n.greater), func(i int) bool { return n.greater[i].value.compare(value) >= 0 })
+	for _, t := range n.greater[:i] {
+		fn(t.entry)
+	}
+
+	// Thresholds lower or equal to the value
+	i = sort.Search(len(n.greaterOrEqual), func(i int) bool { return n.greaterOrEqual[i].value.compare(value) > 0 })
+	for _, t := range n.greaterOrEqual[:i] {
+		fn(t.entry)
+	}
+
+	// Thresholds strictly greater than the value
+	i = sort.Search(len(n.less), func(i int) bool { return n.less[i].value.compare(value) > 0 })
+	for _, t := range n.less[i:] {
+		fn(t.entry)
+	}
+
+	// Thresholds greater or equal to the value
+	i = sort.Search(len(n.lessOrEqual), func(i int) bool { return n.lessOrEqual[i].value.compare(value) >= 0 })
+	for _, t := range n.lessOrEqual[i:] {
+		fn(t.entry)
+	}
+}
+
+// portSegment is a range of ports that are in the same port ranges. It ends
+// where the next segment starts.
+type portSegment struct {
+	start   int
+	entries []*clauseEntry
+}
+
+// portIndex indexes the port ranges of the selectors. The ports are split in
+// segments where the matching ranges don't change, so that a port is looked up
+// with a binary search whatever the size of the ranges. The segments are built
+// on the first search after the ranges changed.
+type portIndex struct {
+	ranges   []policy.PortRange
+	entries  []*clauseEntry
+	segments []*portSegment
+	dirty    bool
+	sync.Mutex
+}
+
+func (p *portIndex) add(r policy.PortRange, e *clauseEntry) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.ranges = append(p.ranges, r)
+	p.entries = append(p.entries, e)
+	p.dirty = true
+}
+
+// build splits the ports in the segments of the ranges
+func (p *portIndex) build() {
+
+	bounds := make([]int, 0, 2*len(p.ranges))
+	for _, r := range p.ranges {
+		bounds = append(bounds, int(r.Min), int(r.Max)+1)
+	}
+	sort.Ints(bounds)
+
+	p.segments = make([]*portSegment, 0, len(bounds))
+	for i, start := range bounds {
+		if i > 0 && start == bounds[i-1] {
+			continue
+		}
+
+		segment := &portSegment{start: start}
+		for j, r := range p.ranges {
+			if start >= int(r.Min) && start <= int(r.Max) {
+				segment.entries = append(segment.entries, p.entries[j])
+			}
+		}
+		p.segments = append(p.segments, segment)
+	}
+
+	p.dirty = false
+}
+
+func (p *portIndex) search(port uint16, fn func(*clauseEntry)) {
+
+	p.Lock()
+	if p.dirty {
+		p.build()
+	}
+	segments := p.segments
+	p.Unlock()
+
+	i := sort.Search(len(segments), func(i int) bool { return segments[i].start > int(port) })
+	if i == 0 {
+		return
+	}
+
+	for _, e := range segments[i-1].entries {
+		fn(e)
+	}
+}
+
+// version is a numeric value or a dotted version like 2.3.1 with an optional
+// v prefix. Missing components are zeros, so that 2.3 equals 2.3.0.
+type version []int64
+
+func parseVersion(s string) (version, bool) {
+
+	s = strings.TrimPrefix(s, "v")
+	if s == "" {
+		return nil, false
+	}
+
+	parts := strings.Split(s, ".")
+	v := make(version, len(parts))
+
+	for i, part := range parts {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		v[i] = n
+	}
+
+	return v, true
+}
+
+func (v version) compare(o version) int {
+
+	for i := 0; i < len(v) || i < len(o); i++ {
+		var a, b int64
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(o) {
+			b = o[i]
+		}
+
+		if a < b {
+			return -1
+		}
+		if a > b {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// CompareValues compares two numeric or version values like the numeric
+// operators do. It returns false if one of the values is not numeric.
+func CompareValues(a, b string) (int, bool) {
+
+	va, ok := parseVersion(a)
+	if !ok {
+		return 0, false
+	}
+
+	vb, ok := parseVersion(b)
+	if !ok {
+		return 0, false
+	}
+
+	return va.compare(vb), true
+}
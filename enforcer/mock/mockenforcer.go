@@ -5,6 +5,7 @@ package mockenforcer
 
 import (
 	gomock "github.com/aporeto-inc/mock/gomock"
+	collector "github.com/aporeto-inc/trireme/collector"
 	enforcer "github.com/aporeto-inc/trireme/enforcer"
 	fqconfig "github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
 	packet "github.com/aporeto-inc/trireme/enforcer/utils/packet"
@@ -36,6 +37,19 @@ func (_m *MockPolicyEnforcer) EXPECT() *MockPolicyEnforcerMockRecorder {
 	return _m.recorder
 }
 
+// Connections mocks base method
+func (_m *MockPolicyEnforcer) Connections(_param0 string) ([]*collector.ConnectionRecord, error) {
+	ret := _m.ctrl.Call(_m, "Connections", _param0)
+	ret0, _ := ret[0].([]*collector.ConnectionRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Connections indicates an expected call of Connections
+func (_mr *MockPolicyEnforcerMockRecorder) Connections(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Connections", arg0)
+}
+
 // Enforce mocks base method
 func (_m *MockPolicyEnforcer) Enforce(_param0 string, _param1 *policy.PUInfo) error {
 	ret := _m.ctrl.Call(_m, "Enforce", _param0, _param1)
@@ -48,6 +62,19 @@ func (_mr *MockPolicyEnforcerMockRecorder) Enforce(arg0, arg1 interface{}) *gomo
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Enforce", arg0, arg1)
 }
 
+// EvaluateFlow mocks base method
+func (_m *MockPolicyEnforcer) EvaluateFlow(_param0 string, _param1 *policy.FlowQuery) (*policy.FlowDecision, error) {
+	ret := _m.ctrl.Call(_m, "EvaluateFlow", _param0, _param1)
+	ret0, _ := ret[0].(*policy.FlowDecision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EvaluateFlow indicates an expected call of EvaluateFlow
+func (_mr *MockPolicyEnforcerMockRecorder) EvaluateFlow(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "EvaluateFlow", arg0, arg1)
+}
+
 // GetFilterQueue mocks base method
 func (_m *MockPolicyEnforcer) GetFilterQueue() *fqconfig.FilterQueue {
 	ret := _m.ctrl.Call(_m, "GetFilterQueue")
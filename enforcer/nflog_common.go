@@ -1,6 +1,8 @@
 package enforcer
 
 import (
+	"net"
+
 	"github.com/aporeto-inc/trireme/policy"
 )
 
@@ -9,4 +11,10 @@ type nfLogger interface {
 	stop()
 }
 
-type puInfoFunc func(string) (string, *policy.TagStore)
+// puInfoFunc returns the management ID, the annotations and the generation of
+// the policy of a processing unit
+type puInfoFunc func(string) (string, *policy.TagStore, uint64)
+
+// fqdnFunc returns the DNS name of the application ACL of a processing unit
+// that matches the address and port of an external service
+type fqdnFunc func(string, net.IP, uint16) string
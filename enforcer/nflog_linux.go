@@ -16,6 +16,7 @@ import (
 
 type nfLog struct {
 	getPUInfo       puInfoFunc
+	getFQDN         fqdnFunc
 	ipv4groupSource uint16
 	ipv4groupDest   uint16
 	collector       collector.EventCollector
@@ -24,13 +25,14 @@ type nfLog struct {
 	sync.Mutex
 }
 
-func newNFLogger(ipv4groupSource, ipv4groupDest uint16, getPUInfo puInfoFunc, collector collector.EventCollector) nfLogger {
+func newNFLogger(ipv4groupSource, ipv4groupDest uint16, getPUInfo puInfoFunc, getFQDN fqdnFunc, collector collector.EventCollector) nfLogger {
 
 	return &nfLog{
 		ipv4groupSource: ipv4groupSource,
 		ipv4groupDest:   ipv4groupDest,
 		collector:       collector,
 		getPUInfo:       getPUInfo,
+		getFQDN:         getFQDN,
 	}
 }
 
@@ -86,7 +88,7 @@ func (a *nfLog) recordFromNFLogBuffer(buf *nflog.NfPacket, puIsSource bool) (*co
 	contextID, policyID, extSrvID := parts[0], parts[1], parts[2]
 	shortAction := string(buf.Prefix[len(buf.Prefix)-1])
 
-	puID, tags := a.getPUInfo(contextID)
+	puID, tags, generation := a.getPUInfo(contextID)
 	if puID == "" {
 		return nil, fmt.Errorf("nflog: unable to find pu ID associated given contexID: %s", contextID)
 	}
@@ -107,9 +109,11 @@ func (a *nfLog) recordFromNFLogBuffer(buf *nflog.NfPacket, puIsSource bool) (*co
 			IP:   buf.DstIP.String(),
 			Port: uint16(buf.DstPort),
 		},
-		PolicyID: policyID,
-		Tags:     tags,
-		Action:   action,
+		PolicyID:         policyID,
+		PolicyGeneration: generation,
+		Tags:             tags,
+		Action:           action,
+		Observed:         shortAction == policy.ObservedShortAction,
 	}
 
 	if puIsSource {
@@ -117,6 +121,9 @@ func (a *nfLog) recordFromNFLogBuffer(buf *nflog.NfPacket, puIsSource bool) (*co
 		record.Source.ID = puID
 		record.Destination.Type = collector.Address
 		record.Destination.ID = extSrvID
+		if a.getFQDN != nil {
+			record.Destination.FQDN = a.getFQDN(contextID, buf.DstIP, uint16(buf.DstPort))
+		}
 	} else {
 		record.Source.Type = collector.Address
 		record.Source.ID = extSrvID
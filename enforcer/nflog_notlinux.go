@@ -8,7 +8,7 @@ import "github.com/aporeto-inc/trireme/collector"
 type nfLog struct {
 }
 
-func newNFLogger(ipv4groupSource, ipv4groupDest uint16, getPUInfo puInfoFunc, collector collector.EventCollector) nfLogger {
+func newNFLogger(ipv4groupSource, ipv4groupDest uint16, getPUInfo puInfoFunc, getFQDN fqdnFunc, collector collector.EventCollector) nfLogger {
 	return &nfLog{}
 }
 
@@ -2,12 +2,18 @@
 
 package enforcer
 
-// Go libraries
+import "github.com/aporeto-inc/trireme/enforcer/utils/packetsource"
 
-// startNetworkInterceptor will the process that processes  packets from the network
-// Still has one more copy than needed. Can be improved.
-func (d *Datapath) startNetworkInterceptor() {}
+// defaultPacketSources returns no packet sources since netfilter queues are
+// not available
+func (d *Datapath) defaultPacketSources() (app packetsource.PacketSource, net packetsource.PacketSource) {
 
-// startApplicationInterceptor will create a interceptor that processes
-// packets originated from a local application
-func (d *Datapath) startApplicationInterceptor() {}
+	return nil, nil
+}
+
+// defaultPacketWriter returns no packet writer since raw sockets are not
+// available
+func (d *Datapath) defaultPacketWriter() packetsource.PacketWriter {
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package enforcer
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPortRangeRules(t *testing.T) {
+
+	Convey("Given I create an enforcer with a processing unit that accepts a range of TCP ports", t, func() {
+
+		rules := policy.TagSelectorList{
+			{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy:    &policy.FlowPolicy{Action: policy.Accept, PolicyID: "range"},
+				Protocols: []string{"tcp"},
+				Ports:     []policy.PortRange{{Min: 8000, Max: 8999}},
+			},
+		}
+
+		recorder := &flowRecorder{}
+		enforcer, err1, err2 := setupTestProcessingUnits(recorder, rules, nil)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+		enforcer.packetWriter = &packetCapture{}
+
+		Convey("When a Syn is sent to a port of the range, it should be accepted", func() {
+			_, _, err := transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 8080, 1000, 0, true, nil))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When a Syn is sent to a port out of the range, it should be rejected", func() {
+			_, _, err := transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 9000, 1000, 0, true, nil))
+			So(err, ShouldNotBeNil)
+			So(recorder.flows, ShouldHaveLength, 1)
+			So(recorder.flows[0].Action, ShouldEqual, policy.Reject)
+		})
+
+		Convey("When a UDP flow is sent to a port of the range, it should be rejected", func() {
+			request := createUDPTestPacket(testIP1, testIP2, 12345, 8080, []byte("request"))
+			So(enforcer.processApplicationUDPPackets(request), ShouldBeNil)
+			So(enforcer.processNetworkUDPPackets(request), ShouldNotBeNil)
+		})
+	})
+}
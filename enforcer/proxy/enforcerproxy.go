@@ -135,6 +135,8 @@ func (s *ProxyInfo) Enforce(contextID string, puInfo *policy.PUInfo) error {
 			TransmitterRules: puInfo.Policy.TransmitterRules(),
 			TriremeNetworks:  puInfo.Policy.TriremeNetworks(),
 			ExcludedNetworks: puInfo.Policy.ExcludedNetworks(),
+			RejectAction:     puInfo.Policy.RejectAction(),
+			Generation:       puInfo.Policy.Generation(),
 		},
 	}
 
@@ -167,6 +169,65 @@ func (s *ProxyInfo) GetFilterQueue() *fqconfig.FilterQueue {
 	return s.filterQueue
 }
 
+// Connections makes a RPC call to list the connections tracked by the remote enforcer
+func (s *ProxyInfo) Connections(contextID string) ([]*collector.ConnectionRecord, error) {
+
+	s.Lock()
+	_, ok := s.initDone[contextID]
+	s.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Remote enforcer not initialized for %s", contextID)
+	}
+
+	request := &rpcwrapper.Request{
+		Payload: &rpcwrapper.ConnectionsPayload{
+			ContextID: contextID,
+		},
+	}
+
+	resp := &rpcwrapper.Response{}
+	if err := s.rpchdl.RemoteCall(contextID, "Server.Connections", request, resp); err != nil {
+		return nil, fmt.Errorf("Failed to get connections from remote enforcer: status %s, error: %s", resp.Status, err.Error())
+	}
+
+	payload, ok := resp.Payload.(rpcwrapper.ConnectionsResponsePayload)
+	if !ok {
+		return nil, fmt.Errorf("Invalid connections response from remote enforcer")
+	}
+
+	return payload.Connections, nil
+}
+
+// EvaluateFlow makes a RPC call to evaluate a flow against the policy of the remote enforcer
+func (s *ProxyInfo) EvaluateFlow(contextID string, query *policy.FlowQuery) (*policy.FlowDecision, error) {
+
+	s.Lock()
+	_, ok := s.initDone[contextID]
+	s.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Remote enforcer not initialized for %s", contextID)
+	}
+
+	request := &rpcwrapper.Request{
+		Payload: &rpcwrapper.EvaluateFlowPayload{
+			ContextID: contextID,
+			Query:     query,
+		},
+	}
+
+	resp := &rpcwrapper.Response{}
+	if err := s.rpchdl.RemoteCall(contextID, "Server.EvaluateFlow", request, resp); err != nil {
+		return nil, fmt.Errorf("Failed to evaluate flow in remote enforcer: status %s, error: %s", resp.Status, err.Error())
+	}
+
+	payload, ok := resp.Payload.(rpcwrapper.EvaluateFlowResponsePayload)
+	if !ok {
+		return nil, fmt.Errorf("Invalid flow evaluation response from remote enforcer")
+	}
+
+	return payload.Decision, nil
+}
+
 // Start starts the the remote enforcer proxy.
 func (s *ProxyInfo) Start() error {
 	return nil
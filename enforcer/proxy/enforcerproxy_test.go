@@ -1,12 +1,16 @@
 package enforcerproxy
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"encoding/gob"
+	"fmt"
 	"testing"
 
 	gomock "github.com/aporeto-inc/mock/gomock"
 	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/enforcer/utils/rpcwrapper"
 	mockrpcwrapper "github.com/aporeto-inc/trireme/enforcer/utils/rpcwrapper/mock"
 	"github.com/aporeto-inc/trireme/enforcer/utils/secrets"
 	"github.com/aporeto-inc/trireme/policy"
@@ -231,6 +235,50 @@ func TestEnforce(t *testing.T) {
 	})
 }
 
+func TestEnforcePayload(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a proxy enforcer and a policy with port and protocol rules", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		policyEnf := NewDefaultProxyEnforcer("testServerID", eventCollector(), secretGen(nil, nil, nil), rpchdl, procMountPoint)
+
+		rules := policy.TagSelectorList{
+			{
+				Clause:    []policy.KeyValueOperator{{Key: "app", Value: []string{"web"}, Operator: policy.Equal}},
+				Policy:    &policy.FlowPolicy{Action: policy.Accept},
+				Protocols: []string{"tcp"},
+				Ports:     []policy.PortRange{{Min: 8000, Max: 8999}},
+			},
+		}
+
+		puInfo := createPUInfo()
+		puInfo.Policy = policy.NewPUPolicy("testServerID", policy.Police, nil, nil, nil, rules, nil, nil, nil, []string{"172.17.0.0/24"}, []string{})
+
+		Convey("When I enforce the policy, the rules should be carried to the remote enforcer", func() {
+			var payload rpcwrapper.EnforcePayload
+
+			rpchdl.EXPECT().NewRPCClient("testServerID", "/var/run/testServerID.sock", gomock.Any()).AnyTimes()
+			rpchdl.EXPECT().RemoteCall("testServerID", "Server.InitEnforcer", gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			rpchdl.EXPECT().RemoteCall("testServerID", "Server.Enforce", gomock.Any(), gomock.Any()).Times(1).Do(
+				func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+					// The requests are gob encoded on the way to the remote enforcer
+					var buffer bytes.Buffer
+					So(gob.NewEncoder(&buffer).Encode(req), ShouldBeNil)
+					decoded := &rpcwrapper.Request{}
+					So(gob.NewDecoder(&buffer).Decode(decoded), ShouldBeNil)
+					payload = decoded.Payload.(rpcwrapper.EnforcePayload)
+				}).Return(nil)
+
+			err := policyEnf.(*ProxyInfo).Enforce("testServerID", puInfo)
+			So(err, ShouldBeNil)
+			So(payload.ReceiverRules, ShouldHaveLength, 1)
+			So(payload.ReceiverRules[0].Protocols, ShouldResemble, []string{"tcp"})
+			So(payload.ReceiverRules[0].Ports, ShouldResemble, []policy.PortRange{{Min: 8000, Max: 8999}})
+		})
+	})
+}
+
 func TestUnenforce(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -262,3 +310,95 @@ func TestUnenforce(t *testing.T) {
 		})
 	})
 }
+
+func TestConnections(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		policyEnf := NewDefaultProxyEnforcer("testServerID", eventCollector(), secretGen(nil, nil, nil), rpchdl, procMountPoint)
+
+		Convey("When I try to get the connections without a remote enforcer", func() {
+			connections, err := policyEnf.Connections("testServerID")
+
+			Convey("Then I should get an error", func() {
+				So(connections, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I try to get the connections of an initialized remote enforcer", func() {
+			rpchdl.EXPECT().RemoteCall("testServerID", "Server.InitEnforcer", gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+
+			record := &collector.ConnectionRecord{ContextID: "testServerID", SourceIP: "10.1.1.1", State: "Data"}
+			rpchdl.EXPECT().RemoteCall("testServerID", "Server.Connections", gomock.Any(), gomock.Any()).Times(1).Do(
+				func(contextID string, methodName string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+					resp.Payload = rpcwrapper.ConnectionsResponsePayload{
+						Connections: []*collector.ConnectionRecord{record},
+					}
+				}).Return(nil)
+
+			connections, err := policyEnf.Connections("testServerID")
+
+			Convey("Then I should get the connections of the remote enforcer", func() {
+				So(err, ShouldBeNil)
+				So(connections, ShouldResemble, []*collector.ConnectionRecord{record})
+			})
+		})
+
+		Convey("When the remote enforcer fails to return the connections", func() {
+			rpchdl.EXPECT().RemoteCall("testServerID", "Server.InitEnforcer", gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+
+			rpchdl.EXPECT().RemoteCall("testServerID", "Server.Connections", gomock.Any(), gomock.Any()).Times(1).Return(fmt.Errorf("error"))
+			_, err := policyEnf.Connections("testServerID")
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestEvaluateFlow(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		policyEnf := NewDefaultProxyEnforcer("testServerID", eventCollector(), secretGen(nil, nil, nil), rpchdl, procMountPoint)
+		query := &policy.FlowQuery{Direction: policy.OutgoingFlow, RemoteIP: "10.1.1.1", Port: 80}
+
+		Convey("When I try to evaluate a flow without a remote enforcer", func() {
+			decision, err := policyEnf.EvaluateFlow("testServerID", query)
+
+			Convey("Then I should get an error", func() {
+				So(decision, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I try to evaluate a flow with an initialized remote enforcer", func() {
+			rpchdl.EXPECT().RemoteCall("testServerID", "Server.InitEnforcer", gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+
+			decision := &policy.FlowDecision{Action: policy.Accept, PolicyID: "1", Source: policy.ApplicationACLs}
+			rpchdl.EXPECT().RemoteCall("testServerID", "Server.EvaluateFlow", gomock.Any(), gomock.Any()).Times(1).Do(
+				func(contextID string, methodName string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+					So(req.Payload.(*rpcwrapper.EvaluateFlowPayload).Query, ShouldEqual, query)
+					resp.Payload = rpcwrapper.EvaluateFlowResponsePayload{
+						Decision: decision,
+					}
+				}).Return(nil)
+
+			result, err := policyEnf.EvaluateFlow("testServerID", query)
+
+			Convey("Then I should get the decision of the remote enforcer", func() {
+				So(err, ShouldBeNil)
+				So(result, ShouldEqual, decision)
+			})
+		})
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"sync"
 	"testing"
 
+	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/enforcer"
 	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme/policy"
@@ -13,6 +14,8 @@ type mockedMethods struct {
 	EnforceMock        func(contextID string, puInfo *policy.PUInfo) error
 	UnenforceMock      func(contextID string) error
 	GetFilterQueueMock func() *fqconfig.FilterQueue
+	ConnectionsMock    func(contextID string) ([]*collector.ConnectionRecord, error)
+	EvaluateFlowMock   func(contextID string, query *policy.FlowQuery) (*policy.FlowDecision, error)
 	StartMock          func() error
 	StopMock           func() error
 }
@@ -23,6 +26,8 @@ type TestEnforcerLauncher interface {
 	MockEnforce(t *testing.T, impl func(contextID string, puInfo *policy.PUInfo) error)
 	MockUnenforce(t *testing.T, impl func(contextID string) error)
 	MockGetFilterQueue(t *testing.T, impl func() *fqconfig.FilterQueue)
+	MockConnections(t *testing.T, impl func(contextID string) ([]*collector.ConnectionRecord, error))
+	MockEvaluateFlow(t *testing.T, impl func(contextID string, query *policy.FlowQuery) (*policy.FlowDecision, error))
 	MockStart(t *testing.T, impl func() error)
 	MockStop(t *testing.T, impl func() error)
 }
@@ -65,6 +70,12 @@ func (m *testEnforcerLauncher) MockUnenforce(t *testing.T, impl func(contextID s
 func (m *testEnforcerLauncher) MockGetFilterQueue(t *testing.T, impl func() *fqconfig.FilterQueue) {
 	m.currentMocks(t).GetFilterQueueMock = impl
 }
+func (m *testEnforcerLauncher) MockConnections(t *testing.T, impl func(contextID string) ([]*collector.ConnectionRecord, error)) {
+	m.currentMocks(t).ConnectionsMock = impl
+}
+func (m *testEnforcerLauncher) MockEvaluateFlow(t *testing.T, impl func(contextID string, query *policy.FlowQuery) (*policy.FlowDecision, error)) {
+	m.currentMocks(t).EvaluateFlowMock = impl
+}
 func (m *testEnforcerLauncher) MockStart(t *testing.T, impl func() error) {
 	m.currentMocks(t).StartMock = impl
 }
@@ -93,6 +104,20 @@ func (m *testEnforcerLauncher) GetFilterQueue() *fqconfig.FilterQueue {
 	}
 	return nil
 }
+func (m *testEnforcerLauncher) Connections(contextID string) ([]*collector.ConnectionRecord, error) {
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.ConnectionsMock != nil {
+		return mock.ConnectionsMock(contextID)
+
+	}
+	return nil, nil
+}
+func (m *testEnforcerLauncher) EvaluateFlow(contextID string, query *policy.FlowQuery) (*policy.FlowDecision, error) {
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.EvaluateFlowMock != nil {
+		return mock.EvaluateFlowMock(contextID, query)
+
+	}
+	return nil, nil
+}
 func (m *testEnforcerLauncher) Start() error {
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.StartMock != nil {
 		return mock.StartMock()
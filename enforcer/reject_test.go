@@ -0,0 +1,143 @@
+package enforcer
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// packetCapture is a packet writer that records the injected packets
+type packetCapture struct {
+	packets [][]byte
+	sync.Mutex
+}
+
+func (c *packetCapture) WritePacket(buffer []byte) error {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.packets = append(c.packets, buffer)
+	return nil
+}
+
+func TestRejectConnection(t *testing.T) {
+
+	Convey("Given I create an enforcer with a processing unit that rejects the other one", t, func() {
+
+		rules := policy.TagSelectorList{
+			{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Reject, PolicyID: "reject"},
+			},
+		}
+
+		netACLs := policy.IPRuleList{
+			{
+				Address:  "192.168.0.0/16",
+				Port:     "80",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject, PolicyID: "acl"},
+			},
+		}
+
+		recorder := &flowRecorder{}
+		enforcer, err1, err2 := setupTestProcessingUnits(recorder, rules, netACLs)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		capture := &packetCapture{}
+		enforcer.packetWriter = capture
+
+		puID2 := "SomeTestProcessingUnitId" + strconv.Itoa(iteration) + "2"
+		ctx, err := enforcer.contextTracker.Get(puID2)
+		So(err, ShouldBeNil)
+		context := ctx.(*PUContext)
+
+		Convey("When the processing unit drops rejected connections, no packet should be sent", func() {
+			_, _, err := transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1000, 0, true, nil))
+			So(err, ShouldNotBeNil)
+			So(capture.packets, ShouldBeEmpty)
+			So(recorder.flows, ShouldNotBeEmpty)
+		})
+
+		Convey("When the processing unit resets rejected connections", func() {
+			context.RejectAction = policy.RejectReset
+
+			Convey("A rejected Syn should be answered with a reset and still be reported", func() {
+				_, _, err := transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1000, 0, true, nil))
+				So(err, ShouldNotBeNil)
+				So(capture.packets, ShouldHaveLength, 1)
+
+				rst, err := packet.New(0, capture.packets[0], "0")
+				So(err, ShouldBeNil)
+				So(rst.SourceAddress.String(), ShouldEqual, testIP2)
+				So(rst.DestinationAddress.String(), ShouldEqual, testIP1)
+				So(rst.SourcePort, ShouldEqual, 80)
+				So(rst.DestinationPort, ShouldEqual, 2000)
+				So(rst.TCPFlags, ShouldEqual, packet.TCPRstMask|packet.TCPAckMask)
+				So(rst.TCPAck, ShouldEqual, 1001)
+				So(rst.VerifyTCPChecksum(), ShouldBeTrue)
+
+				So(recorder.flows, ShouldNotBeEmpty)
+				last := recorder.flows[len(recorder.flows)-1]
+				So(last.Action, ShouldEqual, policy.Reject)
+				So(last.DropReason, ShouldEqual, collector.PolicyDrop)
+			})
+
+			Convey("A rejected Syn from an external network should be answered with a reset", func() {
+				err := enforcer.processNetworkTCPPackets(createTCPTestPacket("192.168.1.1", testIP2, 2000, 80, 1000, 0, true, nil))
+				So(err, ShouldNotBeNil)
+				So(capture.packets, ShouldHaveLength, 1)
+
+				rst, err := packet.New(0, capture.packets[0], "0")
+				So(err, ShouldBeNil)
+				So(rst.IPProto, ShouldEqual, packet.IPProtocolTCP)
+				So(rst.DestinationAddress.String(), ShouldEqual, "192.168.1.1")
+			})
+		})
+
+		Convey("When the processing unit resets rejected connections with ICMP for external networks", func() {
+			context.RejectAction = policy.RejectResetICMP
+
+			Convey("A rejected Syn from an external network should be answered with an ICMP error", func() {
+				err := enforcer.processNetworkTCPPackets(createTCPTestPacket("192.168.1.1", testIP2, 2000, 80, 1000, 0, true, nil))
+				So(err, ShouldNotBeNil)
+				So(capture.packets, ShouldHaveLength, 1)
+				So(capture.packets[0][9], ShouldEqual, packet.IPProtocolICMP)
+			})
+
+			Convey("A rejected Syn from a processing unit should still be answered with a reset", func() {
+				_, _, err := transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1000, 0, true, nil))
+				So(err, ShouldNotBeNil)
+				So(capture.packets, ShouldHaveLength, 1)
+				So(capture.packets[0][9], ShouldEqual, packet.IPProtocolTCP)
+			})
+		})
+
+		Convey("When I enforce a policy with a reject action, the context should use it", func() {
+			puInfo := policy.NewPUInfo("RejectTestProcessingUnit", constants.ContainerPU)
+			puInfo.Runtime.SetIPAddresses(policy.ExtendedMap{"bridge": "10.1.1.3"})
+			puInfo.Policy.SetIPAddresses(policy.ExtendedMap{policy.DefaultNamespace: "10.1.1.3"})
+			puInfo.Policy.SetRejectAction(policy.RejectResetICMP)
+
+			So(enforcer.Enforce("RejectTestProcessingUnit", puInfo), ShouldBeNil)
+
+			ctx, err := enforcer.contextTracker.Get("RejectTestProcessingUnit")
+			So(err, ShouldBeNil)
+			So(ctx.(*PUContext).RejectAction, ShouldEqual, policy.RejectResetICMP)
+		})
+	})
+}
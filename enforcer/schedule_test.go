@@ -0,0 +1,66 @@
+package enforcer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScheduledPolicies(t *testing.T) {
+
+	Convey("Given I create an enforcer with a processing unit whose policies have expired", t, func() {
+
+		expired := &policy.Schedule{NotAfter: time.Now().Add(-time.Minute)}
+
+		rules := policy.TagSelectorList{
+			{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "expired", Schedule: expired},
+			},
+		}
+
+		netACLs := policy.IPRuleList{
+			{
+				Address:  "192.168.0.0/16",
+				Port:     "80",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "expired-acl", Schedule: expired},
+			},
+		}
+
+		recorder := &flowRecorder{}
+		enforcer, err1, err2 := setupTestProcessingUnits(recorder, rules, netACLs)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+		enforcer.packetWriter = &packetCapture{}
+
+		Convey("When a Syn only matches the expired rule, it should be dropped and reported as expired", func() {
+			_, _, err := transmitTCPPacket(enforcer, createTCPTestPacket(testIP1, testIP2, 2000, 80, 1000, 0, true, nil))
+			So(err, ShouldNotBeNil)
+
+			So(recorder.flows, ShouldHaveLength, 1)
+			So(recorder.flows[0].Action, ShouldEqual, policy.Reject)
+			So(recorder.flows[0].DropReason, ShouldEqual, collector.PolicyExpired)
+			So(recorder.flows[0].PolicyID, ShouldEqual, "expired")
+		})
+
+		Convey("When a Syn from an external network only matches the expired ACL, it should be dropped and reported as expired", func() {
+			err := enforcer.processNetworkTCPPackets(createTCPTestPacket("192.168.1.1", testIP2, 2000, 80, 1000, 0, true, nil))
+			So(err, ShouldNotBeNil)
+
+			So(recorder.flows, ShouldHaveLength, 1)
+			So(recorder.flows[0].Action, ShouldEqual, policy.Reject)
+			So(recorder.flows[0].DropReason, ShouldEqual, collector.PolicyExpired)
+			So(recorder.flows[0].PolicyID, ShouldEqual, "expired-acl")
+		})
+	})
+}
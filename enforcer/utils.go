@@ -1,13 +1,17 @@
 package enforcer
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/enforcer/acls"
 	"github.com/aporeto-inc/trireme/enforcer/lookup"
 	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
 	"github.com/aporeto-inc/trireme/policy"
 )
 
-func (d *Datapath) reportFlow(p *packet.Packet, connection *TCPConnection, sourceID string, destID string, context *PUContext, mode string, plc *policy.FlowPolicy) {
+func (d *Datapath) reportFlow(p *packet.Packet, connection *TCPConnection, sourceID string, destID string, context *PUContext, mode string, plc *policy.FlowPolicy) *collector.FlowRecord {
 
 	c := &collector.FlowRecord{
 		ContextID: context.ID,
@@ -23,24 +27,41 @@ func (d *Datapath) reportFlow(p *packet.Packet, connection *TCPConnection, sourc
 			Port: p.DestinationPort,
 			Type: collector.PU,
 		},
-		Tags:       context.Annotations,
-		Action:     plc.Action,
-		DropReason: mode,
-		PolicyID:   plc.PolicyID,
+		Tags:             context.Annotations,
+		Action:           plc.Action,
+		DropReason:       mode,
+		PolicyID:         plc.PolicyID,
+		PolicyGeneration: context.Generation,
+		Encrypted:        connection != nil && connection.Encrypted(),
+		Observed:         context.Audit && (mode == collector.PolicyDrop || mode == collector.PolicyExpired),
+		StartTime:        time.Now(),
 	}
 
 	d.collector.CollectFlowEvent(c)
 
+	return c
 }
 
 func (d *Datapath) reportAcceptedFlow(p *packet.Packet, conn *TCPConnection, sourceID string, destID string, context *PUContext, plc *policy.FlowPolicy) {
 	if conn != nil {
 		conn.SetReported(RejectReported)
 	}
-	d.reportFlow(p, conn, sourceID, destID, context, "NA", plc)
+	record := d.reportFlow(p, conn, sourceID, destID, context, "NA", plc)
+
+	d.trackFlow(record, p)
+}
+
+// trackFlow reports the volume of an accepted or observed flow from the
+// conntrack counters until the flow ends. The packet is in the original
+// direction of the flow.
+func (d *Datapath) trackFlow(record *collector.FlowRecord, p *packet.Packet) {
+
+	if d.flowStats != nil && record != nil {
+		d.flowStats.track(record, p.IPProto, p.SourceAddress.String(), p.SourcePort, p.DestinationAddress.String(), p.DestinationPort)
+	}
 }
 
-func (d *Datapath) reportRejectedFlow(p *packet.Packet, conn *TCPConnection, sourceID string, destID string, context *PUContext, mode string, plc *policy.FlowPolicy) {
+func (d *Datapath) reportRejectedFlow(p *packet.Packet, conn *TCPConnection, sourceID string, destID string, context *PUContext, mode string, plc *policy.FlowPolicy) *collector.FlowRecord {
 	if conn != nil {
 		conn.SetReported(AcceptReported)
 	}
@@ -52,10 +73,10 @@ func (d *Datapath) reportRejectedFlow(p *packet.Packet, conn *TCPConnection, sou
 		}
 	}
 
-	d.reportFlow(p, conn, sourceID, destID, context, mode, plc)
+	return d.reportFlow(p, conn, sourceID, destID, context, mode, plc)
 }
 
-func (d *Datapath) reportExternalServiceFlow(context *PUContext, flowpolicy *policy.FlowPolicy, app bool, p *packet.Packet) {
+func (d *Datapath) reportExternalServiceFlow(context *PUContext, flowpolicy *policy.FlowPolicy, app bool, p *packet.Packet, reason string) {
 
 	src := &collector.EndPoint{
 		IP:   p.SourceAddress.String(),
@@ -79,6 +100,7 @@ func (d *Datapath) reportExternalServiceFlow(context *PUContext, flowpolicy *pol
 		src.Type = collector.PU
 		dst.ID = flowpolicy.ServiceID
 		dst.Type = collector.Address
+		dst.FQDN = matchedFQDN(context, p.IPProto, p.DestinationAddress, p.DestinationPort)
 	} else {
 		src.ID = flowpolicy.ServiceID
 		src.Type = collector.Address
@@ -87,19 +109,26 @@ func (d *Datapath) reportExternalServiceFlow(context *PUContext, flowpolicy *pol
 	}
 
 	record := &collector.FlowRecord{
-		ContextID:   context.ID,
-		Source:      src,
-		Destination: dst,
-		DropReason:  collector.PolicyDrop,
-		Action:      flowpolicy.Action,
-		Tags:        context.Annotations,
-		PolicyID:    flowpolicy.PolicyID,
+		ContextID:        context.ID,
+		Source:           src,
+		Destination:      dst,
+		DropReason:       reason,
+		Action:           flowpolicy.Action,
+		Tags:             context.Annotations,
+		PolicyID:         flowpolicy.PolicyID,
+		PolicyGeneration: context.Generation,
+		Observed:         context.Audit && flowpolicy.Action.Rejected(),
+		StartTime:        time.Now(),
 	}
 
 	d.collector.CollectFlowEvent(record)
+
+	if !flowpolicy.Action.Rejected() || context.Audit {
+		d.trackFlow(record, p)
+	}
 }
 
-func (d *Datapath) reportReverseExternalServiceFlow(context *PUContext, flowpolicy *policy.FlowPolicy, app bool, p *packet.Packet) {
+func (d *Datapath) reportReverseExternalServiceFlow(context *PUContext, flowpolicy *policy.FlowPolicy, app bool, p *packet.Packet, reason string) {
 
 	src := &collector.EndPoint{
 		IP:   p.DestinationAddress.String(),
@@ -123,6 +152,7 @@ func (d *Datapath) reportReverseExternalServiceFlow(context *PUContext, flowpoli
 		src.Type = collector.PU
 		dst.ID = flowpolicy.ServiceID
 		dst.Type = collector.Address
+		dst.FQDN = matchedFQDN(context, p.IPProto, p.SourceAddress, p.SourcePort)
 	} else {
 		src.ID = flowpolicy.ServiceID
 		src.Type = collector.Address
@@ -131,23 +161,87 @@ func (d *Datapath) reportReverseExternalServiceFlow(context *PUContext, flowpoli
 	}
 
 	record := &collector.FlowRecord{
-		ContextID:   context.ID,
-		Source:      src,
-		Destination: dst,
-		DropReason:  collector.PolicyDrop,
-		Action:      flowpolicy.Action,
-		Tags:        context.Annotations,
-		PolicyID:    flowpolicy.PolicyID,
+		ContextID:        context.ID,
+		Source:           src,
+		Destination:      dst,
+		DropReason:       reason,
+		Action:           flowpolicy.Action,
+		Tags:             context.Annotations,
+		PolicyID:         flowpolicy.PolicyID,
+		PolicyGeneration: context.Generation,
+		Observed:         context.Audit && flowpolicy.Action.Rejected(),
+		StartTime:        time.Now(),
 	}
 
 	d.collector.CollectFlowEvent(record)
+
+	// The packet is in the reverse direction of the flow
+	if d.flowStats != nil && (!flowpolicy.Action.Rejected() || context.Audit) {
+		d.flowStats.track(record, p.IPProto, p.DestinationAddress.String(), p.DestinationPort, p.SourceAddress.String(), p.SourcePort)
+	}
+}
+
+// searchRejectRules searches the reject rules of a flow. A matching reject
+// rule wins over the accept rules of the same or a lower priority, but not
+// over a matching accept rule of a higher priority.
+func searchRejectRules(rejectRules *lookup.PolicyDB, acceptRules *lookup.PolicyDB, tags *policy.TagStore) (int, interface{}) {
+
+	index, plc := rejectRules.Search(tags)
+	if index < 0 {
+		return index, plc
+	}
+
+	if acceptIndex, _ := acceptRules.Search(tags); acceptIndex >= 0 && acceptRules.Priority(acceptIndex) > rejectRules.Priority(index) {
+		return -1, nil
+	}
+
+	return index, plc
+}
+
+// rejectReason returns the drop reason and the policy of a flow that none of
+// the accept rules matched. The flow is reported as expired if it matches an
+// accept rule whose schedule is not active.
+func rejectReason(acceptRules *lookup.PolicyDB, tags *policy.TagStore) (string, *policy.FlowPolicy) {
+
+	index, plc := acceptRules.Expired(tags)
+	if index < 0 {
+		return collector.PolicyDrop, nil
+	}
+
+	expired := plc.(*policy.FlowPolicy)
+
+	return collector.PolicyExpired, &policy.FlowPolicy{
+		Action:    policy.Reject,
+		PolicyID:  expired.PolicyID,
+		ServiceID: expired.ServiceID,
+	}
+}
+
+// aclDropReason returns the drop reason of a flow from the error of the lookup
+// of the ACLs
+func aclDropReason(err error) string {
+
+	if _, ok := err.(*acls.ExpiredError); ok {
+		return collector.PolicyExpired
+	}
+
+	return collector.PolicyDrop
+}
+
+// addFlowLabels adds the destination port and the protocol of a flow to the
+// tags of the remote processing unit, so that the rules can match them
+func addFlowLabels(tags *policy.TagStore, protocol string, port uint16) {
+
+	tags.AppendKeyValue(PortNumberLabelString, strconv.Itoa(int(port)))
+	tags.AppendKeyValue(policy.ProtocolLabel, protocol)
 }
 
-// createRuleDBs creates the database of rules from the policy
-func createRuleDBs(policyRules policy.TagSelectorList) (*lookup.PolicyDB, *lookup.PolicyDB) {
+// createRuleDBs creates the database of rules from the policy. The rules are
+// scoped to the namespace of the processing unit.
+func createRuleDBs(namespace string, policyRules policy.TagSelectorList) (*lookup.PolicyDB, *lookup.PolicyDB) {
 
-	acceptRules := lookup.NewPolicyDB()
-	rejectRules := lookup.NewPolicyDB()
+	acceptRules := lookup.NewScopedPolicyDB(namespace)
+	rejectRules := lookup.NewScopedPolicyDB(namespace)
 
 	for _, rule := range policyRules {
 		if rule.Policy.Action&policy.Accept != 0 {
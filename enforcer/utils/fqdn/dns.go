@@ -0,0 +1,311 @@
+package fqdn
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// resolvConf is the configuration of the resolver of the host
+const resolvConf = "/etc/resolv.conf"
+
+// errNotFound is returned when the name doesn't exist
+var errNotFound = errors.New("no such host")
+
+// dnsResolver resolves the names with the name servers of the host. The
+// servers are queried directly so that the TTL of the records is known.
+type dnsResolver struct {
+	servers []string
+	timeout time.Duration
+}
+
+// NewResolver returns a resolver that queries the name servers of the host.
+// The TTL of the addresses is the lowest TTL of the records of the answers,
+// including the aliases of the name.
+func NewResolver() Resolver {
+
+	return &dnsResolver{
+		servers: nameServers(resolvConf),
+		timeout: resolveTimeout,
+	}
+}
+
+// nameServers returns the addresses of the name servers of a resolv.conf file.
+// The local server is used when the file has none, like the libc resolver.
+func nameServers(path string) []string {
+
+	servers := []string{}
+
+	if file, err := os.Open(path); err == nil {
+		defer file.Close() // nolint
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 || fields[0] != "nameserver" {
+				continue
+			}
+			if ip := net.ParseIP(fields[1]); ip != nil {
+				servers = append(servers, net.JoinHostPort(ip.String(), "53"))
+			}
+		}
+	}
+
+	if len(servers) == 0 {
+		servers = []string{"127.0.0.1:53", "[::1]:53"}
+	}
+
+	return servers
+}
+
+// Resolve implements the Resolver interface. The servers are tried in order
+// until one of them answers, within the timeout of the resolver.
+func (r *dnsResolver) Resolve(name string) ([]net.IP, time.Duration, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	if !strings.HasSuffix(name, ".") {
+		name = name + "."
+	}
+
+	err := fmt.Errorf("No name server")
+	for _, server := range r.servers {
+
+		var ips []net.IP
+		var ttl time.Duration
+		if ips, ttl, err = r.lookup(ctx, server, name); err == nil {
+			return ips, ttl, nil
+		}
+
+		if err == errNotFound || ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, 0, fmt.Errorf("Unable to resolve %s: %s", name, err)
+}
+
+// lookup queries a server for the IPv4 and IPv6 addresses of the name
+// concurrently
+func (r *dnsResolver) lookup(ctx context.Context, server string, name string) ([]net.IP, time.Duration, error) {
+
+	types := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+
+	ips := make([][]net.IP, len(types))
+	ttls := make([]time.Duration, len(types))
+	errs := make([]error, len(types))
+
+	var wg sync.WaitGroup
+	for i, qtype := range types {
+		wg.Add(1)
+		go func(i int, qtype dnsmessage.Type) {
+			defer wg.Done()
+			ips[i], ttls[i], errs[i] = query(ctx, server, name, qtype)
+		}(i, qtype)
+	}
+	wg.Wait()
+
+	addresses := []net.IP{}
+	var ttl time.Duration
+	for i := range types {
+		if errs[i] != nil || len(ips[i]) == 0 {
+			continue
+		}
+		if len(addresses) == 0 || ttls[i] < ttl {
+			ttl = ttls[i]
+		}
+		addresses = append(addresses, ips[i]...)
+	}
+
+	if len(addresses) == 0 {
+		for _, err := range errs {
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return nil, 0, errNotFound
+	}
+
+	return addresses, ttl, nil
+}
+
+// query sends a query to a server over UDP, or over TCP if the answer is
+// truncated, and returns the addresses of the answer and their TTL
+func query(ctx context.Context, server string, name string, qtype dnsmessage.Type) ([]net.IP, time.Duration, error) {
+
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	id := uint16(rand.Uint32()) // nolint
+
+	// The message starts with room for its length on TCP
+	b := dnsmessage.NewBuilder(make([]byte, 2, 514), dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err = b.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	if err = b.Question(dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, 0, err
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	answer, err := exchange(ctx, "udp", server, id, msg)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ips, ttl, truncated, err := parseAnswer(answer, qtype)
+	if err != nil || !truncated {
+		return ips, ttl, err
+	}
+
+	if answer, err = exchange(ctx, "tcp", server, id, msg); err != nil {
+		return nil, 0, err
+	}
+
+	ips, ttl, _, err = parseAnswer(answer, qtype)
+
+	return ips, ttl, err
+}
+
+// exchange sends a query to a server and returns the answer with the same ID
+func exchange(ctx context.Context, network string, server string, id uint16, msg []byte) ([]byte, error) {
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() // nolint
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	if network == "tcp" {
+		binary.BigEndian.PutUint16(msg, uint16(len(msg)-2))
+		if _, err = conn.Write(msg); err != nil {
+			return nil, err
+		}
+
+		length := make([]byte, 2)
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+
+		answer := make([]byte, binary.BigEndian.Uint16(length))
+		if _, err = io.ReadFull(conn, answer); err != nil {
+			return nil, err
+		}
+
+		return answer, nil
+	}
+
+	if _, err = conn.Write(msg[2:]); err != nil {
+		return nil, err
+	}
+
+	buffer := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+
+		// Ignore the answers to other queries
+		if n >= 2 && binary.BigEndian.Uint16(buffer) == id {
+			return buffer[:n], nil
+		}
+	}
+}
+
+// parseAnswer returns the addresses of an answer and the lowest TTL of its
+// records. Truncated is true if the answer must be retried over TCP.
+func parseAnswer(answer []byte, qtype dnsmessage.Type) (ips []net.IP, ttl time.Duration, truncated bool, err error) {
+
+	var p dnsmessage.Parser
+
+	h, err := p.Start(answer)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	if h.Truncated {
+		return nil, 0, true, nil
+	}
+
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, false, errNotFound
+	default:
+		return nil, 0, false, fmt.Errorf("Server failure %s", h.RCode)
+	}
+
+	if err = p.SkipAllQuestions(); err != nil {
+		return nil, 0, false, err
+	}
+
+	records := 0
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, 0, false, err
+		}
+
+		if rh.Class != dnsmessage.ClassINET || (rh.Type != qtype && rh.Type != dnsmessage.TypeCNAME) {
+			if err = p.SkipAnswer(); err != nil {
+				return nil, 0, false, err
+			}
+			continue
+		}
+
+		if recordTTL := time.Duration(rh.TTL) * time.Second; records == 0 || recordTTL < ttl {
+			ttl = recordTTL
+		}
+		records++
+
+		switch rh.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return nil, 0, false, err
+			}
+			ips = append(ips, net.IP(append([]byte{}, r.A[:]...)))
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, false, err
+			}
+			ips = append(ips, net.IP(append([]byte{}, r.AAAA[:]...)))
+		default:
+			if err = p.SkipAnswer(); err != nil {
+				return nil, 0, false, err
+			}
+		}
+	}
+
+	return ips, ttl, false, nil
+}
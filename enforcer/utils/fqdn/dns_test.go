@@ -0,0 +1,141 @@
+package fqdn
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveDNS answers the queries on the connection with the records of the
+// zone until the connection is closed
+func serveDNS(conn net.PacketConn, zone map[string][]dnsmessage.Resource) {
+
+	buffer := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+
+		var p dnsmessage.Parser
+		h, err := p.Start(buffer[:n])
+		if err != nil {
+			continue
+		}
+		q, err := p.Question()
+		if err != nil {
+			continue
+		}
+
+		records, ok := zone[q.Name.String()]
+		h.Response = true
+		if !ok {
+			h.RCode = dnsmessage.RCodeNameError
+		}
+
+		b := dnsmessage.NewBuilder(nil, h)
+		b.StartQuestions() // nolint
+		b.Question(q)      // nolint
+		b.StartAnswers()   // nolint
+		for _, r := range records {
+			if r.Header.Type != q.Type && r.Header.Type != dnsmessage.TypeCNAME {
+				continue
+			}
+			switch body := r.Body.(type) {
+			case *dnsmessage.AResource:
+				b.AResource(r.Header, *body) // nolint
+			case *dnsmessage.AAAAResource:
+				b.AAAAResource(r.Header, *body) // nolint
+			case *dnsmessage.CNAMEResource:
+				b.CNAMEResource(r.Header, *body) // nolint
+			}
+		}
+
+		answer, err := b.Finish()
+		if err != nil {
+			continue
+		}
+		conn.WriteTo(answer, addr) // nolint
+	}
+}
+
+func record(name string, ttl uint32, body dnsmessage.ResourceBody) dnsmessage.Resource {
+
+	rtype := dnsmessage.TypeA
+	switch body.(type) {
+	case *dnsmessage.AAAAResource:
+		rtype = dnsmessage.TypeAAAA
+	case *dnsmessage.CNAMEResource:
+		rtype = dnsmessage.TypeCNAME
+	}
+
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  rtype,
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		},
+		Body: body,
+	}
+}
+
+func TestDNSResolver(t *testing.T) {
+
+	Convey("Given a resolver with a name server", t, func() {
+
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer conn.Close() // nolint
+
+		go serveDNS(conn, map[string][]dnsmessage.Resource{
+			"api.example.com.": {
+				record("api.example.com.", 300, &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("lb.example.com.")}),
+				record("lb.example.com.", 60, &dnsmessage.AResource{A: [4]byte{10, 1, 1, 1}}),
+				record("lb.example.com.", 60, &dnsmessage.AResource{A: [4]byte{10, 1, 1, 2}}),
+				record("lb.example.com.", 30, &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}),
+			},
+		})
+
+		r := &dnsResolver{servers: []string{conn.LocalAddr().String()}, timeout: time.Second}
+
+		Convey("When I resolve a name, I should get its addresses and the lowest TTL of the records", func() {
+			ips, ttl, err := r.Resolve("api.example.com")
+			So(err, ShouldBeNil)
+			So(ips, ShouldHaveLength, 3)
+			So(ips[0].Equal(net.ParseIP("10.1.1.1")), ShouldBeTrue)
+			So(ips[1].Equal(net.ParseIP("10.1.1.2")), ShouldBeTrue)
+			So(ips[2].Equal(net.ParseIP("2001:db8::1")), ShouldBeTrue)
+			So(ttl, ShouldEqual, 30*time.Second)
+		})
+
+		Convey("When I resolve a name that doesn't exist, I should get an error", func() {
+			_, _, err := r.Resolve("www.example.com")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a resolv.conf file", t, func() {
+
+		file, err := ioutil.TempFile("", "resolv.conf")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name()) // nolint
+
+		_, err = file.WriteString("search example.com\nnameserver 10.0.0.53\nnameserver fd00::53\n")
+		So(err, ShouldBeNil)
+		So(file.Close(), ShouldBeNil)
+
+		Convey("The name servers should be read from the file", func() {
+			So(nameServers(file.Name()), ShouldResemble, []string{"10.0.0.53:53", "[fd00::53]:53"})
+		})
+
+		Convey("The local server should be used when there is no file", func() {
+			So(nameServers(file.Name()+".missing"), ShouldResemble, []string{"127.0.0.1:53", "[::1]:53"})
+		})
+	})
+}
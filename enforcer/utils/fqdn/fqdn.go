@@ -0,0 +1,356 @@
+package fqdn
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// MinTTL is the minimum time between two resolutions of a name. It is
+	// also the delay before retrying a name whose resolution failed.
+	MinTTL = 5 * time.Second
+
+	// MaxTTL is the maximum time between two resolutions of a name
+	MaxTTL = 10 * time.Minute
+
+	// resolveTimeout is the maximum duration of the resolution of a name
+	resolveTimeout = 5 * time.Second
+
+	// refreshInterval is the interval between two checks of the names to refresh
+	refreshInterval = time.Second
+)
+
+// Resolver resolves DNS names
+type Resolver interface {
+	// Resolve returns the addresses of the name and the TTL of the records
+	Resolve(name string) ([]net.IP, time.Duration, error)
+}
+
+// Handler is called when the addresses of a name change
+type Handler func(name string, ips []net.IP)
+
+// entry holds the addresses of a name and when they must be refreshed
+type entry struct {
+	ips     []net.IP
+	refresh time.Time
+	owners  map[string]bool
+}
+
+// Watcher follows the addresses of the DNS names used by a set of owners, like
+// the processing units. The names are resolved again when the TTL of their
+// records expires and the handlers are called when their addresses change.
+// The users of a shared watcher keep their owners apart with a prefix.
+type Watcher struct {
+	resolver  Resolver
+	handlers  map[int]Handler
+	handlerID int
+	names     map[string]*entry
+	owners    map[string][]string
+	users     int
+	stopCh    chan struct{}
+	sync.Mutex
+}
+
+var (
+	sharedWatcher *Watcher
+	sharedOnce    sync.Once
+)
+
+// NewWatcher creates a watcher that resolves the names with the resolver
+func NewWatcher(resolver Resolver) *Watcher {
+
+	return &Watcher{
+		resolver: resolver,
+		handlers: map[int]Handler{},
+		names:    map[string]*entry{},
+		owners:   map[string][]string{},
+	}
+}
+
+// SharedWatcher returns the watcher shared by the datapath and the supervisors
+// of the process, so that each name is only resolved once
+func SharedWatcher() *Watcher {
+
+	sharedOnce.Do(func() {
+		sharedWatcher = NewWatcher(NewResolver())
+	})
+
+	return sharedWatcher
+}
+
+// Subscribe adds a handler that is called when the addresses of a name change.
+// It returns the function that removes the handler.
+func (w *Watcher) Subscribe(handler Handler) func() {
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.handlerID++
+	id := w.handlerID
+	w.handlers[id] = handler
+
+	return func() {
+		w.Lock()
+		defer w.Unlock()
+
+		delete(w.handlers, id)
+	}
+}
+
+// Watch sets the names used by an owner. The names that are not watched yet
+// are resolved before Watch returns and the handler is not called for them,
+// so that the caller can get their addresses with Addresses.
+func (w *Watcher) Watch(owner string, names []string) {
+
+	w.Lock()
+	previous := w.owners[owner]
+	if len(names) > 0 {
+		w.owners[owner] = names
+	} else {
+		delete(w.owners, owner)
+	}
+
+	current := map[string]bool{}
+	unknown := []string{}
+	for _, name := range names {
+		current[name] = true
+		if e, ok := w.names[name]; ok {
+			e.owners[owner] = true
+			continue
+		}
+		w.names[name] = &entry{owners: map[string]bool{owner: true}}
+		unknown = append(unknown, name)
+	}
+
+	for _, name := range previous {
+		if e, ok := w.names[name]; ok && !current[name] {
+			delete(e.owners, owner)
+			if len(e.owners) == 0 {
+				delete(w.names, name)
+			}
+		}
+	}
+	w.Unlock()
+
+	ips, refresh := w.resolveAll(unknown, time.Now())
+
+	w.Lock()
+	defer w.Unlock()
+
+	for i, name := range unknown {
+		if e, ok := w.names[name]; ok && e.refresh.IsZero() {
+			e.ips = ips[i]
+			e.refresh = refresh[i]
+		}
+	}
+}
+
+// Unwatch removes the names used by an owner
+func (w *Watcher) Unwatch(owner string) {
+
+	w.Watch(owner, nil)
+}
+
+// Names returns the sorted names that are watched by the owners that start
+// with the prefix
+func (w *Watcher) Names(prefix string) []string {
+
+	w.Lock()
+	defer w.Unlock()
+
+	watched := map[string]bool{}
+	for owner, names := range w.owners {
+		if !strings.HasPrefix(owner, prefix) {
+			continue
+		}
+		for _, name := range names {
+			watched[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(watched))
+	for name := range watched {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Addresses returns the current addresses of a name
+func (w *Watcher) Addresses(name string) []net.IP {
+
+	w.Lock()
+	defer w.Unlock()
+
+	if e, ok := w.names[name]; ok {
+		return e.ips
+	}
+
+	return nil
+}
+
+// Start refreshes the names until Stop is called as many times as Start
+func (w *Watcher) Start() {
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.users++
+	if w.stopCh != nil {
+		return
+	}
+	w.stopCh = make(chan struct{})
+
+	go w.run(w.stopCh)
+}
+
+func (w *Watcher) run(stopCh chan struct{}) {
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.refresh(time.Now())
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Stop stops the refresh of the names
+func (w *Watcher) Stop() {
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.users == 0 {
+		return
+	}
+
+	w.users--
+	if w.users == 0 && w.stopCh != nil {
+		close(w.stopCh)
+		w.stopCh = nil
+	}
+}
+
+// refresh resolves the names whose records expired and calls the handler for
+// the names whose addresses changed
+func (w *Watcher) refresh(now time.Time) {
+
+	w.Lock()
+	expired := []string{}
+	for name, e := range w.names {
+		if !now.Before(e.refresh) {
+			expired = append(expired, name)
+		}
+	}
+	w.Unlock()
+
+	sort.Strings(expired)
+
+	ips, refresh := w.resolveAll(expired, now)
+
+	w.Lock()
+	changed := []int{}
+	for i, name := range expired {
+		e, ok := w.names[name]
+		if !ok {
+			continue
+		}
+		e.refresh = refresh[i]
+		if ips[i] == nil || equal(e.ips, ips[i]) {
+			continue
+		}
+		e.ips = ips[i]
+		changed = append(changed, i)
+	}
+
+	handlers := make([]Handler, 0, len(w.handlers))
+	for _, handler := range w.handlers {
+		handlers = append(handlers, handler)
+	}
+	w.Unlock()
+
+	for _, i := range changed {
+		for _, handler := range handlers {
+			handler(expired[i], ips[i])
+		}
+	}
+}
+
+// resolveAll resolves the names concurrently and returns their addresses and
+// when they must be refreshed in the order of the names
+func (w *Watcher) resolveAll(names []string, now time.Time) ([][]net.IP, []time.Time) {
+
+	ips := make([][]net.IP, len(names))
+	refresh := make([]time.Time, len(names))
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			ips[i], refresh[i] = w.resolve(name, now)
+		}(i, name)
+	}
+	wg.Wait()
+
+	return ips, refresh
+}
+
+// resolve resolves a name and returns its sorted addresses and when they must
+// be refreshed. The addresses are nil if the resolution failed, so that the
+// previous addresses are kept until the name resolves again.
+func (w *Watcher) resolve(name string, now time.Time) ([]net.IP, time.Time) {
+
+	ips, ttl, err := w.resolver.Resolve(name)
+	if err != nil {
+		zap.L().Warn("Unable to resolve name", zap.String("name", name), zap.Error(err))
+		return nil, now.Add(MinTTL)
+	}
+
+	if ttl < MinTTL {
+		ttl = MinTTL
+	} else if ttl > MaxTTL {
+		ttl = MaxTTL
+	}
+
+	sorted := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		sorted = append(sorted, ip)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	return sorted, now.Add(ttl)
+}
+
+// equal returns true if the sorted lists of addresses are equal
+func equal(a, b []net.IP) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
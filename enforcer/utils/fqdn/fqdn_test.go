@@ -0,0 +1,160 @@
+package fqdn
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// staticResolver resolves the names from a map
+type staticResolver struct {
+	records map[string][]net.IP
+	ttl     time.Duration
+	calls   int
+	sync.Mutex
+}
+
+func (r *staticResolver) Resolve(name string) ([]net.IP, time.Duration, error) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.calls++
+
+	ips, ok := r.records[name]
+	if !ok {
+		return nil, 0, fmt.Errorf("no such host")
+	}
+
+	return ips, r.ttl, nil
+}
+
+func TestWatcher(t *testing.T) {
+
+	Convey("Given a watcher with a resolver", t, func() {
+
+		resolver := &staticResolver{
+			records: map[string][]net.IP{
+				"api.example.com": {net.ParseIP("10.1.1.2"), net.ParseIP("10.1.1.1")},
+				"www.example.com": {net.ParseIP("2001:db8::1")},
+			},
+			ttl: time.Minute,
+		}
+
+		changes := map[string][]net.IP{}
+		w := NewWatcher(resolver)
+		w.Subscribe(func(name string, ips []net.IP) {
+			changes[name] = ips
+		})
+
+		w.Watch("pu1", []string{"api.example.com", "www.example.com"})
+
+		Convey("The new names should be resolved without calling the handler", func() {
+			So(w.Names(""), ShouldResemble, []string{"api.example.com", "www.example.com"})
+			So(w.Addresses("api.example.com"), ShouldResemble, []net.IP{net.ParseIP("10.1.1.1").To4(), net.ParseIP("10.1.1.2").To4()})
+			So(w.Addresses("www.example.com"), ShouldResemble, []net.IP{net.ParseIP("2001:db8::1")})
+			So(changes, ShouldBeEmpty)
+		})
+
+		Convey("When another owner watches the same name, it should not be resolved again", func() {
+			w.Watch("pu2", []string{"api.example.com"})
+			So(resolver.calls, ShouldEqual, 2)
+
+			Convey("The name should be kept until no owner uses it", func() {
+				w.Unwatch("pu1")
+				So(w.Names(""), ShouldResemble, []string{"api.example.com"})
+				w.Unwatch("pu2")
+				So(w.Names(""), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When other users watch names with their own owners", func() {
+			w.Watch("enforcer/pu1", []string{"api.example.com", "db.example.com"})
+
+			others := map[string][]net.IP{}
+			unsubscribe := w.Subscribe(func(name string, ips []net.IP) {
+				others[name] = ips
+			})
+
+			Convey("The names should be listed by the prefix of their owners", func() {
+				So(w.Names("enforcer/"), ShouldResemble, []string{"api.example.com", "db.example.com"})
+				So(w.Names("pu"), ShouldResemble, []string{"api.example.com", "www.example.com"})
+			})
+
+			Convey("All the handlers should be called until they unsubscribe", func() {
+				resolver.records["api.example.com"] = []net.IP{net.ParseIP("10.1.1.3")}
+				w.refresh(time.Now().Add(2 * time.Minute))
+				So(changes, ShouldContainKey, "api.example.com")
+				So(others, ShouldContainKey, "api.example.com")
+
+				unsubscribe()
+				resolver.records["api.example.com"] = []net.IP{net.ParseIP("10.1.1.4")}
+				w.refresh(time.Now().Add(4 * time.Minute))
+				So(changes["api.example.com"], ShouldResemble, []net.IP{net.ParseIP("10.1.1.4").To4()})
+				So(others["api.example.com"], ShouldResemble, []net.IP{net.ParseIP("10.1.1.3").To4()})
+			})
+		})
+
+		Convey("When the records change before their TTL expires", func() {
+			resolver.records["api.example.com"] = []net.IP{net.ParseIP("10.1.1.3")}
+			w.refresh(time.Now().Add(30 * time.Second))
+
+			Convey("The names should not be resolved again", func() {
+				So(resolver.calls, ShouldEqual, 2)
+				So(changes, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the records change and their TTL expires", func() {
+			resolver.records["api.example.com"] = []net.IP{net.ParseIP("10.1.1.3")}
+			w.refresh(time.Now().Add(2 * time.Minute))
+
+			Convey("The handler should be called for the names that changed", func() {
+				So(len(changes), ShouldEqual, 1)
+				So(changes["api.example.com"], ShouldResemble, []net.IP{net.ParseIP("10.1.1.3").To4()})
+				So(w.Addresses("api.example.com"), ShouldResemble, []net.IP{net.ParseIP("10.1.1.3").To4()})
+			})
+		})
+
+		Convey("When a name can no longer be resolved", func() {
+			delete(resolver.records, "api.example.com")
+			w.refresh(time.Now().Add(2 * time.Minute))
+
+			Convey("The previous addresses should be kept", func() {
+				So(changes, ShouldBeEmpty)
+				So(len(w.Addresses("api.example.com")), ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+// slowResolver takes some time to resolve the names
+type slowResolver struct {
+	delay time.Duration
+}
+
+func (r *slowResolver) Resolve(name string) ([]net.IP, time.Duration, error) {
+
+	time.Sleep(r.delay)
+
+	return []net.IP{net.ParseIP("10.1.1.1")}, time.Minute, nil
+}
+
+func TestWatcherConcurrency(t *testing.T) {
+
+	Convey("Given a watcher with a slow resolver", t, func() {
+
+		w := NewWatcher(&slowResolver{delay: 200 * time.Millisecond})
+
+		Convey("When I watch several names, they should be resolved concurrently", func() {
+			start := time.Now()
+			w.Watch("pu1", []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com"})
+			So(time.Since(start), ShouldBeLessThan, 600*time.Millisecond)
+			So(w.Addresses("d.example.com"), ShouldHaveLength, 1)
+		})
+	})
+}
@@ -4,14 +4,35 @@ const (
 	// minIPPacketLen is the min ip packet size
 	minIPPacketLen = 40
 
+	// minUDPPacketLen is the min ip packet size for UDP packets
+	minUDPPacketLen = 28
+
 	// minIPHdrSize
 	minIPHdrSize = 20
 
 	minIPHdrWords = (minIPHdrSize / 4)
+
+	// minIPv6PacketLen is the min ipv6 packet size
+	minIPv6PacketLen = 60
+
+	// minUDPIPv6PacketLen is the min ipv6 packet size for UDP packets
+	minUDPIPv6PacketLen = 48
+)
+
+// IP Versions
+const (
+	// IPVersion4 is the version of IPv4 packets
+	IPVersion4 = 4
+
+	// IPVersion6 is the version of IPv6 packets
+	IPVersion6 = 6
 )
 
 // IP Header field position constants
 const (
+	// ipVersionPos is the location of the IP version
+	ipVersionPos = 0
+
 	// ipHdrLenPos is location of IP (entire packet) length
 	ipHdrLenPos = 0
 
@@ -21,6 +42,9 @@ const (
 	// ipIDPos is location of IP Identifier
 	IPIDPos = 4
 
+	// ipTTLPos is the location of the IP TTL
+	ipTTLPos = 8
+
 	// ipProtoPos is the location of the IP Protocol
 	ipProtoPos = 9
 
@@ -34,6 +58,27 @@ const (
 	ipDestAddrPos = 16
 )
 
+// IPv6 Header field position constants
+const (
+	// ipv6PayloadLengthPos is the location of the IPv6 payload length
+	ipv6PayloadLengthPos = 4
+
+	// ipv6NextHeaderPos is the location of the IPv6 next header
+	ipv6NextHeaderPos = 6
+
+	// ipv6HopLimitPos is the location of the IPv6 hop limit
+	ipv6HopLimitPos = 7
+
+	// ipv6SourceAddrPos is location of the IPv6 source address
+	ipv6SourceAddrPos = 8
+
+	// ipv6DestAddrPos is location of the IPv6 destination address
+	ipv6DestAddrPos = 24
+
+	// ipv6HdrSize is the size of the IPv6 header. Extension headers are not supported
+	ipv6HdrSize = 40
+)
+
 // IP Protocol numbers
 const (
 	// IPProtocolTCP defines the constant for UDP protocol number
@@ -41,35 +86,47 @@ const (
 
 	// IPProtocolUDP defines the constant for UDP protocol number
 	IPProtocolUDP = 17
+
+	// IPProtocolICMP defines the constant for ICMP protocol number
+	IPProtocolICMP = 1
+
+	// IPProtocolICMPv6 defines the constant for ICMPv6 protocol number
+	IPProtocolICMPv6 = 58
 )
 
 // IP Header masks
 const (
 	ipHdrLenMask = 0xF
+
+	ipVersionShift = 4
 )
 
-// TCP Header field position constants
+// TCP Header field position constants. The positions are relative to
+// the beginning of the TCP header.
 const (
 	// tcpSourcePortPos is the location of source port
-	tcpSourcePortPos = 20
+	tcpSourcePortPos = 0
 
 	// tcpDestPortPos is the location of destination port
-	tcpDestPortPos = 22
+	tcpDestPortPos = 2
 
 	// tcpSeqPos is the location of seq
-	tcpSeqPos = 24
+	tcpSeqPos = 4
 
 	// tcpAckPos is the location of seq
-	tcpAckPos = 28
+	tcpAckPos = 8
 
 	// tcpDataOffsetPos is the location of the TCP data offset
-	tcpDataOffsetPos = 32
+	tcpDataOffsetPos = 12
 
 	//tcpFlagsOfsetPos is the location of the TCP flags
-	tcpFlagsOffsetPos = 33
+	tcpFlagsOffsetPos = 13
 
 	// TCPChecksumPos is the location of TCP checksum
-	TCPChecksumPos = 36
+	TCPChecksumPos = 16
+
+	// tcpOptionsPos is the location of the TCP options
+	tcpOptionsPos = 20
 )
 
 // TCP Header masks
@@ -93,6 +150,67 @@ const (
 	TCPFinMask = 0x1
 )
 
+// UDP Header field position constants. The positions are relative to
+// the beginning of the UDP header.
+const (
+	// udpLengthPos is the location of the UDP length
+	udpLengthPos = 4
+
+	// UDPChecksumPos is the location of the UDP checksum
+	UDPChecksumPos = 6
+
+	// udpHeaderLen is the length of the UDP header
+	udpHeaderLen = 8
+)
+
+// UDP Authentication Header related constants. The authentication header
+// is inserted in front of the UDP payload and is made of a 4 byte marker,
+// a 1 byte token type, 1 reserved byte and the 2 byte token length,
+// followed by the token itself.
+const (
+	// UDPAuthMarker identifies the beginning of the authentication header
+	UDPAuthMarker = "\xa5\x1c\x3e\x77"
+
+	// UDPAuthHeaderLen is the length of the authentication header without the token
+	UDPAuthHeaderLen = 8
+
+	// udpAuthTypePos is the location of the token type in the authentication header
+	udpAuthTypePos = 4
+
+	// udpAuthTokenLenPos is the location of the token length in the authentication header
+	udpAuthTokenLenPos = 6
+
+	// UDPSynMask identifies a UDP packet carrying the token of the flow initiator
+	UDPSynMask = uint8(0x1)
+
+	// UDPSynAckMask identifies a UDP packet carrying the token of the flow responder
+	UDPSynAckMask = uint8(0x2)
+)
+
+// ICMP related constants
+const (
+	// icmpHeaderLen is the length of the ICMP destination unreachable header
+	icmpHeaderLen = 8
+
+	// icmpDestinationUnreachable is the ICMP destination unreachable type
+	icmpDestinationUnreachable = 3
+
+	// icmpAdminProhibited is the ICMP code of communications administratively prohibited
+	icmpAdminProhibited = 13
+
+	// icmpv6DestinationUnreachable is the ICMPv6 destination unreachable type
+	icmpv6DestinationUnreachable = 1
+
+	// icmpv6AdminProhibited is the ICMPv6 code of communications administratively prohibited
+	icmpv6AdminProhibited = 1
+
+	// icmpv6MaxPacketLen is the maximum size of an ICMPv6 error packet (the IPv6 minimum MTU)
+	icmpv6MaxPacketLen = 1280
+
+	// replyTTL is the TTL or hop limit of the packets created in reply of other packets
+	replyTTL = 64
+)
+
 // TCP Options Related constants
 const (
 	// TCPAuthenticationOption is the option number will be using
@@ -103,4 +221,10 @@ const (
 
 	// TCPMssOptionLen is the type for MSS option
 	TCPMssOptionLen = uint8(4)
+
+	// tcpEndOfOptionList is the type of the option that terminates the options
+	tcpEndOfOptionList = uint8(0)
+
+	// tcpNoOperationOption is the type of the padding option
+	tcpNoOperationOption = uint8(1)
 )
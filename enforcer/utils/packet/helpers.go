@@ -8,6 +8,7 @@ import (
 	"strconv"
 
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 // Helpher functions for the package, mainly for debugging and validation
@@ -18,6 +19,11 @@ import (
 // modified.
 func (p *Packet) VerifyIPChecksum() bool {
 
+	// IPv6 headers have no checksum
+	if p.IsIPv6() {
+		return true
+	}
+
 	sum := p.computeIPChecksum()
 
 	return sum == p.ipChecksum
@@ -27,6 +33,10 @@ func (p *Packet) VerifyIPChecksum() bool {
 // packet with the value.
 func (p *Packet) UpdateIPChecksum() {
 
+	if p.IsIPv6() {
+		return
+	}
+
 	p.ipChecksum = p.computeIPChecksum()
 
 	binary.BigEndian.PutUint16(p.Buffer[ipChecksumPos:ipChecksumPos+2], p.ipChecksum)
@@ -48,7 +58,30 @@ func (p *Packet) UpdateTCPChecksum() {
 
 	p.TCPChecksum = p.computeTCPChecksum()
 
-	binary.BigEndian.PutUint16(p.Buffer[TCPChecksumPos:TCPChecksumPos+2], p.TCPChecksum)
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+TCPChecksumPos:p.l4BeginPos+TCPChecksumPos+2], p.TCPChecksum)
+}
+
+// VerifyUDPChecksum returns true if the UDP header checksum is correct
+// for this packet, false otherwise. A zero checksum means that the
+// sender didn't compute it and is always valid.
+func (p *Packet) VerifyUDPChecksum() bool {
+
+	if p.UDPChecksum == 0 {
+		return true
+	}
+
+	sum := p.computeUDPChecksum()
+
+	return sum == p.UDPChecksum
+}
+
+// UpdateUDPChecksum computes the UDP header checksum and updates the
+// packet with the value.
+func (p *Packet) UpdateUDPChecksum() {
+
+	p.UDPChecksum = p.computeUDPChecksum()
+
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+UDPChecksumPos:p.l4BeginPos+UDPChecksumPos+2], p.UDPChecksum)
 }
 
 // String returns a string representation of fields contained in this packet.
@@ -57,7 +90,14 @@ func (p *Packet) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("(error)")
 
-	header, err := ipv4.ParseHeader(p.Buffer)
+	var header fmt.Stringer
+	var err error
+
+	if p.IsIPv6() {
+		header, err = ipv6.ParseHeader(p.Buffer)
+	} else {
+		header, err = ipv4.ParseHeader(p.Buffer)
+	}
 
 	if err == nil {
 		buf.Reset()
@@ -92,12 +132,71 @@ func (p *Packet) computeIPChecksum() uint16 {
 // Computes the TCP header checksum. The packet is not modified.
 func (p *Packet) computeTCPChecksum() uint16 {
 
-	var pseudoHeaderLen uint16 = 12
 	tcpSize := uint16(len(p.Buffer)) - p.l4BeginPos
-	bufLen := pseudoHeaderLen + tcpSize
-	buf := make([]byte, bufLen)
 
-	// Construct the pseudo-header for TCP checksum computation:
+	// The pseudo-header length covers the TCP buffer (real header + payload)
+	buf := p.pseudoHeader(IPProtocolTCP, tcpSize+uint16(len(p.tcpData)+len(p.tcpOptions)))
+	pseudoHeaderLen := len(buf)
+
+	// The TCP buffer (real header + payload)
+	buf = append(buf, p.Buffer[p.l4BeginPos:]...)
+
+	// Set current checksum to zero (in buf, not changing packet)
+	buf[pseudoHeaderLen+TCPChecksumPos] = 0
+	buf[pseudoHeaderLen+TCPChecksumPos+1] = 0
+
+	buf = append(buf, p.tcpOptions...)
+	buf = append(buf, p.tcpData...)
+
+	return checksum(buf)
+}
+
+// Computes the UDP header checksum. The packet is not modified.
+func (p *Packet) computeUDPChecksum() uint16 {
+
+	udpSize := uint16(len(p.Buffer)) - p.l4BeginPos
+
+	// The pseudo-header length covers the UDP buffer (header + payload)
+	buf := p.pseudoHeader(IPProtocolUDP, udpSize)
+	pseudoHeaderLen := len(buf)
+
+	// The UDP buffer (header + payload)
+	buf = append(buf, p.Buffer[p.l4BeginPos:]...)
+
+	// Set current checksum to zero (in buf, not changing packet)
+	buf[pseudoHeaderLen+UDPChecksumPos] = 0
+	buf[pseudoHeaderLen+UDPChecksumPos+1] = 0
+
+	// A computed checksum of zero is transmitted as all ones
+	if csum := checksum(buf); csum != 0 {
+		return csum
+	}
+
+	return 0xFFFF
+}
+
+// pseudoHeader constructs the pseudo-header for the L4 checksum computation
+func (p *Packet) pseudoHeader(proto uint8, length uint16) []byte {
+
+	if p.IsIPv6() {
+		buf := make([]byte, 40)
+
+		// bytes 0-15: Source IP address
+		copy(buf[0:16], p.Buffer[ipv6SourceAddrPos:ipv6SourceAddrPos+16])
+
+		// bytes 16-31: Destination IP address
+		copy(buf[16:32], p.Buffer[ipv6DestAddrPos:ipv6DestAddrPos+16])
+
+		// bytes 32-35: L4 length
+		binary.BigEndian.PutUint32(buf[32:36], uint32(length))
+
+		// bytes 36-38: Constant zero, byte 39: Next header
+		buf[39] = proto
+
+		return buf
+	}
+
+	buf := make([]byte, 12)
 
 	// bytes 0-3: Source IP address
 	copy(buf[0:4], p.Buffer[ipSourceAddrPos:ipSourceAddrPos+4])
@@ -108,23 +207,13 @@ func (p *Packet) computeTCPChecksum() uint16 {
 	// byte 8: Constant zero
 	buf[8] = 0
 
-	// byte 9: Protocol (6==TCP)
-	buf[9] = 6
-
-	// bytes 10,11: TCP buffer size (real header + payload)
-	binary.BigEndian.PutUint16(buf[10:12], tcpSize+uint16(len(p.tcpData)+len(p.tcpOptions)))
+	// byte 9: Protocol
+	buf[9] = proto
 
-	// bytes 12+: The TCP buffer (real header + payload)
-	copy(buf[12:], p.Buffer[p.l4BeginPos:])
+	// bytes 10,11: L4 length
+	binary.BigEndian.PutUint16(buf[10:12], length)
 
-	// Set current checksum to zero (in buf, not changing packet)
-	buf[pseudoHeaderLen+16] = 0
-	buf[pseudoHeaderLen+17] = 0
-
-	buf = append(buf, p.tcpOptions...)
-	buf = append(buf, p.tcpData...)
-
-	return checksum(buf)
+	return buf
 }
 
 // incCsum16 implements rfc1624, equation 3.
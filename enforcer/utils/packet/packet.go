@@ -64,45 +64,140 @@ func New(context uint64, bytes []byte, mark string) (packet *Packet, err error)
 	p.tcpOptions = []byte{}
 	p.tcpData = []byte{}
 
+	if len(bytes) < minIPHdrSize {
+		return nil, fmt.Errorf("IP Packet too small (len=%d)", len(bytes))
+	}
+
 	// IP Header Processing
-	p.ipHeaderLen = bytes[ipHdrLenPos] & ipHdrLenMask
-	p.IPProto = bytes[ipProtoPos]
-	p.IPTotalLength = binary.BigEndian.Uint16(bytes[ipLengthPos : ipLengthPos+2])
-	p.ipID = binary.BigEndian.Uint16(bytes[IPIDPos : IPIDPos+2])
-	p.ipChecksum = binary.BigEndian.Uint16(bytes[ipChecksumPos : ipChecksumPos+2])
-	p.SourceAddress = net.IP(bytes[ipSourceAddrPos : ipSourceAddrPos+4])
-	p.DestinationAddress = net.IP(bytes[ipDestAddrPos : ipDestAddrPos+4])
+	p.IPVersion = bytes[ipVersionPos] >> ipVersionShift
+	if p.IPVersion == IPVersion6 {
+		err = p.parseIPv6Header()
+	} else {
+		err = p.parseIPv4Header()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.context = context
+
+	// UDP Header Processing
+	if p.IPProto == IPProtocolUDP {
+		p.SourcePort = binary.BigEndian.Uint16(p.Buffer[p.l4BeginPos+tcpSourcePortPos : p.l4BeginPos+tcpSourcePortPos+2])
+		p.DestinationPort = binary.BigEndian.Uint16(p.Buffer[p.l4BeginPos+tcpDestPortPos : p.l4BeginPos+tcpDestPortPos+2])
+		p.udpLength = binary.BigEndian.Uint16(p.Buffer[p.l4BeginPos+udpLengthPos : p.l4BeginPos+udpLengthPos+2])
+		p.UDPChecksum = binary.BigEndian.Uint16(p.Buffer[p.l4BeginPos+UDPChecksumPos : p.l4BeginPos+UDPChecksumPos+2])
+
+		if p.udpLength != p.IPTotalLength-p.l4BeginPos {
+			return nil, fmt.Errorf("Stated UDP length (%d) differs from IP payload length (%d)", p.udpLength, p.IPTotalLength-p.l4BeginPos)
+		}
+
+		return &p, nil
+	}
+
+	// TCP Header Processing
+	p.TCPChecksum = binary.BigEndian.Uint16(p.Buffer[p.l4BeginPos+TCPChecksumPos : p.l4BeginPos+TCPChecksumPos+2])
+	p.SourcePort = binary.BigEndian.Uint16(p.Buffer[p.l4BeginPos+tcpSourcePortPos : p.l4BeginPos+tcpSourcePortPos+2])
+	p.DestinationPort = binary.BigEndian.Uint16(p.Buffer[p.l4BeginPos+tcpDestPortPos : p.l4BeginPos+tcpDestPortPos+2])
+	p.TCPAck = binary.BigEndian.Uint32(p.Buffer[p.l4BeginPos+tcpAckPos : p.l4BeginPos+tcpAckPos+4])
+	p.TCPSeq = binary.BigEndian.Uint32(p.Buffer[p.l4BeginPos+tcpSeqPos : p.l4BeginPos+tcpSeqPos+4])
+	p.tcpDataOffset = (p.Buffer[p.l4BeginPos+tcpDataOffsetPos] & tcpDataOffsetMask) >> 4
+	p.TCPFlags = p.Buffer[p.l4BeginPos+tcpFlagsOffsetPos]
+
+	return &p, nil
+}
+
+// parseIPv4Header parses the IPv4 header of the packet
+func (p *Packet) parseIPv4Header() error {
+
+	p.ipHeaderLen = p.Buffer[ipHdrLenPos] & ipHdrLenMask
+	p.IPProto = p.Buffer[ipProtoPos]
+	p.IPTotalLength = binary.BigEndian.Uint16(p.Buffer[ipLengthPos : ipLengthPos+2])
+	p.ipID = binary.BigEndian.Uint16(p.Buffer[IPIDPos : IPIDPos+2])
+	p.ipChecksum = binary.BigEndian.Uint16(p.Buffer[ipChecksumPos : ipChecksumPos+2])
+	p.SourceAddress = net.IP(p.Buffer[ipSourceAddrPos : ipSourceAddrPos+4])
+	p.DestinationAddress = net.IP(p.Buffer[ipDestAddrPos : ipDestAddrPos+4])
+
+	minPacketLen := uint16(minIPPacketLen)
+	if p.IPProto == IPProtocolUDP {
+		minPacketLen = minUDPPacketLen
+	}
 
 	// Some sanity checking...
-	if p.IPTotalLength < minIPPacketLen {
-		return nil, fmt.Errorf("IP Packet too small (hdrlen=%d)", p.ipHeaderLen)
+	if p.IPTotalLength < minPacketLen {
+		return fmt.Errorf("IP Packet too small (hdrlen=%d)", p.ipHeaderLen)
 	}
 
 	if p.ipHeaderLen != minIPHdrWords {
-		return nil, fmt.Errorf("Packets with IP options not supported (hdrlen=%d)", p.ipHeaderLen)
+		return fmt.Errorf("Packets with IP options not supported (hdrlen=%d)", p.ipHeaderLen)
+	}
+
+	if err := p.checkIPTotalLength(); err != nil {
+		return err
+	}
+
+	p.l4BeginPos = minIPHdrSize
+
+	return nil
+}
+
+// parseIPv6Header parses the IPv6 header of the packet. Packets with
+// extension headers are not supported.
+func (p *Packet) parseIPv6Header() error {
+
+	if len(p.Buffer) < ipv6HdrSize {
+		return fmt.Errorf("IPv6 Packet too small (len=%d)", len(p.Buffer))
 	}
 
+	p.ipHeaderLen = ipv6HdrSize / 4
+	p.IPProto = p.Buffer[ipv6NextHeaderPos]
+	p.IPTotalLength = ipv6HdrSize + binary.BigEndian.Uint16(p.Buffer[ipv6PayloadLengthPos:ipv6PayloadLengthPos+2])
+	p.SourceAddress = net.IP(p.Buffer[ipv6SourceAddrPos : ipv6SourceAddrPos+net.IPv6len])
+	p.DestinationAddress = net.IP(p.Buffer[ipv6DestAddrPos : ipv6DestAddrPos+net.IPv6len])
+
+	var minPacketLen uint16
+	switch p.IPProto {
+	case IPProtocolTCP:
+		minPacketLen = minIPv6PacketLen
+	case IPProtocolUDP:
+		minPacketLen = minUDPIPv6PacketLen
+	default:
+		return fmt.Errorf("IPv6 Packets with extension headers not supported (nexthdr=%d)", p.IPProto)
+	}
+
+	// Some sanity checking...
+	if p.IPTotalLength < minPacketLen {
+		return fmt.Errorf("IPv6 Packet too small (len=%d)", p.IPTotalLength)
+	}
+
+	if err := p.checkIPTotalLength(); err != nil {
+		return err
+	}
+
+	p.l4BeginPos = ipv6HdrSize
+
+	return nil
+}
+
+// checkIPTotalLength validates the stated IP length against the buffer and
+// removes any trailing bytes.
+func (p *Packet) checkIPTotalLength() error {
+
 	if p.IPTotalLength != uint16(len(p.Buffer)) {
 		if p.IPTotalLength < uint16(len(p.Buffer)) {
 			p.Buffer = p.Buffer[:p.IPTotalLength]
 		} else {
-			return nil, fmt.Errorf("Stated IP packet length (%d) differs from bytes available (%d)", p.IPTotalLength, len(p.Buffer))
+			return fmt.Errorf("Stated IP packet length (%d) differs from bytes available (%d)", p.IPTotalLength, len(p.Buffer))
 		}
 	}
 
-	// TCP Header Processing
-	p.l4BeginPos = minIPHdrSize
-	p.TCPChecksum = binary.BigEndian.Uint16(bytes[TCPChecksumPos : TCPChecksumPos+2])
-	p.SourcePort = binary.BigEndian.Uint16(bytes[tcpSourcePortPos : tcpSourcePortPos+2])
-	p.DestinationPort = binary.BigEndian.Uint16(bytes[tcpDestPortPos : tcpDestPortPos+2])
-	p.TCPAck = binary.BigEndian.Uint32(bytes[tcpAckPos : tcpAckPos+4])
-	p.TCPSeq = binary.BigEndian.Uint32(bytes[tcpSeqPos : tcpSeqPos+4])
-	p.tcpDataOffset = (bytes[tcpDataOffsetPos] & tcpDataOffsetMask) >> 4
-	p.TCPFlags = bytes[tcpFlagsOffsetPos]
-
-	p.context = context
+	return nil
+}
 
-	return &p, nil
+// IsIPv6 returns true if this is an IPv6 packet
+func (p *Packet) IsIPv6() bool {
+	return p.IPVersion == IPVersion6
 }
 
 // IsEmptyTCPPayload returns the TCP data offset
@@ -188,8 +283,8 @@ func (p *Packet) Print(context uint64) {
 			p.ipID,
 			flagsToDir(p.context|context),
 			flagsToStr(p.context|context),
-			p.SourceAddress.To4().String(), p.SourcePort,
-			p.DestinationAddress.To4().String(), p.DestinationPort,
+			p.SourceAddress.String(), p.SourcePort,
+			p.DestinationAddress.String(), p.DestinationPort,
 			tcpFlagsToStr(p.TCPFlags),
 			p.TCPSeq, p.TCPAck, p.IPTotalLength-p.TCPDataStartBytes(),
 			expAck, expAck, p.tcpDataOffset,
@@ -199,6 +294,9 @@ func (p *Packet) Print(context uint64) {
 
 	if detailed {
 		pktBytes := []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 2, 8, 0}
+		if p.IsIPv6() {
+			pktBytes[12], pktBytes[13] = 0x86, 0xdd
+		}
 		pktBytes = append(pktBytes, p.Buffer...)
 		pktBytes = append(pktBytes, p.tcpOptions...)
 		pktBytes = append(pktBytes, p.tcpData...)
@@ -257,6 +355,14 @@ func (p *Packet) CheckTCPAuthenticationOption(iOptionLength int) (err error) {
 // FixupIPHdrOnDataModify modifies the IP header fields and checksum
 func (p *Packet) FixupIPHdrOnDataModify(old, new uint16) {
 
+	// IPv6 has no header checksum. Only the payload length is updated.
+	if p.IsIPv6() {
+		p.IPTotalLength = p.IPTotalLength + new - old
+
+		binary.BigEndian.PutUint16(p.Buffer[ipv6PayloadLengthPos:ipv6PayloadLengthPos+2], p.IPTotalLength-ipv6HdrSize)
+		return
+	}
+
 	// IP Header Processing
 	// IP chekcsum fixup.
 	p.ipChecksum = incCsum16(p.ipChecksum, old, new)
@@ -271,28 +377,76 @@ func (p *Packet) FixupIPHdrOnDataModify(old, new uint16) {
 func (p *Packet) IncreaseTCPSeq(incr uint32) {
 
 	p.TCPSeq = p.TCPSeq + incr
-	binary.BigEndian.PutUint32(p.Buffer[tcpSeqPos:tcpSeqPos+4], p.TCPSeq)
+	binary.BigEndian.PutUint32(p.Buffer[p.l4BeginPos+tcpSeqPos:p.l4BeginPos+tcpSeqPos+4], p.TCPSeq)
 }
 
 // DecreaseTCPSeq decreases TCP seq number by decr
 func (p *Packet) DecreaseTCPSeq(decr uint32) {
 
 	p.TCPSeq = p.TCPSeq - decr
-	binary.BigEndian.PutUint32(p.Buffer[tcpSeqPos:tcpSeqPos+4], p.TCPSeq)
+	binary.BigEndian.PutUint32(p.Buffer[p.l4BeginPos+tcpSeqPos:p.l4BeginPos+tcpSeqPos+4], p.TCPSeq)
 }
 
 // IncreaseTCPAck increases TCP ack number by incr
 func (p *Packet) IncreaseTCPAck(incr uint32) {
 
 	p.TCPAck = p.TCPAck + incr
-	binary.BigEndian.PutUint32(p.Buffer[tcpAckPos:tcpAckPos+4], p.TCPAck)
+	binary.BigEndian.PutUint32(p.Buffer[p.l4BeginPos+tcpAckPos:p.l4BeginPos+tcpAckPos+4], p.TCPAck)
 }
 
 // DecreaseTCPAck decreases TCP ack number by decr
 func (p *Packet) DecreaseTCPAck(decr uint32) {
 
 	p.TCPAck = p.TCPAck - decr
-	binary.BigEndian.PutUint32(p.Buffer[tcpAckPos:tcpAckPos+4], p.TCPAck)
+	binary.BigEndian.PutUint32(p.Buffer[p.l4BeginPos+tcpAckPos:p.l4BeginPos+tcpAckPos+4], p.TCPAck)
+}
+
+// DecreaseTCPMSS decreases the value of the TCP MSS option by decr. Packets
+// without an MSS option are not modified. The TCP checksum must be updated.
+func (p *Packet) DecreaseTCPMSS(decr uint16) {
+
+	i := p.l4BeginPos + tcpOptionsPos
+	end := p.TCPDataStartBytes()
+
+	for i < end && uint16(len(p.Buffer)) >= end {
+
+		switch p.Buffer[i] {
+		case tcpEndOfOptionList:
+			return
+		case tcpNoOperationOption:
+			i++
+			continue
+		}
+
+		if i+1 >= end || p.Buffer[i+1] < 2 {
+			return
+		}
+
+		if p.Buffer[i] == TCPMssOption && p.Buffer[i+1] == TCPMssOptionLen && i+uint16(TCPMssOptionLen) <= end {
+			mss := binary.BigEndian.Uint16(p.Buffer[i+2 : i+4])
+			if mss > decr {
+				binary.BigEndian.PutUint16(p.Buffer[i+2:i+4], mss-decr)
+			}
+			return
+		}
+
+		i = i + uint16(p.Buffer[i+1])
+	}
+}
+
+// TCPDataTrim removes the last length bytes of the TCP payload and updates the IP header
+func (p *Packet) TCPDataTrim(length uint16) error {
+
+	if uint16(len(p.Buffer)) < p.IPTotalLength || p.IPTotalLength-p.TCPDataStartBytes() < length {
+		return fmt.Errorf("Cannot trim %d bytes of TCP data: IPTotalLength=%d", length, p.IPTotalLength)
+	}
+
+	p.Buffer = p.Buffer[:p.IPTotalLength-length]
+
+	// IP Header Processing
+	p.FixupIPHdrOnDataModify(p.IPTotalLength, p.IPTotalLength-length)
+
+	return nil
 }
 
 // FixupTCPHdrOnTCPDataDetach modifies the TCP header fields and checksum
@@ -300,7 +454,7 @@ func (p *Packet) FixupTCPHdrOnTCPDataDetach(dataLength uint16, optionLength uint
 
 	// Update DataOffset
 	p.tcpDataOffset = p.tcpDataOffset - uint8(optionLength/4)
-	p.Buffer[tcpDataOffsetPos] = p.tcpDataOffset << 4
+	p.Buffer[p.l4BeginPos+tcpDataOffsetPos] = p.tcpDataOffset << 4
 }
 
 // tcpDataDetach splits the p.Buffer into p.Buffer (header + some options), p.tcpOptions (optionLength) and p.TCPData (dataLength)
@@ -360,8 +514,8 @@ func (p *Packet) FixupTCPHdrOnTCPDataAttach(tcpOptions []byte, tcpData []byte) {
 
 	// Modify the fields
 	p.tcpDataOffset = p.tcpDataOffset + uint8(numberOfOptions)
-	binary.BigEndian.PutUint16(p.Buffer[TCPChecksumPos:TCPChecksumPos+2], p.TCPChecksum)
-	p.Buffer[tcpDataOffsetPos] = p.tcpDataOffset << 4
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+TCPChecksumPos:p.l4BeginPos+TCPChecksumPos+2], p.TCPChecksum)
+	p.Buffer[p.l4BeginPos+tcpDataOffsetPos] = p.tcpDataOffset << 4
 }
 
 // tcpDataAttach splits the p.Buffer into p.Buffer (header + some options), p.tcpOptions (optionLength) and p.TCPData (dataLength)
@@ -429,3 +583,110 @@ func (p *Packet) TCPOptionLength() int {
 func (p *Packet) TCPDataLength() int {
 	return len(p.tcpData)
 }
+
+// UDPDataStartBytes provides the UDP data start offset in bytes
+func (p *Packet) UDPDataStartBytes() uint16 {
+	return p.l4BeginPos + udpHeaderLen
+}
+
+// ReadUDPData returns the UDP payload.
+// It does not remove the payload from the packet
+func (p *Packet) ReadUDPData() []byte {
+
+	if uint16(len(p.Buffer)) >= p.IPTotalLength && p.IPTotalLength >= p.UDPDataStartBytes() {
+		return p.Buffer[p.UDPDataStartBytes():p.IPTotalLength]
+	}
+
+	return []byte{}
+}
+
+// ReadUDPToken returns the type and the token of the UDP authentication header.
+// It returns an error if the payload doesn't start with an authentication header.
+func (p *Packet) ReadUDPToken() (uint8, []byte, error) {
+
+	data := p.ReadUDPData()
+
+	if len(data) < UDPAuthHeaderLen || string(data[:len(UDPAuthMarker)]) != UDPAuthMarker {
+		return 0, nil, fmt.Errorf("UDP authentication header not found")
+	}
+
+	tokenLength := int(binary.BigEndian.Uint16(data[udpAuthTokenLenPos : udpAuthTokenLenPos+2]))
+	if len(data) < UDPAuthHeaderLen+tokenLength {
+		return 0, nil, fmt.Errorf("UDP authentication token truncated: tokenLength=%d dataLength=%d", tokenLength, len(data))
+	}
+
+	return data[udpAuthTypePos], data[UDPAuthHeaderLen : UDPAuthHeaderLen+tokenLength], nil
+}
+
+// UDPTokenAttach inserts the authentication header and the token in front
+// of the UDP payload and updates the IP and UDP headers.
+func (p *Packet) UDPTokenAttach(tokenType uint8, token []byte) (err error) {
+
+	if uint16(len(p.Buffer)) != p.IPTotalLength {
+		return fmt.Errorf("UDP Token Attach failed: buffer length (%d) differs from IP length (%d)", len(p.Buffer), p.IPTotalLength)
+	}
+
+	attachLength := UDPAuthHeaderLen + len(token)
+	if int(p.IPTotalLength)+attachLength > 0xFFFF {
+		return fmt.Errorf("UDP Token Attach failed: token too large (%d)", len(token))
+	}
+
+	header := make([]byte, UDPAuthHeaderLen, attachLength)
+	copy(header, UDPAuthMarker)
+	header[udpAuthTypePos] = tokenType
+	binary.BigEndian.PutUint16(header[udpAuthTokenLenPos:udpAuthTokenLenPos+2], uint16(len(token)))
+	header = append(header, token...)
+
+	start := p.UDPDataStartBytes()
+	buffer := make([]byte, 0, len(p.Buffer)+attachLength)
+	buffer = append(buffer, p.Buffer[:start]...)
+	buffer = append(buffer, header...)
+	buffer = append(buffer, p.Buffer[start:]...)
+	p.Buffer = buffer
+
+	// IP Header Processing
+	p.FixupIPHdrOnDataModify(p.IPTotalLength, p.IPTotalLength+uint16(attachLength))
+
+	// UDP Header Processing
+	p.FixupUDPHdrOnDataModify()
+
+	return
+}
+
+// UDPTokenDetach removes the authentication header and the token from the
+// UDP payload and updates the IP and UDP headers.
+func (p *Packet) UDPTokenDetach() (err error) {
+
+	_, token, err := p.ReadUDPToken()
+	if err != nil {
+		return fmt.Errorf("UDP Token Detach failed: %s", err.Error())
+	}
+
+	start := p.UDPDataStartBytes()
+	detachLength := uint16(UDPAuthHeaderLen + len(token))
+
+	p.Buffer = append(p.Buffer[:start], p.Buffer[start+detachLength:p.IPTotalLength]...)
+
+	// IP Header Processing
+	p.FixupIPHdrOnDataModify(p.IPTotalLength, p.IPTotalLength-detachLength)
+
+	// UDP Header Processing
+	p.FixupUDPHdrOnDataModify()
+
+	return
+}
+
+// FixupUDPHdrOnDataModify updates the UDP length and checksum after the payload
+// has been modified. The IP header must have been updated already.
+func (p *Packet) FixupUDPHdrOnDataModify() {
+
+	p.udpLength = p.IPTotalLength - p.l4BeginPos
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+udpLengthPos:p.l4BeginPos+udpLengthPos+2], p.udpLength)
+
+	p.UpdateUDPChecksum()
+}
+
+// UDPDataLength returns the length of the UDP payload
+func (p *Packet) UDPDataLength() int {
+	return len(p.ReadUDPData())
+}
@@ -375,3 +375,313 @@ func getTestPacketWithError(t *testing.T, id SamplePacketName) error {
 	_, err := New(0, tmp, "0")
 	return err
 }
+
+// UDP packet from 10.1.1.1:12345 to 10.1.1.2:53 with payload "hello".
+// Everything is correct.
+var testUDPPacket = []byte{0x45, 0x00, 0x00, 0x21, 0x12, 0x34, 0x40, 0x00, 0x40, 0x11, 0x12,
+	0x94, 0x0a, 0x01, 0x01, 0x01, 0x0a, 0x01, 0x01, 0x02, 0x30, 0x39, 0x00, 0x35, 0x00, 0x0d,
+	0x75, 0x8f, 0x68, 0x65, 0x6c, 0x6c, 0x6f}
+
+func TestGoodUDPPacket(t *testing.T) {
+
+	t.Parallel()
+	pkt := getUDPTestPacket(t)
+
+	if !pkt.VerifyIPChecksum() {
+		t.Error("Test packet IP checksum failed")
+	}
+
+	if !pkt.VerifyUDPChecksum() {
+		t.Error("UDP checksum failed")
+	}
+
+	if pkt.SourcePort != 12345 || pkt.DestinationPort != 53 {
+		t.Errorf("Unexpected ports %d %d", pkt.SourcePort, pkt.DestinationPort)
+	}
+
+	if string(pkt.ReadUDPData()) != "hello" {
+		t.Errorf("Unexpected UDP payload %s", string(pkt.ReadUDPData()))
+	}
+
+	if _, _, err := pkt.ReadUDPToken(); err == nil {
+		t.Error("Expected no UDP authentication header")
+	}
+}
+
+func TestBadUDPLength(t *testing.T) {
+
+	t.Parallel()
+	tmp := make([]byte, len(testUDPPacket))
+	copy(tmp, testUDPPacket)
+	tmp[minIPHdrSize+udpLengthPos+1] = 0x0c
+
+	if _, err := New(0, tmp, "0"); err == nil {
+		t.Error("Expected failure for invalid UDP length")
+	}
+}
+
+func TestUDPTokenAttachDetach(t *testing.T) {
+
+	t.Parallel()
+	pkt := getUDPTestPacket(t)
+	token := []byte("this is a token")
+
+	if err := pkt.UDPTokenAttach(UDPSynMask, token); err != nil {
+		t.Fatal(err)
+	}
+
+	if int(pkt.IPTotalLength) != len(testUDPPacket)+UDPAuthHeaderLen+len(token) {
+		t.Errorf("Unexpected IP length after attach %d", pkt.IPTotalLength)
+	}
+
+	if !pkt.VerifyIPChecksum() || !pkt.VerifyUDPChecksum() {
+		t.Error("Checksums failed after attach")
+	}
+
+	// Parse the modified packet as it would be received
+	received, err := New(0, pkt.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenType, rcvToken, err := received.ReadUDPToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tokenType != UDPSynMask || string(rcvToken) != string(token) {
+		t.Errorf("Unexpected token %d %s", tokenType, string(rcvToken))
+	}
+
+	if err := received.UDPTokenDetach(); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(received.GetBytes()) != string(testUDPPacket) {
+		t.Error("Packet after detach doesn't match the original packet")
+	}
+
+	if err := received.UDPTokenDetach(); err == nil {
+		t.Error("Expected failure when detaching a token twice")
+	}
+}
+
+func TestUDPTruncatedToken(t *testing.T) {
+
+	t.Parallel()
+	pkt := getUDPTestPacket(t)
+
+	if err := pkt.UDPTokenAttach(UDPSynAckMask, []byte("token")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Declare a token length larger than the payload
+	pkt.Buffer[pkt.UDPDataStartBytes()+udpAuthTokenLenPos+1] = 0xFF
+
+	if _, _, err := pkt.ReadUDPToken(); err == nil {
+		t.Error("Expected failure for truncated token")
+	}
+}
+
+func getUDPTestPacket(t *testing.T) *Packet {
+
+	tmp := make([]byte, len(testUDPPacket))
+	copy(tmp, testUDPPacket)
+
+	pkt, err := New(0, tmp, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+// IPv6 SYN packet from [2001:db8::1]:40000 to [2001:db8::2]:80
+var testIPv6TCPPacket = []byte{0x60, 0x00, 0x00, 0x00, 0x00, 0x14, 0x06, 0x40,
+	0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+	0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02,
+	0x9c, 0x40, 0x00, 0x50, 0x00, 0x00, 0x03, 0xe8, 0x00, 0x00, 0x00, 0x00, 0x50, 0x02, 0xff, 0xff,
+	0xb3, 0xf5, 0x00, 0x00}
+
+// IPv6 UDP packet from [2001:db8::1]:12345 to [2001:db8::2]:53 with payload "hello"
+var testIPv6UDPPacket = []byte{0x60, 0x00, 0x00, 0x00, 0x00, 0x0d, 0x11, 0x40,
+	0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+	0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02,
+	0x30, 0x39, 0x00, 0x35, 0x00, 0x0d, 0x30, 0x1f, 0x68, 0x65, 0x6c, 0x6c, 0x6f}
+
+func TestGoodIPv6TCPPacket(t *testing.T) {
+
+	t.Parallel()
+	pkt := getIPv6TestPacket(t, testIPv6TCPPacket)
+
+	if !pkt.IsIPv6() {
+		t.Error("Expected an IPv6 packet")
+	}
+
+	if pkt.SourceAddress.String() != "2001:db8::1" || pkt.DestinationAddress.String() != "2001:db8::2" {
+		t.Errorf("Unexpected addresses %s %s", pkt.SourceAddress, pkt.DestinationAddress)
+	}
+
+	if pkt.SourcePort != 40000 || pkt.DestinationPort != 80 {
+		t.Errorf("Unexpected ports %d %d", pkt.SourcePort, pkt.DestinationPort)
+	}
+
+	if pkt.TCPFlags&TCPSynMask == 0 || pkt.TCPSeq != 1000 {
+		t.Errorf("Unexpected TCP header flags=%x seq=%d", pkt.TCPFlags, pkt.TCPSeq)
+	}
+
+	if !pkt.VerifyTCPChecksum() {
+		t.Error("TCP checksum failed")
+	}
+}
+
+func TestIPv6TCPDataAttachDetach(t *testing.T) {
+
+	t.Parallel()
+	pkt := getIPv6TestPacket(t, testIPv6TCPPacket)
+
+	options := []byte{TCPAuthenticationOption, 4, 0, 0}
+	if err := pkt.TCPDataAttach(options, []byte("token")); err != nil {
+		t.Fatal(err)
+	}
+	pkt.UpdateTCPChecksum()
+
+	buffer := pkt.GetBytes()
+	if len(buffer) != len(testIPv6TCPPacket)+len(options)+len("token") {
+		t.Errorf("Unexpected packet length %d", len(buffer))
+	}
+
+	reparsed, err := New(0, buffer, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reparsed.VerifyTCPChecksum() {
+		t.Error("TCP checksum failed after attach")
+	}
+
+	if string(reparsed.ReadTCPData()) != "token" {
+		t.Errorf("Unexpected TCP payload %s", string(reparsed.ReadTCPData()))
+	}
+
+	if err := reparsed.TCPDataDetach(uint16(len(options))); err != nil {
+		t.Fatal(err)
+	}
+	reparsed.UpdateTCPChecksum()
+
+	if reparsed.IPTotalLength != uint16(len(testIPv6TCPPacket)) || !reparsed.VerifyTCPChecksum() {
+		t.Error("Packet not restored after detach")
+	}
+}
+
+func TestGoodIPv6UDPPacket(t *testing.T) {
+
+	t.Parallel()
+	pkt := getIPv6TestPacket(t, testIPv6UDPPacket)
+
+	if !pkt.VerifyUDPChecksum() {
+		t.Error("UDP checksum failed")
+	}
+
+	if string(pkt.ReadUDPData()) != "hello" {
+		t.Errorf("Unexpected UDP payload %s", string(pkt.ReadUDPData()))
+	}
+
+	if err := pkt.UDPTokenAttach(UDPSynMask, []byte("token")); err != nil {
+		t.Fatal(err)
+	}
+
+	if !pkt.VerifyUDPChecksum() {
+		t.Error("UDP checksum failed after attach")
+	}
+
+	reparsed, err := New(0, pkt.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, token, err := reparsed.ReadUDPToken(); err != nil || string(token) != "token" {
+		t.Errorf("Unexpected UDP token %s: %v", string(token), err)
+	}
+}
+
+func TestIPv6ExtensionHeader(t *testing.T) {
+
+	t.Parallel()
+
+	tmp := make([]byte, len(testIPv6TCPPacket))
+	copy(tmp, testIPv6TCPPacket)
+	// Hop-by-hop options header
+	tmp[ipv6NextHeaderPos] = 0
+
+	if _, err := New(0, tmp, "0"); err == nil {
+		t.Error("Expected failure for IPv6 extension headers")
+	}
+}
+
+func getIPv6TestPacket(t *testing.T, buffer []byte) *Packet {
+
+	tmp := make([]byte, len(buffer))
+	copy(tmp, buffer)
+
+	pkt, err := New(0, tmp, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+func TestDecreaseTCPMSS(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synGoodTCPChecksum)
+
+	pkt.DecreaseTCPMSS(16)
+	pkt.UpdateTCPChecksum()
+
+	reparsed, err := New(0, pkt.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reparsed.VerifyTCPChecksum() {
+		t.Error("TCP checksum failed after MSS update")
+	}
+
+	mssPos := minIPHdrSize + tcpOptionsPos + 2
+	if mss := uint16(reparsed.Buffer[mssPos])<<8 | uint16(reparsed.Buffer[mssPos+1]); mss != 0xffd7-16 {
+		t.Errorf("Unexpected MSS %d", mss)
+	}
+}
+
+func TestIPv6TCPDataTrim(t *testing.T) {
+
+	t.Parallel()
+	pkt := getIPv6TestPacket(t, testIPv6TCPPacket)
+
+	if err := pkt.TCPDataAttach([]byte{}, []byte("payloadtrailer")); err != nil {
+		t.Fatal(err)
+	}
+	pkt.UpdateTCPChecksum()
+
+	reparsed, err := New(0, pkt.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reparsed.TCPDataTrim(uint16(len("trailer"))); err != nil {
+		t.Fatal(err)
+	}
+	reparsed.UpdateTCPChecksum()
+
+	if string(reparsed.ReadTCPData()) != "payload" {
+		t.Errorf("Unexpected TCP payload %s", string(reparsed.ReadTCPData()))
+	}
+
+	if reparsed.IPTotalLength != uint16(len(testIPv6TCPPacket)+len("payload")) || !reparsed.VerifyTCPChecksum() {
+		t.Error("Packet not updated after trim")
+	}
+
+	if err := reparsed.TCPDataTrim(uint16(len("payload") + 1)); err == nil {
+		t.Error("Trimmed more bytes than the TCP payload")
+	}
+}
@@ -0,0 +1,129 @@
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// TCPResetReply creates a TCP RST packet that resets the connection of the
+// packet. The reset is sent from the destination of the packet to its source.
+// A Syn packet is acknowledged without its data, since the data of the Syn
+// packets is attached by the enforcers and not by the initiator.
+func (p *Packet) TCPResetReply() ([]byte, error) {
+
+	if p.IPProto != IPProtocolTCP {
+		return nil, fmt.Errorf("Reset requested for a non TCP packet (proto=%d)", p.IPProto)
+	}
+
+	ipHeader := p.replyIPHeader(IPProtocolTCP, tcpOptionsPos)
+	buffer := append(ipHeader, make([]byte, tcpOptionsPos)...)
+	tcp := buffer[len(ipHeader):]
+
+	binary.BigEndian.PutUint16(tcp[tcpSourcePortPos:], p.DestinationPort)
+	binary.BigEndian.PutUint16(tcp[tcpDestPortPos:], p.SourcePort)
+	tcp[tcpDataOffsetPos] = (tcpOptionsPos / 4) << 4
+
+	// RFC 793: a segment with an ACK is reset with its acknowledgment number
+	// as sequence number. Otherwise the reset acknowledges the segment.
+	if p.TCPFlags&TCPAckMask != 0 {
+		binary.BigEndian.PutUint32(tcp[tcpSeqPos:], p.TCPAck)
+		tcp[tcpFlagsOffsetPos] = TCPRstMask
+	} else {
+		ack := p.TCPSeq
+		if p.TCPFlags&TCPSynMask != 0 {
+			ack++
+		} else {
+			ack += uint32(p.TCPDataLength())
+		}
+		if p.TCPFlags&TCPFinMask != 0 {
+			ack++
+		}
+		binary.BigEndian.PutUint32(tcp[tcpAckPos:], ack)
+		tcp[tcpFlagsOffsetPos] = TCPRstMask | TCPAckMask
+	}
+
+	reply, err := New(0, buffer, "")
+	if err != nil {
+		return nil, err
+	}
+
+	reply.UpdateIPChecksum()
+	reply.UpdateTCPChecksum()
+
+	return reply.Buffer, nil
+}
+
+// ICMPProhibitedReply creates an ICMP destination unreachable packet with the
+// communications administratively prohibited code, in reply to the packet. The
+// ICMPv6 variant is created for IPv6 packets.
+func (p *Packet) ICMPProhibitedReply() ([]byte, error) {
+
+	original := p.Buffer
+
+	if p.IsIPv6() {
+		// The error message must fit in the minimum MTU
+		if max := icmpv6MaxPacketLen - ipv6HdrSize - icmpHeaderLen; len(original) > max {
+			original = original[:max]
+		}
+
+		buffer := append(p.replyIPHeader(IPProtocolICMPv6, icmpHeaderLen+len(original)), make([]byte, icmpHeaderLen)...)
+		buffer = append(buffer, original...)
+
+		icmp := buffer[ipv6HdrSize:]
+		icmp[0] = icmpv6DestinationUnreachable
+		icmp[1] = icmpv6AdminProhibited
+
+		pseudoHeader := make([]byte, 40)
+		copy(pseudoHeader[0:32], buffer[ipv6SourceAddrPos:ipv6SourceAddrPos+32])
+		binary.BigEndian.PutUint32(pseudoHeader[32:36], uint32(len(icmp)))
+		pseudoHeader[39] = IPProtocolICMPv6
+
+		binary.BigEndian.PutUint16(icmp[2:4], checksum(append(pseudoHeader, icmp...)))
+
+		return buffer, nil
+	}
+
+	// The IP header and the first 8 bytes of the payload are returned
+	if max := int(p.ipHeaderLen)*4 + 8; len(original) > max {
+		original = original[:max]
+	}
+
+	ipHeader := p.replyIPHeader(IPProtocolICMP, icmpHeaderLen+len(original))
+	buffer := append(ipHeader, make([]byte, icmpHeaderLen)...)
+	buffer = append(buffer, original...)
+
+	icmp := buffer[len(ipHeader):]
+	icmp[0] = icmpDestinationUnreachable
+	icmp[1] = icmpAdminProhibited
+	binary.BigEndian.PutUint16(icmp[2:4], checksum(icmp))
+
+	binary.BigEndian.PutUint16(buffer[ipChecksumPos:ipChecksumPos+2], checksum(buffer[:minIPHdrSize]))
+
+	return buffer, nil
+}
+
+// replyIPHeader creates the IP header of a packet in reply to the packet, with
+// the source and destination addresses swapped. The IPv4 checksum is not set.
+func (p *Packet) replyIPHeader(proto uint8, payloadLength int) []byte {
+
+	if p.IsIPv6() {
+		header := make([]byte, ipv6HdrSize)
+		header[ipVersionPos] = IPVersion6 << ipVersionShift
+		binary.BigEndian.PutUint16(header[ipv6PayloadLengthPos:], uint16(payloadLength))
+		header[ipv6NextHeaderPos] = proto
+		header[ipv6HopLimitPos] = replyTTL
+		copy(header[ipv6SourceAddrPos:ipv6SourceAddrPos+16], p.DestinationAddress.To16())
+		copy(header[ipv6DestAddrPos:ipv6DestAddrPos+16], p.SourceAddress.To16())
+		return header
+	}
+
+	header := make([]byte, minIPHdrSize)
+	header[ipVersionPos] = IPVersion4<<ipVersionShift | minIPHdrWords
+	binary.BigEndian.PutUint16(header[ipLengthPos:], uint16(minIPHdrSize+payloadLength))
+	header[ipTTLPos] = replyTTL
+	header[ipProtoPos] = proto
+	copy(header[ipSourceAddrPos:ipSourceAddrPos+4], p.DestinationAddress.To4())
+	copy(header[ipDestAddrPos:ipDestAddrPos+4], p.SourceAddress.To4())
+
+	return header
+}
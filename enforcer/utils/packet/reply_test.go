@@ -0,0 +1,143 @@
+package packet
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestTCPResetReplyToSyn(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synGoodTCPChecksum)
+
+	buffer, err := pkt.TCPResetReply()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	reply, err := New(PacketTypeNetwork, buffer, "0")
+	if err != nil {
+		t.Fatalf("Reset is not a valid packet: %s", err)
+	}
+
+	if !reply.SourceAddress.Equal(pkt.DestinationAddress) || !reply.DestinationAddress.Equal(pkt.SourceAddress) {
+		t.Errorf("Unexpected addresses %s %s", reply.SourceAddress, reply.DestinationAddress)
+	}
+
+	if reply.SourcePort != pkt.DestinationPort || reply.DestinationPort != pkt.SourcePort {
+		t.Errorf("Unexpected ports %d %d", reply.SourcePort, reply.DestinationPort)
+	}
+
+	if reply.TCPFlags != TCPRstMask|TCPAckMask {
+		t.Errorf("Unexpected flags %x", reply.TCPFlags)
+	}
+
+	if reply.TCPSeq != 0 || reply.TCPAck != pkt.TCPSeq+1 {
+		t.Errorf("Unexpected seq=%d ack=%d", reply.TCPSeq, reply.TCPAck)
+	}
+
+	if !reply.VerifyIPChecksum() || !reply.VerifyTCPChecksum() {
+		t.Error("Reset checksums are wrong")
+	}
+}
+
+func TestTCPResetReplyToAck(t *testing.T) {
+
+	t.Parallel()
+	pkt := getIPv6TestPacket(t, testIPv6TCPPacket)
+	pkt.TCPFlags = TCPAckMask
+	pkt.TCPAck = 5000
+
+	buffer, err := pkt.TCPResetReply()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	reply := getIPv6TestPacket(t, buffer)
+
+	if reply.SourceAddress.String() != "2001:db8::2" || reply.DestinationAddress.String() != "2001:db8::1" {
+		t.Errorf("Unexpected addresses %s %s", reply.SourceAddress, reply.DestinationAddress)
+	}
+
+	if reply.TCPFlags != TCPRstMask || reply.TCPSeq != 5000 {
+		t.Errorf("Unexpected flags=%x seq=%d", reply.TCPFlags, reply.TCPSeq)
+	}
+
+	if !reply.VerifyTCPChecksum() {
+		t.Error("Reset checksum is wrong")
+	}
+}
+
+func TestTCPResetReplyToUDP(t *testing.T) {
+
+	t.Parallel()
+	pkt := getIPv6TestPacket(t, testIPv6UDPPacket)
+
+	if _, err := pkt.TCPResetReply(); err == nil {
+		t.Error("Expected an error for a UDP packet")
+	}
+}
+
+func TestICMPProhibitedReply(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synGoodTCPChecksum)
+
+	buffer, err := pkt.ICMPProhibitedReply()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if len(buffer) != minIPHdrSize+icmpHeaderLen+minIPHdrSize+8 {
+		t.Fatalf("Unexpected length %d", len(buffer))
+	}
+
+	if int(binary.BigEndian.Uint16(buffer[ipLengthPos:])) != len(buffer) || buffer[ipProtoPos] != IPProtocolICMP {
+		t.Error("Unexpected IP header")
+	}
+
+	if checksum(buffer[:minIPHdrSize]) != 0 || checksum(buffer[minIPHdrSize:]) != 0 {
+		t.Error("Checksums are wrong")
+	}
+
+	icmp := buffer[minIPHdrSize:]
+	if icmp[0] != icmpDestinationUnreachable || icmp[1] != icmpAdminProhibited {
+		t.Errorf("Unexpected type=%d code=%d", icmp[0], icmp[1])
+	}
+
+	if string(icmp[icmpHeaderLen:]) != string(pkt.Buffer[:minIPHdrSize+8]) {
+		t.Error("Original packet not included")
+	}
+}
+
+func TestICMPv6ProhibitedReply(t *testing.T) {
+
+	t.Parallel()
+	pkt := getIPv6TestPacket(t, testIPv6TCPPacket)
+
+	buffer, err := pkt.ICMPProhibitedReply()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if buffer[ipv6NextHeaderPos] != IPProtocolICMPv6 || int(binary.BigEndian.Uint16(buffer[ipv6PayloadLengthPos:])) != len(buffer)-ipv6HdrSize {
+		t.Error("Unexpected IPv6 header")
+	}
+
+	icmp := buffer[ipv6HdrSize:]
+	if icmp[0] != icmpv6DestinationUnreachable || icmp[1] != icmpv6AdminProhibited {
+		t.Errorf("Unexpected type=%d code=%d", icmp[0], icmp[1])
+	}
+
+	pseudoHeader := make([]byte, 40)
+	copy(pseudoHeader[0:32], buffer[ipv6SourceAddrPos:ipv6DestAddrPos+16])
+	binary.BigEndian.PutUint32(pseudoHeader[32:36], uint32(len(icmp)))
+	pseudoHeader[39] = IPProtocolICMPv6
+	if checksum(append(pseudoHeader, icmp...)) != 0 {
+		t.Error("ICMPv6 checksum is wrong")
+	}
+
+	if string(icmp[icmpHeaderLen:]) != string(pkt.Buffer) {
+		t.Error("Original packet not included")
+	}
+}
@@ -0,0 +1,132 @@
+package packetsource
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Verdict is the verdict of the datapath for a packet of a ChannelSource
+type Verdict struct {
+	ID     uint32
+	Mark   uint32
+	Accept bool
+	Buffer []byte
+}
+
+// ChannelSource is an in-memory packet source. Packets are injected in the source
+// and the verdicts are delivered on a channel. Packets are processed one at a
+// time in the order they were injected. It doesn't require netfilter privileges.
+type ChannelSource struct {
+	packets  chan *Packet
+	verdicts chan *Verdict
+	stop     chan struct{}
+	nextID   uint32
+	started  bool
+	stopped  bool
+	sync.Mutex
+}
+
+// NewChannelSource creates a new in-memory packet source. The size is the number
+// of packets and verdicts that can be queued.
+func NewChannelSource(size int) *ChannelSource {
+
+	return &ChannelSource{
+		packets:  make(chan *Packet, size),
+		verdicts: make(chan *Verdict, size),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start implements the PacketSource interface
+func (s *ChannelSource) Start(handler Handler) error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.started {
+		return fmt.Errorf("Channel source already started")
+	}
+
+	s.started = true
+
+	go func() {
+		for {
+			select {
+			case p := <-s.packets:
+				handler(p)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop implements the PacketSource interface
+func (s *ChannelSource) Stop() error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.stopped {
+		return fmt.Errorf("Channel source already stopped")
+	}
+
+	s.stopped = true
+	close(s.stop)
+
+	return nil
+}
+
+// SetVerdict implements the VerdictSink interface. It blocks until the verdict
+// is read from the verdict channel or the source is stopped.
+func (s *ChannelSource) SetVerdict(p *Packet, accept bool, buffer []byte) error {
+
+	verdict := &Verdict{
+		ID:     p.ID,
+		Mark:   p.Mark,
+		Accept: accept,
+		Buffer: buffer,
+	}
+
+	select {
+	case s.verdicts <- verdict:
+		return nil
+	case <-s.stop:
+		return fmt.Errorf("Channel source stopped")
+	}
+}
+
+// Inject queues a copy of the buffer for processing and returns the ID of the
+// packet. It blocks if the queue is full until the source is stopped.
+func (s *ChannelSource) Inject(buffer []byte, mark uint32) (uint32, error) {
+
+	// A stopped source must never accept a packet, even if the queue has room
+	select {
+	case <-s.stop:
+		return 0, fmt.Errorf("Channel source stopped")
+	default:
+	}
+
+	p := &Packet{
+		ID:     atomic.AddUint32(&s.nextID, 1),
+		Mark:   mark,
+		Buffer: append([]byte{}, buffer...),
+		Sink:   s,
+	}
+
+	select {
+	case s.packets <- p:
+		return p.ID, nil
+	case <-s.stop:
+		return 0, fmt.Errorf("Channel source stopped")
+	}
+}
+
+// Verdicts returns the channel of the verdicts for the injected packets
+func (s *ChannelSource) Verdicts() <-chan *Verdict {
+
+	return s.verdicts
+}
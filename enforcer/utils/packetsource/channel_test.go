@@ -0,0 +1,67 @@
+package packetsource
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChannelSource(t *testing.T) {
+
+	Convey("Given I create a channel source", t, func() {
+
+		source := NewChannelSource(10)
+		received := make(chan *Packet, 10)
+
+		So(source.Start(func(p *Packet) { received <- p }), ShouldBeNil)
+
+		Convey("When I start it again, I should get an error", func() {
+			So(source.Start(func(p *Packet) {}), ShouldNotBeNil)
+			So(source.Stop(), ShouldBeNil)
+		})
+
+		Convey("When I inject a packet", func() {
+
+			buffer := []byte{0x45, 0x00}
+			id, err := source.Inject(buffer, 0x10)
+			So(err, ShouldBeNil)
+			buffer[0] = 0
+
+			var p *Packet
+			select {
+			case p = <-received:
+			case <-time.After(time.Second):
+			}
+
+			Convey("Then the handler should receive a copy of the packet", func() {
+				So(p, ShouldNotBeNil)
+				So(p.ID, ShouldEqual, id)
+				So(p.Mark, ShouldEqual, 0x10)
+				So(p.Buffer, ShouldResemble, []byte{0x45, 0x00})
+				So(source.Stop(), ShouldBeNil)
+			})
+
+			Convey("Then the verdict should be delivered on the verdict channel", func() {
+				So(p.Sink.SetVerdict(p, true, []byte{0x45, 0x01}), ShouldBeNil)
+
+				verdict := <-source.Verdicts()
+				So(verdict.ID, ShouldEqual, id)
+				So(verdict.Mark, ShouldEqual, 0x10)
+				So(verdict.Accept, ShouldBeTrue)
+				So(verdict.Buffer, ShouldResemble, []byte{0x45, 0x01})
+				So(source.Stop(), ShouldBeNil)
+			})
+		})
+
+		Convey("When I stop the source", func() {
+			So(source.Stop(), ShouldBeNil)
+
+			Convey("Then I should not be able to inject packets or stop it again", func() {
+				_, err := source.Inject([]byte{0x45}, 0)
+				So(err, ShouldNotBeNil)
+				So(source.Stop(), ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,111 @@
+// +build linux
+
+package packetsource
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	nfqueue "github.com/aporeto-inc/netlink-go/nfqueue"
+	"go.uber.org/zap"
+)
+
+// NFQueueSource is a packet source that receives the packets from a range of
+// netfilter queues
+type NFQueueSource struct {
+	queueStart uint16
+	numQueues  uint16
+	queueSize  uint32
+	handler    Handler
+	queues     []nfqueue.Verdict
+	sync.Mutex
+}
+
+// nfqueueVerdict sets the verdict of a packet received from a netfilter queue
+type nfqueueVerdict struct {
+	packet *nfqueue.NFPacket
+}
+
+// NewNFQueueSource creates a new packet source for the netfilter queues starting
+// at queueStart
+func NewNFQueueSource(queueStart uint16, numQueues uint16, queueSize uint32) *NFQueueSource {
+
+	return &NFQueueSource{
+		queueStart: queueStart,
+		numQueues:  numQueues,
+		queueSize:  queueSize,
+	}
+}
+
+func nfqueueCallback(packet *nfqueue.NFPacket, s interface{}) {
+
+	s.(*NFQueueSource).handler(&Packet{
+		ID:     uint32(packet.ID),
+		Mark:   uint32(packet.Mark),
+		Buffer: packet.Buffer,
+		Sink:   &nfqueueVerdict{packet: packet},
+	})
+}
+
+func nfqueueErrorCallback(err error, data interface{}) {
+	zap.L().Error("Error while processing packets on queue", zap.Error(err))
+}
+
+// Start implements the PacketSource interface. It retries to create each queue
+// before giving up.
+func (s *NFQueueSource) Start(handler Handler) error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.handler = handler
+	s.queues = make([]nfqueue.Verdict, 0, s.numQueues)
+
+	for i := uint16(0); i < s.numQueues; i++ {
+
+		queue, err := nfqueue.CreateAndStartNfQueue(s.queueStart+i, s.queueSize, nfqueue.NfDefaultPacketSize, nfqueueCallback, nfqueueErrorCallback, s)
+		for retry := 0; retry < 5 && err != nil; retry++ {
+			<-time.After(3 * time.Second)
+			queue, err = nfqueue.CreateAndStartNfQueue(s.queueStart+i, s.queueSize, nfqueue.NfDefaultPacketSize, nfqueueCallback, nfqueueErrorCallback, s)
+		}
+
+		if err != nil {
+			return fmt.Errorf("Unable to initialize netfilter queue %d: %s", s.queueStart+i, err)
+		}
+
+		s.queues = append(s.queues, queue)
+	}
+
+	return nil
+}
+
+// Stop implements the PacketSource interface
+func (s *NFQueueSource) Stop() error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	for _, queue := range s.queues {
+		if err := queue.StopQueue(); err != nil {
+			zap.L().Error("Error when stoping nfq", zap.Error(err))
+		}
+	}
+
+	s.queues = nil
+
+	return nil
+}
+
+// SetVerdict implements the VerdictSink interface
+func (v *nfqueueVerdict) SetVerdict(p *Packet, accept bool, buffer []byte) error {
+
+	verdict := uint32(0)
+	if accept {
+		verdict = 1
+	}
+
+	v.packet.QueueHandle.SetVerdict2(uint32(v.packet.QueueHandle.QueueNum), verdict, p.Mark, uint32(len(buffer)), p.ID, buffer)
+
+	return nil
+}
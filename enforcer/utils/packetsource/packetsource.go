@@ -0,0 +1,41 @@
+package packetsource
+
+// Packet is a raw packet received from a PacketSource. The datapath must set
+// the verdict of every packet it receives with the VerdictSink of the packet.
+type Packet struct {
+	// ID identifies the packet in its source
+	ID uint32
+	// Mark is the mark of the packet
+	Mark uint32
+	// Buffer holds the bytes of the packet starting with the IP header
+	Buffer []byte
+	// Sink receives the verdict of the packet
+	Sink VerdictSink
+}
+
+// Handler processes the packets delivered by a PacketSource
+type Handler func(p *Packet)
+
+// PacketSource is the interface to the sources of the packets processed by
+// the datapath
+type PacketSource interface {
+	// Start starts delivering packets to the handler. The handler can be
+	// called concurrently by sources that have multiple queues.
+	Start(handler Handler) error
+	// Stop stops delivering packets
+	Stop() error
+}
+
+// VerdictSink is the interface that receives the verdicts of the datapath
+type VerdictSink interface {
+	// SetVerdict accepts or drops a packet. Accepted packets are transmitted
+	// with the provided buffer, which includes any modification of the datapath.
+	SetVerdict(p *Packet, accept bool, buffer []byte) error
+}
+
+// PacketWriter is the interface used by the datapath to inject packets that it
+// creates, such as the resets of rejected connections
+type PacketWriter interface {
+	// WritePacket transmits the packet in the buffer, starting with the IP header
+	WritePacket(buffer []byte) error
+}
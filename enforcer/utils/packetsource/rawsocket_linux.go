@@ -0,0 +1,111 @@
+// +build linux
+
+package packetsource
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+// RawSocketWriter is a packet writer that transmits the packets with raw IP
+// sockets. The packets must include their IP header. The sockets are opened
+// on first use and require the CAP_NET_RAW capability.
+type RawSocketWriter struct {
+	fd4 int
+	fd6 int
+	sync.Mutex
+}
+
+// NewRawSocketWriter creates a new raw socket packet writer
+func NewRawSocketWriter() *RawSocketWriter {
+
+	return &RawSocketWriter{
+		fd4: -1,
+		fd6: -1,
+	}
+}
+
+// WritePacket implements the PacketWriter interface
+func (w *RawSocketWriter) WritePacket(buffer []byte) error {
+
+	if len(buffer) == 0 {
+		return fmt.Errorf("Empty packet")
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	switch buffer[0] >> 4 {
+	case 4:
+		if len(buffer) < 20 {
+			return fmt.Errorf("IPv4 packet too small (len=%d)", len(buffer))
+		}
+
+		if w.fd4 < 0 {
+			fd, err := rawSocket(syscall.AF_INET)
+			if err != nil {
+				return err
+			}
+			w.fd4 = fd
+		}
+
+		addr := &syscall.SockaddrInet4{}
+		copy(addr.Addr[:], buffer[16:20])
+
+		return syscall.Sendto(w.fd4, buffer, 0, addr)
+
+	case 6:
+		if len(buffer) < 40 {
+			return fmt.Errorf("IPv6 packet too small (len=%d)", len(buffer))
+		}
+
+		if w.fd6 < 0 {
+			fd, err := rawSocket(syscall.AF_INET6)
+			if err != nil {
+				return err
+			}
+			w.fd6 = fd
+		}
+
+		addr := &syscall.SockaddrInet6{}
+		copy(addr.Addr[:], buffer[24:40])
+
+		return syscall.Sendto(w.fd6, buffer, 0, addr)
+	}
+
+	return fmt.Errorf("Unknown IP version %d", buffer[0]>>4)
+}
+
+// Close closes the sockets of the writer
+func (w *RawSocketWriter) Close() error {
+
+	w.Lock()
+	defer w.Unlock()
+
+	var err error
+
+	for _, fd := range []*int{&w.fd4, &w.fd6} {
+		if *fd < 0 {
+			continue
+		}
+		if cerr := syscall.Close(*fd); cerr != nil {
+			err = cerr
+		}
+		*fd = -1
+	}
+
+	return err
+}
+
+// rawSocket opens a raw socket of the family. IPPROTO_RAW sockets imply that
+// the packets include their IP header.
+func rawSocket(family int) (int, error) {
+
+	fd, err := syscall.Socket(family, syscall.SOCK_RAW, syscall.IPPROTO_RAW)
+	if err != nil {
+		return -1, fmt.Errorf("Unable to open raw socket: %s", err)
+	}
+
+	return fd, nil
+}
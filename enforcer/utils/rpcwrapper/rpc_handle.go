@@ -237,4 +237,11 @@ func RegisterTypes() {
 	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Supervise_Request_Payload", *(&SuperviseRequestPayload{}))
 	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.UnSupervise_Payload", *(&UnSupervisePayload{}))
 	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Stats_Payload", *(&StatsPayload{}))
+
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Connections_Payload", *(&ConnectionsPayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Connections_Response_Payload", *(&ConnectionsResponsePayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.EvaluateFlow_Payload", *(&EvaluateFlowPayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.EvaluateFlow_Response_Payload", *(&EvaluateFlowResponsePayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.DriftStats_Payload", *(&DriftStatsPayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.DriftStats_Response_Payload", *(&DriftStatsResponsePayload{}))
 }
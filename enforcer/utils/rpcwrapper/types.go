@@ -17,6 +17,8 @@ const (
 	IPTables CaptureType = iota
 	// IPSets forces an IPSet implementation
 	IPSets
+	// NFTables forces an nftables implementation
+	NFTables
 )
 
 //Request exported
@@ -34,7 +36,8 @@ const (
 //Response is the response for every RPC call. This is used to carry the status of the actual function call
 //made on the remote end
 type Response struct {
-	Status string
+	Status  string
+	Payload interface{} `json:",omitempty"`
 }
 
 //InitRequestPayload Payload for enforcer init request
@@ -54,8 +57,9 @@ type InitRequestPayload struct {
 
 //InitSupervisorPayload for supervisor init request
 type InitSupervisorPayload struct {
-	TriremeNetworks []string    `json:",omitempty"`
-	CaptureMethod   CaptureType `json:",omitempty"`
+	TriremeNetworks   []string      `json:",omitempty"`
+	CaptureMethod     CaptureType   `json:",omitempty"`
+	ReconcileInterval time.Duration `json:",omitempty"`
 }
 
 // EnforcePayload Payload for enforce request
@@ -72,6 +76,8 @@ type EnforcePayload struct {
 	TransmitterRules policy.TagSelectorList `json:",omitempty"`
 	TriremeNetworks  []string               `json:",omitempty"`
 	ExcludedNetworks []string               `json:",omitempty"`
+	RejectAction     policy.RejectAction    `json:",omitempty"`
+	Generation       uint64                 `json:",omitempty"`
 }
 
 //SuperviseRequestPayload for Supervise request
@@ -88,6 +94,7 @@ type SuperviseRequestPayload struct {
 	TransmitterRules policy.TagSelectorList `json:",omitempty"`
 	ExcludedNetworks []string               `json:",omitempty"`
 	TriremeNetworks  []string               `json:",omitempty"`
+	Generation       uint64                 `json:",omitempty"`
 }
 
 //UnEnforcePayload payload for unenforce request
@@ -125,6 +132,37 @@ type StatsPayload struct {
 	Flows map[string]*collector.FlowRecord `json:",omitempty"`
 }
 
+//ConnectionsPayload payload for connections request
+type ConnectionsPayload struct {
+	ContextID string `json:",omitempty"`
+}
+
+//ConnectionsResponsePayload carries the connections tracked by the remote enforcer
+type ConnectionsResponsePayload struct {
+	Connections []*collector.ConnectionRecord `json:",omitempty"`
+}
+
+//EvaluateFlowPayload payload for flow evaluation request
+type EvaluateFlowPayload struct {
+	ContextID string            `json:",omitempty"`
+	Query     *policy.FlowQuery `json:",omitempty"`
+}
+
+//EvaluateFlowResponsePayload carries the decision of the remote enforcer for a flow
+type EvaluateFlowResponsePayload struct {
+	Decision *policy.FlowDecision `json:",omitempty"`
+}
+
+//DriftStatsPayload payload for drift stats request
+type DriftStatsPayload struct {
+	ContextID string `json:",omitempty"`
+}
+
+//DriftStatsResponsePayload carries the drifts of the rules repaired by the remote supervisor
+type DriftStatsResponsePayload struct {
+	Stats *collector.DriftStats `json:",omitempty"`
+}
+
 //ExcludeIPRequestPayload carries the list of excluded ips
 type ExcludeIPRequestPayload struct {
 	IPs []string `json:",omitempty"`
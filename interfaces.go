@@ -13,6 +13,9 @@ type Trireme interface {
 	// PURuntime returns a getter for a specific contextID.
 	PURuntime(contextID string) (policy.RuntimeReader, error)
 
+	// PolicyGeneration returns the generation of the active policy of a specific contextID.
+	PolicyGeneration(contextID string) (uint64, error)
+
 	// Start starts the component.
 	Start() error
 
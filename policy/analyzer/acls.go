@@ -0,0 +1,137 @@
+package analyzer
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// aclRange is the range of addresses and ports of an ACL
+type aclRange struct {
+	network  *net.IPNet
+	protocol string
+	min      int
+	max      int
+	accept   bool
+}
+
+// newACLRange parses an ACL. The ports of the protocols other than TCP and UDP
+// are ignored.
+func newACLRange(rule policy.IPRule) (*aclRange, error) {
+
+	address := rule.Address
+	if !strings.Contains(address, "/") {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+			address += "/128"
+		} else {
+			address += "/32"
+		}
+	}
+
+	_, network, err := net.ParseCIDR(address)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid address %s", rule.Address)
+	}
+
+	r := &aclRange{
+		network:  network,
+		protocol: strings.ToLower(rule.Protocol),
+		min:      0,
+		max:      65535,
+		accept:   rule.Policy != nil && rule.Policy.Action&policy.Accept != 0,
+	}
+
+	if r.protocol != "tcp" && r.protocol != "udp" {
+		return r, nil
+	}
+
+	parts := strings.SplitN(rule.Port, ":", 2)
+	if r.min, err = strconv.Atoi(parts[0]); err != nil {
+		return nil, fmt.Errorf("Invalid port %s", rule.Port)
+	}
+
+	r.max = r.min
+	if len(parts) == 2 {
+		if r.max, err = strconv.Atoi(parts[1]); err != nil {
+			return nil, fmt.Errorf("Invalid port %s", rule.Port)
+		}
+	}
+
+	return r, nil
+}
+
+// overlap returns the network and the ports common to two ranges, or nil
+func (r *aclRange) overlap(o *aclRange) (*net.IPNet, int, int) {
+
+	if r.protocol != o.protocol {
+		return nil, 0, 0
+	}
+
+	min, max := r.min, r.max
+	if o.min > min {
+		min = o.min
+	}
+	if o.max < max {
+		max = o.max
+	}
+	if min > max {
+		return nil, 0, 0
+	}
+
+	rOnes, _ := r.network.Mask.Size()
+	oOnes, _ := o.network.Mask.Size()
+
+	switch {
+	case rOnes >= oOnes && o.network.Contains(r.network.IP):
+		return r.network, min, max
+	case oOnes >= rOnes && r.network.Contains(o.network.IP):
+		return o.network, min, max
+	}
+
+	return nil, 0, 0
+}
+
+// analyzeACLs reports the ACLs that overlap a previous ACL with a conflicting
+// action. The enforcer doesn't order the ACLs of different networks, so the
+// action applied to the overlap is undefined. The ACLs that can't be parsed
+// are ignored.
+func analyzeACLs(set RuleSet, rules policy.IPRuleList) []*Finding {
+
+	findings := []*Finding{}
+
+	ranges := make([]*aclRange, len(rules))
+	for i, rule := range rules {
+		ranges[i], _ = newACLRange(rule)
+	}
+
+	for j, r := range ranges {
+		if r == nil {
+			continue
+		}
+
+		for i, o := range ranges[:j] {
+			if o == nil || o.accept == r.accept {
+				continue
+			}
+
+			network, min, max := r.overlap(o)
+			if network == nil {
+				continue
+			}
+
+			findings = append(findings, &Finding{
+				Kind:    ConflictingACLs,
+				Rules:   set,
+				Index:   j,
+				Other:   i,
+				Message: fmt.Sprintf("the ACL overlaps %s[%d] with a conflicting action on %s %s ports %d-%d", set, i, network, r.protocol, min, max),
+			})
+			break
+		}
+	}
+
+	return findings
+}
@@ -0,0 +1,79 @@
+package analyzer
+
+import (
+	"fmt"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// Kind is the kind of a problem found in a policy
+type Kind string
+
+const (
+	// ShadowedSelector is an accept selector that never applies because a
+	// reject selector matches all the tags it matches
+	ShadowedSelector Kind = "shadowed"
+	// UnreachableSelector is a selector that never applies, because it can't
+	// match any tags or because a selector with the same action and a higher
+	// priority matches all the tags it matches
+	UnreachableSelector Kind = "unreachable"
+	// ConflictingACLs are ACLs whose networks and ports overlap and whose
+	// actions conflict
+	ConflictingACLs Kind = "conflicting"
+	// UnknownTag is a clause that references a tag that no processing unit carries
+	UnknownTag Kind = "unknown-tag"
+)
+
+// RuleSet identifies a list of rules of a policy
+type RuleSet string
+
+const (
+	// TransmitterRules are the tag selectors of the transmitter
+	TransmitterRules RuleSet = "transmitterRules"
+	// ReceiverRules are the tag selectors of the receiver
+	ReceiverRules RuleSet = "receiverRules"
+	// ApplicationACLs are the ACLs of the traffic to external networks
+	ApplicationACLs RuleSet = "applicationACLs"
+	// NetworkACLs are the ACLs of the traffic from external networks
+	NetworkACLs RuleSet = "networkACLs"
+)
+
+// Finding is a problem found in a policy
+type Finding struct {
+	Kind  Kind
+	Rules RuleSet
+	// Index is the index of the rule in its rule set
+	Index int
+	// Other is the index of the rule that shadows or conflicts with the rule,
+	// or -1 if the problem only concerns the rule
+	Other   int
+	Message string
+}
+
+func (f *Finding) String() string {
+
+	return fmt.Sprintf("%s[%d]: %s: %s", f.Rules, f.Index, f.Kind, f.Message)
+}
+
+// Analyze analyzes a policy and returns the problems found in its rules.
+// The identities are the identity tags of all the processing units, and the
+// clauses that reference tags none of them carries are reported. The tags are
+// not checked if identities is nil.
+func Analyze(p *policy.PUPolicy, identities []*policy.TagStore) []*Finding {
+
+	findings := []*Finding{}
+
+	findings = append(findings, analyzeSelectors(TransmitterRules, p.TransmitterRules())...)
+	findings = append(findings, analyzeSelectors(ReceiverRules, p.ReceiverRules())...)
+
+	if identities != nil {
+		tags := newTagIndex(identities)
+		findings = append(findings, tags.analyze(TransmitterRules, p.TransmitterRules())...)
+		findings = append(findings, tags.analyze(ReceiverRules, p.ReceiverRules())...)
+	}
+
+	findings = append(findings, analyzeACLs(ApplicationACLs, p.ApplicationACLs())...)
+	findings = append(findings, analyzeACLs(NetworkACLs, p.NetworkACLs())...)
+
+	return findings
+}
@@ -0,0 +1,304 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func selector(action policy.ActionType, priority int, clauses ...policy.KeyValueOperator) policy.TagSelector {
+
+	return policy.TagSelector{
+		Clause:   clauses,
+		Policy:   &policy.FlowPolicy{Action: action},
+		Priority: priority,
+	}
+}
+
+func clause(key string, operator policy.Operator, values ...string) policy.KeyValueOperator {
+
+	return policy.KeyValueOperator{
+		Key:      key,
+		Value:    values,
+		Operator: operator,
+	}
+}
+
+func acl(address, protocol, port string, action policy.ActionType) policy.IPRule {
+
+	return policy.IPRule{
+		Address:  address,
+		Port:     port,
+		Protocol: protocol,
+		Policy:   &policy.FlowPolicy{Action: action},
+	}
+}
+
+func analyze(receiverRules policy.TagSelectorList, netACLs policy.IPRuleList, identities []*policy.TagStore) []*Finding {
+
+	p := policy.NewPUPolicy("pu", policy.Police, nil, netACLs, nil, receiverRules, nil, nil, nil, nil, nil)
+
+	return Analyze(p, identities)
+}
+
+func TestAnalyzeSelectors(t *testing.T) {
+
+	Convey("Given an accept selector narrower than a reject selector, it should be reported as shadowed", t, func() {
+		findings := analyze(policy.TagSelectorList{
+			selector(policy.Reject, 0, clause("env", policy.HasPrefix, "prod")),
+			selector(policy.Accept, 10, clause("app", policy.Equal, "web"), clause("env", policy.In, "prod-eu", "prod-us")),
+		}, nil, nil)
+
+		So(findings, ShouldHaveLength, 1)
+		So(findings[0].Kind, ShouldEqual, ShadowedSelector)
+		So(findings[0].Rules, ShouldEqual, ReceiverRules)
+		So(findings[0].Index, ShouldEqual, 1)
+		So(findings[0].Other, ShouldEqual, 0)
+		So(findings[0].String(), ShouldEqual, "receiverRules[1]: shadowed: all the tags it matches are rejected by receiverRules[0]")
+	})
+
+	Convey("Given an accept selector broader than a reject selector, nothing should be reported", t, func() {
+		findings := analyze(policy.TagSelectorList{
+			selector(policy.Reject, 0, clause("app", policy.Equal, "web"), clause("env", policy.Equal, "dev")),
+			selector(policy.Accept, 0, clause("app", policy.Equal, "web")),
+		}, nil, nil)
+
+		So(findings, ShouldBeEmpty)
+	})
+
+	Convey("Given an accept selector narrower than a scheduled reject selector, nothing should be reported", t, func() {
+		reject := selector(policy.Reject, 0, clause("app", policy.KeyExists))
+		reject.Policy.Schedule = &policy.Schedule{Windows: []policy.Window{{Start: time.Hour, End: 2 * time.Hour}}}
+
+		findings := analyze(policy.TagSelectorList{
+			reject,
+			selector(policy.Accept, 0, clause("app", policy.Equal, "web")),
+		}, nil, nil)
+
+		So(findings, ShouldBeEmpty)
+	})
+
+	Convey("Given selectors restricted by ports and protocols", t, func() {
+
+		Convey("When the accept selector ports are covered by the reject selector, it should be reported as shadowed", func() {
+			reject := selector(policy.Reject, 0, clause("app", policy.KeyExists))
+			reject.Ports = []policy.PortRange{{Min: 8000, Max: 8499}, {Min: 8500, Max: 8999}}
+			accept := selector(policy.Accept, 0, clause("app", policy.Equal, "web"))
+			accept.Ports = []policy.PortRange{{Min: 8080, Max: 8080}, {Min: 8443, Max: 8600}}
+			accept.Protocols = []string{"tcp"}
+
+			findings := analyze(policy.TagSelectorList{reject, accept}, nil, nil)
+			So(findings, ShouldHaveLength, 1)
+			So(findings[0].Kind, ShouldEqual, ShadowedSelector)
+		})
+
+		Convey("When the accept selector has other ports or protocols, nothing should be reported", func() {
+			reject := selector(policy.Reject, 0, clause("app", policy.KeyExists))
+			reject.Ports = []policy.PortRange{{Min: 8000, Max: 8999}}
+			reject.Protocols = []string{"udp"}
+			accept := selector(policy.Accept, 0, clause("app", policy.Equal, "web"))
+			accept.Ports = []policy.PortRange{{Min: 8080, Max: 8080}}
+			other := selector(policy.Accept, 0, clause("app", policy.Equal, "db"))
+			other.Protocols = []string{"udp"}
+			other.Ports = []policy.PortRange{{Min: 8080, Max: 9000}}
+
+			findings := analyze(policy.TagSelectorList{reject, accept, other}, nil, nil)
+			So(findings, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given selectors with the same action", t, func() {
+
+		Convey("When a narrower selector has a lower priority, it should be reported as unreachable", func() {
+			findings := analyze(policy.TagSelectorList{
+				selector(policy.Accept, 0, clause("version", policy.GreaterThan, "2.1"), clause("app", policy.Equal, "web")),
+				selector(policy.Accept|policy.Encrypt, 5, clause("version", policy.GreaterOrEqual, "2", "3")),
+			}, nil, nil)
+
+			So(findings, ShouldHaveLength, 1)
+			So(findings[0].Kind, ShouldEqual, UnreachableSelector)
+			So(findings[0].Index, ShouldEqual, 0)
+			So(findings[0].Other, ShouldEqual, 1)
+		})
+
+		Convey("When a narrower selector has the same priority, nothing should be reported", func() {
+			findings := analyze(policy.TagSelectorList{
+				selector(policy.Accept, 0, clause("app", policy.KeyExists)),
+				selector(policy.Accept, 0, clause("app", policy.Equal, "web")),
+			}, nil, nil)
+
+			So(findings, ShouldBeEmpty)
+		})
+
+		Convey("When a selector duplicates a previous one, it should be reported as unreachable", func() {
+			findings := analyze(policy.TagSelectorList{
+				selector(policy.Reject, 0, clause("app", policy.NotIn, "web", "db")),
+				selector(policy.Reject, 0, clause("app", policy.NotEqual, "db", "web")),
+			}, nil, nil)
+
+			So(findings, ShouldHaveLength, 1)
+			So(findings[0].Index, ShouldEqual, 1)
+			So(findings[0].Other, ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given selectors scoped to namespaces", t, func() {
+
+		scoped := func(s policy.TagSelector, namespace string) policy.TagSelector {
+			s.Namespace = namespace
+			return s
+		}
+
+		Convey("When an accept selector is in the subtree of a reject selector, it should be reported as shadowed", func() {
+			findings := analyze(policy.TagSelectorList{
+				scoped(selector(policy.Reject, 0, clause("app", policy.KeyExists)), "acme"),
+				scoped(selector(policy.Accept, 10, clause("app", policy.Equal, "web")), "acme/payments"),
+			}, nil, nil)
+
+			So(findings, ShouldHaveLength, 1)
+			So(findings[0].Kind, ShouldEqual, ShadowedSelector)
+			So(findings[0].Index, ShouldEqual, 1)
+		})
+
+		Convey("When the selectors are in other namespaces, nothing should be reported", func() {
+			findings := analyze(policy.TagSelectorList{
+				scoped(selector(policy.Reject, 0, clause("app", policy.KeyExists)), "acme/payments"),
+				scoped(selector(policy.Accept, 10, clause("app", policy.Equal, "web")), "acme"),
+				scoped(selector(policy.Accept, 10), "globex"),
+			}, nil, nil)
+
+			So(findings, ShouldBeEmpty)
+		})
+
+		Convey("When a selector has an invalid namespace, it should be reported as unreachable", func() {
+			findings := analyze(policy.TagSelectorList{
+				scoped(selector(policy.Accept, 0, clause("app", policy.KeyExists)), "acme/*"),
+			}, nil, nil)
+
+			So(findings, ShouldHaveLength, 1)
+			So(findings[0].Kind, ShouldEqual, UnreachableSelector)
+			So(findings[0].Message, ShouldEqual, "the selector has an invalid namespace acme/*")
+		})
+	})
+
+	Convey("Given selectors that can't match, they should be reported as unreachable", t, func() {
+		findings := analyze(policy.TagSelectorList{
+			selector(policy.Accept, 0, clause("app", policy.KeyExists), clause("app", policy.KeyNotExists)),
+			selector(policy.Accept, 0, clause("version", policy.LessThan, "abc")),
+			selector(policy.Accept, 0, clause("app", policy.Matches, "web(")),
+			selector(policy.Accept, 0),
+			selector(policy.Log, 0, clause("app", policy.Equal, "web")),
+		}, nil, nil)
+
+		So(findings, ShouldHaveLength, 5)
+		So(findings[0].Message, ShouldEqual, "clauses 0 and 1 contradict each other")
+		So(findings[1].Message, ShouldEqual, "clause 0 has an invalid value abc")
+		So(findings[2].Message, ShouldEqual, "clause 0 has an invalid value web(")
+		So(findings[3].Message, ShouldEqual, "the selector has no clauses")
+		So(findings[4].Message, ShouldEqual, "the selector neither accepts nor rejects")
+		for _, f := range findings {
+			So(f.Kind, ShouldEqual, UnreachableSelector)
+			So(f.Other, ShouldEqual, -1)
+		}
+	})
+}
+
+func TestClauseImplies(t *testing.T) {
+
+	Convey("Given pairs of clauses, the implications should be correct", t, func() {
+
+		cases := []struct {
+			a, b   policy.KeyValueOperator
+			result bool
+		}{
+			{clause("k", policy.Equal, "a"), clause("k", policy.In, "a", "b"), true},
+			{clause("k", policy.In, "a", "c"), clause("k", policy.In, "a", "b"), false},
+			{clause("k", policy.Equal, "prod-*"), clause("k", policy.HasPrefix, "prod"), true},
+			{clause("k", policy.HasPrefix, "prod"), clause("k", policy.Equal, "prod-*"), false},
+			{clause("k", policy.HasPrefix, "prod"), clause("k", policy.NotIn, "dev"), true},
+			{clause("k", policy.HasPrefix, "prod"), clause("k", policy.NotIn, "production"), false},
+			{clause("k", policy.Equal, "web-1"), clause("k", policy.Matches, "web-[0-9]+"), true},
+			{clause("k", policy.NotIn, "a", "b"), clause("k", policy.NotEqual, "a"), true},
+			{clause("k", policy.NotIn, "a"), clause("k", policy.NotIn, "a", "b"), false},
+			{clause("k", policy.GreaterThan, "5"), clause("k", policy.GreaterOrEqual, "5"), true},
+			{clause("k", policy.GreaterOrEqual, "5"), clause("k", policy.GreaterThan, "5"), false},
+			{clause("k", policy.LessOrEqual, "v1.2"), clause("k", policy.LessThan, "1.3"), true},
+			{clause("k", policy.LessThan, "2"), clause("k", policy.GreaterThan, "1"), false},
+			{clause("k", policy.Equal, "3"), clause("k", policy.GreaterThan, "2"), true},
+			{clause("k", policy.Matches, "a.*"), clause("k", policy.KeyExists), true},
+			{clause("k", policy.KeyNotExists), clause("k", policy.NotEqual, "a"), false},
+		}
+
+		for _, c := range cases {
+			So(clauseImplies(c.a, c.b), ShouldEqual, c.result)
+		}
+	})
+}
+
+func TestAnalyzeTags(t *testing.T) {
+
+	Convey("Given selectors that reference tags no processing unit carries, they should be reported", t, func() {
+		identities := []*policy.TagStore{
+			policy.NewTagStoreFromMap(map[string]string{"app": "web"}),
+			policy.NewTagStoreFromMap(map[string]string{"app": "db", "env": "prod"}),
+		}
+
+		findings := analyze(policy.TagSelectorList{
+			selector(policy.Accept, 0, clause("app", policy.In, "web", "cache", "api"), clause("$sys:port", policy.Equal, "80")),
+			selector(policy.Accept, 0, clause("team", policy.KeyExists), clause("owner", policy.KeyNotExists)),
+			selector(policy.Accept, 0, clause("env", policy.Equal, "pr*"), clause("app", policy.NotEqual, "cache")),
+		}, nil, identities)
+
+		So(findings, ShouldHaveLength, 2)
+		So(findings[0].String(), ShouldEqual, "receiverRules[0]: unknown-tag: clause 0 references values of the key app that no processing unit carries: api, cache")
+		So(findings[1].String(), ShouldEqual, "receiverRules[1]: unknown-tag: clause 0 references the key team that no processing unit carries")
+	})
+
+	Convey("Given no identities, the tags should not be checked", t, func() {
+		findings := analyze(policy.TagSelectorList{
+			selector(policy.Accept, 0, clause("team", policy.KeyExists)),
+		}, nil, nil)
+
+		So(findings, ShouldBeEmpty)
+	})
+}
+
+func TestAnalyzeACLs(t *testing.T) {
+
+	Convey("Given overlapping ACLs", t, func() {
+
+		Convey("When their actions conflict, they should be reported", func() {
+			findings := analyze(nil, policy.IPRuleList{
+				acl("10.0.0.0/8", "tcp", "80:443", policy.Accept),
+				acl("10.1.0.0/16", "TCP", "400:500", policy.Reject),
+				acl("10.1.1.1", "tcp", "8080", policy.Reject),
+			}, nil)
+
+			So(findings, ShouldHaveLength, 1)
+			So(findings[0].String(), ShouldEqual, "networkACLs[1]: conflicting: the ACL overlaps networkACLs[0] with a conflicting action on 10.1.0.0/16 tcp ports 400-443")
+		})
+
+		Convey("When their actions are the same or their protocols differ, nothing should be reported", func() {
+			findings := analyze(nil, policy.IPRuleList{
+				acl("10.0.0.0/8", "tcp", "80", policy.Accept),
+				acl("10.0.0.0/8", "udp", "80", policy.Reject),
+				acl("10.0.0.1/32", "tcp", "80", policy.Accept|policy.Log),
+				acl("192.168.0.0/16", "tcp", "80", policy.Reject),
+			}, nil)
+
+			So(findings, ShouldBeEmpty)
+		})
+
+		Convey("When the protocol has no ports, they should be reported", func() {
+			findings := analyze(nil, policy.IPRuleList{
+				acl("2001:db8::/32", "58", "", policy.Accept),
+				acl("2001:db8::1", "58", "", policy.Reject),
+			}, nil)
+
+			So(findings, ShouldHaveLength, 1)
+			So(findings[0].Message, ShouldContainSubstring, "2001:db8::1/128 58 ports 0-65535")
+		})
+	})
+}
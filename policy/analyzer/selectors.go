@@ -0,0 +1,483 @@
+package analyzer
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aporeto-inc/trireme/enforcer/lookup"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// accepts returns true if the enforcer adds the selector to its accept rules
+func accepts(s policy.TagSelector) bool {
+
+	return s.Policy != nil && s.Policy.Action&policy.Accept != 0
+}
+
+// rejects returns true if the enforcer adds the selector to its reject rules.
+// The reject rules are evaluated before the accept rules.
+func rejects(s policy.TagSelector) bool {
+
+	return s.Policy != nil && s.Policy.Action&policy.Accept == 0 && s.Policy.Action&policy.Reject != 0
+}
+
+// analyzeSelectors finds the selectors that never apply. A selector is
+// reported once, with the first reason found.
+func analyzeSelectors(set RuleSet, selectors policy.TagSelectorList) []*Finding {
+
+	findings := []*Finding{}
+
+	valid := make([]bool, len(selectors))
+	for i, s := range selectors {
+		reason := neverMatches(s)
+		if reason == "" {
+			valid[i] = true
+			continue
+		}
+
+		findings = append(findings, &Finding{
+			Kind:    UnreachableSelector,
+			Rules:   set,
+			Index:   i,
+			Other:   -1,
+			Message: reason,
+		})
+	}
+
+	for j := range selectors {
+		if !valid[j] {
+			continue
+		}
+
+		if finding := shadowed(set, selectors, valid, j); finding != nil {
+			findings = append(findings, finding)
+			continue
+		}
+
+		if finding := preceded(set, selectors, valid, j); finding != nil {
+			findings = append(findings, finding)
+		}
+	}
+
+	return findings
+}
+
+// permanent returns true if the selector has no schedule. A scheduled
+// selector doesn't hide the other selectors outside of its schedule.
+func permanent(s policy.TagSelector) bool {
+
+	return s.Policy == nil || s.Policy.Schedule == nil
+}
+
+// shadowed reports an accept selector if a reject selector matches all the
+// tags it matches
+func shadowed(set RuleSet, selectors policy.TagSelectorList, valid []bool, j int) *Finding {
+
+	if !accepts(selectors[j]) {
+		return nil
+	}
+
+	for i, o := range selectors {
+		if valid[i] && rejects(o) && permanent(o) && implies(selectors[j], o) {
+			return &Finding{
+				Kind:    ShadowedSelector,
+				Rules:   set,
+				Index:   j,
+				Other:   i,
+				Message: fmt.Sprintf("all the tags it matches are rejected by %s[%d]", set, i),
+			}
+		}
+	}
+
+	return nil
+}
+
+// preceded reports a selector if another selector with the same action
+// matches all the tags it matches and is always selected first. Among
+// selectors with the same priority, the selected one depends on the order of
+// the tags, so only the duplicates of a previous selector are reported.
+func preceded(set RuleSet, selectors policy.TagSelectorList, valid []bool, j int) *Finding {
+
+	s := selectors[j]
+
+	for i, o := range selectors {
+		if i == j || !valid[i] || !permanent(o) || accepts(o) != accepts(s) || !implies(s, o) {
+			continue
+		}
+
+		if o.Priority > s.Priority || (o.Priority == s.Priority && i < j && implies(o, s)) {
+			return &Finding{
+				Kind:    UnreachableSelector,
+				Rules:   set,
+				Index:   j,
+				Other:   i,
+				Message: fmt.Sprintf("all the tags it matches are matched first by %s[%d]", set, i),
+			}
+		}
+	}
+
+	return nil
+}
+
+// neverMatches returns the reason why a selector never applies, or an empty
+// string if it can match
+func neverMatches(s policy.TagSelector) string {
+
+	if !accepts(s) && !rejects(s) {
+		return "the selector neither accepts nor rejects"
+	}
+
+	namespace, err := policy.NormalizeNamespace(s.Namespace)
+	if err != nil {
+		return fmt.Sprintf("the selector has an invalid namespace %s", s.Namespace)
+	}
+
+	if len(s.Clause) == 0 && len(s.Protocols) == 0 && len(s.Ports) == 0 && namespace == "" {
+		return "the selector has no clauses"
+	}
+
+	for i, r := range s.Ports {
+		if r.Min > r.Max {
+			return fmt.Sprintf("port range %d is empty", i)
+		}
+	}
+
+	for i, c := range s.Clause {
+		if c.Operator == policy.KeyNotExists {
+			continue
+		}
+
+		for k, o := range s.Clause {
+			if o.Key == c.Key && o.Operator == policy.KeyNotExists {
+				return fmt.Sprintf("clauses %d and %d contradict each other", i, k)
+			}
+		}
+
+		if c.Operator == policy.KeyExists {
+			continue
+		}
+
+		if len(c.Value) == 0 {
+			return fmt.Sprintf("clause %d has no values", i)
+		}
+
+		for _, v := range c.Value {
+			if !validValue(c.Operator, v) {
+				return fmt.Sprintf("clause %d has an invalid value %s", i, v)
+			}
+		}
+	}
+
+	return ""
+}
+
+// validValue returns false if a value makes the clause invalid for the enforcer
+func validValue(operator policy.Operator, value string) bool {
+
+	switch operator {
+	case policy.Matches:
+		_, err := regexp.Compile(value)
+		return err == nil
+	case policy.GreaterThan, policy.GreaterOrEqual, policy.LessThan, policy.LessOrEqual:
+		_, ok := lookup.CompareValues(value, value)
+		return ok
+	}
+
+	return true
+}
+
+// implies returns true if all the tags matched by the selector a are also
+// matched by the selector b. Each clause of b must be implied by a clause of a,
+// the protocols and ports of a must be ones of b, and the namespace of a must
+// be in the namespace subtree of b.
+func implies(a, b policy.TagSelector) bool {
+
+	if !protocolsImply(a.Protocols, b.Protocols) || !portsImply(a.Ports, b.Ports) || !namespaceImplies(a.Namespace, b.Namespace) {
+		return false
+	}
+
+	for _, cb := range b.Clause {
+		implied := false
+
+		for _, ca := range a.Clause {
+			if ca.Key == cb.Key && clauseImplies(ca, cb) {
+				implied = true
+				break
+			}
+		}
+
+		if !implied {
+			return false
+		}
+	}
+
+	return true
+}
+
+// namespaceImplies returns true if the namespace a is in the subtree of the
+// namespace b. No namespace means any namespace.
+func namespaceImplies(a, b string) bool {
+
+	na, erra := policy.NormalizeNamespace(a)
+	nb, errb := policy.NormalizeNamespace(b)
+	if erra != nil || errb != nil {
+		return false
+	}
+
+	return policy.NamespaceContains(nb, na)
+}
+
+// protocolsImply returns true if the protocols a are all in the protocols b.
+// No protocols means any protocol.
+func protocolsImply(a, b []string) bool {
+
+	if len(b) == 0 {
+		return true
+	}
+
+	if len(a) == 0 {
+		return false
+	}
+
+	for _, pa := range a {
+		found := false
+		for _, pb := range b {
+			if strings.EqualFold(pa, pb) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// portsImply returns true if the port ranges a are covered by the port
+// ranges b. No ranges means any port.
+func portsImply(a, b []policy.PortRange) bool {
+
+	if len(b) == 0 {
+		return true
+	}
+
+	if len(a) == 0 {
+		a = []policy.PortRange{{Min: 0, Max: 65535}}
+	}
+
+	for _, ra := range a {
+		if !covered(ra, b) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// covered returns true if all the ports of the range r are in the ranges
+func covered(r policy.PortRange, ranges []policy.PortRange) bool {
+
+	next := int(r.Min)
+
+	for next <= int(r.Max) {
+		extended := false
+		for _, o := range ranges {
+			if int(o.Min) <= next && int(o.Max) >= next {
+				next = int(o.Max) + 1
+				extended = true
+			}
+		}
+		if !extended {
+			return false
+		}
+	}
+
+	return true
+}
+
+// clauseImplies returns true if all the tags matched by the clause a are also
+// matched by the clause b. The clauses must have the same key. A clause other
+// than KeyNotExists matches if one of the values of the key satisfies it, so a
+// implies b if all the values satisfying a also satisfy b.
+func clauseImplies(a, b policy.KeyValueOperator) bool {
+
+	if a.Operator == b.Operator && sameValues(a.Value, b.Value) {
+		return true
+	}
+
+	if a.Operator == policy.KeyNotExists || b.Operator == policy.KeyNotExists {
+		return false
+	}
+
+	if b.Operator == policy.KeyExists {
+		return true
+	}
+
+	switch a.Operator {
+
+	case policy.Equal, policy.In, policy.HasPrefix:
+		exact, prefixes := valueSet(a)
+		for _, v := range exact {
+			if !lookup.ValueMatches(b, v) {
+				return false
+			}
+		}
+		for _, p := range prefixes {
+			if !prefixImplies(p, b) {
+				return false
+			}
+		}
+		return true
+
+	case policy.NotEqual, policy.NotIn:
+		if b.Operator != policy.NotEqual && b.Operator != policy.NotIn {
+			return false
+		}
+		for _, v := range b.Value {
+			if !contains(a.Value, v) {
+				return false
+			}
+		}
+		return true
+
+	case policy.GreaterThan, policy.GreaterOrEqual, policy.LessThan, policy.LessOrEqual:
+		return boundImplies(a, b)
+	}
+
+	return false
+}
+
+// valueSet returns the values and the prefixes matched by a clause of the
+// Equal, In or HasPrefix operators. A value of Equal ending with * is a prefix.
+func valueSet(c policy.KeyValueOperator) (exact []string, prefixes []string) {
+
+	for _, v := range c.Value {
+		switch {
+		case c.Operator == policy.HasPrefix:
+			prefixes = append(prefixes, v)
+		case c.Operator == policy.Equal && strings.HasSuffix(v, "*"):
+			prefixes = append(prefixes, v[:len(v)-1])
+		default:
+			exact = append(exact, v)
+		}
+	}
+
+	return exact, prefixes
+}
+
+// prefixImplies returns true if all the values starting with the prefix
+// satisfy the clause
+func prefixImplies(prefix string, c policy.KeyValueOperator) bool {
+
+	switch c.Operator {
+
+	case policy.Equal, policy.HasPrefix:
+		_, prefixes := valueSet(c)
+		for _, p := range prefixes {
+			if strings.HasPrefix(prefix, p) {
+				return true
+			}
+		}
+
+	case policy.NotEqual, policy.NotIn:
+		for _, v := range c.Value {
+			if strings.HasPrefix(v, prefix) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// bound is the bound of the values matched by a clause of a numeric operator
+type bound struct {
+	value   string
+	strict  bool
+	greater bool
+}
+
+// newBound returns the bound of a numeric clause. The values of the clause are
+// alternatives, so the bound is the lowest threshold of the greater operators
+// and the highest one of the lower operators.
+func newBound(c policy.KeyValueOperator) bound {
+
+	b := bound{
+		strict:  c.Operator == policy.GreaterThan || c.Operator == policy.LessThan,
+		greater: c.Operator == policy.GreaterThan || c.Operator == policy.GreaterOrEqual,
+	}
+
+	for i, v := range c.Value {
+		result, _ := lookup.CompareValues(v, b.value)
+		if i == 0 || (b.greater && result < 0) || (!b.greater && result > 0) {
+			b.value = v
+		}
+	}
+
+	return b
+}
+
+// boundImplies returns true if the range of values of the numeric clause a is
+// included in the range of the numeric clause b
+func boundImplies(a, b policy.KeyValueOperator) bool {
+
+	switch b.Operator {
+	case policy.GreaterThan, policy.GreaterOrEqual, policy.LessThan, policy.LessOrEqual:
+	default:
+		return false
+	}
+
+	ba := newBound(a)
+	bb := newBound(b)
+
+	if ba.greater != bb.greater {
+		return false
+	}
+
+	result, ok := lookup.CompareValues(ba.value, bb.value)
+	if !ok {
+		return false
+	}
+
+	if !ba.greater {
+		result = -result
+	}
+
+	return result > 0 || (result == 0 && (ba.strict || !bb.strict))
+}
+
+func sameValues(a, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	sa := append([]string{}, a...)
+	sb := append([]string{}, b...)
+	sort.Strings(sa)
+	sort.Strings(sb)
+
+	for i := range sa {
+		if sa[i] != sb[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,118 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// tagIndex holds the values of the keys of the identities of the known
+// processing units
+type tagIndex map[string]map[string]struct{}
+
+func newTagIndex(identities []*policy.TagStore) tagIndex {
+
+	index := tagIndex{}
+
+	for _, identity := range identities {
+		if identity == nil {
+			continue
+		}
+
+		for _, tag := range identity.GetSlice() {
+			parts := strings.SplitN(tag, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+
+			if _, ok := index[parts[0]]; !ok {
+				index[parts[0]] = map[string]struct{}{}
+			}
+			index[parts[0]][parts[1]] = struct{}{}
+		}
+	}
+
+	return index
+}
+
+// analyze reports the clauses of the selectors that reference a key or a value
+// that no processing unit carries. Keys starting with $ are added by the
+// enforcer, like the port of a flow, and are not reported. KeyNotExists
+// clauses always match an unknown key and are not reported either.
+func (t tagIndex) analyze(set RuleSet, selectors policy.TagSelectorList) []*Finding {
+
+	findings := []*Finding{}
+
+	for i, s := range selectors {
+		for j, c := range s.Clause {
+			if strings.HasPrefix(c.Key, "$") || c.Operator == policy.KeyNotExists {
+				continue
+			}
+
+			message := t.unknown(c)
+			if message == "" {
+				continue
+			}
+
+			findings = append(findings, &Finding{
+				Kind:    UnknownTag,
+				Rules:   set,
+				Index:   i,
+				Other:   -1,
+				Message: fmt.Sprintf("clause %d references %s", j, message),
+			})
+		}
+	}
+
+	return findings
+}
+
+// unknown describes the key or values of a clause that no processing unit
+// carries, or returns an empty string
+func (t tagIndex) unknown(c policy.KeyValueOperator) string {
+
+	values, ok := t[c.Key]
+	if !ok {
+		return fmt.Sprintf("the key %s that no processing unit carries", c.Key)
+	}
+
+	if c.Operator != policy.Equal && c.Operator != policy.In && c.Operator != policy.HasPrefix {
+		return ""
+	}
+
+	unknown := []string{}
+
+	exact, prefixes := valueSet(c)
+	for _, v := range exact {
+		if _, ok := values[v]; !ok {
+			unknown = append(unknown, v)
+		}
+	}
+
+	for _, p := range prefixes {
+		if !hasPrefix(values, p) {
+			unknown = append(unknown, p+"*")
+		}
+	}
+
+	if len(unknown) == 0 {
+		return ""
+	}
+
+	sort.Strings(unknown)
+
+	return fmt.Sprintf("values of the key %s that no processing unit carries: %s", c.Key, strings.Join(unknown, ", "))
+}
+
+func hasPrefix(values map[string]struct{}, prefix string) bool {
+
+	for v := range values {
+		if strings.HasPrefix(v, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,97 @@
+package policy
+
+import "fmt"
+
+// FlowDirection is the direction of a flow relative to a processing unit
+type FlowDirection int
+
+const (
+	// IncomingFlow is a flow initiated by a remote endpoint towards the processing unit
+	IncomingFlow FlowDirection = iota
+	// OutgoingFlow is a flow initiated by the processing unit towards a remote endpoint
+	OutgoingFlow
+)
+
+func (d FlowDirection) String() string {
+	if d == OutgoingFlow {
+		return "outgoing"
+	}
+	return "incoming"
+}
+
+// DecisionSource identifies the rules of a processing unit that provided a decision
+type DecisionSource string
+
+const (
+	// RejectRcvRules are the reject receiver rules of the processing unit
+	RejectRcvRules DecisionSource = "RejectRcvRules"
+	// AcceptRcvRules are the accept receiver rules of the processing unit
+	AcceptRcvRules DecisionSource = "AcceptRcvRules"
+	// RejectTxtRules are the reject transmitter rules of the processing unit
+	RejectTxtRules DecisionSource = "RejectTxtRules"
+	// AcceptTxtRules are the accept transmitter rules of the processing unit
+	AcceptTxtRules DecisionSource = "AcceptTxtRules"
+	// ApplicationACLs are the ACLs of the flows to external networks
+	ApplicationACLs DecisionSource = "ApplicationACLs"
+	// NetworkACLs are the ACLs of the flows from external networks
+	NetworkACLs DecisionSource = "NetworkACLs"
+	// DefaultDecision indicates that no rule matched the flow
+	DefaultDecision DecisionSource = "default"
+)
+
+// FlowQuery describes a flow of a processing unit to evaluate against its policy.
+// The remote endpoint is either a processing unit identified by its tags or an
+// external address when RemoteTags is nil.
+type FlowQuery struct {
+	// Direction is the direction of the flow relative to the processing unit
+	Direction FlowDirection
+
+	// Protocol is the protocol of the flow, by name or by number. Defaults to tcp.
+	Protocol string
+
+	// RemoteTags are the tags of the remote processing unit
+	RemoteTags *TagStore
+
+	// RemoteIP is the address of the remote external endpoint
+	RemoteIP string
+
+	// Port is the destination port of the flow
+	Port uint16
+
+	// ICMPType and ICMPCode are the type and the code of the ICMP messages of the flow
+	ICMPType uint8
+	ICMPCode uint8
+}
+
+func (q *FlowQuery) String() string {
+
+	remote := q.RemoteIP
+	if q.RemoteTags != nil {
+		remote = fmt.Sprintf("%v", q.RemoteTags.GetSlice())
+	}
+
+	return fmt.Sprintf("<flowquery direction:%s protocol:%s remote:%s port:%d>",
+		q.Direction,
+		q.Protocol,
+		remote,
+		q.Port,
+	)
+}
+
+// FlowDecision is the decision of the policy of a processing unit for a flow. The
+// matching rule is either a TagSelector or an IPRule, depending on the source.
+type FlowDecision struct {
+	Action      ActionType
+	PolicyID    string
+	Source      DecisionSource
+	TagSelector *TagSelector
+	IPRule      *IPRule
+}
+
+func (d *FlowDecision) String() string {
+	return fmt.Sprintf("<flowdecision action:%s policyID:%s source:%s>",
+		d.Action.ActionString(),
+		d.PolicyID,
+		d.Source,
+	)
+}
@@ -0,0 +1,73 @@
+package policy
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// maxDomainNameLen is the maximum length of a DNS name without the final dot
+const maxDomainNameLen = 253
+
+// FQDN returns the DNS name of the address of the rule in lower case, or an
+// empty string if the address is an IP address, a network or an invalid name.
+// The enforcers and the supervisors resolve the names of the application ACLs
+// and follow the changes of their addresses.
+func (r IPRule) FQDN() string {
+
+	if net.ParseIP(r.Address) != nil || strings.Contains(r.Address, "/") {
+		return ""
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(r.Address, "."))
+	if !IsDomainName(name) {
+		return ""
+	}
+
+	return name
+}
+
+// FQDNs returns the sorted DNS names of the rules of the list
+func (l IPRuleList) FQDNs() []string {
+
+	names := []string{}
+	seen := map[string]bool{}
+
+	for _, rule := range l {
+		if name := rule.FQDN(); name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// IsDomainName returns true if the name is a valid host name like
+// api.example.com. The labels are made of letters, digits and hyphens, and
+// the last label can't be numeric so that a name is never an IPv4 address.
+func IsDomainName(name string) bool {
+
+	if name == "" || len(name) > maxDomainNameLen {
+		return false
+	}
+
+	labels := strings.Split(name, ".")
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+
+	last := labels[len(labels)-1]
+
+	return strings.TrimLeft(last, "0123456789") != ""
+}
@@ -0,0 +1,40 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIPRuleFQDN(t *testing.T) {
+
+	Convey("Given rules with addresses, networks and names", t, func() {
+
+		rules := IPRuleList{
+			{Address: "10.1.1.1"},
+			{Address: "10.0.0.0/8"},
+			{Address: "2001:db8::/32"},
+			{Address: "API.example.com."},
+			{Address: "api.example.com"},
+			{Address: "mirror"},
+			{Address: "10.1.1.300"},
+			{Address: "-bad.example.com"},
+			{Address: "under_score.example.com"},
+		}
+
+		Convey("Only the valid names should be returned in lower case", func() {
+			So(rules[0].FQDN(), ShouldBeEmpty)
+			So(rules[1].FQDN(), ShouldBeEmpty)
+			So(rules[2].FQDN(), ShouldBeEmpty)
+			So(rules[3].FQDN(), ShouldEqual, "api.example.com")
+			So(rules[5].FQDN(), ShouldEqual, "mirror")
+			So(rules[6].FQDN(), ShouldBeEmpty)
+			So(rules[7].FQDN(), ShouldBeEmpty)
+			So(rules[8].FQDN(), ShouldBeEmpty)
+		})
+
+		Convey("The list should return each name once", func() {
+			So(rules.FQDNs(), ShouldResemble, []string{"api.example.com", "mirror"})
+		})
+	})
+}
@@ -0,0 +1,85 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NormalizeNamespace returns the canonical form of a namespace like
+// tenant/team/app, without empty elements and leading or trailing slashes.
+// The elements can't contain the characters that have a meaning in the tag
+// selectors.
+func NormalizeNamespace(namespace string) (string, error) {
+
+	elements := []string{}
+
+	for _, element := range strings.Split(namespace, "/") {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+
+		if strings.ContainsAny(element, "*=") {
+			return "", fmt.Errorf("Invalid namespace %s", namespace)
+		}
+
+		elements = append(elements, element)
+	}
+
+	return strings.Join(elements, "/"), nil
+}
+
+// NamespaceContains returns true if the namespace is in the subtree of the
+// parent namespace. Every namespace is in the subtree of the empty namespace.
+func NamespaceContains(parent, namespace string) bool {
+
+	if parent == "" || parent == namespace {
+		return true
+	}
+
+	return strings.HasPrefix(namespace, parent+"/")
+}
+
+// NamespaceClause returns the clause that matches the tags of the processing
+// units in the subtree of a normalized namespace
+func NamespaceClause(namespace string) KeyValueOperator {
+
+	return KeyValueOperator{
+		Key:      NamespaceLabel,
+		Operator: Equal,
+		Value:    []string{namespace, namespace + "/*"},
+	}
+}
+
+// Namespace returns the namespace of the tags, or an empty string if the tags
+// have no namespace
+func (t *TagStore) Namespace() string {
+
+	namespace, _ := t.Get(NamespaceLabel)
+
+	return namespace
+}
+
+// SetNamespace replaces the namespace of the tags
+func (t *TagStore) SetNamespace(namespace string) error {
+
+	namespace, err := NormalizeNamespace(namespace)
+	if err != nil {
+		return err
+	}
+
+	tags := make([]string, 0, len(t.Tags)+1)
+	for _, kv := range t.Tags {
+		if !strings.HasPrefix(kv, NamespaceLabel+"=") {
+			tags = append(tags, kv)
+		}
+	}
+
+	if namespace != "" {
+		tags = append(tags, NamespaceLabel+"="+namespace)
+	}
+
+	t.Tags = tags
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNormalizeNamespace(t *testing.T) {
+
+	Convey("Given namespaces with extra slashes, they should be normalized", t, func() {
+		namespace, err := NormalizeNamespace("/acme//payments/ api/")
+		So(err, ShouldBeNil)
+		So(namespace, ShouldEqual, "acme/payments/api")
+
+		namespace, err = NormalizeNamespace("/")
+		So(err, ShouldBeNil)
+		So(namespace, ShouldBeEmpty)
+	})
+
+	Convey("Given namespaces with selector characters, they should be rejected", t, func() {
+		_, err := NormalizeNamespace("acme/*")
+		So(err, ShouldNotBeNil)
+
+		_, err = NormalizeNamespace("acme/team=a")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestNamespaceContains(t *testing.T) {
+
+	Convey("Given namespaces, only the namespaces of the subtree should be contained", t, func() {
+		So(NamespaceContains("acme", "acme"), ShouldBeTrue)
+		So(NamespaceContains("acme", "acme/payments"), ShouldBeTrue)
+		So(NamespaceContains("", "acme/payments"), ShouldBeTrue)
+		So(NamespaceContains("acme", "acmecorp"), ShouldBeFalse)
+		So(NamespaceContains("acme/payments", "acme"), ShouldBeFalse)
+		So(NamespaceContains("acme", ""), ShouldBeFalse)
+	})
+}
+
+func TestTagStoreNamespace(t *testing.T) {
+
+	Convey("Given a tag store", t, func() {
+		tags := NewTagStoreFromMap(map[string]string{"app": "web"})
+		So(tags.Namespace(), ShouldBeEmpty)
+
+		Convey("When I set the namespace, it should replace the previous one", func() {
+			So(tags.SetNamespace("acme/payments"), ShouldBeNil)
+			So(tags.SetNamespace("/acme/billing/"), ShouldBeNil)
+			So(tags.Namespace(), ShouldEqual, "acme/billing")
+			So(tags.GetSlice(), ShouldResemble, []string{"app=web", NamespaceLabel + "=acme/billing"})
+		})
+
+		Convey("When I set an invalid namespace, the tags should not change", func() {
+			So(tags.SetNamespace("acme/*"), ShouldNotBeNil)
+			So(tags.GetSlice(), ShouldResemble, []string{"app=web"})
+		})
+
+		Convey("When I set an empty namespace, the namespace should be removed", func() {
+			So(tags.SetNamespace("acme"), ShouldBeNil)
+			So(tags.SetNamespace(""), ShouldBeNil)
+			So(tags.Namespace(), ShouldBeEmpty)
+			So(tags.GetSlice(), ShouldResemble, []string{"app=web"})
+		})
+	})
+}
+
+func TestAddIdentityNamespace(t *testing.T) {
+
+	Convey("Given a policy without a namespace", t, func() {
+		p := NewPUPolicy("pu", Police, nil, nil, nil, nil, NewTagStoreFromMap(map[string]string{"app": "web"}), nil, nil, nil, nil)
+
+		Convey("When I add a namespace tag, it should be normalized", func() {
+			So(p.AddIdentityTag(NamespaceLabel, "/acme/payments/"), ShouldBeNil)
+			So(p.Namespace(), ShouldEqual, "acme/payments")
+
+			Convey("The same namespace should be accepted once", func() {
+				So(p.AddIdentityTag(NamespaceLabel, "acme/payments"), ShouldBeNil)
+				So(p.Identity().GetSlice(), ShouldResemble, []string{"app=web", NamespaceLabel + "=acme/payments"})
+			})
+
+			Convey("Another namespace should be rejected", func() {
+				So(p.AddIdentityTag(NamespaceLabel, "globex"), ShouldNotBeNil)
+				So(p.AddIdentityTag(NamespaceLabel, "acme/payments/api"), ShouldNotBeNil)
+				So(p.Namespace(), ShouldEqual, "acme/payments")
+			})
+		})
+
+		Convey("When I add an invalid namespace tag, it should be rejected", func() {
+			So(p.AddIdentityTag(NamespaceLabel, "acme/*"), ShouldNotBeNil)
+			So(p.Namespace(), ShouldBeEmpty)
+		})
+	})
+}
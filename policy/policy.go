@@ -1,6 +1,9 @@
 package policy
 
-import "sync"
+import (
+	"fmt"
+	"sync"
+)
 
 // PUPolicy captures all policy information related ot the container
 type PUPolicy struct {
@@ -30,6 +33,11 @@ type PUPolicy struct {
 	triremeNetworks []string
 	// excludedNetworks a list of networks that must be excluded
 	excludedNetworks []string
+	// rejectAction defines how the connections rejected by the policy are terminated
+	rejectAction RejectAction
+	// generation identifies the version of the policy of the PU. The generations
+	// of the policies of a PU always increase.
+	generation uint64
 
 	sync.Mutex
 }
@@ -42,8 +50,37 @@ const (
 	AllowAll = 0x1
 	// Police filters on the PU based on the PolicyRules.
 	Police = 0x2
+	// Audit evaluates the PolicyRules of the PU like Police, but never drops a
+	// flow because of a policy decision. The flows that would have been rejected
+	// are reported as observed. Flows that fail authentication are still dropped.
+	Audit = 0x4
+)
+
+// RejectAction defines how the datapath terminates the connections rejected
+// by the policy of a PU.
+type RejectAction int
+
+const (
+	// RejectDrop silently drops the packets of rejected connections.
+	RejectDrop RejectAction = iota
+	// RejectReset resets rejected connections with a TCP RST to the initiator.
+	RejectReset
+	// RejectResetICMP resets rejected connections from other PUs with a TCP RST
+	// and answers rejected flows from external networks with an ICMP
+	// administratively prohibited error.
+	RejectResetICMP
 )
 
+func (r RejectAction) String() string {
+	switch r {
+	case RejectReset:
+		return "reset"
+	case RejectResetICMP:
+		return "reset-icmp"
+	}
+	return "drop"
+}
+
 // NewPUPolicy generates a new ContainerPolicyInfo
 // appACLs are the ACLs for packet coming from the Application/PU to the Network.
 // netACLs are the ACLs for packet coming from the Network to the Application/PU.
@@ -123,6 +160,9 @@ func (p *PUPolicy) Clone() *PUPolicy {
 		p.excludedNetworks,
 	)
 
+	np.rejectAction = p.rejectAction
+	np.generation = p.generation
+
 	return np
 }
 
@@ -150,6 +190,38 @@ func (p *PUPolicy) SetTriremeAction(action PUAction) {
 	p.triremeAction = action
 }
 
+// RejectAction returns how the rejected connections are terminated
+func (p *PUPolicy) RejectAction() RejectAction {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.rejectAction
+}
+
+// SetRejectAction sets how the rejected connections are terminated
+func (p *PUPolicy) SetRejectAction(action RejectAction) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.rejectAction = action
+}
+
+// Generation returns the generation of the policy
+func (p *PUPolicy) Generation() uint64 {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.generation
+}
+
+// SetGeneration sets the generation of the policy
+func (p *PUPolicy) SetGeneration(generation uint64) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.generation = generation
+}
+
 // ApplicationACLs returns a copy of IPRuleList
 func (p *PUPolicy) ApplicationACLs() IPRuleList {
 	p.Lock()
@@ -214,12 +286,47 @@ func (p *PUPolicy) Annotations() *TagStore {
 	return p.annotations.Copy()
 }
 
-// AddIdentityTag adds a policy tag
-func (p *PUPolicy) AddIdentityTag(k, v string) {
+// AddIdentityTag adds a policy tag. The namespace tag is only accepted if the
+// identity has no namespace yet or already has the same one, so that a
+// processing unit never gets a second namespace.
+func (p *PUPolicy) AddIdentityTag(k, v string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if k != NamespaceLabel {
+		p.identity.AppendKeyValue(k, v)
+		return nil
+	}
+
+	namespace, err := NormalizeNamespace(v)
+	if err != nil {
+		return err
+	}
+
+	if current := p.identity.Namespace(); current != "" {
+		if current != namespace {
+			return fmt.Errorf("Namespace %s is foreign to the namespace %s of the processing unit", v, current)
+		}
+		return nil
+	}
+
+	return p.identity.SetNamespace(namespace)
+}
+
+// Namespace returns the namespace of the identity of the processing unit
+func (p *PUPolicy) Namespace() string {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.identity.Namespace()
+}
+
+// SetNamespace sets the namespace of the identity of the processing unit
+func (p *PUPolicy) SetNamespace(namespace string) error {
 	p.Lock()
 	defer p.Unlock()
 
-	p.identity.AppendKeyValue(k, v)
+	return p.identity.SetNamespace(namespace)
 }
 
 // IPAddresses returns all the IP addresses for the processing unit
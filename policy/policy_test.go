@@ -191,6 +191,8 @@ func TestFuncClone(t *testing.T) {
 			triremeNetworks,
 			excludedNetworks,
 		)
+		d.SetRejectAction(RejectReset)
+
 		Convey("If I clone the policy", func() {
 			p := d.Clone()
 
@@ -206,6 +208,7 @@ func TestFuncClone(t *testing.T) {
 				So(p.ips, ShouldResemble, ips)
 				So(p.triremeNetworks, ShouldResemble, triremeNetworks)
 				So(p.excludedNetworks, ShouldResemble, excludedNetworks)
+				So(p.rejectAction, ShouldEqual, RejectReset)
 			})
 		})
 	})
@@ -291,6 +294,13 @@ func TestAllLockedSetGet(t *testing.T) {
 			So(p.triremeAction, ShouldEqual, Police)
 		})
 
+		Convey("I should be able to set the reject action", func() {
+			So(p.RejectAction(), ShouldEqual, RejectDrop)
+			p.SetRejectAction(RejectResetICMP)
+			So(p.RejectAction(), ShouldEqual, RejectResetICMP)
+			So(p.RejectAction().String(), ShouldEqual, "reset-icmp")
+		})
+
 		Convey("I should be able to retrieve the APP acls ", func() {
 			So(p.ApplicationACLs(), ShouldResemble, IPRuleList{appACL})
 		})
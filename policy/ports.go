@@ -0,0 +1,55 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortRange is a range of destination ports. A single port has the same Min
+// and Max.
+type PortRange struct {
+	Min uint16
+	Max uint16
+}
+
+// ParsePortRange parses a port or a range of ports in the format of the ACLs,
+// like 80 or 8000:8999
+func ParsePortRange(s string) (PortRange, error) {
+
+	parts := strings.SplitN(s, ":", 2)
+
+	min, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("Invalid port %s", s)
+	}
+
+	max := min
+	if len(parts) == 2 {
+		if max, err = strconv.ParseUint(parts[1], 10, 16); err != nil {
+			return PortRange{}, fmt.Errorf("Invalid port %s", s)
+		}
+	}
+
+	if min > max {
+		return PortRange{}, fmt.Errorf("Invalid port range %s", s)
+	}
+
+	return PortRange{Min: uint16(min), Max: uint16(max)}, nil
+}
+
+// Contains returns true if the port is in the range
+func (r PortRange) Contains(port uint16) bool {
+
+	return port >= r.Min && port <= r.Max
+}
+
+// String returns the range in the format of the ACLs
+func (r PortRange) String() string {
+
+	if r.Min == r.Max {
+		return strconv.Itoa(int(r.Min))
+	}
+
+	return strconv.Itoa(int(r.Min)) + ":" + strconv.Itoa(int(r.Max))
+}
@@ -0,0 +1,32 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParsePortRange(t *testing.T) {
+
+	Convey("Given valid ports and ranges, they should be parsed", t, func() {
+		r, err := ParsePortRange("80")
+		So(err, ShouldBeNil)
+		So(r, ShouldResemble, PortRange{Min: 80, Max: 80})
+		So(r.String(), ShouldEqual, "80")
+
+		r, err = ParsePortRange("8000:8999")
+		So(err, ShouldBeNil)
+		So(r, ShouldResemble, PortRange{Min: 8000, Max: 8999})
+		So(r.String(), ShouldEqual, "8000:8999")
+		So(r.Contains(8000), ShouldBeTrue)
+		So(r.Contains(8999), ShouldBeTrue)
+		So(r.Contains(9000), ShouldBeFalse)
+	})
+
+	Convey("Given invalid ports and ranges, I should get errors", t, func() {
+		for _, s := range []string{"", "http", "70000", "80:", "9000:8000", "-1"} {
+			_, err := ParsePortRange(s)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
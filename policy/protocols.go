@@ -0,0 +1,134 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IANA numbers of the protocols that the ACLs refer to by name
+const (
+	ProtocolICMP    = 1
+	ProtocolIGMP    = 2
+	ProtocolTCP     = 6
+	ProtocolUDP     = 17
+	ProtocolGRE     = 47
+	ProtocolESP     = 50
+	ProtocolAH      = 51
+	ProtocolICMPv6  = 58
+	ProtocolSCTP    = 132
+	ProtocolUDPLite = 136
+)
+
+// protocolNumbers are the numbers of the protocols by name, with the names of
+// /etc/protocols and the aliases of iptables
+var protocolNumbers = map[string]uint8{
+	"icmp":      ProtocolICMP,
+	"igmp":      ProtocolIGMP,
+	"tcp":       ProtocolTCP,
+	"udp":       ProtocolUDP,
+	"gre":       ProtocolGRE,
+	"esp":       ProtocolESP,
+	"ah":        ProtocolAH,
+	"icmpv6":    ProtocolICMPv6,
+	"ipv6-icmp": ProtocolICMPv6,
+	"sctp":      ProtocolSCTP,
+	"udplite":   ProtocolUDPLite,
+}
+
+// ProtocolNumber returns the IANA number of a protocol given by its name, like
+// gre, or by its number, like 47
+func ProtocolNumber(protocol string) (uint8, error) {
+
+	if number, ok := protocolNumbers[strings.ToLower(protocol)]; ok {
+		return number, nil
+	}
+
+	number, err := strconv.ParseUint(protocol, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid protocol %s", protocol)
+	}
+
+	return uint8(number), nil
+}
+
+// SameProtocol returns true if the protocols have the same number, or the same
+// name when they are not known
+func SameProtocol(a, b string) bool {
+
+	na, erra := ProtocolNumber(a)
+	nb, errb := ProtocolNumber(b)
+	if erra != nil || errb != nil {
+		return strings.EqualFold(a, b)
+	}
+
+	return na == nb
+}
+
+// ProtocolHasPorts returns true if the flows of the protocol have destination
+// ports, which is the case of TCP, UDP, SCTP and UDP-Lite
+func ProtocolHasPorts(protocol string) bool {
+
+	number, err := ProtocolNumber(protocol)
+	if err != nil {
+		return false
+	}
+
+	switch number {
+	case ProtocolTCP, ProtocolUDP, ProtocolSCTP, ProtocolUDPLite:
+		return true
+	}
+
+	return false
+}
+
+// HasPorts returns true if the rule applies to the destination ports of its
+// protocol
+func (r IPRule) HasPorts() bool {
+
+	return ProtocolHasPorts(r.Protocol)
+}
+
+// IsICMP returns true if the rule applies to ICMP or ICMPv6 messages
+func (r IPRule) IsICMP() bool {
+
+	number, err := ProtocolNumber(r.Protocol)
+
+	return err == nil && (number == ProtocolICMP || number == ProtocolICMPv6)
+}
+
+// ICMPTypeCode returns the ICMP type and code of the rule. The type is -1 if
+// the rule applies to all the ICMP messages, and the code is -1 if the rule
+// applies to all the codes of the type.
+func (r IPRule) ICMPTypeCode() (int, int, error) {
+
+	if !r.IsICMP() {
+		if r.ICMPType != "" || r.ICMPCode != "" {
+			return -1, -1, fmt.Errorf("ICMP type and code of protocol %s", r.Protocol)
+		}
+		return -1, -1, nil
+	}
+
+	if r.ICMPType == "" {
+		if r.ICMPCode != "" {
+			return -1, -1, fmt.Errorf("ICMP code %s without a type", r.ICMPCode)
+		}
+		return -1, -1, nil
+	}
+
+	icmpType, err := strconv.ParseUint(r.ICMPType, 10, 8)
+	if err != nil {
+		return -1, -1, fmt.Errorf("Invalid ICMP type %s", r.ICMPType)
+	}
+
+	if r.ICMPCode == "" {
+		return int(icmpType), -1, nil
+	}
+
+	icmpCode, err := strconv.ParseUint(r.ICMPCode, 10, 8)
+	if err != nil {
+		return -1, -1, fmt.Errorf("Invalid ICMP code %s", r.ICMPCode)
+	}
+
+	return int(icmpType), int(icmpCode), nil
+}
@@ -0,0 +1,70 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProtocolNumber(t *testing.T) {
+
+	Convey("Given protocols by name and by number, they should have their IANA number", t, func() {
+		for protocol, expected := range map[string]uint8{"tcp": 6, "UDP": 17, "gre": 47, "47": 47, "ipv6-icmp": 58, "icmpv6": 58, "sctp": 132} {
+			number, err := ProtocolNumber(protocol)
+			So(err, ShouldBeNil)
+			So(number, ShouldEqual, expected)
+		}
+	})
+
+	Convey("Given invalid protocols, I should get errors", t, func() {
+		for _, protocol := range []string{"", "tcp6", "256", "-1"} {
+			_, err := ProtocolNumber(protocol)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Given protocols with and without ports, they should be told apart", t, func() {
+		So(SameProtocol("6", "TCP"), ShouldBeTrue)
+		So(SameProtocol("tcp", "udp"), ShouldBeFalse)
+		So(SameProtocol("custom", "Custom"), ShouldBeTrue)
+		So(ProtocolHasPorts("sctp"), ShouldBeTrue)
+		So(ProtocolHasPorts("17"), ShouldBeTrue)
+		So(ProtocolHasPorts("gre"), ShouldBeFalse)
+		So(ProtocolHasPorts("icmp"), ShouldBeFalse)
+	})
+}
+
+func TestIPRuleICMPTypeCode(t *testing.T) {
+
+	Convey("Given ICMP rules with and without a type and a code", t, func() {
+
+		Convey("The type and the code should be -1 when they are not set", func() {
+			icmpType, icmpCode, err := IPRule{Protocol: "icmp"}.ICMPTypeCode()
+			So(err, ShouldBeNil)
+			So(icmpType, ShouldEqual, -1)
+			So(icmpCode, ShouldEqual, -1)
+
+			icmpType, icmpCode, err = IPRule{Protocol: "icmpv6", ICMPType: "128"}.ICMPTypeCode()
+			So(err, ShouldBeNil)
+			So(icmpType, ShouldEqual, 128)
+			So(icmpCode, ShouldEqual, -1)
+
+			icmpType, icmpCode, err = IPRule{Protocol: "1", ICMPType: "3", ICMPCode: "4"}.ICMPTypeCode()
+			So(err, ShouldBeNil)
+			So(icmpType, ShouldEqual, 3)
+			So(icmpCode, ShouldEqual, 4)
+		})
+
+		Convey("The invalid types and codes should be rejected", func() {
+			for _, rule := range []IPRule{
+				{Protocol: "icmp", ICMPCode: "0"},
+				{Protocol: "icmp", ICMPType: "echo"},
+				{Protocol: "icmp", ICMPType: "8", ICMPCode: "256"},
+				{Protocol: "tcp", ICMPType: "8"},
+			} {
+				_, _, err := rule.ICMPTypeCode()
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
@@ -0,0 +1,112 @@
+package policy
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window is a recurring time window, like a maintenance window. The times are
+// in UTC.
+type Window struct {
+	// Weekdays are the days when the window opens. The window opens every day
+	// if there are none.
+	Weekdays []time.Weekday
+	// Start and End are the offsets from midnight when the window opens and
+	// closes. The window closes the next day if End is not after Start.
+	Start time.Duration
+	End   time.Duration
+}
+
+// Schedule restricts when a flow policy applies, like a temporary access grant
+// or a maintenance window. The policy applies between NotBefore and NotAfter
+// when they are set, and only during its windows if it has any.
+type Schedule struct {
+	NotBefore time.Time
+	NotAfter  time.Time
+	Windows   []Window
+}
+
+// Active returns true if the policy applies at the given time. A nil
+// schedule is always active.
+func (s *Schedule) Active(t time.Time) bool {
+
+	if s == nil {
+		return true
+	}
+
+	if !s.NotBefore.IsZero() && t.Before(s.NotBefore) {
+		return false
+	}
+
+	if !s.NotAfter.IsZero() && !t.Before(s.NotAfter) {
+		return false
+	}
+
+	if len(s.Windows) == 0 {
+		return true
+	}
+
+	for _, w := range s.Windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate validates the schedule
+func (s *Schedule) Validate() error {
+
+	if !s.NotBefore.IsZero() && !s.NotAfter.IsZero() && !s.NotAfter.After(s.NotBefore) {
+		return fmt.Errorf("Schedule ends before it starts")
+	}
+
+	for i, w := range s.Windows {
+		if w.Start < 0 || w.Start >= 24*time.Hour || w.End < 0 || w.End >= 24*time.Hour {
+			return fmt.Errorf("Window %d must start and end within a day", i)
+		}
+
+		for _, d := range w.Weekdays {
+			if d < time.Sunday || d > time.Saturday {
+				return fmt.Errorf("Window %d has an invalid weekday %d", i, d)
+			}
+		}
+	}
+
+	return nil
+}
+
+// contains returns true if the window is open at the given time
+func (w Window) contains(t time.Time) bool {
+
+	t = t.UTC()
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End && w.opensOn(t.Weekday())
+	}
+
+	// The window closes the next day
+	if offset >= w.Start {
+		return w.opensOn(t.Weekday())
+	}
+
+	return offset < w.End && w.opensOn((t.Weekday()+6)%7)
+}
+
+// opensOn returns true if the window opens on the given day
+func (w Window) opensOn(day time.Weekday) bool {
+
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+
+	for _, d := range w.Weekdays {
+		if d == day {
+			return true
+		}
+	}
+
+	return false
+}
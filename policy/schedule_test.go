@@ -0,0 +1,58 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScheduleActive(t *testing.T) {
+
+	// 2017-11-04 is a Saturday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2017, time.November, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	Convey("Given a nil schedule, it should always be active", t, func() {
+		var s *Schedule
+		So(s.Active(at(4, 12, 0)), ShouldBeTrue)
+	})
+
+	Convey("Given a schedule with validity dates", t, func() {
+		s := &Schedule{NotBefore: at(4, 8, 0), NotAfter: at(4, 18, 0)}
+
+		Convey("It should only be active between them", func() {
+			So(s.Active(at(4, 7, 59)), ShouldBeFalse)
+			So(s.Active(at(4, 8, 0)), ShouldBeTrue)
+			So(s.Active(at(4, 17, 59)), ShouldBeTrue)
+			So(s.Active(at(4, 18, 0)), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a schedule with a window closing the next day", t, func() {
+		s := &Schedule{
+			Windows: []Window{{Weekdays: []time.Weekday{time.Saturday}, Start: 22 * time.Hour, End: 2 * time.Hour}},
+		}
+
+		Convey("It should be active from the opening day until the next morning", func() {
+			So(s.Active(at(4, 21, 59)), ShouldBeFalse)
+			So(s.Active(at(4, 23, 0)), ShouldBeTrue)
+			So(s.Active(at(5, 1, 59)), ShouldBeTrue)
+			So(s.Active(at(5, 2, 0)), ShouldBeFalse)
+			So(s.Active(at(5, 23, 0)), ShouldBeFalse)
+			So(s.Active(at(4, 1, 0)), ShouldBeFalse)
+		})
+
+		Convey("It should use the UTC time", func() {
+			So(s.Active(at(4, 23, 0).In(time.FixedZone("UTC-5", -5*3600))), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given invalid schedules, I should get errors", t, func() {
+		So((&Schedule{NotBefore: at(4, 8, 0), NotAfter: at(4, 8, 0)}).Validate(), ShouldNotBeNil)
+		So((&Schedule{Windows: []Window{{Start: 25 * time.Hour}}}).Validate(), ShouldNotBeNil)
+		So((&Schedule{Windows: []Window{{Weekdays: []time.Weekday{7}}}}).Validate(), ShouldNotBeNil)
+		So((&Schedule{Windows: []Window{{Start: time.Hour, End: 2 * time.Hour}}}).Validate(), ShouldBeNil)
+	})
+}
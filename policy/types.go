@@ -5,6 +5,21 @@ import "strconv"
 const (
 	// DefaultNamespace is the default namespace for applying policy
 	DefaultNamespace = "bridge"
+
+	// DefaultIPv6Namespace is the namespace of the IPv6 address of a dual-stack PU
+	DefaultIPv6Namespace = "bridge6"
+
+	// PortLabel is the key of the label of the destination port that the
+	// enforcers add to the tags of a flow
+	PortLabel = "$sys:port"
+
+	// ProtocolLabel is the key of the label of the L4 protocol that the
+	// enforcers add to the tags of a flow, like tcp or udp
+	ProtocolLabel = "$sys:protocol"
+
+	// NamespaceLabel is the key of the identity tag of the namespace of a
+	// processing unit. Namespaces are hierarchical, like tenant/team/app.
+	NamespaceLabel = "$namespace"
 )
 
 // Operator defines the operation between your key and value.
@@ -19,6 +34,23 @@ const (
 	KeyExists = "*"
 	// KeyNotExists means that the key doesnt exist in the incoming tags
 	KeyNotExists = "!*"
+	// HasPrefix matches the values that start with one of the prefixes
+	HasPrefix = "^="
+	// Matches matches the values that fully match one of the regular expressions
+	Matches = "=~"
+	// GreaterThan matches the numeric or version values greater than the value
+	GreaterThan = ">"
+	// GreaterOrEqual matches the numeric or version values greater or equal to the value
+	GreaterOrEqual = ">="
+	// LessThan matches the numeric or version values less than the value
+	LessThan = "<"
+	// LessOrEqual matches the numeric or version values less or equal to the value
+	LessOrEqual = "<="
+	// In matches the values that are in the set of values
+	In = "in"
+	// NotIn matches the values that are not in the set of values. Like NotEqual
+	// the key must exist in the incoming tags.
+	NotIn = "notin"
 )
 
 // ActionType   is the action that can be applied to a flow.
@@ -85,6 +117,10 @@ func (f ActionType) String() string {
 	return "unknown"
 }
 
+// ObservedShortAction is the short action string of the flows rejected by the
+// policy of a PU in Audit mode, which are reported but not dropped.
+const ObservedShortAction = "o"
+
 const (
 	// Accept is the accept action
 	Accept ActionType = 0x1
@@ -96,18 +132,26 @@ const (
 	Log ActionType = 0x8
 )
 
-// FlowPolicy captures the policy for a particular flow
+// FlowPolicy captures the policy for a particular flow. A policy with a
+// Schedule only applies when its schedule is active.
 type FlowPolicy struct {
 	Action    ActionType
 	ServiceID string
 	PolicyID  string
+	Schedule  *Schedule
 }
 
-// IPRule holds IP rules to external services
+// IPRule holds IP rules to external services. The Protocol is a name like tcp,
+// icmp or gre, or an IANA protocol number. The Port only applies to the
+// protocols with ports, and the ICMPType and ICMPCode to the ICMP and ICMPv6
+// rules. A rule without an ICMP type applies to all the ICMP messages, and a
+// rule without an ICMP code to all the codes of its type.
 type IPRule struct {
 	Address  string
 	Port     string
 	Protocol string
+	ICMPType string
+	ICMPCode string
 	Policy   *FlowPolicy
 }
 
@@ -130,10 +174,23 @@ type KeyValueOperator struct {
 	Operator Operator
 }
 
-// TagSelector info describes a tag selector key Operator value
+// TagSelector info describes a tag selector key Operator value. When several
+// selectors match, the one with the highest Priority is used, and the one added
+// first among selectors of the same priority. The enforcers compare the best
+// reject and accept rules of a flow the same way: a reject rule wins unless
+// the accept rule has a strictly higher Priority. Protocols and Ports
+// restrict the selector to the flows with one of these L4 protocols and
+// destination ports. A selector without protocols or ports matches them all.
+// A selector with a Namespace only matches the processing units of that
+// namespace and of the namespaces below it, so that the selectors of a tenant
+// never match the processing units of another tenant.
 type TagSelector struct {
-	Clause []KeyValueOperator
-	Policy *FlowPolicy
+	Clause    []KeyValueOperator
+	Policy    *FlowPolicy
+	Priority  int
+	Protocols []string
+	Ports     []PortRange
+	Namespace string
 }
 
 // TagSelectorList defines a list of TagSelectors
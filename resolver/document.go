@@ -0,0 +1,540 @@
+package resolver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/aporeto-inc/trireme/enforcer/lookup"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// Document is a policy document. The first policy whose selector matches the
+// runtime tags of a PU is applied to the PU.
+type Document struct {
+	Policies []*PolicySpec `json:"policies" yaml:"policies"`
+}
+
+// PolicySpec is the policy of the PUs matching the selector
+type PolicySpec struct {
+	// Name identifies the policy in the errors and logs
+	Name string `json:"name" yaml:"name"`
+	// Selector is the set of runtime tags a PU must have. An empty selector
+	// matches every PU.
+	Selector map[string]string `json:"selector" yaml:"selector"`
+	// Namespace is the namespace of the PUs, like tenant/team/app. The rules
+	// without a namespace are scoped to it.
+	Namespace string `json:"namespace" yaml:"namespace"`
+	// Action is one of police (default), allow or audit
+	Action string `json:"action" yaml:"action"`
+	// RejectAction is one of drop (default), reset or reset-icmp
+	RejectAction string `json:"rejectAction" yaml:"rejectAction"`
+	// RuntimeIdentity is the list of runtime tags of the PU added to its identity
+	RuntimeIdentity []string `json:"runtimeIdentity" yaml:"runtimeIdentity"`
+	// Identity is a set of static identity tags
+	Identity map[string]string `json:"identity" yaml:"identity"`
+	// Annotations is a set of static annotations
+	Annotations map[string]string `json:"annotations" yaml:"annotations"`
+	// TransmitterRules are the rules applied to the connections initiated by the PU
+	TransmitterRules []*RuleSpec `json:"transmitterRules" yaml:"transmitterRules"`
+	// ReceiverRules are the rules applied to the connections received by the PU
+	ReceiverRules []*RuleSpec `json:"receiverRules" yaml:"receiverRules"`
+	// ApplicationACLs are the ACLs of the traffic to external networks
+	ApplicationACLs []*ACLSpec `json:"applicationACLs" yaml:"applicationACLs"`
+	// NetworkACLs are the ACLs of the traffic from external networks
+	NetworkACLs []*ACLSpec `json:"networkACLs" yaml:"networkACLs"`
+	// TriremeNetworks are the networks where the traffic is authorized
+	TriremeNetworks []string `json:"triremeNetworks" yaml:"triremeNetworks"`
+	// ExcludedNetworks are the networks excluded from the policy
+	ExcludedNetworks []string `json:"excludedNetworks" yaml:"excludedNetworks"`
+}
+
+// RuleSpec is a tag selector rule. The protocols and the ports, like 80 or
+// 8000:8999, restrict the rule to the flows with one of them. The namespace
+// scopes the rule to a namespace subtree, and the root namespace / to all
+// the namespaces.
+type RuleSpec struct {
+	Clauses   []*ClauseSpec `json:"clauses" yaml:"clauses"`
+	Protocols []string      `json:"protocols" yaml:"protocols"`
+	Ports     []string      `json:"ports" yaml:"ports"`
+	Namespace string        `json:"namespace" yaml:"namespace"`
+	Actions   []string      `json:"actions" yaml:"actions"`
+	PolicyID  string        `json:"policyID" yaml:"policyID"`
+	Priority  int           `json:"priority" yaml:"priority"`
+}
+
+// ClauseSpec is a clause of a rule. The operator is one of the operators of
+// the policy package, like = or notin.
+type ClauseSpec struct {
+	Key      string   `json:"key" yaml:"key"`
+	Operator string   `json:"operator" yaml:"operator"`
+	Values   []string `json:"values" yaml:"values"`
+}
+
+// ACLSpec is an IP rule
+type ACLSpec struct {
+	Address   string   `json:"address" yaml:"address"`
+	Port      string   `json:"port" yaml:"port"`
+	Protocol  string   `json:"protocol" yaml:"protocol"`
+	ICMPType  string   `json:"icmpType" yaml:"icmpType"`
+	ICMPCode  string   `json:"icmpCode" yaml:"icmpCode"`
+	Actions   []string `json:"actions" yaml:"actions"`
+	PolicyID  string   `json:"policyID" yaml:"policyID"`
+	ServiceID string   `json:"serviceID" yaml:"serviceID"`
+}
+
+// operators are the operators that can be used in the clauses
+var operators = map[string]bool{
+	policy.Equal:          true,
+	policy.NotEqual:       true,
+	policy.KeyExists:      true,
+	policy.KeyNotExists:   true,
+	policy.HasPrefix:      true,
+	policy.Matches:        true,
+	policy.GreaterThan:    true,
+	policy.GreaterOrEqual: true,
+	policy.LessThan:       true,
+	policy.LessOrEqual:    true,
+	policy.In:             true,
+	policy.NotIn:          true,
+}
+
+// ParseDocument parses and validates a policy document. JSON documents are
+// parsed when the file name has a .json extension, and YAML documents
+// otherwise. The errors include the location of the problem in the document.
+func ParseDocument(name string, data []byte) (*Document, error) {
+
+	doc := &Document{}
+
+	if strings.ToLower(filepath.Ext(name)) == ".json" {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(doc); err != nil {
+			return nil, jsonError(name, data, err)
+		}
+		if _, err := decoder.Token(); err != io.EOF {
+			return nil, fmt.Errorf("%s: unexpected data after the document", name)
+		}
+	} else {
+		if err := yaml.UnmarshalStrict(data, doc); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+	}
+
+	if err := doc.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	return doc, nil
+}
+
+// jsonError adds the line and column of a JSON error
+func jsonError(name string, data []byte, err error) error {
+
+	var offset int64
+
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return fmt.Errorf("%s: %s", name, err)
+	}
+
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndex(data[:offset], []byte("\n"))
+
+	return fmt.Errorf("%s:%d:%d: %s", name, line, column, err)
+}
+
+// Validate validates the document. The errors give the path of the invalid
+// field, like policies[1].receiverRules[0].clauses[2].operator.
+func (d *Document) Validate() error {
+
+	for i, p := range d.Policies {
+		if p == nil {
+			return fmt.Errorf("policies[%d]: empty policy", i)
+		}
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("policies[%d]%s", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *PolicySpec) validate() error {
+
+	if _, err := policy.NormalizeNamespace(p.Namespace); err != nil {
+		return fmt.Errorf(".namespace: %s", err)
+	}
+
+	for i, k := range p.RuntimeIdentity {
+		if k == policy.NamespaceLabel {
+			return fmt.Errorf(".runtimeIdentity[%d]: the namespace is set with the namespace of the policy", i)
+		}
+	}
+
+	if _, ok := p.Identity[policy.NamespaceLabel]; ok {
+		return fmt.Errorf(".identity: the namespace is set with the namespace of the policy")
+	}
+
+	if _, err := puAction(p.Action); err != nil {
+		return fmt.Errorf(".action: %s", err)
+	}
+
+	if _, err := rejectAction(p.RejectAction); err != nil {
+		return fmt.Errorf(".rejectAction: %s", err)
+	}
+
+	if err := validateRules("transmitterRules", p.TransmitterRules); err != nil {
+		return err
+	}
+
+	if err := validateRules("receiverRules", p.ReceiverRules); err != nil {
+		return err
+	}
+
+	if err := validateACLs("applicationACLs", p.ApplicationACLs, true); err != nil {
+		return err
+	}
+
+	if err := validateACLs("networkACLs", p.NetworkACLs, false); err != nil {
+		return err
+	}
+
+	if err := validateNetworks("triremeNetworks", p.TriremeNetworks); err != nil {
+		return err
+	}
+
+	return validateNetworks("excludedNetworks", p.ExcludedNetworks)
+}
+
+func validateRules(name string, rules []*RuleSpec) error {
+
+	for i, r := range rules {
+		if r == nil {
+			return fmt.Errorf(".%s[%d]: empty rule", name, i)
+		}
+		if err := r.validate(); err != nil {
+			return fmt.Errorf(".%s[%d]%s", name, i, err)
+		}
+	}
+
+	return nil
+}
+
+// validateACLs validates a list of ACLs. The addresses of the ACLs can be DNS
+// names when names is set.
+func validateACLs(name string, acls []*ACLSpec, names bool) error {
+
+	for i, a := range acls {
+		if a == nil {
+			return fmt.Errorf(".%s[%d]: empty ACL", name, i)
+		}
+		if err := a.validate(names); err != nil {
+			return fmt.Errorf(".%s[%d]%s", name, i, err)
+		}
+	}
+
+	return nil
+}
+
+// validateNetworks validates a list of networks. A network is a CIDR or an IP.
+func validateNetworks(name string, networks []string) error {
+
+	for i, n := range networks {
+		if _, _, err := net.ParseCIDR(n); err != nil && net.ParseIP(n) == nil {
+			return fmt.Errorf(".%s[%d]: invalid network %s", name, i, n)
+		}
+	}
+
+	return nil
+}
+
+func (r *RuleSpec) validate() error {
+
+	if len(r.Clauses) == 0 && len(r.Protocols) == 0 && len(r.Ports) == 0 {
+		return fmt.Errorf(".clauses: a rule must have at least one clause")
+	}
+
+	for i, c := range r.Clauses {
+		if c == nil {
+			return fmt.Errorf(".clauses[%d]: empty clause", i)
+		}
+
+		if c.Key == "" {
+			return fmt.Errorf(".clauses[%d].key: empty key", i)
+		}
+
+		if !operators[c.Operator] {
+			return fmt.Errorf(".clauses[%d].operator: unknown operator %q", i, c.Operator)
+		}
+
+		if len(c.Values) == 0 && c.Operator != policy.KeyExists && c.Operator != policy.KeyNotExists {
+			return fmt.Errorf(".clauses[%d].values: operator %q requires values", i, c.Operator)
+		}
+
+		if err := lookup.ValidateValues(policy.Operator(c.Operator), c.Values); err != nil {
+			return fmt.Errorf(".clauses[%d].values: %s", i, err)
+		}
+	}
+
+	for i, p := range r.Protocols {
+		if protocol := strings.ToLower(p); protocol != "tcp" && protocol != "udp" {
+			return fmt.Errorf(".protocols[%d]: invalid protocol %s", i, p)
+		}
+	}
+
+	for i, p := range r.Ports {
+		if _, err := policy.ParsePortRange(p); err != nil {
+			return fmt.Errorf(".ports[%d]: invalid port %s", i, p)
+		}
+	}
+
+	if _, err := policy.NormalizeNamespace(r.Namespace); err != nil {
+		return fmt.Errorf(".namespace: %s", err)
+	}
+
+	if _, err := flowAction(r.Actions); err != nil {
+		return fmt.Errorf(".actions: %s", err)
+	}
+
+	return nil
+}
+
+func (a *ACLSpec) validate(names bool) error {
+
+	isName := names && policy.IsDomainName(strings.TrimSuffix(a.Address, "."))
+
+	if _, _, err := net.ParseCIDR(a.Address); err != nil && !isName {
+		return fmt.Errorf(".address: invalid network %s", a.Address)
+	}
+
+	if _, err := policy.ProtocolNumber(a.Protocol); err != nil {
+		return fmt.Errorf(".protocol: invalid protocol %s", a.Protocol)
+	}
+
+	if policy.ProtocolHasPorts(a.Protocol) {
+		if err := validatePort(a.Port); err != nil {
+			return fmt.Errorf(".port: %s", err)
+		}
+	}
+
+	rule := policy.IPRule{Protocol: a.Protocol, ICMPType: a.ICMPType, ICMPCode: a.ICMPCode}
+	if _, _, err := rule.ICMPTypeCode(); err != nil {
+		return fmt.Errorf(".icmpType: %s", err)
+	}
+
+	if _, err := flowAction(a.Actions); err != nil {
+		return fmt.Errorf(".actions: %s", err)
+	}
+
+	return nil
+}
+
+// validatePort validates a port or a port range like 100:200
+func validatePort(port string) error {
+
+	for _, p := range strings.SplitN(port, ":", 2) {
+		if n, err := strconv.Atoi(p); err != nil || n < 0 || n > 65535 {
+			return fmt.Errorf("invalid port %s", port)
+		}
+	}
+
+	return nil
+}
+
+// puAction converts the action of a policy
+func puAction(action string) (policy.PUAction, error) {
+
+	switch strings.ToLower(action) {
+	case "", "police":
+		return policy.Police, nil
+	case "allow":
+		return policy.AllowAll, nil
+	case "audit":
+		return policy.Audit, nil
+	}
+
+	return 0, fmt.Errorf("unknown action %q", action)
+}
+
+// rejectAction converts the reject action of a policy
+func rejectAction(action string) (policy.RejectAction, error) {
+
+	for _, a := range []policy.RejectAction{policy.RejectDrop, policy.RejectReset, policy.RejectResetICMP} {
+		if strings.ToLower(action) == a.String() {
+			return a, nil
+		}
+	}
+
+	if action == "" {
+		return policy.RejectDrop, nil
+	}
+
+	return 0, fmt.Errorf("unknown reject action %q", action)
+}
+
+// flowAction converts the actions of a rule. A rule must either accept or
+// reject, and may also encrypt and log.
+func flowAction(actions []string) (policy.ActionType, error) {
+
+	var action policy.ActionType
+
+	for _, a := range actions {
+		switch strings.ToLower(a) {
+		case "accept":
+			action |= policy.Accept
+		case "reject":
+			action |= policy.Reject
+		case "encrypt":
+			action |= policy.Encrypt
+		case "log":
+			action |= policy.Log
+		default:
+			return 0, fmt.Errorf("unknown action %q", a)
+		}
+	}
+
+	if action.Accepted() == action.Rejected() {
+		return 0, fmt.Errorf("a rule must either accept or reject")
+	}
+
+	return action, nil
+}
+
+// matches returns true if the runtime tags match the selector of the policy
+func (p *PolicySpec) matches(runtime policy.RuntimeReader) bool {
+
+	for k, v := range p.Selector {
+		if value, ok := runtime.Tag(k); !ok || value != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// puPolicy creates the policy of a PU. The document must be valid.
+func (p *PolicySpec) puPolicy(contextID string, runtime policy.RuntimeReader) *policy.PUPolicy {
+
+	action, _ := puAction(p.Action)
+	reject, _ := rejectAction(p.RejectAction)
+
+	identity := policy.NewTagStore()
+	for _, k := range p.RuntimeIdentity {
+		if v, ok := runtime.Tag(k); ok {
+			identity.AppendKeyValue(k, v)
+		}
+	}
+	appendTags(identity, p.Identity)
+
+	annotations := policy.NewTagStore()
+	appendTags(annotations, p.Annotations)
+
+	triremeNetworks := append([]string{}, p.TriremeNetworks...)
+	excludedNetworks := append([]string{}, p.ExcludedNetworks...)
+
+	puPolicy := policy.NewPUPolicy(
+		contextID,
+		action,
+		ipRules(p.ApplicationACLs),
+		ipRules(p.NetworkACLs),
+		tagSelectors(p.TransmitterRules),
+		tagSelectors(p.ReceiverRules),
+		identity,
+		annotations,
+		runtime.IPAddresses(),
+		triremeNetworks,
+		excludedNetworks,
+	)
+	puPolicy.SetRejectAction(reject)
+	puPolicy.SetNamespace(p.Namespace) // nolint
+
+	return puPolicy
+}
+
+// appendTags appends the tags to the store sorted by key
+func appendTags(store *policy.TagStore, tags map[string]string) {
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		store.AppendKeyValue(k, tags[k])
+	}
+}
+
+func tagSelectors(rules []*RuleSpec) policy.TagSelectorList {
+
+	selectors := policy.TagSelectorList{}
+
+	for _, r := range rules {
+		action, _ := flowAction(r.Actions)
+
+		selector := policy.TagSelector{
+			Policy:    &policy.FlowPolicy{Action: action, PolicyID: r.PolicyID},
+			Priority:  r.Priority,
+			Protocols: append([]string{}, r.Protocols...),
+			Namespace: r.Namespace,
+		}
+
+		for _, p := range r.Ports {
+			ports, _ := policy.ParsePortRange(p)
+			selector.Ports = append(selector.Ports, ports)
+		}
+
+		for _, c := range r.Clauses {
+			selector.Clause = append(selector.Clause, policy.KeyValueOperator{
+				Key:      c.Key,
+				Value:    append([]string{}, c.Values...),
+				Operator: policy.Operator(c.Operator),
+			})
+		}
+
+		selectors = append(selectors, selector)
+	}
+
+	return selectors
+}
+
+func ipRules(acls []*ACLSpec) policy.IPRuleList {
+
+	rules := policy.IPRuleList{}
+
+	for _, a := range acls {
+		action, _ := flowAction(a.Actions)
+
+		rules = append(rules, policy.IPRule{
+			Address:  a.Address,
+			Port:     a.Port,
+			Protocol: a.Protocol,
+			ICMPType: a.ICMPType,
+			ICMPCode: a.ICMPCode,
+			Policy: &policy.FlowPolicy{
+				Action:    action,
+				PolicyID:  a.PolicyID,
+				ServiceID: a.ServiceID,
+			},
+		})
+	}
+
+	return rules
+}
@@ -0,0 +1,246 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const yamlDocument = `
+policies:
+- name: web
+  selector:
+    app: web
+  rejectAction: reset
+  runtimeIdentity: [app]
+  identity:
+    zone: dmz
+  annotations:
+    owner: ops
+  receiverRules:
+  - clauses:
+    - key: app
+      operator: in
+      values: [lb, proxy]
+    protocols: [tcp]
+    ports: ["80", "8000:8999"]
+    actions: [accept, encrypt]
+    policyID: lb-to-web
+    priority: 10
+  applicationACLs:
+  - address: 10.0.0.0/8
+    port: "5432"
+    protocol: tcp
+    actions: [accept]
+    policyID: db
+  excludedNetworks: [192.168.1.1]
+- name: default
+  action: audit
+`
+
+func TestParseDocument(t *testing.T) {
+
+	Convey("Given a valid YAML document", t, func() {
+
+		doc, err := ParseDocument("policy.yaml", []byte(yamlDocument))
+		So(err, ShouldBeNil)
+		So(doc.Policies, ShouldHaveLength, 2)
+
+		Convey("When I resolve the policy of a matching PU", func() {
+			runtime := policy.NewPURuntime("web", 1, "", policy.NewTagStoreFromMap(map[string]string{"app": "web"}), policy.ExtendedMap{"bridge": "172.17.0.2"}, 0, nil)
+			p := resolve("pu", runtime, doc.match(runtime))
+
+			Convey("Then the policy should be created from the first matching policy", func() {
+				So(p.TriremeAction(), ShouldEqual, policy.Police)
+				So(p.RejectAction(), ShouldEqual, policy.RejectReset)
+				So(p.Identity().GetSlice(), ShouldResemble, []string{"app=web", "zone=dmz"})
+				So(p.Annotations().GetSlice(), ShouldResemble, []string{"owner=ops"})
+				So(p.ExcludedNetworks(), ShouldResemble, []string{"192.168.1.1"})
+				So(p.IPAddresses(), ShouldResemble, policy.ExtendedMap{"bridge": "172.17.0.2"})
+
+				rules := p.ReceiverRules()
+				So(rules, ShouldHaveLength, 1)
+				So(rules[0].Priority, ShouldEqual, 10)
+				So(rules[0].Policy.Action, ShouldEqual, policy.Accept|policy.Encrypt)
+				So(rules[0].Clause[0].Operator, ShouldEqual, policy.Operator(policy.In))
+				So(rules[0].Clause[0].Value, ShouldResemble, []string{"lb", "proxy"})
+				So(rules[0].Protocols, ShouldResemble, []string{"tcp"})
+				So(rules[0].Ports, ShouldResemble, []policy.PortRange{{Min: 80, Max: 80}, {Min: 8000, Max: 8999}})
+
+				acls := p.ApplicationACLs()
+				So(acls, ShouldHaveLength, 1)
+				So(acls[0].Address, ShouldEqual, "10.0.0.0/8")
+				So(acls[0].Policy.PolicyID, ShouldEqual, "db")
+			})
+		})
+
+		Convey("When I resolve the policy of a PU with a namespace, the namespace should be set", func() {
+			doc, err := ParseDocument("policy.yaml", []byte("policies:\n- namespace: /acme/payments/\n  receiverRules:\n  - {namespace: /, ports: [\"80\"], actions: [accept]}\n"))
+			So(err, ShouldBeNil)
+
+			runtime := policy.NewPURuntimeWithDefaults()
+			p := resolve("pu", runtime, doc.match(runtime))
+			So(p.Namespace(), ShouldEqual, "acme/payments")
+			So(p.ReceiverRules()[0].Namespace, ShouldEqual, "/")
+		})
+
+		Convey("When I resolve the policy of another PU, it should match the policy with an empty selector", func() {
+			runtime := policy.NewPURuntimeWithDefaults()
+			p := resolve("pu", runtime, doc.match(runtime))
+			So(p.TriremeAction(), ShouldEqual, policy.Audit)
+			So(p.ReceiverRules(), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given a JSON document", t, func() {
+
+		Convey("When it is valid, it should be parsed", func() {
+			doc, err := ParseDocument("policy.json", []byte(`{"policies": [{"name": "all", "action": "allow"}]}`))
+			So(err, ShouldBeNil)
+			So(doc.Policies[0].Action, ShouldEqual, "allow")
+		})
+
+		Convey("When it has a syntax error, the error should give its line and column", func() {
+			_, err := ParseDocument("policy.json", []byte("{\n  \"policies\": [\n    {\"name\": \"all\",}\n  ]\n}"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "policy.json:3:21: ")
+		})
+
+		Convey("When it has a field of the wrong type, the error should give its line", func() {
+			_, err := ParseDocument("policy.json", []byte("{\n  \"policies\": [\n    {\"name\": 3}\n  ]\n}"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "policy.json:3:")
+		})
+
+		Convey("When a field is unknown, it should be rejected", func() {
+			_, err := ParseDocument("policy.json", []byte(`{"policies": [{"name": "web", "selectr": {"app": "web"}}]}`))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "selectr")
+		})
+
+		Convey("When it has data after the document, it should be rejected", func() {
+			_, err := ParseDocument("policy.json", []byte(`{"policies": []} {"policies": []}`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given invalid YAML documents", t, func() {
+
+		Convey("When a field is unknown, the error should give its line", func() {
+			_, err := ParseDocument("policy.yaml", []byte("policies:\n- name: web\n  selectr:\n    app: web\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "line 3")
+		})
+
+		Convey("When an operator is unknown, the error should give the path of the clause", func() {
+			_, err := ParseDocument("policy.yaml", []byte(`
+policies:
+- name: web
+  receiverRules:
+  - clauses:
+    - {key: app, operator: "=", values: [lb]}
+    actions: [accept]
+  - clauses:
+    - {key: app, operator: "=", values: [lb]}
+    - {key: env, operator: "~", values: [prod]}
+    actions: [accept]
+`))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `policy.yaml: policies[0].receiverRules[1].clauses[1].operator: unknown operator "~"`)
+		})
+
+		Convey("When the values of a clause can't be compared with its operator, the error should give the path of the clause", func() {
+			_, err := ParseDocument("policy.yaml", []byte(`
+policies:
+- name: web
+  receiverRules:
+  - clauses:
+    - {key: app, operator: "=~", values: ["web-("]}
+    actions: [accept]
+`))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "policy.yaml: policies[0].receiverRules[0].clauses[0].values: Invalid regular expression web-(")
+
+			_, err = ParseDocument("policy.yaml", []byte(`
+policies:
+- name: web
+  transmitterRules:
+  - clauses:
+    - {key: version, operator: ">=", values: ["2.x"]}
+    actions: [accept]
+`))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "policy.yaml: policies[0].transmitterRules[0].clauses[0].values: Invalid numeric value 2.x")
+		})
+
+		Convey("When a rule neither accepts nor rejects, it should be rejected", func() {
+			_, err := ParseDocument("policy.yaml", []byte("policies:\n- networkACLs:\n  - {address: 10.0.0.0/8, port: \"80\", protocol: tcp, actions: [log]}\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "policy.yaml: policies[0].networkACLs[0].actions: a rule must either accept or reject")
+		})
+
+		Convey("When an application ACL has a DNS name, it should be accepted", func() {
+			_, err := ParseDocument("policy.yaml", []byte("policies:\n- applicationACLs:\n  - {address: api.example.com, port: \"443\", protocol: tcp, actions: [accept]}\n"))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When a network ACL has a DNS name, it should be rejected", func() {
+			_, err := ParseDocument("policy.yaml", []byte("policies:\n- networkACLs:\n  - {address: api.example.com, port: \"443\", protocol: tcp, actions: [accept]}\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "policy.yaml: policies[0].networkACLs[0].address: invalid network api.example.com")
+		})
+
+		Convey("When an ACL has an invalid port, it should be rejected", func() {
+			_, err := ParseDocument("policy.yaml", []byte("policies:\n- networkACLs:\n  - {address: 10.0.0.0/8, port: \"80:x\", protocol: tcp, actions: [accept]}\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "policy.yaml: policies[0].networkACLs[0].port: invalid port 80:x")
+		})
+
+		Convey("When an ACL has an ICMP type and code, they should be parsed", func() {
+			doc, err := ParseDocument("policy.yaml", []byte("policies:\n- networkACLs:\n  - {address: 10.0.0.0/8, protocol: icmp, icmpType: \"3\", icmpCode: \"4\", actions: [accept]}\n  - {address: 10.0.0.0/8, protocol: gre, actions: [accept]}\n"))
+			So(err, ShouldBeNil)
+			So(doc.Policies[0].NetworkACLs[0].ICMPType, ShouldEqual, "3")
+			So(doc.Policies[0].NetworkACLs[0].ICMPCode, ShouldEqual, "4")
+		})
+
+		Convey("When an ACL has an invalid ICMP type, it should be rejected", func() {
+			_, err := ParseDocument("policy.yaml", []byte("policies:\n- networkACLs:\n  - {address: 10.0.0.0/8, protocol: icmp, icmpType: echo, actions: [accept]}\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "policy.yaml: policies[0].networkACLs[0].icmpType: Invalid ICMP type echo")
+		})
+
+		Convey("When a rule has an invalid port range, it should be rejected", func() {
+			_, err := ParseDocument("policy.yaml", []byte("policies:\n- receiverRules:\n  - {ports: [\"9000:8000\"], actions: [accept]}\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "policy.yaml: policies[0].receiverRules[0].ports[0]: invalid port 9000:8000")
+		})
+
+		Convey("When a network is invalid, it should be rejected", func() {
+			_, err := ParseDocument("policy.yaml", []byte("policies:\n- action: police\n  triremeNetworks: [10.0.0.0/8, 10.0.0/8]\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "policy.yaml: policies[0].triremeNetworks[1]: invalid network 10.0.0/8")
+		})
+
+		Convey("When the identity has a namespace tag, it should be rejected", func() {
+			_, err := ParseDocument("policy.yaml", []byte("policies:\n- identity: {$namespace: globex}\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "policy.yaml: policies[0].identity: the namespace is set with the namespace of the policy")
+
+			_, err = ParseDocument("policy.yaml", []byte("policies:\n- runtimeIdentity: [$namespace]\n"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When a namespace is invalid, it should be rejected", func() {
+			_, err := ParseDocument("policy.yaml", []byte("policies:\n- receiverRules:\n  - {namespace: \"acme/*\", ports: [\"80\"], actions: [accept]}\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "policy.yaml: policies[0].receiverRules[0].namespace: ")
+		})
+
+		Convey("When the action is unknown, it should be rejected", func() {
+			_, err := ParseDocument("policy.yaml", []byte("policies:\n- action: police\n- action: block\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `policy.yaml: policies[1].action: unknown action "block"`)
+		})
+	})
+}
@@ -0,0 +1,295 @@
+package resolver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme"
+	"github.com/aporeto-inc/trireme/monitor"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// DefaultPollInterval is the default interval between two checks of the
+// policy file
+const DefaultPollInterval = 2 * time.Second
+
+// runningPU is a PU whose policy was resolved and that was not stopped yet
+type runningPU struct {
+	runtime policy.RuntimeReader
+	spec    *PolicySpec
+}
+
+// FileResolver is a PolicyResolver that resolves the policies from a YAML or
+// JSON policy document. The file is watched for changes, and the policy of
+// the running PUs that are affected by a change is updated. An invalid
+// document is rejected and the last valid document is kept.
+type FileResolver struct {
+	path     string
+	interval time.Duration
+	document *Document
+	data     []byte
+	modTime  time.Time
+	running  map[string]*runningPU
+	failed   map[string]bool
+	updater  trireme.PolicyUpdater
+	stopCh   chan struct{}
+	sync.Mutex
+}
+
+// NewFileResolver creates a resolver for the policy document at the given
+// path. It fails if the document can't be read or is invalid.
+func NewFileResolver(path string, interval time.Duration) (*FileResolver, error) {
+
+	r := &FileResolver{
+		path:     path,
+		interval: interval,
+		running:  map[string]*runningPU{},
+		failed:   map[string]bool{},
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the policy file: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the policy file: %s", err)
+	}
+
+	document, err := ParseDocument(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid policy file: %s", err)
+	}
+
+	r.document = document
+	r.data = data
+	r.modTime = info.ModTime()
+
+	return r, nil
+}
+
+// ResolvePolicy implements the PolicyResolver interface. A PU that doesn't
+// match any policy of the document gets a policy that rejects all the traffic.
+func (r *FileResolver) ResolvePolicy(contextID string, runtime policy.RuntimeReader) (*policy.PUPolicy, error) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	spec := r.document.match(runtime)
+
+	r.running[contextID] = &runningPU{
+		runtime: runtime,
+		spec:    spec,
+	}
+	delete(r.failed, contextID)
+
+	return resolve(contextID, runtime, spec), nil
+}
+
+// HandlePUEvent implements the PolicyResolver interface. The PUs that are
+// stopped or destroyed are no longer updated, and their failed updates are no
+// longer retried.
+func (r *FileResolver) HandlePUEvent(contextID string, eventType monitor.Event) {
+
+	if eventType != monitor.EventStop && eventType != monitor.EventDestroy {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.running, contextID)
+	delete(r.failed, contextID)
+}
+
+// Start starts watching the policy file. The policy of the running PUs is
+// updated with the updater when the file changes.
+func (r *FileResolver) Start(updater trireme.PolicyUpdater) error {
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.stopCh != nil {
+		return fmt.Errorf("File resolver already started")
+	}
+
+	r.updater = updater
+	r.stopCh = make(chan struct{})
+
+	go r.run(r.stopCh)
+
+	return nil
+}
+
+func (r *FileResolver) run(stopCh chan struct{}) {
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				zap.L().Error("Keeping the last valid policy", zap.String("path", r.path), zap.Error(err))
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Stop stops watching the policy file
+func (r *FileResolver) Stop() {
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.stopCh != nil {
+		close(r.stopCh)
+		r.stopCh = nil
+	}
+}
+
+// Reload reads the policy file if it changed, and updates the policy of the
+// running PUs that are affected by the changes. An invalid document is
+// rejected and the last valid document is kept. The failed updates are
+// retried at each reload, even if the file didn't change.
+func (r *FileResolver) Reload() error {
+
+	changed, err := r.readDocument()
+
+	// The policies are updated without the lock, since the updates resolve
+	// the policies under the lock of the runtime of the PUs
+	r.Lock()
+	updates := map[string]*policyUpdate{}
+	for contextID, pu := range r.running {
+		if !changed && !r.failed[contextID] {
+			continue
+		}
+		spec := r.document.match(pu.runtime)
+		if reflect.DeepEqual(spec, pu.spec) {
+			delete(r.failed, contextID)
+			continue
+		}
+		updates[contextID] = &policyUpdate{
+			pu:       pu,
+			spec:     spec,
+			puPolicy: resolve(contextID, pu.runtime, spec),
+		}
+	}
+	updater := r.updater
+	r.Unlock()
+
+	if changed {
+		zap.L().Info("Policy file reloaded", zap.String("path", r.path), zap.Int("updates", len(updates)))
+	}
+
+	if updater == nil {
+		return err
+	}
+
+	for contextID, update := range updates {
+		updateErr := updater.UpdatePolicy(contextID, update.puPolicy)
+
+		// The spec is only recorded once the policy is enforced, and the
+		// failed updates are retried until they succeed or the PU stops
+		r.Lock()
+		if updateErr != nil && r.running[contextID] == update.pu {
+			r.failed[contextID] = true
+		} else if updateErr == nil {
+			update.pu.spec = update.spec
+			delete(r.failed, contextID)
+		}
+		r.Unlock()
+
+		if updateErr != nil {
+			zap.L().Error("Unable to update the policy", zap.String("contextID", contextID), zap.Error(updateErr))
+		}
+	}
+
+	return err
+}
+
+// readDocument reads the policy file if it changed. It returns true if the
+// document changed and is valid.
+func (r *FileResolver) readDocument() (bool, error) {
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false, fmt.Errorf("Unable to read the policy file: %s", err)
+	}
+
+	r.Lock()
+	unchanged := info.ModTime().Equal(r.modTime) && info.Size() == int64(len(r.data))
+	r.Unlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return false, fmt.Errorf("Unable to read the policy file: %s", err)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.modTime = info.ModTime()
+	if bytes.Equal(data, r.data) {
+		return false, nil
+	}
+	r.data = data
+
+	document, err := ParseDocument(r.path, data)
+	if err != nil {
+		return false, fmt.Errorf("Invalid policy file: %s", err)
+	}
+	r.document = document
+
+	return true, nil
+}
+
+// policyUpdate is the update of the policy of a running PU to a new spec
+type policyUpdate struct {
+	pu       *runningPU
+	spec     *PolicySpec
+	puPolicy *policy.PUPolicy
+}
+
+// match returns the first policy of the document matching the runtime, or nil
+func (d *Document) match(runtime policy.RuntimeReader) *PolicySpec {
+
+	for _, p := range d.Policies {
+		if p.matches(runtime) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Resolve creates the policy of a PU from the first policy of the document
+// matching its runtime
+func (d *Document) Resolve(contextID string, runtime policy.RuntimeReader) *policy.PUPolicy {
+
+	return resolve(contextID, runtime, d.match(runtime))
+}
+
+// resolve creates the policy of a PU from the spec it matches
+func resolve(contextID string, runtime policy.RuntimeReader, spec *PolicySpec) *policy.PUPolicy {
+
+	if spec == nil {
+		return (&PolicySpec{}).puPolicy(contextID, runtime)
+	}
+
+	return spec.puPolicy(contextID, runtime)
+}
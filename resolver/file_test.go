@@ -0,0 +1,194 @@
+package resolver
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/monitor"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testUpdater records the policy updates, or fails them with err. The first
+// updates also fail while failures is positive.
+type testUpdater struct {
+	updates  map[string]*policy.PUPolicy
+	err      error
+	failures int
+	sync.Mutex
+}
+
+func (u *testUpdater) UpdatePolicy(contextID string, newPolicy *policy.PUPolicy) error {
+
+	u.Lock()
+	defer u.Unlock()
+
+	if u.err != nil {
+		return u.err
+	}
+
+	if u.failures > 0 {
+		u.failures--
+		return errors.New("update failed once")
+	}
+
+	u.updates[contextID] = newPolicy
+
+	return nil
+}
+
+func (u *testUpdater) count() int {
+
+	u.Lock()
+	defer u.Unlock()
+
+	return len(u.updates)
+}
+
+const initialDocument = `
+policies:
+- selector: {app: web}
+  action: allow
+- selector: {app: db}
+  action: allow
+`
+
+// writePolicy writes the policy file with a new modification time
+func writePolicy(path string, document string, modTime time.Time) {
+
+	So(ioutil.WriteFile(path, []byte(document), 0600), ShouldBeNil)
+	So(os.Chtimes(path, modTime, modTime), ShouldBeNil)
+}
+
+func TestFileResolver(t *testing.T) {
+
+	Convey("Given a file resolver with two running PUs", t, func() {
+
+		dir, err := ioutil.TempDir("", "resolver")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		path := filepath.Join(dir, "policy.yaml")
+		now := time.Now()
+		writePolicy(path, initialDocument, now)
+
+		r, err := NewFileResolver(path, 10*time.Millisecond)
+		So(err, ShouldBeNil)
+
+		updater := &testUpdater{updates: map[string]*policy.PUPolicy{}}
+
+		web := policy.NewPURuntime("web", 1, "", policy.NewTagStoreFromMap(map[string]string{"app": "web"}), nil, 0, nil)
+		db := policy.NewPURuntime("db", 2, "", policy.NewTagStoreFromMap(map[string]string{"app": "db"}), nil, 0, nil)
+
+		p, err := r.ResolvePolicy("web", web)
+		So(err, ShouldBeNil)
+		So(p.TriremeAction(), ShouldEqual, policy.AllowAll)
+
+		_, err = r.ResolvePolicy("db", db)
+		So(err, ShouldBeNil)
+
+		Convey("When the policy of one PU changes, only this PU should be updated", func() {
+			writePolicy(path, "policies:\n- selector: {app: web}\n  action: audit\n- selector: {app: db}\n  action: allow\n", now.Add(time.Second))
+
+			So(r.Reload(), ShouldBeNil)
+			So(updater.updates, ShouldBeEmpty)
+
+			r.updater = updater
+			writePolicy(path, "policies:\n- selector: {app: web}\n  action: police\n- selector: {app: db}\n  action: allow\n", now.Add(2*time.Second))
+
+			So(r.Reload(), ShouldBeNil)
+			So(updater.updates, ShouldHaveLength, 1)
+			So(updater.updates["web"].TriremeAction(), ShouldEqual, policy.Police)
+		})
+
+		Convey("When the update of a PU fails, it should be retried at the next change", func() {
+			r.updater = updater
+			updater.err = errors.New("update failed")
+
+			writePolicy(path, "policies:\n- selector: {app: web}\n  action: police\n- selector: {app: db}\n  action: allow\n", now.Add(time.Second))
+			So(r.Reload(), ShouldBeNil)
+			So(updater.updates, ShouldBeEmpty)
+
+			updater.err = nil
+			writePolicy(path, "policies:\n- selector: {app: web}\n  action: police\n- selector: {app: db}\n  action: audit\n", now.Add(2*time.Second))
+			So(r.Reload(), ShouldBeNil)
+			So(updater.updates, ShouldHaveLength, 2)
+			So(updater.updates["web"].TriremeAction(), ShouldEqual, policy.Police)
+		})
+
+		Convey("When the update of a PU fails once, it should be retried even if the file doesn't change", func() {
+			r.updater = updater
+			updater.failures = 1
+
+			writePolicy(path, "policies:\n- selector: {app: web}\n  action: police\n- selector: {app: db}\n  action: allow\n", now.Add(time.Second))
+			So(r.Reload(), ShouldBeNil)
+			So(updater.updates, ShouldBeEmpty)
+
+			So(r.Reload(), ShouldBeNil)
+			So(updater.updates, ShouldHaveLength, 1)
+			So(updater.updates["web"].TriremeAction(), ShouldEqual, policy.Police)
+
+			delete(updater.updates, "web")
+			So(r.Reload(), ShouldBeNil)
+			So(updater.updates, ShouldBeEmpty)
+		})
+
+		Convey("When the PU is stopped, it should no longer be updated", func() {
+			r.HandlePUEvent("web", monitor.EventStop)
+			r.updater = updater
+
+			writePolicy(path, "policies:\n- action: police\n", now.Add(time.Second))
+			So(r.Reload(), ShouldBeNil)
+			So(updater.updates, ShouldHaveLength, 1)
+			So(updater.updates, ShouldContainKey, "db")
+		})
+
+		Convey("When the document becomes invalid, the last valid policy should be kept", func() {
+			r.updater = updater
+
+			writePolicy(path, "policies:\n- action: block\n", now.Add(time.Second))
+			err := r.Reload()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, `policies[0].action: unknown action "block"`)
+			So(updater.updates, ShouldBeEmpty)
+
+			p, err := r.ResolvePolicy("web", web)
+			So(err, ShouldBeNil)
+			So(p.TriremeAction(), ShouldEqual, policy.AllowAll)
+		})
+
+		Convey("When a PU doesn't match any policy, it should reject all the traffic", func() {
+			other := policy.NewPURuntime("other", 3, "", policy.NewTagStoreFromMap(map[string]string{"app": "other"}), nil, 0, nil)
+			p, err := r.ResolvePolicy("other", other)
+			So(err, ShouldBeNil)
+			So(p.TriremeAction(), ShouldEqual, policy.Police)
+			So(p.ReceiverRules(), ShouldBeEmpty)
+			So(p.NetworkACLs(), ShouldBeEmpty)
+		})
+
+		Convey("When the resolver is started and the file changes, the PUs should be updated", func() {
+			So(r.Start(updater), ShouldBeNil)
+			defer r.Stop()
+
+			So(r.Start(updater), ShouldNotBeNil)
+
+			writePolicy(path, "policies:\n- action: audit\n", now.Add(time.Second))
+
+			deadline := time.Now().Add(5 * time.Second)
+			for updater.count() < 2 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(updater.count(), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given an invalid policy file, the resolver should not be created", t, func() {
+		_, err := NewFileResolver(filepath.Join(os.TempDir(), "does-not-exist.yaml"), time.Second)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -1,6 +1,11 @@
 package supervisor
 
-import "github.com/aporeto-inc/trireme/policy"
+import (
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+)
 
 // A Supervisor is implementing the node control plane that captures the packets.
 type Supervisor interface {
@@ -19,6 +24,13 @@ type Supervisor interface {
 
 	// SetTargetNetworks sets the target networks of the supervisor
 	SetTargetNetworks([]string) error
+
+	// SetReconcileInterval sets the interval between two reconciliations of
+	// the rules. It must be called before the supervisor is started.
+	SetReconcileInterval(interval time.Duration)
+
+	// DriftStats returns the counts of the drifts of the rules that were repaired
+	DriftStats() *collector.DriftStats
 }
 
 // Implementor is the interface of the implementation based on iptables, ipsets, remote etc
@@ -42,3 +54,16 @@ type Implementor interface {
 	// Stop cleans up state
 	Stop() error
 }
+
+// A Reconciler is an Implementor that repairs the rules that drifted from the
+// rules it programmed, for instance when another agent flushes its chains.
+type Reconciler interface {
+
+	// ReconcileRules repairs the rules of a processing unit and returns the
+	// number of drifts that were repaired
+	ReconcileRules(contextID string) (int, error)
+
+	// ReconcileGlobalRules repairs the rules and the sets that are shared by
+	// the processing units and returns the number of drifts that were repaired
+	ReconcileGlobalRules() (int, error)
+}
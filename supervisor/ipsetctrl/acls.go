@@ -2,10 +2,14 @@ package ipsetctrl
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"go.uber.org/zap"
 
+	"github.com/aporeto-inc/trireme/constants"
 	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
 	"github.com/bvandewalle/go-ipset/ipset"
 )
 
@@ -16,9 +20,21 @@ const (
 	rejectPrefix   = "R-"
 )
 
-// createACLSets creates the sets for a given PU
+// createACLSets creates the sets for a given PU. The sets can't expire their
+// entries on a schedule, so the scheduled ACLs are rejected rather than
+// applied forever. The entries are addresses, so the ACLs on DNS names are
+// rejected too.
 func (i *Instance) createACLSets(version string, set string, rules policy.IPRuleList) error {
 
+	for _, rule := range rules {
+		if rule.Policy.Schedule != nil {
+			return fmt.Errorf("Scheduled ACL to %s is not supported by the ipset supervisor", rule.Address)
+		}
+		if name := rule.FQDN(); name != "" {
+			return fmt.Errorf("ACL to the DNS name %s is not supported by the ipset supervisor", name)
+		}
+	}
+
 	allowSet, err := i.ips.NewIpset(set+allowPrefix+version, "hash:net,port", &ipset.Params{})
 	if err != nil {
 		return fmt.Errorf("Couldn't create IPSet for Trireme: %s", err.Error())
@@ -30,32 +46,102 @@ func (i *Instance) createACLSets(version string, set string, rules policy.IPRule
 	}
 
 	for _, rule := range rules {
-		var err error
+		var set provider.Ipset
 		switch rule.Policy.Action {
 		case policy.Accept:
-			err = allowSet.Add(rule.Address+","+rule.Port, 0)
+			set = allowSet
 		case policy.Reject:
-			err = rejectSet.Add(rule.Address+","+rule.Port, 0)
+			set = rejectSet
 		default:
 			continue
 		}
+
+		entries, err := aclEntries(rule)
 		if err != nil {
-			return fmt.Errorf("Couldn't create IPSet for Trireme: %s", err.Error())
+			return fmt.Errorf("Couldn't create IPSet for Trireme: %s", err)
+		}
+
+		for _, entry := range entries {
+			if err := set.Add(entry, 0); err != nil {
+				return fmt.Errorf("Couldn't create IPSet for Trireme: %s", err.Error())
+			}
 		}
 	}
 
 	return nil
 }
 
-// AddAppSetRule adds an ACL rule to the Set
-func (i *Instance) addAppSetRules(version, setPrefix, ip string) error {
+// aclEntries returns the entries of the hash:net,port sets of a rule. The
+// entries of the protocols with ports have the ports of the rule, and the
+// entries of the ICMP rules have the type and the code of the messages. The
+// sets can't match all the types of ICMP, so these rules are rejected.
+func aclEntries(rule policy.IPRule) ([]string, error) {
+
+	number, err := policy.ProtocolNumber(rule.Protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	proto := strings.ToLower(rule.Protocol)
+
+	if rule.HasPorts() {
+		return []string{rule.Address + "," + proto + ":" + strings.Replace(rule.Port, ":", "-", 1)}, nil
+	}
+
+	if !rule.IsICMP() {
+		return []string{rule.Address + "," + proto + ":0"}, nil
+	}
+
+	icmpType, icmpCode, err := rule.ICMPTypeCode()
+	if err != nil {
+		return nil, err
+	}
+
+	if icmpType < 0 {
+		return nil, fmt.Errorf("ICMP rule to %s requires a type", rule.Address)
+	}
+
+	proto = "icmp"
+	if number == policy.ProtocolICMPv6 {
+		proto = "icmpv6"
+	}
+
+	if icmpCode >= 0 {
+		return []string{rule.Address + "," + proto + ":" + strconv.Itoa(icmpType) + "/" + strconv.Itoa(icmpCode)}, nil
+	}
+
+	entries := make([]string, 0, 256)
+	for code := 0; code < 256; code++ {
+		entries = append(entries, rule.Address+","+proto+":"+strconv.Itoa(icmpType)+"/"+strconv.Itoa(code))
+	}
+
+	return entries, nil
+}
+
+// rejectTarget returns the target and the log short action of the flows that
+// match a reject set. The flows rejected by a PU in audit mode are accepted and
+// logged as observed.
+func rejectTarget(audit bool) (string, string) {
+
+	if audit {
+		return "ACCEPT", policy.ObservedShortAction
+	}
+
+	return "DROP", policy.Reject.ShortActionString()
+}
+
+// AddAppSetRule adds an ACL rule to the Set. The flows rejected by a PU in
+// audit mode are logged and accepted.
+func (i *Instance) addAppSetRules(contextID, version, setPrefix, ip string, audit bool) error {
+
+	target, shortAction := rejectTarget(audit)
 
 	if err := i.ipt.Insert(
 		i.appAckPacketIPTableContext, i.appPacketIPTableSection, 3,
 		"-m", "state", "--state", "NEW",
 		"-m", "set", "--match-set", setPrefix+rejectPrefix+version, "dst",
 		"-s", ip,
-		"-j", "DROP",
+		"-j", target,
 	); err != nil {
 		zap.L().Debug("Error when adding app acl rule",
 			zap.String("appAckPacketIPTableContext", i.appAckPacketIPTableContext),
@@ -65,6 +151,19 @@ func (i *Instance) addAppSetRules(version, setPrefix, ip string) error {
 
 	}
 
+	if audit {
+		if err := i.ipt.Insert(
+			i.appAckPacketIPTableContext, i.appPacketIPTableSection, 3,
+			"-m", "state", "--state", "NEW",
+			"-m", "set", "--match-set", setPrefix+rejectPrefix+version, "dst",
+			"-s", ip,
+			"-j", "NFLOG", "--nflog-group", "10",
+			"--nflog-prefix", contextID+":default:default"+shortAction,
+		); err != nil {
+			return fmt.Errorf("Error when adding app acl log rule: %s", err)
+		}
+	}
+
 	if err := i.ipt.Insert(
 		i.appAckPacketIPTableContext, i.appPacketIPTableSection, 3,
 		"-m", "state", "--state", "NEW",
@@ -83,15 +182,18 @@ func (i *Instance) addAppSetRules(version, setPrefix, ip string) error {
 	return nil
 }
 
-// addNetSetRule
-func (i *Instance) addNetSetRules(version, setPrefix, ip string) error {
+// addNetSetRule adds the net ACL rules of the Set. The flows rejected by a PU
+// in audit mode are logged and accepted.
+func (i *Instance) addNetSetRules(contextID, version, setPrefix, ip string, audit bool) error {
+
+	target, shortAction := rejectTarget(audit)
 
 	if err := i.ipt.Insert(
 		i.netPacketIPTableContext, i.netPacketIPTableSection, 2,
 		"-m", "state", "--state", "NEW",
 		"-m", "set", "--match-set", setPrefix+rejectPrefix+version, "src",
 		"-d", ip,
-		"-j", "DROP",
+		"-j", target,
 	); err != nil {
 		zap.L().Debug("Error when adding app acl rule",
 			zap.String("netPacketIPTableContext", i.netPacketIPTableContext),
@@ -100,6 +202,19 @@ func (i *Instance) addNetSetRules(version, setPrefix, ip string) error {
 		return fmt.Errorf("Error when adding net acl rule: %s", err)
 	}
 
+	if audit {
+		if err := i.ipt.Insert(
+			i.netPacketIPTableContext, i.netPacketIPTableSection, 2,
+			"-m", "state", "--state", "NEW",
+			"-m", "set", "--match-set", setPrefix+rejectPrefix+version, "src",
+			"-d", ip,
+			"-j", "NFLOG", "--nflog-group", "11",
+			"--nflog-prefix", contextID+":default:default"+shortAction,
+		); err != nil {
+			return fmt.Errorf("Error when adding net acl log rule: %s", err)
+		}
+	}
+
 	if err := i.ipt.Insert(
 		i.netPacketIPTableContext, i.netPacketIPTableSection, 2,
 		"-m", "state", "--state", "NEW",
@@ -116,20 +231,43 @@ func (i *Instance) addNetSetRules(version, setPrefix, ip string) error {
 	return nil
 }
 
-// deleteAppSetRule
-func (i *Instance) deleteAppSetRules(version, setPrefix, ip string) error {
+// deleteAppSetRule deletes the app ACL rules of the Set. The rules of both
+// modes are deleted since the mode of the previous policy is not known.
+func (i *Instance) deleteAppSetRules(contextID, version, setPrefix, ip string) error {
 
-	if err := i.ipt.Delete(
-		i.appAckPacketIPTableContext, i.appPacketIPTableSection,
-		"-m", "state", "--state", "NEW",
-		"-m", "set", "--match-set", setPrefix+rejectPrefix+version, "dst",
-		"-s", ip,
-		"-j", "DROP",
-	); err != nil {
-		zap.L().Debug("Error when removing app acl rule",
-			zap.String("appAckPacketIPTableContext", i.appAckPacketIPTableContext),
-			zap.Error(err),
-		)
+	for _, audit := range []bool{false, true} {
+		target, shortAction := rejectTarget(audit)
+
+		if err := i.ipt.Delete(
+			i.appAckPacketIPTableContext, i.appPacketIPTableSection,
+			"-m", "state", "--state", "NEW",
+			"-m", "set", "--match-set", setPrefix+rejectPrefix+version, "dst",
+			"-s", ip,
+			"-j", target,
+		); err != nil {
+			zap.L().Debug("Error when removing app acl rule",
+				zap.String("appAckPacketIPTableContext", i.appAckPacketIPTableContext),
+				zap.Error(err),
+			)
+		}
+
+		if !audit {
+			continue
+		}
+
+		if err := i.ipt.Delete(
+			i.appAckPacketIPTableContext, i.appPacketIPTableSection,
+			"-m", "state", "--state", "NEW",
+			"-m", "set", "--match-set", setPrefix+rejectPrefix+version, "dst",
+			"-s", ip,
+			"-j", "NFLOG", "--nflog-group", "10",
+			"--nflog-prefix", contextID+":default:default"+shortAction,
+		); err != nil {
+			zap.L().Debug("Error when removing app acl log rule",
+				zap.String("appAckPacketIPTableContext", i.appAckPacketIPTableContext),
+				zap.Error(err),
+			)
+		}
 	}
 
 	if err := i.ipt.Delete(
@@ -149,21 +287,44 @@ func (i *Instance) deleteAppSetRules(version, setPrefix, ip string) error {
 	return nil
 }
 
-// deleteNetSetRule
-func (i *Instance) deleteNetSetRules(version, setPrefix, ip string) error {
+// deleteNetSetRule deletes the net ACL rules of the Set. The rules of both
+// modes are deleted since the mode of the previous policy is not known.
+func (i *Instance) deleteNetSetRules(contextID, version, setPrefix, ip string) error {
 
-	if err := i.ipt.Delete(
-		i.netPacketIPTableContext, i.netPacketIPTableSection,
-		"-m", "state", "--state", "NEW",
-		"-m", "set", "--match-set", setPrefix+rejectPrefix+version, "src",
-		"-d", ip,
-		"-j", "DROP",
-	); err != nil {
-		zap.L().Debug("Error when removing ingress net acl rule",
-			zap.String("netPacketIPTableContext", i.netPacketIPTableContext),
-			zap.String("chaim", i.appPacketIPTableSection),
-			zap.Error(err),
-		)
+	for _, audit := range []bool{false, true} {
+		target, shortAction := rejectTarget(audit)
+
+		if err := i.ipt.Delete(
+			i.netPacketIPTableContext, i.netPacketIPTableSection,
+			"-m", "state", "--state", "NEW",
+			"-m", "set", "--match-set", setPrefix+rejectPrefix+version, "src",
+			"-d", ip,
+			"-j", target,
+		); err != nil {
+			zap.L().Debug("Error when removing ingress net acl rule",
+				zap.String("netPacketIPTableContext", i.netPacketIPTableContext),
+				zap.String("chaim", i.appPacketIPTableSection),
+				zap.Error(err),
+			)
+		}
+
+		if !audit {
+			continue
+		}
+
+		if err := i.ipt.Delete(
+			i.netPacketIPTableContext, i.netPacketIPTableSection,
+			"-m", "state", "--state", "NEW",
+			"-m", "set", "--match-set", setPrefix+rejectPrefix+version, "src",
+			"-d", ip,
+			"-j", "NFLOG", "--nflog-group", "11",
+			"--nflog-prefix", contextID+":default:default"+shortAction,
+		); err != nil {
+			zap.L().Debug("Error when removing ingress net acl log rule",
+				zap.String("netPacketIPTableContext", i.netPacketIPTableContext),
+				zap.Error(err),
+			)
+		}
 	}
 
 	if err := i.ipt.Delete(
@@ -291,6 +452,15 @@ func (i *Instance) setupTrapRules(set string) error {
 			"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 			"-j", "ACCEPT",
 		},
+		// Application Matching Trireme SRC and DST. All the packets of the encrypted connections
+		{
+			i.appAckPacketIPTableContext, i.appPacketIPTableSection,
+			"-m", "set", "--match-set", containerSet, "src",
+			"-m", "set", "--match-set", set, "dst",
+			"-p", "tcp",
+			"-m", "connmark", "--mark", strconv.Itoa(int(constants.EncryptedConnMark)),
+			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
+		},
 		// Application Matching Trireme SRC and DST. everything but SYN, first 4 packets
 		{
 			i.appAckPacketIPTableContext, i.appPacketIPTableSection,
@@ -316,6 +486,15 @@ func (i *Instance) setupTrapRules(set string) error {
 			"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueSynStr(),
 		},
+		// Network Matching Trireme SRC and DST. All the packets of the encrypted connections
+		{
+			i.netPacketIPTableContext, i.netPacketIPTableSection,
+			"-m", "set", "--match-set", set, "src",
+			"-m", "set", "--match-set", containerSet, "dst",
+			"-p", "tcp",
+			"-m", "connmark", "--mark", strconv.Itoa(int(constants.EncryptedConnMark)),
+			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
+		},
 		// Network Matching Trireme SRC and DST. Everything ut SYN, first 4 packets
 		{
 			i.netPacketIPTableContext, i.netPacketIPTableSection,
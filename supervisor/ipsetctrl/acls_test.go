@@ -3,6 +3,7 @@ package ipsetctrl
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/bvandewalle/go-ipset/ipset"
 	. "github.com/smartystreets/goconvey/convey"
@@ -112,6 +113,144 @@ func TestCreateACLSets(t *testing.T) {
 				So(err, ShouldNotBeNil)
 			})
 		})
+
+		Convey("When I create the ACL sets for APP1 with ICMP and generic protocol rules", func() {
+			entries := map[string][]string{}
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				testset := provider.NewTestIpset()
+				testset.MockAdd(t, func(entry string, timeout int) error {
+					entries[name] = append(entries[name], entry)
+					return nil
+				})
+				return testset, nil
+			})
+
+			rules := policy.IPRuleList{
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Protocol: "icmp",
+					ICMPType: "8",
+					ICMPCode: "0",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Protocol: "icmp",
+					ICMPType: "3",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Protocol: "47",
+					Policy:   &policy.FlowPolicy{Action: policy.Reject},
+				},
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Port:     "3868:3869",
+					Protocol: "SCTP",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+			}
+
+			err := i.createACLSets("0", "APP1-", rules)
+
+			Convey("The entries should have the protocols, the ports and the ICMP types and codes", func() {
+				So(err, ShouldBeNil)
+				So(entries["APP1-A-0"], ShouldHaveLength, 258)
+				So(entries["APP1-A-0"][0], ShouldEqual, "10.0.0.0/8,icmp:8/0")
+				So(entries["APP1-A-0"][5], ShouldEqual, "10.0.0.0/8,icmp:3/4")
+				So(entries["APP1-A-0"][257], ShouldEqual, "10.0.0.0/8,sctp:3868-3869")
+				So(entries["APP1-R-0"], ShouldResemble, []string{"10.0.0.0/8,47:0"})
+			})
+		})
+
+		Convey("When I create the ACL sets for APP1 with an ICMP rule without a type", func() {
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				return provider.NewTestIpset(), nil
+			})
+
+			rules := policy.IPRuleList{
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Protocol: "icmp",
+					Policy:   &policy.FlowPolicy{Action: policy.Reject},
+				},
+			}
+
+			err := i.createACLSets("0", "APP1-", rules)
+			Convey("I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create the ACL sets for APP1 with a scheduled rule", func() {
+			created := []string{}
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				created = append(created, name)
+				return provider.NewTestIpset(), nil
+			})
+
+			rules := policy.IPRuleList{
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Port:     "22",
+					Protocol: "TCP",
+					Policy: &policy.FlowPolicy{
+						Action:   policy.Accept,
+						Schedule: &policy.Schedule{NotAfter: time.Now().Add(time.Hour)},
+					},
+				},
+			}
+
+			err := i.createACLSets("0", "APP1-", rules)
+			Convey("I should get an error and no set should be created", func() {
+				So(err, ShouldNotBeNil)
+				So(created, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I create the ACL sets for APP1 with a rule on a DNS name", func() {
+			created := []string{}
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				created = append(created, name)
+				return provider.NewTestIpset(), nil
+			})
+
+			rules := policy.IPRuleList{
+				policy.IPRule{
+					Address:  "api.example.com",
+					Port:     "443",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+			}
+
+			err := i.createACLSets("0", "APP1-", rules)
+			Convey("I should get an error naming the DNS name and no set should be created", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "api.example.com")
+				So(created, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I create the ACL sets for APP1 with an invalid protocol", func() {
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				return provider.NewTestIpset(), nil
+			})
+
+			rules := policy.IPRuleList{
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Protocol: "unknown",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+			}
+
+			err := i.createACLSets("0", "APP1-", rules)
+			Convey("I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
 	})
 }
 
@@ -138,18 +277,36 @@ func TestAddAppSetRuleS(t *testing.T) {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.addAppSetRules("0", "SET-", "172.17.0.2")
+			err := i.addAppSetRules("context", "0", "SET-", "172.17.0.2", false)
 			Convey("I should not get an error ", func() {
 				So(err, ShouldBeNil)
 			})
 		})
 
+		Convey("When I add the app set rules of a PU in audit mode", func() {
+			rules := [][]string{}
+			iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+				rules = append(rules, rulespec)
+				return nil
+			})
+
+			err := i.addAppSetRules("context", "0", "SET-", "172.17.0.2", true)
+			Convey("The rejected flows should be logged as observed and accepted", func() {
+				So(err, ShouldBeNil)
+				So(rules, ShouldHaveLength, 3)
+				So(matchSpec("SET-R-0", rules[0]) && matchSpec("ACCEPT", rules[0]), ShouldBeTrue)
+				So(matchSpec("SET-R-0", rules[1]) && matchSpec("NFLOG", rules[1]), ShouldBeTrue)
+				So(matchSpec("context:default:default"+policy.ObservedShortAction, rules[1]), ShouldBeTrue)
+				So(matchSpec("DROP", rules[0]) || matchSpec("DROP", rules[1]) || matchSpec("DROP", rules[2]), ShouldBeFalse)
+			})
+		})
+
 		Convey("When I add the app set rules and the command fails ", func() {
 			iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.addAppSetRules("0", "SET-", "172.17.0.2")
+			err := i.addAppSetRules("context", "0", "SET-", "172.17.0.2", false)
 			Convey("I should get an error ", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -180,7 +337,7 @@ func TestAddNetSetRules(t *testing.T) {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.addNetSetRules("0", "SET-", "172.17.0.2")
+			err := i.addNetSetRules("context", "0", "SET-", "172.17.0.2", false)
 			Convey("I should not get an error ", func() {
 				So(err, ShouldBeNil)
 			})
@@ -191,7 +348,7 @@ func TestAddNetSetRules(t *testing.T) {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.addNetSetRules("0", "SET-", "172.17.0.2")
+			err := i.addNetSetRules("context", "0", "SET-", "172.17.0.2", false)
 			Convey("I should get an error ", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -222,7 +379,7 @@ func TestDeleteAppSetRules(t *testing.T) {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.deleteAppSetRules("0", "SET-", "172.17.0.2")
+			err := i.deleteAppSetRules("context", "0", "SET-", "172.17.0.2")
 			Convey("I should not get an error ", func() {
 				So(err, ShouldBeNil)
 			})
@@ -233,7 +390,7 @@ func TestDeleteAppSetRules(t *testing.T) {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.deleteAppSetRules("0", "SET-", "172.17.0.2")
+			err := i.deleteAppSetRules("context", "0", "SET-", "172.17.0.2")
 			Convey("I should still no  error ", func() {
 				So(err, ShouldBeNil)
 			})
@@ -264,7 +421,7 @@ func TestDeleteNetSetRules(t *testing.T) {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.deleteNetSetRules("0", "SET-", "172.17.0.2")
+			err := i.deleteNetSetRules("context", "0", "SET-", "172.17.0.2")
 			Convey("I should not get an error ", func() {
 				So(err, ShouldBeNil)
 			})
@@ -275,7 +432,7 @@ func TestDeleteNetSetRules(t *testing.T) {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.deleteNetSetRules("0", "SET-", "172.17.0.2")
+			err := i.deleteNetSetRules("context", "0", "SET-", "172.17.0.2")
 			Convey("I should stil get no  error ", func() {
 				So(err, ShouldBeNil)
 			})
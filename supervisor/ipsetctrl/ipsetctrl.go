@@ -100,7 +100,7 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 		return fmt.Errorf("No ip address found")
 	}
 
-	if err := i.addAllRules(version, appSetPrefix, netSetPrefix, policyrules.ApplicationACLs(), policyrules.NetworkACLs(), ipAddress); err != nil {
+	if err := i.addAllRules(version, contextID, appSetPrefix, netSetPrefix, policyrules, ipAddress); err != nil {
 		return err
 	}
 
@@ -126,8 +126,8 @@ func (i *Instance) DeleteRules(version int, contextID string, ipAddresses policy
 
 	errvector[0] = i.delContainerFromSet(ipAddress)
 
-	errvector[1] = i.deleteAppSetRules(strconv.Itoa(version), appSetPrefix, ipAddress)
-	errvector[2] = i.deleteNetSetRules(strconv.Itoa(version), netSetPrefix, ipAddress)
+	errvector[1] = i.deleteAppSetRules(contextID, strconv.Itoa(version), appSetPrefix, ipAddress)
+	errvector[2] = i.deleteNetSetRules(contextID, strconv.Itoa(version), netSetPrefix, ipAddress)
 
 	errvector[3] = i.deleteSet(appSetPrefix + allowPrefix + strconv.Itoa(version))
 	errvector[4] = i.deleteSet(appSetPrefix + rejectPrefix + strconv.Itoa(version))
@@ -154,7 +154,7 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 		return fmt.Errorf("No ip address found")
 	}
 
-	if err := i.addAllRules(version, appSetPrefix, netSetPrefix, policyrules.ApplicationACLs(), policyrules.NetworkACLs(), ipAddress); err != nil {
+	if err := i.addAllRules(version, contextID, appSetPrefix, netSetPrefix, policyrules, ipAddress); err != nil {
 		return fmt.Errorf("Unable to add all rules: %s", err)
 	}
 
@@ -162,8 +162,8 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 
 	var errvector [6]error
 
-	errvector[0] = i.deleteAppSetRules(previousVersion, appSetPrefix, ipAddress)
-	errvector[1] = i.deleteNetSetRules(previousVersion, netSetPrefix, ipAddress)
+	errvector[0] = i.deleteAppSetRules(contextID, previousVersion, appSetPrefix, ipAddress)
+	errvector[1] = i.deleteNetSetRules(contextID, previousVersion, netSetPrefix, ipAddress)
 
 	errvector[2] = i.deleteSet(appSetPrefix + allowPrefix + previousVersion)
 	errvector[3] = i.deleteSet(appSetPrefix + rejectPrefix + previousVersion)
@@ -180,27 +180,30 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 
 }
 
-func (i *Instance) addAllRules(version int, appSetPrefix, netSetPrefix string, appACLs policy.IPRuleList, netACLs policy.IPRuleList, ip string) error {
+// addAllRules adds the ACL sets of a policy and their rules. The flows rejected
+// by a PU in audit mode are logged and accepted.
+func (i *Instance) addAllRules(version int, contextID, appSetPrefix, netSetPrefix string, policyrules *policy.PUPolicy, ip string) error {
 
 	versionstring := strconv.Itoa(version)
+	audit := policyrules.TriremeAction() == policy.Audit
 
 	if err := i.addContainerToSet(ip); err != nil {
 		return err
 	}
 
-	if err := i.createACLSets(versionstring, appSetPrefix, appACLs); err != nil {
+	if err := i.createACLSets(versionstring, appSetPrefix, policyrules.ApplicationACLs()); err != nil {
 		return err
 	}
 
-	if err := i.createACLSets(versionstring, netSetPrefix, netACLs); err != nil {
+	if err := i.createACLSets(versionstring, netSetPrefix, policyrules.NetworkACLs()); err != nil {
 		return err
 	}
 
-	if err := i.addAppSetRules(versionstring, appSetPrefix, ip); err != nil {
+	if err := i.addAppSetRules(contextID, versionstring, appSetPrefix, ip, audit); err != nil {
 		return err
 	}
 
-	if err := i.addNetSetRules(versionstring, netSetPrefix, ip); err != nil {
+	if err := i.addNetSetRules(contextID, versionstring, netSetPrefix, ip, audit); err != nil {
 		return err
 	}
 	return nil
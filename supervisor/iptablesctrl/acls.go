@@ -126,14 +126,22 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 		// Application Packets - SYN
 		rules = append(rules, []string{
 			i.appPacketIPTableContext, appChain,
-			"-m", "set", "--match-set", targetNetworkSet, "dst",
+			"-m", "set", "--match-set", i.targetSetName, "dst",
 			"-p", "tcp", "--tcp-flags", "FIN,SYN,RST,PSH,URG", "SYN",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueSynStr(),
 		})
+		// Application Packets - All the packets of the encrypted connections
+		rules = append(rules, []string{
+			i.appAckPacketIPTableContext, appChain,
+			"-m", "set", "--match-set", i.targetSetName, "dst",
+			"-p", "tcp",
+			"-m", "connmark", "--mark", strconv.Itoa(int(constants.EncryptedConnMark)),
+			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
+		})
 		// Application Packets - Evertyhing but SYN (first 4 packets)
 		rules = append(rules, []string{
 			i.appAckPacketIPTableContext, appChain,
-			"-m", "set", "--match-set", targetNetworkSet, "dst",
+			"-m", "set", "--match-set", i.targetSetName, "dst",
 			"-p", "tcp", "--tcp-flags", "SYN,ACK", "ACK",
 			"-m", "connbytes", "--connbytes", ":3", "--connbytes-dir", "original", "--connbytes-mode", "packets",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
@@ -142,14 +150,22 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 		// Network Packets - SYN
 		rules = append(rules, []string{
 			i.netPacketIPTableContext, netChain,
-			"-m", "set", "--match-set", targetNetworkSet, "src",
+			"-m", "set", "--match-set", i.targetSetName, "src",
 			"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueSynStr(),
 		})
+		// Network Packets - All the packets of the encrypted connections
+		rules = append(rules, []string{
+			i.netPacketIPTableContext, netChain,
+			"-m", "set", "--match-set", i.targetSetName, "src",
+			"-p", "tcp",
+			"-m", "connmark", "--mark", strconv.Itoa(int(constants.EncryptedConnMark)),
+			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
+		})
 		// // Network Packets - Evertyhing but SYN (first 4 packets)
 		rules = append(rules, []string{
 			i.netPacketIPTableContext, netChain,
-			"-m", "set", "--match-set", targetNetworkSet, "src",
+			"-m", "set", "--match-set", i.targetSetName, "src",
 			"-p", "tcp",
 			"-m", "connbytes", "--connbytes", ":3", "--connbytes-dir", "original", "--connbytes-mode", "packets",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
@@ -159,7 +175,7 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 		// Application Packets - SYN
 		rules = append(rules, []string{
 			i.appAckPacketIPTableContext, appChain,
-			"-m", "set", "--match-set", targetNetworkSet, "dst",
+			"-m", "set", "--match-set", i.targetSetName, "dst",
 			"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueSynStr(),
 		})
@@ -167,25 +183,46 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 		// Application Packets - Evertyhing but SYN and SYN,ACK (first 4 packets). SYN,ACK is captured by global rule
 		rules = append(rules, []string{
 			i.appAckPacketIPTableContext, appChain,
-			"-m", "set", "--match-set", targetNetworkSet, "dst",
+			"-m", "set", "--match-set", i.targetSetName, "dst",
 			"-p", "tcp", "--tcp-flags", "SYN,ACK", "ACK",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
 		})
 		// Network Packets - SYN
 		rules = append(rules, []string{
 			i.netPacketIPTableContext, netChain,
-			"-m", "set", "--match-set", targetNetworkSet, "src",
+			"-m", "set", "--match-set", i.targetSetName, "src",
 			"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueSynStr(),
 		})
 		// Network Packets - Evertyhing but SYN and SYN,ACK (first 4 packets). SYN,ACK is captured by global rule
 		rules = append(rules, []string{
 			i.netPacketIPTableContext, netChain,
-			"-m", "set", "--match-set", targetNetworkSet, "src",
+			"-m", "set", "--match-set", i.targetSetName, "src",
 			"-p", "tcp", "--tcp-flags", "SYN,ACK", "ACK",
 			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
 		})
 	}
+
+	// UDP flows are authorized on their data packets. Once a flow is authorized
+	// the enforcer marks it and the packets bypass the queues. Linux processes
+	// only capture TCP traffic at the network and are not included.
+	if i.mode != constants.LocalServer {
+		// Application Packets - UDP
+		rules = append(rules, []string{
+			i.appAckPacketIPTableContext, appChain,
+			"-m", "set", "--match-set", i.targetSetName, "dst",
+			"-p", "udp",
+			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
+		})
+		// Network Packets - UDP
+		rules = append(rules, []string{
+			i.netPacketIPTableContext, netChain,
+			"-m", "set", "--match-set", i.targetSetName, "src",
+			"-p", "udp",
+			"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
+		})
+	}
+
 	return rules
 }
 
@@ -233,20 +270,25 @@ func (i *Instance) processRulesFromList(rulelist [][]string, methodType string)
 	return nil
 }
 
-// addChainrules implements all the iptable rules that redirect traffic to a chain
-func (i *Instance) addChainRules(portSetName string, appChain string, netChain string, ip string, port string, mark string, uid string) error {
+// redirectRules returns the rules that redirect the traffic of a processing
+// unit to its chains
+func (i *Instance) redirectRules(portSetName string, appChain string, netChain string, ip string, port string, mark string, uid string) [][]string {
 
 	if i.mode == constants.LocalServer {
 		if port != "0" || uid == "" {
-			return i.processRulesFromList(i.cgroupChainRules(appChain, netChain, mark, port, uid), "Append")
+			return i.cgroupChainRules(appChain, netChain, mark, port, uid)
 		}
 
-		return i.processRulesFromList(i.uidChainRules(portSetName, appChain, netChain, mark, port, uid), "Append")
-
+		return i.uidChainRules(portSetName, appChain, netChain, mark, port, uid)
 	}
 
-	return i.processRulesFromList(i.chainRules(appChain, netChain, ip), "Append")
+	return i.chainRules(appChain, netChain, ip)
+}
+
+// addChainrules implements all the iptable rules that redirect traffic to a chain
+func (i *Instance) addChainRules(portSetName string, appChain string, netChain string, ip string, port string, mark string, uid string) error {
 
+	return i.processRulesFromList(i.redirectRules(portSetName, appChain, netChain, ip, port, mark, uid), "Append")
 }
 
 // addPacketTrap adds the necessary iptables rules to capture control packets to user space
@@ -256,21 +298,64 @@ func (i *Instance) addPacketTrap(appChain string, netChain string, ip string, ne
 
 }
 
+// addChainACLs adds the packet trap rules, the ACLs and the exclusions of a
+// processing unit to its chains
+func (i *Instance) addChainACLs(contextID, appChain, netChain, ipAddress string, policyrules *policy.PUPolicy) error {
+
+	if err := i.addPacketTrap(appChain, netChain, ipAddress, policyrules.TriremeNetworks()); err != nil {
+		return err
+	}
+
+	audit := policyrules.TriremeAction() == policy.Audit
+
+	if err := i.addAppACLs(contextID, appChain, ipAddress, policyrules.ApplicationACLs(), audit); err != nil {
+		return err
+	}
+
+	if err := i.addNetACLs(contextID, netChain, ipAddress, policyrules.NetworkACLs(), audit); err != nil {
+		return err
+	}
+
+	return i.addExclusionACLs(appChain, netChain, ipAddress, policyrules.ExcludedNetworks())
+}
+
 // addAppACLs adds a set of rules to the external services that are initiated
 // by an application. The allow rules are inserted with highest priority.
-func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleList) error {
+// In audit mode the rejected flows are logged as observed and accepted.
+// The rules on DNS names match the sets of the addresses of the names, that
+// watchFQDNs creates before the rules are programmed.
+func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleList, audit bool) error {
 
 	for _, rule := range rules {
 
-		proto := strings.ToLower(rule.Protocol)
+		// The ACLs on DNS names apply to the addresses of both IP families
+		name := rule.FQDN()
+		if name == "" && !i.matchesFamily(rule.Address) {
+			continue
+		}
 
-		if proto == "udp" || proto == "tcp" {
+		icmp, ok, err := i.icmpMatch(rule)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		ipt := i.scheduledRules(rule.Policy.Schedule)
+		ipt.protocol = icmp
+
+		if name != "" {
+			ipt.destination = i.fqdnMatch(name)
+		}
+
+		if rule.HasPorts() {
 
 			switch rule.Policy.Action & (policy.Accept | policy.Reject) {
 			case policy.Accept:
 
 				if rule.Policy.Action&policy.Log > 0 {
-					if err := i.ipt.Append(
+					if err := ipt.Append(
 						i.appAckPacketIPTableContext,
 						chain,
 						"-p", rule.Protocol,
@@ -284,7 +369,7 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 					}
 				}
 
-				if err := i.ipt.Append(
+				if err := ipt.Append(
 					i.appAckPacketIPTableContext, chain,
 					"-p", rule.Protocol, "-m", "state", "--state", "NEW",
 					"-d", rule.Address,
@@ -295,18 +380,20 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 				}
 
 			case policy.Reject:
-				if err := i.ipt.Insert(
+				target, shortAction := rejectTarget(audit, rule.Policy.Action.ShortActionString())
+
+				if err := ipt.Insert(
 					i.appAckPacketIPTableContext, chain, 1,
 					"-p", rule.Protocol, "-m", "state", "--state", "NEW",
 					"-d", rule.Address,
 					"--dport", rule.Port,
-					"-j", "DROP",
+					"-j", target,
 				); err != nil {
 					return fmt.Errorf("Failed to add acl rule for table %s, chain %s, with %s", i.appAckPacketIPTableContext, chain, err.Error())
 				}
 
-				if rule.Policy.Action&policy.Log > 0 {
-					if err := i.ipt.Insert(
+				if rule.Policy.Action&policy.Log > 0 || audit {
+					if err := ipt.Insert(
 						i.appAckPacketIPTableContext,
 						chain,
 						1,
@@ -315,7 +402,7 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 						"--dport", rule.Port,
 						"-m", "state", "--state", "NEW",
 						"-j", "NFLOG", "--nflog-group", "10",
-						"--nflog-prefix", contextID+":"+rule.Policy.PolicyID+":"+rule.Policy.ServiceID+rule.Policy.Action.ShortActionString(),
+						"--nflog-prefix", contextID+":"+rule.Policy.PolicyID+":"+rule.Policy.ServiceID+shortAction,
 					); err != nil {
 						return fmt.Errorf("Failed to add acl log rule for table %s, chain %s, with %s", i.appAckPacketIPTableContext, chain, err.Error())
 					}
@@ -331,7 +418,7 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 			case policy.Accept:
 
 				if rule.Policy.Action&policy.Log > 0 {
-					if err := i.ipt.Append(
+					if err := ipt.Append(
 						i.appAckPacketIPTableContext,
 						chain,
 						"-p", rule.Protocol,
@@ -344,7 +431,7 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 					}
 				}
 
-				if err := i.ipt.Append(
+				if err := ipt.Append(
 					i.appAckPacketIPTableContext, chain,
 					"-p", rule.Protocol,
 					"-d", rule.Address,
@@ -354,17 +441,19 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 				}
 
 			case policy.Reject:
-				if err := i.ipt.Insert(
+				target, shortAction := rejectTarget(audit, rule.Policy.Action.ShortActionString())
+
+				if err := ipt.Insert(
 					i.appAckPacketIPTableContext, chain, 1,
 					"-p", rule.Protocol,
 					"-d", rule.Address,
-					"-j", "DROP",
+					"-j", target,
 				); err != nil {
 					return fmt.Errorf("Failed to add acl rule for table %s, chain %s, with error: %s", i.appAckPacketIPTableContext, chain, err.Error())
 				}
 
-				if rule.Policy.Action&policy.Log > 0 {
-					if err := i.ipt.Insert(
+				if rule.Policy.Action&policy.Log > 0 || audit {
+					if err := ipt.Insert(
 						i.appAckPacketIPTableContext,
 						chain,
 						1,
@@ -372,7 +461,7 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 						"-d", rule.Address,
 						"-m", "state", "--state", "NEW",
 						"-j", "NFLOG", "--nflog-group", "10",
-						"--nflog-prefix", contextID+":"+rule.Policy.PolicyID+":"+rule.Policy.ServiceID+rule.Policy.Action.ShortActionString(),
+						"--nflog-prefix", contextID+":"+rule.Policy.PolicyID+":"+rule.Policy.ServiceID+shortAction,
 					); err != nil {
 						return fmt.Errorf("Failed to add acl log rule for table %s, chain %s, with %s", i.appAckPacketIPTableContext, chain, err.Error())
 					}
@@ -387,7 +476,7 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 	// Accept established connections
 	if err := i.ipt.Append(
 		i.appAckPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.anyNetwork,
 		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT"); err != nil {
 
@@ -396,21 +485,23 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 
 	if err := i.ipt.Append(
 		i.appAckPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.anyNetwork,
 		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT"); err != nil {
 
 		return fmt.Errorf("Failed to add default tcp acl rule for table %s, chain %s, with error: %s", i.appAckPacketIPTableContext, chain, err.Error())
 	}
 
+	target, shortAction := rejectTarget(audit, policy.Reject.ShortActionString())
+
 	// Log everything else
 	if err := i.ipt.Append(
 		i.appAckPacketIPTableContext,
 		chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.anyNetwork,
 		"-m", "state", "--state", "NEW",
 		"-j", "NFLOG", "--nflog-group", "10",
-		"--nflog-prefix", contextID+":default:defaultr",
+		"--nflog-prefix", contextID+":default:default"+shortAction,
 	); err != nil {
 		return fmt.Errorf("Failed to add acl log rule for table %s, chain %s, with %s", i.appAckPacketIPTableContext, chain, err.Error())
 	}
@@ -418,8 +509,8 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 	// Drop everything else
 	if err := i.ipt.Append(
 		i.appAckPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
-		"-j", "DROP"); err != nil {
+		"-d", i.anyNetwork,
+		"-j", target); err != nil {
 
 		return fmt.Errorf("Failed to add default drop acl rule for table %s, chain %s, with error: %s", i.appAckPacketIPTableContext, chain, err.Error())
 	}
@@ -429,19 +520,33 @@ func (i *Instance) addAppACLs(contextID, chain, ip string, rules policy.IPRuleLi
 
 // addNetACLs adds iptables rules that manage traffic from external services. The
 // explicit rules are added with the highest priority since they are direct allows.
-func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleList) error {
+// In audit mode the rejected flows are logged as observed and accepted.
+func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleList, audit bool) error {
 
 	for _, rule := range rules {
 
-		proto := strings.ToLower(rule.Protocol)
+		if !i.matchesFamily(rule.Address) {
+			continue
+		}
+
+		icmp, ok, err := i.icmpMatch(rule)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		ipt := i.scheduledRules(rule.Policy.Schedule)
+		ipt.protocol = icmp
 
-		if proto == "udp" || proto == "tcp" {
+		if rule.HasPorts() {
 
 			switch rule.Policy.Action & (policy.Accept | policy.Reject) {
 			case policy.Accept:
 
 				if rule.Policy.Action&policy.Log > 0 {
-					if err := i.ipt.Append(
+					if err := ipt.Append(
 						i.netPacketIPTableContext,
 						chain,
 						"-p", rule.Protocol,
@@ -455,7 +560,7 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 					}
 				}
 
-				if err := i.ipt.Append(
+				if err := ipt.Append(
 					i.netPacketIPTableContext, chain,
 					"-p", rule.Protocol,
 					"-s", rule.Address,
@@ -466,19 +571,21 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 					return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
 				}
 			case policy.Reject:
-				if err := i.ipt.Insert(
+				target, shortAction := rejectTarget(audit, rule.Policy.Action.ShortActionString())
+
+				if err := ipt.Insert(
 					i.netPacketIPTableContext, chain, 1,
 					"-p", rule.Protocol,
 					"-s", rule.Address,
 					"--dport", rule.Port,
-					"-j", "DROP",
+					"-j", target,
 				); err != nil {
 
 					return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
 				}
 
-				if rule.Policy.Action&policy.Log > 0 {
-					if err := i.ipt.Insert(
+				if rule.Policy.Action&policy.Log > 0 || audit {
+					if err := ipt.Insert(
 						i.netPacketIPTableContext,
 						chain,
 						1,
@@ -487,7 +594,7 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 						"--dport", rule.Port,
 						"-m", "state", "--state", "NEW",
 						"-j", "NFLOG", "--nflog-group", "11",
-						"--nflog-prefix", contextID+":"+rule.Policy.PolicyID+":"+rule.Policy.ServiceID+rule.Policy.Action.ShortActionString(),
+						"--nflog-prefix", contextID+":"+rule.Policy.PolicyID+":"+rule.Policy.ServiceID+shortAction,
 					); err != nil {
 						return fmt.Errorf("Failed to add net log rule for table %s, chain %s, with %s", i.netPacketIPTableContext, chain, err.Error())
 					}
@@ -502,7 +609,7 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 			switch rule.Policy.Action & (policy.Accept | policy.Reject) {
 			case policy.Accept:
 				if rule.Policy.Action&policy.Log > 0 {
-					if err := i.ipt.Append(
+					if err := ipt.Append(
 						i.netPacketIPTableContext,
 						chain,
 						"-p", rule.Protocol,
@@ -515,7 +622,7 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 					}
 				}
 
-				if err := i.ipt.Append(
+				if err := ipt.Append(
 					i.netPacketIPTableContext, chain,
 					"-p", rule.Protocol,
 					"-s", rule.Address,
@@ -525,18 +632,20 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 					return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
 				}
 			case policy.Reject:
-				if err := i.ipt.Insert(
+				target, shortAction := rejectTarget(audit, rule.Policy.Action.ShortActionString())
+
+				if err := ipt.Insert(
 					i.netPacketIPTableContext, chain, 1,
 					"-p", rule.Protocol,
 					"-s", rule.Address,
-					"-j", "DROP",
+					"-j", target,
 				); err != nil {
 
 					return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
 				}
 
-				if rule.Policy.Action&policy.Log > 0 {
-					if err := i.ipt.Insert(
+				if rule.Policy.Action&policy.Log > 0 || audit {
+					if err := ipt.Insert(
 						i.netPacketIPTableContext,
 						chain,
 						1,
@@ -544,7 +653,7 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 						"-s", rule.Address,
 						"-m", "state", "--state", "NEW",
 						"-j", "NFLOG", "--nflog-group", "11",
-						"--nflog-prefix", contextID+":"+rule.Policy.PolicyID+":"+rule.Policy.ServiceID+rule.Policy.Action.ShortActionString(),
+						"--nflog-prefix", contextID+":"+rule.Policy.PolicyID+":"+rule.Policy.ServiceID+shortAction,
 					); err != nil {
 						return fmt.Errorf("Failed to add net log rule for table %s, chain %s, with %s", i.netPacketIPTableContext, chain, err.Error())
 					}
@@ -558,7 +667,7 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 	// Accept established connections
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.anyNetwork,
 		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT",
 	); err != nil {
@@ -568,7 +677,7 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.anyNetwork,
 		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT",
 	); err != nil {
@@ -576,14 +685,16 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 		return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
 	}
 
+	target, shortAction := rejectTarget(audit, policy.Reject.ShortActionString())
+
 	// Log everything
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext,
 		chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.anyNetwork,
 		"-m", "state", "--state", "NEW",
 		"-j", "NFLOG", "--nflog-group", "11",
-		"--nflog-prefix", contextID+":default:defaultr",
+		"--nflog-prefix", contextID+":default:default"+shortAction,
 	); err != nil {
 		return fmt.Errorf("Failed to add net log rule for table %s, chain %s, with %s", i.netPacketIPTableContext, chain, err.Error())
 	}
@@ -591,8 +702,8 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 	// Drop everything else
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
-		"-j", "DROP",
+		"-s", i.anyNetwork,
+		"-j", target,
 	); err != nil {
 
 		return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
@@ -601,6 +712,52 @@ func (i *Instance) addNetACLs(contextID, chain, ip string, rules policy.IPRuleLi
 	return nil
 }
 
+// rejectTarget returns the target and the log short action of the rejected
+// flows. The flows rejected by a PU in audit mode are accepted and logged as
+// observed.
+func rejectTarget(audit bool, shortAction string) (string, string) {
+
+	if audit {
+		return "ACCEPT", policy.ObservedShortAction
+	}
+
+	return "DROP", shortAction
+}
+
+// icmpMatch returns the match of the type and the code of an ICMP rule, which
+// is empty for the other rules. It returns false for the ICMP rules of the
+// other IP family.
+func (i *Instance) icmpMatch(rule policy.IPRule) ([]string, bool, error) {
+
+	if !rule.IsICMP() {
+		return nil, true, nil
+	}
+
+	if number, _ := policy.ProtocolNumber(rule.Protocol); (number == policy.ProtocolICMPv6) != i.ipv6 {
+		return nil, false, nil
+	}
+
+	icmpType, icmpCode, err := rule.ICMPTypeCode()
+	if err != nil {
+		return nil, false, fmt.Errorf("Invalid ICMP rule: %s", err)
+	}
+
+	if icmpType < 0 {
+		return nil, true, nil
+	}
+
+	typeCode := strconv.Itoa(icmpType)
+	if icmpCode >= 0 {
+		typeCode += "/" + strconv.Itoa(icmpCode)
+	}
+
+	if i.ipv6 {
+		return []string{"--icmpv6-type", typeCode}, true, nil
+	}
+
+	return []string{"--icmp-type", typeCode}, true, nil
+}
+
 // deleteChainRules deletes the rules that send traffic to our chain
 func (i *Instance) deleteChainRules(portSetName, appChain, netChain, ip string, port string, mark string, uid string) error {
 
@@ -669,81 +826,55 @@ func (i *Instance) deleteAllContainerChains(appChain, netChain string) error {
 	return nil
 }
 
-// setGlobalRules installs the global rules
-func (i *Instance) setGlobalRules(appChain, netChain string) error {
-
-	err := i.ipt.Insert(
-		i.appAckPacketIPTableContext,
-		appChain, 1,
-		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
-		"-j", "ACCEPT")
-
-	if err != nil {
-		return fmt.Errorf("Failed to add default allow for marked packets at app ")
-	}
-
-	err = i.ipt.Insert(
-		i.appAckPacketIPTableContext,
-		appChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
-		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
-		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetApplicationQueueSynAckStr())
-
-	if err != nil {
-		return fmt.Errorf("Failed to add capture SynAck rule for table %s, chain %s, with error: %s", i.appAckPacketIPTableContext, i.appPacketIPTableSection, err.Error())
-	}
-
-	err = i.ipt.Insert(
-		i.appAckPacketIPTableContext,
-		appChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
-		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
-		"-j", "MARK", "--set-mark", strconv.Itoa(cgnetcls.Initialmarkval-1))
-	if err != nil {
-		return fmt.Errorf("Failed to add capture SynAck rule for table %s, chain %s, with error: %s", i.appAckPacketIPTableContext, i.appPacketIPTableSection, err.Error())
-	}
-
-	err = i.ipt.Insert(
-		i.appAckPacketIPTableContext,
-		i.appPacketIPTableSection, 1,
-		"-j", uidchain)
-	if err != nil {
-		return fmt.Errorf("Failed to add UID chain  %s, chain %s, with error: %s", i.appAckPacketIPTableContext, i.appPacketIPTableSection, err.Error())
-	}
-
-	err = i.ipt.Insert(
-		i.appAckPacketIPTableContext,
-		appChain, 1,
-		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
-		"-j", "ACCEPT")
+// globalRules returns the global rules in the order they are inserted at the
+// top of their chains
+func (i *Instance) globalRules(appChain, netChain string) [][]string {
 
-	if err != nil {
-		return fmt.Errorf("Failed to add default allow for marked packets at net")
-	}
-
-	err = i.ipt.Insert(
-		i.netPacketIPTableContext,
-		netChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
-		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
-		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueSynAckStr())
-
-	if err != nil {
-		return fmt.Errorf("Failed to add capture SynAck rule for table %s, chain %s, with error: %s", i.appAckPacketIPTableContext, i.appPacketIPTableSection, err.Error())
-	}
-
-	err = i.ipt.Insert(
-		i.netPacketIPTableContext,
-		netChain, 1,
-		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
-		"-j", "ACCEPT")
-
-	if err != nil {
-		return fmt.Errorf("Failed to add capture SynAck rule for table %s, chain %s, with error: %s", i.appAckPacketIPTableContext, i.appPacketIPTableSection, err.Error())
+	return [][]string{
+		{
+			i.appAckPacketIPTableContext, appChain,
+			"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+			"-j", "ACCEPT",
+		},
+		{
+			i.appAckPacketIPTableContext, appChain,
+			"-m", "set", "--match-set", i.targetSetName, "dst",
+			"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
+			"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetApplicationQueueSynAckStr(),
+		},
+		{
+			i.appAckPacketIPTableContext, appChain,
+			"-m", "set", "--match-set", i.targetSetName, "dst",
+			"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
+			"-j", "MARK", "--set-mark", strconv.Itoa(cgnetcls.Initialmarkval - 1),
+		},
+		{
+			i.appAckPacketIPTableContext, i.appPacketIPTableSection,
+			"-j", uidchain,
+		},
+		{
+			i.appAckPacketIPTableContext, appChain,
+			"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+			"-j", "ACCEPT",
+		},
+		{
+			i.netPacketIPTableContext, netChain,
+			"-m", "set", "--match-set", i.targetSetName, "src",
+			"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
+			"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueSynAckStr(),
+		},
+		{
+			i.netPacketIPTableContext, netChain,
+			"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+			"-j", "ACCEPT",
+		},
 	}
+}
 
-	return nil
+// setGlobalRules installs the global rules
+func (i *Instance) setGlobalRules(appChain, netChain string) error {
 
+	return i.processRulesFromList(i.globalRules(appChain, netChain), "Insert")
 }
 
 // CleanGlobalRules cleans the capture rules for SynAck packets
@@ -752,7 +883,7 @@ func (i *Instance) CleanGlobalRules() error {
 	if err := i.ipt.Delete(
 		i.appAckPacketIPTableContext,
 		i.appPacketIPTableSection,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetSetName, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetApplicationQueueAckStr()); err != nil {
 
@@ -762,7 +893,7 @@ func (i *Instance) CleanGlobalRules() error {
 	if err := i.ipt.Delete(
 		i.netPacketIPTableContext,
 		i.netPacketIPTableSection,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetSetName, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueAckStr()); err != nil {
 
@@ -931,6 +1062,10 @@ func (i *Instance) addExclusionACLs(appChain, netChain string, ip string, exclus
 
 	for _, e := range exclusions {
 
+		if !i.matchesFamily(e) {
+			continue
+		}
+
 		if err := i.ipt.Insert(
 			i.appAckPacketIPTableContext, appChain, 1,
 			"-s", ip,
@@ -2,12 +2,15 @@ package iptablesctrl
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aporeto-inc/trireme/constants"
 	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqdn"
 	"github.com/aporeto-inc/trireme/policy"
 	"github.com/aporeto-inc/trireme/supervisor/provider"
 	"github.com/bvandewalle/go-ipset/ipset"
@@ -332,7 +335,7 @@ func TestAddAppACLs(t *testing.T) {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.addAppACLs("", "chain", "", policy.IPRuleList{})
+			err := i.addAppACLs("", "chain", "", policy.IPRuleList{}, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -346,7 +349,7 @@ func TestAddAppACLs(t *testing.T) {
 				return nil
 			})
 
-			err := i.addAppACLs("", "chain", "", policy.IPRuleList{})
+			err := i.addAppACLs("", "chain", "", policy.IPRuleList{}, false)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -382,7 +385,7 @@ func TestAddAppACLs(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addAppACLs("chain", "", "", rules)
+			err := i.addAppACLs("chain", "", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -415,7 +418,7 @@ func TestAddAppACLs(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addAppACLs("chain", "", "", rules)
+			err := i.addAppACLs("chain", "", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -448,7 +451,7 @@ func TestAddAppACLs(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addAppACLs("chain", "", "", rules)
+			err := i.addAppACLs("chain", "", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -480,7 +483,7 @@ func TestAddNetAcls(t *testing.T) {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.addNetACLs("", "chain", "", policy.IPRuleList{})
+			err := i.addNetACLs("", "chain", "", policy.IPRuleList{}, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -494,7 +497,7 @@ func TestAddNetAcls(t *testing.T) {
 				return nil
 			})
 
-			err := i.addNetACLs("", "chain", "", policy.IPRuleList{})
+			err := i.addNetACLs("", "chain", "", policy.IPRuleList{}, false)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -533,7 +536,7 @@ func TestAddNetAcls(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addNetACLs("chain", "", "", rules)
+			err := i.addNetACLs("chain", "", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -569,7 +572,7 @@ func TestAddNetAcls(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addNetACLs("chain", "", "", rules)
+			err := i.addNetACLs("chain", "", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -605,7 +608,7 @@ func TestAddNetAcls(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addNetACLs("chain", "", "", rules)
+			err := i.addNetACLs("chain", "", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -614,6 +617,283 @@ func TestAddNetAcls(t *testing.T) {
 	})
 }
 
+func TestAddAuditACLs(t *testing.T) {
+
+	Convey("Given an iptables controller with a memory provider", t, func() {
+		iptables := provider.NewTestIptablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, iptables, false)
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject, PolicyID: "reject", ServiceID: "service"},
+			},
+		}
+
+		targets := []string{}
+		prefixes := []string{}
+		record := func(rulespec []string) {
+			for j := range rulespec {
+				switch rulespec[j] {
+				case "-j":
+					targets = append(targets, rulespec[j+1])
+				case "--nflog-prefix":
+					prefixes = append(prefixes, rulespec[j+1])
+				}
+			}
+		}
+
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			record(rulespec)
+			return nil
+		})
+		iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+			record(rulespec)
+			return nil
+		})
+
+		Convey("When I add net ACLs in audit mode, the rejected flows should be logged as observed and accepted", func() {
+			err := i.addNetACLs("context", "chain", "", rules, true)
+			So(err, ShouldBeNil)
+			So(targets, ShouldNotContain, "DROP")
+			So(prefixes, ShouldContain, "context:reject:service"+policy.ObservedShortAction)
+			So(prefixes, ShouldContain, "context:default:default"+policy.ObservedShortAction)
+		})
+
+		Convey("When I add app ACLs in audit mode, the rejected flows should be logged as observed and accepted", func() {
+			err := i.addAppACLs("context", "chain", "", rules, true)
+			So(err, ShouldBeNil)
+			So(targets, ShouldNotContain, "DROP")
+			So(prefixes, ShouldContain, "context:reject:service"+policy.ObservedShortAction)
+			So(prefixes, ShouldContain, "context:default:default"+policy.ObservedShortAction)
+		})
+
+		Convey("When I add net ACLs without audit mode, the rejected flows should be dropped", func() {
+			err := i.addNetACLs("context", "chain", "", rules, false)
+			So(err, ShouldBeNil)
+			So(targets, ShouldContain, "DROP")
+			So(prefixes, ShouldContain, "context:default:defaultr")
+		})
+	})
+}
+
+func TestAddScheduledACLs(t *testing.T) {
+
+	Convey("Given an iptables controller with a memory provider", t, func() {
+		iptables := provider.NewTestIptablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, iptables, false)
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80",
+				Protocol: "TCP",
+				Policy: &policy.FlowPolicy{
+					Action: policy.Accept,
+					Schedule: &policy.Schedule{
+						NotAfter: time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC),
+						Windows: []policy.Window{
+							{Start: 22 * time.Hour, End: 2 * time.Hour, Weekdays: []time.Weekday{time.Saturday, time.Sunday}},
+							{Start: 12 * time.Hour, End: 13 * time.Hour},
+						},
+					},
+				},
+			},
+		}
+
+		timed := [][]string{}
+		untimed := 0
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			if matchSpec("time", rulespec) == nil {
+				timed = append(timed, rulespec)
+			} else {
+				untimed++
+			}
+			return nil
+		})
+		iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+			if matchSpec("time", rulespec) == nil {
+				timed = append(timed, rulespec)
+			}
+			return nil
+		})
+
+		Convey("When I add app ACLs with a schedule, a rule should be added for each window", func() {
+			err := i.addAppACLs("context", "chain", "", rules, false)
+			So(err, ShouldBeNil)
+			So(timed, ShouldHaveLength, 2)
+			So(strings.Join(timed[0], " "), ShouldEqual,
+				"-p TCP -m state --state NEW -d 192.30.253.0/24 --dport 80 -m time --datestop 2029-12-31T23:59:59 --timestart 22:00:00 --timestop 01:59:59 --contiguous --weekdays Sat,Sun -j ACCEPT")
+			So(strings.Join(timed[1], " "), ShouldEqual,
+				"-p TCP -m state --state NEW -d 192.30.253.0/24 --dport 80 -m time --datestop 2029-12-31T23:59:59 --timestart 12:00:00 --timestop 12:59:59 -j ACCEPT")
+			So(untimed, ShouldBeGreaterThan, 0)
+		})
+	})
+}
+
+func TestAddICMPACLs(t *testing.T) {
+
+	Convey("Given iptables controllers of both IP families", t, func() {
+		iptables := provider.NewTestIptablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, iptables, false)
+		i6 := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, iptables, true)
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "0.0.0.0/0",
+				Protocol: "icmp",
+				ICMPType: "3",
+				ICMPCode: "4",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+			policy.IPRule{
+				Address:  "10.0.0.0/8",
+				Protocol: "icmp",
+				ICMPType: "0",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+			policy.IPRule{
+				Address:  "::/0",
+				Protocol: "icmpv6",
+				ICMPType: "129",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+			policy.IPRule{
+				Address:  "10.0.0.0/8",
+				Protocol: "gre",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+			policy.IPRule{
+				Address:  "10.0.0.0/8",
+				Port:     "3868",
+				Protocol: "sctp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+		}
+
+		accepted := []string{}
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			if matchSpec("ACCEPT", rulespec) == nil && matchSpec("ESTABLISHED", rulespec) != nil {
+				accepted = append(accepted, strings.Join(rulespec, " "))
+			}
+			return nil
+		})
+
+		Convey("When I add net ACLs to the IPv4 controller, the ICMP types and codes and the protocols should be matched", func() {
+			err := i.addNetACLs("context", "chain", "", rules, false)
+			So(err, ShouldBeNil)
+			So(accepted, ShouldResemble, []string{
+				"-p icmp --icmp-type 3/4 -s 0.0.0.0/0 -j ACCEPT",
+				"-p icmp --icmp-type 0 -s 10.0.0.0/8 -j ACCEPT",
+				"-p gre -s 10.0.0.0/8 -j ACCEPT",
+				"-p sctp -s 10.0.0.0/8 --dport 3868 -j ACCEPT",
+			})
+		})
+
+		Convey("When I add net ACLs to the IPv6 controller, only the ICMPv6 rules should be added", func() {
+			err := i6.addNetACLs("context", "chain", "", rules, false)
+			So(err, ShouldBeNil)
+			So(accepted, ShouldResemble, []string{
+				"-p icmpv6 --icmpv6-type 129 -s ::/0 -j ACCEPT",
+			})
+		})
+
+		Convey("When I add app ACLs with an invalid ICMP code, I should get an error", func() {
+			err := i.addAppACLs("context", "chain", "", policy.IPRuleList{
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Protocol: "icmp",
+					ICMPCode: "4",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+			}, false)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// testResolver resolves the names from a map
+type testResolver map[string][]net.IP
+
+func (r testResolver) Resolve(name string) ([]net.IP, time.Duration, error) {
+	return r[name], time.Minute, nil
+}
+
+func TestAddFQDNACLs(t *testing.T) {
+
+	Convey("Given an iptables controller with a memory provider and a resolver", t, func() {
+		iptables := provider.NewTestIptablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, iptables, false)
+		i.fqdnWatcher = fqdn.NewWatcher(testResolver{
+			"api.example.com": {net.ParseIP("192.30.253.1"), net.ParseIP("2001:db8::1")},
+		})
+
+		entries := map[string]bool{}
+		testset := provider.NewTestIpset()
+		testset.MockAdd(t, func(entry string, timeout int) error {
+			entries[entry] = true
+			return nil
+		})
+		testset.MockDel(t, func(entry string) error {
+			delete(entries, entry)
+			return nil
+		})
+
+		sets := []string{}
+		ipsets := provider.NewTestIpsetProvider()
+		ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+			sets = append(sets, name)
+			return testset, nil
+		})
+		i.ipset = ipsets
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "api.example.com",
+				Port:     "443",
+				Protocol: "TCP",
+				Policy: &policy.FlowPolicy{
+					Action: policy.Accept,
+				},
+			},
+		}
+
+		accepted := [][]string{}
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			if matchSpec("443", rulespec) == nil {
+				accepted = append(accepted, rulespec)
+			}
+			return nil
+		})
+
+		Convey("When I render app ACLs on a DNS name, no set should be created", func() {
+			err := i.addAppACLs("context", "chain", "", rules, false)
+			So(err, ShouldBeNil)
+			So(sets, ShouldBeEmpty)
+			So(i.fqdnWatcher.Names(""), ShouldBeEmpty)
+			So(accepted, ShouldHaveLength, 1)
+		})
+
+		Convey("When I add app ACLs on a DNS name, the rules should match the set of the addresses of the name", func() {
+			So(i.watchFQDNs("context", rules), ShouldBeNil)
+			err := i.addAppACLs("context", "chain", "", rules, false)
+			So(err, ShouldBeNil)
+			So(sets, ShouldResemble, []string{i.fqdnSetName("api.example.com")})
+			So(entries, ShouldResemble, map[string]bool{"192.30.253.1": true})
+			So(accepted, ShouldHaveLength, 1)
+			So(strings.Join(accepted[0], " "), ShouldEqual,
+				"-p TCP -m state --state NEW -m set --match-set "+sets[0]+" dst --dport 443 -j ACCEPT")
+
+			Convey("When the addresses of the name change, the set should follow them", func() {
+				i.updateFQDN("api.example.com", []net.IP{net.ParseIP("192.30.253.2"), net.ParseIP("2001:db8::2")})
+				So(entries, ShouldResemble, map[string]bool{"192.30.253.2": true})
+			})
+		})
+	})
+}
+
 func TestDeleteChainRules(t *testing.T) {
 
 	Convey("Given an iptables controller", t, func() {
@@ -0,0 +1,180 @@
+package iptablesctrl
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// DualStackInstance is an implementation that manages the IPv4 rules with iptables
+// and the IPv6 rules with ip6tables. The IPv6 rules of a processing unit are only
+// configured when the processing unit has an IPv6 address.
+type DualStackInstance struct {
+	ipv4 *Instance
+	ipv6 *Instance
+}
+
+// NewDualStackInstance creates a new dual-stack controller instance. If ip6tables
+// is not available, the instance only manages the IPv4 rules.
+func NewDualStackInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType) (*DualStackInstance, error) {
+
+	ipv4, err := NewInstance(fqc, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	ipv6, err := NewIPv6Instance(fqc, mode)
+	if err != nil {
+		zap.L().Warn("IPv6 rules will not be configured", zap.Error(err))
+		ipv6 = nil
+	}
+
+	return &DualStackInstance{
+		ipv4: ipv4,
+		ipv6: ipv6,
+	}, nil
+}
+
+// ConfigureRules implmenets the ConfigureRules interface. The IPv4 rules are
+// deleted if the IPv6 rules can't be configured.
+func (d *DualStackInstance) ConfigureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	if err := d.ipv4.ConfigureRules(version, contextID, containerInfo); err != nil {
+		return err
+	}
+
+	if d.hasIPv6(containerInfo.Policy.IPAddresses()) {
+		if err := d.ipv6.ConfigureRules(version, contextID, containerInfo); err != nil {
+			d.deleteIPv4Rules(version, contextID, containerInfo)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteRules implements the DeleteRules interface
+func (d *DualStackInstance) DeleteRules(version int, contextID string, ipAddresses policy.ExtendedMap, port string, mark string, uid string) error {
+
+	if d.hasIPv6(ipAddresses) {
+		if err := d.ipv6.DeleteRules(version, contextID, ipAddresses, port, mark, uid); err != nil {
+			zap.L().Warn("Failed to delete the IPv6 rules", zap.String("contextID", contextID), zap.Error(err))
+		}
+	}
+
+	return d.ipv4.DeleteRules(version, contextID, ipAddresses, port, mark, uid)
+}
+
+// UpdateRules implements the update part of the interface. The IPv4 rules are
+// deleted if the IPv6 rules can't be updated, so that the processing unit is
+// never left with the policies of different versions.
+func (d *DualStackInstance) UpdateRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	if err := d.ipv4.UpdateRules(version, contextID, containerInfo); err != nil {
+		return err
+	}
+
+	if containerInfo.Policy != nil && d.hasIPv6(containerInfo.Policy.IPAddresses()) {
+		if err := d.ipv6.UpdateRules(version, contextID, containerInfo); err != nil {
+			d.deleteIPv4Rules(version, contextID, containerInfo)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteIPv4Rules deletes the IPv4 rules of a processing unit whose IPv6 rules
+// failed
+func (d *DualStackInstance) deleteIPv4Rules(version int, contextID string, containerInfo *policy.PUInfo) {
+
+	mark, port, uid := d.ipv4.puOptions(containerInfo)
+
+	if err := d.ipv4.DeleteRules(version, contextID, containerInfo.Policy.IPAddresses(), port, mark, uid); err != nil {
+		zap.L().Warn("Failed to delete the IPv4 rules", zap.String("contextID", contextID), zap.Error(err))
+	}
+}
+
+// SetTargetNetworks updates ths target networks of both IP families
+func (d *DualStackInstance) SetTargetNetworks(current, networks []string) error {
+
+	if err := d.ipv4.SetTargetNetworks(current, networks); err != nil {
+		return err
+	}
+
+	if d.ipv6 != nil {
+		return d.ipv6.SetTargetNetworks(current, networks)
+	}
+
+	return nil
+}
+
+// ReconcileRules repairs the rules of a processing unit of both IP families
+func (d *DualStackInstance) ReconcileRules(contextID string) (int, error) {
+
+	drifts, err := d.ipv4.ReconcileRules(contextID)
+	if err != nil || d.ipv6 == nil {
+		return drifts, err
+	}
+
+	drifts6, err := d.ipv6.ReconcileRules(contextID)
+
+	return drifts + drifts6, err
+}
+
+// ReconcileGlobalRules repairs the global rules of both IP families
+func (d *DualStackInstance) ReconcileGlobalRules() (int, error) {
+
+	drifts, err := d.ipv4.ReconcileGlobalRules()
+	if err != nil || d.ipv6 == nil {
+		return drifts, err
+	}
+
+	drifts6, err := d.ipv6.ReconcileGlobalRules()
+
+	return drifts + drifts6, err
+}
+
+// Start starts the iptables and ip6tables controllers
+func (d *DualStackInstance) Start() error {
+
+	if err := d.ipv4.Start(); err != nil {
+		return err
+	}
+
+	if d.ipv6 != nil {
+		return d.ipv6.Start()
+	}
+
+	return nil
+}
+
+// Stop stops the iptables and ip6tables controllers
+func (d *DualStackInstance) Stop() error {
+
+	err := d.ipv4.Stop()
+
+	// The sets can only be destroyed once no rules refer to them. Stopping the
+	// IPv6 controller last cleans up the sets of both families.
+	if d.ipv6 != nil {
+		if err6 := d.ipv6.Stop(); err6 != nil {
+			zap.L().Warn("Failed to stop the IPv6 controller", zap.Error(err6))
+		}
+	}
+
+	return err
+}
+
+// hasIPv6 returns true if the IPv6 rules must be configured for the given addresses
+func (d *DualStackInstance) hasIPv6(ipAddresses policy.ExtendedMap) bool {
+
+	if d.ipv6 == nil {
+		return false
+	}
+
+	_, ok := d.ipv6.defaultIP(ipAddresses)
+
+	return ok
+}
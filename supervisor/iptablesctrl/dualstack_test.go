@@ -0,0 +1,220 @@
+package iptablesctrl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+	"github.com/bvandewalle/go-ipset/ipset"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIPv6Instance(t *testing.T) {
+
+	Convey("Given an ip6tables controller", t, func() {
+		iptables := provider.NewTestIptablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, iptables, true)
+
+		Convey("When I get the default IP of a dual-stack PU, I should get the IPv6 address", func() {
+			address, ok := i.defaultIP(map[string]string{
+				policy.DefaultNamespace:     "10.1.1.1",
+				policy.DefaultIPv6Namespace: "2001:db8::1",
+			})
+			So(ok, ShouldBeTrue)
+			So(address, ShouldEqual, "2001:db8::1")
+		})
+
+		Convey("When I get the default IP of an IPv4 only PU, I should get false", func() {
+			address, ok := i.defaultIP(map[string]string{
+				policy.DefaultNamespace: "10.1.1.1",
+			})
+			So(ok, ShouldBeFalse)
+			So(address, ShouldEqual, "::/0")
+		})
+
+		Convey("When I add net ACLs of both families, only the IPv6 rules should be added", func() {
+			sources := []string{}
+			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+				for j := range rulespec {
+					if rulespec[j] == "-s" {
+						sources = append(sources, rulespec[j+1])
+					}
+				}
+				return nil
+			})
+
+			rules := policy.IPRuleList{
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Port:     "80",
+					Protocol: "tcp",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+				policy.IPRule{
+					Address:  "2001:db8::/32",
+					Port:     "80",
+					Protocol: "tcp",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+			}
+
+			err := i.addNetACLs("context", "chain", "2001:db8::1", rules, false)
+			So(err, ShouldBeNil)
+			So(sources, ShouldContain, "2001:db8::/32")
+			So(sources, ShouldContain, "::/0")
+			So(sources, ShouldNotContain, "10.0.0.0/8")
+			So(sources, ShouldNotContain, "0.0.0.0/0")
+		})
+	})
+}
+
+func TestDualStackSetTargetNetworks(t *testing.T) {
+
+	Convey("Given a dual-stack controller", t, func() {
+		fqc := fqconfig.NewFilterQueueWithDefaults()
+		d := &DualStackInstance{
+			ipv4: newInstance(fqc, constants.LocalContainer, provider.NewTestIptablesProvider(), false),
+			ipv6: newInstance(fqc, constants.LocalContainer, provider.NewTestIptablesProvider(), true),
+		}
+
+		entries := map[string][]string{}
+		families := map[string]string{}
+		ipsets := provider.NewTestIpsetProvider()
+		ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+			if name != targetNetworkSet && name != targetNetworkSetV6 {
+				return nil, fmt.Errorf("Wrong set")
+			}
+			families[name] = p.HashFamily
+			testset := provider.NewTestIpset()
+			testset.MockAdd(t, func(entry string, timeout int) error {
+				entries[name] = append(entries[name], entry)
+				return nil
+			})
+			return testset, nil
+		})
+		d.ipv4.ipset = ipsets
+		d.ipv6.ipset = ipsets
+
+		Convey("When I set networks of both families, each set should get its own networks", func() {
+			err := d.SetTargetNetworks([]string{}, []string{"10.0.0.0/8", "2001:db8::/32"})
+			So(err, ShouldBeNil)
+			So(entries[targetNetworkSet], ShouldResemble, []string{"10.0.0.0/8"})
+			So(entries[targetNetworkSetV6], ShouldResemble, []string{"2001:db8::/32"})
+			So(families[targetNetworkSetV6], ShouldEqual, "inet6")
+		})
+
+		Convey("When I set no networks, each set should capture all the traffic of its family", func() {
+			err := d.SetTargetNetworks([]string{}, []string{})
+			So(err, ShouldBeNil)
+			So(entries[targetNetworkSet], ShouldResemble, []string{"0.0.0.0/1", "128.0.0.0/1"})
+			So(entries[targetNetworkSetV6], ShouldResemble, []string{"::/1", "8000::/1"})
+		})
+	})
+}
+
+func TestDualStackHasIPv6(t *testing.T) {
+
+	Convey("Given a dual-stack controller without ip6tables", t, func() {
+		d := &DualStackInstance{
+			ipv4: newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, provider.NewTestIptablesProvider(), false),
+		}
+
+		Convey("I should never configure IPv6 rules", func() {
+			So(d.hasIPv6(policy.ExtendedMap{policy.DefaultIPv6Namespace: "2001:db8::1"}), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a dual-stack controller for containers", t, func() {
+		d := &DualStackInstance{
+			ipv4: newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, provider.NewTestIptablesProvider(), false),
+			ipv6: newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, provider.NewTestIptablesProvider(), true),
+		}
+
+		Convey("I should only configure IPv6 rules for PUs with an IPv6 address", func() {
+			So(d.hasIPv6(policy.ExtendedMap{policy.DefaultIPv6Namespace: "2001:db8::1"}), ShouldBeTrue)
+			So(d.hasIPv6(policy.ExtendedMap{policy.DefaultNamespace: "10.1.1.1"}), ShouldBeFalse)
+		})
+	})
+}
+
+func TestDualStackRollback(t *testing.T) {
+
+	Convey("Given a dual-stack controller whose IPv4 rules are programmed in memory", t, func() {
+		ipv4, m := newSimulatedInstance(constants.LocalContainer)
+		defer ipv4.Stop() // nolint
+
+		iptables6 := provider.NewTestIptablesProvider()
+		ipv6 := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, iptables6, true)
+		ipv6.ipset = provider.NewTestIpsetProvider()
+
+		d := &DualStackInstance{ipv4: ipv4, ipv6: ipv6}
+
+		puInfo := containerInfo()
+		puInfo.Policy.SetIPAddresses(policy.ExtendedMap{
+			policy.DefaultNamespace:     "172.17.0.2",
+			policy.DefaultIPv6Namespace: "2001:db8::2",
+		})
+
+		appChain, netChain, err := ipv4.chainName("pu1", 0)
+		So(err, ShouldBeNil)
+
+		hasChains := func(chains ...string) bool {
+			for _, table := range []string{"raw", "mangle"} {
+				names, err := m.ListChains(table)
+				So(err, ShouldBeNil)
+				for _, name := range names {
+					for _, chain := range chains {
+						if name == chain {
+							return true
+						}
+					}
+				}
+			}
+			return false
+		}
+
+		failIPv6 := func() {
+			iptables6.MockNewChain(t, func(table string, chain string) error {
+				return fmt.Errorf("ip6tables failed")
+			})
+			iptables6.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+				return fmt.Errorf("ip6tables failed")
+			})
+			iptables6.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+				return fmt.Errorf("ip6tables failed")
+			})
+		}
+
+		Convey("When the IPv6 rules can't be configured", func() {
+			failIPv6()
+			err := d.ConfigureRules(0, "pu1", puInfo)
+
+			Convey("Then I should get an error and the IPv4 chains should be deleted", func() {
+				So(err, ShouldNotBeNil)
+				So(hasChains(appChain, netChain), ShouldBeFalse)
+				_, err = ipv4.contextChains.Get("pu1")
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the IPv6 rules can't be updated", func() {
+			So(d.ConfigureRules(0, "pu1", puInfo), ShouldBeNil)
+			So(hasChains(appChain, netChain), ShouldBeTrue)
+
+			failIPv6()
+			puInfo.Policy.SetTriremeAction(policy.Audit)
+			err := d.UpdateRules(1, "pu1", puInfo)
+
+			Convey("Then I should get an error and the IPv4 chains should be deleted", func() {
+				So(err, ShouldNotBeNil)
+				appChain1, netChain1, _ := ipv4.chainName("pu1", 1)
+				So(hasChains(appChain, netChain, appChain1, netChain1), ShouldBeFalse)
+				_, err = ipv4.contextChains.Get("pu1")
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
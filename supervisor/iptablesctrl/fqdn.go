@@ -0,0 +1,203 @@
+package iptablesctrl
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+	"github.com/bvandewalle/go-ipset/ipset"
+	"go.uber.org/zap"
+)
+
+// fqdnSetPrefix is the prefix of the sets of the addresses of the DNS names
+const fqdnSetPrefix = "TRI-FQDN-"
+
+// fqdnSet is the set of the addresses of a DNS name in the IP family of the
+// instance. The ACLs on the name match the set.
+type fqdnSet struct {
+	name    string
+	set     provider.Ipset
+	entries map[string]bool
+}
+
+// fqdnSetName returns the name of the set of a DNS name. The names of the
+// sets are limited to 31 characters, so the DNS name is hashed.
+func (i *Instance) fqdnSetName(name string) string {
+
+	hash := md5.Sum([]byte(name))
+	setName := fqdnSetPrefix + base64.URLEncoding.EncodeToString(hash[:])[:12]
+
+	if i.ipv6 {
+		return setName + "-6"
+	}
+
+	return setName
+}
+
+// fqdnInstances counts the instances, so that each instance watches the names
+// of its processing units with its own owners on the shared watcher
+var fqdnInstances uint32
+
+// newFQDNOwnerPrefix returns the prefix of the owners of a new instance
+func newFQDNOwnerPrefix() string {
+
+	return "supervisor-" + strconv.Itoa(int(atomic.AddUint32(&fqdnInstances, 1))) + "/"
+}
+
+// watchFQDNs follows the addresses of the DNS names of the application ACLs of
+// a processing unit and creates the sets of the names that have none. It must
+// be called before the rules of the processing unit are programmed.
+func (i *Instance) watchFQDNs(contextID string, rules policy.IPRuleList) error {
+
+	names := rules.FQDNs()
+
+	i.fqdnWatcher.Watch(i.fqdnOwnerPrefix+contextID, names)
+
+	i.fqdnLock.Lock()
+	defer i.fqdnLock.Unlock()
+
+	for _, name := range names {
+
+		if _, ok := i.fqdnSets[name]; ok {
+			continue
+		}
+
+		params := &ipset.Params{}
+		if i.ipv6 {
+			params.HashFamily = "inet6"
+		}
+
+		setName := i.fqdnSetName(name)
+		ips, err := i.ipset.NewIpset(setName, "hash:ip", params)
+		if err != nil {
+			return fmt.Errorf("Couldn't create IPSet for %s: %s", name, err)
+		}
+
+		s := &fqdnSet{
+			name:    setName,
+			set:     ips,
+			entries: map[string]bool{},
+		}
+		s.update(i.familyAddresses(i.fqdnWatcher.Addresses(name)))
+
+		i.fqdnSets[name] = s
+	}
+
+	return nil
+}
+
+// updateFQDN updates the set of a DNS name when its addresses change
+func (i *Instance) updateFQDN(name string, ips []net.IP) {
+
+	i.fqdnLock.Lock()
+	defer i.fqdnLock.Unlock()
+
+	if s, ok := i.fqdnSets[name]; ok {
+		s.update(i.familyAddresses(ips))
+	}
+}
+
+// releaseFQDNSets destroys the sets of the DNS names that are no longer used.
+// The rules that match the sets must be deleted first.
+func (i *Instance) releaseFQDNSets() {
+
+	watched := map[string]bool{}
+	for _, name := range i.fqdnWatcher.Names(i.fqdnOwnerPrefix) {
+		watched[name] = true
+	}
+
+	i.fqdnLock.Lock()
+	defer i.fqdnLock.Unlock()
+
+	for name, s := range i.fqdnSets {
+		if watched[name] {
+			continue
+		}
+
+		if err := s.set.Destroy(); err != nil {
+			zap.L().Warn("Failed to destroy the set of a name", zap.String("name", name), zap.Error(err))
+			continue
+		}
+
+		delete(i.fqdnSets, name)
+	}
+}
+
+// fqdnMatch returns the match of the set of a DNS name
+func (i *Instance) fqdnMatch(name string) []string {
+
+	return []string{"-m", "set", "--match-set", i.fqdnSetName(name), "dst"}
+}
+
+// familyAddresses returns the addresses that belong to the IP family of the instance
+func (i *Instance) familyAddresses(ips []net.IP) []string {
+
+	addresses := []string{}
+	for _, ip := range ips {
+		if (ip.To4() == nil) == i.ipv6 {
+			addresses = append(addresses, ip.String())
+		}
+	}
+
+	return addresses
+}
+
+// update sets the addresses of the set. The addresses that are kept are not
+// removed, so that the connections to them are never rejected.
+func (s *fqdnSet) update(addresses []string) {
+
+	current := map[string]bool{}
+
+	wanted := map[string]bool{}
+
+	for _, address := range addresses {
+		wanted[address] = true
+		if s.entries[address] {
+			current[address] = true
+			continue
+		}
+
+		if err := s.set.Add(address, 0); err != nil {
+			zap.L().Warn("Failed to add address to set", zap.String("set", s.name), zap.String("address", address), zap.Error(err))
+			continue
+		}
+		current[address] = true
+	}
+
+	for address := range s.entries {
+		if wanted[address] {
+			continue
+		}
+
+		if err := s.set.Del(address); err != nil {
+			zap.L().Debug("Failed to remove address from set", zap.String("set", s.name), zap.String("address", address), zap.Error(err))
+		}
+	}
+
+	s.entries = current
+}
+
+// withDestination replaces the destination of a rule with the given match
+func withDestination(destination []string, rulespec []string) []string {
+
+	if len(destination) == 0 {
+		return rulespec
+	}
+
+	rule := make([]string, 0, len(rulespec)+len(destination))
+	for i := 0; i < len(rulespec); i++ {
+		if rulespec[i] == "-d" && i+1 < len(rulespec) {
+			rule = append(rule, destination...)
+			i++
+			continue
+		}
+		rule = append(rule, rulespec[i])
+	}
+
+	return rule
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/bvandewalle/go-ipset/ipset"
 	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/supervisor/provider"
 )
 
 // updateTargetNetworks updates the set of target networks. Tries to minimize
@@ -41,9 +43,14 @@ func (i *Instance) updateTargetNetworks(old, new []string) error {
 // createTargetSet creates a new target set
 func (i *Instance) createTargetSet(networks []string) error {
 
-	ips, err := i.ipset.NewIpset(targetNetworkSet, "hash:net", &ipset.Params{})
+	params := &ipset.Params{}
+	if i.ipv6 {
+		params.HashFamily = "inet6"
+	}
+
+	ips, err := i.ipset.NewIpset(i.targetSetName, "hash:net", params)
 	if err != nil {
-		return fmt.Errorf("Couldn't create IPSet for %s: %s", targetNetworkSet, err)
+		return fmt.Errorf("Couldn't create IPSet for %s: %s", i.targetSetName, err)
 	}
 
 	i.targetSet = ips
@@ -59,6 +66,12 @@ func (i *Instance) createTargetSet(networks []string) error {
 
 //Not using ipset from coreos library they don't support bitmap:port
 func (i *Instance) createPUPortSet(setname string) error {
+
+	if p, ok := i.ipset.(provider.PortSetProvider); ok {
+		_, err := p.NewPortSet(setname)
+		return err
+	}
+
 	//Bitmap type is not supported by the ipset library
 	//_, err := i.ipset.NewIpset(setname, "hash:port", &ipset.Params{})
 	path, _ := exec.LookPath("ipset")
@@ -69,3 +82,21 @@ func (i *Instance) createPUPortSet(setname string) error {
 	return err
 
 }
+
+// destroyPUPortSet destroys the port set of a processing unit
+func (i *Instance) destroyPUPortSet(setname string) error {
+
+	if p, ok := i.ipset.(provider.PortSetProvider); ok {
+		set, err := p.NewPortSet(setname)
+		if err != nil {
+			return err
+		}
+		return set.Destroy()
+	}
+
+	ips := ipset.IPSet{
+		Name: setname,
+	}
+
+	return ips.Destroy()
+}
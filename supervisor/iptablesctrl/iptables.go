@@ -5,14 +5,18 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
+	"strings"
+	"sync"
 
 	"go.uber.org/zap"
 
+	"github.com/aporeto-inc/trireme/cache"
 	"github.com/aporeto-inc/trireme/constants"
 	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqdn"
 	"github.com/aporeto-inc/trireme/policy"
-	"github.com/bvandewalle/go-ipset/ipset"
 
 	"github.com/aporeto-inc/trireme/supervisor/provider"
 )
@@ -23,6 +27,10 @@ const (
 	appChainPrefix   = chainPrefix + "App-"
 	netChainPrefix   = chainPrefix + "Net-"
 	targetNetworkSet = "TargetNetSet"
+	// targetNetworkSetV6 is the target network set of IPv6 networks
+	targetNetworkSetV6 = "TargetNetSet6"
+	ipv4AnyNetwork     = "0.0.0.0/0"
+	ipv6AnyNetwork     = "::/0"
 	//PuPortSet The prefix for portset names
 	PuPortSet                 = "PUPort-"
 	ipTableSectionOutput      = "OUTPUT"
@@ -45,6 +53,28 @@ type Instance struct {
 	appCgroupIPTableSection    string
 	appSynAckIPTableSection    string
 	mode                       constants.ModeType
+
+	// IP family specific parameters
+	ipv6          bool
+	ipNamespace   string
+	anyNetwork    string
+	targetSetName string
+
+	// The target networks of the IP family of the instance
+	targetNetworks []string
+
+	// The global rules as they are listed once they are programmed
+	globalListed map[chainID][]string
+
+	// The sets of the addresses of the DNS names of the application ACLs
+	fqdnWatcher     *fqdn.Watcher
+	fqdnOwnerPrefix string
+	fqdnUnsubscribe func()
+	fqdnSets        map[string]*fqdnSet
+	fqdnLock        *sync.Mutex
+
+	// The programmed chains of the processing units
+	contextChains cache.DataStore
 }
 
 // NewInstance creates a new iptables controller instance
@@ -55,19 +85,48 @@ func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType) (*Instance,
 		return nil, fmt.Errorf("Cannot initialize IPtables provider: %s", err)
 	}
 
-	ips := provider.NewGoIPsetProvider()
+	return newInstance(fqc, mode, ipt, false), nil
+}
+
+// NewIPv6Instance creates a new ip6tables controller instance that manages
+// the IPv6 rules of the processing units
+func NewIPv6Instance(fqc *fqconfig.FilterQueue, mode constants.ModeType) (*Instance, error) {
+
+	ipt, err := provider.NewGoIP6TablesProvider()
 	if err != nil {
-		return nil, fmt.Errorf("Cannot initialize ipsets")
+		return nil, fmt.Errorf("Cannot initialize IP6tables provider: %s", err)
 	}
 
+	return newInstance(fqc, mode, ipt, true), nil
+}
+
+// newInstance creates a controller instance for the given IP family
+func newInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, ipt provider.IptablesProvider, ipv6 bool) *Instance {
+
 	i := &Instance{
-		fqc:   fqc,
-		ipt:   ipt,
-		ipset: ips,
+		fqc:                        fqc,
+		ipt:                        ipt,
+		ipset:                      provider.NewGoIPsetProvider(),
 		appPacketIPTableContext:    "raw",
 		appAckPacketIPTableContext: "mangle",
 		netPacketIPTableContext:    "mangle",
-		mode: mode,
+		mode:                       mode,
+		fqdnWatcher:                fqdn.SharedWatcher(),
+		fqdnOwnerPrefix:            newFQDNOwnerPrefix(),
+		fqdnSets:                   map[string]*fqdnSet{},
+		fqdnLock:                   &sync.Mutex{},
+		contextChains:              cache.NewCache("PUChains"),
+	}
+
+	i.ipv6 = ipv6
+	if ipv6 {
+		i.ipNamespace = policy.DefaultIPv6Namespace
+		i.anyNetwork = ipv6AnyNetwork
+		i.targetSetName = targetNetworkSetV6
+	} else {
+		i.ipNamespace = policy.DefaultNamespace
+		i.anyNetwork = ipv4AnyNetwork
+		i.targetSetName = targetNetworkSet
 	}
 
 	if mode == constants.LocalServer || mode == constants.RemoteContainer {
@@ -82,8 +141,7 @@ func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType) (*Instance,
 		i.appSynAckIPTableSection = ipTableSectionInput
 	}
 
-	return i, nil
-
+	return i
 }
 
 // chainPrefix returns the chain name for the specific PU
@@ -128,15 +186,63 @@ func PuPortSetName(contextID string, mark string) (string, error) {
 // DefaultIPAddress returns the default IP address for the processing unit
 func (i *Instance) defaultIP(addresslist map[string]string) (string, bool) {
 
-	if ip, ok := addresslist[policy.DefaultNamespace]; ok && len(ip) > 0 {
+	if ip, ok := addresslist[i.ipNamespace]; ok && len(ip) > 0 {
 		return ip, true
 	}
 
 	if i.mode == constants.LocalContainer {
-		return "0.0.0.0/0", false
+		return i.anyNetwork, false
+	}
+
+	return i.anyNetwork, true
+}
+
+// matchesFamily returns true if the address or network belongs to the IP
+// family of the instance. Anything that is not an address is left to iptables.
+func (i *Instance) matchesFamily(address string) bool {
+
+	ip := net.ParseIP(strings.Split(address, "/")[0])
+	if ip == nil {
+		return !i.ipv6
+	}
+
+	return (ip.To4() == nil) == i.ipv6
+}
+
+// familyNetworks returns the networks that belong to the IP family of the instance
+func (i *Instance) familyNetworks(networks []string) []string {
+
+	list := []string{}
+	for _, network := range networks {
+		if i.matchesFamily(network) {
+			list = append(list, network)
+		}
+	}
+
+	return list
+}
+
+// transaction runs the operations on a copy of the instance that accumulates
+// the rules and commits them in a single restore. The rules that were
+// programmed are removed if the commit fails. If the provider can't restore
+// the rules, they are programmed one at a time.
+func (i *Instance) transaction(operations func(t *Instance) error) error {
+
+	restore, ok := i.ipt.(provider.IptablesRestore)
+	if !ok {
+		return operations(i)
 	}
 
-	return "0.0.0.0/0", true
+	batch := provider.NewBatchProvider(i.ipt, restore)
+
+	t := *i
+	t.ipt = batch
+
+	if err := operations(&t); err != nil {
+		return err
+	}
+
+	return batch.Commit()
 }
 
 // ConfigureRules implmenets the ConfigureRules interface
@@ -157,27 +263,47 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 		return fmt.Errorf("No ip address found ")
 	}
 
-	// Configure all the ACLs
-	if err := i.addContainerChain(appChain, netChain); err != nil {
+	if err := i.watchFQDNs(contextID, policyrules.ApplicationACLs()); err != nil {
 		return err
 	}
 
-	if i.mode != constants.LocalServer {
+	// Configure all the ACLs in a single transaction
+	if err := i.transaction(func(t *Instance) error {
 
-		if err := i.addChainRules("", appChain, netChain, ipAddress, "", "", ""); err != nil {
+		if err := t.addContainerChain(appChain, netChain); err != nil {
 			return err
 		}
 
-	} else {
-		mark := containerInfo.Runtime.Options().CgroupMark
-		if mark == "" {
-			return fmt.Errorf("No Mark value found")
-		}
+		if t.mode != constants.LocalServer {
+
+			if err := t.addChainRules("", appChain, netChain, ipAddress, "", "", ""); err != nil {
+				return err
+			}
+
+		} else {
+			mark := containerInfo.Runtime.Options().CgroupMark
+			if mark == "" {
+				return fmt.Errorf("No Mark value found")
+			}
 
-		port := policy.ConvertServicesToPortList(containerInfo.Runtime.Options().Services)
+			port := policy.ConvertServicesToPortList(containerInfo.Runtime.Options().Services)
 
-		uid := containerInfo.Runtime.Options().UserID
-		if uid != "" {
+			uid := containerInfo.Runtime.Options().UserID
+			if uid != "" && !t.ipv6 {
+
+				portSetName, err := PuPortSetName(contextID, mark)
+
+				if err != nil {
+					return err
+				}
+
+				//We are about to create a uid login pu
+				//This set will be empty and we will only fill it when we find a port for it
+				//The reason to use contextID here is to ensure that we don't need to talk between supervisor and enforcer to share names the id is derivable from information available in the enforcer
+				if puseterr := t.createPUPortSet(portSetName); puseterr != nil {
+					return puseterr
+				}
+			}
 
 			portSetName, err := PuPortSetName(contextID, mark)
 
@@ -185,45 +311,23 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 				return err
 			}
 
-			//We are about to create a uid login pu
-			//This set will be empty and we will only fill it when we find a port for it
-			//The reason to use contextID here is to ensure that we don't need to talk between supervisor and enforcer to share names the id is derivable from information available in the enforcer
-			if puseterr := i.createPUPortSet(portSetName); puseterr != nil {
-				return puseterr
+			if err = t.addChainRules(portSetName, appChain, netChain, ipAddress, port, mark, uid); err != nil {
+				return err
 			}
 		}
 
-		portSetName, err := PuPortSetName(contextID, mark)
-
-		if err != nil {
-			return err
-		}
-
-		if err = i.addChainRules(portSetName, appChain, netChain, ipAddress, port, mark, uid); err != nil {
-			return err
-		}
-	}
-
-	if err := i.addPacketTrap(appChain, netChain, ipAddress, containerInfo.Policy.TriremeNetworks()); err != nil {
+		return t.addChainACLs(contextID, appChain, netChain, ipAddress, policyrules)
+	}); err != nil {
 		return err
 	}
 
-	if err := i.addAppACLs(contextID, appChain, ipAddress, policyrules.ApplicationACLs()); err != nil {
-		return err
-	}
-
-	if err := i.addNetACLs(contextID, netChain, ipAddress, policyrules.NetworkACLs()); err != nil {
-		return err
-	}
-
-	if err := i.addExclusionACLs(appChain, netChain, ipAddress, policyrules.ExcludedNetworks()); err != nil {
-		return err
-	}
+	i.recordChains(version, contextID, ipAddress, containerInfo)
 
 	return nil
 }
 
-// DeleteRules implements the DeleteRules interface
+// DeleteRules implements the DeleteRules interface. The chains of the version
+// that is programmed are deleted.
 func (i *Instance) DeleteRules(version int, contextID string, ipAddresses policy.ExtendedMap, port string, mark string, uid string) error {
 	var ipAddress string
 	var ok bool
@@ -240,6 +344,10 @@ func (i *Instance) DeleteRules(version int, contextID string, ipAddresses policy
 		}
 	}
 
+	if current, err := i.contextChains.Get(contextID); err == nil {
+		version = current.(*puChains).version
+	}
+
 	appChain, netChain, err := i.chainName(contextID, version)
 
 	if err != nil {
@@ -259,7 +367,14 @@ func (i *Instance) DeleteRules(version int, contextID string, ipAddresses policy
 	if err := i.deleteAllContainerChains(appChain, netChain); err != nil {
 		zap.L().Warn("Failed to clean container chains while deleting the rules", zap.Error(err))
 	}
-	if uid != "" {
+
+	i.contextChains.Remove(contextID) // nolint
+
+	i.fqdnWatcher.Unwatch(i.fqdnOwnerPrefix + contextID)
+	i.releaseFQDNSets()
+
+	// The port set is shared by both IP families and managed by the IPv4 instance
+	if uid != "" && !i.ipv6 {
 
 		portSetName, err := PuPortSetName(contextID, mark)
 
@@ -267,17 +382,17 @@ func (i *Instance) DeleteRules(version int, contextID string, ipAddresses policy
 			return err
 		}
 
-		ips := ipset.IPSet{
-			Name: portSetName,
-		}
-		if err := ips.Destroy(); err != nil {
+		if err := i.destroyPUPortSet(portSetName); err != nil {
 			zap.L().Warn("Failed to clear puport set", zap.Error(err))
 		}
 	}
 	return nil
 }
 
-// UpdateRules implements the update part of the interface
+// UpdateRules implements the update part of the interface. When the rules that
+// send traffic to the chains of the processing unit don't change, only the
+// rules of the chains that change are updated. Otherwise the chains are
+// swapped with the chains of the other version.
 func (i *Instance) UpdateRules(version int, contextID string, containerInfo *policy.PUInfo) error {
 
 	if containerInfo == nil {
@@ -295,54 +410,61 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 		return fmt.Errorf("No ip address found ")
 	}
 
-	appChain, netChain, err := i.chainName(contextID, version)
+	mark, port, uid := i.puOptions(containerInfo)
 
-	if err != nil {
+	if err := i.watchFQDNs(contextID, policyrules.ApplicationACLs()); err != nil {
 		return err
 	}
 
-	oldAppChain, oldNetChain, err := i.chainName(contextID, version^1)
+	oldVersion := version ^ 1
+	oldIPAddress, oldMark, oldPort, oldUID := ipAddress, mark, port, uid
 
-	if err != nil {
-		return err
-	}
+	if item, err := i.contextChains.Get(contextID); err == nil {
+		current := item.(*puChains)
 
-	//Add a new chain for this update and map all rules there
-	if err := i.addContainerChain(appChain, netChain); err != nil {
-		return err
-	}
+		if current.ipAddress == ipAddress && current.mark == mark && current.port == port && current.uid == uid {
+			if i.updateChains(contextID, current, policyrules) {
+				i.releaseFQDNSets()
+				return nil
+			}
+		}
 
-	if err := i.addPacketTrap(appChain, netChain, ipAddress, containerInfo.Policy.TriremeNetworks()); err != nil {
-		return err
+		oldVersion = current.version
+		version = current.version ^ 1
+		oldIPAddress, oldMark, oldPort, oldUID = current.ipAddress, current.mark, current.port, current.uid
 	}
 
-	if err := i.addAppACLs(contextID, appChain, ipAddress, policyrules.ApplicationACLs()); err != nil {
-		return err
-	}
+	appChain, netChain, err := i.chainName(contextID, version)
 
-	if err := i.addNetACLs(contextID, netChain, ipAddress, policyrules.NetworkACLs()); err != nil {
+	if err != nil {
 		return err
 	}
 
-	if err := i.addExclusionACLs(appChain, netChain, ipAddress, policyrules.ExcludedNetworks()); err != nil {
+	oldAppChain, oldNetChain, err := i.chainName(contextID, oldVersion)
+
+	if err != nil {
 		return err
 	}
 
-	// Add mapping to new chain
-	if i.mode != constants.LocalServer {
+	//Add a new chain for this update and map all rules there in a single transaction
+	if err := i.transaction(func(t *Instance) error {
 
-		if err := i.addChainRules("", appChain, netChain, ipAddress, "", "", ""); err != nil {
+		if err := t.addContainerChain(appChain, netChain); err != nil {
 			return err
 		}
-	} else {
-		mark := containerInfo.Runtime.Options().CgroupMark
-		if mark == "" {
-			return fmt.Errorf("No Mark value found")
+
+		if err := t.addChainACLs(contextID, appChain, netChain, ipAddress, policyrules); err != nil {
+			return err
 		}
 
-		portlist := policy.ConvertServicesToPortList(containerInfo.Runtime.Options().Services)
+		// Add mapping to new chain
+		if t.mode != constants.LocalServer {
+			return t.addChainRules("", appChain, netChain, ipAddress, "", "", "")
+		}
 
-		uid := containerInfo.Runtime.Options().UserID
+		if mark == "" {
+			return fmt.Errorf("No Mark value found")
+		}
 
 		portSetName, err := PuPortSetName(contextID, mark)
 
@@ -350,28 +472,24 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 			return err
 		}
 
-		if err := i.addChainRules(portSetName, appChain, netChain, ipAddress, portlist, mark, uid); err != nil {
-			return err
-		}
+		return t.addChainRules(portSetName, appChain, netChain, ipAddress, port, mark, uid)
+	}); err != nil {
+		return err
 	}
 
 	//Remove mapping from old chain
 	if i.mode != constants.LocalServer {
-		if err := i.deleteChainRules("", oldAppChain, oldNetChain, ipAddress, "", "", ""); err != nil {
+		if err := i.deleteChainRules("", oldAppChain, oldNetChain, oldIPAddress, "", "", ""); err != nil {
 			return err
 		}
 	} else {
-		mark := containerInfo.Runtime.Options().CgroupMark
-		port := policy.ConvertServicesToPortList(containerInfo.Runtime.Options().Services)
-		uid := containerInfo.Runtime.Options().UserID
-
-		portSetName, err := PuPortSetName(contextID, mark)
+		portSetName, err := PuPortSetName(contextID, oldMark)
 
 		if err != nil {
 			return err
 		}
 
-		if err := i.deleteChainRules(portSetName, oldAppChain, oldNetChain, ipAddress, port, mark, uid); err != nil {
+		if err := i.deleteChainRules(portSetName, oldAppChain, oldNetChain, oldIPAddress, oldPort, oldMark, oldUID); err != nil {
 			return err
 		}
 	}
@@ -381,6 +499,10 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 		return err
 	}
 
+	i.releaseFQDNSets()
+
+	i.recordChains(version, contextID, ipAddress, containerInfo)
+
 	return nil
 }
 
@@ -398,6 +520,11 @@ func (i *Instance) Start() error {
 		}
 	}
 
+	if i.fqdnUnsubscribe == nil {
+		i.fqdnUnsubscribe = i.fqdnWatcher.Subscribe(i.updateFQDN)
+		i.fqdnWatcher.Start()
+	}
+
 	zap.L().Debug("Started the iptables controller")
 
 	return nil
@@ -407,12 +534,26 @@ func (i *Instance) Start() error {
 func (i *Instance) SetTargetNetworks(current, networks []string) error {
 
 	if len(networks) == 0 {
-		networks = []string{"0.0.0.0/1", "128.0.0.0/1"}
+		if i.ipv6 {
+			networks = []string{"::/1", "8000::/1"}
+		} else {
+			networks = []string{"0.0.0.0/1", "128.0.0.0/1"}
+		}
 	}
 
+	// Each instance only manages the networks of its own IP family
+	current = i.familyNetworks(current)
+	networks = i.familyNetworks(networks)
+
 	// Cleanup old ACLs
 	if len(current) > 0 {
-		return i.updateTargetNetworks(current, networks)
+		if err := i.updateTargetNetworks(current, networks); err != nil {
+			return err
+		}
+
+		i.targetNetworks = networks
+
+		return nil
 	}
 
 	// Create the target network set
@@ -426,6 +567,9 @@ func (i *Instance) SetTargetNetworks(current, networks []string) error {
 	if err := i.setGlobalRules(i.appPacketIPTableSection, i.netPacketIPTableSection); err != nil {
 		return fmt.Errorf("Failed to update synack networks")
 	}
+	i.globalListed = i.listGlobalChains()
+
+	i.targetNetworks = networks
 
 	return nil
 }
@@ -435,6 +579,12 @@ func (i *Instance) Stop() error {
 
 	zap.L().Debug("Stop the supervisor")
 
+	if i.fqdnUnsubscribe != nil {
+		i.fqdnUnsubscribe()
+		i.fqdnUnsubscribe = nil
+		i.fqdnWatcher.Stop()
+	}
+
 	// Clean any previous ACLs that we have installed
 	if err := i.cleanACLs(); err != nil {
 		zap.L().Error("Failed to clean acls while stopping the supervisor", zap.Error(err))
@@ -444,5 +594,11 @@ func (i *Instance) Stop() error {
 		zap.L().Error("Failed to clean up ipsets", zap.Error(err))
 	}
 
+	i.fqdnLock.Lock()
+	i.fqdnSets = map[string]*fqdnSet{}
+	i.fqdnLock.Unlock()
+
+	i.contextChains = cache.NewCache("PUChains")
+
 	return nil
 }
@@ -405,6 +405,180 @@ func TestUpdateRules(t *testing.T) {
 	})
 }
 
+func TestIncrementalUpdateRules(t *testing.T) {
+	Convey("Given an iptables controller with configured rules", t, func() {
+		i, m := newSimulatedInstance(constants.LocalContainer)
+		defer i.Stop() // nolint
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject},
+			},
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "443",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+		}
+
+		containerInfo := func(ip string, appACLs policy.IPRuleList) *policy.PUInfo {
+			policyrules := policy.NewPUPolicy("Context",
+				policy.Police,
+				appACLs,
+				rules,
+				nil,
+				nil,
+				nil,
+				nil, policy.ExtendedMap{policy.DefaultNamespace: ip}, []string{"172.17.0.0/24"}, []string{})
+
+			containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			containerinfo.Policy = policyrules
+			containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+			return containerinfo
+		}
+
+		So(i.ConfigureRules(0, "Context", containerInfo("172.17.0.1", rules)), ShouldBeNil)
+
+		app0, net0, _ := i.chainName("Context", 0)
+		app1, net1, _ := i.chainName("Context", 1)
+
+		assertChains := func(appACLs policy.IPRuleList) {
+			expected, err := i.renderChains("Context", app0, net0, "172.17.0.1", containerInfo("172.17.0.1", appACLs).Policy)
+			So(err, ShouldBeNil)
+
+			for _, id := range []chainID{{"mangle", app0}, {"mangle", net0}} {
+				chain, err := m.Rules(id.table, id.chain)
+				So(err, ShouldBeNil)
+				So(chain, ShouldResemble, expected.chains[id])
+			}
+
+			chains, _ := m.ListChains("mangle")
+			So(chains, ShouldNotContain, app1)
+			So(chains, ShouldNotContain, net1)
+		}
+
+		Convey("When I update the ACLs of the processing unit", func() {
+			updated := append(policy.IPRuleList{
+				policy.IPRule{
+					Address:  "10.1.0.0/16",
+					Port:     "8080",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept | policy.Log, PolicyID: "log"},
+				},
+			}, rules[1])
+
+			So(i.UpdateRules(1, "Context", containerInfo("172.17.0.1", updated)), ShouldBeNil)
+
+			Convey("Then the chains should be updated in place", func() {
+				assertChains(updated)
+			})
+
+			Convey("Then the chains of the programmed version should be deleted", func() {
+				So(i.DeleteRules(1, "Context", policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1"}, "0", "0", ""), ShouldBeNil)
+
+				chains, _ := m.ListChains("mangle")
+				So(chains, ShouldNotContain, app0)
+				So(chains, ShouldNotContain, net0)
+			})
+		})
+
+		Convey("When I reorder the ACLs of the processing unit", func() {
+			reordered := policy.IPRuleList{rules[1], rules[0]}
+
+			So(i.UpdateRules(1, "Context", containerInfo("172.17.0.1", reordered)), ShouldBeNil)
+
+			Convey("Then the moved rules should be in their new position in place", func() {
+				assertChains(reordered)
+			})
+		})
+
+		Convey("When I update the IP address of the processing unit", func() {
+			So(i.UpdateRules(1, "Context", containerInfo("172.17.0.2", rules)), ShouldBeNil)
+
+			Convey("Then the chains should be swapped", func() {
+				chains, _ := m.ListChains("mangle")
+				So(chains, ShouldContain, app1)
+				So(chains, ShouldContain, net1)
+				So(chains, ShouldNotContain, app0)
+				So(chains, ShouldNotContain, net0)
+
+				postrouting, err := m.Rules("mangle", ipTableSectionPostRouting)
+				So(err, ShouldBeNil)
+				So(postrouting, ShouldContain, []string{"-d", "172.17.0.2", "-m", "comment", "--comment", "Container-specific-chain", "-j", net1})
+			})
+
+			Convey("Then the next update should swap back to the first version", func() {
+				So(i.UpdateRules(0, "Context", containerInfo("172.17.0.3", rules)), ShouldBeNil)
+
+				chains, _ := m.ListChains("mangle")
+				So(chains, ShouldContain, app0)
+				So(chains, ShouldNotContain, app1)
+			})
+		})
+	})
+}
+
+func TestDiffChain(t *testing.T) {
+	Convey("Given the rules of a chain", t, func() {
+		id := chainID{"mangle", "chain"}
+
+		current := [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"a"}}
+
+		test := func(target [][]string) int {
+			updates, ok := diffChain(id, current, target)
+			So(ok, ShouldBeTrue)
+
+			recorder := newRuleRecorder()
+			So(recorder.NewChain(id.table, id.chain), ShouldBeNil)
+			for _, rule := range current {
+				So(recorder.Append(id.table, id.chain, rule...), ShouldBeNil)
+			}
+
+			for _, update := range updates {
+				So(update.apply(recorder), ShouldBeNil)
+			}
+
+			So(recorder.chains[id], ShouldResemble, target)
+
+			return len(updates)
+		}
+
+		Convey("When the rules don't change, there should be no update", func() {
+			So(test([][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"a"}}), ShouldEqual, 0)
+		})
+
+		Convey("When rules are added and removed, only they should be updated", func() {
+			So(test([][]string{{"e"}, {"a"}, {"c"}, {"f"}, {"d"}, {"a"}}), ShouldEqual, 3)
+		})
+
+		Convey("When rules are moved, they should be inserted again", func() {
+			So(test([][]string{{"d"}, {"a"}, {"b"}, {"c"}, {"a"}}), ShouldEqual, 2)
+		})
+
+		Convey("When a rule is moved, it should be inserted before its old copy is deleted", func() {
+			updates, ok := diffChain(id, current, [][]string{{"d"}, {"a"}, {"b"}, {"c"}, {"a"}})
+			So(ok, ShouldBeTrue)
+			So(len(updates), ShouldEqual, 2)
+			So(updates[0].pos, ShouldEqual, 1)
+			So(updates[1].pos, ShouldEqual, 0)
+			So(updates[1].num, ShouldEqual, 5)
+		})
+
+		Convey("When duplicate rules are moved, they should be inserted again", func() {
+			So(test([][]string{{"b"}, {"c"}, {"d"}, {"a"}, {"a"}}), ShouldEqual, 2)
+		})
+
+		Convey("When all the rules are removed, they should all be deleted", func() {
+			So(test([][]string{}), ShouldEqual, 5)
+		})
+	})
+}
+
 func TestStart(t *testing.T) {
 	Convey("Given an iptables controllers,", t, func() {
 		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer)
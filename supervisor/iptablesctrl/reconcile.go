@@ -0,0 +1,285 @@
+package iptablesctrl
+
+import (
+	"fmt"
+	"reflect"
+
+	"go.uber.org/zap"
+)
+
+// ReconcileRules repairs the chains of a processing unit that no longer have
+// the rules that were programmed, and the rules that redirect the traffic of
+// the processing unit to its chains. It returns the number of drifts that were
+// repaired.
+func (i *Instance) ReconcileRules(contextID string) (int, error) {
+
+	item, err := i.contextChains.Get(contextID)
+	if err != nil {
+		// The instance does not program the rules of the processing unit
+		return 0, nil
+	}
+
+	current := item.(*puChains)
+
+	appChain, netChain, err := i.chainName(contextID, current.version)
+	if err != nil {
+		return 0, err
+	}
+
+	portSetName, err := PuPortSetName(contextID, current.mark)
+	if err != nil {
+		return 0, err
+	}
+
+	drifts := 0
+
+	// The chains are repaired first since the redirection rules jump to them
+	for _, id := range current.rules.chainIDs() {
+		list, err := i.ipt.List(id.table, id.chain)
+		if err == nil && reflect.DeepEqual(list, current.listed[id]) {
+			continue
+		}
+
+		zap.L().Warn("The rules of the chain of the processing unit drifted",
+			zap.String("contextID", contextID),
+			zap.String("table", id.table),
+			zap.String("chain", id.chain),
+		)
+
+		drifts++
+
+		if err := i.repairChain(id, current.rules.chains[id], err != nil); err != nil {
+			return drifts, err
+		}
+	}
+
+	for _, rule := range i.redirectRules(portSetName, appChain, netChain, current.ipAddress, current.port, current.mark, current.uid) {
+		exists, err := i.ipt.Exists(rule[0], rule[1], rule[2:]...)
+		if err != nil {
+			return drifts, fmt.Errorf("Failed to check rule of table %s and chain %s: %s", rule[0], rule[1], err)
+		}
+
+		if exists {
+			continue
+		}
+
+		zap.L().Warn("A rule that redirects the traffic of the processing unit drifted",
+			zap.String("contextID", contextID),
+			zap.String("table", rule[0]),
+			zap.String("chain", rule[1]),
+		)
+
+		drifts++
+
+		if err := i.ipt.Append(rule[0], rule[1], rule[2:]...); err != nil {
+			return drifts, fmt.Errorf("Failed to repair rule of table %s and chain %s: %s", rule[0], rule[1], err)
+		}
+	}
+
+	if drifts > 0 {
+		current.listed = i.listChains(current.rules)
+	}
+
+	return drifts, nil
+}
+
+// repairChain programs the rules of a chain again. The chain is created if it
+// is missing.
+func (i *Instance) repairChain(id chainID, rules [][]string, missing bool) error {
+
+	return i.transaction(func(t *Instance) error {
+
+		if missing {
+			if err := t.ipt.NewChain(id.table, id.chain); err != nil {
+				return fmt.Errorf("Failed to create chain %s of table %s: %s", id.chain, id.table, err)
+			}
+		} else {
+			if err := t.ipt.ClearChain(id.table, id.chain); err != nil {
+				return fmt.Errorf("Failed to clear chain %s of table %s: %s", id.chain, id.table, err)
+			}
+		}
+
+		for _, rule := range rules {
+			if err := t.ipt.Append(id.table, id.chain, rule...); err != nil {
+				return fmt.Errorf("Failed to repair chain %s of table %s: %s", id.chain, id.table, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// ReconcileGlobalRules repairs the target networks of the target set and the
+// global rules that are shared by the processing units. The global rules of a
+// chain are inserted again in their order when one of them is missing or
+// moved. It returns the number of drifts that were repaired.
+func (i *Instance) ReconcileGlobalRules() (int, error) {
+
+	if i.targetSet == nil {
+		return 0, nil
+	}
+
+	drifts := 0
+
+	for _, network := range i.targetNetworks {
+		found, err := i.targetSet.Test(network)
+		if err != nil {
+			zap.L().Warn("The target set drifted", zap.String("set", i.targetSetName), zap.Error(err))
+			drifts++
+
+			if err := i.createTargetSet(i.targetNetworks); err != nil {
+				return drifts, err
+			}
+			break
+		}
+
+		if found {
+			continue
+		}
+
+		zap.L().Warn("A target network drifted", zap.String("set", i.targetSetName), zap.String("network", network))
+		drifts++
+
+		if err := i.targetSet.Add(network, 0); err != nil {
+			return drifts, fmt.Errorf("Failed to repair target network %s: %s", network, err)
+		}
+	}
+
+	tableChains, err := i.ipt.ListChains(i.appAckPacketIPTableContext)
+	if err != nil {
+		return drifts, err
+	}
+
+	if !contains(tableChains, uidchain) {
+		zap.L().Warn("The chain of the users drifted", zap.String("chain", uidchain))
+		drifts++
+
+		if err := i.ipt.NewChain(i.appAckPacketIPTableContext, uidchain); err != nil {
+			return drifts, fmt.Errorf("Failed to repair chain %s: %s", uidchain, err)
+		}
+	}
+
+	chains, rules := i.globalChains()
+
+	for _, id := range chains {
+		if !i.globalChainDrifted(id, rules[id]) {
+			continue
+		}
+
+		zap.L().Warn("The global rules of the chain drifted", zap.String("table", id.table), zap.String("chain", id.chain))
+		drifts++
+
+		if err := i.repairGlobalChain(id, rules[id]); err != nil {
+			return drifts, err
+		}
+	}
+
+	if drifts > 0 {
+		i.globalListed = i.listGlobalChains()
+	}
+
+	return drifts, nil
+}
+
+// globalChains returns the chains of the global rules and their rules, in the
+// order they are inserted
+func (i *Instance) globalChains() ([]chainID, map[chainID][][]string) {
+
+	chains := []chainID{}
+	rules := map[chainID][][]string{}
+
+	for _, rule := range i.globalRules(i.appPacketIPTableSection, i.netPacketIPTableSection) {
+		id := chainID{table: rule[0], chain: rule[1]}
+		if _, ok := rules[id]; !ok {
+			chains = append(chains, id)
+		}
+		rules[id] = append(rules[id], rule[2:])
+	}
+
+	return chains, rules
+}
+
+// listGlobalChains returns the first rules of the chains of the global rules
+// as iptables lists them, which are the global rules since they are inserted
+// at the top of the chains. The chains that can't be listed are left out.
+func (i *Instance) listGlobalChains() map[chainID][]string {
+
+	listed := map[chainID][]string{}
+
+	chains, rules := i.globalChains()
+	for _, id := range chains {
+		list, err := i.ipt.List(id.table, id.chain)
+		if err != nil {
+			zap.L().Debug("Failed to list the rules of the chain", zap.String("chain", id.chain), zap.Error(err))
+			continue
+		}
+
+		// The policy of the chain is listed before the rules
+		if n := len(rules[id]) + 1; len(list) >= n {
+			listed[id] = list[:n]
+		}
+	}
+
+	return listed
+}
+
+// globalChainDrifted returns true if the global rules of a chain are no longer
+// the first rules of the chain in their order. The rules are only checked one
+// by one when the chain could not be listed once they were programmed.
+func (i *Instance) globalChainDrifted(id chainID, rules [][]string) bool {
+
+	if listed, ok := i.globalListed[id]; ok {
+		list, err := i.ipt.List(id.table, id.chain)
+		return err != nil || len(list) < len(listed) || !reflect.DeepEqual(list[:len(listed)], listed)
+	}
+
+	for _, rule := range rules {
+		if exists, err := i.ipt.Exists(id.table, id.chain, rule...); err != nil || !exists {
+			return true
+		}
+	}
+
+	return false
+}
+
+// repairGlobalChain removes the global rules of a chain wherever they are and
+// inserts them again at the top of the chain in their order
+func (i *Instance) repairGlobalChain(id chainID, rules [][]string) error {
+
+	for _, rule := range rules {
+		for {
+			exists, err := i.ipt.Exists(id.table, id.chain, rule...)
+			if err != nil {
+				return fmt.Errorf("Failed to check rule of table %s and chain %s: %s", id.table, id.chain, err)
+			}
+
+			if !exists {
+				break
+			}
+
+			if err := i.ipt.Delete(id.table, id.chain, rule...); err != nil {
+				return fmt.Errorf("Failed to remove rule of table %s and chain %s: %s", id.table, id.chain, err)
+			}
+		}
+	}
+
+	for _, rule := range rules {
+		if err := i.ipt.Insert(id.table, id.chain, 1, rule...); err != nil {
+			return fmt.Errorf("Failed to repair rule of table %s and chain %s: %s", id.table, id.chain, err)
+		}
+	}
+
+	return nil
+}
+
+// contains returns true if the list contains the value
+func contains(list []string, value string) bool {
+
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
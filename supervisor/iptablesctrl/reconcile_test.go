@@ -0,0 +1,142 @@
+package iptablesctrl
+
+import (
+	"net"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+	"github.com/bvandewalle/go-ipset/ipset"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReconcileRules(t *testing.T) {
+
+	Convey("Given an iptables controller with the rules of a container in memory", t, func() {
+		i, m := newSimulatedInstance(constants.LocalContainer)
+		defer i.Stop() // nolint
+
+		So(i.ConfigureRules(0, "pu1", containerInfo()), ShouldBeNil)
+
+		appChain, netChain, err := i.chainName("pu1", 0)
+		So(err, ShouldBeNil)
+
+		before := m.Save()
+
+		Convey("When nothing changed, there should be no drift", func() {
+			drifts, err := i.ReconcileRules("pu1")
+			So(err, ShouldBeNil)
+			So(drifts, ShouldEqual, 0)
+
+			drifts, err = i.ReconcileGlobalRules()
+			So(err, ShouldBeNil)
+			So(drifts, ShouldEqual, 0)
+		})
+
+		Convey("When the chains of the container are flushed, they should be repaired", func() {
+			So(m.ClearChain("raw", appChain), ShouldBeNil)
+			So(m.ClearChain("mangle", netChain), ShouldBeNil)
+
+			drifts, err := i.ReconcileRules("pu1")
+			So(err, ShouldBeNil)
+			So(drifts, ShouldEqual, 2)
+			So(m.Save(), ShouldEqual, before)
+
+			drifts, err = i.ReconcileRules("pu1")
+			So(err, ShouldBeNil)
+			So(drifts, ShouldEqual, 0)
+		})
+
+		Convey("When the rules of a chain are reordered, the chain should be repaired", func() {
+			rules, err := m.Rules("mangle", appChain)
+			So(err, ShouldBeNil)
+			So(len(rules), ShouldBeGreaterThan, 1)
+
+			So(m.Delete("mangle", appChain, rules[0]...), ShouldBeNil)
+			So(m.Append("mangle", appChain, rules[0]...), ShouldBeNil)
+
+			drifts, err := i.ReconcileRules("pu1")
+			So(err, ShouldBeNil)
+			So(drifts, ShouldEqual, 1)
+			So(m.Save(), ShouldEqual, before)
+		})
+
+		Convey("When the chains and the rules that jump to them are deleted, they should be created again", func() {
+			for _, rule := range i.chainRules(appChain, netChain, "172.17.0.2") {
+				So(m.Delete(rule[0], rule[1], rule[2:]...), ShouldBeNil)
+			}
+			So(m.ClearChain("mangle", netChain), ShouldBeNil)
+			So(m.DeleteChain("mangle", netChain), ShouldBeNil)
+
+			drifts, err := i.ReconcileRules("pu1")
+			So(err, ShouldBeNil)
+			So(drifts, ShouldEqual, 1+len(i.chainRules(appChain, netChain, "172.17.0.2")))
+
+			v, err := m.EvaluateHook("POSTROUTING", &provider.Packet{
+				Protocol: "tcp", Source: net.ParseIP("10.3.1.1"), Destination: net.ParseIP("172.17.0.2"), DestinationPort: 22,
+				TCPFlags: []string{"SYN"},
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "NFQUEUE")
+			So(v.Chain, ShouldEqual, netChain)
+		})
+
+		Convey("When the target networks and the global rules are removed, they should be repaired", func() {
+			set, err := m.NewIpset(targetNetworkSet, "hash:net", &ipset.Params{})
+			So(err, ShouldBeNil)
+			So(set.Del("0.0.0.0/1"), ShouldBeNil)
+
+			rule := i.globalRules(i.appPacketIPTableSection, i.netPacketIPTableSection)[5]
+			So(m.Delete(rule[0], rule[1], rule[2:]...), ShouldBeNil)
+
+			drifts, err := i.ReconcileGlobalRules()
+			So(err, ShouldBeNil)
+			So(drifts, ShouldEqual, 2)
+
+			exists, err := m.Exists(rule[0], rule[1], rule[2:]...)
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeTrue)
+
+			found, err := set.Test("0.0.0.0/1")
+			So(err, ShouldBeNil)
+			So(found, ShouldBeTrue)
+		})
+
+		Convey("When the global rules are reordered or one of two identical rules is removed, they should be inserted again in their order", func() {
+			rules, err := m.Rules("mangle", i.appPacketIPTableSection)
+			So(err, ShouldBeNil)
+
+			global := i.globalRules(i.appPacketIPTableSection, i.netPacketIPTableSection)
+			So(m.Delete("mangle", i.appPacketIPTableSection, global[2][2:]...), ShouldBeNil)
+			So(m.Insert("mangle", i.appPacketIPTableSection, 4, global[2][2:]...), ShouldBeNil)
+
+			drifts, err := i.ReconcileGlobalRules()
+			So(err, ShouldBeNil)
+			So(drifts, ShouldEqual, 1)
+
+			repaired, err := m.Rules("mangle", i.appPacketIPTableSection)
+			So(err, ShouldBeNil)
+			So(repaired, ShouldResemble, rules)
+
+			So(m.Delete("mangle", i.appPacketIPTableSection, global[0][2:]...), ShouldBeNil)
+
+			drifts, err = i.ReconcileGlobalRules()
+			So(err, ShouldBeNil)
+			So(drifts, ShouldEqual, 1)
+
+			repaired, err = m.Rules("mangle", i.appPacketIPTableSection)
+			So(err, ShouldBeNil)
+			So(repaired, ShouldResemble, rules)
+
+			drifts, err = i.ReconcileGlobalRules()
+			So(err, ShouldBeNil)
+			So(drifts, ShouldEqual, 0)
+		})
+
+		Convey("The processing units that are not programmed should not drift", func() {
+			drifts, err := i.ReconcileRules("unknown")
+			So(err, ShouldBeNil)
+			So(drifts, ShouldEqual, 0)
+		})
+	})
+}
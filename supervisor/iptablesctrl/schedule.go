@@ -0,0 +1,156 @@
+package iptablesctrl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+)
+
+// iptablesDate is the format of the dates of the time match
+const iptablesDate = "2006-01-02T15:04:05"
+
+// scheduledRules adds the time matches of the schedule of an ACL to the rules
+// of the ACL. A rule is added for each window of the schedule, so that the
+// kernel activates and expires the ACL without updating the chains. The
+// destination of the rules of an ACL on a DNS name is replaced by a match on
+// the set of the addresses of the name, and the protocol of the rules of an
+// ICMP ACL is followed by the match of its type and code.
+type scheduledRules struct {
+	ipt         provider.IptablesProvider
+	matches     [][]string
+	destination []string
+	protocol    []string
+}
+
+// scheduledRules returns the provider of the rules of an ACL with the given
+// schedule
+func (i *Instance) scheduledRules(schedule *policy.Schedule) *scheduledRules {
+
+	return &scheduledRules{
+		ipt:     i.ipt,
+		matches: timeMatches(schedule),
+	}
+}
+
+// Append appends a rule for each time match
+func (s *scheduledRules) Append(table, chain string, rulespec ...string) error {
+
+	for _, match := range s.matches {
+		if err := s.ipt.Append(table, chain, withMatch(match, withDestination(s.destination, withProtocol(s.protocol, rulespec)))...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Insert inserts a rule for each time match
+func (s *scheduledRules) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	for _, match := range s.matches {
+		if err := s.ipt.Insert(table, chain, pos, withMatch(match, withDestination(s.destination, withProtocol(s.protocol, rulespec)))...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// timeMatches returns the time matches of a schedule, one for each window.
+// The times are in UTC like the schedules. A nil schedule has a single empty
+// match.
+func timeMatches(schedule *policy.Schedule) [][]string {
+
+	if schedule == nil {
+		return [][]string{nil}
+	}
+
+	dates := []string{}
+	if !schedule.NotBefore.IsZero() {
+		dates = append(dates, "--datestart", schedule.NotBefore.UTC().Format(iptablesDate))
+	}
+	if !schedule.NotAfter.IsZero() {
+		dates = append(dates, "--datestop", schedule.NotAfter.Add(-time.Second).UTC().Format(iptablesDate))
+	}
+
+	if len(schedule.Windows) == 0 {
+		if len(dates) == 0 {
+			return [][]string{nil}
+		}
+		return [][]string{append([]string{"-m", "time"}, dates...)}
+	}
+
+	matches := make([][]string, 0, len(schedule.Windows))
+
+	for _, w := range schedule.Windows {
+		match := append([]string{"-m", "time"}, dates...)
+		match = append(match, "--timestart", clock(w.Start), "--timestop", clock(w.End-time.Second))
+
+		if w.End <= w.Start {
+			match = append(match, "--contiguous")
+		}
+
+		if len(w.Weekdays) > 0 {
+			days := make([]string, len(w.Weekdays))
+			for i, d := range w.Weekdays {
+				days[i] = d.String()[:3]
+			}
+			match = append(match, "--weekdays", strings.Join(days, ","))
+		}
+
+		matches = append(matches, match)
+	}
+
+	return matches
+}
+
+// clock formats an offset from midnight as a time of the day
+func clock(offset time.Duration) string {
+
+	day := 24 * time.Hour
+	seconds := int((offset%day + day) % day / time.Second)
+
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+// withProtocol inserts a match in a rule after its protocol
+func withProtocol(match []string, rulespec []string) []string {
+
+	if len(match) == 0 {
+		return rulespec
+	}
+
+	rule := make([]string, 0, len(rulespec)+len(match))
+	for i := 0; i < len(rulespec); i++ {
+		rule = append(rule, rulespec[i])
+		if rulespec[i] == "-p" && i+1 < len(rulespec) {
+			rule = append(rule, rulespec[i+1])
+			rule = append(rule, match...)
+			i++
+		}
+	}
+
+	return rule
+}
+
+// withMatch inserts a match in a rule before its target
+func withMatch(match []string, rulespec []string) []string {
+
+	if len(match) == 0 {
+		return rulespec
+	}
+
+	rule := make([]string, 0, len(rulespec)+len(match))
+	for i, arg := range rulespec {
+		if arg == "-j" {
+			rule = append(rule, match...)
+			return append(rule, rulespec[i:]...)
+		}
+		rule = append(rule, arg)
+	}
+
+	return append(rule, match...)
+}
@@ -0,0 +1,361 @@
+package iptablesctrl
+
+import (
+	"flag"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of the rules")
+
+// newSimulatedInstance returns a started instance that programs the rules in
+// memory
+func newSimulatedInstance(mode constants.ModeType) (*Instance, *provider.MemoryProvider) {
+
+	m := provider.NewMemoryProvider()
+
+	i := newInstance(fqconfig.NewFilterQueueWithDefaults(), mode, m, false)
+	i.ipset = m
+
+	So(i.Start(), ShouldBeNil)
+	So(i.SetTargetNetworks(nil, nil), ShouldBeNil)
+
+	return i, m
+}
+
+// assertGolden compares the rules with a golden file of the testdata
+func assertGolden(name string, rules string) {
+
+	path := filepath.Join("testdata", name+".golden")
+
+	if *updateGolden {
+		So(ioutil.WriteFile(path, []byte(rules), 0644), ShouldBeNil)
+	}
+
+	golden, err := ioutil.ReadFile(path)
+	So(err, ShouldBeNil)
+	So(rules, ShouldEqual, string(golden))
+}
+
+// containerInfo returns a container with the ACLs of the golden file
+func containerInfo() *policy.PUInfo {
+
+	puInfo := policy.NewPUInfo("pu1", constants.ContainerPU)
+	puInfo.Policy = policy.NewPUPolicy("pu1", policy.Police,
+		policy.IPRuleList{
+			{Address: "10.1.0.0/16", Port: "80", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "web"}},
+			{Address: "10.2.0.1", Port: "53", Protocol: "udp", Policy: &policy.FlowPolicy{Action: policy.Reject | policy.Log, PolicyID: "dns"}},
+		},
+		policy.IPRuleList{
+			{Address: "10.3.0.0/16", Port: "22", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "ssh"}},
+		},
+		nil, nil, nil, nil,
+		policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.2"},
+		nil,
+		[]string{"192.168.0.0/16"},
+	)
+
+	return puInfo
+}
+
+func TestSimulateContainer(t *testing.T) {
+
+	Convey("Given an iptables controller of local containers that programs the rules in memory", t, func() {
+		i, m := newSimulatedInstance(constants.LocalContainer)
+		defer i.Stop() // nolint
+
+		puInfo := containerInfo()
+
+		So(i.ConfigureRules(0, "pu1", puInfo), ShouldBeNil)
+
+		appChain, netChain, err := i.chainName("pu1", 0)
+		So(err, ShouldBeNil)
+
+		pu := net.ParseIP("172.17.0.2")
+
+		Convey("The rules of the processing unit should match the golden file", func() {
+			assertGolden("container", m.SaveChains(appChain, netChain)+m.SaveSets())
+		})
+
+		Convey("The SYN packets of the processing unit should be queued before the ACLs", func() {
+			v, err := m.EvaluateHook("PREROUTING", &provider.Packet{
+				Protocol: "tcp", Source: pu, Destination: net.ParseIP("10.9.9.9"), DestinationPort: 443,
+				TCPFlags: []string{"SYN"},
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "NFQUEUE")
+			So(v.Table, ShouldEqual, "raw")
+			So(v.Chain, ShouldEqual, appChain)
+			So(v.Options, ShouldContain, i.fqc.GetApplicationQueueSynStr())
+
+			v, err = m.EvaluateHook("POSTROUTING", &provider.Packet{
+				Protocol: "tcp", Source: net.ParseIP("10.3.1.1"), Destination: pu, DestinationPort: 22,
+				TCPFlags: []string{"SYN"},
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "NFQUEUE")
+			So(v.Options, ShouldContain, i.fqc.GetNetworkQueueSynStr())
+		})
+
+		Convey("Only the first packets of the connections should be queued", func() {
+			v, err := m.EvaluateHook("PREROUTING", &provider.Packet{
+				Protocol: "tcp", Source: pu, Destination: net.ParseIP("10.1.2.3"), DestinationPort: 80,
+				TCPFlags: []string{"ACK"}, State: "ESTABLISHED", Packets: 2,
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "NFQUEUE")
+			So(v.Options, ShouldContain, i.fqc.GetApplicationQueueAckStr())
+
+			v, err = m.EvaluateHook("PREROUTING", &provider.Packet{
+				Protocol: "tcp", Source: pu, Destination: net.ParseIP("10.1.2.3"), DestinationPort: 80,
+				TCPFlags: []string{"ACK"}, State: "ESTABLISHED", Packets: 10,
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+			So(v.Chain, ShouldEqual, appChain)
+		})
+
+		Convey("All the packets of the encrypted connections should be queued", func() {
+			v, err := m.EvaluateHook("PREROUTING", &provider.Packet{
+				Protocol: "tcp", Source: pu, Destination: net.ParseIP("10.1.2.3"), DestinationPort: 80,
+				TCPFlags: []string{"ACK"}, State: "ESTABLISHED", Packets: 10, ConnMark: constants.EncryptedConnMark,
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "NFQUEUE")
+			So(v.Options, ShouldContain, i.fqc.GetApplicationQueueAckStr())
+
+			v, err = m.EvaluateHook("POSTROUTING", &provider.Packet{
+				Protocol: "tcp", Source: net.ParseIP("10.3.1.1"), Destination: pu, DestinationPort: 22,
+				TCPFlags: []string{"ACK"}, State: "ESTABLISHED", Packets: 10, ConnMark: constants.EncryptedConnMark,
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "NFQUEUE")
+			So(v.Options, ShouldContain, i.fqc.GetNetworkQueueAckStr())
+		})
+
+		Convey("The connections authorized by the enforcer should be accepted before the chains of the processing units", func() {
+			v, err := m.EvaluateHook("POSTROUTING", &provider.Packet{
+				Protocol: "tcp", Source: net.ParseIP("10.3.1.1"), Destination: pu, DestinationPort: 22,
+				TCPFlags: []string{"ACK"}, State: "ESTABLISHED", ConnMark: constants.DefaultConnMark,
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+			So(v.Chain, ShouldEqual, "POSTROUTING")
+			So(v.Rule, ShouldBeGreaterThan, 0)
+		})
+
+		Convey("The rejected flows should be logged and dropped before they are queued", func() {
+			v, err := m.EvaluateHook("PREROUTING", &provider.Packet{
+				Protocol: "udp", Source: pu, Destination: net.ParseIP("10.2.0.1"), DestinationPort: 53,
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "DROP")
+			So(v.Logs, ShouldResemble, []string{"pu1:dns:r"})
+		})
+
+		Convey("The flows that match no ACL should be logged and dropped", func() {
+			v, err := m.EvaluateHook("PREROUTING", &provider.Packet{
+				Protocol: "icmp", Source: pu, Destination: net.ParseIP("8.8.8.8"),
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "DROP")
+			So(v.Logs, ShouldResemble, []string{"pu1:default:defaultr"})
+		})
+
+		Convey("The excluded networks should bypass the enforcer unless the packets are authenticated", func() {
+			v, err := m.EvaluateHook("PREROUTING", &provider.Packet{
+				Protocol: "tcp", Source: pu, Destination: net.ParseIP("192.168.1.1"), DestinationPort: 80,
+				TCPFlags: []string{"ACK"}, State: "ESTABLISHED",
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+			So(v.Chain, ShouldEqual, appChain)
+			So(v.Rule, ShouldEqual, 1)
+
+			v, err = m.EvaluateHook("POSTROUTING", &provider.Packet{
+				Protocol: "tcp", Source: net.ParseIP("192.168.1.1"), Destination: pu, DestinationPort: 80,
+				TCPFlags: []string{"SYN"},
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+
+			v, err = m.EvaluateHook("POSTROUTING", &provider.Packet{
+				Protocol: "tcp", Source: net.ParseIP("192.168.1.1"), Destination: pu, DestinationPort: 80,
+				TCPFlags: []string{"SYN"}, TCPOptions: []int{int(packet.TCPAuthenticationOption)},
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "NFQUEUE")
+		})
+
+		Convey("The packets of other containers should not be processed", func() {
+			v, err := m.EvaluateHook("PREROUTING", &provider.Packet{
+				Protocol: "tcp", Source: net.ParseIP("172.17.0.3"), Destination: net.ParseIP("10.9.9.9"), DestinationPort: 443,
+				TCPFlags: []string{"ACK"}, State: "ESTABLISHED", Packets: 10,
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+			So(v.Rule, ShouldEqual, 0)
+		})
+
+		Convey("When I update the rules, the packets should see the new ACLs", func() {
+			puInfo.Policy = policy.NewPUPolicy("pu1", policy.Police,
+				policy.IPRuleList{
+					{Address: "8.8.8.8", Protocol: "icmp", Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "ping"}},
+				},
+				nil, nil, nil, nil, nil,
+				policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.2"},
+				nil,
+				[]string{"192.168.0.0/16"},
+			)
+
+			So(i.UpdateRules(1, "pu1", puInfo), ShouldBeNil)
+
+			v, err := m.EvaluateHook("PREROUTING", &provider.Packet{
+				Protocol: "icmp", Source: pu, Destination: net.ParseIP("8.8.8.8"),
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+			So(v.Logs, ShouldBeEmpty)
+		})
+
+		Convey("When I delete the rules, the chains of the processing unit should be removed", func() {
+			So(i.DeleteRules(0, "pu1", puInfo.Policy.IPAddresses(), "", "", ""), ShouldBeNil)
+
+			chains, err := m.ListChains("mangle")
+			So(err, ShouldBeNil)
+			So(chains, ShouldNotContain, appChain)
+			So(chains, ShouldNotContain, netChain)
+
+			v, err := m.EvaluateHook("PREROUTING", &provider.Packet{
+				Protocol: "icmp", Source: pu, Destination: net.ParseIP("8.8.8.8"),
+			})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+			So(v.Rule, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestSimulateLinuxProcesses(t *testing.T) {
+
+	Convey("Given an iptables controller of linux processes that programs the rules in memory", t, func() {
+		i, m := newSimulatedInstance(constants.LocalServer)
+		defer i.Stop() // nolint
+
+		process := func(contextID, mark, uid string, services []policy.Service) *policy.PUInfo {
+			puInfo := policy.NewPUInfo(contextID, constants.LinuxProcessPU)
+			puInfo.Runtime.SetOptions(policy.OptionsType{CgroupMark: mark, UserID: uid, Services: services})
+			puInfo.Policy = policy.NewPUPolicy(contextID, policy.Police, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+			return puInfo
+		}
+
+		Convey("When I configure the rules of a process with a cgroup", func() {
+			So(i.ConfigureRules(0, "server", process("server", "200", "", []policy.Service{{Port: 80}})), ShouldBeNil)
+
+			appChain, netChain, err := i.chainName("server", 0)
+			So(err, ShouldBeNil)
+
+			Convey("The packets of the cgroup should be marked and queued", func() {
+				v, err := m.EvaluateHook("OUTPUT", &provider.Packet{
+					Protocol: "tcp", Source: net.ParseIP("10.0.0.1"), Destination: net.ParseIP("10.1.1.1"), DestinationPort: 443,
+					TCPFlags: []string{"SYN"}, Cgroup: 200,
+				})
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "NFQUEUE")
+				So(v.Chain, ShouldEqual, appChain)
+				So(v.Mark, ShouldEqual, 200)
+			})
+
+			Convey("The packets to the ports of the process should be queued", func() {
+				v, err := m.EvaluateHook("INPUT", &provider.Packet{
+					Protocol: "tcp", Source: net.ParseIP("10.1.1.1"), Destination: net.ParseIP("10.0.0.1"), DestinationPort: 80,
+					TCPFlags: []string{"SYN"},
+				})
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "NFQUEUE")
+				So(v.Chain, ShouldEqual, netChain)
+
+				v, err = m.EvaluateHook("INPUT", &provider.Packet{
+					Protocol: "tcp", Source: net.ParseIP("10.1.1.1"), Destination: net.ParseIP("10.0.0.1"), DestinationPort: 81,
+					TCPFlags: []string{"SYN"},
+				})
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "ACCEPT")
+				So(v.Rule, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I configure the rules of a user session", func() {
+			So(i.ConfigureRules(0, "session", process("session", "300", "1000", nil)), ShouldBeNil)
+
+			appChain, netChain, err := i.chainName("session", 0)
+			So(err, ShouldBeNil)
+
+			portSetName, err := PuPortSetName("session", "300")
+			So(err, ShouldBeNil)
+
+			Convey("The rules of the user should match the golden file", func() {
+				assertGolden("uid", m.SaveChains(uidchain, appChain, netChain)+m.SaveSets())
+			})
+
+			Convey("The packets of the user should be marked and queued", func() {
+				v, err := m.EvaluateHook("OUTPUT", &provider.Packet{
+					Protocol: "tcp", Source: net.ParseIP("10.0.0.1"), Destination: net.ParseIP("10.1.1.1"), DestinationPort: 443,
+					TCPFlags: []string{"SYN"}, UID: "1000",
+				})
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "NFQUEUE")
+				So(v.Chain, ShouldEqual, appChain)
+				So(v.Mark, ShouldEqual, 300)
+
+				v, err = m.EvaluateHook("OUTPUT", &provider.Packet{
+					Protocol: "tcp", Source: net.ParseIP("10.0.0.1"), Destination: net.ParseIP("10.1.1.1"), DestinationPort: 443,
+					TCPFlags: []string{"SYN"}, UID: "1001",
+				})
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "ACCEPT")
+				So(v.Rule, ShouldEqual, 0)
+			})
+
+			Convey("The packets to the ports of the user should be marked", func() {
+				set, err := m.NewPortSet(portSetName)
+				So(err, ShouldBeNil)
+				So(set.Add("8080", 0), ShouldBeNil)
+
+				v, err := m.EvaluateHook("PREROUTING", &provider.Packet{
+					Protocol: "tcp", Source: net.ParseIP("10.1.1.1"), Destination: net.ParseIP("10.0.0.1"), DestinationPort: 8080,
+					TCPFlags: []string{"SYN"},
+				})
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "ACCEPT")
+				So(v.Mark, ShouldEqual, 300)
+
+				v, err = m.EvaluateHook("INPUT", &provider.Packet{
+					Protocol: "tcp", Source: net.ParseIP("10.1.1.1"), Destination: net.ParseIP("10.0.0.1"), DestinationPort: 8080,
+					TCPFlags: []string{"SYN"}, Mark: v.Mark,
+				})
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "NFQUEUE")
+				So(v.Chain, ShouldEqual, netChain)
+			})
+
+			Convey("When I delete the rules, the port set should be destroyed", func() {
+				So(i.DeleteRules(0, "session", nil, "0", "300", "1000"), ShouldBeNil)
+				So(m.Sets(), ShouldNotContain, portSetName)
+
+				rules, err := m.Rules("mangle", uidchain)
+				So(err, ShouldBeNil)
+				So(rules, ShouldBeEmpty)
+			})
+		})
+	})
+}
@@ -0,0 +1,106 @@
+package iptablesctrl
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testRestore is a memory provider that counts the restores and fails the
+// ones that contain a line
+type testRestore struct {
+	*provider.MemoryProvider
+	restores int
+	fail     string
+}
+
+func (r *testRestore) Restore(script string) error {
+
+	r.restores++
+
+	if r.fail != "" && strings.Contains(script, r.fail) {
+		return fmt.Errorf("restore failed")
+	}
+
+	return r.MemoryProvider.Restore(script)
+}
+
+func TestTransactions(t *testing.T) {
+
+	Convey("Given an iptables controller that programs the rules in restore transactions", t, func() {
+		i, m := newSimulatedInstance(constants.LocalContainer)
+		defer i.Stop() // nolint
+
+		restore := &testRestore{MemoryProvider: m}
+		i.ipt = restore
+
+		appChain, netChain, err := i.chainName("pu1", 0)
+		So(err, ShouldBeNil)
+
+		Convey("The rules of a processing unit should be programmed in a single restore", func() {
+			So(i.ConfigureRules(0, "pu1", containerInfo()), ShouldBeNil)
+			So(restore.restores, ShouldEqual, 1)
+
+			assertGolden("container", m.SaveChains(appChain, netChain)+m.SaveSets())
+		})
+
+		Convey("The rules of a processing unit should be removed if the restore fails", func() {
+			before := m.Save()
+
+			restore.fail = "-A " + netChain
+			So(i.ConfigureRules(0, "pu1", containerInfo()), ShouldNotBeNil)
+			So(m.Save(), ShouldEqual, before)
+		})
+
+		Convey("When I update the ACLs of a processing unit", func() {
+			puInfo := containerInfo()
+			So(i.ConfigureRules(0, "pu1", puInfo), ShouldBeNil)
+
+			puInfo.Policy = policy.NewPUPolicy("pu1", policy.Police,
+				policy.IPRuleList{
+					{Address: "8.8.8.8", Protocol: "icmp", Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "ping"}},
+				},
+				nil, nil, nil, nil, nil,
+				policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.2"},
+				nil,
+				[]string{"192.168.0.0/16"},
+			)
+
+			ping := &provider.Packet{Protocol: "icmp", Source: net.ParseIP("172.17.0.2"), Destination: net.ParseIP("8.8.8.8")}
+
+			Convey("The chains should be updated in a single restore", func() {
+				So(i.UpdateRules(1, "pu1", puInfo), ShouldBeNil)
+				So(restore.restores, ShouldEqual, 2)
+
+				v, err := m.EvaluateHook("PREROUTING", ping)
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "ACCEPT")
+				So(v.Chain, ShouldEqual, appChain)
+			})
+
+			Convey("The chains should be swapped if the update fails", func() {
+				restore.fail = "-I " + appChain + " "
+				So(i.UpdateRules(1, "pu1", puInfo), ShouldBeNil)
+				So(restore.restores, ShouldEqual, 4)
+
+				newAppChain, _, err := i.chainName("pu1", 1)
+				So(err, ShouldBeNil)
+
+				v, err := m.EvaluateHook("PREROUTING", ping)
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "ACCEPT")
+				So(v.Chain, ShouldEqual, newAppChain)
+
+				chains, err := m.ListChains("mangle")
+				So(err, ShouldBeNil)
+				So(chains, ShouldNotContain, appChain)
+			})
+		})
+	})
+}
@@ -0,0 +1,549 @@
+package iptablesctrl
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+)
+
+// puChains are the chains of a processing unit as they are programmed. The
+// chains are only swapped with the chains of the other version when the rules
+// that send traffic to them change, so the instance keeps track of the version
+// of the chains rather than the supervisor. The listed rules are the rules of
+// the chains as iptables lists them once they are programmed, to detect the
+// changes made by other agents.
+type puChains struct {
+	version   int
+	ipAddress string
+	mark      string
+	port      string
+	uid       string
+	rules     *ruleRecorder
+	listed    map[chainID][]string
+}
+
+// puOptions returns the mark, the ports and the user of the rules that send
+// the traffic of a linux process to its chains
+func (i *Instance) puOptions(containerInfo *policy.PUInfo) (mark string, port string, uid string) {
+
+	if i.mode != constants.LocalServer {
+		return "", "", ""
+	}
+
+	options := containerInfo.Runtime.Options()
+
+	return options.CgroupMark, policy.ConvertServicesToPortList(options.Services), options.UserID
+}
+
+// renderChains returns the rules of the chains of a processing unit without
+// programming them
+func (i *Instance) renderChains(contextID, appChain, netChain, ipAddress string, policyrules *policy.PUPolicy) (*ruleRecorder, error) {
+
+	recorder := newRuleRecorder()
+
+	renderer := *i
+	renderer.ipt = recorder
+
+	if err := renderer.addContainerChain(appChain, netChain); err != nil {
+		return nil, err
+	}
+
+	if err := renderer.addChainACLs(contextID, appChain, netChain, ipAddress, policyrules); err != nil {
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+// recordChains keeps the rules of the chains of a processing unit once they
+// are programmed. The next update of the rules of the unit is incremental.
+func (i *Instance) recordChains(version int, contextID, ipAddress string, containerInfo *policy.PUInfo) {
+
+	appChain, netChain, err := i.chainName(contextID, version)
+	if err != nil {
+		return
+	}
+
+	rules, err := i.renderChains(contextID, appChain, netChain, ipAddress, containerInfo.Policy)
+	if err != nil {
+		zap.L().Warn("Failed to render the chains of the processing unit", zap.String("contextID", contextID), zap.Error(err))
+		i.contextChains.Remove(contextID) // nolint
+		return
+	}
+
+	mark, port, uid := i.puOptions(containerInfo)
+
+	i.contextChains.AddOrUpdate(contextID, &puChains{
+		version:   version,
+		ipAddress: ipAddress,
+		mark:      mark,
+		port:      port,
+		uid:       uid,
+		rules:     rules,
+		listed:    i.listChains(rules),
+	})
+}
+
+// listChains returns the rules of the chains of the recorder as iptables
+// lists them. The chains that can't be listed are left out.
+func (i *Instance) listChains(rules *ruleRecorder) map[chainID][]string {
+
+	listed := map[chainID][]string{}
+
+	for id := range rules.chains {
+		list, err := i.ipt.List(id.table, id.chain)
+		if err != nil {
+			zap.L().Debug("Failed to list the rules of the chain", zap.String("chain", id.chain), zap.Error(err))
+			continue
+		}
+		listed[id] = list
+	}
+
+	return listed
+}
+
+// updateChains updates the rules of the chains of a processing unit in place.
+// Only the rules that change are inserted or deleted. It returns false if the
+// chains must be swapped instead.
+func (i *Instance) updateChains(contextID string, current *puChains, policyrules *policy.PUPolicy) bool {
+
+	appChain, netChain, err := i.chainName(contextID, current.version)
+	if err != nil {
+		return false
+	}
+
+	rules, err := i.renderChains(contextID, appChain, netChain, current.ipAddress, policyrules)
+	if err != nil {
+		zap.L().Debug("Failed to render the chains of the processing unit", zap.String("contextID", contextID), zap.Error(err))
+		return false
+	}
+
+	updates, ok := current.rules.updates(rules)
+	if !ok {
+		return false
+	}
+
+	if err := i.transaction(func(t *Instance) error {
+		for _, update := range updates {
+			if err := update.apply(t.ipt); err != nil {
+				return fmt.Errorf("Failed to update the rules of chain %s: %s", update.id.chain, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		zap.L().Warn("Failed to update the rules of the chains",
+			zap.String("contextID", contextID),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	current.rules = rules
+	current.listed = i.listChains(rules)
+
+	zap.L().Debug("Updated the rules of the chains",
+		zap.String("contextID", contextID),
+		zap.Int("updates", len(updates)),
+	)
+
+	return true
+}
+
+// chainID identifies a chain in a table
+type chainID struct {
+	table string
+	chain string
+}
+
+// ruleRecorder is an in memory iptables provider that keeps the rules of the
+// chains in their order. It is used to render the chains of a processing unit
+// without programming them.
+type ruleRecorder struct {
+	chains map[chainID][][]string
+}
+
+// newRuleRecorder returns an empty recorder
+func newRuleRecorder() *ruleRecorder {
+
+	return &ruleRecorder{
+		chains: map[chainID][][]string{},
+	}
+}
+
+// Append appends a rule to a chain
+func (r *ruleRecorder) Append(table, chain string, rulespec ...string) error {
+
+	id := chainID{table: table, chain: chain}
+
+	rules, ok := r.chains[id]
+	if !ok {
+		return fmt.Errorf("No chain %s in table %s", chain, table)
+	}
+
+	r.chains[id] = append(rules, append([]string{}, rulespec...))
+
+	return nil
+}
+
+// Insert inserts a rule in a chain at the given position, starting at 1
+func (r *ruleRecorder) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	id := chainID{table: table, chain: chain}
+
+	rules, ok := r.chains[id]
+	if !ok {
+		return fmt.Errorf("No chain %s in table %s", chain, table)
+	}
+
+	if pos < 1 || pos > len(rules)+1 {
+		return fmt.Errorf("Invalid position %d in chain %s of table %s", pos, chain, table)
+	}
+
+	rule := append([]string{}, rulespec...)
+	r.chains[id] = append(rules[:pos-1], append([][]string{rule}, rules[pos-1:]...)...)
+
+	return nil
+}
+
+// Delete deletes the first rule of a chain that matches the rulespec, or the
+// rule with the given number
+func (r *ruleRecorder) Delete(table, chain string, rulespec ...string) error {
+
+	id := chainID{table: table, chain: chain}
+
+	if len(rulespec) == 1 {
+		if num, err := strconv.Atoi(rulespec[0]); err == nil {
+			if num < 1 || num > len(r.chains[id]) {
+				return fmt.Errorf("Invalid rule number %d in chain %s of table %s", num, chain, table)
+			}
+			r.chains[id] = append(r.chains[id][:num-1], r.chains[id][num:]...)
+			return nil
+		}
+	}
+
+	for index, rule := range r.chains[id] {
+		if ruleKey(rule) == ruleKey(rulespec) {
+			r.chains[id] = append(r.chains[id][:index], r.chains[id][index+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No matching rule in chain %s of table %s", chain, table)
+}
+
+// Exists returns true if a rule of the chain matches the rulespec
+func (r *ruleRecorder) Exists(table, chain string, rulespec ...string) (bool, error) {
+
+	id := chainID{table: table, chain: chain}
+
+	rules, ok := r.chains[id]
+	if !ok {
+		return false, fmt.Errorf("No chain %s in table %s", chain, table)
+	}
+
+	for _, rule := range rules {
+		if ruleKey(rule) == ruleKey(rulespec) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// List lists the rules of a chain
+func (r *ruleRecorder) List(table, chain string) ([]string, error) {
+
+	id := chainID{table: table, chain: chain}
+
+	rules, ok := r.chains[id]
+	if !ok {
+		return nil, fmt.Errorf("No chain %s in table %s", chain, table)
+	}
+
+	list := []string{"-N " + chain}
+	for _, rule := range rules {
+		list = append(list, "-A "+chain+" "+strings.Join(rule, " "))
+	}
+
+	return list, nil
+}
+
+// ListChains lists the chains of a table
+func (r *ruleRecorder) ListChains(table string) ([]string, error) {
+
+	chains := []string{}
+	for id := range r.chains {
+		if id.table == table {
+			chains = append(chains, id.chain)
+		}
+	}
+	sort.Strings(chains)
+
+	return chains, nil
+}
+
+// chainIDs returns the chains of the recorder in a stable order
+func (r *ruleRecorder) chainIDs() []chainID {
+
+	ids := make([]chainID, 0, len(r.chains))
+	for id := range r.chains {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(a, b int) bool {
+		if ids[a].table != ids[b].table {
+			return ids[a].table < ids[b].table
+		}
+		return ids[a].chain < ids[b].chain
+	})
+
+	return ids
+}
+
+// ClearChain removes the rules of a chain. The chain is created if needed.
+func (r *ruleRecorder) ClearChain(table, chain string) error {
+
+	r.chains[chainID{table: table, chain: chain}] = [][]string{}
+
+	return nil
+}
+
+// DeleteChain deletes a chain
+func (r *ruleRecorder) DeleteChain(table, chain string) error {
+
+	id := chainID{table: table, chain: chain}
+
+	if _, ok := r.chains[id]; !ok {
+		return fmt.Errorf("No chain %s in table %s", chain, table)
+	}
+
+	delete(r.chains, id)
+
+	return nil
+}
+
+// NewChain creates an empty chain
+func (r *ruleRecorder) NewChain(table, chain string) error {
+
+	id := chainID{table: table, chain: chain}
+
+	if _, ok := r.chains[id]; ok {
+		return fmt.Errorf("Chain %s already exists in table %s", chain, table)
+	}
+
+	r.chains[id] = [][]string{}
+
+	return nil
+}
+
+// ruleUpdate is an insertion or, when its position is 0, a deletion of a rule.
+// A deletion removes the rule with the given number, or the first matching
+// rule when the number is 0.
+type ruleUpdate struct {
+	id       chainID
+	pos      int
+	num      int
+	rulespec []string
+}
+
+// apply programs the update
+func (u *ruleUpdate) apply(ipt provider.IptablesProvider) error {
+
+	if u.pos == 0 && u.num > 0 {
+		return ipt.Delete(u.id.table, u.id.chain, strconv.Itoa(u.num))
+	}
+
+	if u.pos == 0 {
+		return ipt.Delete(u.id.table, u.id.chain, u.rulespec...)
+	}
+
+	return ipt.Insert(u.id.table, u.id.chain, u.pos, u.rulespec...)
+}
+
+// updates returns the updates that change the chains of the recorder into the
+// chains of the target. It returns false if the chains are not the same.
+func (r *ruleRecorder) updates(target *ruleRecorder) ([]*ruleUpdate, bool) {
+
+	if len(r.chains) != len(target.chains) {
+		return nil, false
+	}
+
+	ids := target.chainIDs()
+	for _, id := range ids {
+		if _, ok := r.chains[id]; !ok {
+			return nil, false
+		}
+	}
+
+	updates := []*ruleUpdate{}
+	for _, id := range ids {
+		chainUpdates, ok := diffChain(id, r.chains[id], target.chains[id])
+		if !ok {
+			return nil, false
+		}
+		updates = append(updates, chainUpdates...)
+	}
+
+	return updates, true
+}
+
+// diffChain returns the updates that change the current rules of a chain into
+// the target rules. The rules that are kept are the longest sequence of rules
+// found in the same order in both, so that moved rules are inserted again.
+// The new rules, including the moved rules at their new position, are
+// inserted before the removed rules are deleted, so that the chain never
+// rejects the flows that are accepted by both. It returns false
+// if the updates don't give the target rules, which can only happen when the
+// chain has duplicate rules.
+func diffChain(id chainID, current, target [][]string) ([]*ruleUpdate, bool) {
+
+	// Match each target rule with an identical current rule in order
+	available := map[string][]int{}
+	for index, rule := range current {
+		key := ruleKey(rule)
+		available[key] = append(available[key], index)
+	}
+
+	matched := make([]int, len(target))
+	for index, rule := range target {
+		key := ruleKey(rule)
+		matched[index] = -1
+		if indexes := available[key]; len(indexes) > 0 {
+			matched[index] = indexes[0]
+			available[key] = indexes[1:]
+		}
+	}
+
+	kept := keptRules(matched)
+
+	// chain is the copy of the chain that the updates are applied to. It
+	// starts with the current rules, and the position in the target of the
+	// rules that are kept.
+	chain := make([]chainEntry, len(current))
+	for index, rule := range current {
+		chain[index] = chainEntry{key: ruleKey(rule), current: index, target: -1}
+	}
+
+	for index, keep := range kept {
+		if keep {
+			chain[matched[index]].target = index
+		}
+	}
+
+	updates := []*ruleUpdate{}
+
+	// Each new rule is inserted after the rule that precedes it in the target
+	for index, rule := range target {
+		if kept[index] {
+			continue
+		}
+
+		pos := 0
+		if index > 0 {
+			for pos < len(chain) && chain[pos].target != index-1 {
+				pos++
+			}
+			if pos == len(chain) {
+				return nil, false
+			}
+			pos++
+		}
+
+		chain = append(chain[:pos], append([]chainEntry{{key: ruleKey(rule), current: -1, target: index}}, chain[pos:]...)...)
+		updates = append(updates, &ruleUpdate{id: id, pos: pos + 1, rulespec: rule})
+	}
+
+	// The current rules that are not kept are deleted. They are deleted by
+	// their number when an identical rule, like a moved rule inserted at its
+	// new position, precedes them in the chain.
+	for pos := 0; pos < len(chain); {
+		if chain[pos].current < 0 || chain[pos].target >= 0 {
+			pos++
+			continue
+		}
+
+		update := &ruleUpdate{id: id, rulespec: current[chain[pos].current]}
+		for first := range chain[:pos] {
+			if chain[first].key == chain[pos].key {
+				update.num = pos + 1
+				break
+			}
+		}
+
+		chain = append(chain[:pos], chain[pos+1:]...)
+		updates = append(updates, update)
+	}
+
+	if len(chain) != len(target) {
+		return nil, false
+	}
+
+	for index, rule := range target {
+		if chain[index].key != ruleKey(rule) {
+			return nil, false
+		}
+	}
+
+	return updates, true
+}
+
+// chainEntry is a rule of a chain and its position in the current and in the
+// target rules
+type chainEntry struct {
+	key     string
+	current int
+	target  int
+}
+
+// keptRules returns the target rules that are kept in the chain. These are the
+// longest increasing sequence of the positions of the matched current rules.
+func keptRules(matched []int) []bool {
+
+	// tails[l] is the index of the smallest last position of the increasing
+	// sequences of length l+1
+	tails := []int{}
+	previous := make([]int, len(matched))
+
+	for index, position := range matched {
+		previous[index] = -1
+		if position < 0 {
+			continue
+		}
+
+		length := sort.Search(len(tails), func(l int) bool {
+			return matched[tails[l]] >= position
+		})
+
+		if length > 0 {
+			previous[index] = tails[length-1]
+		}
+
+		if length == len(tails) {
+			tails = append(tails, index)
+		} else {
+			tails[length] = index
+		}
+	}
+
+	kept := make([]bool, len(matched))
+	if len(tails) > 0 {
+		for index := tails[len(tails)-1]; index >= 0; index = previous[index] {
+			kept[index] = true
+		}
+	}
+
+	return kept
+}
+
+// ruleKey returns the key that identifies a rule in a chain
+func ruleKey(rulespec []string) string {
+
+	return strings.Join(rulespec, " ")
+}
@@ -5,7 +5,10 @@
 package mockinterfaces
 
 import (
+	time "time"
+
 	gomock "github.com/aporeto-inc/mock/gomock"
+	collector "github.com/aporeto-inc/trireme/collector"
 	policy "github.com/aporeto-inc/trireme/policy"
 )
 
@@ -80,6 +83,24 @@ func (_mr *_MockSupervisorRecorder) SetTargetNetworks(arg0 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetTargetNetworks", arg0)
 }
 
+func (_m *MockSupervisor) SetReconcileInterval(interval time.Duration) {
+	_m.ctrl.Call(_m, "SetReconcileInterval", interval)
+}
+
+func (_mr *_MockSupervisorRecorder) SetReconcileInterval(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetReconcileInterval", arg0)
+}
+
+func (_m *MockSupervisor) DriftStats() *collector.DriftStats {
+	ret := _m.ctrl.Call(_m, "DriftStats")
+	ret0, _ := ret[0].(*collector.DriftStats)
+	return ret0
+}
+
+func (_mr *_MockSupervisorRecorder) DriftStats() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DriftStats")
+}
+
 // Mock of Implementor interface
 type MockImplementor struct {
 	ctrl     *gomock.Controller
@@ -160,3 +181,46 @@ func (_m *MockImplementor) Stop() error {
 func (_mr *_MockImplementorRecorder) Stop() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Stop")
 }
+
+// Mock of Reconciler interface
+type MockReconciler struct {
+	ctrl     *gomock.Controller
+	recorder *_MockReconcilerRecorder
+}
+
+// Recorder for MockReconciler (not exported)
+type _MockReconcilerRecorder struct {
+	mock *MockReconciler
+}
+
+func NewMockReconciler(ctrl *gomock.Controller) *MockReconciler {
+	mock := &MockReconciler{ctrl: ctrl}
+	mock.recorder = &_MockReconcilerRecorder{mock}
+	return mock
+}
+
+func (_m *MockReconciler) EXPECT() *_MockReconcilerRecorder {
+	return _m.recorder
+}
+
+func (_m *MockReconciler) ReconcileRules(contextID string) (int, error) {
+	ret := _m.ctrl.Call(_m, "ReconcileRules", contextID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockReconcilerRecorder) ReconcileRules(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ReconcileRules", arg0)
+}
+
+func (_m *MockReconciler) ReconcileGlobalRules() (int, error) {
+	ret := _m.ctrl.Call(_m, "ReconcileGlobalRules")
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockReconcilerRecorder) ReconcileGlobalRules() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ReconcileGlobalRules")
+}
@@ -0,0 +1,102 @@
+package nftablesctrl
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+const (
+	fqdnSetPrefix4 = "fqdn4-"
+	fqdnSetPrefix6 = "fqdn6-"
+)
+
+// fqdnInstances counts the instances, so that each instance watches the names
+// of its processing units with its own owners on the shared watcher
+var fqdnInstances uint32
+
+// newFQDNOwnerPrefix returns the prefix of the owners of a new instance
+func newFQDNOwnerPrefix() string {
+
+	return "nftables-" + strconv.Itoa(int(atomic.AddUint32(&fqdnInstances, 1))) + "/"
+}
+
+// fqdnSetNames returns the names of the sets of the IPv4 and IPv6 addresses of
+// a DNS name
+func fqdnSetNames(name string) (string, string) {
+
+	hash := md5.Sum([]byte(name))
+	suffix := hex.EncodeToString(hash[:6])
+
+	return fqdnSetPrefix4 + suffix, fqdnSetPrefix6 + suffix
+}
+
+// fqdnMatches returns the matches of the addresses of a DNS name in both IP
+// families
+func fqdnMatches(name string) []string {
+
+	set4, set6 := fqdnSetNames(name)
+
+	return []string{"ip daddr @" + set4, "ip6 daddr @" + set6}
+}
+
+// splitAddresses returns the IPv4 and the IPv6 addresses of a list
+func splitAddresses(ips []net.IP) (addresses4, addresses6 []string) {
+
+	addresses4 = []string{}
+	addresses6 = []string{}
+
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			addresses4 = append(addresses4, ip.String())
+		} else {
+			addresses6 = append(addresses6, ip.String())
+		}
+	}
+
+	return addresses4, addresses6
+}
+
+// fqdnSets adds the sets of the current addresses of DNS names to a table
+func (i *Instance) fqdnSets(t *table, names []string) {
+
+	for _, name := range names {
+		set4, set6 := fqdnSetNames(name)
+		addresses4, addresses6 := splitAddresses(i.fqdnWatcher.Addresses(name))
+
+		t.addSet(set4, "ipv4_addr", addresses4)
+		t.addSet(set6, "ipv6_addr", addresses6)
+	}
+}
+
+// updateFQDN updates the sets of a DNS name in all the tables that use it in a
+// single transaction, when its addresses change
+func (i *Instance) updateFQDN(name string, ips []net.IP) {
+
+	i.Lock()
+	defer i.Unlock()
+
+	set4, set6 := fqdnSetNames(name)
+	addresses4, addresses6 := splitAddresses(ips)
+
+	script := ""
+	for _, contextID := range i.contextTables.KeyList() {
+		ct, ok := i.contextTable(contextID.(string))
+		if !ok || !ct.usesName(name) {
+			continue
+		}
+		script += setScript(ct.name, set4, addresses4) + setScript(ct.name, set6, addresses6)
+	}
+
+	if script == "" {
+		return
+	}
+
+	if err := i.nft.Apply(script); err != nil {
+		zap.L().Warn("Failed to update the sets of a name", zap.String("name", name), zap.Error(err))
+	}
+}
@@ -0,0 +1,356 @@
+// Package nftablesctrl implements the supervisor with nftables. Each processing
+// unit has its own table, so that its rules are created, replaced and deleted
+// atomically in a single transaction, and the target networks are native sets.
+//
+// The Linux processes of the users are not supported: their ports are in the
+// ipsets that the enforcer fills, that nftables can't match. Their rules fail
+// before any table is programmed.
+package nftablesctrl
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/cache"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqdn"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+)
+
+const (
+	tableFamily     = "inet"
+	globalTableName = "trireme"
+	tablePrefix     = globalTableName + "-"
+	targetSet4      = "target4"
+	targetSet6      = "target6"
+	appChain        = "app"
+	netChain        = "net"
+	appRawChain     = "app-raw"
+	puAppChain      = "pu-app"
+	puNetChain      = "pu-net"
+	puAppRawChain   = "pu-app-raw"
+	appLogGroup     = 10
+	netLogGroup     = 11
+
+	// The base chains have the priorities of the raw and mangle tables of
+	// iptables. The chains of the processing units come after the global
+	// chains.
+	rawPriority      = -300
+	manglePriority   = -150
+	puPriorityOffset = 10
+)
+
+// Instance is the structure holding all information about a implementation
+type Instance struct {
+	fqc     *fqconfig.FilterQueue
+	nft     provider.NftablesProvider
+	mode    constants.ModeType
+	appHook string
+	netHook string
+
+	// targetNetworks are the networks of the sets of all the tables
+	targetNetworks []string
+
+	// contextTables are the tables of the processing units
+	contextTables cache.DataStore
+
+	fqdnWatcher     *fqdn.Watcher
+	fqdnOwnerPrefix string
+	fqdnUnsubscribe func()
+	scheduleStop    chan struct{}
+
+	// The lock serializes the transactions so that the tables always have
+	// the current target networks
+	sync.Mutex
+}
+
+// NewInstance creates a new nftables controller instance
+func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType) (*Instance, error) {
+
+	nft, err := provider.NewNftProvider()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot initialize nftables provider: %s", err)
+	}
+
+	return newInstance(fqc, mode, nft), nil
+}
+
+// newInstance creates a controller instance with the given provider
+func newInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, nft provider.NftablesProvider) *Instance {
+
+	i := &Instance{
+		fqc:            fqc,
+		nft:            nft,
+		mode:           mode,
+		targetNetworks: defaultNetworks(),
+		contextTables:  cache.NewCache("PUTables"),
+
+		fqdnWatcher:     fqdn.SharedWatcher(),
+		fqdnOwnerPrefix: newFQDNOwnerPrefix(),
+	}
+
+	if mode == constants.LocalServer || mode == constants.RemoteContainer {
+		i.appHook = "output"
+		i.netHook = "input"
+	} else {
+		i.appHook = "prerouting"
+		i.netHook = "postrouting"
+	}
+
+	return i
+}
+
+// contextTable is the table of a processing unit with the policy it was
+// programmed from
+type contextTable struct {
+	name          string
+	containerInfo *policy.PUInfo
+
+	// names are the DNS names of the sets of the table
+	names []string
+
+	// schedules are the states of the scheduled ACLs of the table
+	schedules string
+}
+
+// usesName returns true if the table has the sets of a DNS name
+func (c *contextTable) usesName(name string) bool {
+
+	for _, n := range c.names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// contextTable returns the table of a processing unit
+func (i *Instance) contextTable(contextID string) (*contextTable, bool) {
+
+	value, err := i.contextTables.Get(contextID)
+	if err != nil {
+		return nil, false
+	}
+
+	return value.(*contextTable), true
+}
+
+// defaultNetworks returns the networks captured when there are no target
+// networks, all the traffic of both IP families
+func defaultNetworks() []string {
+
+	return []string{"0.0.0.0/1", "128.0.0.0/1", "::/1", "8000::/1"}
+}
+
+// tableName returns the name of the table of a processing unit
+func tableName(contextID string) string {
+
+	hash := md5.Sum([]byte(contextID))
+
+	return tablePrefix + hex.EncodeToString(hash[:6])
+}
+
+// ConfigureRules implements the ConfigureRules interface. The table of the
+// processing unit is created in a single transaction.
+func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	return i.programTable(contextID, containerInfo)
+}
+
+// UpdateRules implements the update part of the interface. The table of the
+// processing unit is replaced in a single transaction, so the packets never
+// see the rules of both policies and the versions of the supervisor are not
+// needed.
+func (i *Instance) UpdateRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	return i.programTable(contextID, containerInfo)
+}
+
+// programTable creates or replaces the table of a processing unit. The DNS
+// names of the ACLs are watched before the table is programmed, so that their
+// sets have their addresses.
+func (i *Instance) programTable(contextID string, containerInfo *policy.PUInfo) error {
+
+	if containerInfo == nil || containerInfo.Policy == nil {
+		return fmt.Errorf("Container info and policy cannot be nil")
+	}
+
+	previous := []string{}
+	if ct, ok := i.contextTable(contextID); ok {
+		previous = ct.names
+	}
+
+	i.fqdnWatcher.Watch(i.fqdnOwnerPrefix+contextID, containerInfo.Policy.ApplicationACLs().FQDNs())
+
+	i.Lock()
+	defer i.Unlock()
+
+	if err := i.replaceTable(contextID, containerInfo, time.Now()); err != nil {
+		i.fqdnWatcher.Watch(i.fqdnOwnerPrefix+contextID, previous)
+		return err
+	}
+
+	return nil
+}
+
+// replaceTable programs the table of a processing unit with the ACLs that are
+// active at the given time. The instance must be locked.
+func (i *Instance) replaceTable(contextID string, containerInfo *policy.PUInfo, now time.Time) error {
+
+	t, err := i.puTable(contextID, containerInfo, now)
+	if err != nil {
+		return err
+	}
+
+	if err := i.nft.Apply(t.replaceScript()); err != nil {
+		return fmt.Errorf("Failed to program the table %s of %s: %s", t.name, contextID, err)
+	}
+
+	i.contextTables.AddOrUpdate(contextID, &contextTable{
+		name:          t.name,
+		containerInfo: containerInfo,
+		names:         containerInfo.Policy.ApplicationACLs().FQDNs(),
+		schedules:     scheduleState(containerInfo, now),
+	})
+
+	return nil
+}
+
+// DeleteRules implements the DeleteRules interface. The table of the
+// processing unit is deleted with all its rules.
+func (i *Instance) DeleteRules(version int, contextID string, ipAddresses policy.ExtendedMap, port string, mark string, uid string) error {
+
+	i.Lock()
+	defer i.Unlock()
+
+	name := tableName(contextID)
+
+	i.contextTables.Remove(contextID) // nolint
+	i.fqdnWatcher.Unwatch(i.fqdnOwnerPrefix + contextID)
+
+	if err := i.nft.Apply(deleteScript(name)); err != nil {
+		return fmt.Errorf("Failed to delete the table %s of %s: %s", name, contextID, err)
+	}
+
+	return nil
+}
+
+// SetTargetNetworks updates the target networks. The global table and the sets
+// of the tables of all the processing units are updated in a single
+// transaction.
+func (i *Instance) SetTargetNetworks(current, networks []string) error {
+
+	if len(networks) == 0 {
+		networks = defaultNetworks()
+	}
+
+	i.Lock()
+	defer i.Unlock()
+
+	networks4, networks6 := splitNetworks(networks)
+
+	script := i.globalTable(networks).replaceScript()
+	for _, contextID := range i.contextTables.KeyList() {
+		if ct, ok := i.contextTable(contextID.(string)); ok {
+			script += updateSetsScript(ct.name, networks4, networks6)
+		}
+	}
+
+	if err := i.nft.Apply(script); err != nil {
+		return fmt.Errorf("Failed to update the target networks: %s", err)
+	}
+
+	i.targetNetworks = networks
+
+	return nil
+}
+
+// Start starts the nftables controller. The tables of a previous run are
+// deleted.
+func (i *Instance) Start() error {
+
+	i.Lock()
+	defer i.Unlock()
+
+	if err := i.cleanTables(); err != nil {
+		zap.L().Warn("Failed to clean previous tables while starting the supervisor", zap.Error(err))
+	}
+
+	if i.fqdnUnsubscribe == nil {
+		i.fqdnUnsubscribe = i.fqdnWatcher.Subscribe(i.updateFQDN)
+		i.fqdnWatcher.Start()
+	}
+
+	if i.scheduleStop == nil {
+		i.scheduleStop = make(chan struct{})
+		go i.runSchedules(i.scheduleStop)
+	}
+
+	zap.L().Debug("Started the nftables controller")
+
+	return nil
+}
+
+// Stop stops the supervisor and deletes all the tables
+func (i *Instance) Stop() error {
+
+	zap.L().Debug("Stop the supervisor")
+
+	i.Lock()
+	defer i.Unlock()
+
+	if i.fqdnUnsubscribe != nil {
+		i.fqdnUnsubscribe()
+		i.fqdnUnsubscribe = nil
+		i.fqdnWatcher.Stop()
+	}
+
+	if i.scheduleStop != nil {
+		close(i.scheduleStop)
+		i.scheduleStop = nil
+	}
+
+	if err := i.cleanTables(); err != nil {
+		zap.L().Error("Failed to clean tables while stopping the supervisor", zap.Error(err))
+	}
+
+	for _, contextID := range i.contextTables.KeyList() {
+		i.fqdnWatcher.Unwatch(i.fqdnOwnerPrefix + contextID.(string))
+	}
+
+	i.contextTables = cache.NewCache("PUTables")
+
+	return nil
+}
+
+// cleanTables deletes all the tables of Trireme in a single transaction. The
+// instance must be locked.
+func (i *Instance) cleanTables() error {
+
+	tables, err := i.nft.ListTables(tableFamily)
+	if err != nil {
+		return err
+	}
+
+	script := ""
+	for _, name := range tables {
+		if name == globalTableName || strings.HasPrefix(name, tablePrefix) {
+			script += fmt.Sprintf("delete table %s %s\n", tableFamily, name)
+		}
+	}
+
+	if script == "" {
+		return nil
+	}
+
+	return i.nft.Apply(script)
+}
@@ -0,0 +1,336 @@
+package nftablesctrl
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqdn"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testResolver resolves the names from a map
+type testResolver map[string][]net.IP
+
+func (r testResolver) Resolve(name string) ([]net.IP, time.Duration, error) {
+	return r[name], time.Minute, nil
+}
+
+func containerInfo(contextID string, ips policy.ExtendedMap, appACLs, netACLs policy.IPRuleList, excluded []string) *policy.PUInfo {
+
+	puInfo := policy.NewPUInfo(contextID, constants.ContainerPU)
+	puInfo.Policy = policy.NewPUPolicy(contextID, policy.Police, appACLs, netACLs, nil, nil, nil, nil, ips, nil, excluded)
+
+	return puInfo
+}
+
+// ruleIndex returns the position of the first line of the script that contains the rule
+func ruleIndex(script, rule string) int {
+
+	for index, line := range strings.Split(script, "\n") {
+		if strings.Contains(line, rule) {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func TestNewInstance(t *testing.T) {
+
+	Convey("When I create a new nftables instance", t, func() {
+
+		Convey("If I create a local container implementation, it should use the routing hooks", func() {
+			i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalContainer, provider.NewTestNftablesProvider())
+			So(i.appHook, ShouldEqual, "prerouting")
+			So(i.netHook, ShouldEqual, "postrouting")
+		})
+
+		Convey("If I create a remote implementation, it should use the local hooks", func() {
+			i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, provider.NewTestNftablesProvider())
+			So(i.appHook, ShouldEqual, "output")
+			So(i.netHook, ShouldEqual, "input")
+		})
+	})
+}
+
+func TestQueue(t *testing.T) {
+
+	Convey("Given ranges of queues, the statements should balance the packets", t, func() {
+		So(queue("0:3", false), ShouldEqual, "queue num 0-3")
+		So(queue("4:4", false), ShouldEqual, "queue num 4")
+		So(queue("8:11", true), ShouldEqual, "queue num 8-11 bypass")
+	})
+}
+
+func TestConfigureRules(t *testing.T) {
+
+	Convey("Given an nftables controller of local containers", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		fqc := fqconfig.NewFilterQueueWithDefaults()
+		i := newInstance(fqc, constants.LocalContainer, nft)
+
+		scripts := []string{}
+		nft.MockApply(t, func(script string) error {
+			scripts = append(scripts, script)
+			return nil
+		})
+
+		name := func() string {
+			return tableName("pu1")
+		}
+
+		appACLs := policy.IPRuleList{
+			{Address: "10.1.0.0/16", Port: "80", Protocol: "TCP", Policy: &policy.FlowPolicy{Action: policy.Accept | policy.Log, PolicyID: "web"}},
+			{Address: "10.2.0.1", Port: "53", Protocol: "udp", Policy: &policy.FlowPolicy{Action: policy.Reject, PolicyID: "dns"}},
+			{Address: "2001:db8::/32", Protocol: "icmpv6", Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "ping"}},
+		}
+		netACLs := policy.IPRuleList{
+			{Address: "10.3.0.0/16", Port: "8000:8999", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "range"}},
+			{Address: "10.4.0.0/16", Protocol: "icmp", ICMPType: "3", ICMPCode: "4", Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "mtu"}},
+		}
+		ips := policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.2", policy.DefaultIPv6Namespace: "fd00::2"}
+
+		Convey("When I configure the rules of a container, its table should be replaced in one transaction", func() {
+			err := i.ConfigureRules(0, "pu1", containerInfo("pu1", ips, appACLs, netACLs, []string{"192.168.0.0/16"}))
+			So(err, ShouldBeNil)
+			So(scripts, ShouldHaveLength, 1)
+
+			name := tableName("pu1")
+			script := scripts[0]
+			So(script, ShouldStartWith, fmt.Sprintf("table inet %s\ndelete table inet %s\ntable inet %s {\n", name, name, name))
+
+			Convey("The base chains should send the packets of the addresses of the container to its chains", func() {
+				So(script, ShouldContainSubstring, "type filter hook prerouting priority -140; policy accept;")
+				So(script, ShouldContainSubstring, "type filter hook postrouting priority -140; policy accept;")
+				So(script, ShouldContainSubstring, "type filter hook prerouting priority -290; policy accept;")
+				So(script, ShouldContainSubstring, "ip saddr 172.17.0.2 jump pu-app\n")
+				So(script, ShouldContainSubstring, "ip6 saddr fd00::2 jump pu-app\n")
+				So(script, ShouldContainSubstring, "ip daddr 172.17.0.2 jump pu-net\n")
+				So(script, ShouldContainSubstring, "ip saddr 172.17.0.2 jump pu-app-raw\n")
+				So(ruleIndex(script, fmt.Sprintf("ct mark %d accept", constants.DefaultConnMark)), ShouldBeLessThan, ruleIndex(script, "jump pu-app"))
+			})
+
+			Convey("The target networks should be native sets", func() {
+				So(script, ShouldContainSubstring, "elements = { 0.0.0.0/1, 128.0.0.0/1 }")
+				So(script, ShouldContainSubstring, "elements = { ::/1, 8000::/1 }")
+			})
+
+			Convey("The trap rules should balance the packets over the queues", func() {
+				So(script, ShouldContainSubstring, "ip daddr @target4 tcp flags & (fin | syn | rst | psh | urg) == syn "+queue(fqc.GetApplicationQueueSynStr(), false))
+				So(script, ShouldContainSubstring, "ip daddr @target4 tcp flags & (syn | ack) == ack ct original packets <= 3 "+queue(fqc.GetApplicationQueueAckStr(), false))
+				So(script, ShouldContainSubstring, fmt.Sprintf("ip daddr @target4 meta l4proto tcp ct mark %d %s", constants.EncryptedConnMark, queue(fqc.GetApplicationQueueAckStr(), false)))
+				So(script, ShouldContainSubstring, fmt.Sprintf("ip6 saddr @target6 meta l4proto tcp ct mark %d %s", constants.EncryptedConnMark, queue(fqc.GetNetworkQueueAckStr(), false)))
+				So(script, ShouldContainSubstring, "ip6 saddr @target6 tcp flags & (syn | ack) == syn "+queue(fqc.GetNetworkQueueSynStr(), false))
+				So(script, ShouldContainSubstring, "ip saddr @target4 meta l4proto udp "+queue(fqc.GetNetworkQueueAckStr(), false))
+			})
+
+			Convey("The ACLs should be in the order of the iptables implementation", func() {
+				exclusion := ruleIndex(script, "ip saddr 172.17.0.2 ip daddr 192.168.0.0/16 accept")
+				reject := ruleIndex(script, "ip daddr 10.2.0.1 udp dport 53 ct state new drop")
+				trap := ruleIndex(script, "ip daddr @target4 tcp flags & (syn | ack) == ack")
+				log := ruleIndex(script, `ip daddr 10.1.0.0/16 tcp dport 80 ct state new log group 10 prefix "pu1:web:a"`)
+				accept := ruleIndex(script, "ip daddr 10.1.0.0/16 tcp dport 80 ct state new accept")
+				drop := ruleIndex(script, `ct state new log group 10 prefix "pu1:default:defaultr"`)
+
+				So(exclusion, ShouldBeGreaterThan, 0)
+				So(exclusion, ShouldBeLessThan, reject)
+				So(reject, ShouldBeLessThan, trap)
+				So(trap, ShouldBeLessThan, log)
+				So(log, ShouldBeLessThan, accept)
+				So(accept, ShouldBeLessThan, drop)
+
+				So(script, ShouldContainSubstring, "ip6 daddr 2001:db8::/32 meta l4proto icmpv6 accept")
+				So(script, ShouldContainSubstring, "ip saddr 10.3.0.0/16 tcp dport 8000-8999 accept")
+				So(script, ShouldContainSubstring, "ip saddr 10.4.0.0/16 meta l4proto icmp icmp type 3 icmp code 4 accept")
+				So(script, ShouldContainSubstring, "ip saddr 192.168.0.0/16 ip daddr 172.17.0.2 meta l4proto tcp tcp option 34 missing accept")
+			})
+
+			Convey("When I update the rules, the table should be replaced", func() {
+				err := i.UpdateRules(1, "pu1", containerInfo("pu1", ips, nil, nil, nil))
+				So(err, ShouldBeNil)
+				So(scripts, ShouldHaveLength, 2)
+				So(scripts[1], ShouldStartWith, fmt.Sprintf("table inet %s\ndelete table inet %s\n", name, name))
+				So(scripts[1], ShouldNotContainSubstring, "10.1.0.0/16")
+			})
+
+			Convey("When I set the target networks, the sets of all the tables should be updated", func() {
+				err := i.SetTargetNetworks(nil, []string{"10.0.0.0/8", "fd00::/8"})
+				So(err, ShouldBeNil)
+				So(scripts, ShouldHaveLength, 2)
+				So(scripts[1], ShouldStartWith, "table inet trireme\ndelete table inet trireme\ntable inet trireme {\n")
+				So(scripts[1], ShouldContainSubstring, "ip daddr @target4 tcp flags & (syn | ack) == syn | ack "+queue(fqc.GetApplicationQueueSynAckStr(), true))
+				So(scripts[1], ShouldContainSubstring, fmt.Sprintf("flush set inet %s target4\nadd element inet %s target4 { 10.0.0.0/8 }\n", name, name))
+				So(scripts[1], ShouldContainSubstring, fmt.Sprintf("flush set inet %s target6\nadd element inet %s target6 { fd00::/8 }\n", name, name))
+
+				Convey("The new tables should have the new target networks", func() {
+					So(i.ConfigureRules(0, "pu2", containerInfo("pu2", ips, nil, nil, nil)), ShouldBeNil)
+					So(scripts[2], ShouldContainSubstring, "elements = { 10.0.0.0/8 }")
+				})
+			})
+
+			Convey("When I delete the rules, the table should be deleted and not updated anymore", func() {
+				err := i.DeleteRules(0, "pu1", ips, "", "", "")
+				So(err, ShouldBeNil)
+				So(scripts[1], ShouldEqual, fmt.Sprintf("table inet %s\ndelete table inet %s\n", name, name))
+
+				So(i.SetTargetNetworks(nil, nil), ShouldBeNil)
+				So(scripts[2], ShouldNotContainSubstring, name)
+			})
+		})
+
+		Convey("When the container has no address, it should fail", func() {
+			err := i.ConfigureRules(0, "pu1", containerInfo("pu1", policy.ExtendedMap{}, nil, nil, nil))
+			So(err, ShouldNotBeNil)
+			So(scripts, ShouldBeEmpty)
+		})
+
+		Convey("When the ACLs have DNS names, the rules should match the sets of their addresses", func() {
+			i.fqdnWatcher = fqdn.NewWatcher(testResolver{"api.example.com": {net.ParseIP("192.168.1.1"), net.ParseIP("2001:db8::1")}})
+
+			err := i.ConfigureRules(0, "pu1", containerInfo("pu1", ips, policy.IPRuleList{
+				{Address: "api.example.com", Port: "443", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Accept}},
+			}, policy.IPRuleList{
+				{Address: "api.example.com", Port: "22", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Accept}},
+			}, nil))
+			So(err, ShouldBeNil)
+
+			set4, set6 := fqdnSetNames("api.example.com")
+			So(scripts[0], ShouldContainSubstring, "set "+set4+" {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\tauto-merge\n\t\telements = { 192.168.1.1 }\n")
+			So(scripts[0], ShouldContainSubstring, "elements = { 2001:db8::1 }")
+			So(scripts[0], ShouldContainSubstring, "ip daddr @"+set4+" tcp dport 443 ct state new accept")
+			So(scripts[0], ShouldContainSubstring, "ip6 daddr @"+set6+" tcp dport 443 ct state new accept")
+			So(scripts[0], ShouldNotContainSubstring, "dport 22")
+			So(i.fqdnWatcher.Names(i.fqdnOwnerPrefix), ShouldResemble, []string{"api.example.com"})
+
+			Convey("When the addresses of the name change, the sets of the table should be replaced", func() {
+				i.updateFQDN("api.example.com", []net.IP{net.ParseIP("192.168.1.2")})
+				So(scripts, ShouldHaveLength, 2)
+				So(scripts[1], ShouldEqual, fmt.Sprintf("flush set inet %s %s\nadd element inet %s %s { 192.168.1.2 }\nflush set inet %s %s\n", name(), set4, name(), set4, name(), set6))
+
+				i.updateFQDN("www.example.com", []net.IP{net.ParseIP("192.168.1.3")})
+				So(scripts, ShouldHaveLength, 2)
+			})
+
+			Convey("When I delete the rules, the name should not be watched anymore", func() {
+				So(i.DeleteRules(0, "pu1", ips, "", "", ""), ShouldBeNil)
+				So(i.fqdnWatcher.Names(i.fqdnOwnerPrefix), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the ACLs are scheduled, the table should only have the active ACLs", func() {
+			now := time.Now()
+			err := i.ConfigureRules(0, "pu1", containerInfo("pu1", ips, nil, policy.IPRuleList{
+				{Address: "10.0.0.0/8", Port: "22", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Accept, Schedule: &policy.Schedule{NotAfter: now.Add(time.Hour)}}},
+				{Address: "10.0.0.0/8", Port: "23", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Accept, Schedule: &policy.Schedule{NotBefore: now.Add(time.Hour)}}},
+			}, nil))
+			So(err, ShouldBeNil)
+			So(scripts[0], ShouldContainSubstring, "ip saddr 10.0.0.0/8 tcp dport 22 accept")
+			So(scripts[0], ShouldNotContainSubstring, "dport 23")
+
+			Convey("The table should not be replaced until the schedules change", func() {
+				i.refreshSchedules(now.Add(time.Minute))
+				So(scripts, ShouldHaveLength, 1)
+
+				i.refreshSchedules(now.Add(2 * time.Hour))
+				So(scripts, ShouldHaveLength, 2)
+				So(scripts[1], ShouldNotContainSubstring, "dport 22")
+				So(scripts[1], ShouldContainSubstring, "ip saddr 10.0.0.0/8 tcp dport 23 accept")
+
+				i.refreshSchedules(now.Add(3 * time.Hour))
+				So(scripts, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("When an ACL address is invalid, it should fail without programming the table", func() {
+			err := i.ConfigureRules(0, "pu1", containerInfo("pu1", ips, policy.IPRuleList{
+				{Address: "not a name", Port: "443", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Accept}},
+			}, nil, nil))
+			So(err, ShouldNotBeNil)
+			So(scripts, ShouldBeEmpty)
+		})
+
+		Convey("When nft fails, the error should be returned", func() {
+			nft.MockApply(t, func(script string) error {
+				return fmt.Errorf("error")
+			})
+			So(i.ConfigureRules(0, "pu1", containerInfo("pu1", ips, nil, nil, nil)), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestConfigureRulesLinuxProcesses(t *testing.T) {
+
+	Convey("Given an nftables controller of linux processes", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, nft)
+
+		scripts := []string{}
+		nft.MockApply(t, func(script string) error {
+			scripts = append(scripts, script)
+			return nil
+		})
+
+		process := func(mark, uid string, services []policy.Service) *policy.PUInfo {
+			puInfo := containerInfo("pu1", policy.ExtendedMap{}, nil, nil, nil)
+			puInfo.Runtime.SetOptions(policy.OptionsType{CgroupMark: mark, UserID: uid, Services: services})
+			return puInfo
+		}
+
+		Convey("When I configure the rules of a process, the packets of its cgroup should be sent to its chains", func() {
+			err := i.ConfigureRules(0, "pu1", process("100", "", []policy.Service{{Port: 80}, {Port: 443}}))
+			So(err, ShouldBeNil)
+			So(scripts[0], ShouldContainSubstring, "type filter hook output priority -140; policy accept;")
+			So(scripts[0], ShouldContainSubstring, "meta cgroup 100 meta mark set 100\n")
+			So(scripts[0], ShouldContainSubstring, "meta cgroup 100 jump pu-app\n")
+			So(scripts[0], ShouldContainSubstring, "tcp dport { 80, 443 } jump pu-net\n")
+			So(scripts[0], ShouldNotContainSubstring, "meta l4proto udp queue")
+		})
+
+		Convey("When the process has no mark, it should fail", func() {
+			So(i.ConfigureRules(0, "pu1", process("", "", nil)), ShouldNotBeNil)
+		})
+
+		Convey("When the process is a user session, it should fail", func() {
+			So(i.ConfigureRules(0, "pu1", process("100", "1000", nil)), ShouldNotBeNil)
+			So(scripts, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestStartStop(t *testing.T) {
+
+	Convey("Given an nftables controller", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, nft)
+
+		scripts := []string{}
+		nft.MockApply(t, func(script string) error {
+			scripts = append(scripts, script)
+			return nil
+		})
+		nft.MockListTables(t, func(family string) ([]string, error) {
+			So(family, ShouldEqual, "inet")
+			return []string{"filter", "trireme", "trireme-0123456789ab"}, nil
+		})
+
+		Convey("When I start or stop it, the tables of Trireme should be deleted", func() {
+			So(i.Start(), ShouldBeNil)
+			So(i.ConfigureRules(0, "pu1", containerInfo("pu1", policy.ExtendedMap{}, nil, nil, nil)), ShouldBeNil)
+			So(i.Stop(), ShouldBeNil)
+			So(i.contextTables.KeyList(), ShouldBeEmpty)
+			So(i.scheduleStop, ShouldBeNil)
+			scripts = append(scripts[:1], scripts[2:]...)
+			So(scripts, ShouldHaveLength, 2)
+			for _, script := range scripts {
+				So(script, ShouldEqual, "delete table inet trireme\ndelete table inet trireme-0123456789ab\n")
+			}
+		})
+	})
+}
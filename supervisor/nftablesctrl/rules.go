@@ -0,0 +1,599 @@
+package nftablesctrl
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// table is the definition of an nftables table of the inet family
+type table struct {
+	name   string
+	sets   []*set
+	chains []*chain
+}
+
+// set is a named set of networks
+type set struct {
+	name     string
+	kind     string
+	elements []string
+}
+
+// chain is a chain of a table. The base chains have a hook.
+type chain struct {
+	name     string
+	hook     string
+	priority int
+	rules    []string
+}
+
+// newTable returns an empty table
+func newTable(name string) *table {
+
+	return &table{name: name}
+}
+
+// addSet adds a set of networks to the table
+func (t *table) addSet(name, kind string, elements []string) {
+
+	t.sets = append(t.sets, &set{name: name, kind: kind, elements: elements})
+}
+
+// addChain adds a regular chain to the table
+func (t *table) addChain(name string, rules ...string) *chain {
+
+	c := &chain{name: name, rules: rules}
+	t.chains = append(t.chains, c)
+
+	return c
+}
+
+// addBaseChain adds a chain attached to a hook to the table
+func (t *table) addBaseChain(name, hook string, priority int, rules ...string) *chain {
+
+	c := t.addChain(name, rules...)
+	c.hook = hook
+	c.priority = priority
+
+	return c
+}
+
+// String returns the definition of the table in the nft syntax
+func (t *table) String() string {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "table %s %s {\n", tableFamily, t.name)
+
+	for _, s := range t.sets {
+		fmt.Fprintf(&b, "\tset %s {\n\t\ttype %s\n\t\tflags interval\n\t\tauto-merge\n", s.name, s.kind)
+		if len(s.elements) > 0 {
+			fmt.Fprintf(&b, "\t\telements = { %s }\n", strings.Join(s.elements, ", "))
+		}
+		b.WriteString("\t}\n")
+	}
+
+	for _, c := range t.chains {
+		fmt.Fprintf(&b, "\tchain %s {\n", c.name)
+		if c.hook != "" {
+			fmt.Fprintf(&b, "\t\ttype filter hook %s priority %d; policy accept;\n", c.hook, c.priority)
+		}
+		for _, r := range c.rules {
+			fmt.Fprintf(&b, "\t\t%s\n", r)
+		}
+		b.WriteString("\t}\n")
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// replaceScript returns the commands that replace the table in a single
+// transaction. The table is declared first so that the deletion never fails.
+func (t *table) replaceScript() string {
+
+	return deleteScript(t.name) + t.String()
+}
+
+// deleteScript returns the commands that delete a table
+func deleteScript(name string) string {
+
+	return fmt.Sprintf("table %s %s\ndelete table %s %s\n", tableFamily, name, tableFamily, name)
+}
+
+// updateSetsScript returns the commands that replace the elements of the
+// target network sets of a table
+func updateSetsScript(name string, networks4, networks6 []string) string {
+
+	return setScript(name, targetSet4, networks4) + setScript(name, targetSet6, networks6)
+}
+
+// setScript returns the commands that replace the elements of a set of a table
+func setScript(name, set string, elements []string) string {
+
+	script := fmt.Sprintf("flush set %s %s %s\n", tableFamily, name, set)
+	if len(elements) > 0 {
+		script += fmt.Sprintf("add element %s %s %s { %s }\n", tableFamily, name, set, strings.Join(elements, ", "))
+	}
+
+	return script
+}
+
+// addressFamily returns the nft family of an address or a network, ip or ip6
+func addressFamily(address string) (string, bool) {
+
+	ip := net.ParseIP(strings.Split(address, "/")[0])
+	if ip == nil {
+		return "", false
+	}
+
+	if ip.To4() != nil {
+		return "ip", true
+	}
+
+	return "ip6", true
+}
+
+// splitNetworks returns the IPv4 and the IPv6 networks of a list
+func splitNetworks(networks []string) (networks4, networks6 []string) {
+
+	networks4 = []string{}
+	networks6 = []string{}
+
+	for _, network := range networks {
+		switch family, _ := addressFamily(network); family {
+		case "ip":
+			networks4 = append(networks4, network)
+		case "ip6":
+			networks6 = append(networks6, network)
+		}
+	}
+
+	return networks4, networks6
+}
+
+// targetSets adds the sets of the target networks to a table
+func targetSets(t *table, networks []string) {
+
+	networks4, networks6 := splitNetworks(networks)
+
+	t.addSet(targetSet4, "ipv4_addr", networks4)
+	t.addSet(targetSet6, "ipv6_addr", networks6)
+}
+
+// targetMatches returns the matches of the target networks of both families in
+// the source or the destination of the packets
+func targetMatches(direction string) []string {
+
+	return []string{
+		"ip " + direction + " @" + targetSet4,
+		"ip6 " + direction + " @" + targetSet6,
+	}
+}
+
+// queue returns the statement that balances the packets over a range of queues
+// given like iptables, first:last
+func queue(queues string, bypass bool) string {
+
+	statement := "queue num " + strings.Replace(queues, ":", "-", 1)
+	if parts := strings.Split(queues, ":"); len(parts) == 2 && parts[0] == parts[1] {
+		statement = "queue num " + parts[0]
+	}
+
+	if bypass {
+		statement += " bypass"
+	}
+
+	return statement
+}
+
+// synAckRules returns the rules of the SynAck packets of the target networks.
+// The global table queues them and the tables of the processing units accept
+// them.
+func synAckRules(direction string, statements ...string) []string {
+
+	rules := []string{}
+	for _, match := range targetMatches(direction) {
+		rules = append(rules, match+" tcp flags & (syn | ack) == syn | ack "+strings.Join(statements, " "))
+	}
+
+	return rules
+}
+
+// acceptedRules returns the rules that accept the packets of the flows that
+// the enforcer already authorized
+func (i *Instance) acceptedRules() []string {
+
+	rules := []string{}
+
+	if i.mode == constants.LocalContainer {
+		rules = append(rules, "meta mark "+strconv.Itoa(i.fqc.GetMarkValue())+" accept")
+	}
+
+	return append(rules, "ct mark "+strconv.Itoa(int(constants.DefaultConnMark))+" accept")
+}
+
+// globalTable returns the table of the rules shared by all the processing
+// units, the capture of the SynAck packets of the target networks
+func (i *Instance) globalTable(networks []string) *table {
+
+	t := newTable(globalTableName)
+	targetSets(t, networks)
+
+	appRules := i.acceptedRules()
+	appRules = append(appRules, synAckRules("daddr", "meta mark set "+strconv.Itoa(cgnetcls.Initialmarkval-1))...)
+	appRules = append(appRules, synAckRules("daddr", queue(i.fqc.GetApplicationQueueSynAckStr(), true))...)
+	t.addBaseChain(appChain, i.appHook, manglePriority, appRules...)
+
+	netRules := []string{"ct mark " + strconv.Itoa(int(constants.DefaultConnMark)) + " accept"}
+	netRules = append(netRules, synAckRules("saddr", queue(i.fqc.GetNetworkQueueSynAckStr(), true))...)
+	t.addBaseChain(netChain, i.netHook, manglePriority, netRules...)
+
+	return t
+}
+
+// puTable returns the table of a processing unit. The base chains of the table
+// send the packets of the unit to its chains, after the accepted packets that
+// the global table doesn't hold back.
+func (i *Instance) puTable(contextID string, containerInfo *policy.PUInfo, now time.Time) (*table, error) {
+
+	policyrules := containerInfo.Policy
+
+	t := newTable(tableName(contextID))
+	targetSets(t, i.targetNetworks)
+	i.fqdnSets(t, policyrules.ApplicationACLs().FQDNs())
+
+	appJumps, netJumps, rawJumps, err := i.jumpRules(containerInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	appRules := append(i.acceptedRules(), synAckRules("daddr", "accept")...)
+	t.addBaseChain(appChain, i.appHook, manglePriority+puPriorityOffset, append(appRules, appJumps...)...)
+
+	netRules := []string{"ct mark " + strconv.Itoa(int(constants.DefaultConnMark)) + " accept"}
+	netRules = append(netRules, synAckRules("saddr", "accept")...)
+	t.addBaseChain(netChain, i.netHook, manglePriority+puPriorityOffset, append(netRules, netJumps...)...)
+
+	if i.mode == constants.LocalContainer {
+		t.addBaseChain(appRawChain, i.appHook, rawPriority+puPriorityOffset, rawJumps...)
+		t.addChain(puAppRawChain, i.rawTrapRules()...)
+	}
+
+	audit := policyrules.TriremeAction() == policy.Audit
+	addresses := i.addresses(policyrules.IPAddresses())
+
+	appACLs, err := i.aclRules(contextID, "daddr", appLogGroup, policyrules.ApplicationACLs(), audit, now)
+	if err != nil {
+		return nil, err
+	}
+
+	netACLs, err := i.aclRules(contextID, "saddr", netLogGroup, policyrules.NetworkACLs(), audit, now)
+	if err != nil {
+		return nil, err
+	}
+
+	appExclusions, netExclusions := exclusionRules(addresses, policyrules.ExcludedNetworks())
+	appTraps, netTraps := i.trapRules()
+
+	t.addChain(puAppChain, chainRules(appExclusions, appTraps, appACLs, contextID, "daddr", appLogGroup, audit)...)
+	t.addChain(puNetChain, chainRules(netExclusions, netTraps, netACLs, contextID, "saddr", netLogGroup, audit)...)
+
+	return t, nil
+}
+
+// chainRules returns the rules of a chain of a processing unit in the order of
+// the iptables implementation: the exclusions, the rejected flows, the packet
+// traps, the accepted flows, the established flows and the default rules
+func chainRules(exclusions, traps []string, acls *aclRules, contextID, direction string, group int, audit bool) []string {
+
+	rules := append([]string{}, exclusions...)
+	rules = append(rules, acls.rejects...)
+	rules = append(rules, traps...)
+	rules = append(rules, acls.accepts...)
+
+	verdict, shortAction := rejectVerdict(audit, policy.Reject.ShortActionString())
+
+	return append(rules,
+		"meta l4proto { tcp, udp } ct state established accept",
+		"ct state new "+logStatement(group, contextID+":default:default"+shortAction),
+		verdict,
+	)
+}
+
+// jumpRules returns the rules that send the packets of a processing unit to its
+// chains, the rules of the application, network and raw application chains
+func (i *Instance) jumpRules(containerInfo *policy.PUInfo) (app, net, raw []string, err error) {
+
+	if i.mode == constants.LocalServer {
+
+		options := containerInfo.Runtime.Options()
+
+		mark := options.CgroupMark
+		if mark == "" {
+			return nil, nil, nil, fmt.Errorf("No Mark value found")
+		}
+
+		port := policy.ConvertServicesToPortList(options.Services)
+
+		if port == "0" && options.UserID != "" {
+			return nil, nil, nil, fmt.Errorf("Processing units of users are not supported by the nftables implementation")
+		}
+
+		app = []string{
+			"meta cgroup " + mark + " meta mark set " + mark,
+			"meta cgroup " + mark + " jump " + puAppChain,
+		}
+		net = []string{
+			"tcp dport { " + strings.Replace(port, ",", ", ", -1) + " } jump " + puNetChain,
+		}
+
+		return app, net, nil, nil
+	}
+
+	addresses := i.addresses(containerInfo.Policy.IPAddresses())
+
+	if len(addresses) == 0 {
+		if i.mode == constants.LocalContainer {
+			return nil, nil, nil, fmt.Errorf("No ip address found")
+		}
+
+		return []string{"jump " + puAppChain}, []string{"jump " + puNetChain}, nil, nil
+	}
+
+	app, net, raw = []string{}, []string{}, []string{}
+
+	for _, address := range addresses {
+		family, _ := addressFamily(address)
+
+		app = append(app, family+" saddr "+address+" jump "+puAppChain)
+		net = append(net, family+" daddr "+address+" jump "+puNetChain)
+		raw = append(raw, family+" saddr "+address+" jump "+puAppRawChain)
+	}
+
+	return app, net, raw, nil
+}
+
+// addresses returns the IPv4 and IPv6 addresses of a processing unit
+func (i *Instance) addresses(ipAddresses policy.ExtendedMap) []string {
+
+	addresses := []string{}
+
+	for _, namespace := range []string{policy.DefaultNamespace, policy.DefaultIPv6Namespace} {
+		if address, ok := ipAddresses[namespace]; ok {
+			if _, ok := addressFamily(address); ok {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+
+	return addresses
+}
+
+// rawTrapRules returns the rules that capture the SYN packets of the
+// applications before the connection tracking of the kernel
+func (i *Instance) rawTrapRules() []string {
+
+	rules := []string{}
+
+	for _, match := range targetMatches("daddr") {
+		rules = append(rules, match+" tcp flags & (fin | syn | rst | psh | urg) == syn "+queue(i.fqc.GetApplicationQueueSynStr(), false))
+	}
+
+	return rules
+}
+
+// trapRules returns the rules that capture the control packets to the
+// enforcer, like the iptables implementation
+func (i *Instance) trapRules() (app, net []string) {
+
+	app, net = []string{}, []string{}
+
+	for _, match := range targetMatches("daddr") {
+		if i.mode == constants.LocalContainer {
+			// The SYN packets are captured in the raw chain. All the packets
+			// of the encrypted connections go to the enforcer.
+			app = append(app,
+				match+" meta l4proto tcp ct mark "+strconv.Itoa(int(constants.EncryptedConnMark))+" "+queue(i.fqc.GetApplicationQueueAckStr(), false),
+				match+" tcp flags & (syn | ack) == ack ct original packets <= 3 "+queue(i.fqc.GetApplicationQueueAckStr(), false),
+			)
+			continue
+		}
+
+		app = append(app,
+			match+" tcp flags & (syn | ack) == syn "+queue(i.fqc.GetApplicationQueueSynStr(), false),
+			match+" tcp flags & (syn | ack) == ack "+queue(i.fqc.GetApplicationQueueAckStr(), false),
+		)
+	}
+
+	for _, match := range targetMatches("saddr") {
+		net = append(net, match+" tcp flags & (syn | ack) == syn "+queue(i.fqc.GetNetworkQueueSynStr(), false))
+
+		if i.mode == constants.LocalContainer {
+			net = append(net,
+				match+" meta l4proto tcp ct mark "+strconv.Itoa(int(constants.EncryptedConnMark))+" "+queue(i.fqc.GetNetworkQueueAckStr(), false),
+				match+" meta l4proto tcp ct original packets <= 3 "+queue(i.fqc.GetNetworkQueueAckStr(), false),
+			)
+			continue
+		}
+
+		net = append(net, match+" tcp flags & (syn | ack) == ack "+queue(i.fqc.GetNetworkQueueAckStr(), false))
+	}
+
+	// UDP flows are authorized on their data packets. Linux processes only
+	// capture TCP traffic at the network and are not included.
+	if i.mode != constants.LocalServer {
+		for _, match := range targetMatches("daddr") {
+			app = append(app, match+" meta l4proto udp "+queue(i.fqc.GetApplicationQueueAckStr(), false))
+		}
+		for _, match := range targetMatches("saddr") {
+			net = append(net, match+" meta l4proto udp "+queue(i.fqc.GetNetworkQueueAckStr(), false))
+		}
+	}
+
+	return app, net
+}
+
+// aclRules are the rules of the ACLs of a chain
+type aclRules struct {
+	rejects []string
+	accepts []string
+}
+
+// aclRules returns the rules of a list of ACLs on the remote address of the
+// flows. In audit mode the rejected flows are logged as observed and accepted.
+// The application ACLs on DNS names match the sets of the addresses of the
+// names in both IP families, like the iptables implementation that ignores
+// the network ACLs on names. The scheduled ACLs are only added while they are
+// active at the given time.
+func (i *Instance) aclRules(contextID, direction string, group int, rules policy.IPRuleList, audit bool, now time.Time) (*aclRules, error) {
+
+	acls := &aclRules{rejects: []string{}, accepts: []string{}}
+
+	for _, rule := range rules {
+
+		if rule.Policy == nil || !rule.Policy.Schedule.Active(now) {
+			continue
+		}
+
+		addresses := []string{}
+		if family, ok := addressFamily(rule.Address); ok {
+			addresses = append(addresses, family+" "+direction+" "+rule.Address)
+		} else if name := rule.FQDN(); name == "" {
+			return nil, fmt.Errorf("Invalid ACL address %s", rule.Address)
+		} else if direction == "daddr" {
+			addresses = append(addresses, fqdnMatches(name)...)
+		}
+
+		icmpType, icmpCode, err := rule.ICMPTypeCode()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ICMP ACL: %s", err)
+		}
+
+		for _, match := range addresses {
+
+			proto := strings.ToLower(rule.Protocol)
+			switch proto {
+			case "tcp", "udp", "sctp":
+				if rule.Port != "" {
+					match += " " + proto + " dport " + strings.Replace(rule.Port, ":", "-", 1)
+				} else {
+					match += " meta l4proto " + proto
+				}
+				// The accepted application flows are matched on their first packet
+				if direction == "daddr" || rule.Policy.Action&policy.Reject != 0 {
+					match += " ct state new"
+				}
+			case "", "all":
+			default:
+				match += " meta l4proto " + proto
+				if icmpType >= 0 {
+					match += " " + icmpHeader(rule) + " type " + strconv.Itoa(icmpType)
+				}
+				if icmpCode >= 0 {
+					match += " " + icmpHeader(rule) + " code " + strconv.Itoa(icmpCode)
+				}
+			}
+
+			prefix := contextID + ":" + rule.Policy.PolicyID + ":" + rule.Policy.ServiceID
+
+			switch rule.Policy.Action & (policy.Accept | policy.Reject) {
+			case policy.Accept:
+				if rule.Policy.Action&policy.Log > 0 {
+					acls.accepts = append(acls.accepts, logRule(match, group, prefix+rule.Policy.Action.ShortActionString()))
+				}
+				acls.accepts = append(acls.accepts, match+" accept")
+
+			case policy.Reject:
+				verdict, shortAction := rejectVerdict(audit, rule.Policy.Action.ShortActionString())
+				if rule.Policy.Action&policy.Log > 0 || audit {
+					acls.rejects = append(acls.rejects, logRule(match, group, prefix+shortAction))
+				}
+				acls.rejects = append(acls.rejects, match+" "+verdict)
+			}
+		}
+	}
+
+	return acls, nil
+}
+
+// icmpHeader returns the header of the ICMP messages of a rule
+func icmpHeader(rule policy.IPRule) string {
+
+	if number, _ := policy.ProtocolNumber(rule.Protocol); number == policy.ProtocolICMPv6 {
+		return "icmpv6"
+	}
+
+	return "icmp"
+}
+
+// logRule returns the rule that logs the new flows of a match
+func logRule(match string, group int, prefix string) string {
+
+	if !strings.HasSuffix(match, " ct state new") {
+		match += " ct state new"
+	}
+
+	return match + " " + logStatement(group, prefix)
+}
+
+// logStatement returns the statement that logs packets to an nflog group
+func logStatement(group int, prefix string) string {
+
+	return "log group " + strconv.Itoa(group) + " prefix " + strconv.Quote(prefix)
+}
+
+// rejectVerdict returns the verdict and the log short action of the rejected
+// flows. The flows rejected by a PU in audit mode are accepted and logged as
+// observed.
+func rejectVerdict(audit bool, shortAction string) (string, string) {
+
+	if audit {
+		return "accept", policy.ObservedShortAction
+	}
+
+	return "drop", shortAction
+}
+
+// exclusionRules returns the rules that accept the traffic with the excluded
+// networks. The network packets with the Trireme TCP option are still
+// captured.
+func exclusionRules(addresses, exclusions []string) (app, net []string) {
+
+	app, net = []string{}, []string{}
+	option := strconv.Itoa(int(packet.TCPAuthenticationOption))
+
+	for _, e := range exclusions {
+
+		family, ok := addressFamily(e)
+		if !ok {
+			continue
+		}
+
+		sources := []string{""}
+		if len(addresses) > 0 {
+			sources = []string{}
+			for _, address := range addresses {
+				if f, _ := addressFamily(address); f == family {
+					sources = append(sources, family+" saddr "+address+" ")
+				}
+			}
+		}
+
+		for _, source := range sources {
+			app = append(app, source+family+" daddr "+e+" accept")
+			net = append(net, family+" saddr "+e+" "+strings.Replace(source, "saddr", "daddr", 1)+"meta l4proto tcp tcp option "+option+" missing accept")
+		}
+	}
+
+	return app, net
+}
@@ -0,0 +1,73 @@
+package nftablesctrl
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// scheduleInterval is the interval between two checks of the scheduled ACLs
+const scheduleInterval = time.Second
+
+// scheduleState returns the states of the scheduled ACLs of a processing unit
+// at the given time, one character for each ACL. It is empty if the
+// processing unit has no scheduled ACLs.
+func scheduleState(containerInfo *policy.PUInfo, now time.Time) string {
+
+	state := []byte{}
+
+	for _, rules := range []policy.IPRuleList{containerInfo.Policy.ApplicationACLs(), containerInfo.Policy.NetworkACLs()} {
+		for _, rule := range rules {
+			if rule.Policy == nil || rule.Policy.Schedule == nil {
+				continue
+			}
+			if rule.Policy.Schedule.Active(now) {
+				state = append(state, '1')
+			} else {
+				state = append(state, '0')
+			}
+		}
+	}
+
+	return string(state)
+}
+
+// runSchedules refreshes the tables of the scheduled ACLs until the channel is
+// closed
+func (i *Instance) runSchedules(stopCh chan struct{}) {
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			i.refreshSchedules(now)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// refreshSchedules replaces the tables of the processing units whose scheduled
+// ACLs were activated or expired since their tables were programmed
+func (i *Instance) refreshSchedules(now time.Time) {
+
+	i.Lock()
+	defer i.Unlock()
+
+	for _, key := range i.contextTables.KeyList() {
+		contextID := key.(string)
+
+		ct, ok := i.contextTable(contextID)
+		if !ok || ct.schedules == "" || scheduleState(ct.containerInfo, now) == ct.schedules {
+			continue
+		}
+
+		if err := i.replaceTable(contextID, ct.containerInfo, now); err != nil {
+			zap.L().Warn("Failed to update the scheduled ACLs", zap.String("contextID", contextID), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package provider
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// batchOperation is an operation on a chain of a table and the lines that undo
+// it. The chains created by the operations are deleted last.
+type batchOperation struct {
+	table        string
+	chain        string
+	line         string
+	inverse      []string
+	creates      bool
+	irreversible bool
+}
+
+// chainSnapshot holds the rules of a chain before the operations
+type chainSnapshot struct {
+	table string
+	chain string
+	rules []string
+}
+
+// BatchProvider is an iptables provider that accumulates the operations on the
+// rules and commits them in a single iptables-restore, without flushing the
+// tables. If the restore fails, the operations of the tables that were
+// committed are undone. The chains are listed by the underlying provider.
+type BatchProvider struct {
+	ipt        IptablesProvider
+	restore    IptablesRestore
+	operations []*batchOperation
+
+	// created are the chains created by the operations, per table
+	created map[string]map[string]bool
+
+	// snapshots are the rules of the chains that existed before they were
+	// cleared, deleted or created again by the operations
+	snapshots []*chainSnapshot
+
+	// chains are the chains of the tables before the operations
+	chains map[string]map[string]bool
+}
+
+// NewBatchProvider returns a provider that commits the operations with the
+// given restore
+func NewBatchProvider(ipt IptablesProvider, restore IptablesRestore) *BatchProvider {
+
+	return &BatchProvider{
+		ipt:     ipt,
+		restore: restore,
+		created: map[string]map[string]bool{},
+		chains:  map[string]map[string]bool{},
+	}
+}
+
+// isCreated returns true if the chain is created by the operations. The
+// operations on the rules of these chains are undone when the chain is
+// deleted.
+func (b *BatchProvider) isCreated(table, chain string) bool {
+
+	return b.created[table][chain]
+}
+
+// isSaved returns true if the rules of the chain before the operations are
+// saved. The operations on the rules of these chains are undone when the
+// rules are restored.
+func (b *BatchProvider) isSaved(table, chain string) bool {
+
+	for _, snapshot := range b.snapshots {
+		if snapshot.table == table && snapshot.chain == chain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isUndone returns true if the chain is created or saved, so that the
+// operations on its rules are not undone one by one
+func (b *BatchProvider) isUndone(table, chain string) bool {
+
+	return b.isCreated(table, chain) || b.isSaved(table, chain)
+}
+
+// exists returns true if the chain existed before the operations. The chains
+// of each table are listed once.
+func (b *BatchProvider) exists(table, chain string) (bool, error) {
+
+	if _, ok := b.chains[table]; !ok {
+		chains, err := b.ipt.ListChains(table)
+		if err != nil {
+			return false, err
+		}
+
+		b.chains[table] = map[string]bool{}
+		for _, c := range chains {
+			b.chains[table][c] = true
+		}
+	}
+
+	return b.chains[table][chain], nil
+}
+
+// save saves the rules of a chain that existed before the operations, so that
+// they are restored if the operations are undone. The previous operations on
+// the rules of the chain are not undone anymore. It returns false if the
+// chain didn't exist.
+func (b *BatchProvider) save(table, chain string) (bool, error) {
+
+	exists, err := b.exists(table, chain)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	list, err := b.ipt.List(table, chain)
+	if err != nil {
+		return false, err
+	}
+
+	rules := []string{}
+	for _, rule := range list {
+		if strings.HasPrefix(rule, "-A ") {
+			rules = append(rules, rule)
+		}
+	}
+
+	b.snapshots = append(b.snapshots, &chainSnapshot{table: table, chain: chain, rules: rules})
+
+	for _, op := range b.operations {
+		if op.table == table && op.chain == chain {
+			op.inverse = nil
+		}
+	}
+
+	return true, nil
+}
+
+// add adds an operation
+func (b *BatchProvider) add(table, chain, line string, inverse ...string) *batchOperation {
+
+	op := &batchOperation{
+		table:   table,
+		chain:   chain,
+		line:    line,
+		inverse: inverse,
+	}
+
+	b.operations = append(b.operations, op)
+
+	return op
+}
+
+// Append appends a rule to a chain
+func (b *BatchProvider) Append(table, chain string, rulespec ...string) error {
+
+	if b.isUndone(table, chain) {
+		b.add(table, chain, "-A "+chain+" "+quoteRule(rulespec))
+		return nil
+	}
+
+	b.add(table, chain, "-A "+chain+" "+quoteRule(rulespec), "-D "+chain+" "+quoteRule(rulespec))
+
+	return nil
+}
+
+// Insert inserts a rule in a chain at the given position, starting at 1
+func (b *BatchProvider) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	line := "-I " + chain + " " + strconv.Itoa(pos) + " " + quoteRule(rulespec)
+
+	if b.isUndone(table, chain) {
+		b.add(table, chain, line)
+		return nil
+	}
+
+	b.add(table, chain, line, "-D "+chain+" "+quoteRule(rulespec))
+
+	return nil
+}
+
+// Delete deletes the first rule of a chain that matches the rulespec. The rule
+// is appended again if the operations are undone, so the rules that send the
+// traffic to the chains are restored but not the order of the rules. A rule
+// deleted by its number can't be appended again, so the rules of its chain are
+// saved instead.
+func (b *BatchProvider) Delete(table, chain string, rulespec ...string) error {
+
+	line := "-D " + chain + " " + quoteRule(rulespec)
+
+	if b.isUndone(table, chain) {
+		b.add(table, chain, line)
+		return nil
+	}
+
+	if _, ok := ruleNumber(rulespec); ok {
+		if _, err := b.save(table, chain); err != nil {
+			b.add(table, chain, line).irreversible = true
+			return nil
+		}
+		b.add(table, chain, line)
+		return nil
+	}
+
+	b.add(table, chain, line, "-A "+chain+" "+quoteRule(rulespec))
+
+	return nil
+}
+
+// Exists checks the rule with the underlying provider. The operations that
+// are not committed are ignored.
+func (b *BatchProvider) Exists(table, chain string, rulespec ...string) (bool, error) {
+
+	return b.ipt.Exists(table, chain, rulespec...)
+}
+
+// List lists the rules of a chain with the underlying provider. The
+// operations that are not committed are ignored.
+func (b *BatchProvider) List(table, chain string) ([]string, error) {
+
+	return b.ipt.List(table, chain)
+}
+
+// ListChains lists the chains of a table with the underlying provider
+func (b *BatchProvider) ListChains(table string) ([]string, error) {
+
+	return b.ipt.ListChains(table)
+}
+
+// ClearChain removes the rules of a chain. The chain is created if needed.
+// The rules of a chain that existed before the operations are saved and
+// restored if the operations are undone.
+func (b *BatchProvider) ClearChain(table, chain string) error {
+
+	line := ":" + chain + " - [0:0]"
+	if isBuiltin(table, chain) {
+		line = "-F " + chain
+	}
+
+	if b.isUndone(table, chain) {
+		b.add(table, chain, line)
+		return nil
+	}
+
+	existed, err := b.save(table, chain)
+	if err != nil {
+		b.add(table, chain, line).irreversible = true
+		return nil
+	}
+
+	if existed {
+		b.add(table, chain, line)
+		return nil
+	}
+
+	return b.NewChain(table, chain)
+}
+
+// DeleteChain deletes a chain. The chain is created again if the operations
+// are undone, with its rules if it existed before the operations.
+func (b *BatchProvider) DeleteChain(table, chain string) error {
+
+	line := "-X " + chain
+
+	if b.isUndone(table, chain) {
+		b.add(table, chain, line, ":"+chain+" - [0:0]")
+		delete(b.created[table], chain)
+		return nil
+	}
+
+	existed, err := b.save(table, chain)
+	switch {
+	case err != nil:
+		b.add(table, chain, line).irreversible = true
+	case existed:
+		b.add(table, chain, line, ":"+chain+" - [0:0]")
+	default:
+		b.add(table, chain, line)
+	}
+
+	return nil
+}
+
+// NewChain creates a chain. The chain is flushed if it exists. The rules of a
+// chain that existed before the operations are saved and restored if the
+// operations are undone.
+func (b *BatchProvider) NewChain(table, chain string) error {
+
+	line := ":" + chain + " - [0:0]"
+
+	if b.isUndone(table, chain) {
+		b.add(table, chain, line)
+		return nil
+	}
+
+	existed, err := b.save(table, chain)
+	if err != nil {
+		b.add(table, chain, line).irreversible = true
+		return nil
+	}
+
+	if existed {
+		b.add(table, chain, line)
+		return nil
+	}
+
+	b.add(table, chain, line, "-F "+chain).creates = true
+
+	if _, ok := b.created[table]; !ok {
+		b.created[table] = map[string]bool{}
+	}
+	b.created[table][chain] = true
+
+	return nil
+}
+
+// tables returns the tables of the operations in the order they are used
+func (b *BatchProvider) tables(operations []*batchOperation) []string {
+
+	tables := []string{}
+	seen := map[string]bool{}
+
+	for _, op := range operations {
+		if !seen[op.table] {
+			seen[op.table] = true
+			tables = append(tables, op.table)
+		}
+	}
+
+	return tables
+}
+
+// Script returns the script of the operations that are not committed
+func (b *BatchProvider) Script() string {
+
+	var s strings.Builder
+
+	for _, table := range b.tables(b.operations) {
+		s.WriteString("*" + table + "\n")
+		for _, op := range b.operations {
+			if op.table == table {
+				s.WriteString(op.line + "\n")
+			}
+		}
+		s.WriteString("COMMIT\n")
+	}
+
+	return s.String()
+}
+
+// Commit programs the operations in a single restore. The operations are
+// undone if the restore fails.
+func (b *BatchProvider) Commit() error {
+
+	if len(b.operations) == 0 {
+		return nil
+	}
+
+	script := b.Script()
+	operations := b.operations
+	snapshots := b.snapshots
+
+	b.operations = nil
+	b.snapshots = nil
+	b.created = map[string]map[string]bool{}
+	b.chains = map[string]map[string]bool{}
+
+	if err := b.restore.Restore(script); err != nil {
+		b.rollback(operations, snapshots)
+		return fmt.Errorf("Failed to commit the rules: %s", err)
+	}
+
+	return nil
+}
+
+// rollback undoes the operations of each table. The saved chains get their
+// rules back after the other operations are undone, when all the chains that
+// their rules jump to exist again, and the created chains are deleted once
+// no rule jumps to them anymore. Each table is committed atomically, so the
+// operations of the tables that were not committed fail to be undone and are
+// ignored.
+func (b *BatchProvider) rollback(operations []*batchOperation, snapshots []*chainSnapshot) {
+
+	tables := b.tables(operations)
+
+	for t := len(tables) - 1; t >= 0; t-- {
+		table := tables[t]
+
+		var s strings.Builder
+		s.WriteString("*" + table + "\n")
+
+		for index := len(operations) - 1; index >= 0; index-- {
+			op := operations[index]
+			if op.table != table {
+				continue
+			}
+
+			if op.irreversible {
+				zap.L().Warn("Cannot undo an operation on the rules", zap.String("table", table), zap.String("operation", op.line))
+			}
+
+			for _, line := range op.inverse {
+				s.WriteString(line + "\n")
+			}
+		}
+
+		for _, snapshot := range snapshots {
+			if snapshot.table != table {
+				continue
+			}
+			if isBuiltin(table, snapshot.chain) {
+				s.WriteString("-F " + snapshot.chain + "\n")
+			} else {
+				s.WriteString(":" + snapshot.chain + " - [0:0]\n")
+			}
+		}
+
+		for _, snapshot := range snapshots {
+			if snapshot.table != table {
+				continue
+			}
+			for _, rule := range snapshot.rules {
+				s.WriteString(rule + "\n")
+			}
+		}
+
+		deleted := map[string]bool{}
+		for index := len(operations) - 1; index >= 0; index-- {
+			op := operations[index]
+			if op.table == table && op.creates && !deleted[op.chain] {
+				deleted[op.chain] = true
+				s.WriteString("-X " + op.chain + "\n")
+			}
+		}
+
+		s.WriteString("COMMIT\n")
+
+		if err := b.restore.Restore(s.String()); err != nil {
+			zap.L().Debug("Did not undo the operations on the table", zap.String("table", table), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// partialRestore commits the first table of a script and fails. The next
+// restores are programmed normally.
+type partialRestore struct {
+	m      *MemoryProvider
+	failed bool
+}
+
+func (r *partialRestore) Restore(script string) error {
+
+	if r.failed {
+		return r.m.Restore(script)
+	}
+
+	r.failed = true
+
+	if err := r.m.Restore(script[:strings.Index(script, "COMMIT\n")+len("COMMIT\n")]); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("restore failed")
+}
+
+func TestMemoryProviderRestore(t *testing.T) {
+
+	Convey("Given a memory provider", t, func() {
+		m := NewMemoryProvider()
+		So(m.NewChain("mangle", "PU"), ShouldBeNil)
+		So(m.Append("mangle", "PU", "-j", "DROP"), ShouldBeNil)
+
+		Convey("The lines of a script should be programmed without flushing the tables", func() {
+			So(m.Restore("# comment\n"+
+				"*mangle\n"+
+				":INPUT ACCEPT [0:0]\n"+
+				":NEW - [0:0]\n"+
+				"-A NEW -m comment --comment \"a comment\" -j ACCEPT\n"+
+				"-I PU 1 -p tcp -j RETURN\n"+
+				"-A INPUT -j PU\n"+
+				"COMMIT\n"), ShouldBeNil)
+
+			rules, err := m.Rules("mangle", "NEW")
+			So(err, ShouldBeNil)
+			So(rules, ShouldResemble, [][]string{{"-m", "comment", "--comment", "a comment", "-j", "ACCEPT"}})
+
+			rules, err = m.Rules("mangle", "PU")
+			So(err, ShouldBeNil)
+			So(rules, ShouldResemble, [][]string{{"-p", "tcp", "-j", "RETURN"}, {"-j", "DROP"}})
+		})
+
+		Convey("A table should not change if a line of the table fails", func() {
+			before := m.Save()
+
+			So(m.Restore("*raw\n:RAW - [0:0]\nCOMMIT\n*mangle\n-A INPUT -j PU\n-D PU -j ACCEPT\nCOMMIT\n"), ShouldNotBeNil)
+
+			chains, err := m.ListChains("raw")
+			So(err, ShouldBeNil)
+			So(chains, ShouldContain, "RAW")
+
+			So(m.SaveChains("PU"), ShouldEqual, "*mangle\n"+
+				":PREROUTING ACCEPT [0:0]\n:INPUT ACCEPT [0:0]\n:FORWARD ACCEPT [0:0]\n:OUTPUT ACCEPT [0:0]\n:POSTROUTING ACCEPT [0:0]\n"+
+				":PU - [0:0]\n"+
+				"-A PU -j DROP\n"+
+				"COMMIT\n")
+			So(m.Save(), ShouldNotEqual, before)
+		})
+
+		Convey("A table that is not committed should not change", func() {
+			So(m.Restore("*mangle\n-F PU\n"), ShouldNotBeNil)
+
+			rules, err := m.Rules("mangle", "PU")
+			So(err, ShouldBeNil)
+			So(rules, ShouldHaveLength, 1)
+		})
+	})
+}
+
+func TestBatchProvider(t *testing.T) {
+
+	Convey("Given a batch provider on a memory provider", t, func() {
+		m := NewMemoryProvider()
+		So(m.NewChain("mangle", "OLD"), ShouldBeNil)
+		So(m.Append("mangle", "INPUT", "-j", "OLD"), ShouldBeNil)
+
+		before := m.Save()
+
+		b := NewBatchProvider(m, m)
+		So(b.NewChain("raw", "PU"), ShouldBeNil)
+		So(b.Append("raw", "PU", "-m", "comment", "--comment", "a comment", "-j", "ACCEPT"), ShouldBeNil)
+		So(b.Insert("raw", "PREROUTING", 1, "-j", "PU"), ShouldBeNil)
+		So(b.NewChain("mangle", "PU"), ShouldBeNil)
+		So(b.Append("mangle", "PU", "-j", "DROP"), ShouldBeNil)
+		So(b.Append("mangle", "INPUT", "-j", "PU"), ShouldBeNil)
+		So(b.Delete("mangle", "INPUT", "-j", "OLD"), ShouldBeNil)
+
+		Convey("The operations should be grouped by table", func() {
+			So(b.Script(), ShouldEqual, "*raw\n"+
+				":PU - [0:0]\n"+
+				"-A PU -m comment --comment \"a comment\" -j ACCEPT\n"+
+				"-I PREROUTING 1 -j PU\n"+
+				"COMMIT\n"+
+				"*mangle\n"+
+				":PU - [0:0]\n"+
+				"-A PU -j DROP\n"+
+				"-A INPUT -j PU\n"+
+				"-D INPUT -j OLD\n"+
+				"COMMIT\n")
+		})
+
+		Convey("The operations should only be programmed when they are committed", func() {
+			So(m.Save(), ShouldEqual, before)
+
+			So(b.Commit(), ShouldBeNil)
+			So(b.Script(), ShouldBeEmpty)
+
+			rules, err := m.Rules("mangle", "INPUT")
+			So(err, ShouldBeNil)
+			So(rules, ShouldResemble, [][]string{{"-j", "PU"}})
+
+			rules, err = m.Rules("raw", "PREROUTING")
+			So(err, ShouldBeNil)
+			So(rules, ShouldResemble, [][]string{{"-j", "PU"}})
+		})
+
+		Convey("The tables that were committed should be restored if the commit fails", func() {
+			b.restore = &partialRestore{m: m}
+
+			So(b.Commit(), ShouldNotBeNil)
+			So(m.Save(), ShouldEqual, before)
+		})
+
+		Convey("The operations should be undone if a later table fails", func() {
+			So(b.Delete("mangle", "INPUT", "-j", "MISSING"), ShouldBeNil)
+
+			So(b.Commit(), ShouldNotBeNil)
+			So(m.Save(), ShouldEqual, before)
+		})
+	})
+}
+
+func TestBatchProviderExistingChains(t *testing.T) {
+
+	Convey("Given a batch provider on a memory provider with chains", t, func() {
+		m := NewMemoryProvider()
+		So(m.NewChain("mangle", "OLD"), ShouldBeNil)
+		So(m.Append("mangle", "OLD", "-p", "tcp", "-j", "ACCEPT"), ShouldBeNil)
+		So(m.Append("mangle", "OLD", "-j", "DROP"), ShouldBeNil)
+		So(m.Append("mangle", "INPUT", "-j", "OLD"), ShouldBeNil)
+		So(m.NewChain("mangle", "KEPT"), ShouldBeNil)
+		So(m.Append("mangle", "KEPT", "-j", "RETURN"), ShouldBeNil)
+		So(m.Append("mangle", "OUTPUT", "-j", "KEPT"), ShouldBeNil)
+
+		before := m.Save()
+
+		b := NewBatchProvider(m, m)
+
+		Convey("The rules of the chains that are cleared or deleted should be restored if the commit fails", func() {
+			So(b.Append("mangle", "OLD", "-j", "RETURN"), ShouldBeNil)
+			So(b.ClearChain("mangle", "OLD"), ShouldBeNil)
+			So(b.Append("mangle", "OLD", "-j", "ACCEPT"), ShouldBeNil)
+			So(b.Delete("mangle", "INPUT", "-j", "OLD"), ShouldBeNil)
+			So(b.ClearChain("mangle", "OLD"), ShouldBeNil)
+			So(b.DeleteChain("mangle", "OLD"), ShouldBeNil)
+			So(b.Delete("raw", "PREROUTING", "-j", "MISSING"), ShouldBeNil)
+
+			So(b.Commit(), ShouldNotBeNil)
+			So(m.Save(), ShouldEqual, before)
+		})
+
+		Convey("The rules of a chain that is created again should be restored if the commit fails", func() {
+			So(b.NewChain("mangle", "KEPT"), ShouldBeNil)
+			So(b.Append("mangle", "KEPT", "-j", "ACCEPT"), ShouldBeNil)
+			So(b.NewChain("mangle", "NEW"), ShouldBeNil)
+			So(b.Insert("mangle", "KEPT", 1, "-j", "NEW"), ShouldBeNil)
+			So(b.Delete("raw", "PREROUTING", "-j", "MISSING"), ShouldBeNil)
+
+			So(b.Commit(), ShouldNotBeNil)
+			So(m.Save(), ShouldEqual, before)
+		})
+
+		Convey("The rules deleted by their number should be restored in order if the commit fails", func() {
+			So(b.Insert("mangle", "OLD", 3, "-p", "tcp", "-j", "ACCEPT"), ShouldBeNil)
+			So(b.Delete("mangle", "OLD", "1"), ShouldBeNil)
+			So(b.Delete("raw", "PREROUTING", "-j", "MISSING"), ShouldBeNil)
+
+			So(b.Commit(), ShouldNotBeNil)
+			So(m.Save(), ShouldEqual, before)
+		})
+
+		Convey("The rules should be deleted by their number", func() {
+			So(b.Insert("mangle", "OLD", 3, "-p", "tcp", "-j", "ACCEPT"), ShouldBeNil)
+			So(b.Delete("mangle", "OLD", "1"), ShouldBeNil)
+			So(b.Commit(), ShouldBeNil)
+
+			rules, err := m.Rules("mangle", "OLD")
+			So(err, ShouldBeNil)
+			So(rules, ShouldResemble, [][]string{{"-j", "DROP"}, {"-p", "tcp", "-j", "ACCEPT"}})
+		})
+
+		Convey("The operations on the chains should be committed", func() {
+			So(b.NewChain("mangle", "KEPT"), ShouldBeNil)
+			So(b.Append("mangle", "KEPT", "-j", "ACCEPT"), ShouldBeNil)
+			So(b.Commit(), ShouldBeNil)
+
+			rules, err := m.Rules("mangle", "KEPT")
+			So(err, ShouldBeNil)
+			So(rules, ShouldResemble, [][]string{{"-j", "ACCEPT"}})
+		})
+	})
+}
+
+func TestRestoreVersion(t *testing.T) {
+
+	Convey("Given the versions of iptables-restore, the wait option should be detected", t, func() {
+		v1, v2, v3, err := extractVersion("iptables-restore v1.8.4 (legacy)\n")
+		So(err, ShouldBeNil)
+		So([]int{v1, v2, v3}, ShouldResemble, []int{1, 8, 4})
+		So(restoreHasWait(v1, v2, v3), ShouldBeTrue)
+
+		So(restoreHasWait(1, 6, 2), ShouldBeTrue)
+		So(restoreHasWait(1, 6, 1), ShouldBeFalse)
+		So(restoreHasWait(1, 4, 21), ShouldBeFalse)
+
+		_, _, _, err = extractVersion("unknown")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -1,6 +1,9 @@
 package provider
 
-import "github.com/coreos/go-iptables/iptables"
+import (
+	"github.com/coreos/go-iptables/iptables"
+	"go.uber.org/zap"
+)
 
 // IptablesProvider is an abstraction of all the methods an implementation of userspace
 // iptables need to provide.
@@ -8,14 +11,58 @@ type IptablesProvider interface {
 	Append(table, chain string, rulespec ...string) error
 	Insert(table, chain string, pos int, rulespec ...string) error
 	Delete(table, chain string, rulespec ...string) error
+	Exists(table, chain string, rulespec ...string) (bool, error)
+	List(table, chain string) ([]string, error)
 	ListChains(table string) ([]string, error)
 	ClearChain(table, chain string) error
 	DeleteChain(table, chain string) error
 	NewChain(table, chain string) error
 }
 
+// goIptablesProvider is a go-iptables provider that also programs the rules
+// with iptables-restore
+type goIptablesProvider struct {
+	*iptables.IPTables
+	IptablesRestore
+}
+
 // NewGoIPTablesProvider returns an IptablesProvider interface based on the go-iptables
-// external package.
+// external package. The provider is also an IptablesRestore if iptables-restore
+// is available.
 func NewGoIPTablesProvider() (IptablesProvider, error) {
-	return iptables.New()
+
+	ipt, err := iptables.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return withRestore(ipt, NewIptablesRestore)
+}
+
+// NewGoIP6TablesProvider returns an IptablesProvider interface for ip6tables based
+// on the go-iptables external package. The provider is also an IptablesRestore
+// if ip6tables-restore is available.
+func NewGoIP6TablesProvider() (IptablesProvider, error) {
+
+	ipt, err := iptables.NewWithProtocol(iptables.ProtocolIPv6)
+	if err != nil {
+		return nil, err
+	}
+
+	return withRestore(ipt, NewIp6tablesRestore)
+}
+
+// withRestore adds the restore to the provider when it is available
+func withRestore(ipt *iptables.IPTables, newRestore func() (IptablesRestore, error)) (IptablesProvider, error) {
+
+	restore, err := newRestore()
+	if err != nil {
+		zap.L().Warn("Programming the rules one at a time", zap.Error(err))
+		return ipt, nil
+	}
+
+	return &goIptablesProvider{
+		IPTables:        ipt,
+		IptablesRestore: restore,
+	}, nil
 }
@@ -9,6 +9,8 @@ type iptablesProviderMockedMethods struct {
 	appendMock      func(table, chain string, rulespec ...string) error
 	insertMock      func(table, chain string, pos int, rulespec ...string) error
 	deleteMock      func(table, chain string, rulespec ...string) error
+	existsMock      func(table, chain string, rulespec ...string) (bool, error)
+	listMock        func(table, chain string) ([]string, error)
 	listChainsMock  func(table string) ([]string, error)
 	clearChainMock  func(table, chain string) error
 	deleteChainMock func(table, chain string) error
@@ -21,6 +23,8 @@ type TestIptablesProvider interface {
 	MockAppend(t *testing.T, impl func(table, chain string, rulespec ...string) error)
 	MockInsert(t *testing.T, impl func(table, chain string, pos int, rulespec ...string) error)
 	MockDelete(t *testing.T, impl func(table, chain string, rulespec ...string) error)
+	MockExists(t *testing.T, impl func(table, chain string, rulespec ...string) (bool, error))
+	MockList(t *testing.T, impl func(table, chain string) ([]string, error))
 	MockListChains(t *testing.T, impl func(table string) ([]string, error))
 	MockClearChain(t *testing.T, impl func(table, chain string) error)
 	MockDeleteChain(t *testing.T, impl func(table, chain string) error)
@@ -57,6 +61,16 @@ func (m *testIptablesProvider) MockDelete(t *testing.T, impl func(table, chain s
 	m.currentMocks(t).deleteMock = impl
 }
 
+func (m *testIptablesProvider) MockExists(t *testing.T, impl func(table, chain string, rulespec ...string) (bool, error)) {
+
+	m.currentMocks(t).existsMock = impl
+}
+
+func (m *testIptablesProvider) MockList(t *testing.T, impl func(table, chain string) ([]string, error)) {
+
+	m.currentMocks(t).listMock = impl
+}
+
 func (m *testIptablesProvider) MockListChains(t *testing.T, impl func(table string) ([]string, error)) {
 
 	m.currentMocks(t).listChainsMock = impl
@@ -104,6 +118,24 @@ func (m *testIptablesProvider) Delete(table, chain string, rulespec ...string) e
 	return nil
 }
 
+func (m *testIptablesProvider) Exists(table, chain string, rulespec ...string) (bool, error) {
+
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.existsMock != nil {
+		return mock.existsMock(table, chain, rulespec...)
+	}
+
+	return true, nil
+}
+
+func (m *testIptablesProvider) List(table, chain string) ([]string, error) {
+
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.listMock != nil {
+		return mock.listMock(table, chain)
+	}
+
+	return nil, nil
+}
+
 func (m *testIptablesProvider) ListChains(table string) ([]string, error) {
 
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.listChainsMock != nil {
@@ -0,0 +1,115 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// versionMatcher matches the version of the output of --version
+var versionMatcher = regexp.MustCompile(`v([0-9]+)\.([0-9]+)\.([0-9]+)`)
+
+// IptablesRestore is an abstraction of iptables-restore. The script is in the
+// format of iptables-save, and each table is committed atomically.
+type IptablesRestore interface {
+	Restore(script string) error
+}
+
+type iptablesRestore struct {
+	path string
+	wait bool
+}
+
+// NewIptablesRestore returns an IptablesRestore that programs the rules of
+// iptables without flushing the tables
+func NewIptablesRestore() (IptablesRestore, error) {
+
+	return newIptablesRestore("iptables-restore")
+}
+
+// NewIp6tablesRestore returns an IptablesRestore that programs the rules of
+// ip6tables without flushing the tables
+func NewIp6tablesRestore() (IptablesRestore, error) {
+
+	return newIptablesRestore("ip6tables-restore")
+}
+
+// newIptablesRestore returns the restore of a command. The restore waits for
+// the lock of the tables, like go-iptables, if the version of the command has
+// the option.
+func newIptablesRestore(command string) (IptablesRestore, error) {
+
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot find %s: %s", command, err)
+	}
+
+	out, err := exec.Command(path, "--version").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get the version of %s: %s", command, err)
+	}
+
+	v1, v2, v3, err := extractVersion(string(out))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get the version of %s: %s", command, err)
+	}
+
+	return &iptablesRestore{path: path, wait: restoreHasWait(v1, v2, v3)}, nil
+}
+
+// extractVersion returns the first three components of a version, like
+// "iptables-restore v1.6.2"
+func extractVersion(str string) (int, int, int, error) {
+
+	result := versionMatcher.FindStringSubmatch(str)
+	if result == nil {
+		return 0, 0, 0, fmt.Errorf("No version found in %s", strings.TrimSpace(str))
+	}
+
+	v := [3]int{}
+	for i := range v {
+		n, err := strconv.Atoi(result[i+1])
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		v[i] = n
+	}
+
+	return v[0], v[1], v[2], nil
+}
+
+// restoreHasWait returns true if the version is 1.6.2 or later, when --wait
+// was added to iptables-restore
+func restoreHasWait(v1, v2, v3 int) bool {
+
+	if v1 != 1 {
+		return v1 > 1
+	}
+
+	if v2 != 6 {
+		return v2 > 6
+	}
+
+	return v3 >= 2
+}
+
+// Restore programs the rules of the script
+func (r *iptablesRestore) Restore(script string) error {
+
+	args := []string{"--noflush"}
+	if r.wait {
+		args = append(args, "--wait")
+	}
+
+	cmd := exec.Command(r.path, args...)
+	cmd.Stdin = bytes.NewBufferString(script)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
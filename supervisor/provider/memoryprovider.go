@@ -0,0 +1,887 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+)
+
+// PortSetProvider is implemented by the ipset providers that create the sets
+// of ports themselves. The ipset library doesn't support the bitmap sets.
+type PortSetProvider interface {
+	NewPortSet(name string) (Ipset, error)
+}
+
+// builtinChains are the chains of each table of iptables in the order of the
+// hooks of the packets
+var builtinChains = map[string][]string{
+	"raw":    {"PREROUTING", "OUTPUT"},
+	"mangle": {"PREROUTING", "INPUT", "FORWARD", "OUTPUT", "POSTROUTING"},
+	"nat":    {"PREROUTING", "INPUT", "OUTPUT", "POSTROUTING"},
+	"filter": {"INPUT", "FORWARD", "OUTPUT"},
+}
+
+// tableOrder is the order in which the tables see the packets of a hook
+var tableOrder = []string{"raw", "mangle", "nat", "filter"}
+
+// MemoryProvider is an in memory implementation of the iptables and the ipset
+// providers. It keeps the tables, the chains and their rules in order and the
+// sets with their entries, and rejects the operations that iptables and ipset
+// reject, like rules that jump to missing chains or match missing sets, and
+// deleting chains or sets that are still referenced. The packets can be
+// evaluated against the rules without programming the kernel.
+type MemoryProvider struct {
+	tables map[string]map[string][]*memoryRule
+	sets   map[string]*memorySet
+
+	sync.Mutex
+}
+
+// memorySet is an ipset kept in memory
+type memorySet struct {
+	name     string
+	hashType string
+	family   string
+	entries  map[string]bool
+}
+
+// NewMemoryProvider returns a memory provider with the builtin chains of the
+// tables and no sets
+func NewMemoryProvider() *MemoryProvider {
+
+	m := &MemoryProvider{
+		tables: map[string]map[string][]*memoryRule{},
+		sets:   map[string]*memorySet{},
+	}
+
+	for table, chains := range builtinChains {
+		m.tables[table] = map[string][]*memoryRule{}
+		for _, chain := range chains {
+			m.tables[table][chain] = []*memoryRule{}
+		}
+	}
+
+	return m
+}
+
+// isBuiltin returns true if the chain is a builtin chain of the table
+func isBuiltin(table, chain string) bool {
+
+	for _, builtin := range builtinChains[table] {
+		if builtin == chain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// chain returns the rules of a chain
+func (m *MemoryProvider) chain(table, chain string) ([]*memoryRule, error) {
+
+	chains, ok := m.tables[table]
+	if !ok {
+		return nil, fmt.Errorf("Table %s does not exist", table)
+	}
+
+	rules, ok := chains[chain]
+	if !ok {
+		return nil, fmt.Errorf("No chain %s in table %s", chain, table)
+	}
+
+	return rules, nil
+}
+
+// Append appends a rule to a chain
+func (m *MemoryProvider) Append(table, chain string, rulespec ...string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.appendRule(table, chain, rulespec)
+}
+
+// Insert inserts a rule in a chain at the given position, starting at 1
+func (m *MemoryProvider) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.insertRule(table, chain, pos, rulespec)
+}
+
+// Delete deletes the first rule of a chain that matches the rulespec, or the
+// rule with the given number like iptables does
+func (m *MemoryProvider) Delete(table, chain string, rulespec ...string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.deleteRule(table, chain, rulespec)
+}
+
+// ListChains lists the chains of a table, the builtin chains first
+func (m *MemoryProvider) ListChains(table string) ([]string, error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.listChains(table)
+}
+
+// ClearChain removes the rules of a chain. The chain is created if needed.
+func (m *MemoryProvider) ClearChain(table, chain string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.clearChain(table, chain)
+}
+
+// DeleteChain deletes an empty chain that no rule jumps to
+func (m *MemoryProvider) DeleteChain(table, chain string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.deleteChain(table, chain)
+}
+
+// NewChain creates an empty chain
+func (m *MemoryProvider) NewChain(table, chain string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.newChain(table, chain)
+}
+
+// appendRule appends a rule to a chain
+func (m *MemoryProvider) appendRule(table, chain string, rulespec []string) error {
+
+	rules, err := m.chain(table, chain)
+	if err != nil {
+		return err
+	}
+
+	rule, err := m.parseRule(table, rulespec)
+	if err != nil {
+		return err
+	}
+
+	m.tables[table][chain] = append(rules, rule)
+
+	return nil
+}
+
+// insertRule inserts a rule in a chain at the given position
+func (m *MemoryProvider) insertRule(table, chain string, pos int, rulespec []string) error {
+
+	rules, err := m.chain(table, chain)
+	if err != nil {
+		return err
+	}
+
+	if pos < 1 || pos > len(rules)+1 {
+		return fmt.Errorf("Invalid position %d in chain %s of table %s", pos, chain, table)
+	}
+
+	rule, err := m.parseRule(table, rulespec)
+	if err != nil {
+		return err
+	}
+
+	m.tables[table][chain] = append(rules[:pos-1:pos-1], append([]*memoryRule{rule}, rules[pos-1:]...)...)
+
+	return nil
+}
+
+// deleteRule deletes the first rule of a chain that matches the rulespec, or
+// the rule with the given number
+func (m *MemoryProvider) deleteRule(table, chain string, rulespec []string) error {
+
+	rules, err := m.chain(table, chain)
+	if err != nil {
+		return err
+	}
+
+	if num, ok := ruleNumber(rulespec); ok {
+		if num > len(rules) {
+			return fmt.Errorf("Invalid rule number %d in chain %s of table %s", num, chain, table)
+		}
+		m.tables[table][chain] = append(rules[:num-1:num-1], rules[num:]...)
+		return nil
+	}
+
+	key := strings.Join(rulespec, " ")
+	for index, rule := range rules {
+		if strings.Join(rule.spec, " ") == key {
+			m.tables[table][chain] = append(rules[:index:index], rules[index+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No matching rule in chain %s of table %s", chain, table)
+}
+
+// listChains lists the chains of a table like iptables does
+func (m *MemoryProvider) listChains(table string) ([]string, error) {
+
+	chains, ok := m.tables[table]
+	if !ok {
+		return nil, fmt.Errorf("Table %s does not exist", table)
+	}
+
+	user := []string{}
+	for chain := range chains {
+		if !isBuiltin(table, chain) {
+			user = append(user, chain)
+		}
+	}
+	sort.Strings(user)
+
+	return append(append([]string{}, builtinChains[table]...), user...), nil
+}
+
+// clearChain removes the rules of a chain and creates it if needed
+func (m *MemoryProvider) clearChain(table, chain string) error {
+
+	if _, ok := m.tables[table]; !ok {
+		return fmt.Errorf("Table %s does not exist", table)
+	}
+
+	m.tables[table][chain] = []*memoryRule{}
+
+	return nil
+}
+
+// deleteChain deletes an empty chain that no rule jumps to
+func (m *MemoryProvider) deleteChain(table, chain string) error {
+
+	rules, err := m.chain(table, chain)
+	if err != nil {
+		return err
+	}
+
+	if isBuiltin(table, chain) {
+		return fmt.Errorf("Cannot delete the builtin chain %s of table %s", chain, table)
+	}
+
+	if len(rules) > 0 {
+		return fmt.Errorf("Chain %s of table %s is not empty", chain, table)
+	}
+
+	for name, rules := range m.tables[table] {
+		for _, rule := range rules {
+			if rule.target == chain {
+				return fmt.Errorf("Chain %s of table %s is referenced by chain %s", chain, table, name)
+			}
+		}
+	}
+
+	delete(m.tables[table], chain)
+
+	return nil
+}
+
+// newChain creates an empty chain
+func (m *MemoryProvider) newChain(table, chain string) error {
+
+	chains, ok := m.tables[table]
+	if !ok {
+		return fmt.Errorf("Table %s does not exist", table)
+	}
+
+	if _, ok := chains[chain]; ok {
+		return fmt.Errorf("Chain %s already exists in table %s", chain, table)
+	}
+
+	chains[chain] = []*memoryRule{}
+
+	return nil
+}
+
+// Exists returns true if a rule of the chain matches the rulespec
+func (m *MemoryProvider) Exists(table, chain string, rulespec ...string) (bool, error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	rules, err := m.chain(table, chain)
+	if err != nil {
+		return false, err
+	}
+
+	key := strings.Join(rulespec, " ")
+	for _, rule := range rules {
+		if strings.Join(rule.spec, " ") == key {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// List lists the rules of a chain like iptables -S does
+func (m *MemoryProvider) List(table, chain string) ([]string, error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	rules, err := m.chain(table, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []string{"-N " + chain}
+	if isBuiltin(table, chain) {
+		list[0] = "-P " + chain + " ACCEPT"
+	}
+
+	for _, rule := range rules {
+		list = append(list, "-A "+chain+" "+quoteRule(rule.spec))
+	}
+
+	return list, nil
+}
+
+// Rules returns the rulespecs of a chain
+func (m *MemoryProvider) Rules(table, chain string) ([][]string, error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	rules, err := m.chain(table, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := make([][]string, len(rules))
+	for index, rule := range rules {
+		specs[index] = append([]string{}, rule.spec...)
+	}
+
+	return specs, nil
+}
+
+// NewIpset creates a set, or returns the set with the same name like ipset
+// does with an existing set
+func (m *MemoryProvider) NewIpset(name string, hasht string, p *ipset.Params) (Ipset, error) {
+
+	family := "inet"
+	if p != nil && p.HashFamily != "" {
+		family = p.HashFamily
+	}
+
+	if !strings.HasPrefix(hasht, "hash:") {
+		return nil, fmt.Errorf("Invalid hash type %s of set %s", hasht, name)
+	}
+
+	return m.newSet(name, hasht, family)
+}
+
+// NewPortSet creates a bitmap set of ports
+func (m *MemoryProvider) NewPortSet(name string) (Ipset, error) {
+
+	return m.newSet(name, "bitmap:port", "")
+}
+
+// newSet creates a set if it doesn't exist
+func (m *MemoryProvider) newSet(name, hashType, family string) (Ipset, error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	if s, ok := m.sets[name]; ok {
+		if s.hashType != hashType || s.family != family {
+			return nil, fmt.Errorf("Set %s already exists with a different type", name)
+		}
+		return &memoryIpset{provider: m, name: name}, nil
+	}
+
+	m.sets[name] = &memorySet{
+		name:     name,
+		hashType: hashType,
+		family:   family,
+		entries:  map[string]bool{},
+	}
+
+	return &memoryIpset{provider: m, name: name}, nil
+}
+
+// DestroyAll destroys all the sets. It fails if any set is referenced by a
+// rule.
+func (m *MemoryProvider) DestroyAll() error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	for name := range m.sets {
+		if m.setInUse(name) {
+			return fmt.Errorf("Set %s cannot be destroyed: it is in use by a kernel component", name)
+		}
+	}
+
+	m.sets = map[string]*memorySet{}
+
+	return nil
+}
+
+// setInUse returns true if a rule matches the set
+func (m *MemoryProvider) setInUse(name string) bool {
+
+	for _, chains := range m.tables {
+		for _, rules := range chains {
+			for _, rule := range rules {
+				for _, set := range rule.sets {
+					if set == name {
+						return true
+					}
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// Sets returns the names of the sets
+func (m *MemoryProvider) Sets() []string {
+
+	m.Lock()
+	defer m.Unlock()
+
+	names := []string{}
+	for name := range m.sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// memoryIpset is the handle of a set of a memory provider
+type memoryIpset struct {
+	provider *MemoryProvider
+	name     string
+}
+
+// set returns the set of the handle
+func (s *memoryIpset) set() (*memorySet, error) {
+
+	set, ok := s.provider.sets[s.name]
+	if !ok {
+		return nil, fmt.Errorf("The set with the given name does not exist: %s", s.name)
+	}
+
+	return set, nil
+}
+
+// Add adds an entry to the set
+func (s *memoryIpset) Add(entry string, timeout int) error {
+
+	s.provider.Lock()
+	defer s.provider.Unlock()
+
+	set, err := s.set()
+	if err != nil {
+		return err
+	}
+
+	if err := set.validEntry(entry); err != nil {
+		return err
+	}
+
+	set.entries[entry] = true
+
+	return nil
+}
+
+// AddOption adds an entry with options to the set. The options are ignored.
+func (s *memoryIpset) AddOption(entry string, option string, timeout int) error {
+
+	return s.Add(entry, timeout)
+}
+
+// Del deletes an entry of the set
+func (s *memoryIpset) Del(entry string) error {
+
+	s.provider.Lock()
+	defer s.provider.Unlock()
+
+	set, err := s.set()
+	if err != nil {
+		return err
+	}
+
+	delete(set.entries, entry)
+
+	return nil
+}
+
+// Destroy destroys the set. It fails if the set is referenced by a rule.
+func (s *memoryIpset) Destroy() error {
+
+	s.provider.Lock()
+	defer s.provider.Unlock()
+
+	if _, err := s.set(); err != nil {
+		return err
+	}
+
+	if s.provider.setInUse(s.name) {
+		return fmt.Errorf("Set %s cannot be destroyed: it is in use by a kernel component", s.name)
+	}
+
+	delete(s.provider.sets, s.name)
+
+	return nil
+}
+
+// Flush removes all the entries of the set
+func (s *memoryIpset) Flush() error {
+
+	s.provider.Lock()
+	defer s.provider.Unlock()
+
+	set, err := s.set()
+	if err != nil {
+		return err
+	}
+
+	set.entries = map[string]bool{}
+
+	return nil
+}
+
+// Test returns true if the entry is in the set
+func (s *memoryIpset) Test(entry string) (bool, error) {
+
+	s.provider.Lock()
+	defer s.provider.Unlock()
+
+	set, err := s.set()
+	if err != nil {
+		return false, err
+	}
+
+	return set.entries[entry], nil
+}
+
+// Save returns the tables in the format of iptables-save, without the
+// counters. The tables without user chains and rules are skipped.
+func (m *MemoryProvider) Save() string {
+
+	return m.SaveChains()
+}
+
+// SaveChains returns the given chains and the rules that jump to them in the
+// format of iptables-save, like the chains of a processing unit. All the
+// chains are returned if none is given.
+func (m *MemoryProvider) SaveChains(chains ...string) string {
+
+	m.Lock()
+	defer m.Unlock()
+
+	selected := map[string]bool{}
+	for _, chain := range chains {
+		selected[chain] = true
+	}
+
+	keep := func(chain string, rule *memoryRule) bool {
+		return len(selected) == 0 || selected[chain] || selected[rule.target]
+	}
+
+	var b strings.Builder
+
+	for _, table := range tableOrder {
+
+		names, _ := m.listChains(table) // nolint
+
+		declared := []string{}
+		rules := []string{}
+
+		for _, name := range names {
+			builtin := isBuiltin(table, name)
+			if !builtin && (len(selected) == 0 || selected[name]) {
+				declared = append(declared, name)
+			}
+
+			for _, rule := range m.tables[table][name] {
+				if keep(name, rule) {
+					rules = append(rules, "-A "+name+" "+quoteRule(rule.spec))
+				}
+			}
+		}
+
+		if len(declared) == 0 && len(rules) == 0 {
+			continue
+		}
+
+		b.WriteString("*" + table + "\n")
+		for _, name := range names {
+			if isBuiltin(table, name) {
+				b.WriteString(":" + name + " ACCEPT [0:0]\n")
+			}
+		}
+		for _, name := range declared {
+			b.WriteString(":" + name + " - [0:0]\n")
+		}
+		for _, rule := range rules {
+			b.WriteString(rule + "\n")
+		}
+		b.WriteString("COMMIT\n")
+	}
+
+	return b.String()
+}
+
+// SaveSets returns the sets and their entries in the format of ipset save
+func (m *MemoryProvider) SaveSets() string {
+
+	m.Lock()
+	defer m.Unlock()
+
+	names := []string{}
+	for name := range m.sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+
+	for _, name := range names {
+		set := m.sets[name]
+
+		if set.family != "" {
+			b.WriteString(fmt.Sprintf("create %s %s family %s\n", name, set.hashType, set.family))
+		} else {
+			b.WriteString(fmt.Sprintf("create %s %s range 0-65535\n", name, set.hashType))
+		}
+
+		entries := []string{}
+		for entry := range set.entries {
+			entries = append(entries, entry)
+		}
+		sort.Strings(entries)
+
+		for _, entry := range entries {
+			b.WriteString(fmt.Sprintf("add %s %s\n", name, entry))
+		}
+	}
+
+	return b.String()
+}
+
+// quoteRule joins the arguments of a rule and quotes the arguments with
+// spaces like iptables-save does
+func quoteRule(spec []string) string {
+
+	args := make([]string, len(spec))
+	for index, arg := range spec {
+		if arg == "" || strings.ContainsAny(arg, " \t\"") {
+			arg = fmt.Sprintf("%q", arg)
+		}
+		args[index] = arg
+	}
+
+	return strings.Join(args, " ")
+}
+
+// ruleNumber returns the number of the rule if the rulespec of a deletion is a
+// rule number, starting at 1
+func ruleNumber(spec []string) (int, bool) {
+
+	if len(spec) != 1 {
+		return 0, false
+	}
+
+	num, err := strconv.Atoi(spec[0])
+	if err != nil || num < 1 {
+		return 0, false
+	}
+
+	return num, true
+}
+
+// Restore programs the rules of a script in the format of iptables-save
+// without flushing the tables, like iptables-restore --noflush. Each table is
+// committed atomically when its COMMIT line is reached.
+func (m *MemoryProvider) Restore(script string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	table := ""
+	var original map[string][]*memoryRule
+
+	for number, line := range strings.Split(script, "\n") {
+
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var err error
+
+		switch {
+		case strings.HasPrefix(line, "*"):
+			if table != "" {
+				err = fmt.Errorf("Table %s is not committed", table)
+				break
+			}
+			table = line[1:]
+			chains, ok := m.tables[table]
+			if !ok {
+				err = fmt.Errorf("Table %s does not exist", table)
+				table = ""
+				break
+			}
+			original = chains
+			m.tables[table] = copyChains(chains)
+
+		case line == "COMMIT":
+			if table == "" {
+				err = fmt.Errorf("No table to commit")
+				break
+			}
+			table = ""
+
+		case table == "":
+			err = fmt.Errorf("No table for the line")
+
+		default:
+			err = m.restoreLine(table, line)
+		}
+
+		if err != nil {
+			if table != "" {
+				m.tables[table] = original
+			}
+			return fmt.Errorf("line %d failed: %s", number+1, err)
+		}
+	}
+
+	if table != "" {
+		m.tables[table] = original
+		return fmt.Errorf("Table %s is not committed", table)
+	}
+
+	return nil
+}
+
+// restoreLine applies a line of a restore to a table
+func (m *MemoryProvider) restoreLine(table, line string) error {
+
+	args, err := splitLine(line)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(args[0], ":") {
+		chain := args[0][1:]
+		if isBuiltin(table, chain) {
+			return nil
+		}
+		return m.clearChain(table, chain)
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf("Missing chain in line %s", line)
+	}
+
+	command, chain, spec := args[0], args[1], args[2:]
+
+	switch command {
+	case "-A":
+		return m.appendRule(table, chain, spec)
+
+	case "-I":
+		pos := 1
+		if len(spec) > 0 {
+			if n, err := strconv.Atoi(spec[0]); err == nil {
+				pos = n
+				spec = spec[1:]
+			}
+		}
+		return m.insertRule(table, chain, pos, spec)
+
+	case "-D":
+		return m.deleteRule(table, chain, spec)
+
+	case "-F":
+		if _, err := m.chain(table, chain); err != nil {
+			return err
+		}
+		return m.clearChain(table, chain)
+
+	case "-X":
+		return m.deleteChain(table, chain)
+
+	case "-N":
+		return m.newChain(table, chain)
+	}
+
+	return fmt.Errorf("Unsupported command %s", command)
+}
+
+// copyChains returns a copy of the chains of a table
+func copyChains(chains map[string][]*memoryRule) map[string][]*memoryRule {
+
+	copied := make(map[string][]*memoryRule, len(chains))
+	for chain, rules := range chains {
+		copied[chain] = append([]*memoryRule{}, rules...)
+	}
+
+	return copied
+}
+
+// splitLine splits a line of a restore in arguments. The arguments with
+// spaces are quoted.
+func splitLine(line string) ([]string, error) {
+
+	args := []string{}
+
+	var arg strings.Builder
+	quoted, inArg, escaped := false, false, false
+
+	for _, c := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+
+		case c == '\\' && quoted:
+			escaped = true
+
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+
+		case (c == ' ' || c == '\t') && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("Unterminated quote in line %s", line)
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
@@ -0,0 +1,200 @@
+package provider
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryProviderChains(t *testing.T) {
+
+	Convey("Given a memory provider", t, func() {
+		m := NewMemoryProvider()
+
+		Convey("The builtin chains should exist and not be deleted", func() {
+			chains, err := m.ListChains("raw")
+			So(err, ShouldBeNil)
+			So(chains, ShouldResemble, []string{"PREROUTING", "OUTPUT"})
+			So(m.DeleteChain("raw", "OUTPUT"), ShouldNotBeNil)
+		})
+
+		Convey("The rules that jump to missing chains or use unknown options should be rejected", func() {
+			So(m.Append("mangle", "INPUT", "-j", "MISSING"), ShouldNotBeNil)
+			So(m.Append("mangle", "INPUT", "--dport", "80", "-j", "ACCEPT"), ShouldNotBeNil)
+			So(m.Append("mangle", "INPUT", "-m", "set", "--match-set", "missing", "src", "-j", "ACCEPT"), ShouldNotBeNil)
+			So(m.Append("mangle", "INPUT", "--unknown", "-j", "ACCEPT"), ShouldNotBeNil)
+			So(m.Insert("mangle", "INPUT", 2, "-j", "ACCEPT"), ShouldNotBeNil)
+		})
+
+		Convey("When I create a chain that a rule jumps to", func() {
+			So(m.NewChain("mangle", "PU"), ShouldBeNil)
+			So(m.NewChain("mangle", "PU"), ShouldNotBeNil)
+			So(m.Append("mangle", "PU", "-p", "tcp", "--dport", "22", "-j", "RETURN"), ShouldBeNil)
+			So(m.Append("mangle", "PU", "-p", "tcp", "-j", "DROP"), ShouldBeNil)
+			So(m.Append("mangle", "INPUT", "-d", "10.0.0.0/8", "-j", "PU"), ShouldBeNil)
+
+			Convey("The chain can't be deleted until it is empty and not referenced", func() {
+				So(m.ClearChain("mangle", "PU"), ShouldBeNil)
+				So(m.DeleteChain("mangle", "PU"), ShouldNotBeNil)
+				So(m.Delete("mangle", "INPUT", "-d", "10.0.0.0/8", "-j", "PU"), ShouldBeNil)
+				So(m.DeleteChain("mangle", "PU"), ShouldBeNil)
+			})
+
+			Convey("The packets should return from the chain or get its verdict", func() {
+				v, err := m.Evaluate("mangle", "INPUT", &Packet{Protocol: "tcp", Destination: net.ParseIP("10.0.0.1"), DestinationPort: 22})
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "ACCEPT")
+				So(v.Rule, ShouldEqual, 0)
+
+				v, err = m.Evaluate("mangle", "INPUT", &Packet{Protocol: "tcp", Destination: net.ParseIP("10.0.0.1"), DestinationPort: 80})
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "DROP")
+				So(v.Chain, ShouldEqual, "PU")
+				So(v.Rule, ShouldEqual, 2)
+
+				v, err = m.Evaluate("mangle", "INPUT", &Packet{Protocol: "tcp", Destination: net.ParseIP("fd00::1"), DestinationPort: 80})
+				So(err, ShouldBeNil)
+				So(v.Target, ShouldEqual, "ACCEPT")
+			})
+
+			Convey("The save should have the chain and the rules that jump to it", func() {
+				So(m.SaveChains("PU"), ShouldEqual, "*mangle\n"+
+					":PREROUTING ACCEPT [0:0]\n:INPUT ACCEPT [0:0]\n:FORWARD ACCEPT [0:0]\n:OUTPUT ACCEPT [0:0]\n:POSTROUTING ACCEPT [0:0]\n"+
+					":PU - [0:0]\n"+
+					"-A INPUT -d 10.0.0.0/8 -j PU\n"+
+					"-A PU -p tcp --dport 22 -j RETURN\n"+
+					"-A PU -p tcp -j DROP\n"+
+					"COMMIT\n")
+			})
+		})
+	})
+}
+
+func TestMemoryProviderSets(t *testing.T) {
+
+	Convey("Given a memory provider with a set of networks", t, func() {
+		m := NewMemoryProvider()
+
+		set, err := m.NewIpset("targets", "hash:net", &ipset.Params{})
+		So(err, ShouldBeNil)
+		So(set.Add("10.0.0.0/8", 0), ShouldBeNil)
+		So(set.Add("fd00::/8", 0), ShouldNotBeNil)
+
+		So(m.Append("mangle", "OUTPUT", "-m", "set", "--match-set", "targets", "dst", "-j", "NFQUEUE", "--queue-balance", "0:3"), ShouldBeNil)
+
+		Convey("The packets to the networks of the set should match", func() {
+			v, err := m.EvaluateHook("OUTPUT", &Packet{Protocol: "udp", Destination: net.ParseIP("10.1.1.1")})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "NFQUEUE")
+			So(v.Options, ShouldResemble, []string{"--queue-balance", "0:3"})
+
+			So(set.Del("10.0.0.0/8"), ShouldBeNil)
+
+			v, err = m.EvaluateHook("OUTPUT", &Packet{Protocol: "udp", Destination: net.ParseIP("10.1.1.1")})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+		})
+
+		Convey("The set can't be destroyed while a rule uses it", func() {
+			So(set.Destroy(), ShouldNotBeNil)
+			So(m.DestroyAll(), ShouldNotBeNil)
+
+			So(m.Delete("mangle", "OUTPUT", "-m", "set", "--match-set", "targets", "dst", "-j", "NFQUEUE", "--queue-balance", "0:3"), ShouldBeNil)
+			So(set.Destroy(), ShouldBeNil)
+			So(m.Sets(), ShouldBeEmpty)
+		})
+
+		Convey("The sets of ports should match the ports of the packets", func() {
+			ports, err := m.NewPortSet("ports")
+			So(err, ShouldBeNil)
+			So(ports.Add("8000-8010", 0), ShouldBeNil)
+			So(m.Append("mangle", "PREROUTING", "-m", "set", "--match-set", "ports", "dst", "-j", "MARK", "--set-mark", "100"), ShouldBeNil)
+
+			v, err := m.EvaluateHook("PREROUTING", &Packet{Protocol: "tcp", DestinationPort: 8005})
+			So(err, ShouldBeNil)
+			So(v.Mark, ShouldEqual, 100)
+
+			v, err = m.EvaluateHook("PREROUTING", &Packet{Protocol: "tcp", DestinationPort: 8011})
+			So(err, ShouldBeNil)
+			So(v.Mark, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestMemoryProviderMatches(t *testing.T) {
+
+	Convey("Given a memory provider", t, func() {
+		m := NewMemoryProvider()
+
+		Convey("The TCP flags should be matched among the flags of the mask", func() {
+			So(m.Append("mangle", "INPUT", "-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN", "-j", "DROP"), ShouldBeNil)
+
+			v, err := m.Evaluate("mangle", "INPUT", &Packet{Protocol: "tcp", TCPFlags: []string{"SYN", "ECE"}})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "DROP")
+
+			v, err = m.Evaluate("mangle", "INPUT", &Packet{Protocol: "tcp", TCPFlags: []string{"SYN", "ACK"}})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "ACCEPT")
+		})
+
+		Convey("The ICMP types and the protocol numbers should be matched", func() {
+			So(m.Append("mangle", "INPUT", "-p", "icmp", "--icmp-type", "3/4", "-j", "ACCEPT"), ShouldBeNil)
+			So(m.Append("mangle", "INPUT", "-p", "icmp", "--icmp-type", "8", "-j", "ACCEPT"), ShouldBeNil)
+			So(m.Append("mangle", "INPUT", "-p", "47", "-j", "ACCEPT"), ShouldBeNil)
+			So(m.Append("mangle", "INPUT", "-p", "sctp", "--dport", "3868", "-j", "ACCEPT"), ShouldBeNil)
+			So(m.Append("mangle", "INPUT", "-j", "DROP"), ShouldBeNil)
+			So(m.Append("mangle", "INPUT", "-p", "tcp", "--icmp-type", "8", "-j", "ACCEPT"), ShouldNotBeNil)
+			So(m.Append("mangle", "INPUT", "-p", "icmp", "--icmpv6-type", "128", "-j", "ACCEPT"), ShouldNotBeNil)
+
+			verdict := func(p *Packet) string {
+				v, err := m.Evaluate("mangle", "INPUT", p)
+				So(err, ShouldBeNil)
+				return v.Target
+			}
+
+			So(verdict(&Packet{Protocol: "icmp", ICMPType: 3, ICMPCode: 4}), ShouldEqual, "ACCEPT")
+			So(verdict(&Packet{Protocol: "icmp", ICMPType: 3, ICMPCode: 1}), ShouldEqual, "DROP")
+			So(verdict(&Packet{Protocol: "icmp", ICMPType: 8, ICMPCode: 0}), ShouldEqual, "ACCEPT")
+			So(verdict(&Packet{Protocol: "gre"}), ShouldEqual, "ACCEPT")
+			So(verdict(&Packet{Protocol: "sctp", DestinationPort: 3868}), ShouldEqual, "ACCEPT")
+			So(verdict(&Packet{Protocol: "sctp", DestinationPort: 80}), ShouldEqual, "DROP")
+		})
+
+		Convey("The time windows should be matched in UTC", func() {
+			So(m.Append("mangle", "INPUT",
+				"-m", "time", "--datestart", "2017-01-01T00:00:00", "--timestart", "22:00:00", "--timestop", "01:59:59", "--contiguous", "--weekdays", "Mon",
+				"-j", "ACCEPT"), ShouldBeNil)
+			So(m.Append("mangle", "INPUT", "-j", "DROP"), ShouldBeNil)
+
+			verdict := func(at string) string {
+				now, err := time.Parse(time.RFC3339, at)
+				So(err, ShouldBeNil)
+				v, err := m.Evaluate("mangle", "INPUT", &Packet{Protocol: "tcp", Time: now})
+				So(err, ShouldBeNil)
+				return v.Target
+			}
+
+			So(verdict("2017-06-05T23:00:00Z"), ShouldEqual, "ACCEPT")
+			So(verdict("2017-06-06T01:00:00Z"), ShouldEqual, "ACCEPT")
+			So(verdict("2017-06-06T23:00:00Z"), ShouldEqual, "DROP")
+			So(verdict("2017-06-05T12:00:00Z"), ShouldEqual, "DROP")
+			So(verdict("2016-06-06T01:00:00+02:00"), ShouldEqual, "DROP")
+		})
+
+		Convey("The logs and the marks should not end the evaluation", func() {
+			So(m.Append("mangle", "INPUT", "-m", "state", "--state", "NEW", "-j", "NFLOG", "--nflog-group", "10", "--nflog-prefix", "new"), ShouldBeNil)
+			So(m.Append("mangle", "INPUT", "-j", "MARK", "--set-mark", "0x10"), ShouldBeNil)
+			So(m.Append("mangle", "INPUT", "-m", "mark", "--mark", "16", "-j", "DROP"), ShouldBeNil)
+
+			v, err := m.Evaluate("mangle", "INPUT", &Packet{Protocol: "udp"})
+			So(err, ShouldBeNil)
+			So(v.Target, ShouldEqual, "DROP")
+			So(v.Logs, ShouldResemble, []string{"new"})
+			So(v.Mark, ShouldEqual, 16)
+		})
+	})
+}
@@ -0,0 +1,1072 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// maxJumps is the maximum depth of the jumps between chains, like the limit
+// of the kernel that detects loops
+const maxJumps = 64
+
+// Packet is a packet evaluated against the rules of a memory provider. Only
+// the fields that are matched by the rules need to be set.
+type Packet struct {
+	Protocol        string
+	Source          net.IP
+	Destination     net.IP
+	SourcePort      int
+	DestinationPort int
+
+	// ICMPType and ICMPCode are the type and the code of an ICMP or an ICMPv6
+	// packet
+	ICMPType uint8
+	ICMPCode uint8
+
+	// TCPFlags are the flags of a TCP packet, like SYN and ACK
+	TCPFlags []string
+
+	// TCPOptions are the kinds of the options of a TCP packet
+	TCPOptions []int
+
+	// State is the state of the connection of the packet, NEW if empty
+	State string
+
+	// Packets is the number of packets of the connection in the original
+	// direction, including this one
+	Packets int
+
+	// Mark and ConnMark are the marks of the packet and of its connection
+	Mark     uint32
+	ConnMark uint32
+
+	// Cgroup is the class of the net_cls cgroup of the socket of the packet
+	Cgroup uint32
+
+	// UID is the owner of the socket of the packet
+	UID string
+
+	// Time is the time at which the packet is seen. The current time is
+	// used if it is zero.
+	Time time.Time
+}
+
+// Verdict is the result of the evaluation of a packet
+type Verdict struct {
+	// Target is the target that terminated the evaluation, like ACCEPT, DROP
+	// or NFQUEUE, or the policy of the builtin chain
+	Target string
+
+	// Options are the options of the target, like the queues of NFQUEUE
+	Options []string
+
+	// Table, Chain and Rule are where the packet got its verdict. The rules
+	// start at 1, and Rule is 0 for the policy of the chain.
+	Table string
+	Chain string
+	Rule  int
+
+	// Logs are the prefixes of the NFLOG and LOG rules that the packet hit
+	Logs []string
+
+	// Mark is the mark of the packet after the evaluation
+	Mark uint32
+}
+
+// memoryMatch is a match of a rule
+type memoryMatch func(m *MemoryProvider, p *Packet) bool
+
+// memoryRule is a rule of a memory provider
+type memoryRule struct {
+	spec    []string
+	matches []memoryMatch
+	sets    []string
+	target  string
+	options []string
+	mark    uint32
+}
+
+// terminalTargets are the targets that end the evaluation of a packet in a
+// table
+var terminalTargets = map[string]bool{
+	"ACCEPT":  true,
+	"DROP":    true,
+	"REJECT":  true,
+	"NFQUEUE": true,
+}
+
+// parseRule parses a rulespec. It fails on the options that are not modeled,
+// so that the evaluation of the packets is never wrong.
+func (m *MemoryProvider) parseRule(table string, spec []string) (*memoryRule, error) {
+
+	rule := &memoryRule{spec: append([]string{}, spec...)}
+
+	module := ""
+	proto := ""
+	negate := false
+	var t *timeMatch
+
+	for i := 0; i < len(spec); i++ {
+		arg := spec[i]
+
+		if arg == "-j" {
+			if i+1 >= len(spec) {
+				return nil, fmt.Errorf("Missing target in rule %s", quoteRule(spec))
+			}
+			rule.target = spec[i+1]
+			rule.options = spec[i+2:]
+			break
+		}
+
+		if arg == "!" {
+			negate = true
+			continue
+		}
+
+		values := func(n int) ([]string, error) {
+			if i+n >= len(spec) {
+				return nil, fmt.Errorf("Missing argument of %s in rule %s", arg, quoteRule(spec))
+			}
+			v := spec[i+1 : i+1+n]
+			i += n
+			return v, nil
+		}
+
+		var match memoryMatch
+		var err error
+		var v []string
+
+		switch arg {
+		case "-m", "--match":
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			module = v[0]
+			continue
+
+		case "-p", "--protocol":
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			proto = strings.ToLower(v[0])
+			match = protocolMatch(proto)
+
+		case "-s", "--source", "-d", "--destination":
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			var network *net.IPNet
+			if network, err = parseNetwork(v[0]); err != nil {
+				return nil, err
+			}
+			match = addressMatch(network, arg == "-s" || arg == "--source")
+
+		case "--dport", "--destination-port", "--sport", "--source-port":
+			if !policy.ProtocolHasPorts(proto) {
+				return nil, fmt.Errorf("Unknown option %s without protocol", arg)
+			}
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			if match, err = portsMatch(v[0], arg == "--sport" || arg == "--source-port"); err != nil {
+				return nil, err
+			}
+
+		case "--destination-ports", "--dports", "--source-ports", "--sports":
+			if module != "multiport" {
+				return nil, fmt.Errorf("Unknown option %s without the multiport match", arg)
+			}
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			if match, err = portsMatch(v[0], arg == "--source-ports" || arg == "--sports"); err != nil {
+				return nil, err
+			}
+
+		case "--tcp-flags":
+			if proto != "tcp" {
+				return nil, fmt.Errorf("Unknown option %s without the tcp protocol", arg)
+			}
+			if v, err = values(2); err != nil {
+				return nil, err
+			}
+			if match, err = tcpFlagsMatch(v[0], v[1]); err != nil {
+				return nil, err
+			}
+
+		case "--tcp-option":
+			if proto != "tcp" {
+				return nil, fmt.Errorf("Unknown option %s without the tcp protocol", arg)
+			}
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			if match, err = tcpOptionMatch(v[0]); err != nil {
+				return nil, err
+			}
+
+		case "--icmp-type", "--icmpv6-type":
+			number, _ := policy.ProtocolNumber(proto)
+			if arg == "--icmp-type" && number != policy.ProtocolICMP || arg == "--icmpv6-type" && number != policy.ProtocolICMPv6 {
+				return nil, fmt.Errorf("Unknown option %s without the icmp protocol", arg)
+			}
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			if match, err = icmpTypeMatch(v[0]); err != nil {
+				return nil, err
+			}
+
+		case "--state", "--ctstate":
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			match = stateMatch(v[0])
+
+		case "--match-set":
+			if v, err = values(2); err != nil {
+				return nil, err
+			}
+			if match, err = m.setMatch(v[0], v[1]); err != nil {
+				return nil, err
+			}
+			rule.sets = append(rule.sets, v[0])
+
+		case "--mark":
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			if match, err = markMatch(module, v[0]); err != nil {
+				return nil, err
+			}
+
+		case "--cgroup":
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			if match, err = cgroupMatch(v[0]); err != nil {
+				return nil, err
+			}
+
+		case "--uid-owner":
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			uid := v[0]
+			match = func(m *MemoryProvider, p *Packet) bool {
+				return p.UID == uid
+			}
+
+		case "--connbytes":
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			if match, err = connbytesMatch(v[0]); err != nil {
+				return nil, err
+			}
+
+		case "--connbytes-dir":
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			if v[0] != "original" {
+				return nil, fmt.Errorf("Unsupported direction %s of connbytes", v[0])
+			}
+			continue
+
+		case "--connbytes-mode":
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			if v[0] != "packets" {
+				return nil, fmt.Errorf("Unsupported mode %s of connbytes", v[0])
+			}
+			continue
+
+		case "--comment":
+			if _, err = values(1); err != nil {
+				return nil, err
+			}
+			continue
+
+		case "--datestart", "--datestop", "--timestart", "--timestop", "--weekdays", "--contiguous":
+			if module != "time" {
+				return nil, fmt.Errorf("Unknown option %s without the time match", arg)
+			}
+			if negate {
+				return nil, fmt.Errorf("Unsupported negation of %s", arg)
+			}
+			if t == nil {
+				t = &timeMatch{stop: 24*time.Hour - time.Second}
+				rule.matches = append(rule.matches, t.match)
+			}
+			if arg == "--contiguous" {
+				t.contiguous = true
+				continue
+			}
+			if v, err = values(1); err != nil {
+				return nil, err
+			}
+			if err = t.parse(arg, v[0]); err != nil {
+				return nil, err
+			}
+			continue
+
+		default:
+			return nil, fmt.Errorf("Unsupported option %s in rule %s", arg, quoteRule(spec))
+		}
+
+		if negate {
+			positive := match
+			match = func(m *MemoryProvider, p *Packet) bool {
+				return !positive(m, p)
+			}
+			negate = false
+		}
+
+		rule.matches = append(rule.matches, match)
+	}
+
+	if negate {
+		return nil, fmt.Errorf("Invalid negation in rule %s", quoteRule(spec))
+	}
+
+	if err := m.parseTarget(table, rule); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// parseTarget validates the target of a rule and its options
+func (m *MemoryProvider) parseTarget(table string, rule *memoryRule) error {
+
+	switch rule.target {
+	case "", "ACCEPT", "DROP", "REJECT", "RETURN", "NFQUEUE", "NFLOG", "LOG":
+		return nil
+
+	case "MARK", "CONNMARK":
+		if len(rule.options) != 2 || (rule.options[0] != "--set-mark" && rule.options[0] != "--set-xmark") {
+			return fmt.Errorf("Unsupported options of %s in rule %s", rule.target, quoteRule(rule.spec))
+		}
+		value, mask, err := parseMark(rule.options[1])
+		if err != nil {
+			return err
+		}
+		if mask != 0xffffffff {
+			return fmt.Errorf("Unsupported mask of %s in rule %s", rule.target, quoteRule(rule.spec))
+		}
+		rule.mark = value
+		return nil
+	}
+
+	if isBuiltin(table, rule.target) {
+		return fmt.Errorf("Cannot jump to the builtin chain %s", rule.target)
+	}
+
+	if _, ok := m.tables[table][rule.target]; !ok {
+		return fmt.Errorf("Couldn't load target %s: No chain/target/match by that name", rule.target)
+	}
+
+	return nil
+}
+
+// Evaluate returns the verdict of a packet that traverses a chain of a table
+func (m *MemoryProvider) Evaluate(table, chain string, packet *Packet) (*Verdict, error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	p := *packet
+	v := &Verdict{}
+
+	if err := m.evaluate(table, chain, &p, v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// EvaluateHook returns the verdict of a packet that traverses the builtin
+// chain of a hook, like PREROUTING, in all the tables. The packet traverses
+// the next table when it is accepted. An accepted packet gets the verdict of
+// the last table that accepted it with a rule, or the policy of the chain of
+// the first table.
+func (m *MemoryProvider) EvaluateHook(hook string, packet *Packet) (*Verdict, error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	p := *packet
+	logs := []string{}
+
+	var accepted *Verdict
+	for _, table := range tableOrder {
+		if !isBuiltin(table, hook) {
+			continue
+		}
+
+		v := &Verdict{Logs: logs}
+		if err := m.evaluate(table, hook, &p, v); err != nil {
+			return nil, err
+		}
+
+		if v.Target != "ACCEPT" {
+			return v, nil
+		}
+
+		if accepted == nil || v.Rule > 0 {
+			accepted = v
+		}
+
+		logs = v.Logs
+	}
+
+	if accepted == nil {
+		return nil, fmt.Errorf("Hook %s does not exist", hook)
+	}
+
+	accepted.Logs = logs
+	accepted.Mark = p.Mark
+
+	return accepted, nil
+}
+
+// evaluate evaluates a packet in a chain of a table
+func (m *MemoryProvider) evaluate(table, chain string, p *Packet, v *Verdict) error {
+
+	if _, err := m.chain(table, chain); err != nil {
+		return err
+	}
+
+	terminal, err := m.traverse(table, chain, p, v, 0)
+	if err != nil {
+		return err
+	}
+
+	if !terminal {
+		v.Target = "RETURN"
+		if isBuiltin(table, chain) {
+			v.Target = "ACCEPT"
+		}
+		v.Table = table
+		v.Chain = chain
+		v.Rule = 0
+	}
+
+	v.Mark = p.Mark
+
+	return nil
+}
+
+// traverse evaluates the rules of a chain in order. It returns true if the
+// packet got a terminal verdict.
+func (m *MemoryProvider) traverse(table, chain string, p *Packet, v *Verdict, depth int) (bool, error) {
+
+	if depth > maxJumps {
+		return false, fmt.Errorf("Too many jumps from chain %s of table %s", chain, table)
+	}
+
+	for index, rule := range m.tables[table][chain] {
+
+		if !rule.matchesPacket(m, p) {
+			continue
+		}
+
+		switch rule.target {
+		case "":
+			continue
+
+		case "RETURN":
+			return false, nil
+
+		case "MARK":
+			p.Mark = rule.mark
+
+		case "CONNMARK":
+			p.ConnMark = rule.mark
+
+		case "NFLOG":
+			v.Logs = append(v.Logs, targetOption(rule.options, "--nflog-prefix"))
+
+		case "LOG":
+			v.Logs = append(v.Logs, targetOption(rule.options, "--log-prefix"))
+
+		default:
+			if terminalTargets[rule.target] {
+				v.Target = rule.target
+				v.Options = append([]string{}, rule.options...)
+				v.Table = table
+				v.Chain = chain
+				v.Rule = index + 1
+				return true, nil
+			}
+
+			terminal, err := m.traverse(table, rule.target, p, v, depth+1)
+			if err != nil || terminal {
+				return terminal, err
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// matchesPacket returns true if all the matches of the rule match the packet
+func (r *memoryRule) matchesPacket(m *MemoryProvider, p *Packet) bool {
+
+	for _, match := range r.matches {
+		if !match(m, p) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// targetOption returns the value of an option of a target
+func targetOption(options []string, name string) string {
+
+	for index := 0; index+1 < len(options); index++ {
+		if options[index] == name {
+			return options[index+1]
+		}
+	}
+
+	return ""
+}
+
+// protocolMatch matches the protocol of the packets
+func protocolMatch(proto string) memoryMatch {
+
+	return func(m *MemoryProvider, p *Packet) bool {
+		return proto == "all" || policy.SameProtocol(p.Protocol, proto)
+	}
+}
+
+// parseNetwork parses an address or a network
+func parseNetwork(address string) (*net.IPNet, error) {
+
+	if strings.Contains(address, "/") {
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid network %s", address)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid address %s", address)
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// containsIP returns true if the network contains the address of the same
+// IP family
+func containsIP(network *net.IPNet, ip net.IP) bool {
+
+	if ip == nil || (network.IP.To4() != nil) != (ip.To4() != nil) {
+		return false
+	}
+
+	return network.Contains(ip)
+}
+
+// addressMatch matches the source or the destination of the packets
+func addressMatch(network *net.IPNet, source bool) memoryMatch {
+
+	return func(m *MemoryProvider, p *Packet) bool {
+		if source {
+			return containsIP(network, p.Source)
+		}
+		return containsIP(network, p.Destination)
+	}
+}
+
+// portRange is a range of ports
+type portRange struct {
+	from int
+	to   int
+}
+
+// parsePortRanges parses a list of ports and ranges of ports separated by
+// commas. The bounds of the ranges are separated by the separator.
+func parsePortRanges(ports string, separator string) ([]portRange, error) {
+
+	ranges := []portRange{}
+
+	for _, port := range strings.Split(ports, ",") {
+		bounds := strings.SplitN(port, separator, 2)
+
+		r := portRange{from: 0, to: 65535}
+		var err error
+
+		if bounds[0] != "" {
+			if r.from, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("Invalid port %s", port)
+			}
+		}
+
+		if len(bounds) == 1 {
+			r.to = r.from
+		} else if bounds[1] != "" {
+			if r.to, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("Invalid port %s", port)
+			}
+		}
+
+		if r.from < 0 || r.to > 65535 || r.from > r.to {
+			return nil, fmt.Errorf("Invalid port %s", port)
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges, nil
+}
+
+// inPortRanges returns true if the port is in one of the ranges
+func inPortRanges(ranges []portRange, port int) bool {
+
+	for _, r := range ranges {
+		if port >= r.from && port <= r.to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// portsMatch matches the source or destination port of the packets
+func portsMatch(ports string, source bool) (memoryMatch, error) {
+
+	ranges, err := parsePortRanges(ports, ":")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(m *MemoryProvider, p *Packet) bool {
+		if source {
+			return inPortRanges(ranges, p.SourcePort)
+		}
+		return inPortRanges(ranges, p.DestinationPort)
+	}, nil
+}
+
+// icmpTypeMatch matches the ICMP packets of a type, and optionally of a code,
+// given by their numbers like 8 or 3/4
+func icmpTypeMatch(typeCode string) (memoryMatch, error) {
+
+	parts := strings.SplitN(typeCode, "/", 2)
+
+	icmpType, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ICMP type %s", typeCode)
+	}
+
+	icmpCode := int64(-1)
+	if len(parts) == 2 {
+		code, err := strconv.ParseUint(parts[1], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ICMP type %s", typeCode)
+		}
+		icmpCode = int64(code)
+	}
+
+	return func(m *MemoryProvider, p *Packet) bool {
+		return p.ICMPType == uint8(icmpType) && (icmpCode < 0 || int64(p.ICMPCode) == icmpCode)
+	}, nil
+}
+
+// tcpFlags are the flags of the TCP packets
+var tcpFlags = []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG", "ECE", "CWR"}
+
+// parseTCPFlags parses a list of TCP flags separated by commas
+func parseTCPFlags(flags string) (map[string]bool, error) {
+
+	set := map[string]bool{}
+
+	for _, flag := range strings.Split(strings.ToUpper(flags), ",") {
+		switch flag {
+		case "ALL":
+			for _, f := range tcpFlags {
+				set[f] = true
+			}
+		case "NONE":
+		default:
+			valid := false
+			for _, f := range tcpFlags {
+				valid = valid || f == flag
+			}
+			if !valid {
+				return nil, fmt.Errorf("Invalid TCP flag %s", flag)
+			}
+			set[flag] = true
+		}
+	}
+
+	return set, nil
+}
+
+// tcpFlagsMatch matches the packets with the given flags among the flags of
+// the mask
+func tcpFlagsMatch(mask, comp string) (memoryMatch, error) {
+
+	maskFlags, err := parseTCPFlags(mask)
+	if err != nil {
+		return nil, err
+	}
+
+	compFlags, err := parseTCPFlags(comp)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(m *MemoryProvider, p *Packet) bool {
+		if strings.ToLower(p.Protocol) != "tcp" {
+			return false
+		}
+
+		flags := map[string]bool{}
+		for _, flag := range p.TCPFlags {
+			flags[strings.ToUpper(flag)] = true
+		}
+
+		for flag := range maskFlags {
+			if flags[flag] != compFlags[flag] {
+				return false
+			}
+		}
+
+		return true
+	}, nil
+}
+
+// tcpOptionMatch matches the TCP packets with the given option
+func tcpOptionMatch(option string) (memoryMatch, error) {
+
+	kind, err := strconv.Atoi(option)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TCP option %s", option)
+	}
+
+	return func(m *MemoryProvider, p *Packet) bool {
+		if strings.ToLower(p.Protocol) != "tcp" {
+			return false
+		}
+
+		for _, o := range p.TCPOptions {
+			if o == kind {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+// stateMatch matches the packets of the connections in the given states
+func stateMatch(states string) memoryMatch {
+
+	list := strings.Split(strings.ToUpper(states), ",")
+
+	return func(m *MemoryProvider, p *Packet) bool {
+		state := strings.ToUpper(p.State)
+		if state == "" {
+			state = "NEW"
+		}
+
+		for _, s := range list {
+			if s == state {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// setMatch matches the source or the destination of the packets with the
+// entries of a set
+func (m *MemoryProvider) setMatch(name, direction string) (memoryMatch, error) {
+
+	if _, ok := m.sets[name]; !ok {
+		return nil, fmt.Errorf("Set %s doesn't exist", name)
+	}
+
+	if direction != "src" && direction != "dst" {
+		return nil, fmt.Errorf("Unsupported direction %s of set %s", direction, name)
+	}
+
+	source := direction == "src"
+
+	return func(m *MemoryProvider, p *Packet) bool {
+		set, ok := m.sets[name]
+		if !ok {
+			return false
+		}
+
+		if set.hashType == "bitmap:port" {
+			if source {
+				return set.containsPort(p.SourcePort)
+			}
+			return set.containsPort(p.DestinationPort)
+		}
+
+		if source {
+			return set.containsIP(p.Source)
+		}
+		return set.containsIP(p.Destination)
+	}, nil
+}
+
+// validEntry returns an error if the entry can't be added to the set
+func (s *memorySet) validEntry(entry string) error {
+
+	if s.hashType == "bitmap:port" {
+		_, err := parsePortRanges(entry, "-")
+		return err
+	}
+
+	if s.hashType == "hash:ip" && strings.Contains(entry, "/") {
+		return fmt.Errorf("Invalid address %s of set %s", entry, s.name)
+	}
+
+	network, err := parseNetwork(entry)
+	if err != nil {
+		return err
+	}
+
+	if (network.IP.To4() == nil) != (s.family == "inet6") {
+		return fmt.Errorf("Invalid family of entry %s of set %s", entry, s.name)
+	}
+
+	return nil
+}
+
+// containsIP returns true if an entry of the set contains the address
+func (s *memorySet) containsIP(ip net.IP) bool {
+
+	for entry := range s.entries {
+		network, err := parseNetwork(entry)
+		if err == nil && containsIP(network, ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsPort returns true if an entry of the set contains the port
+func (s *memorySet) containsPort(port int) bool {
+
+	for entry := range s.entries {
+		ranges, err := parsePortRanges(entry, "-")
+		if err == nil && inPortRanges(ranges, port) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseMark parses a mark and its optional mask
+func parseMark(mark string) (uint32, uint32, error) {
+
+	parts := strings.SplitN(mark, "/", 2)
+
+	value, err := strconv.ParseUint(parts[0], 0, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid mark %s", mark)
+	}
+
+	mask := uint64(0xffffffff)
+	if len(parts) == 2 {
+		if mask, err = strconv.ParseUint(parts[1], 0, 32); err != nil {
+			return 0, 0, fmt.Errorf("Invalid mark %s", mark)
+		}
+	}
+
+	return uint32(value), uint32(mask), nil
+}
+
+// markMatch matches the mark of the packets or of their connections
+func markMatch(module, mark string) (memoryMatch, error) {
+
+	value, mask, err := parseMark(mark)
+	if err != nil {
+		return nil, err
+	}
+
+	switch module {
+	case "mark":
+		return func(m *MemoryProvider, p *Packet) bool {
+			return p.Mark&mask == value
+		}, nil
+
+	case "connmark":
+		return func(m *MemoryProvider, p *Packet) bool {
+			return p.ConnMark&mask == value
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown option --mark without the mark or connmark match")
+}
+
+// cgroupMatch matches the class of the cgroup of the packets
+func cgroupMatch(class string) (memoryMatch, error) {
+
+	value, err := strconv.ParseUint(class, 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cgroup %s", class)
+	}
+
+	return func(m *MemoryProvider, p *Packet) bool {
+		return p.Cgroup == uint32(value)
+	}, nil
+}
+
+// connbytesMatch matches the number of packets of the connections
+func connbytesMatch(packets string) (memoryMatch, error) {
+
+	bounds := strings.SplitN(packets, ":", 2)
+
+	from, to := 0, -1
+	var err error
+
+	if bounds[0] != "" {
+		if from, err = strconv.Atoi(bounds[0]); err != nil {
+			return nil, fmt.Errorf("Invalid connbytes %s", packets)
+		}
+	}
+
+	if len(bounds) == 2 && bounds[1] != "" {
+		if to, err = strconv.Atoi(bounds[1]); err != nil {
+			return nil, fmt.Errorf("Invalid connbytes %s", packets)
+		}
+	}
+
+	return func(m *MemoryProvider, p *Packet) bool {
+		count := p.Packets
+		if count == 0 {
+			count = 1
+		}
+		return count >= from && (to < 0 || count <= to)
+	}, nil
+}
+
+// timeMatch matches the time of the packets. The times are in UTC.
+type timeMatch struct {
+	dateStart  time.Time
+	dateStop   time.Time
+	start      time.Duration
+	stop       time.Duration
+	weekdays   map[time.Weekday]bool
+	contiguous bool
+}
+
+// parse parses an option of the time match
+func (t *timeMatch) parse(option, value string) error {
+
+	var err error
+
+	switch option {
+	case "--datestart":
+		t.dateStart, err = time.Parse("2006-01-02T15:04:05", value)
+
+	case "--datestop":
+		t.dateStop, err = time.Parse("2006-01-02T15:04:05", value)
+
+	case "--timestart":
+		t.start, err = parseClock(value)
+
+	case "--timestop":
+		t.stop, err = parseClock(value)
+
+	case "--weekdays":
+		t.weekdays = map[time.Weekday]bool{}
+		for _, day := range strings.Split(value, ",") {
+			found := false
+			for d := time.Sunday; d <= time.Saturday; d++ {
+				if strings.EqualFold(d.String()[:3], day) || strconv.Itoa(int(d)) == day || (d == time.Sunday && day == "7") {
+					t.weekdays[d] = true
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("Invalid weekday %s", day)
+			}
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("Invalid value %s of %s", value, option)
+	}
+
+	return nil
+}
+
+// parseClock parses a time of the day
+func parseClock(value string) (time.Duration, error) {
+
+	layout := "15:04:05"
+	if strings.Count(value, ":") == 1 {
+		layout = "15:04"
+	}
+
+	clock, err := time.Parse(layout, value)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute + time.Duration(clock.Second())*time.Second, nil
+}
+
+// match matches the time of the packets
+func (t *timeMatch) match(m *MemoryProvider, p *Packet) bool {
+
+	now := p.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	now = now.UTC()
+
+	if !t.dateStart.IsZero() && now.Before(t.dateStart) {
+		return false
+	}
+
+	if !t.dateStop.IsZero() && now.After(t.dateStop) {
+		return false
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	clock := now.Sub(midnight)
+	day := now.Weekday()
+
+	if t.start <= t.stop {
+		if clock < t.start || clock > t.stop {
+			return false
+		}
+	} else {
+		if clock > t.stop && clock < t.start {
+			return false
+		}
+		// The windows that span midnight belong to the day they start on
+		if t.contiguous && clock <= t.stop {
+			day = (day + 6) % 7
+		}
+	}
+
+	return t.weekdays == nil || t.weekdays[day]
+}
@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// NftablesProvider is an abstraction of all the methods an implementation of userspace
+// nftables need to provide.
+type NftablesProvider interface {
+	// Apply applies a script of nft commands as a single atomic transaction
+	Apply(script string) error
+	// ListTables lists the tables of a family
+	ListTables(family string) ([]string, error)
+}
+
+// nftProvider is an NftablesProvider based on the nft command
+type nftProvider struct {
+	path string
+}
+
+// NewNftProvider returns an NftablesProvider interface based on the nft command.
+func NewNftProvider() (NftablesProvider, error) {
+
+	path, err := exec.LookPath("nft")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot find the nft command: %s", err)
+	}
+
+	return &nftProvider{path: path}, nil
+}
+
+// Apply runs nft with the script on its standard input. nft loads the whole
+// script in one transaction.
+func (n *nftProvider) Apply(script string) error {
+
+	cmd := exec.Command(n.path, "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("nft failed: %s: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// ListTables returns the names of the tables of a family
+func (n *nftProvider) ListTables(family string) ([]string, error) {
+
+	output, err := exec.Command(n.path, "list", "tables", family).Output()
+	if err != nil {
+		return nil, fmt.Errorf("nft failed to list the tables: %s", err)
+	}
+
+	tables := []string{}
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		fields := strings.Fields(string(line))
+		if len(fields) == 3 && fields[0] == "table" && fields[1] == family {
+			tables = append(tables, fields[2])
+		}
+	}
+
+	return tables, nil
+}
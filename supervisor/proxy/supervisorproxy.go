@@ -5,15 +5,20 @@ package supervisorproxy
 import (
 	"fmt"
 	"sync"
+	"time"
+
+	"go.uber.org/zap"
 
 	"github.com/aporeto-inc/trireme/cache"
 	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
 	"github.com/aporeto-inc/trireme/enforcer"
 	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme/enforcer/utils/rpcwrapper"
 
 	"github.com/aporeto-inc/trireme/policy"
 	"github.com/aporeto-inc/trireme/processmon"
+	"github.com/aporeto-inc/trireme/supervisor"
 )
 
 //ProxyInfo is a struct used to store state for the remote launcher.
@@ -28,6 +33,13 @@ type ProxyInfo struct {
 	rpchdl         rpcwrapper.RPCClient
 	initDone       map[string]bool
 
+	// captureMethod is the implementation of the remote supervisors
+	captureMethod rpcwrapper.CaptureType
+
+	// reconcileInterval is the interval of the reconciliations of the remote
+	// supervisors
+	reconcileInterval time.Duration
+
 	sync.Mutex
 }
 
@@ -58,6 +70,7 @@ func (s *ProxyInfo) Supervise(contextID string, puInfo *policy.PUInfo) error {
 			TransmitterRules: puInfo.Policy.TransmitterRules(),
 			ExcludedNetworks: puInfo.Policy.ExcludedNetworks(),
 			TriremeNetworks:  puInfo.Policy.TriremeNetworks(),
+			Generation:       puInfo.Policy.Generation(),
 		},
 	}
 
@@ -92,7 +105,7 @@ func (s *ProxyInfo) SetTargetNetworks(networks []string) error {
 			request := &rpcwrapper.Request{
 				Payload: &rpcwrapper.InitSupervisorPayload{
 					TriremeNetworks: networks,
-					CaptureMethod:   rpcwrapper.IPTables,
+					CaptureMethod:   s.captureMethod,
 				},
 			}
 
@@ -105,6 +118,60 @@ func (s *ProxyInfo) SetTargetNetworks(networks []string) error {
 	return nil
 }
 
+// SetReconcileInterval sets the interval between two reconciliations of the
+// rules of the remote supervisors. It only applies to the remote supervisors
+// that are initialized after it is set.
+func (s *ProxyInfo) SetReconcileInterval(interval time.Duration) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.reconcileInterval = interval
+}
+
+// DriftStats returns the sum of the counts of the drifts of the rules that were
+// repaired by the remote supervisors. The remote supervisors that fail to
+// answer are left out.
+func (s *ProxyInfo) DriftStats() *collector.DriftStats {
+
+	s.Lock()
+	defer s.Unlock()
+
+	stats := &collector.DriftStats{UnitDrifts: map[string]int{}}
+
+	for contextID, done := range s.initDone {
+		if !done {
+			continue
+		}
+
+		request := &rpcwrapper.Request{
+			Payload: &rpcwrapper.DriftStatsPayload{
+				ContextID: contextID,
+			},
+		}
+
+		resp := &rpcwrapper.Response{}
+		if err := s.rpchdl.RemoteCall(contextID, "Server.DriftStats", request, resp); err != nil {
+			zap.L().Warn("Failed to get the drift stats of the remote supervisor", zap.String("contextID", contextID), zap.Error(err))
+			continue
+		}
+
+		payload, ok := resp.Payload.(rpcwrapper.DriftStatsResponsePayload)
+		if !ok || payload.Stats == nil {
+			zap.L().Warn("Invalid drift stats response from the remote supervisor", zap.String("contextID", contextID))
+			continue
+		}
+
+		stats.Reconciliations += payload.Stats.Reconciliations
+		stats.GlobalDrifts += payload.Stats.GlobalDrifts
+		for unit, drifts := range payload.Stats.UnitDrifts {
+			stats.UnitDrifts[unit] += drifts
+		}
+	}
+
+	return stats
+}
+
 // Start This method does nothing and is implemented for completeness
 // THe work done is done in the InitRemoteSupervisor method in the remote enforcer
 func (s *ProxyInfo) Start() error {
@@ -120,8 +187,9 @@ func (s *ProxyInfo) Stop() error {
 	return nil
 }
 
-// NewProxySupervisor creates a new IptablesSupervisor launcher
-func NewProxySupervisor(collector collector.EventCollector, enforcer enforcer.PolicyEnforcer, rpchdl rpcwrapper.RPCClient) (*ProxyInfo, error) {
+// NewProxySupervisor creates a new IptablesSupervisor launcher. The remote
+// supervisors use the given implementation.
+func NewProxySupervisor(collector collector.EventCollector, enforcer enforcer.PolicyEnforcer, rpchdl rpcwrapper.RPCClient, implementation constants.ImplementationType) (*ProxyInfo, error) {
 
 	if collector == nil {
 		return nil, fmt.Errorf("Collector cannot be nil")
@@ -138,6 +206,9 @@ func NewProxySupervisor(collector collector.EventCollector, enforcer enforcer.Po
 		rpchdl:         rpchdl,
 		initDone:       make(map[string]bool),
 		ExcludedIPs:    []string{},
+		captureMethod:  captureMethod(implementation),
+
+		reconcileInterval: supervisor.DefaultReconcileInterval,
 	}
 
 	return s, nil
@@ -147,10 +218,15 @@ func NewProxySupervisor(collector collector.EventCollector, enforcer enforcer.Po
 //InitRemoteSupervisor calls initsupervisor method on the remote
 func (s *ProxyInfo) InitRemoteSupervisor(contextID string, puInfo *policy.PUInfo) error {
 
+	s.Lock()
+	interval := s.reconcileInterval
+	s.Unlock()
+
 	request := &rpcwrapper.Request{
 		Payload: &rpcwrapper.InitSupervisorPayload{
-			TriremeNetworks: puInfo.Policy.TriremeNetworks(),
-			CaptureMethod:   rpcwrapper.IPTables,
+			TriremeNetworks:   puInfo.Policy.TriremeNetworks(),
+			CaptureMethod:     s.captureMethod,
+			ReconcileInterval: interval,
 		},
 	}
 
@@ -182,3 +258,17 @@ func (s *ProxyInfo) AddExcludedIPs(ips []string) error {
 	}
 	return nil
 }
+
+// captureMethod returns the capture method of the remote supervisors of an
+// implementation
+func captureMethod(implementation constants.ImplementationType) rpcwrapper.CaptureType {
+
+	switch implementation {
+	case constants.IPSets:
+		return rpcwrapper.IPSets
+	case constants.NFTables:
+		return rpcwrapper.NFTables
+	default:
+		return rpcwrapper.IPTables
+	}
+}
@@ -3,7 +3,9 @@ package supervisorproxy
 import (
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/policy"
 	"github.com/aporeto-inc/trireme/supervisor"
 )
@@ -14,6 +16,7 @@ type mockedMethods struct {
 	StartMock             func() error
 	StopMock              func() error
 	SetTargetNetworksMock func([]string) error
+	DriftStatsMock        func() *collector.DriftStats
 }
 
 // TestSupervisorLauncher is a mock
@@ -66,6 +69,10 @@ func (m *testSupervisorLauncher) MockSetTargetNetworks(t *testing.T, impl func([
 	m.currentMocks(t).SetTargetNetworksMock = impl
 }
 
+func (m *testSupervisorLauncher) MockDriftStats(t *testing.T, impl func() *collector.DriftStats) {
+	m.currentMocks(t).DriftStatsMock = impl
+}
+
 func (m *testSupervisorLauncher) MockStop(t *testing.T, impl func() error) {
 	m.currentMocks(t).StopMock = impl
 }
@@ -109,3 +116,14 @@ func (m *testSupervisorLauncher) Stop() error {
 	}
 	return nil
 }
+
+func (m *testSupervisorLauncher) SetReconcileInterval(interval time.Duration) {
+}
+
+func (m *testSupervisorLauncher) DriftStats() *collector.DriftStats {
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.DriftStatsMock != nil {
+		return mock.DriftStatsMock()
+
+	}
+	return &collector.DriftStats{UnitDrifts: map[string]int{}}
+}
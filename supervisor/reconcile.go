@@ -0,0 +1,156 @@
+package supervisor
+
+import (
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// DefaultReconcileInterval is the default interval between two reconciliations
+// of the rules
+const DefaultReconcileInterval = 60 * time.Second
+
+// newDriftStats returns the counts of the drifts of a new supervisor
+func newDriftStats() *collector.DriftStats {
+
+	return &collector.DriftStats{UnitDrifts: map[string]int{}}
+}
+
+// SetReconcileInterval sets the interval between two reconciliations of the
+// rules. The rules are not reconciled periodically if the interval is 0. The
+// interval must be set before the supervisor is started.
+func (s *Config) SetReconcileInterval(interval time.Duration) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.reconcileInterval = interval
+}
+
+// DriftStats returns the counts of the drifts of the rules that were repaired
+// since the supervisor was created
+func (s *Config) DriftStats() *collector.DriftStats {
+
+	s.Lock()
+	defer s.Unlock()
+
+	stats := &collector.DriftStats{
+		Reconciliations: s.drifts.Reconciliations,
+		GlobalDrifts:    s.drifts.GlobalDrifts,
+		UnitDrifts:      map[string]int{},
+	}
+
+	for contextID, drifts := range s.drifts.UnitDrifts {
+		stats.UnitDrifts[contextID] = drifts
+	}
+
+	return stats
+}
+
+// Reconcile compares the rules of the supervised processing units with the
+// rules that are programmed and repairs the drifts. A container event is
+// collected for each processing unit whose rules drifted. The supervisor is
+// only locked while the rules of one processing unit are reconciled, and the
+// events are collected once it is unlocked.
+func (s *Config) Reconcile() error {
+
+	reconciler, ok := s.impl.(Reconciler)
+	if !ok {
+		return fmt.Errorf("The implementation cannot reconcile the rules")
+	}
+
+	failed := 0
+
+	s.Lock()
+	s.drifts.Reconciliations++
+	drifts, err := reconciler.ReconcileGlobalRules()
+	s.drifts.GlobalDrifts += drifts
+	keys := s.versionTracker.KeyList()
+	s.Unlock()
+
+	if err != nil {
+		zap.L().Warn("Failed to reconcile the global rules", zap.Error(err))
+		failed++
+	}
+
+	records := []*collector.ContainerRecord{}
+	for _, key := range keys {
+		contextID := key.(string)
+
+		record, err := s.reconcileRules(reconciler, contextID)
+		if record != nil {
+			records = append(records, record)
+		}
+
+		if err != nil {
+			zap.L().Warn("Failed to reconcile the rules of the processing unit",
+				zap.String("contextID", contextID),
+				zap.Error(err),
+			)
+			failed++
+		}
+	}
+
+	for _, record := range records {
+		s.collector.CollectContainerEvent(record)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Failed to reconcile %d sets of rules", failed)
+	}
+
+	return nil
+}
+
+// reconcileRules reconciles the rules of a processing unit that is still
+// supervised. It returns the drift event of the processing unit if its rules
+// drifted.
+func (s *Config) reconcileRules(reconciler Reconciler, contextID string) (*collector.ContainerRecord, error) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	entry, err := s.versionTracker.Get(contextID)
+	if err != nil {
+		return nil, nil
+	}
+
+	drifts, err := reconciler.ReconcileRules(contextID)
+	if drifts == 0 {
+		return nil, err
+	}
+
+	s.drifts.UnitDrifts[contextID] += drifts
+
+	cacheEntry := entry.(*cacheData)
+	record := &collector.ContainerRecord{
+		ContextID:        contextID,
+		Event:            collector.ContainerDrift,
+		PolicyGeneration: cacheEntry.generation,
+	}
+	record.IPAddress, _ = cacheEntry.ips.Get(policy.DefaultNamespace)
+
+	return record, err
+}
+
+// reconcile reconciles the rules periodically until the supervisor stops
+func (s *Config) reconcile(interval time.Duration, stop chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Reconcile(); err != nil {
+				zap.L().Warn("Failed to reconcile the rules", zap.Error(err))
+			}
+		case <-stop:
+			return
+		}
+	}
+}
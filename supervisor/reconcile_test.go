@@ -0,0 +1,198 @@
+package supervisor
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/mock/gomock"
+	"github.com/aporeto-inc/trireme/cache"
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/fqconfig"
+	mock_supervisor "github.com/aporeto-inc/trireme/supervisor/mock"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testReconciler is an implementor that reports the given drifts
+type testReconciler struct {
+	*mock_supervisor.MockImplementor
+
+	globalDrifts int
+	unitDrifts   map[string]int
+	err          error
+
+	reconciliations int
+	sync.Mutex
+}
+
+func (r *testReconciler) ReconcileRules(contextID string) (int, error) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	return r.unitDrifts[contextID], r.err
+}
+
+func (r *testReconciler) ReconcileGlobalRules() (int, error) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.reconciliations++
+
+	return r.globalDrifts, nil
+}
+
+func (r *testReconciler) count() int {
+
+	r.Lock()
+	defer r.Unlock()
+
+	return r.reconciliations
+}
+
+// testCollector keeps the container events, and calls onEvent for each of them
+type testCollector struct {
+	collector.DefaultCollector
+	events  []*collector.ContainerRecord
+	onEvent func()
+}
+
+func (c *testCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+
+	c.events = append(c.events, record)
+
+	if c.onEvent != nil {
+		c.onEvent()
+	}
+}
+
+// newTestSupervisor returns a supervisor of local containers with the given
+// implementation, that doesn't need the privileges of the real implementations
+func newTestSupervisor(c collector.EventCollector, impl Implementor) *Config {
+
+	return &Config{
+		mode:              constants.LocalContainer,
+		impl:              impl,
+		versionTracker:    cache.NewCache("SupVersionTracker"),
+		collector:         c,
+		filterQueue:       fqconfig.NewFilterQueueWithDefaults(),
+		excludedIPs:       []string{},
+		triremeNetworks:   []string{"172.17.0.0/16"},
+		reconcileInterval: DefaultReconcileInterval,
+		drifts:            newDriftStats(),
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a supervisor", t, func() {
+		c := &testCollector{}
+		s := newTestSupervisor(c, mock_supervisor.NewMockImplementor(ctrl))
+
+		Convey("When the implementation cannot reconcile the rules, I should get an error", func() {
+			s.impl = mock_supervisor.NewMockImplementor(ctrl)
+
+			So(s.Reconcile(), ShouldNotBeNil)
+		})
+
+		Convey("When the rules of a supervised PU drifted", func() {
+			impl := &testReconciler{
+				MockImplementor: mock_supervisor.NewMockImplementor(ctrl),
+				globalDrifts:    1,
+				unitDrifts:      map[string]int{"contextID": 2},
+			}
+			s.impl = impl
+
+			puInfo := createPUInfo()
+			puInfo.Policy.SetGeneration(3)
+			impl.MockImplementor.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+			So(s.Supervise("contextID", puInfo), ShouldBeNil)
+
+			So(s.Reconcile(), ShouldBeNil)
+
+			Convey("The drift event should be collected once the supervisor is unlocked", func() {
+				unlocked := false
+				c.onEvent = func() {
+					done := make(chan struct{})
+					go func() {
+						s.DriftStats()
+						close(done)
+					}()
+					select {
+					case <-done:
+						unlocked = true
+					case <-time.After(time.Second):
+					}
+				}
+
+				So(s.Reconcile(), ShouldBeNil)
+				So(unlocked, ShouldBeTrue)
+			})
+
+			Convey("A drift event should be collected for the PU", func() {
+				So(c.events, ShouldHaveLength, 1)
+				So(c.events[0].ContextID, ShouldEqual, "contextID")
+				So(c.events[0].IPAddress, ShouldEqual, "172.17.0.1")
+				So(c.events[0].Event, ShouldEqual, collector.ContainerDrift)
+				So(c.events[0].PolicyGeneration, ShouldEqual, 3)
+			})
+
+			Convey("The drifts should be counted", func() {
+				So(s.Reconcile(), ShouldBeNil)
+
+				stats := s.DriftStats()
+				So(stats.Reconciliations, ShouldEqual, 2)
+				So(stats.GlobalDrifts, ShouldEqual, 2)
+				So(stats.UnitDrifts, ShouldResemble, map[string]int{"contextID": 4})
+			})
+
+			Convey("The drifts of the PU should be forgotten when it is unsupervised", func() {
+				impl.MockImplementor.EXPECT().DeleteRules(0, "contextID", gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				So(s.Unsupervise("contextID"), ShouldBeNil)
+
+				So(s.DriftStats().UnitDrifts, ShouldBeEmpty)
+			})
+
+			Convey("The failures should be reported", func() {
+				impl.err = fmt.Errorf("error")
+
+				So(s.Reconcile(), ShouldNotBeNil)
+				So(s.DriftStats().UnitDrifts, ShouldResemble, map[string]int{"contextID": 4})
+			})
+		})
+
+		Convey("When I start the supervisor with a reconcile interval", func() {
+			impl := &testReconciler{
+				MockImplementor: mock_supervisor.NewMockImplementor(ctrl),
+			}
+			s.impl = impl
+
+			impl.MockImplementor.EXPECT().Start().Return(nil)
+			impl.MockImplementor.EXPECT().SetTargetNetworks([]string{}, []string{"172.17.0.0/16"}).Return(nil)
+			impl.MockImplementor.EXPECT().Stop().Return(nil)
+
+			s.SetReconcileInterval(time.Millisecond)
+			So(s.Start(), ShouldBeNil)
+
+			Convey("The rules should be reconciled until it stops", func() {
+				deadline := time.Now().Add(5 * time.Second)
+				for impl.count() < 2 && time.Now().Before(deadline) {
+					time.Sleep(time.Millisecond)
+				}
+
+				So(s.Stop(), ShouldBeNil)
+				So(impl.count(), ShouldBeGreaterThanOrEqualTo, 2)
+
+				count := impl.count()
+				time.Sleep(10 * time.Millisecond)
+				So(impl.count(), ShouldBeLessThanOrEqualTo, count+1)
+			})
+		})
+	})
+}
@@ -3,6 +3,7 @@ package supervisor
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -14,14 +15,16 @@ import (
 	"github.com/aporeto-inc/trireme/policy"
 	"github.com/aporeto-inc/trireme/supervisor/ipsetctrl"
 	"github.com/aporeto-inc/trireme/supervisor/iptablesctrl"
+	"github.com/aporeto-inc/trireme/supervisor/nftablesctrl"
 )
 
 type cacheData struct {
-	version int
-	ips     policy.ExtendedMap
-	mark    string
-	port    string
-	uid     string
+	version    int
+	ips        policy.ExtendedMap
+	mark       string
+	port       string
+	uid        string
+	generation uint64
 }
 
 // Config is the structure holding all information about the supervisor
@@ -37,6 +40,11 @@ type Config struct {
 
 	triremeNetworks []string
 
+	// The periodic reconciliation of the rules
+	reconcileInterval time.Duration
+	stopReconcile     chan struct{}
+	drifts            *collector.DriftStats
+
 	sync.Mutex
 }
 
@@ -68,14 +76,19 @@ func NewSupervisor(collector collector.EventCollector, enforcerInstance enforcer
 		filterQueue:     filterQueue,
 		excludedIPs:     []string{},
 		triremeNetworks: networks,
+
+		reconcileInterval: DefaultReconcileInterval,
+		drifts:            newDriftStats(),
 	}
 
 	var err error
 	switch implementation {
 	case constants.IPSets:
 		s.impl, err = ipsetctrl.NewInstance(s.filterQueue, false, mode)
+	case constants.NFTables:
+		s.impl, err = nftablesctrl.NewInstance(s.filterQueue, mode)
 	default:
-		s.impl, err = iptablesctrl.NewInstance(s.filterQueue, mode)
+		s.impl, err = iptablesctrl.NewDualStackInstance(s.filterQueue, mode)
 	}
 
 	if err != nil {
@@ -93,6 +106,9 @@ func (s *Config) Supervise(contextID string, containerInfo *policy.PUInfo) error
 		return fmt.Errorf("Runtime, Policy and ContainerInfo should not be nil")
 	}
 
+	s.Lock()
+	defer s.Unlock()
+
 	_, err := s.versionTracker.Get(contextID)
 
 	if err != nil {
@@ -109,6 +125,15 @@ func (s *Config) Supervise(contextID string, containerInfo *policy.PUInfo) error
 // as much cleanup as possible to avoid stale state
 func (s *Config) Unsupervise(contextID string) error {
 
+	s.Lock()
+	defer s.Unlock()
+
+	return s.unsupervise(contextID)
+}
+
+// unsupervise cleans up the rules of a processing unit. The supervisor must be locked.
+func (s *Config) unsupervise(contextID string) error {
+
 	version, err := s.versionTracker.Get(contextID)
 
 	if err != nil {
@@ -125,6 +150,8 @@ func (s *Config) Unsupervise(contextID string) error {
 		zap.L().Warn("Failed to clean the rule version cache", zap.Error(err))
 	}
 
+	delete(s.drifts.UnitDrifts, contextID)
+
 	return nil
 }
 
@@ -136,10 +163,17 @@ func (s *Config) Start() error {
 	}
 
 	s.Lock()
+	defer s.Unlock()
+
 	if err := s.impl.SetTargetNetworks([]string{}, s.triremeNetworks); err != nil {
 		return err
 	}
-	s.Unlock()
+
+	// Reconcile the rules periodically if the implementation can repair them
+	if _, ok := s.impl.(Reconciler); ok && s.reconcileInterval > 0 && s.stopReconcile == nil {
+		s.stopReconcile = make(chan struct{})
+		go s.reconcile(s.reconcileInterval, s.stopReconcile)
+	}
 
 	zap.L().Debug("Started the supervisor")
 
@@ -149,6 +183,13 @@ func (s *Config) Start() error {
 // Stop stops the supervisor
 func (s *Config) Stop() error {
 
+	s.Lock()
+	if s.stopReconcile != nil {
+		close(s.stopReconcile)
+		s.stopReconcile = nil
+	}
+	s.Unlock()
+
 	if err := s.impl.Stop(); err != nil {
 		return fmt.Errorf("Failed to stop the implementer: %s", err)
 	}
@@ -164,7 +205,7 @@ func (s *Config) SetTargetNetworks(networks []string) error {
 
 	// If there are no target networks, capture all traffic
 	if len(networks) == 0 {
-		networks = []string{"0.0.0.0/1", "128.0.0.0/1"}
+		networks = []string{"0.0.0.0/1", "128.0.0.0/1", "::/1", "8000::/1"}
 	}
 
 	if err := s.impl.SetTargetNetworks(s.triremeNetworks, networks); err != nil {
@@ -186,18 +227,19 @@ func (s *Config) doCreatePU(contextID string, containerInfo *policy.PUInfo) erro
 	uid := containerInfo.Runtime.Options().UserID
 
 	cacheEntry := &cacheData{
-		version: version,
-		ips:     containerInfo.Policy.IPAddresses(),
-		mark:    mark,
-		port:    port,
-		uid:     uid,
+		version:    version,
+		ips:        containerInfo.Policy.IPAddresses(),
+		mark:       mark,
+		port:       port,
+		uid:        uid,
+		generation: containerInfo.Policy.Generation(),
 	}
 
 	// Version the policy so that we can do hitless policy changes
 	s.versionTracker.AddOrUpdate(contextID, cacheEntry)
 
 	if err := s.impl.ConfigureRules(version, contextID, containerInfo); err != nil {
-		if uerr := s.Unsupervise(contextID); uerr != nil {
+		if uerr := s.unsupervise(contextID); uerr != nil {
 			zap.L().Warn("Failed to clean up state while creating the PU",
 				zap.String("contextID", contextID),
 				zap.Error(uerr),
@@ -222,7 +264,7 @@ func (s *Config) doUpdatePU(contextID string, containerInfo *policy.PUInfo) erro
 	cachedEntry := cacheEntry.(*cacheData)
 
 	if err := s.impl.UpdateRules(cachedEntry.version, contextID, containerInfo); err != nil {
-		if uerr := s.Unsupervise(contextID); uerr != nil {
+		if uerr := s.unsupervise(contextID); uerr != nil {
 			zap.L().Warn("Failed to clean up state while updating the PU",
 				zap.String("contextID", contextID),
 				zap.Error(uerr),
@@ -231,6 +273,8 @@ func (s *Config) doUpdatePU(contextID string, containerInfo *policy.PUInfo) erro
 		return err
 	}
 
+	cachedEntry.generation = containerInfo.Policy.Generation()
+
 	return nil
 }
 
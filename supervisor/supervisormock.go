@@ -3,7 +3,9 @@ package supervisor
 import (
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/policy"
 )
 
@@ -26,6 +28,12 @@ type mockedMethods struct {
 
 	// SetTargetNetworksMock  adds the SetTargetNetworks implementation
 	SetTargetNetworksMock func(networks []string) error
+
+	// SetReconcileIntervalMock adds the SetReconcileInterval implementation
+	SetReconcileIntervalMock func(interval time.Duration)
+
+	// DriftStatsMock adds the DriftStats implementation
+	DriftStatsMock func() *collector.DriftStats
 }
 
 // TestSupervisor is a test implementation for IptablesProvider
@@ -37,6 +45,8 @@ type TestSupervisor interface {
 	MockStop(t *testing.T, impl func() error)
 	MockAddExcludedIPs(t *testing.T, impl func(ips []string) error)
 	MockSetTargetNetworks(t *testing.T, impl func(networks []string) error)
+	MockSetReconcileInterval(t *testing.T, impl func(interval time.Duration))
+	MockDriftStats(t *testing.T, impl func() *collector.DriftStats)
 }
 
 // A TestSupervisorInst is an empty TransactionalManipulator that can be easily mocked.
@@ -89,6 +99,18 @@ func (m *TestSupervisorInst) MockSetTargetNetworks(t *testing.T, impl func(netwo
 	m.currentMocks(t).SetTargetNetworksMock = impl
 }
 
+// MockSetReconcileInterval mocks the SetReconcileInterval method
+func (m *TestSupervisorInst) MockSetReconcileInterval(t *testing.T, impl func(interval time.Duration)) {
+
+	m.currentMocks(t).SetReconcileIntervalMock = impl
+}
+
+// MockDriftStats mocks the DriftStats method
+func (m *TestSupervisorInst) MockDriftStats(t *testing.T, impl func() *collector.DriftStats) {
+
+	m.currentMocks(t).DriftStatsMock = impl
+}
+
 // Supervise is a test implementation of the Supervise interface
 func (m *TestSupervisorInst) Supervise(contextID string, puInfo *policy.PUInfo) error {
 
@@ -147,6 +169,24 @@ func (m *TestSupervisorInst) SetTargetNetworks(networks []string) error {
 	return nil
 }
 
+// SetReconcileInterval is a test implementation of the SetReconcileInterval interface method
+func (m *TestSupervisorInst) SetReconcileInterval(interval time.Duration) {
+
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.SetReconcileIntervalMock != nil {
+		mock.SetReconcileIntervalMock(interval)
+	}
+}
+
+// DriftStats is a test implementation of the DriftStats interface method
+func (m *TestSupervisorInst) DriftStats() *collector.DriftStats {
+
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.DriftStatsMock != nil {
+		return mock.DriftStatsMock()
+	}
+
+	return &collector.DriftStats{UnitDrifts: map[string]int{}}
+}
+
 func (m *TestSupervisorInst) currentMocks(t *testing.T) *mockedMethods {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -2,6 +2,7 @@ package trireme
 
 import (
 	"fmt"
+	"sync"
 
 	"go.uber.org/zap"
 
@@ -19,10 +20,17 @@ import (
 type trireme struct {
 	serverID    string
 	cache       cache.DataStore
+	generations cache.DataStore
 	supervisors map[constants.PUType]supervisor.Supervisor
 	enforcers   map[constants.PUType]enforcer.PolicyEnforcer
 	resolver    PolicyResolver
 	collector   collector.EventCollector
+
+	// lastGeneration is the last generation given to a policy of any PU. The
+	// generations of a new PU start after it, so that a PU that is deleted and
+	// created again never reuses the generations of its previous policies.
+	lastGeneration uint64
+	generationLock sync.Mutex
 }
 
 // NewTrireme returns a reference to the trireme object based on the parameter subelements.
@@ -31,6 +39,7 @@ func NewTrireme(serverID string, resolver PolicyResolver, supervisors map[consta
 	t := &trireme{
 		serverID:    serverID,
 		cache:       cache.NewCache("TriremeCache"),
+		generations: cache.NewCache("TriremeGenerations"),
 		supervisors: supervisors,
 		enforcers:   enforcers,
 		resolver:    resolver,
@@ -115,6 +124,17 @@ func (t *trireme) PURuntime(contextID string) (policy.RuntimeReader, error) {
 	return container.(*policy.PURuntime), nil
 }
 
+// PolicyGeneration returns the generation of the active policy of the PU based on the contextID.
+func (t *trireme) PolicyGeneration(contextID string) (uint64, error) {
+
+	g := t.generation(contextID)
+	if g.active == 0 {
+		return 0, fmt.Errorf("No active policy for contextID %s", contextID)
+	}
+
+	return g.active, nil
+}
+
 // SetPURuntime returns the RuntimeInfo based on the contextID.
 func (t *trireme) SetPURuntime(contextID string, runtimeInfo *policy.PURuntime) error {
 
@@ -128,6 +148,64 @@ func (t *trireme) SetPURuntime(contextID string, runtimeInfo *policy.PURuntime)
 
 }
 
+// puGeneration holds the generation of the active policy of a PU and the last
+// generation given to a policy of the PU. The entries of the cache are
+// replaced and never modified.
+type puGeneration struct {
+	active uint64
+	last   uint64
+}
+
+// generation returns the generations of the policies of a PU
+func (t *trireme) generation(contextID string) puGeneration {
+
+	if item, err := t.generations.Get(contextID); err == nil {
+		return *item.(*puGeneration)
+	}
+
+	return puGeneration{}
+}
+
+// nextGeneration sets the generation of a new policy of a PU. The policy keeps
+// the generation given by the resolver if it is greater than the generations
+// of the previous policies of the PU. Otherwise it gets the next one. The first
+// policy of a PU is compared to the last generation given to any PU.
+func (t *trireme) nextGeneration(contextID string, newPolicy *policy.PUPolicy) uint64 {
+
+	t.generationLock.Lock()
+	defer t.generationLock.Unlock()
+
+	g := t.generation(contextID)
+	if _, err := t.generations.Get(contextID); err != nil {
+		g.last = t.lastGeneration
+	}
+
+	generation := newPolicy.Generation()
+	if generation <= g.last {
+		generation = g.last + 1
+		newPolicy.SetGeneration(generation)
+	}
+
+	t.generations.AddOrUpdate(contextID, &puGeneration{active: g.active, last: generation})
+
+	if generation > t.lastGeneration {
+		t.lastGeneration = generation
+	}
+
+	return generation
+}
+
+// activateGeneration records the generation of the policy of a PU once it is applied
+func (t *trireme) activateGeneration(contextID string, generation uint64) {
+
+	t.generationLock.Lock()
+	defer t.generationLock.Unlock()
+
+	g := t.generation(contextID)
+
+	t.generations.AddOrUpdate(contextID, &puGeneration{active: generation, last: g.last})
+}
+
 // addTransmitterLabel adds the TransmitterLabel as a fixed label in the policy.
 // The ManagementID part of the policy is used as the TransmitterLabel.
 // If the Policy didn't set the ManagementID, we use the Local contextID as the
@@ -189,26 +267,32 @@ func (t *trireme) doHandleCreate(contextID string) error {
 
 	ip, _ := policyInfo.DefaultIPAddress()
 
+	generation := t.nextGeneration(contextID, policyInfo)
+
 	containerInfo := policy.PUInfoFromPolicyAndRuntime(contextID, policyInfo, runtimeInfo)
 
 	addTransmitterLabel(contextID, containerInfo)
 
 	if !mustEnforce(contextID, containerInfo) {
+		t.activateGeneration(contextID, generation)
+
 		t.collector.CollectContainerEvent(&collector.ContainerRecord{
-			ContextID: contextID,
-			IPAddress: ip,
-			Tags:      policyInfo.Annotations(),
-			Event:     collector.ContainerIgnored,
+			ContextID:        contextID,
+			IPAddress:        ip,
+			Tags:             policyInfo.Annotations(),
+			Event:            collector.ContainerIgnored,
+			PolicyGeneration: generation,
 		})
 		return nil
 	}
 
 	if err := t.enforcers[containerInfo.Runtime.PUType()].Enforce(contextID, containerInfo); err != nil {
 		t.collector.CollectContainerEvent(&collector.ContainerRecord{
-			ContextID: contextID,
-			IPAddress: ip,
-			Tags:      policyInfo.Annotations(),
-			Event:     collector.ContainerFailed,
+			ContextID:        contextID,
+			IPAddress:        ip,
+			Tags:             policyInfo.Annotations(),
+			Event:            collector.ContainerFailed,
+			PolicyGeneration: generation,
 		})
 		return fmt.Errorf("Not able to setup enforcer: %s", err)
 	}
@@ -222,20 +306,24 @@ func (t *trireme) doHandleCreate(contextID string) error {
 		}
 
 		t.collector.CollectContainerEvent(&collector.ContainerRecord{
-			ContextID: contextID,
-			IPAddress: ip,
-			Tags:      policyInfo.Annotations(),
-			Event:     collector.ContainerFailed,
+			ContextID:        contextID,
+			IPAddress:        ip,
+			Tags:             policyInfo.Annotations(),
+			Event:            collector.ContainerFailed,
+			PolicyGeneration: generation,
 		})
 
 		return fmt.Errorf("Not able to setup supervisor: %s", err)
 	}
 
+	t.activateGeneration(contextID, generation)
+
 	t.collector.CollectContainerEvent(&collector.ContainerRecord{
-		ContextID: contextID,
-		IPAddress: ip,
-		Tags:      containerInfo.Policy.Annotations(),
-		Event:     collector.ContainerStart,
+		ContextID:        contextID,
+		IPAddress:        ip,
+		Tags:             containerInfo.Policy.Annotations(),
+		Event:            collector.ContainerStart,
+		PolicyGeneration: generation,
 	})
 
 	return nil
@@ -262,6 +350,8 @@ func (t *trireme) doHandleDelete(contextID string) error {
 
 	ip, _ := runtime.DefaultIPAddress()
 
+	generation := t.generation(contextID).active
+
 	errS := t.supervisors[runtime.PUType()].Unsupervise(contextID)
 	errE := t.enforcers[runtime.PUType()].Unenforce(contextID)
 
@@ -272,22 +362,28 @@ func (t *trireme) doHandleDelete(contextID string) error {
 		)
 	}
 
+	t.generationLock.Lock()
+	t.generations.Remove(contextID) // nolint
+	t.generationLock.Unlock()
+
 	if errS != nil || errE != nil {
 		t.collector.CollectContainerEvent(&collector.ContainerRecord{
-			ContextID: contextID,
-			IPAddress: ip,
-			Tags:      nil,
-			Event:     collector.ContainerDelete,
+			ContextID:        contextID,
+			IPAddress:        ip,
+			Tags:             nil,
+			Event:            collector.ContainerDelete,
+			PolicyGeneration: generation,
 		})
 
 		return fmt.Errorf("Delete Error for contextID %s. supervisor %s, enforcer %s", contextID, errS, errE)
 	}
 
 	t.collector.CollectContainerEvent(&collector.ContainerRecord{
-		ContextID: contextID,
-		IPAddress: ip,
-		Tags:      nil,
-		Event:     collector.ContainerDelete,
+		ContextID:        contextID,
+		IPAddress:        ip,
+		Tags:             nil,
+		Event:            collector.ContainerDelete,
+		PolicyGeneration: generation,
 	})
 
 	return nil
@@ -309,6 +405,8 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy) e
 		zap.L().Error("PU Already Deleted do nothing", zap.String("contextID", contextID))
 		return err
 	}
+	generation := t.nextGeneration(contextID, newPolicy)
+
 	containerInfo := policy.PUInfoFromPolicyAndRuntime(contextID, newPolicy, runtime)
 
 	addTransmitterLabel(contextID, containerInfo)
@@ -355,12 +453,15 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy) e
 		return fmt.Errorf("Supervisor failed to update PU policy: context=%s error=%s", contextID, err)
 	}
 
+	t.activateGeneration(contextID, generation)
+
 	ip, _ := newPolicy.DefaultIPAddress()
 	t.collector.CollectContainerEvent(&collector.ContainerRecord{
-		ContextID: contextID,
-		IPAddress: ip,
-		Tags:      containerInfo.Runtime.Tags(),
-		Event:     collector.ContainerUpdate,
+		ContextID:        contextID,
+		IPAddress:        ip,
+		Tags:             containerInfo.Runtime.Tags(),
+		Event:            collector.ContainerUpdate,
+		PolicyGeneration: generation,
 	})
 
 	return nil
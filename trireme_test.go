@@ -3,6 +3,7 @@ package trireme
 import (
 	"reflect"
 	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/aporeto-inc/trireme/collector"
@@ -278,6 +279,105 @@ func TestSimpleUpdate(t *testing.T) {
 	doTestUpdate(t, trireme, tresolver, tsupervisor[constants.ContainerPU].(supervisor.TestSupervisor), tenforcer[constants.ContainerPU].(enforcer.TestPolicyEnforcer), tmonitor, contextID, runtime, newPolicy)
 }
 
+func TestPolicyGeneration(t *testing.T) {
+	tresolver, tsupervisor, tenforcer, tmonitor, tcollector := createMocks()
+	trireme := NewTrireme("serverID", tresolver, tsupervisor, tenforcer, tcollector)
+	if err := trireme.Start(); err != nil {
+		t.Errorf("Failed to start trireme")
+	}
+	contextID := "123123"
+	runtime := policy.NewPURuntimeWithDefaults()
+
+	if _, err := trireme.PolicyGeneration(contextID); err == nil {
+		t.Errorf("Expecting an error for a PU without policy, but no error returned")
+	}
+
+	doTestCreate(t, trireme, tresolver, tsupervisor[constants.ContainerPU].(supervisor.TestSupervisor), tenforcer[constants.ContainerPU].(enforcer.TestPolicyEnforcer), tmonitor, contextID, runtime)
+
+	if generation, err := trireme.PolicyGeneration(contextID); err != nil || generation != 1 {
+		t.Errorf("Expecting generation 1 after create, got %d %v", generation, err)
+	}
+
+	// The generations of the policies always increase
+	ipl := policy.ExtendedMap{policy.DefaultNamespace: "127.0.0.1"}
+	newPolicy := policy.NewPUPolicy("", policy.Police, nil, nil, nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{})
+	doTestUpdate(t, trireme, tresolver, tsupervisor[constants.ContainerPU].(supervisor.TestSupervisor), tenforcer[constants.ContainerPU].(enforcer.TestPolicyEnforcer), tmonitor, contextID, runtime, newPolicy)
+
+	if generation, err := trireme.PolicyGeneration(contextID); err != nil || generation != 2 || newPolicy.Generation() != 2 {
+		t.Errorf("Expecting generation 2 after update, got %d %d %v", generation, newPolicy.Generation(), err)
+	}
+
+	// The generation given with the policy is kept if it is greater
+	newPolicy = policy.NewPUPolicy("", policy.Police, nil, nil, nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{})
+	newPolicy.SetGeneration(10)
+	doTestUpdate(t, trireme, tresolver, tsupervisor[constants.ContainerPU].(supervisor.TestSupervisor), tenforcer[constants.ContainerPU].(enforcer.TestPolicyEnforcer), tmonitor, contextID, runtime, newPolicy)
+
+	if generation, err := trireme.PolicyGeneration(contextID); err != nil || generation != 10 {
+		t.Errorf("Expecting generation 10 after update, got %d %v", generation, err)
+	}
+
+	// An older generation is replaced by the next one
+	newPolicy = policy.NewPUPolicy("", policy.Police, nil, nil, nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{})
+	newPolicy.SetGeneration(5)
+	doTestUpdate(t, trireme, tresolver, tsupervisor[constants.ContainerPU].(supervisor.TestSupervisor), tenforcer[constants.ContainerPU].(enforcer.TestPolicyEnforcer), tmonitor, contextID, runtime, newPolicy)
+
+	if generation, err := trireme.PolicyGeneration(contextID); err != nil || generation != 11 {
+		t.Errorf("Expecting generation 11 after update, got %d %v", generation, err)
+	}
+
+	// A PU created again doesn't reuse the generations of its previous policies
+	doTestDelete(t, trireme, tresolver, tsupervisor[constants.ContainerPU].(supervisor.TestSupervisor), tenforcer[constants.ContainerPU].(enforcer.TestPolicyEnforcer), tmonitor, contextID, runtime)
+	doTestCreate(t, trireme, tresolver, tsupervisor[constants.ContainerPU].(supervisor.TestSupervisor), tenforcer[constants.ContainerPU].(enforcer.TestPolicyEnforcer), tmonitor, contextID, runtime)
+
+	if generation, err := trireme.PolicyGeneration(contextID); err != nil || generation != 12 {
+		t.Errorf("Expecting generation 12 after a new create, got %d %v", generation, err)
+	}
+
+	// The first policy of another PU starts after the last generation
+	doTestCreate(t, trireme, tresolver, tsupervisor[constants.ContainerPU].(supervisor.TestSupervisor), tenforcer[constants.ContainerPU].(enforcer.TestPolicyEnforcer), tmonitor, "456456", runtime)
+
+	if generation, err := trireme.PolicyGeneration("456456"); err != nil || generation != 13 {
+		t.Errorf("Expecting generation 13 after the create of another PU, got %d %v", generation, err)
+	}
+}
+
+func TestConcurrentPolicyGenerations(t *testing.T) {
+	tresolver, tsupervisor, tenforcer, _, tcollector := createMocks()
+	tr := NewTrireme("serverID", tresolver, tsupervisor, tenforcer, tcollector).(*trireme)
+
+	contextID := "123123"
+	ipl := policy.ExtendedMap{policy.DefaultNamespace: "127.0.0.1"}
+
+	// The policies applied while others are being resolved never get the same
+	// generation
+	generations := make(chan uint64, 100)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newPolicy := policy.NewPUPolicy("", policy.Police, nil, nil, nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{})
+			generation := tr.nextGeneration(contextID, newPolicy)
+			tr.activateGeneration(contextID, generation)
+			generations <- generation
+		}()
+	}
+	wg.Wait()
+	close(generations)
+
+	seen := map[uint64]bool{}
+	for generation := range generations {
+		if seen[generation] {
+			t.Errorf("Generation %d given to two policies", generation)
+		}
+		seen[generation] = true
+	}
+
+	if g := tr.generation(contextID); g.last != 100 {
+		t.Errorf("Expecting the last generation to be 100, got %d", g.last)
+	}
+}
+
 func TestCache(t *testing.T) {
 	tresolver, tsupervisor, tenforcer, tmonitor, tcollector := createMocks()
 	trireme := NewTrireme("serverID", tresolver, tsupervisor, tenforcer, tcollector)